@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Screen-reader output: results are spoken as sentences, one short line at a time,
+// with no emoji, arrows or column layouts
+
+// Longest line printed in accessible mode
+const accessibleLineWidth = 72
+
+// describeTrains verbalizes a list or search result, e.g.
+// "Result 1 of 3: train G100 from Beijing to Shanghai on June 1, 2025."
+func describeTrains(title string, trains []Train) string {
+	lines := []string{fmt.Sprintf("%s: %s.", title, countNoun(len(trains), "train", "trains"))}
+	for i, train := range trains {
+		lines = append(lines, fmt.Sprintf("Result %d of %d: train %s", i+1, len(trains), describeTrain(train)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// describeTrain is one train as a few short sentences, starting with its ID
+func describeTrain(train Train) string {
+	description := fmt.Sprintf("%s from %s to %s on %s. Departs %s, arrives %s. %d of %d seats available.",
+		train.ID, train.From, train.To, spokenDate(train.Date),
+		spokenTime(train.DepartureTime), spokenTime(train.ArrivalTime),
+		train.Available, train.TotalTickets)
+	if train.Provider != "" {
+		description += fmt.Sprintf(" Sold by %s.", train.Provider)
+	}
+	return description
+}
+
+// describeBookings verbalizes the user's tickets
+func describeBookings(bookings []UserBooking, trains map[string]Train) string {
+	lines := []string{fmt.Sprintf("You have tickets on %s.", countNoun(len(bookings), "train", "trains"))}
+	for i, booking := range bookings {
+		line := fmt.Sprintf("Booking %d of %d: %s on train %s", i+1, len(bookings), countNoun(booking.Count, "ticket", "tickets"), booking.TrainID)
+		if train, ok := trains[booking.TrainID]; ok {
+			line += fmt.Sprintf(" from %s to %s on %s, departing %s",
+				train.From, train.To, spokenDate(train.Date), spokenTime(train.DepartureTime))
+		}
+		lines = append(lines, line+".")
+	}
+	return strings.Join(lines, "\n")
+}
+
+func countNoun(n int, singular, plural string) string {
+	if n == 1 {
+		return "1 " + singular
+	}
+	return fmt.Sprintf("%d %s", n, plural)
+}
+
+var monthNames = []string{"January", "February", "March", "April", "May", "June",
+	"July", "August", "September", "October", "November", "December"}
+
+// spokenDate turns 2025-06-01 into "June 1, 2025"
+func spokenDate(date string) string {
+	parts := strings.Split(date, "-")
+	if len(parts) != 3 {
+		return date
+	}
+	month, err1 := strconv.Atoi(parts[1])
+	day, err2 := strconv.Atoi(parts[2])
+	if err1 != nil || err2 != nil || month < 1 || month > 12 {
+		return date
+	}
+	return fmt.Sprintf("%s %d, %s", monthNames[month-1], day, parts[0])
+}
+
+// spokenTime turns 08:00 into "8 AM" and 13:30 into "1:30 PM"
+func spokenTime(clock string) string {
+	hh, mm, ok := strings.Cut(clock, ":")
+	hour, err1 := strconv.Atoi(hh)
+	minute, err2 := strconv.Atoi(mm)
+	if !ok || err1 != nil || err2 != nil || hour < 0 || hour > 23 {
+		return clock
+	}
+	suffix := "AM"
+	if hour >= 12 {
+		suffix = "PM"
+	}
+	if hour = hour % 12; hour == 0 {
+		hour = 12
+	}
+	if minute == 0 {
+		return fmt.Sprintf("%d %s", hour, suffix)
+	}
+	return fmt.Sprintf("%d:%02d %s", hour, minute, suffix)
+}
+
+// wrapLines breaks every line of s at word boundaries so none is longer than width
+func wrapLines(s string, width int) string {
+	var out []string
+	for _, line := range strings.Split(s, "\n") {
+		current := ""
+		for _, word := range strings.Fields(line) {
+			if current != "" && len(current)+1+len(word) > width {
+				out = append(out, current)
+				current = ""
+			}
+			if current != "" {
+				current += " "
+			}
+			current += word
+		}
+		out = append(out, current)
+	}
+	return strings.Join(out, "\n")
+}
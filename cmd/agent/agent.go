@@ -1,20 +1,28 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"strings"
+	"time"
+
+	"github.com/zhangbiao2009/train-booking/internal/otlp"
+	"github.com/zhangbiao2009/train-booking/internal/server"
 )
 
 // DeepSeek API structures
 type ChatRequest struct {
-	Model    string    `json:"model"`
-	Messages []Message `json:"messages"`
+	Model       string    `json:"model"`
+	Messages    []Message `json:"messages"`
+	Temperature *float64  `json:"temperature,omitempty"`
+	TopP        *float64  `json:"top_p,omitempty"`
+	MaxTokens   int       `json:"max_tokens,omitempty"`
 }
 
 type Message struct {
@@ -32,14 +40,17 @@ type Choice struct {
 
 // Train booking structures
 type Train struct {
-	ID            string `json:"id"`
-	From          string `json:"from"`
-	To            string `json:"to"`
-	Date          string `json:"date"`
-	DepartureTime string `json:"departure_time"`
-	ArrivalTime   string `json:"arrival_time"`
-	TotalTickets  int    `json:"total_tickets"`
-	Available     int    `json:"available"`
+	ID              string `json:"id"`
+	From            string `json:"from"`
+	To              string `json:"to"`
+	Date            string `json:"date"`
+	DepartureTime   string `json:"departure_time"`
+	ArrivalTime     string `json:"arrival_time"`
+	TotalTickets    int    `json:"total_tickets"`
+	Available       int    `json:"available"`
+	PetsAllowed     bool   `json:"pets_allowed"`
+	AccessibleSeats int    `json:"accessible_seats,omitempty"` // wheelchair-accessible seats set aside
+	Provider        string `json:"provider,omitempty"`         // server it came from, in federated results
 }
 
 // Intent response structure
@@ -48,110 +59,156 @@ type IntentResponse struct {
 	Parameters        map[string]string `json:"parameters"`
 	MissingParameters []string          `json:"missing_parameters"`
 	ClarifyQuestion   string            `json:"clarify_question"`
+	Confidence        *float64          `json:"confidence,omitempty"` // 0-1, how sure the model is of its reading
+
+	parseFailed bool // the model's reply was not valid JSON, or broke the schema
+	settled     bool // ClarifyQuestion is the agent's own, not for slot filling to replace
 }
 
 type BookingAgent struct {
 	apiKey              string
-	serverURL           string
+	serverURL           string // the provider's endpoint
 	conversationHistory []Message
-	userID              string // Add user ID support
+
+	// Tokens the history and the summary of older turns may take; 0 for no limit
+	memoryTokens int
+	summary      []string // one line per turn evicted from the history, oldest first
+
+	// Models tried in turn for each message, with their sampling, and the
+	// times a reply that is not JSON is sent back to be fixed
+	llm        []llmBackend
+	llmRepairs int
+
+	// Placeholders standing in for personal data in what DeepSeek is sent
+	pii         *piiVault
+	userID      string // Add user ID support
+	pinned      bool   // a channel conversation: every action is for userID
+	pendingUser string // user named by /switch, awaiting confirmation
+
+	// Bookings and cancellations read with less confidence than confirmBelow are
+	// restated and held in pendingAction until the user says yes
+	confirmBelow  float64
+	pendingAction *IntentResponse
+	pendingSeat   bool           // pendingAction waits to hear whether wheelchair-accessible seats are wanted
+	pendingField  *fieldQuestion // pendingAction waits for a traveler detail the provider turned down
+
+	// A search with misspelled cities corrected, offered after one found nothing
+	suggestedSearch *IntentResponse
+
+	// pendingAction waits for the answer to a provider's challenge, which
+	// challengeAnswer carries to the booking run again
+	pendingChallenge *challengeQuestion
+	challengeAnswer  *ChallengeAnswer
+
+	// Last booking sent; the same booking again within duplicateWindow is
+	// questioned or retried under the same idempotency key
+	lastBooking     *bookingAttempt
+	duplicateWindow time.Duration
+
+	// References of the last successful booking, for its receipt
+	lastBookingRefs []string
+
+	// Misunderstood messages in a row, and why a support case would be filed
+	misunderstood    int
+	escalationReason string
+
+	// Canned stand-in for DeepSeek in --offline mode
+	offline *cannedLLM
+
+	// Intents of earlier messages, reused when a message is repeated
+	intents *intentCache
+
+	// Actions DeepSeek can choose from, listed in the system prompt
+	tools *toolRegistry
+
+	// Forecasts for the weather tool and booking warnings; nil when off
+	weather WeatherProvider
+
+	// System prompt sent with every DeepSeek call, and the experiment that chose it
+	prompts    *promptSet
+	experiment *experiment
+
+	// Rail operator API for trains and bookings, the booking server by default
+	provider ProviderAdapter
+
+	// HTTP client for provider calls, which are recorded per turn
+	client   *http.Client
+	recorder *callRecorder
+
+	// Print every turn as a JSON object instead of prose
+	jsonOutput bool
+
+	// Describe the booking server calls for every message instead of making them
+	dryRun bool
+
+	// Deadlines for one message from input to reply and for each DeepSeek
+	// call; zero means none. Booking server calls use client.Timeout.
+	turnTimeout time.Duration
+	llmTimeout  time.Duration
+
+	// The action whose required parameters are being asked for, if any
+	frame *slotFrame
+
+	// Trains shown in the last list or search, in display order, and those
+	// shown by the current turn; the one train the last action was about
+	lastResults []Train
+	turnResults []Train
+	focusTrain  string
+
+	// Correlation IDs of the conversation and of the current turn, sent to
+	// the booking server as X-Session-ID and X-Request-ID and logged by both
+	sessionID string
+	requestID string
+
+	// Spoken-style results for screen readers
+	accessible bool
+
+	// Reads replies aloud when set, as SSML when ssml is set
+	tts  TextToSpeech
+	ssml bool
+
+	// Every turn with its action and result; rewritten to transcriptFile after each turn when set
+	transcript     []TranscriptEntry
+	transcriptFile string
+	started        time.Time
 }
 
 func NewBookingAgent(apiKey, serverURL string) *BookingAgent {
-	return &BookingAgent{
+	recorder := &callRecorder{next: http.DefaultTransport}
+	a := &BookingAgent{
 		apiKey:              apiKey,
 		serverURL:           serverURL,
 		conversationHistory: []Message{},
+		memoryTokens:        defaultMemoryTokens,
+		pii:                 newPIIVault(),
+		llm:                 defaultLLMChain(apiKey),
+		llmRepairs:          defaultLLMRepairs,
 		userID:              "user_001", // Default user ID
+		client:              &http.Client{Transport: loggingTransport{recorder}},
+		recorder:            recorder,
+		started:             time.Now(),
+		tools:               newToolRegistry(),
+		sessionID:           processSession,
 	}
-}
-
-// Fetch available trains from server
-func (a *BookingAgent) fetchAvailableTrains() ([]Train, error) {
-	resp, err := http.Get(fmt.Sprintf("%s/list", a.serverURL))
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("server error: %s", resp.Status)
-	}
-
-	var trains []Train
-	if err := json.NewDecoder(resp.Body).Decode(&trains); err != nil {
-		return nil, err
-	}
-
-	return trains, nil
+	a.provider, _ = newLocalServer(serverURL, a.client)
+	a.registerBuiltinTools()
+	a.registerStationTools()
+	a.registerReceiptTool()
+	a.registerEscalationTool()
+	a.registerFeedbackTool()
+	a.registerUpgradeTool()
+	a.registerMealsTool()
+	a.registerLuggageTool()
+	a.registerSeatTool()
+	a.registerTravelersTool()
+	a.registerPositionTool()
+	a.registerPunctualityTool()
+	a.registerFareCalendarTool()
+	return a
 }
 
 // Call DeepSeek API to understand user intent
-func (a *BookingAgent) callDeepSeek(userInput string) (*IntentResponse, error) {
-	systemPrompt := `You are a train booking assistant. Analyze user requests and respond with structured JSON.
-
-CRITICAL: Your response must be valid JSON only. Do not use markdown code blocks, do not wrap JSON in backticks, do not add any explanatory text. Return only the raw JSON object without any formatting or wrapper text.
-
-AVAILABLE APIs:
-/query - Get train information
-Parameters: id (required, train ID like G100)
-
-/book - Book a train ticket  
-Parameters: id (required, train ID), user_id (required, user identifier)
-
-/cancel - Cancel a train ticket
-Parameters: id (required, train ID), user_id (required, user identifier)
-
-/list - Show all available trains
-Parameters: None
-
-/tickets - Search trains by criteria
-Parameters: from (optional, departure city), to (optional, destination city), date (optional, YYYY-MM-DD)
-
-/user/tickets - Get user's booked tickets
-Parameters: user_id (required, user identifier)
-
-INTENT CLASSIFICATION:
-- query_ticket: User wants information about a specific train
-- book_ticket: User wants to book a ticket (specific train or search criteria)
-- cancel_ticket: User wants to cancel a booked ticket
-- list_trains: User wants to see all available trains
-- search_trains: User wants to search for trains by criteria
-- my_tickets: User wants to see their booked tickets
-- unknown: Cannot determine intent
-
-CONTEXT PARSING:
-- Parse numbered results from previous responses like "1. G100: Beijing → Shanghai..."
-- When user says "first", "second", extract train ID from numbered position
-- For vague references with multiple options, ask for clarification
-
-If the user's message is unclear or lacks required parameters, ask a clarifying question. Try to confirm the missing fields in natural, polite English.
-
-RESPONSE FORMAT: Return ONLY valid JSON in this exact structure (no markdown, no backticks, no explanations):
-{
-  "intent": "query_ticket | book_ticket | cancel_ticket | list_trains | search_trains | my_tickets | unknown",
-  "parameters": {
-    "train_id": "",
-    "from": "",
-    "to": "",
-    "date": "",
-    "user_id": ""
-  },
-  "missing_parameters": [],
-  "clarify_question": ""
-}
-
-EXAMPLES:
-User: "Check train G100" → {"intent": "query_ticket", "parameters": {"train_id": "G100"}, "missing_parameters": [], "clarify_question": ""}
-User: "Book ticket for D200" → {"intent": "book_ticket", "parameters": {"train_id": "D200"}, "missing_parameters": ["user_id"], "clarify_question": "Please provide your user ID to book the ticket."}
-User: "Book G102 for me. my user id is 4343" → {"intent": "book_ticket", "parameters": {"train_id": "G102", "user_id": "4343"}, "missing_parameters": [], "clarify_question": ""}
-User: "Find trains to Shanghai" → {"intent": "search_trains", "parameters": {"to": "Shanghai"}, "missing_parameters": [], "clarify_question": ""}
-User: "Book a ticket" → {"intent": "book_ticket", "parameters": {}, "missing_parameters": ["train_id"], "clarify_question": "Which train would you like to book? Please provide the train ID or tell me your travel details."}
-User: "Show my bookings" → {"intent": "my_tickets", "parameters": {}, "missing_parameters": ["user_id"], "clarify_question": "Please provide your user ID to view your tickets."}
-
-If you cannot understand the user's intent at all, set intent to "unknown" and leave other fields empty.
-
-IMPORTANT: Your entire response must be parseable JSON. No markdown formatting, no code blocks, no extra text.`
+func (a *BookingAgent) callDeepSeek(ctx context.Context, userInput string) (*IntentResponse, error) {
 
 	// Add user input to conversation history
 	a.conversationHistory = append(a.conversationHistory, Message{
@@ -159,105 +216,159 @@ IMPORTANT: Your entire response must be parseable JSON. No markdown formatting,
 		Content: userInput,
 	})
 
-	// Build messages with conversation history
-	messages := []Message{
-		{Role: "system", Content: systemPrompt},
+	// A repeat of an earlier self-contained request needs no DeepSeek call
+	if cached, ok := a.intents.lookup(a.userID, userInput); ok {
+		logger.InfoContext(ctx, "intent", "intent", cached.Intent, "parameters", cached.Parameters, "cached", true)
+		return cached, nil
 	}
 
-	// Add recent conversation history (last 10 messages to avoid token limits)
-	historyStart := 0
-	if len(a.conversationHistory) > 10 {
-		historyStart = len(a.conversationHistory) - 10
+	// Build messages with the conversation history that fits the memory budget
+	messages := a.memoryMessages()
+
+	// Parse the JSON response, asking again while it does not parse
+	intentResp, model, err := a.readIntent(ctx, userInput, messages)
+	if err != nil {
+		return nil, err
 	}
-	messages = append(messages, a.conversationHistory[historyStart:]...)
+	if intentResp == nil {
+		// If JSON parsing still fails, treat as unknown intent
+		return &IntentResponse{
+			Intent:          "unknown",
+			Parameters:      map[string]string{},
+			ClarifyQuestion: "I didn't understand your request. Could you please rephrase it?",
+			parseFailed:     true,
+		}, nil
+	}
+	a.restoreIntent(intentResp)
+	a.normalizeParams(intentResp)
+	a.applyReferences(intentResp, userInput)
+
+	// Nothing the schema rules out reaches the tools or the server
+	if v := a.checkIntent(intentResp); v != nil {
+		logger.WarnContext(ctx, "invalid llm response", "intent", intentResp.Intent, "problem", v.problem, "model", model)
+		debugLog.Printf("DeepSeek response breaks the schema: %s", v.problem)
+		return &IntentResponse{
+			Intent:          intentResp.Intent,
+			Parameters:      intentResp.Parameters,
+			ClarifyQuestion: v.question,
+			parseFailed:     true,
+			settled:         true,
+		}, nil
+	}
+
+	logger.InfoContext(ctx, "intent", "intent", intentResp.Intent, "parameters", intentResp.Parameters,
+		"missing", intentResp.MissingParameters, "clarifying", intentResp.ClarifyQuestion != "",
+		"confidence", intentResp.Confidence, "model", model)
+	a.intents.store(a.userID, userInput, intentResp)
+	return intentResp, nil
+}
 
-	req := ChatRequest{
-		Model:    "deepseek-chat",
-		Messages: messages,
+// completeChain asks the models of the chain in turn, each within
+// --llm-timeout, and returns the first reply and the model that gave it.
+// Failures before the last model are logged and passed over; the last
+// model's failure is returned.
+func (a *BookingAgent) completeChain(ctx context.Context, messages []Message) (reply, model string, err error) {
+	for i, b := range a.llm {
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if a.llmTimeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, a.llmTimeout)
+		}
+		reply, err = a.complete(attemptCtx, b, messages)
+		if err != nil && ctx.Err() == nil && errors.Is(attemptCtx.Err(), context.DeadlineExceeded) {
+			err = &llmTimeoutError{a.llmTimeout, b.name()}
+		}
+		cancel()
+		if err == nil {
+			return reply, b.Model, nil
+		}
+		if ctx.Err() != nil || i == len(a.llm)-1 {
+			break
+		}
+		logger.WarnContext(ctx, "llm fallback", "model", b.Model, "next", a.llm[i+1].Model, "err", err)
+		debugLog.Printf("%s (%s) failed, trying %s: %v", b.name(), b.Model, a.llm[i+1].Model, err)
+	}
+	return "", "", err
+}
+
+// complete sends messages to the chat API of b and returns the reply text
+func (a *BookingAgent) complete(ctx context.Context, b llmBackend, messages []Message) (reply string, err error) {
+	req := b.request(messages)
+	ctx, span := telemetry.Start(ctx, "chat "+req.Model, otlp.KindClient,
+		otlp.String("gen_ai.system", b.Provider),
+		otlp.String("gen_ai.operation.name", "chat"),
+		otlp.String("gen_ai.request.model", req.Model),
+	)
+	if req.Temperature != nil {
+		span.SetAttributes(otlp.Float("gen_ai.request.temperature", *req.Temperature))
 	}
+	if req.MaxTokens > 0 {
+		span.SetAttributes(otlp.Int("gen_ai.request.max_tokens", req.MaxTokens))
+	}
+	start := time.Now()
+	defer func() {
+		attrs := []otlp.Attr{otlp.String("gen_ai.system", b.Provider), otlp.String("gen_ai.request.model", req.Model)}
+		if err != nil {
+			span.SetError(err.Error())
+			attrs = append(attrs, otlp.String("error.type", "error"))
+		}
+		span.End()
+		llmDuration.Record(time.Since(start).Seconds(), attrs...)
+	}()
 
 	data, err := json.Marshal(req)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
-	httpReq, err := http.NewRequest("POST", "https://api.deepseek.com/v1/chat/completions", bytes.NewBuffer(data))
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", b.url, bytes.NewBuffer(data))
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
 	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", "Bearer "+a.apiKey)
+	httpReq.Header.Set("Authorization", "Bearer "+b.key)
 
 	client := &http.Client{}
 	resp, err := client.Do(httpReq)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	logger.InfoContext(ctx, "llm call", "model", req.Model, "status", resp.StatusCode, "latency_ms", time.Since(start).Milliseconds())
+	span.SetAttributes(otlp.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s answered %s", b.name(), resp.Status)
 	}
 
 	var chatResp ChatResponse
 	if err := json.Unmarshal(body, &chatResp); err != nil {
-		return nil, err
+		return "", err
 	}
 
 	if len(chatResp.Choices) == 0 {
-		return nil, fmt.Errorf("no response from DeepSeek")
+		return "", fmt.Errorf("no response from %s", b.name())
 	}
 
-	response := strings.TrimSpace(chatResp.Choices[0].Message.Content)
-
-	// Debug logging - remove this in production
-	fmt.Printf("\r🔍 Debug - DeepSeek response: %q\n", response)
-
-	// Parse JSON response
-	var intentResp IntentResponse
-	if err := json.Unmarshal([]byte(response), &intentResp); err != nil {
-		// If JSON parsing fails, treat as unknown intent
-		return &IntentResponse{
-			Intent:          "unknown",
-			Parameters:      map[string]string{},
-			ClarifyQuestion: "I didn't understand your request. Could you please rephrase it?",
-		}, nil
-	}
-
-	return &intentResp, nil
+	return strings.TrimSpace(chatResp.Choices[0].Message.Content), nil
 }
 
-// Execute the action determined by DeepSeek
-func (a *BookingAgent) executeAction(intentResp *IntentResponse) string {
+// Execute the action determined by DeepSeek: run the tool it named
+func (a *BookingAgent) executeAction(ctx context.Context, intentResp *IntentResponse) string {
 	// If there's a clarify question, return it directly
 	if intentResp.ClarifyQuestion != "" {
 		return "🤔 " + intentResp.ClarifyQuestion
 	}
 
+	if tool, ok := a.tools.lookup(intentResp.Intent); ok {
+		return tool.Execute(ctx, intentResp.Parameters)
+	}
+
 	switch intentResp.Intent {
-	case "query_ticket":
-		trainID := intentResp.Parameters["train_id"]
-		return a.queryTrain(trainID)
-	case "book_ticket":
-		trainID := intentResp.Parameters["train_id"]
-		userID := intentResp.Parameters["user_id"]
-		return a.bookTicket(trainID, userID)
-	case "cancel_ticket":
-		trainID := intentResp.Parameters["train_id"]
-		userID := intentResp.Parameters["user_id"]
-		return a.cancelTicket(trainID, userID)
-	case "list_trains":
-		return a.listTrains()
-	case "search_trains":
-		from := intentResp.Parameters["from"]
-		to := intentResp.Parameters["to"]
-		date := intentResp.Parameters["date"]
-		return a.searchTrains(from, to, date)
-	case "my_tickets":
-		userID := intentResp.Parameters["user_id"]
-		return a.getUserTickets(userID)
 	case "unknown":
 		return "❌ I didn't understand your request. Please try asking to query, book, cancel, search for trains, or list all trains."
 	default:
@@ -265,35 +376,32 @@ func (a *BookingAgent) executeAction(intentResp *IntentResponse) string {
 	}
 }
 
-func (a *BookingAgent) queryTrain(trainID string) string {
+func (a *BookingAgent) queryTrain(ctx context.Context, trainID string) string {
 	if trainID == "" {
 		return "❌ Please specify a train ID (e.g., G100, D200, K300)"
 	}
 
-	resp, err := http.Get(fmt.Sprintf("%s/query?id=%s", a.serverURL, trainID))
+	trains, err := a.provider.Search(ctx, SearchQuery{TrainIDs: []string{trainID}})
 	if err != nil {
-		return fmt.Sprintf("❌ Error querying train: %v", err)
+		return a.providerError(ctx, "querying train", err)
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == http.StatusNotFound {
+	if len(trains) == 0 {
 		return fmt.Sprintf("❌ Train %s not found", trainID)
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Sprintf("❌ Error: %s", resp.Status)
+	train := trains[0]
+	if a.accessible {
+		return "Train " + describeTrain(train)
 	}
-
-	var train Train
-	if err := json.NewDecoder(resp.Body).Decode(&train); err != nil {
-		return fmt.Sprintf("❌ Error decoding response: %v", err)
+	if diagram := routeDiagram([]Train{train}); diagram != "" {
+		return fmt.Sprintf("🚄 Train %s%s\n📅 Date: %s\n%s\n🎫 Available: %d/%d tickets",
+			train.ID, providerTag(train.Provider), train.Date, diagram, train.Available, train.TotalTickets)
 	}
-
-	return fmt.Sprintf("🚄 Train %s\n📍 Route: %s → %s\n📅 Date: %s\n🕐 Departure: %s | Arrival: %s\n🎫 Available: %d/%d tickets",
-		train.ID, train.From, train.To, train.Date, train.DepartureTime, train.ArrivalTime, train.Available, train.TotalTickets)
+	return fmt.Sprintf("🚄 Train %s%s\n📍 Route: %s → %s\n📅 Date: %s\n🕐 Departure: %s | Arrival: %s\n🎫 Available: %d/%d tickets",
+		train.ID, providerTag(train.Provider), train.From, train.To, train.Date, train.DepartureTime, train.ArrivalTime, train.Available, train.TotalTickets)
 }
 
-func (a *BookingAgent) bookTicket(trainID string, userID string) string {
+func (a *BookingAgent) bookTicket(ctx context.Context, trainID string, userID string, extras bookingExtras, key string) string {
 	if trainID == "" {
 		return "❌ Please specify a train ID to book (e.g., G100, D200, K300)"
 	}
@@ -304,39 +412,84 @@ func (a *BookingAgent) bookTicket(trainID string, userID string) string {
 		effectiveUserID = a.userID
 	}
 
-	// Debug logging - remove this in production
-	fmt.Printf("🔍 Debug - Booking train ID: %q (length: %d)\n", trainID, len(trainID))
-
-	url := fmt.Sprintf("%s/book?id=%s&user_id=%s", a.serverURL, trainID, effectiveUserID)
-	fmt.Printf("🔍 Debug - Request URL: %q\n", url)
-
-	resp, err := http.Get(url)
-	if err != nil {
-		return fmt.Sprintf("❌ Error booking ticket: %v", err)
+	refs, err := a.provider.Book(ctx, BookingRequest{TrainIDs: []string{trainID}, UserID: effectiveUserID, Insurance: extras.insured, Pet: extras.pet, Wheelchair: extras.wheelchair, Travelers: extras.travelers, IdempotencyKey: key, Challenge: a.takeChallengeAnswer()})
+	switch {
+	case errors.Is(err, ErrTrainNotFound):
+		return fmt.Sprintf("❌ Train %s not found", trainID)
+	case errors.Is(err, ErrSoldOut) && extras.wheelchair:
+		// The provider explains, e.g. "K300: no wheelchair-accessible seats left"
+		return fmt.Sprintf("❌ %v. I'm sorry; another train on the route may still have one.", err)
+	case errors.Is(err, ErrSoldOut):
+		return fmt.Sprintf("❌ No tickets available for train %s", trainID)
+	case errors.Is(err, ErrTrainLimit):
+		// The provider explains, e.g. "G100: the ticket limit per user on a train is 5, and 5 already booked"
+		return fmt.Sprintf("❌ %v. That is the most one person may book on a train; cancel one of those tickets first, or choose another train.", err)
+	case errors.Is(err, ErrThrottled), errors.Is(err, ErrVerificationRequired):
+		if question, ok := a.askChallenge(err); ok {
+			return question
+		}
+		return fmt.Sprintf("❌ Train %s was not booked: %v. The provider holds back bookings made in quick succession, against ticket scalping.", trainID, err)
+	case errors.Is(err, ErrNotAllowed):
+		// The provider explains, e.g. "G100: pets are not allowed on this train; ..."
+		return fmt.Sprintf("❌ %v", err)
+	case errors.Is(err, ErrDeparted):
+		return a.departedReply(ctx, trainID, err)
+	case errors.Is(err, ErrInvalid):
+		if question, ok := a.askField(effectiveUserID, err); ok {
+			return question
+		}
+		return fmt.Sprintf("❌ Invalid request: %v", err)
+	case err != nil:
+		return a.providerError(ctx, "booking ticket", err)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusNotFound {
-		return fmt.Sprintf("❌ Train %s not found", trainID)
+	if len(extras.travelers) > 0 {
+		return fmt.Sprintf("✅ Successfully booked %d tickets on train %s%s (user %s)!", len(extras.travelers), trainID, travelersNote(extras.travelers), effectiveUserID) + a.bookingReceipts(ctx, refs, effectiveUserID) + a.mealOffer(ctx, []string{trainID})
 	}
+	return fmt.Sprintf("✅ Successfully booked ticket for train %s for user %s!", trainID, effectiveUserID) + a.bookingReceipts(ctx, refs, effectiveUserID) + a.mealOffer(ctx, []string{trainID})
+}
 
-	if resp.StatusCode == http.StatusConflict {
-		return fmt.Sprintf("❌ No tickets available for train %s", trainID)
+// Book every leg of a journey in one all-or-nothing request
+func (a *BookingAgent) bookItinerary(ctx context.Context, trainIDs []string, userID string, extras bookingExtras, key string) string {
+	for i := range trainIDs {
+		trainIDs[i] = strings.TrimSpace(trainIDs[i])
 	}
 
-	if resp.StatusCode == http.StatusBadRequest {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Sprintf("❌ Invalid request: %s", string(body))
+	// Use provided userID, fallback to agent's default if empty
+	effectiveUserID := userID
+	if effectiveUserID == "" {
+		effectiveUserID = a.userID
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Sprintf("❌ Error: %s", resp.Status)
+	refs, err := a.provider.Book(ctx, BookingRequest{TrainIDs: trainIDs, UserID: effectiveUserID, Insurance: extras.insured, Pet: extras.pet, Wheelchair: extras.wheelchair, Travelers: extras.travelers, IdempotencyKey: key, Challenge: a.takeChallengeAnswer()})
+	switch {
+	case errors.Is(err, ErrTrainLimit):
+		return fmt.Sprintf("❌ Nothing was booked: %v. That is the most one person may book on a train; cancel one of those tickets first, or choose another train.", err)
+	case errors.Is(err, ErrThrottled), errors.Is(err, ErrVerificationRequired):
+		if question, ok := a.askChallenge(err); ok {
+			return question
+		}
+		return fmt.Sprintf("❌ Nothing was booked: %v. The provider holds back bookings made in quick succession, against ticket scalping.", err)
+	case errors.Is(err, ErrTrainNotFound), errors.Is(err, ErrSoldOut), errors.Is(err, ErrNotAllowed):
+		// The provider names the train that failed, e.g. "K300: no tickets available"
+		return fmt.Sprintf("❌ Nothing was booked: %v", err)
+	case errors.Is(err, ErrDeparted):
+		return "❌ Nothing was booked. " + strings.TrimPrefix(a.departedReply(ctx, departedTrain(err), err), "❌ ")
+	case errors.Is(err, ErrInvalid):
+		if question, ok := a.askField(effectiveUserID, err); ok {
+			return question
+		}
+		return fmt.Sprintf("❌ Invalid request: %v", err)
+	case err != nil:
+		return a.providerError(ctx, "booking tickets", err)
 	}
 
-	return fmt.Sprintf("✅ Successfully booked ticket for train %s for user %s!", trainID, effectiveUserID)
+	return fmt.Sprintf("✅ Successfully booked tickets for trains %s%s for user %s!", strings.Join(trainIDs, ", "), travelersNote(extras.travelers), effectiveUserID) + a.journeyDiagram(ctx, trainIDs) + a.bookingReceipts(ctx, refs, effectiveUserID) + a.mealOffer(ctx, trainIDs)
 }
 
-func (a *BookingAgent) cancelTicket(trainID string, userID string) string {
+// cancelTicket cancels a ticket on trainID, refunding it by bookingRef, or by
+// the last booking made in this conversation with that train
+func (a *BookingAgent) cancelTicket(ctx context.Context, trainID string, userID string, bookingRef string) string {
 	if trainID == "" {
 		return "❌ Please specify a train ID to cancel (e.g., G100, D200, K300)"
 	}
@@ -347,101 +500,102 @@ func (a *BookingAgent) cancelTicket(trainID string, userID string) string {
 		effectiveUserID = a.userID
 	}
 
-	resp, err := http.Get(fmt.Sprintf("%s/cancel?id=%s&user_id=%s", a.serverURL, trainID, effectiveUserID))
-	if err != nil {
-		return fmt.Sprintf("❌ Error canceling ticket: %v", err)
+	if bookingRef == "" {
+		bookingRef = a.bookingWith(ctx, trainID, effectiveUserID)
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == http.StatusNotFound {
+	refund, err := a.provider.Cancel(ctx, CancelRequest{TrainID: trainID, UserID: effectiveUserID, BookingRef: bookingRef})
+	switch {
+	case errors.Is(err, ErrNoReceipt):
+		return fmt.Sprintf("❌ No booking %s with a ticket on train %s found for user %s", bookingRef, trainID, effectiveUserID)
+	case errors.Is(err, ErrTrainNotFound):
 		return fmt.Sprintf("❌ Train %s not found", trainID)
-	}
-
-	if resp.StatusCode == http.StatusConflict {
+	case errors.Is(err, ErrNoBooking):
 		return fmt.Sprintf("❌ No tickets to cancel for train %s", trainID)
+	case errors.Is(err, ErrDeparted):
+		return fmt.Sprintf("❌ %v. Tickets cannot be cancelled once the train is about to leave.", err)
+	case err != nil:
+		return a.providerError(ctx, "canceling ticket", err)
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Sprintf("❌ Error: %s", resp.Status)
-	}
-
-	return fmt.Sprintf("✅ Successfully canceled ticket for train %s!", trainID)
+	return fmt.Sprintf("✅ Successfully canceled ticket for train %s!", trainID) + a.formatRefund(refund)
 }
 
-func (a *BookingAgent) listTrains() string {
-	trains, err := a.fetchAvailableTrains()
+func (a *BookingAgent) listTrains(ctx context.Context) string {
+	trains, err := a.provider.Search(ctx, SearchQuery{})
 	if err != nil {
-		return fmt.Sprintf("❌ Error fetching train list: %v", err)
+		return a.providerError(ctx, "fetching train list", err)
 	}
 
+	a.showResults(trains)
 	if len(trains) == 0 {
 		return "❌ No trains available"
 	}
+	if a.accessible {
+		return describeTrains("Available trains", trains)
+	}
 
 	result := "🚄 Available Trains:\n"
 	for _, train := range trains {
-		result += fmt.Sprintf("• %s: %s → %s | %s | %s-%s (%d/%d available)\n",
-			train.ID, train.From, train.To, train.Date, train.DepartureTime, train.ArrivalTime, train.Available, train.TotalTickets)
+		result += fmt.Sprintf("• %s: %s → %s | %s | %s-%s (%d/%d available)%s\n",
+			train.ID, train.From, train.To, train.Date, train.DepartureTime, train.ArrivalTime, train.Available, train.TotalTickets, providerTag(train.Provider))
 	}
 
 	return result
 }
 
-func (a *BookingAgent) searchTrains(from, to, date string) string {
-	// Build query string
-	var queryParams []string
-	if from != "" {
-		queryParams = append(queryParams, fmt.Sprintf("from=%s", from))
-	}
-	if to != "" {
-		queryParams = append(queryParams, fmt.Sprintf("to=%s", to))
-	}
-	if date != "" {
-		queryParams = append(queryParams, fmt.Sprintf("date=%s", date))
-	}
-
-	queryString := ""
-	if len(queryParams) > 0 {
-		queryString = "?" + strings.Join(queryParams, "&")
-	}
-
-	resp, err := http.Get(fmt.Sprintf("%s/tickets%s", a.serverURL, queryString))
+func (a *BookingAgent) searchTrains(ctx context.Context, q SearchQuery) string {
+	trains, err := a.provider.Search(ctx, q)
 	if err != nil {
-		return fmt.Sprintf("❌ Error searching tickets: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Sprintf("❌ Error: %s", resp.Status)
-	}
-
-	var trains []Train
-	if err := json.NewDecoder(resp.Body).Decode(&trains); err != nil {
-		return fmt.Sprintf("❌ Error decoding response: %v", err)
+		return a.providerError(ctx, "searching tickets", err)
 	}
+	a.showResults(trains)
 
 	if len(trains) == 0 {
 		searchCriteria := []string{}
-		if from != "" {
-			searchCriteria = append(searchCriteria, fmt.Sprintf("from %s", from))
+		if q.From != "" {
+			searchCriteria = append(searchCriteria, fmt.Sprintf("from %s", q.From))
+		}
+		if q.To != "" {
+			searchCriteria = append(searchCriteria, fmt.Sprintf("to %s", q.To))
 		}
-		if to != "" {
-			searchCriteria = append(searchCriteria, fmt.Sprintf("to %s", to))
+		if q.Date != "" {
+			searchCriteria = append(searchCriteria, fmt.Sprintf("on %s", q.Date))
 		}
-		if date != "" {
-			searchCriteria = append(searchCriteria, fmt.Sprintf("on %s", date))
+		if q.Pets {
+			searchCriteria = append(searchCriteria, "that take pets")
 		}
 		criteriaText := strings.Join(searchCriteria, " ")
 		if criteriaText == "" {
 			criteriaText = "matching your criteria"
 		}
-		return fmt.Sprintf("❌ No trains found %s", criteriaText)
+		return fmt.Sprintf("❌ No trains found %s", criteriaText) + a.suggestSearch(ctx, q)
+	}
+	// With several options, each train's punctuality helps choose
+	var punctuality map[string]string
+	if len(trains) > 1 {
+		punctuality = a.punctualityNotes(ctx, trains)
+	}
+	if a.accessible {
+		result := describeTrains("Search results", trains)
+		for _, train := range trains {
+			if note, ok := punctuality[train.ID]; ok {
+				result += "\n" + note
+			}
+		}
+		return result
 	}
 
 	result := "🔍 Search Results:\n"
 	for i, train := range trains {
-		result += fmt.Sprintf("%d. %s: %s → %s | %s | %s-%s (%d/%d available)\n",
-			i+1, train.ID, train.From, train.To, train.Date, train.DepartureTime, train.ArrivalTime, train.Available, train.TotalTickets)
+		pets := ""
+		if train.PetsAllowed {
+			pets = " 🐾"
+		}
+		if note, ok := punctuality[train.ID]; ok {
+			pets += " | ⏱️ " + note
+		}
+		result += fmt.Sprintf("%d. %s: %s → %s | %s | %s-%s (%d/%d available)%s%s\n",
+			i+1, train.ID, train.From, train.To, train.Date, train.DepartureTime, train.ArrivalTime, train.Available, train.TotalTickets, pets, providerTag(train.Provider))
 	}
 
 	return result
@@ -449,134 +603,475 @@ func (a *BookingAgent) searchTrains(from, to, date string) string {
 
 // UserBooking represents a user's booking information
 type UserBooking struct {
-	TrainID string `json:"train_id"`
-	Count   int    `json:"count"`
+	TrainID  string `json:"train_id"`
+	Count    int    `json:"count"`
+	Provider string `json:"provider,omitempty"` // server holding it, when federated
 }
 
-func (a *BookingAgent) getUserTickets(userID string) string {
+func (a *BookingAgent) getUserTickets(ctx context.Context, userID string) string {
 	// Use provided userID, fallback to agent's default if empty
 	effectiveUserID := userID
 	if effectiveUserID == "" {
 		effectiveUserID = a.userID
 	}
 
-	resp, err := http.Get(fmt.Sprintf("%s/user/tickets?user_id=%s", a.serverURL, effectiveUserID))
+	userBookings, err := a.provider.Status(ctx, effectiveUserID)
 	if err != nil {
-		return fmt.Sprintf("❌ Error fetching your tickets: %v", err)
+		return a.providerError(ctx, "fetching your tickets", err)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Sprintf("❌ Error: %s", resp.Status)
+	if len(userBookings) == 0 {
+		return "📋 You don't have any booked tickets yet."
 	}
 
-	var userBookings []UserBooking
-	if err := json.NewDecoder(resp.Body).Decode(&userBookings); err != nil {
-		return fmt.Sprintf("❌ Error decoding response: %v", err)
+	// Get train details for all bookings in one request
+	trainIDs := make([]string, len(userBookings))
+	for i, booking := range userBookings {
+		trainIDs[i] = booking.TrainID
 	}
-
-	if len(userBookings) == 0 {
-		return "📋 You don't have any booked tickets yet."
+	trains := a.getTrainDetails(ctx, trainIDs)
+	if a.accessible {
+		return describeBookings(userBookings, trains)
 	}
 
 	result := "🎫 Your Booked Tickets:\n"
 	for _, booking := range userBookings {
-		// Get train details for each booking
-		train := a.getTrainDetails(booking.TrainID)
-		if train != nil {
-			result += fmt.Sprintf("• %s: %s → %s | %s | %s-%s (x%d tickets)\n",
+		if train, ok := trains[booking.TrainID]; ok {
+			result += fmt.Sprintf("• %s: %s → %s | %s | %s-%s (x%d tickets)%s\n",
 				booking.TrainID, train.From, train.To, train.Date,
-				train.DepartureTime, train.ArrivalTime, booking.Count)
+				train.DepartureTime, train.ArrivalTime, booking.Count, providerTag(booking.Provider))
 		} else {
-			result += fmt.Sprintf("• %s (x%d tickets)\n", booking.TrainID, booking.Count)
+			result += fmt.Sprintf("• %s (x%d tickets)%s\n", booking.TrainID, booking.Count, providerTag(booking.Provider))
 		}
 	}
 
 	return result
 }
 
-// Helper method to get train details
-func (a *BookingAgent) getTrainDetails(trainID string) *Train {
-	resp, err := http.Get(fmt.Sprintf("%s/query?id=%s", a.serverURL, trainID))
-	if err != nil {
-		return nil
-	}
-	defer resp.Body.Close()
+// Helper method to get details for several trains, keyed by train ID
+func (a *BookingAgent) getTrainDetails(ctx context.Context, trainIDs []string) map[string]Train {
+	trains := map[string]Train{}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil
+	found, err := a.provider.Search(ctx, SearchQuery{TrainIDs: trainIDs})
+	if err != nil {
+		return trains
 	}
 
-	var train Train
-	if err := json.NewDecoder(resp.Body).Decode(&train); err != nil {
-		return nil
+	for _, train := range found {
+		trains[train.ID] = train
 	}
-
-	return &train
+	return trains
 }
 
-func (a *BookingAgent) chat() {
-	fmt.Println("🤖 Train Booking Agent")
-	fmt.Println("💬 I can help you query, book, and cancel train tickets!")
-	fmt.Println("📝 Type 'quit' to exit")
-
-	scanner := bufio.NewScanner(os.Stdin)
+func (a *BookingAgent) chat(input lineReader) {
+	fmt.Fprintln(notices, "🤖 Train Booking Agent")
+	fmt.Fprintln(notices, "💬 I can help you query, book, and cancel train tickets!")
+	fmt.Fprintln(notices, "📝 Type 'quit' to exit, ':help' for commands")
 
 	for {
-		fmt.Print("You: ")
-		if !scanner.Scan() {
+		input.SetPrompt(a.prompt())
+		line, err := input.ReadLine()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				logger.Error("reading input failed", "err", err)
+			}
 			break
 		}
 
-		userInput := strings.TrimSpace(scanner.Text())
+		userInput := strings.TrimSpace(line)
 		if userInput == "" {
 			continue
 		}
 
 		if strings.ToLower(userInput) == "quit" {
-			fmt.Println("👋 Goodbye!")
+			fmt.Fprintln(notices, "👋 Goodbye!")
 			break
 		}
 
-		fmt.Print("🤖 Agent: Thinking...")
+		if reply, ok := a.metaCommand(userInput); ok {
+			fmt.Fprintln(notices, reply)
+			continue
+		}
+
+		if a.jsonOutput {
+			a.respondInterruptible(userInput)
+			a.printTurn()
+			continue
+		}
+
+		if a.accessible {
+			// Screen readers announce each line once, so nothing is overwritten in place
+			fmt.Fprintln(out, "Agent is thinking.")
+			result, err := a.respondInterruptible(userInput)
+			if err != nil {
+				fmt.Fprintln(out, wrapLines(a.turnError(err), accessibleLineWidth))
+				a.speak(a.turnError(err))
+				continue
+			}
+			fmt.Fprintf(out, "Agent:\n%s\n\n", wrapLines(result, accessibleLineWidth))
+			a.speak(result)
+			continue
+		}
 
-		// Get intent from DeepSeek
-		intentResp, err := a.callDeepSeek(userInput)
+		stop := startSpinner(out, "🤖 Agent: Thinking")
+		result, err := a.respondInterruptible(userInput)
+		stop()
 		if err != nil {
-			fmt.Printf("\r❌ Error calling DeepSeek API: %v\n", err)
+			fmt.Fprintf(out, "%s\n", a.turnError(err))
+			a.speak(a.turnError(err))
 			continue
 		}
+		fmt.Fprintf(out, "🤖 Agent: %s\n\n", result)
+		a.speak(result)
+	}
+}
+
+// respond runs one conversation turn: understand the message, act on it and
+// remember the reply
+func (a *BookingAgent) respond(ctx context.Context, userInput string) (string, error) {
+	a.requestID = newRequestID()
+	ctx = withRequestID(withConversation(ctx, a.sessionID), a.requestID)
+	userInput = sanitizeInput(userInput)
+	ctx, span := telemetry.Start(ctx, "agent.turn", otlp.KindInternal,
+		otlp.String("request.id", a.requestID),
+		otlp.String("session.id", a.sessionID),
+	)
+	defer span.End()
+	logger.DebugContext(ctx, "user input", "text", userInput)
+	a.recorder.take(a.sessionID)
+	a.turnResults = nil
+	if a.turnTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.turnTimeout)
+		defer cancel()
+	}
 
-		// Execute the action
-		result := a.executeAction(intentResp)
-		fmt.Printf("\r🤖 Agent: %s\n\n", result)
+	// A yes or no to "... correct?" settles the held-back action without DeepSeek
+	if pending := a.pendingAction; pending != nil {
+		a.pendingAction = nil
+		answer := a.answerConfirmation
+		if a.pendingSeat {
+			answer = a.answerWheelchairQuestion
+		}
+		if a.pendingField != nil {
+			answer = a.answerFieldQuestion
+		}
+		if a.pendingChallenge != nil {
+			answer = a.answerChallenge
+		}
+		if reply, ok := answer(ctx, pending, userInput); ok {
+			return reply, nil
+		}
+	}
 
-		a.conversationHistory = append(a.conversationHistory, Message{
-			Role:    "assistant",
-			Content: result,
-		})
+	// "what would happen if I book G100" is a dry run of "book G100"
+	message := userInput
+	dryRun := a.dryRun
+	if rest, ok := whatIf(userInput); ok {
+		dryRun, message = true, rest
 	}
+	suspicious := looksLikeInjection(message)
+	if suspicious {
+		logger.WarnContext(ctx, "possible prompt injection")
+	}
+
+	// Get intent from DeepSeek, or the models after it in the chain
+	intentResp, err := a.callDeepSeek(ctx, message)
+	if err != nil {
+		logger.ErrorContext(ctx, "llm call failed", "err", err)
+		span.SetError(err.Error())
+		turnCounter.Add(1, otlp.String("intent", "error"))
+		a.record(TranscriptEntry{User: userInput, Error: err.Error()})
+		return "", err
+	}
+
+	// Parameters are gathered over turns until the action has what it needs
+	intentResp = a.fillSlots(ctx, intentResp, message)
+
+	// Changes to bookings must rest on what the user actually said
+	if question := a.ungrounded(ctx, intentResp, message, suspicious); question != "" {
+		refused := *intentResp
+		refused.ClarifyQuestion = question
+		intentResp = &refused
+	}
+
+	// Execute the action, unless it changes bookings on a shaky reading of the
+	// message or this is a dry run
+	var result string
+	var planned []ServerCall
+	confirming := false
+	if dryRun {
+		planned = a.plannedCalls(intentResp)
+		result = a.describePlan(intentResp, planned)
+	} else if a.needsConfirmation(intentResp) {
+		confirming = true
+		a.pendingAction, a.pendingSeat = intentResp, false
+		result = a.restate(ctx, intentResp)
+	} else if question := a.duplicateQuestion(intentResp); question != "" {
+		confirming = true
+		a.pendingAction, a.pendingSeat = intentResp, false
+		result = question
+	} else if question := a.wheelchairQuestion(ctx, intentResp, message); question != "" {
+		confirming = true
+		a.pendingAction, a.pendingSeat = intentResp, true
+		result = question
+	} else {
+		result = a.executeAction(ctx, intentResp)
+		result += a.noteOutcome(intentResp, result)
+		a.noteFocus(intentResp)
+		if a.pendingField != nil || a.pendingChallenge != nil {
+			confirming = true
+			a.pendingAction, a.pendingSeat = intentResp, false
+		}
+		if a.suggestedSearch != nil {
+			// A yes runs the corrected search
+			confirming = true
+			a.pendingAction, a.pendingSeat = a.suggestedSearch, false
+			a.suggestedSearch = nil
+		}
+	}
+	span.SetAttributes(otlp.String("intent", intentResp.Intent), otlp.Bool("dry_run", dryRun))
+	turnCounter.Add(1, otlp.String("intent", intentResp.Intent))
+	booked := intentResp.Intent == "book_ticket" && intentResp.ClarifyQuestion == "" && !confirming && !dryRun
+	a.experiment.recordTurn(turnResult{
+		parseFailed:   intentResp.parseFailed,
+		clarified:     intentResp.ClarifyQuestion != "" || confirming,
+		bookingTried:  booked,
+		bookingWorked: booked && outcome(result) == exitOK,
+	})
+	if !dryRun {
+		result += a.pendingNotices(ctx)
+	}
+	a.remember(result)
+	a.record(TranscriptEntry{
+		User:            userInput,
+		Intent:          intentResp.Intent,
+		Parameters:      intentResp.Parameters,
+		ClarifyQuestion: intentResp.ClarifyQuestion,
+		Calls:           a.recorder.take(a.sessionID),
+		DryRun:          dryRun,
+		Planned:         planned,
+		Trains:          a.turnResults,
+		Reply:           result,
+	})
+	return result, nil
+}
+
+// showResults remembers trains listed to the user, for "book the first one"
+func (a *BookingAgent) showResults(trains []Train) {
+	a.lastResults, a.turnResults = trains, trains
+}
+
+// remember adds an agent reply to the conversation history
+func (a *BookingAgent) remember(result string) {
+	a.conversationHistory = append(a.conversationHistory, Message{
+		Role:    "assistant",
+		Content: result,
+	})
+	a.trimMemory()
 }
 
 func main() {
-	apiKey := os.Getenv("DEEPSEEK_API_KEY")
-	if apiKey == "" {
-		fmt.Println("❌ Please set DEEPSEEK_API_KEY environment variable")
-		fmt.Println("💡 Example: export DEEPSEEK_API_KEY=your_api_key_here")
+	cfg := loadConfig()
+	setupOutput(cfg)
+	if err := setupDebugLog(cfg); err != nil {
+		fmt.Fprintf(notices, "❌ Cannot open debug log: %v\n", err)
+		os.Exit(1)
+	}
+	if err := setupLogger(cfg); err != nil {
+		fmt.Fprintf(notices, "❌ Cannot open log file: %v\n", err)
+		os.Exit(1)
+	}
+	if err := setupTelemetry(); err != nil {
+		fmt.Fprintf(notices, "❌ Invalid OpenTelemetry configuration: %v\n", err)
 		os.Exit(1)
 	}
+	defer flushTelemetry()
+
+	if cfg.ExperimentReport {
+		report, err := experimentReport(cfg.ExperimentFile)
+		if err != nil {
+			fmt.Fprintf(notices, "❌ Cannot read experiment data: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintln(out, report)
+		return
+	}
 
-	serverURL := "http://localhost:8080"
+	var apiKey string
+	var offline *cannedLLM
+	if cfg.Offline {
+		offline = &cannedLLM{}
+		if cfg.OfflineScript != "" {
+			script, err := loadOfflineScript(cfg.OfflineScript)
+			if err != nil {
+				fmt.Fprintf(notices, "❌ Cannot read offline script: %v\n", err)
+				os.Exit(1)
+			}
+			offline.script = script
+		}
+		fmt.Fprintln(notices, "🧪 Offline demo mode: replies come from canned rules, not DeepSeek")
+	} else {
+		key, keySource, err := loadAPIKey(cfg.APIKeyFile)
+		if err != nil {
+			fmt.Fprintf(notices, "❌ Cannot load DeepSeek API key: %v\n", err)
+			fmt.Fprintln(notices, "💡 Store it in a file only you can read and pass --api-key-file, or set DEEPSEEK_API_KEY_FILE,")
+			fmt.Fprintln(notices, "   DEEPSEEK_API_KEY_COMMAND, DEEPSEEK_API_KEY, or add it to the OS keychain (see README)")
+			fmt.Fprintln(notices, "💡 To try the agent without a key, use --offline")
+			os.Exit(1)
+		}
+		apiKey = key
+		fmt.Fprintf(notices, "🔑 Using DeepSeek API key from %s\n", keySource)
+	}
+
+	serverURL := cfg.ServerURL
+	if cfg.EmbeddedServer {
+		// Its request log joins the diagnostics
+		url, stop, err := server.Embedded(debugLog.Writer())
+		if err != nil {
+			fmt.Fprintf(notices, "❌ Cannot start the embedded booking server: %v\n", err)
+			os.Exit(1)
+		}
+		defer stop()
+		serverURL = url
+		fmt.Fprintf(notices, "🚆 Embedded demo booking server running at %s\n", serverURL)
+	}
 	agent := NewBookingAgent(apiKey, serverURL)
+	if endpoints, _ := parseServerURLs(serverURL); cfg.Provider != "local" || len(endpoints) > 1 {
+		provider, err := openProvider(cfg.Provider, endpoints, agent.client)
+		if err != nil {
+			fmt.Fprintf(notices, "❌ Cannot set up provider %s: %v\n", cfg.Provider, err)
+			os.Exit(1)
+		}
+		agent.provider = provider
+	}
+	weather, err := newWeatherProvider(cfg.Weather)
+	if err == nil && weather != nil {
+		err = agent.enableWeather(weather)
+	}
+	if err != nil {
+		fmt.Fprintf(notices, "❌ Cannot set up weather: %v\n", err)
+		os.Exit(1)
+	}
+
+	prompts, exp, err := choosePrompts(cfg, agent.tools)
+	if err != nil {
+		fmt.Fprintf(notices, "❌ Cannot load prompt templates: %v\n", err)
+		os.Exit(1)
+	}
+	if exp != nil {
+		logger = logger.With("prompt_variant", exp.variant)
+		exp.startSession()
+	}
+	logger.Info("session started", "offline", cfg.Offline, "prompt_version", prompts.version, "prompt_sha", prompts.hash, "prompt_source", prompts.source)
+	debugLog.Printf("System prompt version %s from %s", prompts, prompts.source)
+
+	agent.offline = offline
+	agent.prompts = prompts
+	if !cfg.Offline {
+		// Offline scripts answer message by message, so nothing is skipped
+		cachePath := cfg.IntentCache
+		if cachePath == "off" {
+			cachePath = ""
+		}
+		agent.intents = newIntentCache(cachePath, prompts)
+	}
+	agent.experiment = exp
+	agent.userID = cfg.User
+	agent.confirmBelow = cfg.ConfirmBelow
+	agent.duplicateWindow = cfg.DuplicateWindow
+	agent.accessible = cfg.Accessible
+	agent.tts, _ = newTextToSpeech(cfg.TTS, cfg.TTSCommand, cfg.PlayCommand)
+	agent.ssml = cfg.TTSSSML
+	agent.transcriptFile = cfg.Transcript
+	agent.jsonOutput = cfg.Output == "json"
+	agent.dryRun = cfg.DryRun
+	agent.turnTimeout = cfg.TurnTimeout
+	agent.llmTimeout = cfg.LLMTimeout
+	agent.llmRepairs = cfg.LLMRepairs
+	agent.memoryTokens = cfg.MemoryTokens
+	if !cfg.Offline {
+		chain, err := llmChainFor(cfg, apiKey)
+		if err != nil {
+			fmt.Fprintf(notices, "❌ Cannot set up --llm-chain: %v\n", err)
+			os.Exit(1)
+		}
+		agent.llm = chain
+	}
+	agent.client.Timeout = cfg.ServerTimeout
 
 	// Test if server is running
-	resp, err := http.Get(serverURL + "/query?id=G100")
+	if _, err := agent.provider.Search(withConversation(context.Background(), agent.sessionID), SearchQuery{}); err != nil {
+		fmt.Fprintf(notices, "❌ Cannot connect to booking server at %s\n", serverURL)
+		fmt.Fprintln(notices, "💡 Start the server with: make run-server, or point the agent at it with --server-url")
+		os.Exit(exitUnavailable)
+	}
+
+	if cfg.Command != "" {
+		code := agent.runOnce(cfg.Command)
+		flushTelemetry()
+		os.Exit(code)
+	}
+	if cfg.Batch != "" {
+		code := agent.runBatch(cfg.Batch)
+		flushTelemetry()
+		os.Exit(code)
+	}
+
+	if cfg.Whatsapp != "" {
+		bridge, err := newWhatsappBridge(cfg, agent)
+		if err == nil {
+			err = serveWhatsapp(cfg.Whatsapp, bridge)
+		}
+		fmt.Fprintf(notices, "❌ WhatsApp: %v\n", err)
+		os.Exit(1)
+	}
+	if cfg.Wechat != "" {
+		bridge, err := newWechatBridge(cfg, agent)
+		if err == nil {
+			err = serveWechat(cfg.Wechat, bridge)
+		}
+		fmt.Fprintf(notices, "❌ WeChat: %v\n", err)
+		os.Exit(1)
+	}
+	if cfg.Email != "" {
+		bridge, err := newEmailBridge(cfg, agent)
+		if err == nil {
+			err = bridge.poll(cfg.EmailPoll)
+		}
+		fmt.Fprintf(notices, "❌ Email: %v\n", err)
+		os.Exit(1)
+	}
+
+	if cfg.Voice {
+		if cfg.TUI {
+			fmt.Fprintln(notices, "💡 The full-screen UI is not available with --voice; using the line-based chat")
+		}
+		input, err := newVoiceReader(cfg)
+		if err != nil {
+			fmt.Fprintf(notices, "❌ Cannot set up voice input: %v\n", err)
+			os.Exit(1)
+		}
+		agent.chat(input)
+		return
+	}
+
+	if cfg.TUI && (cfg.Accessible || agent.jsonOutput) {
+		fmt.Fprintln(notices, "💡 The full-screen UI is not available with --accessible or --output=json; using the line-based chat")
+	} else if cfg.TUI {
+		if err := runTUI(agent, cfg.Plain); err != nil {
+			fmt.Fprintf(notices, "❌ %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	input, err := newLineReader(cfg)
 	if err != nil {
-		fmt.Printf("❌ Cannot connect to booking server at %s\n", serverURL)
-		fmt.Println("💡 Make sure to start the server with: go run server.go")
+		fmt.Fprintf(notices, "❌ Cannot set up input: %v\n", err)
 		os.Exit(1)
 	}
-	resp.Body.Close()
+	defer input.Close()
 
-	agent.chat()
+	agent.chat(input)
 }
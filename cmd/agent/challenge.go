@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Verification challenges: a provider that holds back a user for booking too
+// fast may ask a question before the booking goes ahead, such as a sum or a
+// one-time code it sent them. The agent puts the question to the user and
+// books again with the reply, asking again if the provider turns it down.
+
+// challengeQuestion is the challenge a held-back booking waits on
+type challengeQuestion struct {
+	id     string
+	prompt string
+}
+
+// askChallenge holds the booking for the challenge err poses, and asks it;
+// ok is false when err poses none
+func (a *BookingAgent) askChallenge(err error) (question string, ok bool) {
+	var refused *ProviderError
+	if !errors.As(err, &refused) || refused.Challenge == nil {
+		return "", false
+	}
+	a.pendingChallenge = &challengeQuestion{id: refused.Challenge.ID, prompt: refused.Challenge.Prompt}
+	message := strings.ToUpper(refused.Message[:1]) + refused.Message[1:]
+	return fmt.Sprintf("🤔 %s, so nothing was booked yet. %s Say cancel to stop.", message, refused.Challenge.Prompt), true
+}
+
+// How a reply to a challenge may start, e.g. "it's 33"
+var challengeReplyPrefix = regexp.MustCompile(`(?i)^(?:it'?s|it is|the answer is|answer:?|the code is|code:?)\s+`)
+
+// answerChallenge books again with the reply to askChallenge. Anything but a
+// single answer is a new request, so ok is false and the message goes to
+// DeepSeek as usual.
+func (a *BookingAgent) answerChallenge(ctx context.Context, pending *IntentResponse, answer string) (reply string, ok bool) {
+	q := a.pendingChallenge
+	a.pendingChallenge = nil
+	if yes, ok := yesOrNo(answer); ok && !yes {
+		return a.answerConfirmation(ctx, pending, answer)
+	}
+	words := strings.Fields(challengeReplyPrefix.ReplaceAllString(strings.Trim(strings.TrimSpace(answer), ".!"), ""))
+	if len(words) != 1 {
+		return "", false
+	}
+	a.challengeAnswer = &ChallengeAnswer{ID: q.id, Answer: strings.Trim(words[0], `"'`)}
+	return a.runAnswered(ctx, pending, answer), true
+}
+
+// takeChallengeAnswer is the answer for the next booking to carry, once
+func (a *BookingAgent) takeChallengeAnswer() *ChallengeAnswer {
+	answer := a.challengeAnswer
+	a.challengeAnswer = nil
+	return answer
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Messaging channels: the agent can serve travelers over a messaging
+// platform instead of the terminal. Each channel user gets a conversation
+// of their own, started with the agent's configuration and dropped after
+// an idle spell. Each user's turns are handled one at a time, while other
+// users' conversations go on meanwhile. A channel user acts for the user
+// the channel maps them to, whoever their messages name.
+
+// A conversation left this long starts afresh
+const sessionIdle = 30 * time.Minute
+
+// Characters a user ID may not carry, replaced when one is made from a
+// channel's own ID
+var userIDUnsafe = regexp.MustCompile(`[^A-Za-z0-9._@-]+`)
+
+// channelUserID makes a booking user ID from a channel's ID for someone,
+// e.g. "wa-8613800138000" for a WhatsApp number
+func channelUserID(prefix, id string) string {
+	id = userIDUnsafe.ReplaceAllString(id, "")
+	if len(prefix)+1+len(id) > 64 {
+		id = id[len(id)-(63-len(prefix)):]
+	}
+	return prefix + "-" + id
+}
+
+// session starts a new conversation for userID with a's configuration,
+// sharing its provider, caches and prompts
+func (a *BookingAgent) session(userID string) *BookingAgent {
+	s := NewBookingAgent(a.apiKey, a.serverURL)
+	s.client, s.recorder, s.provider = a.client, a.recorder, a.provider
+	if a.weather != nil {
+		s.enableWeather(a.weather)
+	}
+	s.offline = a.offline
+	s.intents = a.intents
+	s.prompts = a.prompts
+	s.experiment = a.experiment
+	s.userID = userID
+	s.sessionID = newSessionID()
+	s.confirmBelow = a.confirmBelow
+	s.duplicateWindow = a.duplicateWindow
+	s.accessible = a.accessible
+	s.dryRun = a.dryRun
+	s.turnTimeout = a.turnTimeout
+	s.llmTimeout = a.llmTimeout
+	s.memoryTokens = a.memoryTokens
+	s.llm = a.llm
+	s.llmRepairs = a.llmRepairs
+	s.pinned = true
+	return s
+}
+
+// sessionPool keeps one conversation per channel user
+type sessionPool struct {
+	mu       sync.Mutex // guards sessions
+	template *BookingAgent
+	sessions map[string]*channelSession
+	idle     time.Duration // conversations left this long start afresh
+}
+
+type channelSession struct {
+	mu       sync.Mutex // held for a turn, so a user's messages are answered in order
+	agent    *BookingAgent
+	lastSeen time.Time
+}
+
+// channelTurn is the outcome of one message
+type channelTurn struct {
+	Reply string
+	// Booking references made by the message, empty unless it booked
+	BookingRefs []string
+}
+
+func newSessionPool(template *BookingAgent) *sessionPool {
+	return &sessionPool{template: template, sessions: map[string]*channelSession{}, idle: sessionIdle}
+}
+
+// reply handles text sent by the channel user key, booking as userID;
+// "quit" ends their conversation
+func (p *sessionPool) reply(key, userID, text string) channelTurn {
+	text = strings.TrimSpace(text)
+	s, ok := p.sessionOf(key, userID, strings.EqualFold(text, "quit"))
+	if !ok {
+		return channelTurn{Reply: "👋 Goodbye! Send another message to start again."}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a := s.agent
+	refs := a.lastBookingRefs
+	result, err := a.respond(context.Background(), text)
+	if err != nil {
+		return channelTurn{Reply: a.turnError(err)}
+	}
+	turn := channelTurn{Reply: result}
+	if len(a.lastBookingRefs) > 0 && !sameStrings(a.lastBookingRefs, refs) {
+		turn.BookingRefs = a.lastBookingRefs
+	}
+	return turn
+}
+
+// sessionOf returns the conversation of the channel user key, started for
+// userID if they have none; quit ends it instead
+func (p *sessionPool) sessionOf(key, userID string, quit bool) (*channelSession, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	for k, s := range p.sessions {
+		if now.Sub(s.lastSeen) > p.idle {
+			delete(p.sessions, k)
+		}
+	}
+	if quit {
+		delete(p.sessions, key)
+		return nil, false
+	}
+	s, ok := p.sessions[key]
+	if !ok {
+		s = &channelSession{agent: p.template.session(userID)}
+		p.sessions[key] = s
+		logger.Info("channel session started", "user", userID, "conversation", s.agent.sessionID)
+	}
+	s.lastSeen = now
+	return s, true
+}
+
+// loadChannelUsers reads a JSON object mapping a channel's IDs for people,
+// such as phone numbers, to user IDs
+func loadChannelUsers(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	users := map[string]string{}
+	if err := json.Unmarshal(data, &users); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for id, user := range users {
+		if err := validateUserID(user); err != nil {
+			return nil, fmt.Errorf("%s: user of %s: %v", path, id, err)
+		}
+	}
+	return users, nil
+}
+
+func sameStrings(a, b []string) bool {
+	return strings.Join(a, ",") == strings.Join(b, ",")
+}
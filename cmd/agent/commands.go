@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// In-chat meta-commands. They start with ":" (or "/save") and are handled by the
+// agent itself, never sent to DeepSeek.
+
+const metaHelp = `Commands:
+  :history        show the conversation so far
+  :clear          forget the conversation history
+  :user [id]      show or switch the active user ID
+  /switch user ID hand the terminal to another user (asks first)
+  :server [url]   show or change the booking server URL
+  :stats          show session statistics
+  /save [file]    save the transcript (Markdown, or JSON for .json)
+  :help           show this list`
+
+// metaCommand runs line if it is a meta-command and reports whether it was one
+func (a *BookingAgent) metaCommand(line string) (string, bool) {
+	if a.pendingUser != "" {
+		return a.confirmSwitch(line), true
+	}
+	if arg, ok := strings.CutPrefix(line, "/switch"); ok {
+		return a.requestSwitch(arg), true
+	}
+	if arg, ok := strings.CutPrefix(line, "/save"); ok {
+		return a.saveCommand(arg), true
+	}
+	if !strings.HasPrefix(line, ":") {
+		return "", false
+	}
+
+	name, arg, _ := strings.Cut(strings.TrimPrefix(line, ":"), " ")
+	arg = strings.TrimSpace(arg)
+	switch strings.ToLower(name) {
+	case "history":
+		return a.showHistory(), true
+	case "clear":
+		a.forgetConversation()
+		a.lastResults, a.focusTrain = nil, ""
+		a.pendingAction = nil
+		return "✅ Conversation history cleared", true
+	case "user":
+		if arg == "" {
+			return fmt.Sprintf("👤 Active user: %s", a.userID), true
+		}
+		if err := validateUserID(arg); err != nil {
+			return fmt.Sprintf("❌ Invalid user ID: %v", err), true
+		}
+		a.userID = arg
+		return fmt.Sprintf("✅ Active user is now %s", a.userID), true
+	case "server":
+		if arg == "" {
+			return fmt.Sprintf("🌐 Booking server: %s", a.serverURL), true
+		}
+		return a.switchServer(arg), true
+	case "stats":
+		return a.stats(), true
+	case "help", "?":
+		return metaHelp, true
+	}
+	return fmt.Sprintf("❌ Unknown command :%s (try :help)", name), true
+}
+
+// prompt shows whose identity the next message is sent under
+func (a *BookingAgent) prompt() string {
+	return fmt.Sprintf("You (%s): ", a.userID)
+}
+
+// requestSwitch handles "/switch user 4343"; the switch waits for a yes
+func (a *BookingAgent) requestSwitch(arg string) string {
+	fields := strings.Fields(arg)
+	if len(fields) != 2 || strings.ToLower(fields[0]) != "user" {
+		return "❌ Usage: /switch user <id>"
+	}
+	if err := validateUserID(fields[1]); err != nil {
+		return fmt.Sprintf("❌ Invalid user ID: %v", err)
+	}
+	if fields[1] == a.userID {
+		return fmt.Sprintf("👤 Already booking as %s", a.userID)
+	}
+	a.pendingUser = fields[1]
+	return fmt.Sprintf("🤔 Switch from %s to %s? The conversation so far will be cleared. (yes/no)", a.userID, a.pendingUser)
+}
+
+func (a *BookingAgent) confirmSwitch(answer string) string {
+	user := a.pendingUser
+	a.pendingUser = ""
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+	default:
+		return fmt.Sprintf("👤 Still booking as %s", a.userID)
+	}
+
+	// The next person should not see or build on the previous person's requests
+	a.userID = user
+	a.forgetConversation()
+	a.lastResults, a.focusTrain = nil, ""
+	a.pendingAction = nil
+	return fmt.Sprintf("✅ Now booking as %s", a.userID)
+}
+
+func (a *BookingAgent) showHistory() string {
+	if len(a.conversationHistory) == 0 && len(a.summary) == 0 {
+		return "📋 No conversation yet."
+	}
+	var b strings.Builder
+	b.WriteString("📋 Conversation history:")
+	if len(a.summary) > 0 {
+		fmt.Fprintf(&b, "\n(%d earlier turns summarized)", len(a.summary))
+	}
+	for i, msg := range a.conversationHistory {
+		who := "You"
+		if msg.Role == "assistant" {
+			who = "Agent"
+		}
+		fmt.Fprintf(&b, "\n%d. %s: %s", i+1, who, strings.ReplaceAll(strings.TrimSpace(msg.Content), "\n", "\n   "))
+	}
+	return b.String()
+}
+
+// switchServer points the agent at another booking server if it answers
+func (a *BookingAgent) switchServer(raw string) string {
+	endpoints, err := parseServerURLs(raw)
+	if err != nil {
+		return fmt.Sprintf("❌ Invalid server URL: %v", err)
+	}
+	serverURL := formatServerURLs(endpoints)
+
+	provider, err := openProvider(a.provider.Name(), endpoints, a.client)
+	if err != nil {
+		return fmt.Sprintf("❌ Cannot use %s: %v", serverURL, err)
+	}
+	_, err = provider.Search(withConversation(context.Background(), a.sessionID), SearchQuery{})
+	a.recorder.take(a.sessionID)
+	if err != nil {
+		return fmt.Sprintf("❌ Cannot connect to booking server at %s", serverURL)
+	}
+
+	a.provider = provider
+	a.serverURL = serverURL
+	a.lastResults, a.focusTrain = nil, ""
+	return fmt.Sprintf("✅ Now using booking server %s", a.serverURL)
+}
+
+func (a *BookingAgent) stats() string {
+	intents := map[string]int{}
+	calls, failures := 0, 0
+	for _, turn := range a.transcript {
+		if turn.Intent != "" {
+			intents[turn.Intent]++
+		}
+		calls += len(turn.Calls)
+		if turn.Error != "" || outcome(turn.Reply) != exitOK {
+			failures++
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "📊 Session statistics:\n")
+	fmt.Fprintf(&b, "• Running for %s as %s against %s\n", time.Since(a.started).Round(time.Second), a.userID, a.serverURL)
+	fmt.Fprintf(&b, "• %s, %d unsuccessful\n", countNoun(len(a.transcript), "message", "messages"), failures)
+	fmt.Fprintf(&b, "• %s to the booking server\n", countNoun(calls, "call", "calls"))
+	if a.intents != nil && a.intents.hits > 0 {
+		fmt.Fprintf(&b, "• %s answered from the intent cache\n", countNoun(a.intents.hits, "message", "messages"))
+	}
+	for _, name := range append(a.tools.names(), "unknown") {
+		if intents[name] > 0 {
+			fmt.Fprintf(&b, "• %s: %d\n", name, intents[name])
+		}
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zhangbiao2009/train-booking/internal/plain"
+)
+
+// Agent configuration: command-line flags, defaulting to TRAIN_AGENT_* environment variables
+type Config struct {
+	APIKeyFile string
+
+	// Canned, rule-driven replies instead of DeepSeek, optionally scripted from a file
+	Offline       bool
+	OfflineScript string
+
+	// Booking servers, comma-separated, and the user to book for unless a message names another
+	ServerURL string
+	User      string
+
+	// Run the booking server in this process with demo data instead of using ServerURL
+	EmbeddedServer bool
+
+	// Rail operator adapter serving ServerURL; "local" is the booking server
+	Provider string
+
+	// Diagnostics such as raw LLM responses and request URLs; off by default
+	Debug     bool
+	DebugFile string // empty writes diagnostics to stderr
+
+	// Structured log file, rotated by size; "off" disables it
+	LogFile    string
+	LogMaxMB   int
+	LogBackups int
+
+	// ASCII output without emoji; also enabled by NO_COLOR or a non-UTF-8 locale
+	Plain bool
+
+	// Screen-reader friendly output: plain text, spoken-style results, short lines
+	Accessible bool
+
+	// "text" for prose replies, "json" for one JSON object per turn
+	Output string
+
+	// Deadlines for one message from input to reply, one DeepSeek call and one
+	// booking server call
+	TurnTimeout   time.Duration
+	LLMTimeout    time.Duration
+	ServerTimeout time.Duration
+
+	// Bookings and cancellations understood with less confidence than this are
+	// restated for a yes/no first; 0 disables
+	ConfirmBelow float64
+
+	// The same booking asked for again within this window is questioned, or
+	// retried without double-booking if the first attempt got no answer; 0 disables
+	DuplicateWindow time.Duration
+
+	// Model and sampling of LLM calls; an empty model or a negative number
+	// keeps the provider's default
+	LLMModel       string
+	LLMTemperature float64
+	LLMTopP        float64
+	LLMMaxTokens   int
+
+	// Models tried in turn when one fails or times out, e.g.
+	// "deepseek-chat,openai:gpt-4o-mini"; empty for --llm-model alone
+	LLMChain string
+
+	// Times a reply that is not valid JSON is sent back to the model to fix
+	LLMRepairs int
+
+	// Tokens of conversation history, with a summary of older turns, sent
+	// to DeepSeek; 0 for no limit
+	MemoryTokens int
+
+	// Directory with system.tmpl, apis.txt or examples.txt replacing the built-in prompt files
+	PromptDir string
+
+	// Prompt A/B experiment: name=dir[:weight],... and where its counters are kept
+	PromptVariants   string
+	ExperimentFile   string
+	ExperimentReport bool
+
+	// Intents of repeated messages, kept across sessions; "off" keeps them for the session only
+	IntentCache string
+
+	// Weather provider for the weather tool and booking warnings: off, mock or open-meteo
+	Weather string
+
+	// Show the booking server calls for each message without making them
+	DryRun bool
+
+	// Run this one message and exit instead of starting a conversation
+	Command string
+
+	// Run one message per line of this file ("-" for stdin) and exit
+	Batch string
+
+	// Full-screen terminal UI instead of the line-based chat
+	TUI bool
+
+	// Keep the conversation in this file, Markdown or JSON by extension
+	Transcript string
+
+	// Input history for line editing; "off" keeps history in memory only
+	HistoryFile string
+
+	// Spoken input: each message is recorded by RecordCommand and transcribed
+	// by the STT provider, "whisper" or "command" (STTCommand)
+	Voice         bool
+	STT           string
+	STTCommand    string
+	RecordCommand string
+
+	// Spoken replies: "off", "openai" (played by PlayCommand) or "command"
+	// (TTSCommand), optionally as SSML; implies Accessible
+	TTS         string
+	TTSCommand  string
+	PlayCommand string
+	TTSSSML     bool
+
+	// Serve WhatsApp users through a Cloud API webhook at this address
+	// instead of the terminal; bookings are confirmed with WhatsappTemplate
+	// when set, and WhatsappUsers maps phone numbers to user IDs
+	Whatsapp         string
+	WhatsappTemplate string
+	WhatsappUsers    string
+
+	// Serve WeChat official account followers through a message handler at
+	// this address instead of the terminal; WechatUsers maps OpenIDs to
+	// user IDs
+	Wechat      string
+	WechatUsers string
+
+	// Answer mail in the IMAP inbox Email every EmailPoll instead of the
+	// terminal, replying through EmailSMTP; EmailUsers maps addresses to
+	// user IDs
+	Email      string
+	EmailSMTP  string
+	EmailPoll  time.Duration
+	EmailUsers string
+}
+
+func loadConfig() Config {
+	var cfg Config
+	flag.StringVar(&cfg.APIKeyFile, "api-key-file", "", "read the DeepSeek API key from this file (must not be readable by other users)")
+	flag.BoolVar(&cfg.Offline, "offline", envBool("TRAIN_AGENT_OFFLINE", false), "demo mode: a canned LLM instead of DeepSeek, no API key needed (env TRAIN_AGENT_OFFLINE)")
+	flag.StringVar(&cfg.OfflineScript, "offline-script", envOr("TRAIN_AGENT_OFFLINE_SCRIPT", ""), "raw model replies to give in order in offline mode, one per line; implies --offline (env TRAIN_AGENT_OFFLINE_SCRIPT)")
+	flag.StringVar(&cfg.ServerURL, "server-url", envOr("TRAIN_AGENT_SERVER_URL", "http://localhost:8080"), "booking server base URL; several comma-separated, optionally name=URL, to search them all (env TRAIN_AGENT_SERVER_URL)")
+	flag.BoolVar(&cfg.EmbeddedServer, "embedded-server", envBool("TRAIN_AGENT_EMBEDDED_SERVER", false), "start the booking server in this process on a random port with demo data, instead of --server-url (env TRAIN_AGENT_EMBEDDED_SERVER)")
+	flag.StringVar(&cfg.Provider, "provider", envOr("TRAIN_AGENT_PROVIDER", "local"), `rail operator adapter for --server-url, "local" for the booking server (env TRAIN_AGENT_PROVIDER)`)
+	flag.StringVar(&cfg.User, "user", envOr("TRAIN_AGENT_USER", "user_001"), "default user ID for bookings (env TRAIN_AGENT_USER)")
+	flag.BoolVar(&cfg.Debug, "debug", envBool("TRAIN_AGENT_DEBUG", false), "print diagnostics (env TRAIN_AGENT_DEBUG)")
+	flag.StringVar(&cfg.DebugFile, "debug-file", envOr("TRAIN_AGENT_DEBUG_FILE", ""), "append diagnostics to this file instead of stderr (env TRAIN_AGENT_DEBUG_FILE)")
+	flag.StringVar(&cfg.LogFile, "log-file", envOr("TRAIN_AGENT_LOG_FILE", defaultLogFile()), `structured log file, or "off" (env TRAIN_AGENT_LOG_FILE)`)
+	flag.IntVar(&cfg.LogMaxMB, "log-max-mb", envInt("TRAIN_AGENT_LOG_MAX_MB", 10), "rotate the log file at this size (env TRAIN_AGENT_LOG_MAX_MB)")
+	flag.IntVar(&cfg.LogBackups, "log-backups", envInt("TRAIN_AGENT_LOG_BACKUPS", 3), "rotated log files to keep (env TRAIN_AGENT_LOG_BACKUPS)")
+	flag.BoolVar(&cfg.Plain, "plain", envBool("TRAIN_AGENT_PLAIN", false), "plain ASCII output without emoji (env TRAIN_AGENT_PLAIN)")
+	flag.BoolVar(&cfg.Accessible, "accessible", envBool("TRAIN_AGENT_ACCESSIBLE", false), "screen-reader friendly output (env TRAIN_AGENT_ACCESSIBLE)")
+	flag.StringVar(&cfg.Output, "output", envOr("TRAIN_AGENT_OUTPUT", "text"), `reply format: "text" or "json" (env TRAIN_AGENT_OUTPUT)`)
+	flag.DurationVar(&cfg.TurnTimeout, "turn-timeout", envDuration("TRAIN_AGENT_TURN_TIMEOUT", 60*time.Second), "give up on a message after this long, 0 for no limit (env TRAIN_AGENT_TURN_TIMEOUT)")
+	flag.DurationVar(&cfg.LLMTimeout, "llm-timeout", envDuration("TRAIN_AGENT_LLM_TIMEOUT", 30*time.Second), "give up on a DeepSeek call after this long, 0 for no limit (env TRAIN_AGENT_LLM_TIMEOUT)")
+	flag.DurationVar(&cfg.ServerTimeout, "server-timeout", envDuration("TRAIN_AGENT_SERVER_TIMEOUT", 10*time.Second), "give up on a booking server call after this long, 0 for no limit (env TRAIN_AGENT_SERVER_TIMEOUT)")
+	flag.Float64Var(&cfg.ConfirmBelow, "confirm-below", envFloat("TRAIN_AGENT_CONFIRM_BELOW", 0.7), "ask before booking or cancelling when intent confidence is below this, 0 to never ask (env TRAIN_AGENT_CONFIRM_BELOW)")
+	flag.DurationVar(&cfg.DuplicateWindow, "duplicate-window", envDuration("TRAIN_AGENT_DUPLICATE_WINDOW", 2*time.Minute), "ask before repeating a booking made this recently, 0 to never ask (env TRAIN_AGENT_DUPLICATE_WINDOW)")
+	flag.StringVar(&cfg.LLMModel, "llm-model", envOr("TRAIN_AGENT_LLM_MODEL", ""), "model for reading messages, e.g. deepseek-reasoner; the provider's default when empty (env TRAIN_AGENT_LLM_MODEL)")
+	flag.Float64Var(&cfg.LLMTemperature, "llm-temperature", envFloat("TRAIN_AGENT_LLM_TEMPERATURE", -1), "sampling temperature, 0 to 2; negative for the provider's default (env TRAIN_AGENT_LLM_TEMPERATURE)")
+	flag.Float64Var(&cfg.LLMTopP, "llm-top-p", envFloat("TRAIN_AGENT_LLM_TOP_P", -1), "nucleus sampling top_p, 0 to 1 (0 for the API's default); negative for the provider's default (env TRAIN_AGENT_LLM_TOP_P)")
+	flag.IntVar(&cfg.LLMMaxTokens, "llm-max-tokens", envInt("TRAIN_AGENT_LLM_MAX_TOKENS", -1), "longest LLM reply in tokens (0 for the API's default); negative for the provider's default (env TRAIN_AGENT_LLM_MAX_TOKENS)")
+	flag.StringVar(&cfg.LLMChain, "llm-chain", envOr("TRAIN_AGENT_LLM_CHAIN", ""), `models tried in turn when one fails or times out, e.g. "deepseek-chat,openai:gpt-4o-mini" (env TRAIN_AGENT_LLM_CHAIN)`)
+	flag.IntVar(&cfg.LLMRepairs, "llm-repairs", envInt("TRAIN_AGENT_LLM_REPAIRS", defaultLLMRepairs), "times to ask the model again when its reply is not valid JSON, 0 to give up at once (env TRAIN_AGENT_LLM_REPAIRS)")
+	flag.IntVar(&cfg.MemoryTokens, "memory-tokens", envInt("TRAIN_AGENT_MEMORY_TOKENS", defaultMemoryTokens), "tokens of conversation history sent to DeepSeek, older turns summarized, 0 for no limit (env TRAIN_AGENT_MEMORY_TOKENS)")
+	flag.StringVar(&cfg.PromptDir, "prompt-dir", envOr("TRAIN_AGENT_PROMPT_DIR", ""), "directory overriding the built-in prompt files (env TRAIN_AGENT_PROMPT_DIR)")
+	flag.StringVar(&cfg.PromptVariants, "prompt-variants", envOr("TRAIN_AGENT_PROMPT_VARIANTS", ""), `prompt experiment, e.g. "a=builtin,b=./prompts-b:3" (env TRAIN_AGENT_PROMPT_VARIANTS)`)
+	flag.StringVar(&cfg.ExperimentFile, "experiment-file", envOr("TRAIN_AGENT_EXPERIMENT_FILE", defaultExperimentFile()), "per-variant experiment counters (env TRAIN_AGENT_EXPERIMENT_FILE)")
+	flag.BoolVar(&cfg.ExperimentReport, "experiment-report", false, "print the per-variant experiment results and exit")
+	flag.StringVar(&cfg.IntentCache, "intent-cache", envOr("TRAIN_AGENT_INTENT_CACHE", defaultIntentCacheFile()), `file of intents reused for repeated messages, or "off" (env TRAIN_AGENT_INTENT_CACHE)`)
+	flag.StringVar(&cfg.Weather, "weather", envOr("TRAIN_AGENT_WEATHER", "off"), `weather at the destination: "off", "mock" or "open-meteo" (env TRAIN_AGENT_WEATHER)`)
+	flag.BoolVar(&cfg.DryRun, "dry-run", envBool("TRAIN_AGENT_DRY_RUN", false), "show the booking server calls each message would make without making them (env TRAIN_AGENT_DRY_RUN)")
+	flag.StringVar(&cfg.Command, "c", "", `run a single message, e.g. -c "book G100 for user 4343", print the reply and exit`)
+	flag.StringVar(&cfg.Batch, "batch", "", `run one message per line of this file, or "-" for stdin, and exit`)
+	flag.BoolVar(&cfg.TUI, "tui", envBool("TRAIN_AGENT_TUI", false), "full-screen terminal UI (env TRAIN_AGENT_TUI)")
+	flag.StringVar(&cfg.Transcript, "transcript", envOr("TRAIN_AGENT_TRANSCRIPT", ""), "write the conversation to this .md or .json file after every turn (env TRAIN_AGENT_TRANSCRIPT)")
+	flag.StringVar(&cfg.HistoryFile, "history-file", envOr("TRAIN_AGENT_HISTORY_FILE", defaultHistoryFile()), `input history file, or "off" (env TRAIN_AGENT_HISTORY_FILE)`)
+	flag.BoolVar(&cfg.Voice, "voice", envBool("TRAIN_AGENT_VOICE", false), "listen to the microphone instead of reading the keyboard (env TRAIN_AGENT_VOICE)")
+	flag.StringVar(&cfg.STT, "stt", envOr("TRAIN_AGENT_STT", "whisper"), `speech-to-text for --voice: "whisper" (OpenAI API) or "command" (env TRAIN_AGENT_STT)`)
+	flag.StringVar(&cfg.STTCommand, "stt-command", envOr("TRAIN_AGENT_STT_COMMAND", ""), "local transcriber for --stt=command, {file} replaced by the WAV recording, printing the text (env TRAIN_AGENT_STT_COMMAND)")
+	flag.StringVar(&cfg.RecordCommand, "record-command", envOr("TRAIN_AGENT_RECORD_COMMAND", defaultRecordCommand), "records one spoken message into {file} as WAV for --voice (env TRAIN_AGENT_RECORD_COMMAND)")
+	flag.StringVar(&cfg.TTS, "tts", envOr("TRAIN_AGENT_TTS", "off"), `read replies aloud: "off", "openai" or "command"; implies --accessible (env TRAIN_AGENT_TTS)`)
+	flag.StringVar(&cfg.TTSCommand, "tts-command", envOr("TRAIN_AGENT_TTS_COMMAND", ""), "local speech engine for --tts=command, given the text on stdin (env TRAIN_AGENT_TTS_COMMAND)")
+	flag.StringVar(&cfg.PlayCommand, "play-command", envOr("TRAIN_AGENT_PLAY_COMMAND", defaultPlayCommand), "plays the WAV audio in {file} for --tts=openai (env TRAIN_AGENT_PLAY_COMMAND)")
+	flag.BoolVar(&cfg.TTSSSML, "tts-ssml", envBool("TRAIN_AGENT_TTS_SSML", false), "give the speech engine SSML rather than plain text (env TRAIN_AGENT_TTS_SSML)")
+	flag.StringVar(&cfg.Whatsapp, "whatsapp", envOr("TRAIN_AGENT_WHATSAPP", ""), "serve WhatsApp Business messages through a webhook at this address, e.g. :8090 (env TRAIN_AGENT_WHATSAPP)")
+	flag.StringVar(&cfg.WhatsappTemplate, "whatsapp-template", envOr("TRAIN_AGENT_WHATSAPP_TEMPLATE", ""), "approved message template confirming bookings, given the booking reference (env TRAIN_AGENT_WHATSAPP_TEMPLATE)")
+	flag.StringVar(&cfg.WhatsappUsers, "whatsapp-users", envOr("TRAIN_AGENT_WHATSAPP_USERS", ""), "JSON file mapping phone numbers to user IDs; others book as wa-<number> (env TRAIN_AGENT_WHATSAPP_USERS)")
+	flag.StringVar(&cfg.Wechat, "wechat", envOr("TRAIN_AGENT_WECHAT", ""), "serve a WeChat official account through a message handler at this address, e.g. :8092 (env TRAIN_AGENT_WECHAT)")
+	flag.StringVar(&cfg.WechatUsers, "wechat-users", envOr("TRAIN_AGENT_WECHAT_USERS", ""), "JSON file mapping OpenIDs to user IDs; others book as wx-<OpenID> (env TRAIN_AGENT_WECHAT_USERS)")
+	flag.StringVar(&cfg.Email, "email", envOr("TRAIN_AGENT_EMAIL", ""), "answer booking requests mailed to this IMAP inbox, e.g. imaps://imap.example.com (env TRAIN_AGENT_EMAIL)")
+	flag.StringVar(&cfg.EmailSMTP, "email-smtp", envOr("TRAIN_AGENT_EMAIL_SMTP", ""), "mail server for --email replies, e.g. smtp://smtp.example.com:587 or smtps://smtp.example.com (env TRAIN_AGENT_EMAIL_SMTP)")
+	flag.DurationVar(&cfg.EmailPoll, "email-poll", envDuration("TRAIN_AGENT_EMAIL_POLL", time.Minute), "how often --email checks for new mail (env TRAIN_AGENT_EMAIL_POLL)")
+	flag.StringVar(&cfg.EmailUsers, "email-users", envOr("TRAIN_AGENT_EMAIL_USERS", ""), "JSON file mapping email addresses to user IDs; others book as mail-<address> (env TRAIN_AGENT_EMAIL_USERS)")
+	flag.Parse()
+
+	if cfg.Output != "text" && cfg.Output != "json" {
+		fmt.Fprintf(os.Stderr, "invalid --output %q: want text or json\n", cfg.Output)
+		os.Exit(2)
+	}
+	if _, ok := providers[cfg.Provider]; !ok {
+		fmt.Fprintf(os.Stderr, "invalid --provider %q: want %s\n", cfg.Provider, strings.Join(providerNames(), " or "))
+		os.Exit(2)
+	}
+	if _, err := newWeatherProvider(cfg.Weather); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid --weather: %v\n", err)
+		os.Exit(2)
+	}
+	if _, err := newSpeechToText(cfg.STT, cfg.STTCommand); cfg.Voice && err != nil {
+		fmt.Fprintf(os.Stderr, "invalid --stt: %v\n", err)
+		os.Exit(2)
+	}
+	if _, err := newTextToSpeech(cfg.TTS, cfg.TTSCommand, cfg.PlayCommand); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid --tts: %v\n", err)
+		os.Exit(2)
+	}
+	if err := validateLLMSettings(cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if cfg.LLMRepairs < 0 {
+		fmt.Fprintf(os.Stderr, "invalid --llm-repairs %d: must not be negative\n", cfg.LLMRepairs)
+		os.Exit(2)
+	}
+	if cfg.MemoryTokens != 0 && cfg.MemoryTokens < 200 {
+		fmt.Fprintf(os.Stderr, "invalid --memory-tokens %d: must be at least 200, or 0 for no limit\n", cfg.MemoryTokens)
+		os.Exit(2)
+	}
+	if cfg.EmailPoll <= 0 {
+		fmt.Fprintf(os.Stderr, "invalid --email-poll %s: must be positive\n", cfg.EmailPoll)
+		os.Exit(2)
+	}
+	if cfg.EmbeddedServer && cfg.Provider != "local" {
+		fmt.Fprintf(os.Stderr, "invalid --provider %q: --embedded-server runs the local booking server\n", cfg.Provider)
+		os.Exit(2)
+	}
+	endpoints, err := parseServerURLs(cfg.ServerURL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid --server-url: %v\n", err)
+		os.Exit(2)
+	}
+	cfg.ServerURL = formatServerURLs(endpoints)
+	if err := validateUserID(cfg.User); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid --user: %v\n", err)
+		os.Exit(2)
+	}
+
+	cfg.Offline = cfg.Offline || cfg.OfflineScript != ""
+	cfg.Accessible = cfg.Accessible || cfg.TTS != "off"
+	cfg.Plain = cfg.Plain || cfg.Accessible || plain.Detect()
+	return cfg
+}
+
+// Console output; converted to ASCII in plain mode
+var out io.Writer = os.Stdout
+
+// Startup and error messages; on stderr for -c, --batch and JSON output so stdout carries only the replies
+var notices io.Writer = os.Stdout
+
+func setupOutput(cfg Config) {
+	if cfg.Plain {
+		out = plain.Writer{W: os.Stdout}
+		spinnerFrames = []string{"|", "/", "-", "\\"}
+	}
+	notices = out
+	if cfg.Command != "" || cfg.Batch != "" || cfg.Output == "json" {
+		notices = os.Stderr
+		if cfg.Plain {
+			notices = plain.Writer{W: os.Stderr}
+		}
+	}
+}
+
+// parseServerURL checks a booking server base URL and drops any trailing slash
+func parseServerURL(raw string) (string, error) {
+	u, err := url.Parse(strings.TrimRight(strings.TrimSpace(raw), "/"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("%q is not an http(s) URL such as http://localhost:8080", raw)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("%q must not have a query or fragment", raw)
+	}
+	return u.String(), nil
+}
+
+// User IDs end up in query strings, so they are kept to a URL-safe alphabet
+var userIDPattern = regexp.MustCompile(`^[A-Za-z0-9._@-]{1,64}$`)
+
+func validateUserID(id string) error {
+	if !userIDPattern.MatchString(id) {
+		return fmt.Errorf("%q must be 1-64 letters, digits or . _ @ -", id)
+	}
+	return nil
+}
+
+func envOr(key, fallback string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func envBool(key string, fallback bool) bool {
+	v, err := strconv.ParseBool(envOr(key, ""))
+	if err != nil {
+		return fallback
+	}
+	return v
+}
+
+func envInt(key string, fallback int) int {
+	v, err := strconv.Atoi(envOr(key, ""))
+	if err != nil {
+		return fallback
+	}
+	return v
+}
+
+func envFloat(key string, fallback float64) float64 {
+	v, err := strconv.ParseFloat(envOr(key, ""), 64)
+	if err != nil {
+		return fallback
+	}
+	return v
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	v, err := time.ParseDuration(envOr(key, ""))
+	if err != nil {
+		return fallback
+	}
+	return v
+}
+
+// Diagnostics never go to stdout, so they cannot end up in the chat transcript
+var debugLog = log.New(io.Discard, "", 0)
+
+func setupDebugLog(cfg Config) error {
+	if !cfg.Debug {
+		return nil
+	}
+	if cfg.DebugFile == "" {
+		var stderr io.Writer = os.Stderr
+		if cfg.Plain {
+			stderr = plain.Writer{W: os.Stderr}
+		}
+		debugLog = log.New(stderr, "🔍 ", log.Ltime)
+		return nil
+	}
+
+	f, err := os.OpenFile(cfg.DebugFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	debugLog = log.New(f, "", log.LstdFlags)
+	return nil
+}
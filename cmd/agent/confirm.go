@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Train IDs look like G100: a letter followed by digits
+var trainIDPattern = regexp.MustCompile(`^[A-Z][0-9]+$`)
+
+// confidence is the model's own score, lowered when the parameters do not look
+// like what the action needs. A model that gives no score is trusted.
+func confidence(intent *IntentResponse) float64 {
+	score := 1.0
+	if intent.Confidence != nil {
+		score = *intent.Confidence
+	}
+	for _, id := range strings.Split(intent.Parameters["train_id"], ",") {
+		if !trainIDPattern.MatchString(strings.TrimSpace(id)) {
+			score = min(score, 0.3)
+		}
+	}
+	return score
+}
+
+// needsConfirmation holds back bookings and cancellations the model was unsure of
+func (a *BookingAgent) needsConfirmation(intent *IntentResponse) bool {
+	if intent.ClarifyQuestion != "" || a.confirmBelow <= 0 {
+		return false
+	}
+	if intent.Intent != "book_ticket" && intent.Intent != "cancel_ticket" {
+		return false
+	}
+	return confidence(intent) < a.confirmBelow
+}
+
+// restate describes the held-back action and asks the user to confirm it
+func (a *BookingAgent) restate(ctx context.Context, intent *IntentResponse) string {
+	ids := strings.Split(intent.Parameters["train_id"], ",")
+	trains := a.getTrainDetails(ctx, ids)
+
+	var described []string
+	for _, id := range ids {
+		id = strings.TrimSpace(id)
+		if train, ok := trains[id]; ok {
+			described = append(described, fmt.Sprintf("%s (%s → %s, %s %s)", id, train.From, train.To, train.Date, train.DepartureTime))
+		} else {
+			described = append(described, id)
+		}
+	}
+
+	verb := "book"
+	if intent.Intent == "cancel_ticket" {
+		verb = "cancel your ticket on"
+	}
+	user := intent.Parameters["user_id"]
+	if user == "" {
+		user = a.userID
+	}
+	who := "for user " + user
+	if labels := parseTravelers(intent.Parameters["travelers"]); intent.Intent == "book_ticket" && len(labels) > 0 {
+		who = strings.TrimPrefix(travelersNote(labels), " ") + " (user " + user + ")"
+	}
+	question := fmt.Sprintf("🤔 You want to %s %s %s — correct? (yes/no)", verb, strings.Join(described, " and "), who)
+	if intent.Intent == "book_ticket" {
+		if len(ids) > 1 {
+			question += a.journeyDiagram(ctx, ids)
+		}
+		if warnings := a.connectionWarnings(ctx, ids); len(warnings) > 0 {
+			question += "\n" + strings.Join(warnings, "\n")
+		}
+		question += a.weatherWarning(ctx, ids)
+	}
+	return question
+}
+
+// answerConfirmation acts on a reply to restate. Anything but yes or no is a
+// new request, so ok is false and the message goes to DeepSeek as usual.
+func (a *BookingAgent) answerConfirmation(ctx context.Context, pending *IntentResponse, answer string) (reply string, ok bool) {
+	yes, ok := yesOrNo(answer)
+	switch {
+	case !ok:
+		return "", false
+	case yes:
+		return a.runAnswered(ctx, pending, answer), true
+	}
+
+	reply = "👍 OK, nothing was changed. What would you like to do instead?"
+	a.conversationHistory = append(a.conversationHistory, Message{Role: "user", Content: answer})
+	a.remember(reply)
+	a.record(TranscriptEntry{User: answer, Calls: a.recorder.take(a.sessionID), Reply: reply})
+	return reply, true
+}
+
+// yesOrNo reads the reply to a yes/no question; ok is false for anything else
+func yesOrNo(answer string) (yes, ok bool) {
+	switch strings.ToLower(strings.Trim(strings.TrimSpace(answer), ".!")) {
+	case "y", "yes", "correct", "yes please", "ok", "sure":
+		return true, true
+	case "n", "no", "nope", "no thanks", "cancel":
+		return false, true
+	}
+	return false, false
+}
+
+// runAnswered carries out an action held back for the user's answer
+func (a *BookingAgent) runAnswered(ctx context.Context, pending *IntentResponse, answer string) string {
+	reply := a.executeAction(ctx, pending)
+	a.noteFocus(pending)
+	if a.pendingField != nil || a.pendingChallenge != nil {
+		// Turned down again, for another detail or a wrong answer
+		a.pendingAction, a.pendingSeat = pending, false
+	}
+	if pending.Intent == "book_ticket" {
+		a.experiment.update(func(s *variantStats) {
+			s.BookingAttempts++
+			if outcome(reply) == exitOK {
+				s.BookingSuccess++
+			}
+		})
+	}
+
+	a.conversationHistory = append(a.conversationHistory, Message{Role: "user", Content: answer})
+	a.remember(reply)
+	a.record(TranscriptEntry{User: answer, Intent: pending.Intent, Parameters: pending.Parameters, Calls: a.recorder.take(a.sessionID), Reply: reply})
+	return reply
+}
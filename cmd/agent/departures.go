@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Departures: the booking server closes a train's bookings and cancellations
+// shortly before it leaves. The agent explains the refusal and offers the
+// later trains on the same route.
+
+// Alternatives listed after a departed train
+const maxAlternatives = 3
+
+// departedReply explains that trainID can no longer be booked, suggesting
+// later trains between the same cities
+func (a *BookingAgent) departedReply(ctx context.Context, trainID string, err error) string {
+	// The server names the train, e.g. "K300: booking closed, ..."
+	reply := fmt.Sprintf("❌ %v", err)
+
+	if trainID == "" {
+		return reply
+	}
+	found, lookupErr := a.provider.Search(ctx, SearchQuery{TrainIDs: []string{trainID}})
+	if lookupErr != nil || len(found) == 0 {
+		return reply
+	}
+	closed := found[0]
+	leaves, _, timeErr := trainTimes(closed)
+	trains, searchErr := a.provider.Search(ctx, SearchQuery{From: closed.From, To: closed.To})
+	if timeErr != nil || searchErr != nil {
+		return reply
+	}
+
+	var later []Train
+	for _, t := range trains {
+		depart, _, err := trainTimes(t)
+		if err != nil || t.ID == closed.ID || t.Available == 0 || !depart.After(leaves) {
+			continue
+		}
+		later = append(later, t)
+		if len(later) == maxAlternatives {
+			break
+		}
+	}
+	if len(later) == 0 {
+		return reply + fmt.Sprintf("\n\nThere is no later train from %s to %s.", closed.From, closed.To)
+	}
+	a.showResults(later)
+
+	if a.accessible {
+		return reply + "\n\n" + describeTrains(fmt.Sprintf("Later trains from %s to %s", closed.From, closed.To), later)
+	}
+	var lines []string
+	for _, t := range later {
+		lines = append(lines, fmt.Sprintf("• %s: %s %s-%s (%d/%d available)%s",
+			t.ID, t.Date, t.DepartureTime, t.ArrivalTime, t.Available, t.TotalTickets, providerTag(t.Provider)))
+	}
+	return reply + fmt.Sprintf("\n\n🚄 Later trains from %s to %s:\n%s", closed.From, closed.To, strings.Join(lines, "\n"))
+}
+
+// departedTrain is the train a departure refusal names, "" when the
+// provider names none
+func departedTrain(err error) string {
+	var refused *ProviderError
+	if errors.As(err, &refused) {
+		return refused.TrainID
+	}
+	return ""
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Route diagrams: a train or a multi-leg journey drawn as a timeline of
+// stops, so an itinerary reads at a glance in the terminal:
+//
+//	08:00    ● Beijing        G100
+//	         │ 5h30m
+//	13:30    ● Shanghai
+//	         ┆ change at Shanghai Hongqiao, 30m
+//	14:00    ● Shanghai       G102
+//	         │ 5h30m
+//	19:30    ● Beijing
+//
+// Times on later days than the first departure are marked +1, +2 and so on.
+// Screen readers get the spoken descriptions instead.
+
+// routeDiagram draws legs, in travel order; empty when a leg's times cannot
+// be read
+func routeDiagram(legs []Train) string {
+	if len(legs) == 0 {
+		return ""
+	}
+	first, _, err := trainTimes(legs[0])
+	if err != nil {
+		return ""
+	}
+	day0 := time.Date(first.Year(), first.Month(), first.Day(), 0, 0, 0, 0, time.UTC)
+	clock := func(t time.Time) string {
+		s := t.Format("15:04")
+		if days := int(t.Sub(day0).Hours()) / 24; days > 0 {
+			s += fmt.Sprintf("+%d", days)
+		}
+		return s
+	}
+	width := 0
+	for _, leg := range legs {
+		width = max(width, len([]rune(leg.From)), len([]rune(leg.To)))
+	}
+
+	var lines []string
+	for i, leg := range legs {
+		depart, arrive, err := trainTimes(leg)
+		if err != nil {
+			return ""
+		}
+		if i > 0 {
+			if note := transferNote(legs[i-1], leg); note != "" {
+				lines = append(lines, fmt.Sprintf("%-8s ┆ %s", "", note))
+			}
+		}
+		lines = append(lines,
+			fmt.Sprintf("%-8s ● %-*s  %s", clock(depart), width, leg.From, leg.ID),
+			fmt.Sprintf("%-8s │ %s", "", formatRideTime(arrive.Sub(depart))),
+			fmt.Sprintf("%-8s ● %s", clock(arrive), leg.To))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// transferNote describes the change from one leg to the next: where and how
+// long, or how much later a leg days away leaves
+func transferNote(from, to Train) string {
+	_, arrive, err1 := trainTimes(from)
+	depart, _, err2 := trainTimes(to)
+	if err1 != nil || err2 != nil || depart.Before(arrive) {
+		return ""
+	}
+	gap := depart.Sub(arrive)
+	switch {
+	case !strings.EqualFold(from.To, to.From):
+		return fmt.Sprintf("travel on from %s to %s", from.To, to.From)
+	case gap > 12*time.Hour:
+		return fmt.Sprintf("%s later", formatRideTime(gap))
+	}
+	where := from.To
+	if station, ok := cityStation(from.To); ok {
+		where = station.Name
+	}
+	return fmt.Sprintf("change at %s, %s", where, formatRideTime(gap))
+}
+
+// formatRideTime shows a duration as e.g. 5h30m, 45m or 2d3h
+func formatRideTime(d time.Duration) string {
+	minutes := int(d.Round(time.Minute).Minutes())
+	switch {
+	case minutes >= 24*60:
+		return fmt.Sprintf("%dd%dh", minutes/(24*60), minutes%(24*60)/60)
+	case minutes >= 60 && minutes%60 == 0:
+		return fmt.Sprintf("%dh", minutes/60)
+	case minutes >= 60:
+		return fmt.Sprintf("%dh%02dm", minutes/60, minutes%60)
+	}
+	return fmt.Sprintf("%dm", minutes)
+}
+
+// journeyDiagram draws the trains of trainIDs in the order given, after a
+// blank line; empty in accessible mode or when a train is unknown
+func (a *BookingAgent) journeyDiagram(ctx context.Context, trainIDs []string) string {
+	if a.accessible {
+		return ""
+	}
+	trains := a.getTrainDetails(ctx, trainIDs)
+	legs := make([]Train, 0, len(trainIDs))
+	for _, id := range trainIDs {
+		train, ok := trains[strings.TrimSpace(id)]
+		if !ok {
+			return ""
+		}
+		legs = append(legs, train)
+	}
+	if diagram := routeDiagram(legs); diagram != "" {
+		return "\n\n" + diagram
+	}
+	return ""
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strings"
+)
+
+// Slot filling: which parameters an action still needs, what to ask for next
+// and when the action is ready are decided here, from the required
+// parameters of the tool's schema, not by DeepSeek. DeepSeek only reads the
+// values out of each reply, and they are gathered in a frame across turns.
+
+// Times one slot is asked for before the action is given up
+const maxSlotAsks = 3
+
+// slotFrame is an action being put together over several turns
+type slotFrame struct {
+	intent string
+	params map[string]string
+	asking string // the slot last asked for
+	asked  int    // times it was asked for
+}
+
+// Questions for slots, by "intent/slot" or, for any intent, by slot
+var slotQuestions = map[string]string{
+	"book_ticket/train_id":   "Which train would you like to book? Give its ID, such as G100, or tell me where and when you're going.",
+	"cancel_ticket/train_id": "Which train's ticket should I cancel?",
+	"query_ticket/train_id":  "Which train would you like to know about?",
+	"train_id":               "Which train do you mean? Please give its ID, such as G100.",
+	"to":                     "Where would you like to travel to?",
+	"station":                "Which station would you like to know about?",
+	"action":                 "Would you like to save, remove or list your travelers?",
+}
+
+func slotQuestion(intent, slot string, tool Tool) string {
+	if q, ok := slotQuestions[intent+"/"+slot]; ok {
+		return q
+	}
+	if q, ok := slotQuestions[slot]; ok {
+		return q
+	}
+	return fmt.Sprintf("Please tell me the %s (%s).", strings.ReplaceAll(slot, "_", " "), tool.Parameters().Properties[slot].Description)
+}
+
+// onlyValue reports whether message says value and nothing else
+func onlyValue(message, value string) bool {
+	trim := func(s string) string { return strings.ToLower(strings.Trim(s, " .!?")) }
+	return value != "" && trim(message) == trim(value)
+}
+
+// missingSlots lists the required parameters of tool the frame has no value for
+func (f *slotFrame) missingSlots(tool Tool) []string {
+	var missing []string
+	for _, name := range tool.Parameters().Required {
+		if strings.TrimSpace(f.params[name]) == "" {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// fillSlots merges DeepSeek's reading of a message into the frame being
+// filled, or starts one for an action with required parameters, and returns
+// the action when every slot is filled or the question for the next one.
+// A reading of another action leaves the frame behind, unless message is no
+// more than the value asked for: "G100" answers "Which train would you like to
+// book?" even if read on its own as a query.
+func (a *BookingAgent) fillSlots(ctx context.Context, intent *IntentResponse, message string) *IntentResponse {
+	if intent.settled {
+		return intent
+	}
+	f := a.frame
+	tool, ok := a.tools.lookup(intent.Intent)
+	switch {
+	case f != nil && (intent.Intent == f.intent || !ok || onlyValue(message, intent.Parameters[f.asking])):
+		// The answer to the question asked
+		tool, _ = a.tools.lookup(f.intent)
+		for name, value := range intent.Parameters {
+			if _, takes := tool.Parameters().Properties[name]; takes && value != "" {
+				f.params[name] = value
+			}
+		}
+	case ok && len(tool.Parameters().Required) > 0:
+		f = &slotFrame{intent: intent.Intent, params: maps.Clone(intent.Parameters)}
+		if f.params == nil {
+			f.params = map[string]string{}
+		}
+	default:
+		a.frame = nil
+		return intent
+	}
+
+	missing := f.missingSlots(tool)
+	if len(missing) == 0 {
+		a.frame = nil
+		if f.asked > 0 {
+			logger.InfoContext(ctx, "slots filled", "intent", f.intent, "parameters", f.params)
+		}
+		// A question of the model's, such as which of two trains was meant,
+		// still stands when nothing is missing
+		return &IntentResponse{Intent: f.intent, Parameters: maps.Clone(f.params), ClarifyQuestion: intent.ClarifyQuestion, Confidence: intent.Confidence}
+	}
+
+	slot := missing[0]
+	if slot == f.asking {
+		f.asked++
+	} else {
+		f.asking, f.asked = slot, 1
+	}
+	if f.asked > maxSlotAsks {
+		a.frame = nil
+		logger.InfoContext(ctx, "slot filling abandoned", "intent", f.intent, "slot", slot)
+		return &IntentResponse{
+			Intent:          "unknown",
+			Parameters:      map[string]string{},
+			ClarifyQuestion: fmt.Sprintf("I still don't have the %s, so let's leave that for now. What would you like to do?", strings.ReplaceAll(slot, "_", " ")),
+			settled:         true,
+		}
+	}
+	a.frame = f
+	debugLog.Printf("Slot filling %s: asking for %s (%d of %d), have %v", f.intent, slot, f.asked, maxSlotAsks, f.params)
+	return &IntentResponse{
+		Intent:            f.intent,
+		Parameters:        maps.Clone(f.params),
+		MissingParameters: missing,
+		ClarifyQuestion:   slotQuestion(f.intent, slot, tool),
+		Confidence:        intent.Confidence,
+		settled:           true,
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/zhangbiao2009/train-booking/internal/server"
+)
+
+// serverURL is the embedded booking server the agents under test talk to
+var serverURL string
+
+func TestMain(m *testing.M) {
+	url, stop, err := server.Embedded(io.Discard)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "starting the embedded booking server: %v\n", err)
+		os.Exit(1)
+	}
+	serverURL = url
+	code := m.Run()
+	stop()
+	os.Exit(code)
+}
+
+// offlineAgent is an agent whose model replies are script, in order, and
+// after that the offline rules
+func offlineAgent(t *testing.T, script ...string) *BookingAgent {
+	t.Helper()
+	a := NewBookingAgent("", serverURL)
+	a.offline = &cannedLLM{script: script}
+	prompts, err := loadPrompts("", a.tools)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.prompts = prompts
+	a.userID = "dialog-" + strings.ReplaceAll(t.Name(), "/", "-")
+	return a
+}
+
+// turn sends message and returns how the agent took it
+func turn(t *testing.T, a *BookingAgent, message string) TranscriptEntry {
+	t.Helper()
+	if _, err := a.respond(context.Background(), message); err != nil {
+		t.Fatalf("%q: %v", message, err)
+	}
+	return a.transcript[len(a.transcript)-1]
+}
+
+// booked reports whether the turn booked a ticket on the booking server
+func booked(entry TranscriptEntry) bool {
+	for _, call := range entry.Calls {
+		if strings.Contains(call.URL, "/book?") && call.Status == http.StatusOK {
+			return true
+		}
+	}
+	return false
+}
+
+const askForTrain = `{"intent":"book_ticket","parameters":{},"missing_parameters":["train_id"],"confidence":0.9}`
+
+func TestSlotFilling(t *testing.T) {
+	tests := []struct {
+		name string
+		// Messages and the model's reading of each
+		messages, script []string
+		// The last turn's intent, train and question, and whether it booked
+		intent, trainID, question string
+		booked                    bool
+		// The action still being put together afterwards, if any
+		frame string
+	}{
+		{
+			name:     "frame start",
+			messages: []string{"I'd like to book a ticket"},
+			script:   []string{askForTrain},
+			intent:   "book_ticket",
+			question: slotQuestions["book_ticket/train_id"],
+			frame:    "book_ticket",
+		},
+		{
+			name:     "answer",
+			messages: []string{"I'd like to book a ticket", "G100, please"},
+			script:   []string{askForTrain, `{"intent":"book_ticket","parameters":{"train_id":"G100"},"confidence":0.9}`},
+			intent:   "book_ticket",
+			trainID:  "G100",
+			booked:   true,
+		},
+		{
+			// Read on its own "G100" is a query, but it answers the question
+			name:     "answer only",
+			messages: []string{"I'd like to book a ticket", "G100"},
+			script:   []string{askForTrain, `{"intent":"query_ticket","parameters":{"train_id":"G100"},"confidence":0.9}`},
+			intent:   "book_ticket",
+			trainID:  "G100",
+			booked:   true,
+		},
+		{
+			name:     "another intent",
+			messages: []string{"I'd like to book a ticket", "actually, list the trains"},
+			script:   []string{askForTrain, `{"intent":"list_trains","parameters":{},"confidence":0.9}`},
+			intent:   "list_trains",
+		},
+		{
+			name:     "another intent with the slot",
+			messages: []string{"I'd like to book a ticket", "how full is G100?"},
+			script:   []string{askForTrain, `{"intent":"query_ticket","parameters":{"train_id":"G100"},"confidence":0.9}`},
+			intent:   "query_ticket",
+			trainID:  "G100",
+		},
+		{
+			name:     "given up",
+			messages: []string{"I'd like to book a ticket", "hmm", "not sure", "no idea"},
+			script:   []string{askForTrain, `{"intent":"unknown","parameters":{}}`, `{"intent":"unknown","parameters":{}}`, `{"intent":"unknown","parameters":{}}`},
+			intent:   "unknown",
+			question: "I still don't have the train id, so let's leave that for now. What would you like to do?",
+		},
+		{
+			name:     "asked again",
+			messages: []string{"I'd like to book a ticket", "hmm", "not sure"},
+			script:   []string{askForTrain, `{"intent":"unknown","parameters":{}}`, `{"intent":"unknown","parameters":{}}`},
+			intent:   "book_ticket",
+			question: slotQuestions["book_ticket/train_id"],
+			frame:    "book_ticket",
+		},
+		{
+			// The model's question stands once the frame has every slot
+			name:     "clarify question kept",
+			messages: []string{"I'd like to book a ticket", "the one to Shanghai"},
+			script:   []string{askForTrain, `{"intent":"book_ticket","parameters":{"train_id":"G100"},"clarify_question":"Do you mean G100 or G102, both to Shanghai?","confidence":0.6}`},
+			intent:   "book_ticket",
+			trainID:  "G100",
+			question: "Do you mean G100 or G102, both to Shanghai?",
+		},
+		{
+			name:     "no frame without required slots",
+			messages: []string{"list trains"},
+			script:   []string{`{"intent":"list_trains","parameters":{},"confidence":0.9}`},
+			intent:   "list_trains",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := offlineAgent(t, tt.script...)
+			var last TranscriptEntry
+			for _, message := range tt.messages {
+				last = turn(t, a, message)
+			}
+			if last.Intent != tt.intent || last.Parameters["train_id"] != tt.trainID || last.ClarifyQuestion != tt.question {
+				t.Errorf("last turn read as %s %v asking %q, want %s train_id=%q asking %q",
+					last.Intent, last.Parameters, last.ClarifyQuestion, tt.intent, tt.trainID, tt.question)
+			}
+			if booked(last) != tt.booked {
+				t.Errorf("booked = %v, want %v; reply %q", booked(last), tt.booked, last.Reply)
+			}
+			frame := ""
+			if a.frame != nil {
+				frame = a.frame.intent
+			}
+			if frame != tt.frame {
+				t.Errorf("frame afterwards = %q, want %q", frame, tt.frame)
+			}
+		})
+	}
+}
+
+func TestOnlyValue(t *testing.T) {
+	tests := []struct {
+		message, value string
+		want           bool
+	}{
+		{"G100", "G100", true},
+		{" g100. ", "G100", true},
+		{"G100!", "G100", true},
+		{"G100 please", "G100", false},
+		{"how full is G100?", "G100", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		if got := onlyValue(tt.message, tt.value); got != tt.want {
+			t.Errorf("onlyValue(%q, %q) = %v, want %v", tt.message, tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Traveler details a provider turns down: when a booking is refused for one
+// field of a saved traveler, such as a passport number not in the passport
+// format or, with real-name ticketing, a missing one, the agent asks for just
+// that field, saves the answer and books again, rather than having the user
+// restate the whole booking. The user's own details are saved as the
+// traveler "self".
+
+// fieldQuestion is the traveler detail a held-back booking waits for
+type fieldQuestion struct {
+	user     string
+	traveler string // label of the traveler
+	field    string // document, document_type or name
+}
+
+// Document numbers as the user may reply with them
+var documentNumber = regexp.MustCompile(`^[A-Z0-9]{4,32}$`)
+
+// askField holds the booking for the first traveler field err turns down,
+// and asks for it; ok is false when err names no such field
+func (a *BookingAgent) askField(user string, err error) (question string, ok bool) {
+	var refused *ProviderError
+	if !errors.As(err, &refused) {
+		return "", false
+	}
+	for _, f := range refused.Fields {
+		their := "their"
+		if f.Traveler == selfTraveler {
+			their = "your"
+		}
+		var ask string
+		switch f.Field {
+		case "document":
+			ask = "What is the number? Reply with just the number."
+		case "document_type":
+			ask = "Is it an ID card or a passport?"
+		case "name":
+			ask = fmt.Sprintf("What is the full name on %s ID card or passport?", their)
+		default:
+			continue
+		}
+		if f.Traveler == "" {
+			continue
+		}
+		a.pendingField = &fieldQuestion{user: user, traveler: f.Traveler, field: f.Field}
+		message := strings.ToUpper(f.Message[:1]) + f.Message[1:]
+		return fmt.Sprintf("🤔 %s, so nothing was booked yet. %s Say cancel to stop.", message, ask), true
+	}
+	return "", false
+}
+
+// fieldAnswer reads the reply to askField as the field's value; ok is false
+// for a reply that is not one, such as a new request
+func fieldAnswer(field, answer string) (value string, ok bool) {
+	answer = strings.Trim(strings.TrimSpace(answer), ".!")
+	words := strings.Fields(answer)
+	if len(words) == 0 || len(words) > 5 {
+		return "", false
+	}
+	switch field {
+	case "document":
+		// "it's E1234567"
+		value = strings.ToUpper(strings.Trim(words[len(words)-1], `"'`))
+		return value, documentNumber.MatchString(value)
+	case "document_type":
+		lower := strings.ToLower(answer)
+		switch {
+		case strings.Contains(lower, "passport"):
+			return "passport", true
+		case strings.Contains(lower, "id"):
+			return "id_card", true
+		}
+		return "", false
+	}
+	return answer, true
+}
+
+// answerFieldQuestion saves the reply to askField on the traveler and runs
+// the held-back booking again. Anything but the value asked for is a new
+// request, so ok is false and the message goes to DeepSeek as usual.
+func (a *BookingAgent) answerFieldQuestion(ctx context.Context, pending *IntentResponse, answer string) (reply string, ok bool) {
+	q := a.pendingField
+	a.pendingField = nil
+	if yes, ok := yesOrNo(answer); ok && !yes {
+		return a.answerConfirmation(ctx, pending, answer)
+	}
+	value, ok := fieldAnswer(q.field, answer)
+	if !ok {
+		return "", false
+	}
+
+	travelers, err := a.provider.Travelers(ctx, q.user)
+	if err != nil {
+		reply = a.providerError(ctx, "fetching travelers", err)
+	} else if i := slices.IndexFunc(travelers, func(t Traveler) bool { return t.Label == q.traveler }); i < 0 && q.traveler != selfTraveler {
+		return "", false
+	} else {
+		if i < 0 {
+			travelers, i = append(travelers, Traveler{Label: selfTraveler, Type: "adult"}), len(travelers)
+		}
+		a.pii.learnTravelers(travelers)
+		switch q.field {
+		case "document":
+			travelers[i].Document = value
+		case "document_type":
+			travelers[i].DocumentType = value
+		case "name":
+			travelers[i].Name = value
+		}
+		if _, err := a.provider.SaveTravelers(ctx, q.user, travelers); errors.Is(err, ErrInvalid) {
+			reply = fmt.Sprintf("❌ %v", err)
+		} else if err != nil {
+			reply = a.providerError(ctx, "saving travelers", err)
+		} else {
+			return a.runAnswered(ctx, pending, answer), true
+		}
+	}
+
+	a.conversationHistory = append(a.conversationHistory, Message{Role: "user", Content: answer})
+	a.remember(reply)
+	a.record(TranscriptEntry{User: answer, Calls: a.recorder.take(a.sessionID), Reply: reply})
+	return reply, true
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Dry runs resolve the intent as usual but only describe the booking server
+// calls the agent would make. With --dry-run every message is a dry run;
+// otherwise a message starting with "what would happen if" is.
+
+var whatIfPattern = regexp.MustCompile(`(?i)^\s*what\s+(would\s+happen|happens)\s+if\s+(i\s+)?`)
+
+// whatIf strips a "what would happen if" prefix and reports whether there was one
+func whatIf(input string) (string, bool) {
+	loc := whatIfPattern.FindStringIndex(input)
+	if loc == nil {
+		return input, false
+	}
+	rest := strings.TrimRight(strings.TrimSpace(input[loc[1]:]), "?")
+	if rest == "" {
+		return input, false
+	}
+	return rest, true
+}
+
+// plannedCalls lists the requests executeAction would send for intent, with the
+// same endpoints and parameters. Status is left at zero: nothing is sent.
+// Only the booking server's requests are known; other providers plan none.
+func (a *BookingAgent) plannedCalls(intent *IntentResponse) []ServerCall {
+	if _, ok := a.provider.(*localServer); !ok || intent.ClarifyQuestion != "" {
+		return nil
+	}
+	p := intent.Parameters
+	user := p["user_id"]
+	if user == "" {
+		user = a.userID
+	}
+	user = url.QueryEscape(user) // only ever in query strings
+
+	id := p["train_id"]
+	var u string
+	switch intent.Intent {
+	case "query_ticket":
+		u = fmt.Sprintf("%s/v1/query?id=%s", a.serverURL, url.QueryEscape(id))
+	case "book_ticket":
+		travelers := parseTravelers(p["travelers"])
+		if strings.Contains(id, ",") || len(travelers) > 0 {
+			ids := strings.Split(id, ",")
+			for i := range ids {
+				ids[i] = strings.TrimSpace(ids[i])
+			}
+			u = fmt.Sprintf("%s/v1/book/batch?ids=%s&user_id=%s", a.serverURL, url.QueryEscape(strings.Join(ids, ",")), user)
+		} else {
+			u = fmt.Sprintf("%s/v1/book?id=%s&user_id=%s", a.serverURL, url.QueryEscape(id), user)
+		}
+		if len(travelers) > 0 {
+			u += "&travelers=" + url.QueryEscape(strings.Join(travelers, ","))
+		}
+		if saidYes(p["insurance"]) {
+			u += "&insurance=true"
+		}
+		if saidYes(p["pet"]) {
+			u += "&pet=true"
+		}
+		if saidYes(p["wheelchair"]) {
+			u += "&accessible=true"
+		}
+	case "cancel_ticket":
+		u = fmt.Sprintf("%s/v1/cancel?id=%s&user_id=%s", a.serverURL, url.QueryEscape(id), user)
+		if p["booking_ref"] != "" {
+			u += "&booking_ref=" + url.QueryEscape(strings.ToUpper(p["booking_ref"]))
+		}
+	case "list_trains":
+		u = a.serverURL + "/v1/list"
+	case "search_trains":
+		query := url.Values{}
+		for _, name := range []string{"from", "to", "date"} {
+			if p[name] != "" {
+				query.Set(name, p[name])
+			}
+		}
+		if saidYes(p["pets"]) {
+			query.Set("pets_allowed", "true")
+		}
+		u = a.serverURL + "/v1/list"
+		if len(query) > 0 {
+			u = a.serverURL + "/v1/tickets?" + query.Encode()
+		}
+	case "my_tickets":
+		u = fmt.Sprintf("%s/v1/user/tickets?user_id=%s", a.serverURL, user)
+	case "booking_receipt":
+		refs := a.lastBookingRefs
+		if p["booking_ref"] != "" {
+			refs = []string{strings.ToUpper(p["booking_ref"])}
+		}
+		var calls []ServerCall
+		for _, ref := range refs {
+			calls = append(calls, ServerCall{Method: http.MethodGet, URL: fmt.Sprintf("%s/v1/booking/%s/receipt?user_id=%s", a.serverURL, url.PathEscape(ref), user)})
+		}
+		return calls
+	case "feedback":
+		return []ServerCall{{Method: http.MethodPost, URL: a.serverURL + "/v1/feedback"}}
+	case "order_meals":
+		ref := url.PathEscape(strings.ToUpper(p["booking_ref"]))
+		id := url.PathEscape(id)
+		if p["meals"] == "" {
+			return []ServerCall{{Method: http.MethodGet, URL: fmt.Sprintf("%s/v1/trains/%s/menu", a.serverURL, id)}}
+		}
+		if ref == "" {
+			ref = "{booking_ref}"
+		}
+		return []ServerCall{
+			{Method: http.MethodGet, URL: fmt.Sprintf("%s/v1/trains/%s/menu", a.serverURL, id)},
+			{Method: http.MethodPost, URL: fmt.Sprintf("%s/v1/booking/%s/meals", a.serverURL, ref)},
+		}
+	case "train_position":
+		id := url.PathEscape(id)
+		if id == "" {
+			id = "{train_id}"
+		}
+		return []ServerCall{{Method: http.MethodGet, URL: fmt.Sprintf("%s/v1/trains/%s/position", a.serverURL, id)}}
+	case "fare_calendar":
+		query := url.Values{"from": {p["from"]}, "to": {p["to"]}}
+		return []ServerCall{{Method: http.MethodGet, URL: a.serverURL + "/v1/fares/calendar?" + query.Encode()}}
+	case "train_punctuality":
+		return []ServerCall{{Method: http.MethodGet, URL: fmt.Sprintf("%s/v1/trains/%s/punctuality", a.serverURL, url.PathEscape(id))}}
+	case "choose_seat":
+		if p["seat"] == "" && p["preference"] == "" {
+			id := url.PathEscape(strings.ToUpper(p["train_id"]))
+			if id == "" {
+				id = "{train_id}"
+			}
+			return []ServerCall{{Method: http.MethodGet, URL: fmt.Sprintf("%s/v1/trains/%s/seats", a.serverURL, id)}}
+		}
+		ref := url.PathEscape(strings.ToUpper(p["booking_ref"]))
+		if ref == "" {
+			ref = "{booking_ref}"
+		}
+		return []ServerCall{{Method: http.MethodPost, URL: fmt.Sprintf("%s/v1/booking/%s/seat", a.serverURL, ref)}}
+	case "declare_luggage":
+		ref := url.PathEscape(strings.ToUpper(p["booking_ref"]))
+		if ref == "" {
+			ref = "{booking_ref}"
+		}
+		return []ServerCall{{Method: http.MethodPost, URL: fmt.Sprintf("%s/v1/booking/%s/luggage", a.serverURL, ref)}}
+	case "upgrade":
+		ref := url.PathEscape(strings.ToUpper(p["booking_ref"]))
+		if ref == "" {
+			ref = "{booking_ref}"
+		}
+		return []ServerCall{{Method: http.MethodPost, URL: fmt.Sprintf("%s/v1/booking/%s/upgrade", a.serverURL, ref)}}
+	case "escalate":
+		return []ServerCall{{Method: http.MethodPost, URL: a.serverURL + "/v1/support/tickets"}}
+	default:
+		return nil
+	}
+	calls := []ServerCall{{Method: http.MethodGet, URL: u}}
+	if intent.Intent == "book_ticket" {
+		// The receipt of the booking made, under the reference it returns
+		calls = append(calls, ServerCall{Method: http.MethodGet, URL: fmt.Sprintf("%s/v1/booking/{booking_ref}/receipt?user_id=%s", a.serverURL, user)})
+	}
+	return calls
+}
+
+// describePlan is the reply to a dry run
+func (a *BookingAgent) describePlan(intent *IntentResponse, planned []ServerCall) string {
+	if intent.ClarifyQuestion != "" {
+		return "🤔 " + intent.ClarifyQuestion
+	}
+
+	var b strings.Builder
+	b.WriteString("🧪 Dry run, nothing was sent to the booking server:\n")
+	fmt.Fprintf(&b, "• Intent: %s", intent.Intent)
+	if intent.Confidence != nil {
+		fmt.Fprintf(&b, " (confidence %.2f)", *intent.Confidence)
+	}
+	b.WriteString("\n")
+	if _, local := a.provider.(*localServer); !local {
+		fmt.Fprintf(&b, "• Calls to the %s provider cannot be listed in advance\n", a.provider.Name())
+	} else if len(planned) == 0 {
+		b.WriteString("• No booking server call would be made")
+		return b.String()
+	}
+	for _, call := range planned {
+		fmt.Fprintf(&b, "• Would call: %s %s\n", call.Method, call.URL)
+	}
+
+	switch {
+	case a.needsConfirmation(intent):
+		fmt.Fprintf(&b, "• Would ask you to confirm first, since the confidence %.2f is below %.2f\n", confidence(intent), a.confirmBelow)
+	case a.duplicateQuestion(intent) != "":
+		b.WriteString("• Would ask you first, since you just made this booking\n")
+	}
+	if intent.Intent == "book_ticket" {
+		if last := a.recentBooking(intent.Parameters["train_id"], intent.Parameters["user_id"]); last != nil && last.outcome == exitUnavailable {
+			b.WriteString("• Would reuse the Idempotency-Key of the unanswered attempt, so it is booked at most once\n")
+		} else {
+			b.WriteString("• Would send a new Idempotency-Key\n")
+		}
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
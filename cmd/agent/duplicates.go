@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Duplicate-booking guard. Every booking request carries an Idempotency-Key.
+// The same booking asked for again within duplicateWindow is either retried
+// with the first attempt's key, when that attempt got no clear answer, or held
+// for a yes/no when it already succeeded.
+
+// bookingAttempt is the last booking the agent sent
+type bookingAttempt struct {
+	trains  string // sorted, comma-separated train IDs
+	user    string
+	key     string
+	at      time.Time
+	outcome int
+}
+
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// bookingTarget normalizes what a booking intent asks for, so "G102, G100"
+// repeats "G100,G102"
+func (a *BookingAgent) bookingTarget(trainID, userID string) (trains, user string) {
+	ids := strings.Split(trainID, ",")
+	for i := range ids {
+		ids[i] = strings.ToUpper(strings.TrimSpace(ids[i]))
+	}
+	sort.Strings(ids)
+	if userID == "" {
+		userID = a.userID
+	}
+	return strings.Join(ids, ","), userID
+}
+
+// recentBooking returns the last attempt if it asked for the same booking within the window
+func (a *BookingAgent) recentBooking(trainID, userID string) *bookingAttempt {
+	last := a.lastBooking
+	if last == nil || a.duplicateWindow <= 0 || time.Since(last.at) > a.duplicateWindow {
+		return nil
+	}
+	if trains, user := a.bookingTarget(trainID, userID); trains != last.trains || user != last.user {
+		return nil
+	}
+	return last
+}
+
+// duplicateQuestion asks before repeating a booking that just succeeded; it is
+// empty when intent is not such a repeat
+func (a *BookingAgent) duplicateQuestion(intent *IntentResponse) string {
+	if intent.Intent != "book_ticket" || intent.ClarifyQuestion != "" {
+		return ""
+	}
+	last := a.recentBooking(intent.Parameters["train_id"], intent.Parameters["user_id"])
+	if last == nil || last.outcome != exitOK {
+		return ""
+	}
+	when := "just now"
+	if ago := time.Since(last.at).Round(time.Second); ago >= 5*time.Second {
+		when = ago.String() + " ago"
+	}
+	return fmt.Sprintf("🤔 You already booked %s for user %s %s. Book another ticket? (yes/no)",
+		strings.ReplaceAll(last.trains, ",", " and "), last.user, when)
+}
+
+// placeBooking books trainID (one ID or a comma-separated itinerary). A retry of
+// an attempt that ended without a clear answer reuses its key, so the server
+// replays the first result instead of booking twice. extras are asked for
+// with every ticket.
+func (a *BookingAgent) placeBooking(ctx context.Context, trainID, userID string, extras bookingExtras) string {
+	if len(extras.travelers) > 0 {
+		user := userID
+		if user == "" {
+			user = a.userID
+		}
+		labels, question, err := a.checkTravelers(ctx, user, extras.travelers)
+		if err != nil {
+			return a.providerError(ctx, "fetching travelers", err)
+		}
+		if question != "" {
+			return question
+		}
+		extras.travelers = labels
+	}
+
+	key := newIdempotencyKey()
+	if last := a.recentBooking(trainID, userID); last != nil && last.outcome == exitUnavailable {
+		key = last.key
+		logger.Info("retrying booking with earlier idempotency key", "trains", last.trains)
+	}
+
+	var reply string
+	if strings.Contains(trainID, ",") {
+		reply = a.bookItinerary(ctx, strings.Split(trainID, ","), userID, extras, key)
+	} else {
+		reply = a.bookTicket(ctx, trainID, userID, extras, key)
+	}
+
+	trains, user := a.bookingTarget(trainID, userID)
+	a.lastBooking = &bookingAttempt{trains: trains, user: user, key: key, at: time.Now(), outcome: outcome(reply)}
+	if a.lastBooking.outcome == exitOK {
+		ids := strings.Split(trainID, ",")
+		if warnings := a.connectionWarnings(ctx, ids); len(warnings) > 0 {
+			reply += "\n" + strings.Join(warnings, "\n")
+		}
+		reply += a.weatherWarning(ctx, ids)
+		reply += a.bookedLuggage(ctx, ids, userID, extras.luggage)
+	}
+	return reply
+}
+
+// bookingExtras are what a booking asks for beyond the tickets
+type bookingExtras struct {
+	insured    bool   // insure every ticket
+	pet        bool   // the passenger travels with a pet
+	wheelchair bool   // the passenger needs wheelchair-accessible seats
+	luggage    string // luggage to declare on every train, as the user said it
+
+	travelers []string // labels of the saved travelers given a ticket each; none for the user alone
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Email: --email imaps://imap.example.com polls that inbox instead of
+// running the terminal chat, for travel desks that book by mail. Each new
+// email is a turn of a conversation, one per sender and thread, so a reply
+// to the agent's answer ("book the first one") follows on from it. The
+// answer is sent back through --email-smtp as a reply in the same thread.
+//
+// EMAIL_USERNAME and EMAIL_PASSWORD log in to both servers; replies come
+// from EMAIL_FROM, the username by default, and EMAIL_MAILBOX is read
+// rather than INBOX.
+
+// A thread quiet this long starts afresh; mail is answered at leisure
+const emailIdle = 24 * time.Hour
+
+type emailBridge struct {
+	pool     *sessionPool
+	imap     string
+	smtp     string
+	username string
+	password string
+	from     string
+	mailbox  string
+	users    map[string]string // address to user ID; others get mail-<address>
+}
+
+func newEmailBridge(cfg Config, agent *BookingAgent) (*emailBridge, error) {
+	b := &emailBridge{
+		pool:     newSessionPool(agent),
+		imap:     cfg.Email,
+		smtp:     cfg.EmailSMTP,
+		username: os.Getenv("EMAIL_USERNAME"),
+		password: os.Getenv("EMAIL_PASSWORD"),
+		mailbox:  envOr("EMAIL_MAILBOX", "INBOX"),
+	}
+	b.pool.idle = emailIdle
+	b.from = envOr("EMAIL_FROM", b.username)
+	if b.username == "" || b.password == "" {
+		return nil, fmt.Errorf("EMAIL_USERNAME and EMAIL_PASSWORD must be set")
+	}
+	if b.smtp == "" {
+		return nil, fmt.Errorf("--email-smtp is not set")
+	}
+	if _, err := mail.ParseAddress(b.from); err != nil {
+		return nil, fmt.Errorf("EMAIL_FROM %q: %v", b.from, err)
+	}
+	if cfg.EmailUsers != "" {
+		users, err := loadChannelUsers(cfg.EmailUsers)
+		if err != nil {
+			return nil, err
+		}
+		b.users = map[string]string{}
+		for address, user := range users {
+			b.users[strings.ToLower(address)] = user
+		}
+	}
+	return b, nil
+}
+
+// userID is the booking user an address maps to
+func (b *emailBridge) userID(address string) string {
+	if user, ok := b.users[strings.ToLower(address)]; ok {
+		return user
+	}
+	return channelUserID("mail", strings.ToLower(address))
+}
+
+// poll answers new mail every interval. It gives up only if the first check
+// fails, as that is most likely a configuration mistake.
+func (b *emailBridge) poll(interval time.Duration) error {
+	fmt.Fprintf(notices, "📧 Reading %s at %s every %s\n", b.mailbox, b.imap, interval)
+	if err := b.check(); err != nil {
+		return err
+	}
+	for range time.Tick(interval) {
+		if err := b.check(); err != nil {
+			logger.Error("email check failed", "err", err)
+		}
+	}
+	return nil
+}
+
+// check fetches the unseen messages and answers them in turn
+func (b *emailBridge) check() error {
+	c, err := dialIMAP(b.imap)
+	if err != nil {
+		return err
+	}
+	defer c.logout()
+	if err := c.login(b.username, b.password); err != nil {
+		return err
+	}
+	if err := c.selectMailbox(b.mailbox); err != nil {
+		return err
+	}
+	uids, err := c.unseen()
+	if err != nil {
+		return err
+	}
+	for _, uid := range uids {
+		raw, err := c.fetch(uid)
+		if err != nil {
+			return err
+		}
+		if err := b.answer(raw); err != nil {
+			logger.Error("email not answered", "uid", uid, "err", err)
+		}
+	}
+	return nil
+}
+
+// answer runs a message as a turn of its thread and mails the reply
+func (b *emailBridge) answer(raw []byte) error {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	h := msg.Header
+	sender, err := mail.ParseAddress(h.Get("Reply-To"))
+	if err != nil {
+		if sender, err = mail.ParseAddress(h.Get("From")); err != nil {
+			return fmt.Errorf("no sender: %v", err)
+		}
+	}
+	if reason := automatic(h, sender.Address, b.from); reason != "" {
+		logger.Info("email skipped", "from", sender.Address, "reason", reason)
+		return nil
+	}
+
+	subject, _ := new(mime.WordDecoder).DecodeHeader(h.Get("Subject"))
+	body, err := mailText(h, msg.Body)
+	if err != nil {
+		return err
+	}
+	request := requestText(body)
+	if request == "" {
+		request = replyPrefix.ReplaceAllString(subject, "")
+	}
+	if request == "" {
+		return fmt.Errorf("empty message from %s", sender.Address)
+	}
+
+	key := "mail:" + strings.ToLower(sender.Address) + ":" + threadID(h)
+	turn := b.pool.reply(key, b.userID(sender.Address), request)
+	reply := turn.Reply + "\n\n" + quote(h.Get("Date"), sender, request)
+	if err := b.send(sender, subject, h, reply); err != nil {
+		return err
+	}
+	logger.Info("email answered", "from", sender.Address, "thread", threadID(h))
+	return nil
+}
+
+// Subject prefixes of replies and forwards
+var replyPrefix = regexp.MustCompile(`(?i)^((re|fw|fwd|aw|回复|转发)\s*[:：]\s*)+`)
+
+// automatic says why a message was sent by a machine, such as an
+// out-of-office reply, or by the agent itself, or "" if it was not; answering
+// those could start a mail loop
+func automatic(h mail.Header, sender, self string) string {
+	if a := strings.ToLower(h.Get("Auto-Submitted")); a != "" && a != "no" {
+		return "auto-submitted"
+	}
+	switch strings.ToLower(h.Get("Precedence")) {
+	case "bulk", "junk", "list":
+		return "bulk"
+	}
+	if h.Get("List-Id") != "" {
+		return "mailing list"
+	}
+	if own, err := mail.ParseAddress(self); err == nil && strings.EqualFold(own.Address, sender) {
+		return "own message"
+	}
+	return ""
+}
+
+// threadID is the Message-ID a thread started with: the first of the
+// References, or the message replied to, or the message itself
+func threadID(h mail.Header) string {
+	if refs := strings.Fields(h.Get("References")); len(refs) > 0 {
+		return refs[0]
+	}
+	if id := strings.TrimSpace(h.Get("In-Reply-To")); id != "" {
+		return id
+	}
+	return strings.TrimSpace(h.Get("Message-ID"))
+}
+
+// mailText returns the plain text of a message body, preferring the text
+// part of a multipart message and falling back on HTML without its tags
+func mailText(h mail.Header, body io.Reader) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain"
+	}
+	switch strings.ToLower(h.Get("Content-Transfer-Encoding")) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		parts := multipart.NewReader(body, params["boundary"])
+		var htmlText string
+		for {
+			p, err := parts.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return "", err
+			}
+			text, err := mailText(mail.Header(p.Header), p)
+			if err != nil {
+				return "", err
+			}
+			partType, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+			if partType == "text/html" {
+				htmlText = text
+				continue
+			}
+			if text != "" {
+				return text, nil
+			}
+		}
+		return htmlText, nil
+	}
+
+	data, err := io.ReadAll(io.LimitReader(body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	switch mediaType {
+	case "text/plain":
+		return string(data), nil
+	case "text/html":
+		text := htmlBreaks.ReplaceAllString(string(data), "\n")
+		return html.UnescapeString(htmlTags.ReplaceAllString(text, "")), nil
+	}
+	return "", nil
+}
+
+var (
+	htmlBreaks = regexp.MustCompile(`(?i)<br\s*/?>|</p>|</div>`)
+	htmlTags   = regexp.MustCompile(`(?s)<[^>]*>`)
+)
+
+// Lines where the quoted earlier conversation or a signature begins
+var quoteStart = regexp.MustCompile(`^(On .+ wrote:|.+写道[:：]|-----\s*Original Message\s*-----|From: .+|-- ?|_{10,})$`)
+
+// requestText keeps what the sender wrote of a message, without the quoted
+// conversation beneath it or their signature, as one line
+func requestText(body string) string {
+	var lines []string
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if quoteStart.MatchString(line) {
+			break
+		}
+		if line == "" || strings.HasPrefix(line, ">") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, " ")
+}
+
+// quote is the request, quoted beneath the reply
+func quote(date string, sender *mail.Address, request string) string {
+	who := sender.Address
+	if sender.Name != "" {
+		who = sender.Name
+	}
+	if date == "" {
+		return fmt.Sprintf("%s wrote:\n> %s\n", who, request)
+	}
+	return fmt.Sprintf("On %s, %s wrote:\n> %s\n", date, who, request)
+}
+
+// send mails reply to the sender, threaded after the message it answers
+func (b *emailBridge) send(to *mail.Address, subject string, original mail.Header, reply string) error {
+	if !replyPrefix.MatchString(subject) {
+		subject = "Re: " + subject
+	}
+	from, _ := mail.ParseAddress(b.from)
+	refs := strings.TrimSpace(original.Get("References") + " " + original.Get("Message-ID"))
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Message-ID: %s\r\n", newMessageID(from.Address))
+	if id := strings.TrimSpace(original.Get("Message-ID")); id != "" {
+		fmt.Fprintf(&msg, "In-Reply-To: %s\r\n", id)
+	}
+	if refs != "" {
+		fmt.Fprintf(&msg, "References: %s\r\n", refs)
+	}
+	msg.WriteString("Auto-Submitted: auto-replied\r\n")
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	qp := quotedprintable.NewWriter(&msg)
+	qp.Write([]byte(strings.ReplaceAll(reply, "\n", "\r\n")))
+	qp.Close()
+
+	return sendMail(b.smtp, b.username, b.password, from.Address, to.Address, msg.Bytes())
+}
+
+// newMessageID makes a unique Message-ID at the domain of address
+func newMessageID(address string) string {
+	_, domain, _ := strings.Cut(address, "@")
+	if domain == "" {
+		domain = "localhost"
+	}
+	id := make([]byte, 12)
+	rand.Read(id)
+	return fmt.Sprintf("<%s.%s@%s>", time.Now().Format("20060102150405"), hex.EncodeToString(id), domain)
+}
+
+// sendMail delivers msg through an smtps:// (TLS, port 465 by default) or
+// smtp:// (STARTTLS when offered, port 587) server, logging in if it
+// supports that
+func sendMail(server, username, password, from, to string, msg []byte) error {
+	u, err := url.Parse(server)
+	if err != nil {
+		return err
+	}
+	host := u.Host
+	tlsConfig := &tls.Config{ServerName: u.Hostname()}
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	var conn net.Conn
+	switch u.Scheme {
+	case "smtps":
+		if u.Port() == "" {
+			host = net.JoinHostPort(host, "465")
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, tlsConfig)
+	case "smtp":
+		if u.Port() == "" {
+			host = net.JoinHostPort(host, "587")
+		}
+		conn, err = dialer.Dial("tcp", host)
+	default:
+		return fmt.Errorf("%q: want an smtps:// or smtp:// address", server)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(time.Minute))
+	c, err := smtp.NewClient(conn, u.Hostname())
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok && u.Scheme == "smtp" {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if ok, _ := c.Extension("AUTH"); ok {
+		// PlainAuth refuses to send the password unencrypted, but to localhost
+		if err := c.Auth(smtp.PlainAuth("", username, password, u.Hostname())); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Escalation: when the agent keeps misunderstanding the user, or a cancellation
+// (and so its refund) fails, it offers to hand the issue over to a person. The
+// escalate tool files a support case with the recent conversation and gives the
+// user its case number.
+
+// Misunderstood messages in a row before the agent offers a person
+const escalateAfter = 3
+
+// Conversation messages attached to a support case, and their size limit
+const (
+	caseHistoryMessages = 8
+	caseHistoryBytes    = 3500
+)
+
+const escalationOffer = "💁 I can't seem to sort this out. Say \"talk to a person\" and I'll open a support case for our staff."
+
+func (a *BookingAgent) registerEscalationTool() {
+	err := a.tools.register(&funcTool{
+		name:        "escalate",
+		description: "User wants to talk to a person or support, or complains that an issue (such as a refund) was not resolved",
+		parameters: objectSchema(map[string]string{
+			"subject":     "One-line summary of the user's problem",
+			"booking_ref": "Booking reference concerned, such as BK1A2B3C4D5E",
+			"user_id":     userIDParam,
+		}),
+		run: func(ctx context.Context, p map[string]string) string {
+			return a.escalate(ctx, p["subject"], p["booking_ref"], p["user_id"])
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// noteOutcome keeps count of misunderstood messages and returns the offer of a
+// person to add to the reply, if it is time for one
+func (a *BookingAgent) noteOutcome(intent *IntentResponse, reply string) string {
+	_, known := a.tools.lookup(intent.Intent)
+	switch {
+	case intent.Intent == "escalate":
+		a.misunderstood = 0
+		return ""
+	case intent.parseFailed || !known:
+		a.misunderstood++
+		a.escalationReason = "The agent did not understand the user's requests"
+		if a.misunderstood == escalateAfter {
+			return "\n" + escalationOffer
+		}
+	case intent.Intent == "cancel_ticket" && outcome(reply) != exitOK:
+		a.escalationReason = fmt.Sprintf("Cancellation of %s failed", intent.Parameters["train_id"])
+		return "\n" + escalationOffer
+	case outcome(reply) == exitOK:
+		a.misunderstood = 0
+	}
+	return ""
+}
+
+// escalate files a support case and tells the user its number
+func (a *BookingAgent) escalate(ctx context.Context, subject, bookingRef, userID string) string {
+	user := userID
+	if user == "" {
+		user = a.userID
+	}
+	if subject == "" {
+		subject = a.escalationReason
+	}
+	if subject == "" {
+		subject = "The user asked to talk to a person"
+	}
+	if bookingRef == "" && len(a.lastBookingRefs) > 0 {
+		bookingRef = a.lastBookingRefs[0]
+	}
+
+	number, err := a.provider.OpenSupportCase(ctx, SupportRequest{
+		UserID:      user,
+		Subject:     subject,
+		Description: a.recentConversation(),
+		BookingRef:  strings.ToUpper(bookingRef),
+	})
+	if err != nil {
+		return a.providerError(ctx, "opening support case", err)
+	}
+	a.misunderstood, a.escalationReason = 0, ""
+	return fmt.Sprintf("🆘 I've opened support case %s for you. Our staff will look into it; please quote the case number if you contact us.", number)
+}
+
+// recentConversation is the end of the conversation, for the staff handling
+// a support case
+func (a *BookingAgent) recentConversation() string {
+	history := a.conversationHistory
+	if len(history) > caseHistoryMessages {
+		history = history[len(history)-caseHistoryMessages:]
+	}
+	var lines []string
+	for _, m := range history {
+		lines = append(lines, m.Role+": "+m.Content)
+	}
+	text := strings.Join(lines, "\n")
+	if len(text) > caseHistoryBytes {
+		text = "…" + strings.ToValidUTF8(text[len(text)-caseHistoryBytes:], "")
+	}
+	return text
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// Prompt A/B experiments: every session is assigned one prompt variant by
+// weight, and per-variant counters accumulate in a JSON file across sessions.
+
+// promptVariant is one arm of an experiment
+type promptVariant struct {
+	name   string
+	dir    string // prompt override directory; empty for the built-in prompt
+	weight int
+}
+
+// parsePromptVariants reads "a=builtin,b=./prompts-b:3": name=dir with an
+// optional weight (default 1); "builtin" means the embedded prompt
+func parsePromptVariants(spec string) ([]promptVariant, error) {
+	var variants []promptVariant
+	seen := map[string]bool{}
+	for _, part := range strings.Split(spec, ",") {
+		name, rest, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok || name == "" || rest == "" {
+			return nil, fmt.Errorf("%q is not name=dir[:weight]", part)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("variant %q is listed twice", name)
+		}
+		seen[name] = true
+
+		v := promptVariant{name: name, dir: rest, weight: 1}
+		if i := strings.LastIndex(rest, ":"); i >= 0 {
+			weight, err := strconv.Atoi(rest[i+1:])
+			if err != nil || weight < 0 {
+				return nil, fmt.Errorf("variant %q: weight %q is not a non-negative number", name, rest[i+1:])
+			}
+			v.dir, v.weight = rest[:i], weight
+		}
+		if v.dir == "builtin" {
+			v.dir = ""
+		}
+		variants = append(variants, v)
+	}
+	return variants, nil
+}
+
+// pickVariant chooses a variant with probability proportional to its weight
+func pickVariant(variants []promptVariant) (promptVariant, error) {
+	total := 0
+	for _, v := range variants {
+		total += v.weight
+	}
+	if total == 0 {
+		return promptVariant{}, errors.New("all variant weights are zero")
+	}
+	n := rand.Intn(total)
+	for _, v := range variants {
+		if n < v.weight {
+			return v, nil
+		}
+		n -= v.weight
+	}
+	return variants[len(variants)-1], nil
+}
+
+// choosePrompts loads the prompt for this session: one variant picked by weight
+// when an experiment is configured, otherwise --prompt-dir or the built-in one
+func choosePrompts(cfg Config, tools *toolRegistry) (*promptSet, *experiment, error) {
+	if cfg.PromptVariants == "" {
+		p, err := loadPrompts(cfg.PromptDir, tools)
+		return p, nil, err
+	}
+
+	variants, err := parsePromptVariants(cfg.PromptVariants)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Load every variant so a broken one fails at startup, not in a later session
+	loaded := map[string]*promptSet{}
+	for _, v := range variants {
+		p, err := loadPrompts(v.dir, tools)
+		if err != nil {
+			return nil, nil, fmt.Errorf("variant %s: %w", v.name, err)
+		}
+		loaded[v.name] = p
+	}
+	v, err := pickVariant(variants)
+	if err != nil {
+		return nil, nil, err
+	}
+	p := loaded[v.name]
+	return p, &experiment{path: cfg.ExperimentFile, variant: v.name, prompt: p.String()}, nil
+}
+
+// variantStats are the counters kept for one variant
+type variantStats struct {
+	Prompt          string `json:"prompt"` // version and hash of the rendered prompt
+	Sessions        int    `json:"sessions"`
+	Turns           int    `json:"turns"`
+	ParseFailures   int    `json:"parse_failures"`
+	Clarifications  int    `json:"clarifications"`
+	BookingAttempts int    `json:"booking_attempts"`
+	BookingSuccess  int    `json:"booking_success"`
+}
+
+// experiment records the session's variant into the shared stats file
+type experiment struct {
+	path    string
+	variant string
+	prompt  string
+
+	mu sync.Mutex // one update at a time within this agent
+}
+
+// turnResult is what one turn contributes to the counters
+type turnResult struct {
+	parseFailed   bool
+	clarified     bool
+	bookingTried  bool
+	bookingWorked bool
+}
+
+func defaultExperimentFile() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "train-booking-agent", "experiments.json")
+}
+
+func loadExperimentStats(path string) (map[string]*variantStats, error) {
+	stats := map[string]*variantStats{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return stats, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &stats); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return stats, nil
+}
+
+// update applies fn to this variant's counters and writes the file back.
+// The rename keeps the file whole if two agents write at once; one update may be lost.
+func (e *experiment) update(fn func(*variantStats)) {
+	if e == nil || e.path == "" {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	stats, err := loadExperimentStats(e.path)
+	if err != nil {
+		logger.Error("reading experiment stats failed", "err", err)
+		return
+	}
+	s := stats[e.variant]
+	if s == nil {
+		s = &variantStats{}
+		stats[e.variant] = s
+	}
+	s.Prompt = e.prompt
+	fn(s)
+
+	data, err := json.MarshalIndent(stats, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(e.path), 0o700)
+	}
+	if err == nil {
+		tmp := e.path + ".tmp"
+		if err = os.WriteFile(tmp, data, 0o600); err == nil {
+			err = os.Rename(tmp, e.path)
+		}
+	}
+	if err != nil {
+		logger.Error("writing experiment stats failed", "err", err)
+	}
+}
+
+func (e *experiment) startSession() {
+	e.update(func(s *variantStats) { s.Sessions++ })
+}
+
+func (e *experiment) recordTurn(r turnResult) {
+	e.update(func(s *variantStats) {
+		s.Turns++
+		if r.parseFailed {
+			s.ParseFailures++
+		}
+		if r.clarified {
+			s.Clarifications++
+		}
+		if r.bookingTried {
+			s.BookingAttempts++
+		}
+		if r.bookingWorked {
+			s.BookingSuccess++
+		}
+	})
+}
+
+// experimentReport is a per-variant table of the accumulated rates
+func experimentReport(path string) (string, error) {
+	stats, err := loadExperimentStats(path)
+	if err != nil {
+		return "", err
+	}
+	if len(stats) == 0 {
+		return "No experiment data in " + path, nil
+	}
+
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	rate := func(n, of int) string {
+		if of == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.1f%%", 100*float64(n)/float64(of))
+	}
+
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VARIANT\tPROMPT\tSESSIONS\tTURNS\tPARSE FAILURES\tCLARIFICATIONS\tBOOKING SUCCESS")
+	for _, name := range names {
+		s := stats[name]
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t%s\t%s (%d/%d)\n", name, s.Prompt, s.Sessions, s.Turns,
+			rate(s.ParseFailures, s.Turns), rate(s.Clarifications, s.Turns),
+			rate(s.BookingSuccess, s.BookingAttempts), s.BookingSuccess, s.BookingAttempts)
+	}
+	w.Flush()
+	return strings.TrimRight(b.String(), "\n"), nil
+}
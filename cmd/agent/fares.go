@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Fare calendar: "when is it cheapest to go from Beijing to Shanghai this
+// month?" shows the lowest fare of each day on the route as a calendar grid,
+// a week per row, and names the cheapest day. Without a month it covers the
+// next 30 days.
+
+// FareCalendarQuery asks for the lowest fares on a route from Start to End
+// (YYYY-MM-DD); empty dates leave the range to the provider
+type FareCalendarQuery struct {
+	From  string
+	To    string
+	Start string
+	End   string
+}
+
+// FareCalendar is the lowest fare of each day on a route
+type FareCalendar struct {
+	From     string    `json:"from"`
+	To       string    `json:"to"`
+	Currency string    `json:"currency,omitempty"` // empty when tickets are unpriced
+	Days     []FareDay `json:"days"`
+}
+
+// FareDay is one day of a fare calendar; TrainID is empty when no train runs
+type FareDay struct {
+	Date       string   `json:"date"`
+	Trains     int      `json:"trains"`
+	LowestFare *float64 `json:"lowest_fare,omitempty"`
+	TrainID    string   `json:"train_id,omitempty"`
+}
+
+var yearMonth = regexp.MustCompile(`^\d{4}-\d{2}$`)
+
+func (a *BookingAgent) registerFareCalendarTool() {
+	err := a.tools.register(&funcTool{
+		name:        "fare_calendar",
+		description: "User asks which day is cheapest to travel on a route, or for the fares over a month",
+		parameters: objectSchema(map[string]string{
+			"from":  "Departure city",
+			"to":    "Destination city",
+			"month": "\"this month\", \"next month\", a month name or YYYY-MM; empty for the next 30 days",
+		}, "to"),
+		run: func(ctx context.Context, p map[string]string) string {
+			return a.fareCalendar(ctx, p)
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// fareCalendar shows the lowest fares on the route asked about
+func (a *BookingAgent) fareCalendar(ctx context.Context, p map[string]string) string {
+	q := FareCalendarQuery{From: strings.TrimSpace(p["from"]), To: strings.TrimSpace(p["to"])}
+	switch {
+	case q.From == "":
+		return fmt.Sprintf("🤔 Where would you travel to %s from?", q.To)
+	case q.To == "":
+		return fmt.Sprintf("🤔 Where would you travel to from %s?", q.From)
+	}
+	start, end, ok := monthRange(p["month"], time.Now())
+	if !ok {
+		return fmt.Sprintf("🤔 Which month? Say \"this month\", \"next month\" or a month such as %s.", time.Now().AddDate(0, 1, 0).Format("January"))
+	}
+	if !start.IsZero() {
+		q.Start, q.End = start.Format(time.DateOnly), end.Format(time.DateOnly)
+	}
+
+	calendar, err := a.provider.FareCalendar(ctx, q)
+	switch {
+	case errors.Is(err, ErrInvalid):
+		return fmt.Sprintf("❌ %v", err)
+	case err != nil:
+		return a.providerError(ctx, "fetching fares", err)
+	}
+	running := 0
+	for _, day := range calendar.Days {
+		if day.TrainID != "" {
+			running++
+		}
+	}
+	if running == 0 {
+		return fmt.Sprintf("❌ No trains with tickets left from %s to %s in those days", q.From, q.To)
+	}
+	if a.accessible {
+		return describeFareCalendar(q, calendar)
+	}
+	return formatFareCalendar(q, calendar)
+}
+
+// monthRange reads the month asked about into its days from today on; zero
+// times for an empty month, ok false for one not understood
+func monthRange(month string, now time.Time) (start, end time.Time, ok bool) {
+	month = strings.ToLower(strings.TrimSpace(month))
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	first := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	switch {
+	case month == "":
+		return time.Time{}, time.Time{}, true
+	case month == "this month":
+	case month == "next month":
+		first = first.AddDate(0, 1, 0)
+	case yearMonth.MatchString(month):
+		t, err := time.Parse("2006-01", month)
+		if err != nil {
+			return time.Time{}, time.Time{}, false
+		}
+		first = t
+	default:
+		t, err := time.Parse("January", strings.ToUpper(month[:1])+month[1:])
+		if err != nil {
+			return time.Time{}, time.Time{}, false
+		}
+		first = time.Date(now.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+		if t.Month() < now.Month() {
+			first = first.AddDate(1, 0, 0)
+		}
+	}
+	end = first.AddDate(0, 1, -1)
+	if end.Before(today) {
+		return time.Time{}, time.Time{}, false
+	}
+	if first.Before(today) {
+		first = today
+	}
+	return first, end, true
+}
+
+// cheapestDays returns the lowest fare on the calendar and the days offering
+// it; no fare when tickets are unpriced
+func cheapestDays(calendar *FareCalendar) (fare *float64, days []FareDay) {
+	for _, day := range calendar.Days {
+		switch {
+		case day.LowestFare == nil:
+		case fare == nil || *day.LowestFare < *fare:
+			fare, days = day.LowestFare, []FareDay{day}
+		case *day.LowestFare == *fare:
+			days = append(days, day)
+		}
+	}
+	return fare, days
+}
+
+// formatFareCalendar draws the calendar a week per row, Monday first. Each
+// day shows its lowest fare, the cheapest marked *, or with unpriced tickets
+// its earliest train; · is a day without trains.
+func formatFareCalendar(q FareCalendarQuery, calendar *FareCalendar) string {
+	const cell = "%-10s"
+	fare, cheapest := cheapestDays(calendar)
+
+	var b strings.Builder
+	title := fmt.Sprintf("💰 Lowest fares from %s to %s", q.From, q.To)
+	if fare == nil {
+		title = fmt.Sprintf("📅 Trains from %s to %s", q.From, q.To)
+	}
+	if n := len(calendar.Days); n > 0 {
+		title += fmt.Sprintf(", %s to %s", calendar.Days[0].Date, calendar.Days[n-1].Date)
+	}
+	if calendar.Currency != "" {
+		title += fmt.Sprintf(" (%s)", calendar.Currency)
+	}
+	b.WriteString(title + ":\n")
+	for _, name := range []string{"Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"} {
+		fmt.Fprintf(&b, cell, name)
+	}
+
+	for i, day := range calendar.Days {
+		date, err := time.Parse(time.DateOnly, day.Date)
+		if err != nil {
+			continue
+		}
+		column := (int(date.Weekday()) + 6) % 7
+		if i == 0 || column == 0 {
+			b.WriteString("\n")
+			if i == 0 {
+				b.WriteString(strings.Repeat(" ", 10*column))
+			}
+		}
+		text := "·"
+		switch {
+		case day.LowestFare != nil:
+			text = fmt.Sprintf("%.0f", *day.LowestFare)
+			if *day.LowestFare == *fare {
+				text += "*"
+			}
+		case day.TrainID != "":
+			text = day.TrainID
+		}
+		fmt.Fprintf(&b, cell, fmt.Sprintf("%2d %s", date.Day(), text))
+	}
+	rows := strings.Split(b.String(), "\n")
+	for i := range rows {
+		rows[i] = strings.TrimRight(rows[i], " ")
+	}
+	grid := strings.Join(rows, "\n")
+
+	if fare == nil {
+		return grid + "\nFares are not priced; each day shows its earliest train."
+	}
+	best := cheapest[0]
+	grid += fmt.Sprintf("\n⭐ Cheapest: %.2f %s on %s (%s)", *fare, calendar.Currency, best.Date, best.TrainID)
+	if len(cheapest) > 1 {
+		grid += fmt.Sprintf(", and %s at that fare", countNoun(len(cheapest)-1, "other day", "other days"))
+	}
+	return grid
+}
+
+// describeFareCalendar says the fare of each day with trains, then the cheapest
+func describeFareCalendar(q FareCalendarQuery, calendar *FareCalendar) string {
+	fare, cheapest := cheapestDays(calendar)
+	lines := []string{fmt.Sprintf("Trains from %s to %s:", q.From, q.To)}
+	for _, day := range calendar.Days {
+		switch {
+		case day.LowestFare != nil:
+			lines = append(lines, fmt.Sprintf("%s, from %.2f %s on train %s.", spokenDate(day.Date), *day.LowestFare, calendar.Currency, day.TrainID))
+		case day.TrainID != "":
+			lines = append(lines, fmt.Sprintf("%s, train %s.", spokenDate(day.Date), day.TrainID))
+		}
+	}
+	if fare != nil {
+		lines = append(lines, fmt.Sprintf("The cheapest day is %s.", spokenDate(cheapest[0].Date)))
+	}
+	return strings.Join(lines, "\n")
+}
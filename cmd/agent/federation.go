@@ -0,0 +1,598 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Federated search: with several booking servers in --server-url, every search
+// goes to all of them at once, and the merged results name the server each
+// train came from. Bookings and cancellations go back to that server.
+
+// serverEndpoint is one --server-url entry, "name=URL" or a URL named by its host
+type serverEndpoint struct {
+	name string
+	url  string
+}
+
+// parseServerURLs reads a comma-separated --server-url
+func parseServerURLs(raw string) ([]serverEndpoint, error) {
+	var endpoints []serverEndpoint
+	seen := map[string]bool{}
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		var e serverEndpoint
+		if i := strings.Index(item, "="); i > 0 && !strings.Contains(item[:i], "://") {
+			e.name, item = item[:i], item[i+1:]
+		}
+		serverURL, err := parseServerURL(item)
+		if err != nil {
+			return nil, err
+		}
+		e.url = serverURL
+		if e.name == "" {
+			u, _ := url.Parse(serverURL)
+			e.name = u.Host
+		}
+		if seen[e.name] {
+			return nil, fmt.Errorf("server %q is listed twice; name them, e.g. east=http://host:8080", e.name)
+		}
+		seen[e.name] = true
+		endpoints = append(endpoints, e)
+	}
+	if len(endpoints) == 0 {
+		return nil, errors.New("no booking server URL")
+	}
+	return endpoints, nil
+}
+
+// formatServerURLs is the normalized --server-url for endpoints
+func formatServerURLs(endpoints []serverEndpoint) string {
+	if len(endpoints) == 1 {
+		return endpoints[0].url
+	}
+	items := make([]string, len(endpoints))
+	for i, e := range endpoints {
+		items[i] = e.name + "=" + e.url
+	}
+	return strings.Join(items, ",")
+}
+
+// openProvider connects the adapter called name to endpoints, federating them
+// when there are several
+func openProvider(name string, endpoints []serverEndpoint, client *http.Client) (ProviderAdapter, error) {
+	if len(endpoints) == 1 {
+		return newProvider(name, endpoints[0].url, client)
+	}
+	f := &federation{kind: name, routes: map[string]route{}, receipts: map[string]int{}}
+	for _, e := range endpoints {
+		p, err := newProvider(name, e.url, client)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", e.name, err)
+		}
+		f.members = append(f.members, member{name: e.name, ProviderAdapter: p})
+	}
+	return f, nil
+}
+
+type member struct {
+	name string
+	ProviderAdapter
+}
+
+// route is where bookings of a train ID go: the member that last offered it
+// with the most tickets available
+type route struct {
+	member    int
+	available int
+}
+
+// federation is a ProviderAdapter over several servers of the same kind
+type federation struct {
+	kind    string
+	members []member
+
+	mu       sync.Mutex
+	routes   map[string]route
+	receipts map[string]int // booking reference -> member that issued it
+}
+
+func (f *federation) Name() string { return f.kind }
+
+// each calls fn for every member at once and returns its errors by member
+func (f *federation) each(fn func(i int, m member) error) []error {
+	errs := make([]error, len(f.members))
+	var wg sync.WaitGroup
+	for i, m := range f.members {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = fn(i, m)
+		}()
+	}
+	wg.Wait()
+	return errs
+}
+
+// failed logs the members that failed doing action and returns an error only
+// when all of them did, so one server being down leaves the others usable
+func (f *federation) failed(action string, errs []error) error {
+	count := 0
+	for i, err := range errs {
+		if err != nil {
+			count++
+			logger.Warn("federated server failed", "server", f.members[i].name, "action", action, "err", err)
+		}
+	}
+	if count == len(errs) {
+		return errs[0]
+	}
+	return nil
+}
+
+// Search merges every member's results. A train offered by several servers,
+// with the same ID, date, route and departure, is listed once, from the
+// server with the most tickets left.
+func (f *federation) Search(ctx context.Context, q SearchQuery) ([]Train, error) {
+	results := make([][]Train, len(f.members))
+	errs := f.each(func(i int, m member) error {
+		trains, err := m.Search(ctx, q)
+		results[i] = trains
+		return err
+	})
+	if err := f.failed("search", errs); err != nil {
+		return nil, err
+	}
+
+	var merged []Train
+	index := map[string]int{}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, m := range f.members {
+		for _, train := range results[i] {
+			train.Provider = m.name
+			if r, ok := f.routes[train.ID]; !ok || r.member == i || train.Available > r.available {
+				f.routes[train.ID] = route{member: i, available: train.Available}
+			}
+
+			key := strings.Join([]string{train.ID, train.Date, train.From, train.To, train.DepartureTime}, "|")
+			if j, ok := index[key]; ok {
+				if train.Available > merged[j].Available {
+					merged[j] = train
+				}
+				continue
+			}
+			index[key] = len(merged)
+			merged = append(merged, train)
+		}
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		if merged[i].ID != merged[j].ID {
+			return merged[i].ID < merged[j].ID
+		}
+		return merged[i].Date < merged[j].Date
+	})
+	return merged, nil
+}
+
+// route finds the member for each train ID, searching for IDs not seen yet
+func (f *federation) route(ctx context.Context, trainIDs []string) ([]int, error) {
+	lookup := func() (members []int, missing []string) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		for _, id := range trainIDs {
+			r, ok := f.routes[id]
+			if !ok {
+				missing = append(missing, id)
+			}
+			members = append(members, r.member)
+		}
+		return
+	}
+
+	members, missing := lookup()
+	if len(missing) > 0 {
+		if _, err := f.Search(ctx, SearchQuery{TrainIDs: missing}); err != nil {
+			return nil, err
+		}
+		if members, missing = lookup(); len(missing) > 0 {
+			return nil, &ProviderError{Kind: ErrTrainNotFound, Message: missing[0] + ": train not found"}
+		}
+	}
+	return members, nil
+}
+
+// Book books each train on its server. An itinerary spanning servers is booked
+// one server at a time, and the legs already booked are cancelled if a later
+// server refuses, so it stays all or nothing.
+func (f *federation) Book(ctx context.Context, req BookingRequest) ([]string, error) {
+	members, err := f.route(ctx, req.TrainIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var order []int
+	legs := map[int][]string{}
+	for i, m := range members {
+		if _, ok := legs[m]; !ok {
+			order = append(order, m)
+		}
+		legs[m] = append(legs[m], req.TrainIDs[i])
+	}
+	if len(order) == 1 {
+		refs, err := f.members[order[0]].Book(ctx, req)
+		f.issued(order[0], refs)
+		return refs, err
+	}
+
+	var refs []string
+	for n, m := range order {
+		part := req
+		part.TrainIDs, part.IdempotencyKey = legs[m], ""
+		if req.IdempotencyKey != "" {
+			// Each server sees its own part, so each gets its own key
+			part.IdempotencyKey = req.IdempotencyKey + "-" + f.members[m].name
+		}
+		booked, err := f.members[m].Book(ctx, part)
+		if err == nil {
+			f.issued(m, booked)
+			refs = append(refs, booked...)
+			continue
+		}
+
+		var stuck []string
+		for _, booked := range order[:n] {
+			for _, id := range legs[booked] {
+				if _, cerr := f.members[booked].Cancel(ctx, CancelRequest{TrainID: id, UserID: req.UserID}); cerr != nil {
+					logger.Error("cannot undo federated booking", "server", f.members[booked].name, "train", id, "err", cerr)
+					stuck = append(stuck, fmt.Sprintf("%s on %s", id, f.members[booked].name))
+				}
+			}
+		}
+		if len(stuck) > 0 {
+			return nil, &ProviderError{Message: fmt.Sprintf("%v; %s could not be cancelled again and stayed booked", err, strings.Join(stuck, ", "))}
+		}
+		return nil, err
+	}
+	return refs, nil
+}
+
+// issued remembers which member issued booking references, for Receipt,
+// Upgrade, OrderMeals, DeclareLuggage and ChooseSeat
+func (f *federation) issued(member int, refs []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, ref := range refs {
+		f.receipts[ref] = member
+	}
+}
+
+// Receipt asks the member that issued ref, or every member in turn for a
+// booking made before the agent started
+func (f *federation) Receipt(ctx context.Context, ref, userID string) (*Receipt, error) {
+	f.mu.Lock()
+	m, ok := f.receipts[ref]
+	f.mu.Unlock()
+	if ok {
+		receipt, err := f.members[m].Receipt(ctx, ref, userID)
+		if receipt != nil {
+			receipt.Provider = f.members[m].name
+		}
+		return receipt, err
+	}
+
+	err := error(&ProviderError{Kind: ErrNoReceipt, Message: fmt.Sprintf("no booking %s", ref)})
+	for i, m := range f.members {
+		receipt, merr := m.Receipt(ctx, ref, userID)
+		if merr == nil {
+			f.issued(i, []string{ref})
+			receipt.Provider = m.name
+			return receipt, nil
+		}
+		if !errors.Is(merr, ErrNoReceipt) {
+			err = merr
+		}
+	}
+	return nil, err
+}
+
+// Upgrade asks the member that issued the booking, found as Receipt finds it
+func (f *federation) Upgrade(ctx context.Context, req UpgradeRequest) (*Upgrade, error) {
+	if _, err := f.Receipt(ctx, req.BookingRef, req.UserID); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	m := f.members[f.receipts[req.BookingRef]]
+	f.mu.Unlock()
+	upgrade, err := m.Upgrade(ctx, req)
+	if upgrade != nil && upgrade.Receipt != nil {
+		upgrade.Receipt.Provider = m.name
+	}
+	return upgrade, err
+}
+
+// Position asks the server that runs the train
+func (f *federation) Position(ctx context.Context, trainID string) (*TrainPosition, error) {
+	members, err := f.route(ctx, []string{trainID})
+	if err != nil {
+		return nil, err
+	}
+	return f.members[members[0]].Position(ctx, trainID)
+}
+
+// FareCalendar asks every server and keeps each day's lowest fare. Fares in
+// another currency than the first server's are left out.
+func (f *federation) FareCalendar(ctx context.Context, q FareCalendarQuery) (*FareCalendar, error) {
+	results := make([]*FareCalendar, len(f.members))
+	errs := f.each(func(i int, m member) error {
+		calendar, err := m.FareCalendar(ctx, q)
+		results[i] = calendar
+		return err
+	})
+	if err := f.failed("fare calendar", errs); err != nil {
+		return nil, err
+	}
+
+	var merged *FareCalendar
+	index := map[string]int{}
+	for _, calendar := range results {
+		if calendar == nil {
+			continue
+		}
+		if merged == nil {
+			merged = &FareCalendar{From: calendar.From, To: calendar.To, Currency: calendar.Currency}
+		}
+		for _, day := range calendar.Days {
+			i, ok := index[day.Date]
+			if !ok {
+				index[day.Date] = len(merged.Days)
+				merged.Days = append(merged.Days, FareDay{Date: day.Date})
+				i = len(merged.Days) - 1
+			}
+			if calendar.Currency != merged.Currency {
+				continue
+			}
+			best := &merged.Days[i]
+			best.Trains += day.Trains
+			if best.TrainID == "" || (day.LowestFare != nil && best.LowestFare != nil && *day.LowestFare < *best.LowestFare) {
+				best.LowestFare, best.TrainID = day.LowestFare, day.TrainID
+			}
+		}
+	}
+	if merged == nil {
+		return &FareCalendar{From: q.From, To: q.To}, nil
+	}
+	sort.Slice(merged.Days, func(i, j int) bool { return merged.Days[i].Date < merged.Days[j].Date })
+	return merged, nil
+}
+
+// Punctuality asks the server that runs the train
+func (f *federation) Punctuality(ctx context.Context, trainID string) (*Punctuality, error) {
+	members, err := f.route(ctx, []string{trainID})
+	if err != nil {
+		return nil, err
+	}
+	return f.members[members[0]].Punctuality(ctx, trainID)
+}
+
+// Menu asks the server that runs the train
+func (f *federation) Menu(ctx context.Context, trainID string) (*Menu, error) {
+	members, err := f.route(ctx, []string{trainID})
+	if err != nil {
+		return nil, err
+	}
+	return f.members[members[0]].Menu(ctx, trainID)
+}
+
+// OrderMeals asks the member that issued the booking, as Upgrade does
+func (f *federation) OrderMeals(ctx context.Context, req MealOrder) (*Receipt, error) {
+	if _, err := f.Receipt(ctx, req.BookingRef, req.UserID); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	m := f.members[f.receipts[req.BookingRef]]
+	f.mu.Unlock()
+	receipt, err := m.OrderMeals(ctx, req)
+	if receipt != nil {
+		receipt.Provider = m.name
+	}
+	return receipt, err
+}
+
+// DeclareLuggage asks the member that issued the booking, as Upgrade does
+func (f *federation) DeclareLuggage(ctx context.Context, req LuggageDeclaration) (*Receipt, error) {
+	if _, err := f.Receipt(ctx, req.BookingRef, req.UserID); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	m := f.members[f.receipts[req.BookingRef]]
+	f.mu.Unlock()
+	receipt, err := m.DeclareLuggage(ctx, req)
+	if receipt != nil {
+		receipt.Provider = m.name
+	}
+	return receipt, err
+}
+
+// SeatMap asks the server that runs the train
+func (f *federation) SeatMap(ctx context.Context, trainID string) (*SeatMap, error) {
+	members, err := f.route(ctx, []string{trainID})
+	if err != nil {
+		return nil, err
+	}
+	return f.members[members[0]].SeatMap(ctx, trainID)
+}
+
+// ChooseSeat asks the member that issued the booking, as Upgrade does
+func (f *federation) ChooseSeat(ctx context.Context, req SeatChoice) (*Receipt, error) {
+	if _, err := f.Receipt(ctx, req.BookingRef, req.UserID); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	m := f.members[f.receipts[req.BookingRef]]
+	f.mu.Unlock()
+	receipt, err := m.ChooseSeat(ctx, req)
+	if receipt != nil {
+		receipt.Provider = m.name
+	}
+	return receipt, err
+}
+
+// Cancel cancels on the server that issued the booking named, else on a
+// server where the user holds a ticket on the train, or else on the server
+// that offers it, which explains the refusal
+func (f *federation) Cancel(ctx context.Context, req CancelRequest) (*Refund, error) {
+	f.mu.Lock()
+	m, ok := f.receipts[req.BookingRef]
+	f.mu.Unlock()
+	if ok {
+		return f.members[m].Cancel(ctx, req)
+	}
+
+	holders := make([]bool, len(f.members))
+	f.each(func(i int, m member) error {
+		bookings, err := m.Status(ctx, req.UserID)
+		for _, booking := range bookings {
+			holders[i] = holders[i] || booking.TrainID == req.TrainID
+		}
+		return err
+	})
+	for i, holds := range holders {
+		if holds {
+			return f.members[i].Cancel(ctx, req)
+		}
+	}
+
+	members, err := f.route(ctx, []string{req.TrainID})
+	if err != nil {
+		return nil, err
+	}
+	return f.members[members[0]].Cancel(ctx, req)
+}
+
+// Status lists the user's tickets on every server
+func (f *federation) Status(ctx context.Context, userID string) ([]UserBooking, error) {
+	results := make([][]UserBooking, len(f.members))
+	errs := f.each(func(i int, m member) error {
+		bookings, err := m.Status(ctx, userID)
+		results[i] = bookings
+		return err
+	})
+	if err := f.failed("status", errs); err != nil {
+		return nil, err
+	}
+
+	var merged []UserBooking
+	for i, m := range f.members {
+		for _, booking := range results[i] {
+			booking.Provider = m.name
+			merged = append(merged, booking)
+		}
+	}
+	return merged, nil
+}
+
+// OpenSupportCase files the case with the server that issued the booking
+// concerned, or else the first server that accepts it
+func (f *federation) OpenSupportCase(ctx context.Context, req SupportRequest) (string, error) {
+	order := make([]int, 0, len(f.members))
+	f.mu.Lock()
+	if m, ok := f.receipts[req.BookingRef]; ok {
+		order = append(order, m)
+	}
+	f.mu.Unlock()
+	for i := range f.members {
+		order = append(order, i)
+	}
+
+	var err error
+	for _, i := range order {
+		var number string
+		if number, err = f.members[i].OpenSupportCase(ctx, req); err == nil {
+			return fmt.Sprintf("%s (%s)", number, f.members[i].name), nil
+		}
+		logger.Warn("federated server failed", "server", f.members[i].name, "action", "support case", "err", err)
+	}
+	return "", err
+}
+
+// SendFeedback sends feedback to the server that issued the booking, or else
+// to the one that runs the train
+func (f *federation) SendFeedback(ctx context.Context, req FeedbackRequest) (string, error) {
+	f.mu.Lock()
+	m, ok := f.receipts[req.BookingRef]
+	f.mu.Unlock()
+	if !ok {
+		if req.TrainID == "" {
+			return "", &ProviderError{Kind: ErrNoReceipt, Message: fmt.Sprintf("no booking %s", req.BookingRef)}
+		}
+		members, err := f.route(ctx, []string{req.TrainID})
+		if err != nil {
+			return "", err
+		}
+		m = members[0]
+	}
+	return f.members[m].SendFeedback(ctx, req)
+}
+
+// TakeNotices gathers the user's notifications from every server
+func (f *federation) TakeNotices(ctx context.Context, userID string) ([]Notice, error) {
+	results := make([][]Notice, len(f.members))
+	errs := f.each(func(i int, m member) error {
+		notices, err := m.TakeNotices(ctx, userID)
+		results[i] = notices
+		return err
+	})
+
+	// Notices taken from the servers that answered are not shown again, so
+	// they are returned even when another server failed
+	var merged []Notice
+	for i, m := range f.members {
+		for _, notice := range results[i] {
+			notice.Provider = m.name
+			merged = append(merged, notice)
+		}
+	}
+	return merged, f.failed("notifications", errs)
+}
+
+// Travelers reads the user's travelers from the first server; SaveTravelers
+// keeps them on every server, so a booking on any of them can name them
+func (f *federation) Travelers(ctx context.Context, userID string) ([]Traveler, error) {
+	return f.members[0].Travelers(ctx, userID)
+}
+
+func (f *federation) SaveTravelers(ctx context.Context, userID string, travelers []Traveler) ([]Traveler, error) {
+	results := make([][]Traveler, len(f.members))
+	errs := f.each(func(i int, m member) error {
+		saved, err := m.SaveTravelers(ctx, userID, travelers)
+		results[i] = saved
+		return err
+	})
+	if err := f.failed("saving travelers", errs); err != nil {
+		return nil, err
+	}
+	for i, err := range errs {
+		if err == nil {
+			return results[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// providerTag marks a train or booking with its server in federated results
+func providerTag(provider string) string {
+	if provider == "" {
+		return ""
+	}
+	return " [" + provider + "]"
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Feedback: "I want to complain about the K300 delay" or "rate my trip 5 stars"
+// is recorded with the booking server, about the train or booking named or
+// else the last booking made in this conversation, for the operator's staff.
+
+func (a *BookingAgent) registerFeedbackTool() {
+	err := a.tools.register(&funcTool{
+		name:        "feedback",
+		description: "User rates a trip or complains about a train or booking (a delay, dirty carriage, rude staff...)",
+		parameters: objectSchema(map[string]string{
+			"train_id":    "Train concerned, such as K300",
+			"booking_ref": "Booking reference concerned, such as BK1A2B3C4D5E",
+			"rating":      "Stars given to the trip, 1 to 5; empty for a complaint",
+			"message":     "The user's feedback in their own words",
+			"user_id":     userIDParam,
+		}),
+		run: func(ctx context.Context, p map[string]string) string {
+			return a.sendFeedback(ctx, p)
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// sendFeedback records a rating or complaint and thanks the user
+func (a *BookingAgent) sendFeedback(ctx context.Context, p map[string]string) string {
+	req := FeedbackRequest{
+		UserID:     p["user_id"],
+		TrainID:    strings.ToUpper(p["train_id"]),
+		BookingRef: strings.ToUpper(p["booking_ref"]),
+		Message:    strings.TrimSpace(p["message"]),
+	}
+	if req.UserID == "" {
+		req.UserID = a.userID
+	}
+	if req.TrainID == "" && req.BookingRef == "" && len(a.lastBookingRefs) > 0 {
+		req.BookingRef = a.lastBookingRefs[0]
+	}
+	if req.TrainID == "" && req.BookingRef == "" {
+		return "🤔 Which trip is this about? Please give the train ID, such as K300, or the booking reference."
+	}
+	if p["rating"] != "" {
+		rating, err := strconv.Atoi(strings.TrimSpace(p["rating"]))
+		if err != nil || rating < 1 || rating > 5 {
+			return "❌ Error: a rating is 1 to 5 stars"
+		}
+		req.Rating = rating
+	}
+	if req.Rating == 0 && req.Message == "" {
+		// A complaint is recorded in the user's own words
+		req.Message = a.lastUserMessage()
+	}
+
+	id, err := a.provider.SendFeedback(ctx, req)
+	if err != nil {
+		return a.providerError(ctx, "sending feedback", err)
+	}
+	about := req.TrainID
+	if about == "" {
+		about = "booking " + req.BookingRef
+	}
+	if req.Rating > 0 {
+		return fmt.Sprintf("⭐ Thanks for rating your trip on %s %d/5 (feedback %s).", about, req.Rating, id)
+	}
+	return fmt.Sprintf("📝 Sorry about that. I've recorded your complaint about %s as %s, and our staff will look into it.", about, id)
+}
+
+// lastUserMessage is the message being answered
+func (a *BookingAgent) lastUserMessage() string {
+	for i := len(a.conversationHistory) - 1; i >= 0; i-- {
+		if m := a.conversationHistory[i]; m.Role == "user" {
+			return m.Content
+		}
+	}
+	return ""
+}
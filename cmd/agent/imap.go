@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A minimal IMAP4rev1 client, enough to read new mail: log in, select a
+// mailbox, find the unseen messages and fetch them, which marks them seen.
+
+type imapClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+	tag  int
+}
+
+// dialIMAP connects to an imaps:// (TLS, port 993 by default) or, on this
+// machine, an imap:// (plain, port 143) address
+func dialIMAP(server string) (*imapClient, error) {
+	u, err := url.Parse(server)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Host
+	var conn net.Conn
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	switch u.Scheme {
+	case "imaps":
+		if u.Port() == "" {
+			host = net.JoinHostPort(host, "993")
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, &tls.Config{ServerName: u.Hostname()})
+	case "imap":
+		// LOGIN would send the password in the clear
+		if ip := net.ParseIP(u.Hostname()); u.Hostname() != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			return nil, fmt.Errorf("%q: imap:// is only for localhost; use imaps://", server)
+		}
+		if u.Port() == "" {
+			host = net.JoinHostPort(host, "143")
+		}
+		conn, err = dialer.Dial("tcp", host)
+	default:
+		return nil, fmt.Errorf("%q: want an imaps:// or imap:// address", server)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &imapClient{conn: conn, r: bufio.NewReader(conn)}
+	c.conn.SetDeadline(time.Now().Add(time.Minute))
+	greeting, _, err := c.readResponse()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !strings.HasPrefix(greeting, "* OK") && !strings.HasPrefix(greeting, "* PREAUTH") {
+		conn.Close()
+		return nil, fmt.Errorf("imap: unexpected greeting %q", greeting)
+	}
+	return c, nil
+}
+
+// readResponse reads one response line, with the literals ({n} and n bytes)
+// it carries
+func (c *imapClient) readResponse() (string, [][]byte, error) {
+	var line strings.Builder
+	var literals [][]byte
+	for {
+		part, err := c.r.ReadString('\n')
+		if err != nil {
+			return "", nil, err
+		}
+		part = strings.TrimRight(part, "\r\n")
+		line.WriteString(part)
+		open := strings.LastIndex(part, "{")
+		if open < 0 || !strings.HasSuffix(part, "}") {
+			return line.String(), literals, nil
+		}
+		n, err := strconv.Atoi(part[open+1 : len(part)-1])
+		if err != nil {
+			return line.String(), literals, nil
+		}
+		literal := make([]byte, n)
+		if _, err := io.ReadFull(c.r, literal); err != nil {
+			return "", nil, err
+		}
+		literals = append(literals, literal)
+	}
+}
+
+// command runs an IMAP command, returning its untagged responses and their
+// literals
+func (c *imapClient) command(format string, args ...any) ([]string, [][]byte, error) {
+	c.tag++
+	tag := fmt.Sprintf("a%d", c.tag)
+	c.conn.SetDeadline(time.Now().Add(time.Minute))
+	if _, err := fmt.Fprintf(c.conn, "%s %s\r\n", tag, fmt.Sprintf(format, args...)); err != nil {
+		return nil, nil, err
+	}
+	var untagged []string
+	var literals [][]byte
+	for {
+		line, lits, err := c.readResponse()
+		if err != nil {
+			return nil, nil, err
+		}
+		if rest, ok := strings.CutPrefix(line, tag+" "); ok {
+			if !strings.HasPrefix(rest, "OK") {
+				return nil, nil, fmt.Errorf("imap: %s", rest)
+			}
+			return untagged, literals, nil
+		}
+		untagged = append(untagged, line)
+		literals = append(literals, lits...)
+	}
+}
+
+// imapQuote makes s an IMAP quoted string
+func imapQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func (c *imapClient) login(user, password string) error {
+	_, _, err := c.command("LOGIN %s %s", imapQuote(user), imapQuote(password))
+	return err
+}
+
+func (c *imapClient) selectMailbox(name string) error {
+	_, _, err := c.command("SELECT %s", imapQuote(name))
+	return err
+}
+
+// unseen returns the UIDs of the messages not yet seen
+func (c *imapClient) unseen() ([]string, error) {
+	lines, _, err := c.command("UID SEARCH UNSEEN")
+	if err != nil {
+		return nil, err
+	}
+	var uids []string
+	for _, line := range lines {
+		if rest, ok := strings.CutPrefix(line, "* SEARCH"); ok {
+			uids = append(uids, strings.Fields(rest)...)
+		}
+	}
+	return uids, nil
+}
+
+// fetch returns the raw message with uid, marking it seen
+func (c *imapClient) fetch(uid string) ([]byte, error) {
+	_, literals, err := c.command("UID FETCH %s BODY[]", uid)
+	if err != nil {
+		return nil, err
+	}
+	if len(literals) == 0 {
+		return nil, fmt.Errorf("imap: message %s not found", uid)
+	}
+	return literals[0], nil
+}
+
+func (c *imapClient) logout() {
+	c.command("LOGOUT")
+	c.conn.Close()
+}
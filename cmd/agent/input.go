@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/chzyer/readline"
+)
+
+// lineReader reads one user message at a time; io.EOF ends the conversation
+type lineReader interface {
+	ReadLine() (string, error)
+	SetPrompt(prompt string)
+	Close() error
+}
+
+// defaultHistoryFile is the input history in the user's cache directory
+func defaultHistoryFile() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "train-booking-agent", "history")
+}
+
+// newLineReader offers line editing, history and Ctrl-R search on a terminal and
+// falls back to plain line reading when stdin is piped
+func newLineReader(cfg Config) (lineReader, error) {
+	if !readline.IsTerminal(int(os.Stdin.Fd())) {
+		return &scannerReader{scanner: bufio.NewScanner(os.Stdin), prompt: "You: "}, nil
+	}
+
+	historyFile := cfg.HistoryFile
+	if historyFile == "off" {
+		historyFile = ""
+	}
+	if historyFile != "" {
+		if err := os.MkdirAll(filepath.Dir(historyFile), 0o700); err != nil {
+			return nil, err
+		}
+	}
+
+	rl, err := readline.NewEx(&readline.Config{
+		Prompt:            "You: ",
+		HistoryFile:       historyFile,
+		HistoryLimit:      500,
+		HistorySearchFold: true,
+		InterruptPrompt:   "^C",
+		EOFPrompt:         "quit",
+	})
+	if err != nil {
+		return nil, err
+	}
+	if historyFile != "" {
+		os.Chmod(historyFile, 0o600)
+	}
+	return &readlineReader{rl: rl}, nil
+}
+
+type readlineReader struct {
+	rl *readline.Instance
+}
+
+// ReadLine treats Ctrl-C as "clear the current line" and Ctrl-D as end of input
+func (r *readlineReader) ReadLine() (string, error) {
+	for {
+		line, err := r.rl.Readline()
+		if errors.Is(err, readline.ErrInterrupt) {
+			if line == "" {
+				fmt.Fprintln(out, "💡 Type 'quit' or press Ctrl-D to exit")
+			}
+			continue
+		}
+		return line, err
+	}
+}
+
+func (r *readlineReader) SetPrompt(prompt string) { r.rl.SetPrompt(prompt) }
+
+func (r *readlineReader) Close() error { return r.rl.Close() }
+
+type scannerReader struct {
+	scanner *bufio.Scanner
+	prompt  string
+}
+
+func (r *scannerReader) ReadLine() (string, error) {
+	fmt.Fprint(notices, r.prompt)
+	if !r.scanner.Scan() {
+		if err := r.scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return r.scanner.Text(), nil
+}
+
+func (r *scannerReader) SetPrompt(prompt string) { r.prompt = prompt }
+
+func (r *scannerReader) Close() error { return nil }
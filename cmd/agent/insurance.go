@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Insurance: "book G100 with insurance" insures the tickets booked, where the
+// provider offers travel insurance. Cancelling a ticket names its booking, so
+// the provider refunds it by the receipt: insured tickets get more back.
+
+const insuranceParam = "\"yes\" to insure every ticket against cancellation; empty otherwise"
+
+// saidYes reads a yes-or-no parameter, such as insurance
+func saidYes(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "yes", "true", "y", "1":
+		return true
+	}
+	return false
+}
+
+// formatRefund is appended to a cancellation's reply: what was refunded,
+// line by line; empty when the provider made no refund or prices nothing
+func (a *BookingAgent) formatRefund(refund *Refund) string {
+	if refund == nil || refund.Currency == "" {
+		return ""
+	}
+	money := func(amount float64) string { return fmt.Sprintf("%.2f", amount) }
+	insured := ""
+	if refund.Insured {
+		insured = " (insured)"
+	}
+	if a.accessible {
+		var lines []string
+		for _, line := range refund.Lines {
+			lines = append(lines, fmt.Sprintf("%s %s", line.Name, money(line.Amount)))
+		}
+		return fmt.Sprintf("\nRefund%s: %s %s, made up of %s.", insured, money(refund.Amount), refund.Currency, strings.Join(lines, ", "))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "\n💸 Refund%s: %s %s", insured, refund.Currency, money(refund.Amount))
+	for _, line := range refund.Lines {
+		fmt.Fprintf(&b, "\n    %s: %s", line.Name, money(line.Amount))
+	}
+	return b.String()
+}
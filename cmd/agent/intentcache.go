@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Intent cache: repeats of a message skip DeepSeek. Within a session messages
+// match after normalization; the file on disk only matches exact repeats.
+
+// Most entries kept in the cache file; the least recently used go first
+const maxSavedIntents = 200
+
+// Read-only intents; a repeated booking or cancellation always goes to DeepSeek
+var cacheableIntents = map[string]bool{
+	"list_trains":   true,
+	"query_ticket":  true,
+	"search_trains": true,
+	"my_tickets":    true,
+}
+
+type cachedIntent struct {
+	Intent IntentResponse `json:"intent"`
+	Used   time.Time      `json:"used"`
+}
+
+// intentCacheFile is the cache on disk. It is dropped when the prompt changes,
+// since a new prompt may read the same message differently.
+type intentCacheFile struct {
+	Prompt  string                   `json:"prompt"`
+	Entries map[string]*cachedIntent `json:"entries"`
+}
+
+type intentCache struct {
+	path   string // empty keeps the cache in memory only
+	prompt string
+
+	mu      sync.Mutex // channel users' conversations share the cache
+	session map[string]IntentResponse
+	saved   map[string]*cachedIntent
+	hits    int
+}
+
+// defaultIntentCacheFile is the intent cache in the user's cache directory
+func defaultIntentCacheFile() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "train-booking-agent", "intents.json")
+}
+
+// newIntentCache loads the cache file for prompt. A missing, unreadable or
+// outdated file starts an empty cache.
+func newIntentCache(path string, prompt *promptSet) *intentCache {
+	c := &intentCache{
+		path:    path,
+		prompt:  prompt.String(),
+		session: map[string]IntentResponse{},
+		saved:   map[string]*cachedIntent{},
+	}
+	if path == "" {
+		return c
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			logger.Warn("reading intent cache failed", "err", err)
+		}
+		return c
+	}
+	var file intentCacheFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		logger.Warn("intent cache is corrupt, starting over", "path", path, "err", err)
+		return c
+	}
+	if file.Prompt == c.prompt && file.Entries != nil {
+		c.saved = file.Entries
+	}
+	return c
+}
+
+// normalizeUtterance folds case, spacing and trailing punctuation
+func normalizeUtterance(s string) string {
+	s = strings.Join(strings.Fields(strings.ToLower(s)), " ")
+	return strings.TrimRight(s, ".!?。！？ ")
+}
+
+// Entries are per user: "my tickets" means something else after /switch
+func cacheKey(userID, text string) string {
+	return userID + "\x00" + text
+}
+
+// lookup returns a copy of the intent cached for input, if any
+func (c *intentCache) lookup(userID, input string) (*IntentResponse, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	intent, ok := c.session[cacheKey(userID, normalizeUtterance(input))]
+	if !ok {
+		entry, found := c.saved[cacheKey(userID, input)]
+		if !found {
+			return nil, false
+		}
+		entry.Used = time.Now()
+		c.save()
+		intent = entry.Intent
+	}
+	c.hits++
+	return copyIntent(intent), true
+}
+
+// store caches intent for input if it can be reused: a read-only action whose
+// parameters all come from the message itself, not from earlier turns
+func (c *intentCache) store(userID, input string, intent *IntentResponse) {
+	if c == nil || intent.parseFailed || intent.ClarifyQuestion != "" || !cacheableIntents[intent.Intent] {
+		return
+	}
+	lower := strings.ToLower(input)
+	for _, v := range intent.Parameters {
+		if !strings.Contains(lower, strings.ToLower(v)) {
+			return
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.session[cacheKey(userID, normalizeUtterance(input))] = *copyIntent(*intent)
+	c.saved[cacheKey(userID, input)] = &cachedIntent{Intent: *copyIntent(*intent), Used: time.Now()}
+	c.save()
+}
+
+// save writes the cache file, keeping the most recently used entries
+func (c *intentCache) save() {
+	if c.path == "" {
+		return
+	}
+	if len(c.saved) > maxSavedIntents {
+		keys := make([]string, 0, len(c.saved))
+		for k := range c.saved {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool { return c.saved[keys[i]].Used.After(c.saved[keys[j]].Used) })
+		for _, k := range keys[maxSavedIntents:] {
+			delete(c.saved, k)
+		}
+	}
+
+	data, err := json.MarshalIndent(intentCacheFile{Prompt: c.prompt, Entries: c.saved}, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(c.path), 0o700)
+	}
+	if err == nil {
+		tmp := c.path + ".tmp"
+		if err = os.WriteFile(tmp, data, 0o600); err == nil {
+			err = os.Rename(tmp, c.path)
+		}
+	}
+	if err != nil {
+		logger.Error("writing intent cache failed", "err", err)
+	}
+}
+
+func copyIntent(intent IntentResponse) *IntentResponse {
+	params := make(map[string]string, len(intent.Parameters))
+	for k, v := range intent.Parameters {
+		params[k] = v
+	}
+	intent.Parameters = params
+	return &intent
+}
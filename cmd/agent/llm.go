@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// llmSettings are the model and sampling settings of the agent's LLM calls,
+// which read intents from messages
+type llmSettings struct {
+	Model       string
+	Temperature float64 // 0-2; negative leaves the API's default
+	TopP        float64 // 0-1; 0 leaves the API's default
+	MaxTokens   int     // longest reply; 0 leaves the API's default
+}
+
+// llmProvider is a chat completions API and its default settings
+type llmProvider struct {
+	Name     string // as shown to users
+	URL      string
+	KeyEnv   string // environment variable with the API key; "" for the DeepSeek key
+	Defaults llmSettings
+}
+
+// Intent extraction wants the same reading of the same message, so sampling
+// is nearly greedy, and a short reply: the intent JSON
+var llmProviders = map[string]llmProvider{
+	"deepseek": {
+		Name:     "DeepSeek",
+		URL:      "https://api.deepseek.com/v1/chat/completions",
+		Defaults: llmSettings{Model: "deepseek-chat", Temperature: 0, MaxTokens: 1024},
+	},
+	"openai": {
+		Name:     "OpenAI",
+		URL:      "https://api.openai.com/v1/chat/completions",
+		KeyEnv:   "OPENAI_API_KEY",
+		Defaults: llmSettings{Model: "gpt-4o-mini", Temperature: 0, MaxTokens: 1024},
+	},
+}
+
+// The provider the agent calls unless --llm-chain says otherwise
+const defaultLLMProvider = "deepseek"
+
+// llmBackend is one model of the fallback chain, at its provider
+type llmBackend struct {
+	Provider string
+	llmSettings
+	url string
+	key string
+}
+
+func (b llmBackend) name() string {
+	return llmProviders[b.Provider].Name
+}
+
+// chainEntry is one entry of --llm-chain: "provider:model", a provider for
+// its default model, or a DeepSeek model
+type chainEntry struct {
+	provider, model string
+}
+
+func parseLLMChain(s string) ([]chainEntry, error) {
+	var entries []chainEntry
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		e := chainEntry{provider: defaultLLMProvider, model: field}
+		if provider, model, ok := strings.Cut(field, ":"); ok {
+			e = chainEntry{provider: provider, model: model}
+		} else if _, ok := llmProviders[field]; ok {
+			e = chainEntry{provider: field}
+		}
+		if _, ok := llmProviders[e.provider]; !ok {
+			return nil, fmt.Errorf("unknown provider %q in %q", e.provider, field)
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no models")
+	}
+	return entries, nil
+}
+
+// llmChainFor is the models the agent tries in turn, the first one
+// answering: --llm-chain, or the default provider with --llm-model.
+// deepSeekKey is the key loaded for DeepSeek; other providers read theirs
+// from the environment.
+func llmChainFor(cfg Config, deepSeekKey string) ([]llmBackend, error) {
+	entries := []chainEntry{{provider: defaultLLMProvider, model: cfg.LLMModel}}
+	if cfg.LLMChain != "" {
+		var err error
+		if entries, err = parseLLMChain(cfg.LLMChain); err != nil {
+			return nil, err
+		}
+	}
+	var chain []llmBackend
+	for _, e := range entries {
+		p := llmProviders[e.provider]
+		b := llmBackend{Provider: e.provider, llmSettings: llmSettingsFor(e.provider, cfg), url: p.URL, key: deepSeekKey}
+		if e.model != "" {
+			b.Model = e.model
+		}
+		if p.KeyEnv != "" {
+			if b.key = os.Getenv(p.KeyEnv); b.key == "" {
+				return nil, fmt.Errorf("%s is in the chain but %s is not set", p.Name, p.KeyEnv)
+			}
+		}
+		chain = append(chain, b)
+	}
+	return chain, nil
+}
+
+// defaultLLMChain is the default provider's default model
+func defaultLLMChain(apiKey string) []llmBackend {
+	p := llmProviders[defaultLLMProvider]
+	return []llmBackend{{Provider: defaultLLMProvider, llmSettings: p.Defaults, url: p.URL, key: apiKey}}
+}
+
+// llmSettingsFor is provider's defaults overridden by the settings cfg
+// gives; an empty model or a negative number keeps the default
+func llmSettingsFor(provider string, cfg Config) llmSettings {
+	s := llmProviders[provider].Defaults
+	if cfg.LLMModel != "" {
+		s.Model = cfg.LLMModel
+	}
+	if cfg.LLMTemperature >= 0 {
+		s.Temperature = cfg.LLMTemperature
+	}
+	if cfg.LLMTopP >= 0 {
+		s.TopP = cfg.LLMTopP
+	}
+	if cfg.LLMMaxTokens >= 0 {
+		s.MaxTokens = cfg.LLMMaxTokens
+	}
+	return s
+}
+
+func validateLLMSettings(cfg Config) error {
+	switch {
+	case cfg.LLMTemperature > 2:
+		return fmt.Errorf("invalid --llm-temperature %g: want 0 to 2", cfg.LLMTemperature)
+	case cfg.LLMTopP > 1:
+		return fmt.Errorf("invalid --llm-top-p %g: want 0 to 1", cfg.LLMTopP)
+	case cfg.LLMChain != "" && cfg.LLMModel != "":
+		return fmt.Errorf("--llm-model and --llm-chain both choose models; name the models in --llm-chain")
+	}
+	if cfg.LLMChain != "" {
+		if _, err := parseLLMChain(cfg.LLMChain); err != nil {
+			return fmt.Errorf("invalid --llm-chain: %v", err)
+		}
+	}
+	return nil
+}
+
+// request is the chat completions request for messages with these settings
+func (s llmSettings) request(messages []Message) ChatRequest {
+	req := ChatRequest{Model: s.Model, Messages: messages, MaxTokens: s.MaxTokens}
+	if s.Temperature >= 0 {
+		t := s.Temperature
+		req.Temperature = &t
+	}
+	if s.TopP > 0 {
+		p := s.TopP
+		req.TopP = &p
+	}
+	return req
+}
@@ -0,0 +1,636 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// localServer is the ProviderAdapter for the booking server in cmd/server
+type localServer struct {
+	baseURL string
+	client  *http.Client
+
+	// Last /list response, revalidated with its ETag
+	mu        sync.Mutex
+	listCache []Train
+	listETag  string
+}
+
+func newLocalServer(endpoint string, client *http.Client) (ProviderAdapter, error) {
+	return &localServer{baseURL: endpoint, client: client}, nil
+}
+
+func (s *localServer) Name() string { return "local" }
+
+// do sends a GET request that ends with ctx, with extra headers
+func (s *localServer) do(ctx context.Context, url string, header map[string]string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	return s.client.Do(req)
+}
+
+// post sends body as JSON in a POST request that ends with ctx
+func (s *localServer) post(ctx context.Context, url string, body any) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return s.client.Do(req)
+}
+
+// refusal turns an unsuccessful response into a ProviderError; a 409 Conflict
+// means conflict, and a 410 Gone with the code booking_closed a train past
+// its booking cutoff
+func refusal(resp *http.Response, conflict error) error {
+	var kind error
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		kind = ErrTrainNotFound
+	case http.StatusConflict:
+		kind = conflict
+	case http.StatusGone:
+		// An expired hold, unless the code says booking closed
+	case http.StatusForbidden:
+		kind = ErrNotAllowed
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		kind = ErrInvalid
+	case http.StatusTooManyRequests:
+		kind = ErrThrottled
+	default:
+		return &ProviderError{Message: resp.Status}
+	}
+	// The server explains, e.g. "K300: no tickets available", or answers
+	// JSON with a code or the fields it turned down
+	body, _ := io.ReadAll(resp.Body)
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == "application/json" {
+		var refused struct {
+			Error     string       `json:"error"`
+			Code      string       `json:"code"`
+			TrainID   string       `json:"train_id"`
+			Fields    []FieldError `json:"fields"`
+			Challenge *Challenge   `json:"challenge"`
+		}
+		if json.Unmarshal(body, &refused) == nil && refused.Error != "" {
+			switch refused.Code {
+			case "train_limit":
+				kind = ErrTrainLimit
+			case "verification_required":
+				kind = ErrVerificationRequired
+			case "booking_closed":
+				kind = ErrDeparted
+			}
+			return &ProviderError{Kind: kind, Message: refused.Error, TrainID: refused.TrainID, Fields: refused.Fields, Challenge: refused.Challenge}
+		}
+	}
+	return &ProviderError{Kind: kind, Message: strings.TrimSpace(string(body))}
+}
+
+// decode reads a successful JSON response into v
+func decode(resp *http.Response, v any) error {
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return &ProviderError{Message: fmt.Sprintf("cannot decode response: %v", err)}
+	}
+	return nil
+}
+
+func (s *localServer) Search(ctx context.Context, q SearchQuery) ([]Train, error) {
+	if len(q.TrainIDs) > 0 {
+		trains, err := s.lookup(ctx, q.TrainIDs)
+		if err != nil {
+			return nil, err
+		}
+		// The server looks trains up by ID only
+		var matching []Train
+		for _, train := range trains {
+			if (q.From == "" || strings.EqualFold(train.From, q.From)) &&
+				(q.To == "" || strings.EqualFold(train.To, q.To)) &&
+				(q.Date == "" || train.Date == q.Date) &&
+				(!q.Pets || train.PetsAllowed) {
+				matching = append(matching, train)
+			}
+		}
+		return matching, nil
+	}
+	if q.From == "" && q.To == "" && q.Date == "" && !q.Pets {
+		return s.list(ctx)
+	}
+
+	query := url.Values{}
+	if q.From != "" {
+		query.Set("from", q.From)
+	}
+	if q.To != "" {
+		query.Set("to", q.To)
+	}
+	if q.Date != "" {
+		query.Set("date", q.Date)
+	}
+	if q.Pets {
+		query.Set("pets_allowed", "true")
+	}
+	resp, err := s.do(ctx, fmt.Sprintf("%s/v1/tickets?%s", s.baseURL, query.Encode()), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, refusal(resp, nil)
+	}
+	var trains []Train
+	if err := decode(resp, &trains); err != nil {
+		return nil, err
+	}
+	return trains, nil
+}
+
+// list fetches every train, reusing the cached list when it has not changed
+func (s *localServer) list(ctx context.Context) ([]Train, error) {
+	header := map[string]string{}
+	s.mu.Lock()
+	cached, etag := s.listCache, s.listETag
+	s.mu.Unlock()
+	if etag != "" {
+		header["If-None-Match"] = etag
+	}
+	resp, err := s.do(ctx, fmt.Sprintf("%s/v1/list", s.baseURL), header)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return cached, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, refusal(resp, nil)
+	}
+
+	var trains []Train
+	if err := decode(resp, &trains); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.listCache, s.listETag = trains, resp.Header.Get("ETag")
+	s.mu.Unlock()
+	return trains, nil
+}
+
+// lookup fetches trains by ID, one with /query and several with /query/batch
+func (s *localServer) lookup(ctx context.Context, trainIDs []string) ([]Train, error) {
+	if len(trainIDs) == 1 {
+		resp, err := s.do(ctx, fmt.Sprintf("%s/v1/query?id=%s", s.baseURL, url.QueryEscape(trainIDs[0])), nil)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, refusal(resp, nil)
+		}
+		var train Train
+		if err := decode(resp, &train); err != nil {
+			return nil, err
+		}
+		return []Train{train}, nil
+	}
+
+	resp, err := s.do(ctx, fmt.Sprintf("%s/v1/query/batch?ids=%s", s.baseURL, url.QueryEscape(strings.Join(trainIDs, ","))), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, refusal(resp, nil)
+	}
+	var batch struct {
+		Trains []Train `json:"trains"`
+	}
+	if err := decode(resp, &batch); err != nil {
+		return nil, err
+	}
+	return batch.Trains, nil
+}
+
+func (s *localServer) Book(ctx context.Context, req BookingRequest) ([]string, error) {
+	u := fmt.Sprintf("%s/v1/book?id=%s&user_id=%s", s.baseURL, url.QueryEscape(req.TrainIDs[0]), url.QueryEscape(req.UserID))
+	if len(req.TrainIDs) > 1 || len(req.Travelers) > 0 {
+		u = fmt.Sprintf("%s/v1/book/batch?ids=%s&user_id=%s", s.baseURL, url.QueryEscape(strings.Join(req.TrainIDs, ",")), url.QueryEscape(req.UserID))
+	}
+	if len(req.Travelers) > 0 {
+		u += "&travelers=" + url.QueryEscape(strings.Join(req.Travelers, ","))
+	}
+	if req.Insurance {
+		u += "&insurance=true"
+	}
+	if req.Pet {
+		u += "&pet=true"
+	}
+	if req.Wheelchair {
+		u += "&accessible=true"
+	}
+	debugLog.Printf("Booking trains %q, request URL %q", req.TrainIDs, u)
+
+	header := map[string]string{}
+	if req.IdempotencyKey != "" {
+		header["Idempotency-Key"] = req.IdempotencyKey
+	}
+	if req.Challenge != nil {
+		header["X-Challenge-ID"] = req.Challenge.ID
+		header["X-Challenge-Answer"] = req.Challenge.Answer
+	}
+	resp, err := s.do(ctx, u, header)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, refusal(resp, ErrSoldOut)
+	}
+	// The booking stands even if its reference cannot be read
+	var booked struct {
+		BookingRef string `json:"booking_ref"`
+	}
+	if err := decode(resp, &booked); err != nil || booked.BookingRef == "" {
+		return nil, nil
+	}
+	return []string{booked.BookingRef}, nil
+}
+
+func (s *localServer) Cancel(ctx context.Context, req CancelRequest) (*Refund, error) {
+	u := fmt.Sprintf("%s/v1/cancel?id=%s&user_id=%s", s.baseURL, url.QueryEscape(req.TrainID), url.QueryEscape(req.UserID))
+	if req.BookingRef != "" {
+		u += "&booking_ref=" + url.QueryEscape(req.BookingRef)
+	}
+	resp, err := s.do(ctx, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err := refusal(resp, ErrNoBooking)
+		var refused *ProviderError
+		if req.BookingRef != "" && errors.As(err, &refused) && refused.Message == "booking not found" {
+			refused.Kind, refused.Message = ErrNoReceipt, fmt.Sprintf("no booking %s with a ticket on %s", req.BookingRef, req.TrainID)
+		}
+		return nil, err
+	}
+	// The ticket is cancelled even if its refund cannot be read
+	var cancelled struct {
+		Refund   *Refund `json:"refund"`
+		Currency string  `json:"currency"`
+	}
+	if err := decode(resp, &cancelled); err != nil || cancelled.Refund == nil {
+		return nil, nil
+	}
+	cancelled.Refund.Currency = cancelled.Currency
+	return cancelled.Refund, nil
+}
+
+func (s *localServer) Receipt(ctx context.Context, ref, userID string) (*Receipt, error) {
+	resp, err := s.do(ctx, fmt.Sprintf("%s/v1/booking/%s/receipt?user_id=%s", s.baseURL, url.PathEscape(ref), url.QueryEscape(userID)), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, &ProviderError{Kind: ErrNoReceipt, Message: fmt.Sprintf("no booking %s", ref)}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, refusal(resp, nil)
+	}
+	var receipt Receipt
+	if err := decode(resp, &receipt); err != nil {
+		return nil, err
+	}
+	return &receipt, nil
+}
+
+func (s *localServer) Upgrade(ctx context.Context, req UpgradeRequest) (*Upgrade, error) {
+	resp, err := s.post(ctx, fmt.Sprintf("%s/v1/booking/%s/upgrade", s.baseURL, url.PathEscape(req.BookingRef)), map[string]string{
+		"user_id":  req.UserID,
+		"train_id": req.TrainID,
+		"class":    req.Class,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, &ProviderError{Kind: ErrNoReceipt, Message: fmt.Sprintf("no booking %s", req.BookingRef)}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, refusal(resp, nil)
+	}
+	var upgrade Upgrade
+	if err := decode(resp, &upgrade); err != nil {
+		return nil, err
+	}
+	return &upgrade, nil
+}
+
+func (s *localServer) Menu(ctx context.Context, trainID string) (*Menu, error) {
+	resp, err := s.do(ctx, fmt.Sprintf("%s/v1/trains/%s/menu", s.baseURL, url.PathEscape(trainID)), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, refusal(resp, nil)
+	}
+	var menu Menu
+	if err := decode(resp, &menu); err != nil {
+		return nil, err
+	}
+	return &menu, nil
+}
+
+func (s *localServer) Position(ctx context.Context, trainID string) (*TrainPosition, error) {
+	resp, err := s.do(ctx, fmt.Sprintf("%s/v1/trains/%s/position", s.baseURL, url.PathEscape(trainID)), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, refusal(resp, nil)
+	}
+	var position TrainPosition
+	if err := decode(resp, &position); err != nil {
+		return nil, err
+	}
+	return &position, nil
+}
+
+func (s *localServer) FareCalendar(ctx context.Context, q FareCalendarQuery) (*FareCalendar, error) {
+	query := url.Values{"from": {q.From}, "to": {q.To}}
+	if q.Start != "" {
+		query.Set("start", q.Start)
+	}
+	if q.End != "" {
+		query.Set("end", q.End)
+	}
+	resp, err := s.do(ctx, s.baseURL+"/v1/fares/calendar?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, refusal(resp, nil)
+	}
+	var calendar FareCalendar
+	if err := decode(resp, &calendar); err != nil {
+		return nil, err
+	}
+	return &calendar, nil
+}
+
+func (s *localServer) Punctuality(ctx context.Context, trainID string) (*Punctuality, error) {
+	resp, err := s.do(ctx, fmt.Sprintf("%s/v1/trains/%s/punctuality", s.baseURL, url.PathEscape(trainID)), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, refusal(resp, nil)
+	}
+	var punctuality Punctuality
+	if err := decode(resp, &punctuality); err != nil {
+		return nil, err
+	}
+	return &punctuality, nil
+}
+
+func (s *localServer) OrderMeals(ctx context.Context, req MealOrder) (*Receipt, error) {
+	meals := req.Meals
+	if meals == nil {
+		meals = []MealChoice{}
+	}
+	resp, err := s.post(ctx, fmt.Sprintf("%s/v1/booking/%s/meals", s.baseURL, url.PathEscape(req.BookingRef)), map[string]any{
+		"user_id":  req.UserID,
+		"train_id": req.TrainID,
+		"meals":    meals,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, refusal(resp, nil)
+	}
+	var receipt Receipt
+	if err := decode(resp, &receipt); err != nil {
+		return nil, err
+	}
+	return &receipt, nil
+}
+
+func (s *localServer) SeatMap(ctx context.Context, trainID string) (*SeatMap, error) {
+	resp, err := s.do(ctx, fmt.Sprintf("%s/v1/trains/%s/seats", s.baseURL, url.PathEscape(trainID)), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, refusal(resp, nil)
+	}
+	var seats SeatMap
+	if err := decode(resp, &seats); err != nil {
+		return nil, err
+	}
+	return &seats, nil
+}
+
+func (s *localServer) ChooseSeat(ctx context.Context, req SeatChoice) (*Receipt, error) {
+	resp, err := s.post(ctx, fmt.Sprintf("%s/v1/booking/%s/seat", s.baseURL, url.PathEscape(req.BookingRef)), map[string]any{
+		"user_id":  req.UserID,
+		"train_id": req.TrainID,
+		"seat":     req.Seat,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, refusal(resp, ErrSoldOut)
+	}
+	var receipt Receipt
+	if err := decode(resp, &receipt); err != nil {
+		return nil, err
+	}
+	return &receipt, nil
+}
+
+func (s *localServer) DeclareLuggage(ctx context.Context, req LuggageDeclaration) (*Receipt, error) {
+	luggage := req.Luggage
+	if luggage == nil {
+		luggage = []LuggageChoice{}
+	}
+	resp, err := s.post(ctx, fmt.Sprintf("%s/v1/booking/%s/luggage", s.baseURL, url.PathEscape(req.BookingRef)), map[string]any{
+		"user_id":  req.UserID,
+		"train_id": req.TrainID,
+		"luggage":  luggage,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, refusal(resp, ErrSoldOut)
+	}
+	var receipt Receipt
+	if err := decode(resp, &receipt); err != nil {
+		return nil, err
+	}
+	return &receipt, nil
+}
+
+func (s *localServer) OpenSupportCase(ctx context.Context, req SupportRequest) (string, error) {
+	resp, err := s.post(ctx, s.baseURL+"/v1/support/tickets", map[string]string{
+		"user_id":     req.UserID,
+		"subject":     req.Subject,
+		"description": req.Description,
+		"booking_ref": req.BookingRef,
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return "", refusal(resp, nil)
+	}
+	var opened struct {
+		CaseNumber string `json:"case_number"`
+	}
+	if err := decode(resp, &opened); err != nil {
+		return "", err
+	}
+	return opened.CaseNumber, nil
+}
+
+func (s *localServer) SendFeedback(ctx context.Context, req FeedbackRequest) (string, error) {
+	body := map[string]any{
+		"user_id":     req.UserID,
+		"kind":        "complaint",
+		"train_id":    req.TrainID,
+		"booking_ref": req.BookingRef,
+		"message":     req.Message,
+	}
+	if req.Rating > 0 {
+		body["kind"], body["rating"] = "rating", req.Rating
+	}
+	resp, err := s.post(ctx, s.baseURL+"/v1/feedback", body)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return "", refusal(resp, nil)
+	}
+	var sent struct {
+		FeedbackID string `json:"feedback_id"`
+	}
+	if err := decode(resp, &sent); err != nil {
+		return "", err
+	}
+	return sent.FeedbackID, nil
+}
+
+func (s *localServer) TakeNotices(ctx context.Context, userID string) ([]Notice, error) {
+	resp, err := s.do(ctx, fmt.Sprintf("%s/v1/user/notifications?user_id=%s", s.baseURL, url.QueryEscape(userID)), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, refusal(resp, nil)
+	}
+	var notices []Notice
+	if err := decode(resp, &notices); err != nil {
+		return nil, err
+	}
+	if len(notices) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]string, len(notices))
+	for i, n := range notices {
+		ids[i] = n.ID
+	}
+	ack, err := s.post(ctx, s.baseURL+"/v1/user/notifications/ack", map[string]any{"user_id": userID, "notification_ids": ids})
+	if err != nil {
+		return nil, err
+	}
+	ack.Body.Close()
+	if ack.StatusCode != http.StatusOK {
+		return nil, refusal(ack, nil)
+	}
+	return notices, nil
+}
+
+func (s *localServer) Travelers(ctx context.Context, userID string) ([]Traveler, error) {
+	resp, err := s.do(ctx, fmt.Sprintf("%s/v1/user/travelers?user_id=%s", s.baseURL, url.QueryEscape(userID)), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, refusal(resp, nil)
+	}
+	var travelers []Traveler
+	if err := decode(resp, &travelers); err != nil {
+		return nil, err
+	}
+	return travelers, nil
+}
+
+func (s *localServer) SaveTravelers(ctx context.Context, userID string, travelers []Traveler) ([]Traveler, error) {
+	resp, err := s.post(ctx, s.baseURL+"/v1/user/travelers", map[string]any{"user_id": userID, "travelers": travelers})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, refusal(resp, nil)
+	}
+	var saved []Traveler
+	if err := decode(resp, &saved); err != nil {
+		return nil, err
+	}
+	return saved, nil
+}
+
+func (s *localServer) Status(ctx context.Context, userID string) ([]UserBooking, error) {
+	resp, err := s.do(ctx, fmt.Sprintf("%s/v1/user/tickets?user_id=%s", s.baseURL, url.QueryEscape(userID)), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, refusal(resp, nil)
+	}
+	var bookings []UserBooking
+	if err := decode(resp, &bookings); err != nil {
+		return nil, err
+	}
+	return bookings, nil
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/zhangbiao2009/train-booking/internal/otlp"
+)
+
+// Structured log of LLM calls, intents, server calls and errors; discarded until setupLogger runs
+var logger = slog.New(slog.NewJSONHandler(io.Discard, nil))
+
+// defaultLogFile is agent.log in the user's cache directory
+func defaultLogFile() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "train-booking-agent", "agent.log")
+}
+
+func setupLogger(cfg Config) error {
+	if cfg.LogFile == "" || cfg.LogFile == "off" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(cfg.LogFile), 0o700); err != nil {
+		return err
+	}
+	w, err := newRotatingFile(cfg.LogFile, int64(cfg.LogMaxMB)<<20, cfg.LogBackups)
+	if err != nil {
+		return err
+	}
+
+	level := slog.LevelInfo
+	if cfg.Debug {
+		level = slog.LevelDebug
+	}
+	// Every record carries a session ID so one conversation can be followed,
+	// and those of a turn its request ID
+	logger = slog.New(requestIDHandler{slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})}).With("session", processSession)
+	return nil
+}
+
+// The session ID of this process, and of its conversation unless it serves
+// several, as the messaging channels do
+var processSession = newSessionID()
+
+type requestIDKey struct{}
+
+type conversationKey struct{}
+
+// newRequestID makes the correlation ID of a turn
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// withConversation marks ctx as part of the conversation with session ID id
+func withConversation(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, conversationKey{}, id)
+}
+
+// conversationFrom is the session ID of the conversation ctx belongs to, or ""
+func conversationFrom(ctx context.Context) string {
+	id, _ := ctx.Value(conversationKey{}).(string)
+	return id
+}
+
+// requestIDFrom is the request ID of the turn ctx belongs to, or ""
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestIDHandler adds the turn's request ID to records logged with its
+// context, and its conversation's session ID when that is not the process's
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := conversationFrom(ctx); id != "" && id != processSession {
+		r.AddAttrs(slog.String("conversation", id))
+	}
+	if id := requestIDFrom(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
+
+func newSessionID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// rotatingFile is a size-capped log file that keeps a fixed number of backups
+// (agent.log.1 is the newest)
+type rotatingFile struct {
+	mu       sync.Mutex
+	path     string
+	maxBytes int64
+	backups  int
+	file     *os.File
+	size     int64
+}
+
+func newRotatingFile(path string, maxBytes int64, backups int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxBytes: maxBytes, backups: backups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.file, r.size = f, info.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.maxBytes > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxBytes {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) rotate() error {
+	r.file.Close()
+	for i := r.backups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+	}
+	if r.backups > 0 {
+		os.Rename(r.path, r.path+".1")
+	} else {
+		os.Remove(r.path)
+	}
+	return r.open()
+}
+
+// loggingTransport records every booking server call with its status and
+// latency, and sends it with the turn's X-Request-ID and the conversation's
+// X-Session-ID
+type loggingTransport struct {
+	next http.RoundTripper
+}
+
+func (t loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	requestID, conversation := requestIDFrom(ctx), conversationFrom(ctx)
+	if requestID != "" || conversation != "" {
+		req = req.Clone(ctx)
+		if requestID != "" && req.Header.Get("X-Request-ID") == "" {
+			req.Header.Set("X-Request-ID", requestID)
+		}
+		if conversation != "" && req.Header.Get("X-Session-ID") == "" {
+			req.Header.Set("X-Session-ID", conversation)
+		}
+	}
+	ctx, span := telemetry.Start(ctx, req.Method, otlp.KindClient,
+		otlp.String("http.request.method", req.Method),
+		otlp.String("url.full", req.URL.String()),
+		otlp.String("server.address", req.URL.Hostname()),
+	)
+	defer span.End()
+	if span != nil {
+		req = req.Clone(ctx)
+		otlp.Inject(ctx, req.Header)
+	}
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	latency := time.Since(start)
+	if err != nil {
+		span.SetError(err.Error())
+		logger.ErrorContext(ctx, "server call failed", "method", req.Method, "url", req.URL.String(), "latency_ms", latency.Milliseconds(), "err", err)
+		return nil, err
+	}
+	span.SetAttributes(otlp.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= 500 {
+		span.SetError(resp.Status)
+	}
+	logger.InfoContext(ctx, "server call", "method", req.Method, "url", req.URL.String(), "status", resp.StatusCode, "latency_ms", latency.Milliseconds())
+	return resp, nil
+}
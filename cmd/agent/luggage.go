@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Luggage: "I'm traveling with a bicycle on G100" declares luggage beyond the
+// free allowance on the last booking made with that train, or on the booking
+// named; said while booking, it is declared on the new booking. Its fee is
+// added to the receipt, and the train may have no room left for it.
+
+const luggageParam = "Luggage beyond the free allowance, such as \"bicycle\" or \"2 oversized bags\"; \"none\" withdraws it"
+
+// Common names of luggage the provider lists under another
+var luggageAliases = map[string]string{
+	"bike":  "bicycle",
+	"bikes": "bicycle",
+	"cycle": "bicycle",
+}
+
+func (a *BookingAgent) registerLuggageTool() {
+	err := a.tools.register(&funcTool{
+		name:        "declare_luggage",
+		description: "User travels with luggage beyond the free allowance on a booked trip, such as a bicycle or oversized bags",
+		parameters: objectSchema(map[string]string{
+			"train_id":    "Train, such as G100",
+			"luggage":     luggageParam,
+			"booking_ref": "Booking reference, such as BK1A2B3C4D5E",
+			"user_id":     userIDParam,
+		}),
+		run: func(ctx context.Context, p map[string]string) string {
+			return a.declareLuggage(ctx, p)
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// declareLuggage declares the luggage asked for on a booking
+func (a *BookingAgent) declareLuggage(ctx context.Context, p map[string]string) string {
+	req := LuggageDeclaration{
+		UserID:     p["user_id"],
+		BookingRef: strings.ToUpper(p["booking_ref"]),
+		TrainID:    strings.ToUpper(p["train_id"]),
+	}
+	if req.UserID == "" {
+		req.UserID = a.userID
+	}
+	luggage, ok := parseLuggage(p["luggage"])
+	if !ok {
+		return "🤔 What luggage will you bring, such as a bicycle or 2 oversized bags?"
+	}
+	req.Luggage = luggage
+	if req.BookingRef == "" {
+		req.BookingRef = a.bookingWith(ctx, req.TrainID, req.UserID)
+	}
+	if req.BookingRef == "" {
+		ticket := "ticket"
+		if req.TrainID != "" {
+			ticket = req.TrainID + " ticket"
+		}
+		return fmt.Sprintf("🤔 Which booking? Please give the booking reference of your %s, such as BK1A2B3C4D5E.", ticket)
+	}
+
+	receipt, err := a.provider.DeclareLuggage(ctx, req)
+	switch {
+	case errors.Is(err, ErrNoReceipt):
+		return fmt.Sprintf("❌ No booking %s found for user %s", req.BookingRef, req.UserID)
+	case errors.Is(err, ErrSoldOut), errors.Is(err, ErrDeparted), errors.Is(err, ErrInvalid):
+		return fmt.Sprintf("❌ %v", err)
+	case err != nil:
+		return a.providerError(ctx, "declaring luggage", err)
+	}
+	if len(req.Luggage) == 0 {
+		return fmt.Sprintf("✅ Your luggage on booking %s is withdrawn.", req.BookingRef)
+	}
+	return fmt.Sprintf("🧳 Your luggage is declared on booking %s.\n%s", req.BookingRef, a.formatReceipt(receipt))
+}
+
+// parseLuggage reads "2 oversized bags and a bicycle" into kinds of luggage
+// and quantities; "none" is no luggage. ok is false when nothing is named.
+func parseLuggage(text string) (luggage []LuggageChoice, ok bool) {
+	text = strings.ToLower(strings.TrimSpace(text))
+	if text == "" {
+		return nil, false
+	}
+	if text == "none" {
+		return nil, true
+	}
+	for _, part := range mealSeparator.Split(text, -1) {
+		quantity := 1
+		for _, article := range []string{"a ", "an ", "one ", "my "} {
+			part = strings.TrimPrefix(part, article)
+		}
+		if m := mealQuantity.FindStringSubmatch(part); m != nil {
+			quantity, _ = strconv.Atoi(m[1])
+			part = m[2]
+		}
+		part = strings.TrimSpace(part)
+		if alias, ok := luggageAliases[part]; ok {
+			part = alias
+		}
+		if part == "" || quantity < 1 {
+			continue
+		}
+		luggage = append(luggage, LuggageChoice{Item: part, Quantity: quantity})
+	}
+	return luggage, len(luggage) > 0
+}
+
+// bookedLuggage is appended to a booking's reply: the luggage asked for with
+// it, declared on each train booked. The tickets stand either way, so a
+// refusal is reported without undoing them.
+func (a *BookingAgent) bookedLuggage(ctx context.Context, trainIDs []string, userID, luggage string) string {
+	choices, ok := parseLuggage(luggage)
+	if !ok || len(choices) == 0 {
+		return ""
+	}
+	if userID == "" {
+		userID = a.userID
+	}
+	var lines []string
+	for _, id := range trainIDs {
+		id = strings.ToUpper(strings.TrimSpace(id))
+		ref := a.bookingWith(ctx, id, userID)
+		if ref == "" {
+			continue
+		}
+		receipt, err := a.provider.DeclareLuggage(ctx, LuggageDeclaration{UserID: userID, BookingRef: ref, TrainID: id, Luggage: choices})
+		if err != nil {
+			lines = append(lines, fmt.Sprintf("⚠️ Your ticket on %s is booked, but your luggage could not be declared: %v", id, err))
+			continue
+		}
+		line := fmt.Sprintf("🧳 Declared %s on %s", luggage, id)
+		if receipt.Currency != "" {
+			fee := 0.0
+			for _, addOn := range receipt.AddOns {
+				if addOn.Kind == "luggage" && addOn.TrainID == id {
+					fee += addOn.Amount
+				}
+			}
+			line += fmt.Sprintf(" for %s %.2f, added to booking %s", receipt.Currency, fee, ref)
+		}
+		lines = append(lines, line+".")
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return "\n" + strings.Join(lines, "\n")
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Meals: trains with dining service take meal pre-orders until a cutoff
+// before departure. "Pre-order 2 beef noodles and tea on G100" orders for the
+// last booking made with that train; without meals the menu is shown. After a
+// long journey is booked, the agent offers its menu.
+
+// Journeys at least this long are offered meals when booked
+const longJourney = 4 * time.Hour
+
+// Menu is what a train serves and until when it can be ordered
+type Menu struct {
+	TrainID  string     `json:"train_id"`
+	OrderBy  time.Time  `json:"order_by"`
+	Currency string     `json:"currency,omitempty"`
+	Items    []MenuItem `json:"items"`
+}
+
+// MenuItem is a dish or drink; the price is 0 when the provider does not
+// price tickets
+type MenuItem struct {
+	ID    string  `json:"id"`
+	Name  string  `json:"name"`
+	Price float64 `json:"price,omitempty"`
+}
+
+func (a *BookingAgent) registerMealsTool() {
+	err := a.tools.register(&funcTool{
+		name:        "order_meals",
+		description: "User wants to see a train's menu or pre-order meals for a booked trip; \"none\" cancels the order",
+		parameters: objectSchema(map[string]string{
+			"train_id":    "Train, such as G100",
+			"meals":       "Meals wanted, such as \"2 beef noodles, tea\"; empty to show the menu, \"none\" to cancel",
+			"booking_ref": "Booking reference, such as BK1A2B3C4D5E",
+			"user_id":     userIDParam,
+		}),
+		run: func(ctx context.Context, p map[string]string) string {
+			return a.orderMeals(ctx, p)
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// orderMeals shows the menu, or pre-orders the meals asked for
+func (a *BookingAgent) orderMeals(ctx context.Context, p map[string]string) string {
+	req := MealOrder{
+		UserID:     p["user_id"],
+		BookingRef: strings.ToUpper(p["booking_ref"]),
+		TrainID:    strings.ToUpper(p["train_id"]),
+	}
+	if req.UserID == "" {
+		req.UserID = a.userID
+	}
+	if req.BookingRef == "" {
+		req.BookingRef = a.bookingWith(ctx, req.TrainID, req.UserID)
+	}
+	if req.TrainID == "" && req.BookingRef != "" {
+		if receipt, err := a.provider.Receipt(ctx, req.BookingRef, req.UserID); err == nil && len(receipt.Items) == 1 {
+			req.TrainID = receipt.Items[0].TrainID
+		}
+	}
+	if req.TrainID == "" {
+		return "🤔 Which train? Please give the train ID, such as G100."
+	}
+
+	menu, err := a.provider.Menu(ctx, req.TrainID)
+	if err != nil {
+		return a.providerError(ctx, "fetching menu", err)
+	}
+	wanted := strings.TrimSpace(p["meals"])
+	if wanted == "" {
+		return a.formatMenu(menu)
+	}
+	if !strings.EqualFold(wanted, "none") {
+		for _, part := range mealSeparator.Split(wanted, -1) {
+			choice, ok := parseMeal(menu, part)
+			if !ok {
+				return fmt.Sprintf("❌ %s does not serve %q.\n%s", req.TrainID, strings.TrimSpace(part), a.formatMenu(menu))
+			}
+			req.Meals = append(req.Meals, choice)
+		}
+	}
+	if req.BookingRef == "" {
+		return fmt.Sprintf("🤔 Which booking? Please give the booking reference of your %s ticket, such as BK1A2B3C4D5E.", req.TrainID)
+	}
+
+	receipt, err := a.provider.OrderMeals(ctx, req)
+	switch {
+	case errors.Is(err, ErrDeparted):
+		return fmt.Sprintf("❌ %v", err)
+	case err != nil:
+		return a.providerError(ctx, "ordering meals", err)
+	}
+	if len(req.Meals) == 0 {
+		return fmt.Sprintf("✅ Your meals on %s are cancelled.", req.TrainID)
+	}
+	return fmt.Sprintf("🍱 Your meals on %s are ordered.\n%s", req.TrainID, a.formatReceipt(receipt))
+}
+
+var (
+	mealSeparator = regexp.MustCompile(`(?i)\s*(?:,|\band\b|\+)\s*`)
+	mealQuantity  = regexp.MustCompile(`^(\d+)\s*(?:x\s*|×\s*)?(.+)$`)
+)
+
+// parseMeal reads "2 beef noodles" or "tea" into a menu item and quantity,
+// matching the item by ID or by words of its name
+func parseMeal(menu *Menu, text string) (MealChoice, bool) {
+	text = strings.ToLower(strings.TrimSpace(text))
+	quantity := 1
+	if m := mealQuantity.FindStringSubmatch(text); m != nil {
+		quantity, _ = strconv.Atoi(m[1])
+		text = m[2]
+	}
+	if text == "" || quantity < 1 {
+		return MealChoice{}, false
+	}
+	for _, item := range menu.Items {
+		name := strings.ToLower(item.Name)
+		if text == strings.ToLower(item.ID) || text == name || strings.Contains(name, text) || strings.Contains(text, strings.ToLower(item.ID)) {
+			return MealChoice{Item: item.ID, Quantity: quantity}, true
+		}
+	}
+	return MealChoice{}, false
+}
+
+// formatMenu lists a menu with the time orders close
+func (a *BookingAgent) formatMenu(menu *Menu) string {
+	orderBy := menu.OrderBy.Local().Format("15:04 on 2006-01-02")
+	var items []string
+	for _, item := range menu.Items {
+		if menu.Currency != "" {
+			items = append(items, fmt.Sprintf("%s (%.2f %s)", item.Name, item.Price, menu.Currency))
+		} else {
+			items = append(items, item.Name)
+		}
+	}
+	if a.accessible {
+		return fmt.Sprintf("Menu on train %s: %s. Order by %s.", menu.TrainID, strings.Join(items, ", "), orderBy)
+	}
+	return fmt.Sprintf("🍽️ Menu on %s (order by %s):\n• %s", menu.TrainID, orderBy, strings.Join(items, "\n• "))
+}
+
+// mealOffer is appended to a booking's reply: the menu of each long journey
+// booked that still takes orders
+func (a *BookingAgent) mealOffer(ctx context.Context, trainIDs []string) string {
+	trains, err := a.provider.Search(ctx, SearchQuery{TrainIDs: trainIDs})
+	if err != nil {
+		return ""
+	}
+	var offers []string
+	example := ""
+	for _, train := range trains {
+		depart, arrive, err := trainTimes(train)
+		if err != nil || arrive.Sub(depart) < longJourney {
+			continue
+		}
+		menu, err := a.provider.Menu(ctx, train.ID)
+		if err != nil || !time.Now().Before(menu.OrderBy) {
+			continue
+		}
+		offers = append(offers, a.formatMenu(menu))
+		if example == "" && len(menu.Items) > 0 {
+			example = fmt.Sprintf("pre-order 1 %s on %s", strings.ToLower(menu.Items[0].Name), train.ID)
+		}
+	}
+	if len(offers) == 0 {
+		return ""
+	}
+	question := "Would you like to pre-order a meal for this long journey?"
+	if example != "" {
+		question += fmt.Sprintf(" Say, for example, \"%s\".", example)
+	}
+	if !a.accessible {
+		question = "🍱 " + question
+	}
+	return "\n\n" + question + "\n" + strings.Join(offers, "\n")
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Parameters are put in canonical form before they are checked or sent:
+// " g100 ", "train G-100" and "train no. G100" all become G100, and a bare
+// number such as "no. 100" becomes the one train shown last with that number.
+
+// A train ID as users write it: an optional "train", "no." or "#" before a
+// letter and digits, possibly apart
+var looseTrainID = regexp.MustCompile(`(?i)^(?:train\s*)?(?:(?:no\.?|number|#)\s*)?([A-Z]?)[\s-]*(\d+)$`)
+
+// normalizeTrainID returns the canonical form of one train ID, or raw trimmed
+// when it does not look like one
+func (a *BookingAgent) normalizeTrainID(raw string) string {
+	raw = strings.TrimSpace(raw)
+	m := looseTrainID.FindStringSubmatch(raw)
+	if m == nil {
+		return raw
+	}
+	letter, number := strings.ToUpper(m[1]), m[2]
+	if letter != "" {
+		return letter + number
+	}
+	// Without its letter the number stands for a train the user was shown,
+	// if only one has it
+	var match string
+	for _, t := range a.lastResults {
+		if len(t.ID) > 1 && t.ID[1:] == number && t.ID != match {
+			if match != "" {
+				return raw
+			}
+			match = t.ID
+		}
+	}
+	if match == "" {
+		return raw
+	}
+	return match
+}
+
+// normalizeParams trims every parameter and canonicalizes train IDs and
+// booking references
+func (a *BookingAgent) normalizeParams(intent *IntentResponse) {
+	for name, value := range intent.Parameters {
+		value = strings.TrimSpace(value)
+		switch name {
+		case "train_id":
+			ids := strings.Split(value, ",")
+			for i, id := range ids {
+				ids[i] = a.normalizeTrainID(id)
+			}
+			value = strings.Join(ids, ",")
+		case "booking_ref":
+			value = strings.ToUpper(value)
+		}
+		intent.Parameters[name] = value
+	}
+}
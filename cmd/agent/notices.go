@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Notices: the booking server keeps notifications for users who get them
+// through the agent, such as a delay announced on a booked train. Each turn
+// ends with the ones that arrived since the last.
+
+// pendingNotices is the user's new notifications, to add to a reply. Failing
+// to fetch them is logged and leaves them for the next turn.
+func (a *BookingAgent) pendingNotices(ctx context.Context) string {
+	notices, err := a.provider.TakeNotices(ctx, a.userID)
+	if err != nil {
+		logger.Warn("cannot fetch notifications", "err", err)
+	}
+	if len(notices) == 0 {
+		return ""
+	}
+
+	var lines []string
+	for _, n := range notices {
+		if a.accessible {
+			lines = append(lines, fmt.Sprintf("Notice: %s", n.Message))
+			continue
+		}
+		icon := "🔔"
+		switch n.Type {
+		case "train.delayed":
+			icon = "⏰"
+		case "train.platform_changed":
+			icon = "🚉"
+		case "waitlist.promoted":
+			icon = "🎉"
+		}
+		lines = append(lines, fmt.Sprintf("%s %s%s", icon, n.Message, providerTag(n.Provider)))
+	}
+	return "\n\n" + strings.Join(lines, "\n")
+}
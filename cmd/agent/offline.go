@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Offline mode: a deterministic stand-in for DeepSeek so the agent can be demoed
+// and its chat loop exercised without an API key or network access.
+
+// offlineRule maps messages matching pattern to intent; the parameters are
+// picked out of the message by offlineParameters
+type offlineRule struct {
+	pattern *regexp.Regexp
+	intent  string
+}
+
+// Rules are tried in order, so "cancel my booking of G100" is a cancellation
+var offlineRules = []offlineRule{
+	{regexp.MustCompile(`(?i)\bweather\b`), "weather"},
+	{regexp.MustCompile(`(?i)\b(connection|transfer|change\s+trains)\b`), "check_connection"},
+	{regexp.MustCompile(`(?i)\bstation\b`), "station_info"},
+	{regexp.MustCompile(`(?i)\b(where\s+is|where's|position|how\s+far\s+along)\b`), "train_position"},
+	{regexp.MustCompile(`(?i)\b(receipts?|fare\s+breakdown|invoice)\b`), "booking_receipt"},
+	{regexp.MustCompile(`(?i)\b(escalate|human|person|support|supervisor)\b`), "escalate"},
+	{regexp.MustCompile(`(?i)\b(complain\w*|feedback|rate|rating|stars?)\b`), "feedback"},
+	{regexp.MustCompile(`(?i)\bupgrade\b`), "upgrade"},
+	{regexp.MustCompile(`(?i)\b(cheapest|cheaper|lowest\s+fares?|fare\s+calendar)\b`), "fare_calendar"},
+	{regexp.MustCompile(`(?i)\b(punctual\w*|on[\s-]time|(usually|often)\s+(late|delayed))\b`), "train_punctuality"},
+	{regexp.MustCompile(`(?i)\b(meals?|food|menu|dining|lunch|dinner|breakfast|pre-?order)\b`), "order_meals"},
+	{regexp.MustCompile(`(?i)\btravell?ers?\b|\b(save|forget)\s+my\s+\w+\s*:?`), "manage_travelers"},
+	{regexp.MustCompile(`(?i)\b(cancel|refund)`), "cancel_ticket"},
+	{regexp.MustCompile(`(?i)\b(book|reserve|buy)\b`), "book_ticket"},
+	{regexp.MustCompile(`(?i)\b(seat\s+map|(window|aisle|middle)\s+seats?|choose\s+(a\s+)?seat|seat\s+\d*[A-Z]?\d+[A-F])\b`), "choose_seat"},
+	{regexp.MustCompile(`(?i)\b(luggage|baggage|bicycles?|bikes?|oversized|suitcases?)\b`), "declare_luggage"},
+	{regexp.MustCompile(`(?i)\bmy\s+(tickets?|bookings?|reservations?)\b`), "my_tickets"},
+	{regexp.MustCompile(`(?i)\b(search|find)\b|\bfrom\s+\w+\s+to\b|\btrains?\s+(from|to)\b`), "search_trains"},
+	{regexp.MustCompile(`(?i)\b(list|trains)\b`), "list_trains"},
+	{regexp.MustCompile(`(?i)\b[A-Z][0-9]+\b`), "query_ticket"},
+}
+
+var (
+	offlineTrainID  = regexp.MustCompile(`(?i)\b[A-Z][0-9]+\b`)
+	offlineUser     = regexp.MustCompile(`(?i)\buser(?:\s+id)?(?:\s+is)?\s+([A-Za-z0-9_-]+)`)
+	offlineFrom     = regexp.MustCompile(`(?i)\bfrom\s+([A-Za-z]+)`)
+	offlineTo       = regexp.MustCompile(`(?i)\bto\s+([A-Za-z]+)`)
+	offlineCity     = regexp.MustCompile(`(?i)\b(?:in|at)\s+([A-Za-z]+)`)
+	offlineStation  = regexp.MustCompile(`(?i)\b([A-Z][a-z']+(?:\s+(?:South|North|East|West|Hongqiao))?)\s+station\b`)
+	offlineDate     = regexp.MustCompile(`\b\d{4}-\d{2}-\d{2}\b`)
+	offlineRating   = regexp.MustCompile(`(?i)\b([1-5])\s*(?:stars?\b|/\s*5\b)`)
+	offlineClass    = regexp.MustCompile(`(?i)\b([A-Za-z]+)\s+class\b`)
+	offlineInsure   = regexp.MustCompile(`(?i)\b(insur\w*|protection)\b`)
+	offlineSeat     = regexp.MustCompile(`(?i)\b(wheel\s*chair|accessible)[\s-]+(seats?|spaces?|places?)\b`)
+	offlinePet      = regexp.MustCompile(`(?i)\b(pets?|dogs?|cats?|puppy|kitten)\b`)
+	offlineLuggage  = regexp.MustCompile(`(?i)\b(?:(\d+)\s+)?(extra|oversized|bicycles?|bikes?)\b`)
+	offlineSeatNo   = regexp.MustCompile(`(?i)\bseat\s+(\d+-\d+[A-F]|\d+[A-F])\b`)
+	offlineSeatPref = regexp.MustCompile(`(?i)\b(window|aisle|middle)\b(?:.*?\b(front|back|rear)\b)?`)
+	offlineMonth    = regexp.MustCompile(`(?i)\b(?:this|next)\s+month\b|\b\d{4}-\d{2}\b`)
+	offlineFor      = regexp.MustCompile(`(?i)\bfor\s+((?:me|myself|my\s+\w+)(?:\s*(?:,|and)\s*(?:me|myself|my\s+\w+))*)`)
+	offlineTraveler = regexp.MustCompile(`(?i)\b(?:save|add)\s+(?:my\s+)?(?:travell?er\s+)?(\w+)\s*:\s*([^,]+?)\s*(?:,\s*(id(?:\s+card)?|passport|document)?\s*([A-Za-z0-9]+))?\s*(?:,\s*(adult|child|senior|student))?\s*$`)
+	offlineForget   = regexp.MustCompile(`(?i)\b(?:forget|remove|delete)\s+(?:my\s+)?(?:travell?er\s+)?(\w+)`)
+	offlineMeals    = regexp.MustCompile(`(?i)\bpre-?order\s+(.+?)(?:\s+(?:on|for)\s+(?:train\s+)?[A-Z][0-9]+\b.*)?$`)
+)
+
+// cannedLLM answers the way DeepSeek would. Scripted replies are used first,
+// one per message in order; after that the rules decide.
+type cannedLLM struct {
+	mu     sync.Mutex // guards script, shared by channel users' conversations
+	script []string
+}
+
+// loadOfflineScript reads one raw model reply per line, skipping blank lines
+// and # comments. A line need not be JSON, to exercise the unparseable path.
+func loadOfflineScript(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var script []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		script = append(script, line)
+	}
+	return script, scanner.Err()
+}
+
+// reply returns the model's raw response to userInput
+func (c *cannedLLM) reply(userInput string) string {
+	c.mu.Lock()
+	if len(c.script) > 0 {
+		next := c.script[0]
+		c.script = c.script[1:]
+		c.mu.Unlock()
+		return next
+	}
+	c.mu.Unlock()
+
+	resp := IntentResponse{
+		Intent:            "unknown",
+		Parameters:        map[string]string{},
+		MissingParameters: []string{},
+		ClarifyQuestion:   "I'm in offline demo mode and only understand simple requests, such as \"list trains\", \"check G100\", \"search from Beijing to Shanghai\", \"book G100\", \"cancel G100\" or \"show my tickets\".",
+	}
+	for _, rule := range offlineRules {
+		if rule.pattern.MatchString(userInput) {
+			resp.Intent, resp.ClarifyQuestion = rule.intent, ""
+			resp.Parameters = offlineParameters(rule.intent, userInput)
+			break
+		}
+	}
+
+	switch resp.Intent {
+	case "unknown":
+		// A bare train ID answers the agent's "Which train?"
+		if id := offlineTrainID.FindString(userInput); id != "" && strings.TrimSpace(userInput) == id {
+			resp.Parameters["train_id"] = strings.ToUpper(id)
+		}
+	case "query_ticket", "book_ticket", "cancel_ticket":
+		if resp.Parameters["train_id"] == "" {
+			resp.MissingParameters = []string{"train_id"}
+		}
+	case "search_trains":
+		if resp.Parameters["from"] == "" && resp.Parameters["to"] == "" && resp.Parameters["date"] == "" {
+			resp.Intent, resp.Parameters = "list_trains", map[string]string{}
+		}
+	}
+	if resp.Intent != "unknown" {
+		confidence := 0.9
+		resp.Confidence = &confidence
+	}
+
+	data, _ := json.Marshal(resp)
+	return string(data)
+}
+
+// offlineParameters picks the parameters intent uses out of the message
+func offlineParameters(intent, input string) map[string]string {
+	params := map[string]string{}
+	switch intent {
+	case "query_ticket", "book_ticket", "cancel_ticket", "check_connection":
+		ids := offlineTrainID.FindAllString(input, -1)
+		for i := range ids {
+			ids[i] = strings.ToUpper(ids[i])
+		}
+		if intent == "query_ticket" && len(ids) > 1 {
+			ids = ids[:1]
+		}
+		params["train_id"] = strings.Join(ids, ",")
+		switch intent {
+		case "book_ticket":
+			if offlineInsure.MatchString(input) {
+				params["insurance"] = "yes"
+			}
+			if offlinePet.MatchString(input) {
+				params["pet"] = "yes"
+			}
+			if offlineSeat.MatchString(input) {
+				params["wheelchair"] = "yes"
+			}
+			params["luggage"] = offlineLuggageParam(input)
+			if m := offlineFor.FindStringSubmatch(input); m != nil {
+				params["travelers"] = m[1]
+			}
+		case "cancel_ticket":
+			params["booking_ref"] = strings.ToUpper(bookingRefPattern.FindString(input))
+		}
+	case "search_trains":
+		if m := offlineFrom.FindStringSubmatch(input); m != nil {
+			params["from"] = m[1]
+		}
+		if m := offlineTo.FindStringSubmatch(input); m != nil {
+			params["to"] = m[1]
+		}
+		params["date"] = offlineDate.FindString(input)
+		if offlinePet.MatchString(input) {
+			params["pets"] = "yes"
+		}
+	case "fare_calendar":
+		if m := offlineFrom.FindStringSubmatch(input); m != nil {
+			params["from"] = m[1]
+		}
+		if m := offlineTo.FindStringSubmatch(input); m != nil {
+			params["to"] = m[1]
+		}
+		params["month"] = strings.ToLower(offlineMonth.FindString(input))
+	case "weather":
+		if m := offlineCity.FindStringSubmatch(input); m != nil {
+			params["city"] = m[1]
+		}
+		params["date"] = offlineDate.FindString(input)
+	case "manage_travelers":
+		params["action"] = "list"
+		if m := offlineTraveler.FindStringSubmatch(input); m != nil {
+			params["action"], params["label"], params["name"], params["document"], params["type"] = "save", m[1], m[2], m[4], strings.ToLower(m[5])
+			if kind := strings.ToLower(m[3]); kind != "" && kind != "document" {
+				params["document_type"] = kind
+			}
+		} else if m := offlineForget.FindStringSubmatch(input); m != nil {
+			params["action"], params["label"] = "remove", m[1]
+		}
+	case "booking_receipt", "escalate":
+		params["booking_ref"] = strings.ToUpper(bookingRefPattern.FindString(input))
+	case "feedback":
+		params["train_id"] = strings.ToUpper(offlineTrainID.FindString(input))
+		params["booking_ref"] = strings.ToUpper(bookingRefPattern.FindString(input))
+		if m := offlineRating.FindStringSubmatch(input); m != nil {
+			params["rating"] = m[1]
+		}
+	case "upgrade":
+		params["train_id"] = strings.ToUpper(offlineTrainID.FindString(input))
+		params["booking_ref"] = strings.ToUpper(bookingRefPattern.FindString(input))
+		if m := offlineClass.FindStringSubmatch(input); m != nil {
+			params["class"] = strings.ToLower(m[1])
+		}
+	case "order_meals":
+		params["train_id"] = strings.ToUpper(offlineTrainID.FindString(input))
+		params["booking_ref"] = strings.ToUpper(bookingRefPattern.FindString(input))
+		if m := offlineMeals.FindStringSubmatch(input); m != nil && !strings.Contains(strings.ToLower(m[1]), "meal") {
+			params["meals"] = m[1]
+		}
+	case "declare_luggage":
+		params["train_id"] = strings.ToUpper(offlineTrainID.FindString(input))
+		params["booking_ref"] = strings.ToUpper(bookingRefPattern.FindString(input))
+		params["luggage"] = offlineLuggageParam(input)
+	case "choose_seat":
+		params["train_id"] = strings.ToUpper(offlineTrainID.FindString(input))
+		params["booking_ref"] = strings.ToUpper(bookingRefPattern.FindString(input))
+		if m := offlineSeatNo.FindStringSubmatch(input); m != nil {
+			params["seat"] = strings.ToUpper(m[1])
+		}
+		if m := offlineSeatPref.FindString(input); m != "" {
+			params["preference"] = strings.ToLower(m)
+		}
+	case "train_position", "train_punctuality":
+		params["train_id"] = strings.ToUpper(offlineTrainID.FindString(input))
+	case "station_info":
+		if m := offlineStation.FindStringSubmatch(input); m != nil {
+			params["station"] = m[1]
+		} else if m := offlineCity.FindStringSubmatch(input); m != nil {
+			params["station"] = m[1]
+		}
+	}
+	if intent == "book_ticket" || intent == "cancel_ticket" || intent == "my_tickets" || intent == "booking_receipt" || intent == "escalate" || intent == "feedback" || intent == "upgrade" || intent == "order_meals" || intent == "declare_luggage" || intent == "train_position" || intent == "choose_seat" {
+		if m := offlineUser.FindStringSubmatch(input); m != nil {
+			params["user_id"] = m[1]
+		}
+	}
+	for k, v := range params {
+		if v == "" {
+			delete(params, k)
+		}
+	}
+	return params
+}
+
+// offlineLuggageParam lists the luggage a message names, e.g. "2 oversized,
+// bicycle" for "2 oversized bags and my bike"
+func offlineLuggageParam(input string) string {
+	var items []string
+	for _, m := range offlineLuggage.FindAllStringSubmatch(input, -1) {
+		item := strings.ToLower(m[2])
+		if m[1] != "" {
+			item = m[1] + " " + item
+		}
+		items = append(items, item)
+	}
+	return strings.Join(items, ", ")
+}
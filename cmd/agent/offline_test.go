@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// typedLines is a lineReader over messages typed in advance
+type typedLines struct {
+	lines   []string
+	prompts []string
+}
+
+func (r *typedLines) ReadLine() (string, error) {
+	if len(r.lines) == 0 {
+		return "", io.EOF
+	}
+	line := r.lines[0]
+	r.lines = r.lines[1:]
+	return line, nil
+}
+
+func (r *typedLines) SetPrompt(prompt string) { r.prompts = append(r.prompts, prompt) }
+func (r *typedLines) Close() error            { return nil }
+
+// captureOutput collects what the agent prints, replies and notices apart
+func captureOutput(t *testing.T) (replies, notes *bytes.Buffer) {
+	t.Helper()
+	replies, notes = &bytes.Buffer{}, &bytes.Buffer{}
+	savedOut, savedNotices := out, notices
+	out, notices = replies, notes
+	t.Cleanup(func() { out, notices = savedOut, savedNotices })
+	return replies, notes
+}
+
+func TestChat(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		// Replies in the output, in order, and lines left unread
+		want []string
+		left int
+		// Whether the user was seen off
+		goodbye bool
+	}{
+		{"one message", []string{"check G100"}, []string{"G100"}, 0, false},
+		{"blank lines skipped", []string{"", "   ", "check G100"}, []string{"G100"}, 0, false},
+		{"quit ends the chat", []string{"check G100", "QUIT", "check D200"}, []string{"G100"}, 1, true},
+		{"slots over turns", []string{"book a ticket", "G100"}, []string{slotQuestions["book_ticket/train_id"], "Successfully booked ticket for train G100"}, 0, false},
+		{"meta command", []string{":clear", "check G100"}, []string{"G100"}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			replies, notes := captureOutput(t)
+			a := offlineAgent(t)
+			input := &typedLines{lines: tt.lines}
+			a.chat(input)
+
+			got := strings.Split(replies.String(), "\r🤖 Agent: ")[1:] // after the spinner
+			if len(got) != len(tt.want) {
+				t.Fatalf("%d replies, want %d:\n%s", len(got), len(tt.want), replies)
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(got[i], want) {
+					t.Errorf("reply %d is %q, want it to say %q", i+1, got[i], want)
+				}
+			}
+			if len(input.lines) != tt.left {
+				t.Errorf("%d lines left unread, want %d", len(input.lines), tt.left)
+			}
+			if strings.Contains(notes.String(), "Goodbye") != tt.goodbye {
+				t.Errorf("notices %q, goodbye %v", notes, tt.goodbye)
+			}
+			if want := "You (" + a.userID + "): "; len(input.prompts) == 0 || input.prompts[0] != want {
+				t.Errorf("prompts %q, want %q", input.prompts, want)
+			}
+		})
+	}
+}
+
+func TestCannedLLMRules(t *testing.T) {
+	tests := []struct {
+		message string
+		intent  string
+		params  map[string]string
+	}{
+		{"list trains", "list_trains", map[string]string{}},
+		{"check G100", "query_ticket", map[string]string{"train_id": "G100"}},
+		{"book g100 and D200 with insurance", "book_ticket", map[string]string{"train_id": "G100,D200", "insurance": "yes"}},
+		{"cancel my booking of G100", "cancel_ticket", map[string]string{"train_id": "G100"}},
+		{"search from Beijing to Shanghai", "search_trains", map[string]string{"from": "Beijing", "to": "Shanghai"}},
+		{"search for trains", "list_trains", map[string]string{}},
+		{"show my tickets", "my_tickets", map[string]string{}},
+		{"what's the weather in Shanghai on 2030-01-01", "weather", map[string]string{"city": "Shanghai", "date": "2030-01-01"}},
+		{"G100", "query_ticket", map[string]string{"train_id": "G100"}},
+		{"hello there", "unknown", map[string]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.message, func(t *testing.T) {
+			var got IntentResponse
+			if err := json.Unmarshal([]byte((&cannedLLM{}).reply(tt.message)), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Intent != tt.intent || !reflect.DeepEqual(got.Parameters, tt.params) {
+				t.Errorf("read as %s %v, want %s %v", got.Intent, got.Parameters, tt.intent, tt.params)
+			}
+			if (got.Intent == "unknown") != (got.ClarifyQuestion != "") {
+				t.Errorf("%s with question %q", got.Intent, got.ClarifyQuestion)
+			}
+		})
+	}
+}
+
+func TestCannedLLMScript(t *testing.T) {
+	c := &cannedLLM{script: []string{`{"intent":"list_trains"}`, "not JSON"}}
+	for i, want := range []string{`{"intent":"list_trains"}`, "not JSON"} {
+		if got := c.reply("check G100"); got != want {
+			t.Errorf("reply %d = %q, want %q", i+1, got, want)
+		}
+	}
+	if got := c.reply("check G100"); !strings.Contains(got, `"query_ticket"`) {
+		t.Errorf("reply after the script = %q, want the rules' reading", got)
+	}
+}
+
+func TestLoadOfflineScript(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "script.txt")
+	data := "# a booking\n{\"intent\":\"book_ticket\"}\n\n  not JSON  \n# end\n"
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	script, err := loadOfflineScript(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{`{"intent":"book_ticket"}`, "not JSON"}; !reflect.DeepEqual(script, want) {
+		t.Errorf("script %q, want %q", script, want)
+	}
+}
+
+func TestUnparseableReply(t *testing.T) {
+	tests := []struct {
+		name    string
+		repairs int
+		intent  string
+	}{
+		// The repair is answered by the rules once the script runs out
+		{"repaired", 1, "query_ticket"},
+		{"not repaired", 0, "unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := offlineAgent(t, "Sure! Here is the train.")
+			a.llmRepairs = tt.repairs
+			entry := turn(t, a, "check G100")
+			if entry.Intent != tt.intent {
+				t.Errorf("read as %s, want %s; reply %q", entry.Intent, tt.intent, entry.Reply)
+			}
+		})
+	}
+}
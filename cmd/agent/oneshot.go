@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Exit codes for -c and --batch, so scripts and cron jobs can tell outcomes apart
+const (
+	exitOK          = 0 // the request was carried out
+	exitFailed      = 1 // the action failed, e.g. sold out or train not found
+	exitUnclear     = 2 // the agent needs more information or did not understand
+	exitUnavailable = 3 // the booking server or DeepSeek could not be reached
+)
+
+// runOnce handles a single message, prints the reply and returns the exit code
+func (a *BookingAgent) runOnce(message string) int {
+	result, err := a.respondInterruptible(message)
+	if a.jsonOutput {
+		a.printTurn()
+	}
+	if err != nil {
+		if !a.jsonOutput {
+			fmt.Fprintln(notices, a.turnError(err))
+		}
+		return exitUnavailable
+	}
+	if !a.jsonOutput {
+		fmt.Fprintln(out, result)
+	}
+	return outcome(result)
+}
+
+// outcome classifies a reply by the marker it starts with
+func outcome(result string) int {
+	switch {
+	case strings.HasPrefix(result, "🤔"), strings.Contains(result, "didn't understand"), strings.Contains(result, "don't understand"):
+		return exitUnclear
+	case strings.Contains(result, "Error booking"), strings.Contains(result, "Error canceling"),
+		strings.Contains(result, "Error querying"), strings.Contains(result, "Error searching"),
+		strings.Contains(result, "Error fetching"):
+		// The booking server could not be reached mid-request
+		return exitUnavailable
+	case strings.HasPrefix(result, "❌"):
+		return exitFailed
+	}
+	return exitOK
+}
+
+// runBatch handles one message per line of path ("-" for stdin) in order and
+// returns the most severe exit code. Blank lines and lines starting with # are skipped.
+func (a *BookingAgent) runBatch(path string) int {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintf(notices, "❌ Cannot open batch file: %v\n", err)
+			return exitFailed
+		}
+		defer f.Close()
+		r = f
+	}
+
+	code := exitOK
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.ToLower(line) == "quit" {
+			break
+		}
+		if reply, ok := a.metaCommand(line); ok {
+			fmt.Fprintln(notices, reply)
+			continue
+		}
+
+		if a.jsonOutput {
+			result, err := a.respondInterruptible(line)
+			a.printTurn()
+			if err != nil {
+				code = exitUnavailable
+			} else {
+				code = max(code, outcome(result))
+			}
+			continue
+		}
+
+		fmt.Fprintf(out, "[%d] %s\n", n, line)
+		result, err := a.respondInterruptible(line)
+		lineCode := outcome(result)
+		if err != nil {
+			result, lineCode = a.turnError(err), exitUnavailable
+		}
+		fmt.Fprintf(out, "%s\n\n", result)
+		code = max(code, lineCode)
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintf(notices, "❌ Cannot read batch input: %v\n", err)
+		return exitFailed
+	}
+	return code
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/zhangbiao2009/train-booking/internal/plain"
+)
+
+// Largest server response body kept per call
+const maxRecordedBody = 64 << 10
+
+// ServerCall is one booking server request made while handling a message
+type ServerCall struct {
+	Method   string          `json:"method"`
+	URL      string          `json:"url"`
+	Status   int             `json:"status,omitempty"` // zero for calls a dry run only planned
+	Response json.RawMessage `json:"response,omitempty"`
+}
+
+// callRecorder keeps the booking server calls of the current turn
+type callRecorder struct {
+	next http.RoundTripper
+
+	mu    sync.Mutex
+	calls map[string][]ServerCall // by the conversation that made them
+}
+
+func (c *callRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := c.next.RoundTrip(req)
+	if err != nil || req.Method == http.MethodHead {
+		// Health checks are not part of any turn
+		return resp, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	call := ServerCall{Method: req.Method, URL: req.URL.String(), Status: resp.StatusCode}
+	if kept := body[:min(len(body), maxRecordedBody)]; len(kept) > 0 {
+		if json.Valid(kept) {
+			call.Response = kept
+		} else {
+			call.Response, _ = json.Marshal(string(bytes.TrimSpace(kept)))
+		}
+	}
+
+	conversation := conversationFrom(req.Context())
+	c.mu.Lock()
+	if c.calls == nil {
+		c.calls = map[string][]ServerCall{}
+	}
+	c.calls[conversation] = append(c.calls[conversation], call)
+	c.mu.Unlock()
+	return resp, nil
+}
+
+// take returns the calls conversation made since its last take
+func (c *callRecorder) take(conversation string) []ServerCall {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	calls := c.calls[conversation]
+	delete(c.calls, conversation)
+	return calls
+}
+
+// Outcome names for the exit codes, used in JSON output
+var outcomeNames = map[int]string{
+	exitOK:          "ok",
+	exitFailed:      "failed",
+	exitUnclear:     "unclear",
+	exitUnavailable: "unavailable",
+}
+
+// turnOutput is what --output=json prints for every message, one object per line
+type turnOutput struct {
+	Input           string            `json:"input"`
+	SessionID       string            `json:"session_id"`
+	RequestID       string            `json:"request_id,omitempty"`
+	Intent          string            `json:"intent,omitempty"`
+	Parameters      map[string]string `json:"parameters,omitempty"`
+	ClarifyQuestion string            `json:"clarify_question,omitempty"`
+	Actions         []ServerCall      `json:"actions"`
+	DryRun          bool              `json:"dry_run,omitempty"`
+	Planned         []ServerCall      `json:"planned,omitempty"`
+	Trains          []Train           `json:"trains,omitempty"` // trains the reply lists
+	Summary         string            `json:"summary,omitempty"`
+	Outcome         string            `json:"outcome"`
+	Error           string            `json:"error,omitempty"`
+}
+
+// printTurn writes the last turn as a JSON line
+func (a *BookingAgent) printTurn() {
+	if len(a.transcript) == 0 {
+		return
+	}
+	turn := a.transcript[len(a.transcript)-1]
+
+	o := turnOutput{
+		Input:           turn.User,
+		SessionID:       a.sessionID,
+		RequestID:       turn.RequestID,
+		Intent:          turn.Intent,
+		Parameters:      turn.Parameters,
+		ClarifyQuestion: turn.ClarifyQuestion,
+		Actions:         turn.Calls,
+		DryRun:          turn.DryRun,
+		Planned:         turn.Planned,
+		Trains:          turn.Trains,
+		Summary:         plain.String(turn.Reply),
+		Outcome:         outcomeNames[outcome(turn.Reply)],
+		Error:           turn.Error,
+	}
+	if o.Actions == nil {
+		o.Actions = []ServerCall{}
+	}
+	if turn.Error != "" {
+		o.Outcome = outcomeNames[exitUnavailable]
+	}
+
+	data, err := json.Marshal(o)
+	if err != nil {
+		fmt.Fprintf(notices, "❌ Cannot encode output: %v\n", err)
+		return
+	}
+	fmt.Fprintln(os.Stdout, string(data))
+}
@@ -0,0 +1,11 @@
+package main
+
+// Pets: "book K300, I'm traveling with my dog" books for a passenger with a
+// pet, which the provider refuses on trains that do not take pets, naming
+// those on the route that do. "Find pet-friendly trains from Beijing to
+// Shanghai" lists only trains that take pets; search results mark them 🐾.
+
+const (
+	petParam  = "\"yes\" when the passenger travels with a pet; empty otherwise"
+	petsParam = "\"yes\" to list only trains that take pets; empty otherwise"
+)
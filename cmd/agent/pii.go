@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// PII minimization: what DeepSeek is sent has user IDs, passenger names,
+// document numbers, emails and phone numbers replaced by placeholders such
+// as [USER_1], and its reply has them put back before any action runs. A
+// value keeps its placeholder for the whole session, so DeepSeek can still
+// tell that two mentions are the same person.
+
+// Identifiers recognized by their form
+var piiPatterns = []struct {
+	kind    string
+	pattern *regexp.Regexp
+}{
+	{"EMAIL", regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)},
+	{"PHONE", regexp.MustCompile(`\+\d[\d -]{6,14}\d|\b1[3-9]\d{9}\b`)},
+	{"DOCUMENT", regexp.MustCompile(`\b\d{17}[\dXx]\b|\b[A-Z]{1,2}\d{7,8}\b`)},
+}
+
+// User IDs as messages name them: "for user bob", "as user 4343", "user_id: bob"
+var userMention = regexp.MustCompile(`(?i)\b(?:(?:for|as) user|user[ _]id[:=]?)\s+([A-Za-z0-9][A-Za-z0-9_.@-]*)`)
+
+var placeholderPattern = regexp.MustCompile(`\[(?:USER|NAME|EMAIL|PHONE|DOCUMENT)_\d+\]`)
+
+// piiVault maps identifiers to their placeholders for one conversation
+type piiVault struct {
+	mu      sync.Mutex
+	byValue map[string]string // value -> placeholder
+	values  map[string]string // placeholder -> value
+	counts  map[string]int    // kind -> placeholders made
+	known   map[string]string // values recognized verbatim, such as saved passenger names -> kind
+}
+
+func newPIIVault() *piiVault {
+	return &piiVault{byValue: map[string]string{}, values: map[string]string{}, counts: map[string]int{}, known: map[string]string{}}
+}
+
+// learn makes value, of kind USER, NAME or DOCUMENT, scrubbed wherever it
+// appears from now on
+func (v *piiVault) learn(kind, value string) {
+	value = strings.TrimSpace(value)
+	if len([]rune(value)) < 2 || placeholderPattern.MatchString(value) {
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.known[value] = kind
+}
+
+// learnTravelers learns the names and documents of saved travelers
+func (v *piiVault) learnTravelers(travelers []Traveler) {
+	for _, t := range travelers {
+		v.learn("NAME", t.Name)
+		v.learn("DOCUMENT", t.Document)
+	}
+}
+
+func (v *piiVault) placeholderLocked(kind, value string) string {
+	if p, ok := v.byValue[value]; ok {
+		return p
+	}
+	v.counts[kind]++
+	p := fmt.Sprintf("[%s_%d]", kind, v.counts[kind])
+	v.byValue[value], v.values[p] = p, value
+	return p
+}
+
+// scrub replaces the identifiers in s with placeholders
+func (v *piiVault) scrub(s string) string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	// Longest first, so a full name goes before a shorter one inside it
+	known := make([]string, 0, len(v.known))
+	for value := range v.known {
+		known = append(known, value)
+	}
+	sort.Slice(known, func(i, j int) bool { return len(known[i]) > len(known[j]) })
+	for _, value := range known {
+		s = knownValuePattern(value).ReplaceAllStringFunc(s, func(match string) string {
+			return v.placeholderLocked(v.known[value], value)
+		})
+	}
+
+	for _, p := range piiPatterns {
+		s = p.pattern.ReplaceAllStringFunc(s, func(match string) string {
+			return v.placeholderLocked(p.kind, match)
+		})
+	}
+	return userMention.ReplaceAllStringFunc(s, func(match string) string {
+		id := userMention.FindStringSubmatch(match)[1]
+		if placeholderPattern.MatchString(id) {
+			return match
+		}
+		return strings.TrimSuffix(match, id) + v.placeholderLocked("USER", id)
+	})
+}
+
+// knownValuePattern matches value as a whole word, ignoring case
+func knownValuePattern(value string) *regexp.Regexp {
+	pattern := regexp.QuoteMeta(value)
+	if isWordByte(value[0]) {
+		pattern = `\b` + pattern
+	}
+	if isWordByte(value[len(value)-1]) {
+		pattern += `\b`
+	}
+	return regexp.MustCompile(`(?i)` + pattern)
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
+
+// restore puts the identifiers back in place of their placeholders
+func (v *piiVault) restore(s string) string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return placeholderPattern.ReplaceAllStringFunc(s, func(p string) string {
+		if value, ok := v.values[p]; ok {
+			return value
+		}
+		return p
+	})
+}
+
+// restoreIntent puts the identifiers back into DeepSeek's reading of a
+// message before it is acted on
+func (a *BookingAgent) restoreIntent(intent *IntentResponse) {
+	for name, value := range intent.Parameters {
+		intent.Parameters[name] = a.pii.restore(value)
+	}
+	intent.ClarifyQuestion = a.pii.restore(intent.ClarifyQuestion)
+}
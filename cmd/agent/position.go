@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Live position: "where is my train right now?" asks the provider where the
+// train is on its journey. Without a train named, it is the user's earliest
+// booked train.
+
+// TrainPosition is where a train is on its journey at AsOf
+type TrainPosition struct {
+	TrainID     string    `json:"train_id"`
+	From        string    `json:"from"`
+	To          string    `json:"to"`
+	Status      string    `json:"status"`   // scheduled, en_route or arrived
+	Progress    float64   `json:"progress"` // share of the journey done, 0 to 1
+	Description string    `json:"description"`
+	DepartsAt   time.Time `json:"departs_at"`
+	ArrivesAt   time.Time `json:"arrives_at"`
+	AsOf        time.Time `json:"as_of"`
+	Location    *struct {
+		Latitude  float64 `json:"latitude"`
+		Longitude float64 `json:"longitude"`
+	} `json:"location,omitempty"`
+}
+
+// Width of the progress bar shown for a train under way
+const positionBarWidth = 20
+
+func (a *BookingAgent) registerPositionTool() {
+	err := a.tools.register(&funcTool{
+		name:        "train_position",
+		description: "User asks where a train is right now or how far along its journey it is; without a train, the user's booked train",
+		parameters: objectSchema(map[string]string{
+			"train_id": "Train, such as G100; empty for the user's booked train",
+			"user_id":  userIDParam,
+		}),
+		run: func(ctx context.Context, p map[string]string) string {
+			return a.trainPosition(ctx, strings.ToUpper(strings.TrimSpace(p["train_id"])), p["user_id"])
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// trainPosition tells where trainID, or userID's booked train, is now
+func (a *BookingAgent) trainPosition(ctx context.Context, trainID, userID string) string {
+	if userID == "" {
+		userID = a.userID
+	}
+	if trainID == "" {
+		trip, ok := a.bookedTrip(ctx, userID, "")
+		if !ok {
+			return "🤔 Which train? Please give the train ID, such as G100."
+		}
+		trainID = trip.ID
+	}
+	position, err := a.provider.Position(ctx, trainID)
+	switch {
+	case errors.Is(err, ErrTrainNotFound):
+		return fmt.Sprintf("❌ Train %s not found", trainID)
+	case err != nil:
+		return a.providerError(ctx, "locating train", err)
+	}
+	return a.formatPosition(position)
+}
+
+// formatPosition describes a position, with a progress bar for a train under
+// way
+func (a *BookingAgent) formatPosition(p *TrainPosition) string {
+	if a.accessible {
+		return p.Description + "."
+	}
+	if p.Status != "en_route" {
+		return fmt.Sprintf("🚄 %s.", p.Description)
+	}
+	done := int(p.Progress * positionBarWidth)
+	bar := strings.Repeat("▓", done) + strings.Repeat("░", positionBarWidth-done)
+	return fmt.Sprintf("🚄 %s.\n%s %s %s", p.Description, p.From, bar, p.To)
+}
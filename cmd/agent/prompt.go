@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// The system prompt is a template with the API catalog and the examples in
+// separate files. A --prompt-dir may replace any of them.
+//
+//go:embed prompts
+var embeddedPrompts embed.FS
+
+const (
+	promptTemplateFile = "system.tmpl"
+	promptAPIsFile     = "apis.txt"
+	promptExamplesFile = "examples.txt"
+)
+
+// The template declares its version in a comment: {{/* prompt-version: 2 */}}
+var promptVersionPattern = regexp.MustCompile(`prompt-version:\s*([^\s*]+)`)
+
+// promptData is what the system template can refer to
+type promptData struct {
+	APIs     string
+	Examples string
+	Tools    string // every registered tool with its parameter schema
+	Intents  string // tool names for the response format, e.g. "query_ticket | book_ticket"
+}
+
+// promptSet is a rendered system prompt and where it came from
+type promptSet struct {
+	text    string
+	version string // declared in the template, "unversioned" if missing
+	hash    string // first 12 hex digits of the SHA-256 of the rendered prompt
+	source  string // "embedded" or the override directory
+}
+
+// loadPrompts renders the system prompt for tools from the embedded files,
+// replacing any that exist in dir
+func loadPrompts(dir string, tools *toolRegistry) (*promptSet, error) {
+	read := func(name string) (string, error) {
+		if dir != "" {
+			data, err := os.ReadFile(filepath.Join(dir, name))
+			if err == nil {
+				return string(data), nil
+			}
+			if !errors.Is(err, fs.ErrNotExist) {
+				return "", err
+			}
+		}
+		data, err := embeddedPrompts.ReadFile("prompts/" + name)
+		return string(data), err
+	}
+
+	source, err := read(promptTemplateFile)
+	if err != nil {
+		return nil, err
+	}
+	apis, err := read(promptAPIsFile)
+	if err != nil {
+		return nil, err
+	}
+	examples, err := read(promptExamplesFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl, err := template.New(promptTemplateFile).Option("missingkey=error").Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	data := promptData{
+		APIs:     strings.TrimSpace(apis),
+		Examples: strings.TrimSpace(examples),
+		Tools:    tools.catalog(),
+		Intents:  strings.Join(tools.names(), " | "),
+	}
+	if err := tmpl.Execute(&b, data); err != nil {
+		return nil, err
+	}
+
+	p := &promptSet{text: strings.TrimSpace(b.String()), version: "unversioned", source: "embedded"}
+	if m := promptVersionPattern.FindStringSubmatch(source); m != nil {
+		p.version = m[1]
+	}
+	if dir != "" {
+		p.source = dir
+	}
+	sum := sha256.Sum256([]byte(p.text))
+	p.hash = hex.EncodeToString(sum[:])[:12]
+	return p, nil
+}
+
+// String identifies the prompt in logs and transcripts, e.g. "2 (4504956dcde1)"
+func (p *promptSet) String() string {
+	if p == nil {
+		return ""
+	}
+	return p.version + " (" + p.hash + ")"
+}
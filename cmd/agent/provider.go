@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// ProviderAdapter is a rail operator's booking API. The agent reaches trains
+// and bookings only through it, so supporting another operator, such as a
+// 12306-style gateway, means writing an adapter and registering it with
+// registerProvider; no agent code changes. localServer, for the booking server
+// in cmd/server, is the built-in adapter.
+type ProviderAdapter interface {
+	// Name is the name the adapter is registered under, e.g. "local"
+	Name() string
+
+	// Search returns the trains matching q. A zero query returns every train;
+	// trains named in q.TrainIDs that do not exist are left out.
+	Search(ctx context.Context, q SearchQuery) ([]Train, error)
+
+	// Book books every train in req for req.UserID, all or nothing, and returns
+	// the references of the bookings made; none if the provider issues none
+	Book(ctx context.Context, req BookingRequest) ([]string, error)
+
+	// Cancel cancels one of the user's tickets on req.TrainID and returns its
+	// refund; nil when no booking is named or the provider does not refund
+	Cancel(ctx context.Context, req CancelRequest) (*Refund, error)
+
+	// Status returns userID's booked tickets
+	Status(ctx context.Context, userID string) ([]UserBooking, error)
+
+	// Receipt returns the fare breakdown of userID's booking ref, or ErrNoReceipt
+	Receipt(ctx context.Context, ref, userID string) (*Receipt, error)
+	// Upgrade moves a ticket of a booking to a higher class, charging the
+	// fare difference
+	Upgrade(ctx context.Context, req UpgradeRequest) (*Upgrade, error)
+	// Position returns where trainID is on its journey now
+	Position(ctx context.Context, trainID string) (*TrainPosition, error)
+	// FareCalendar returns the lowest fare of each day on a route
+	FareCalendar(ctx context.Context, q FareCalendarQuery) (*FareCalendar, error)
+	// Punctuality returns how late trainID has run recently
+	Punctuality(ctx context.Context, trainID string) (*Punctuality, error)
+	// Menu returns what trainID serves, or a ProviderError if it has no dining
+	Menu(ctx context.Context, trainID string) (*Menu, error)
+	// OrderMeals replaces the meals pre-ordered for a train of a booking and
+	// returns the updated receipt
+	OrderMeals(ctx context.Context, req MealOrder) (*Receipt, error)
+	// DeclareLuggage replaces the luggage declared beyond the free allowance
+	// for a train of a booking and returns the updated receipt; ErrSoldOut when
+	// the train has no room left for it
+	DeclareLuggage(ctx context.Context, req LuggageDeclaration) (*Receipt, error)
+	// SeatMap returns trainID's standard class seats and those chosen
+	SeatMap(ctx context.Context, trainID string) (*SeatMap, error)
+	// ChooseSeat gives a standard class ticket of a booking the seat asked
+	// for and returns the updated receipt; ErrSoldOut when it is taken
+	ChooseSeat(ctx context.Context, req SeatChoice) (*Receipt, error)
+
+	// OpenSupportCase hands an issue over to the operator's staff and returns
+	// the case number to give the user
+	OpenSupportCase(ctx context.Context, req SupportRequest) (string, error)
+	// SendFeedback records a trip rating or complaint and returns its ID
+	SendFeedback(ctx context.Context, req FeedbackRequest) (string, error)
+	// TakeNotices returns the user's notifications waiting for the agent, such
+	// as a delay announced since the last turn, and marks them shown
+	TakeNotices(ctx context.Context, userID string) ([]Notice, error)
+
+	// Travelers returns the people userID has saved to book for
+	Travelers(ctx context.Context, userID string) ([]Traveler, error)
+	// SaveTravelers replaces userID's saved travelers and returns them as kept
+	SaveTravelers(ctx context.Context, userID string, travelers []Traveler) ([]Traveler, error)
+}
+
+// SearchQuery selects trains; empty fields match anything
+type SearchQuery struct {
+	From     string
+	To       string
+	Date     string // YYYY-MM-DD
+	TrainIDs []string
+	Pets     bool // only trains that take pets
+}
+
+// BookingRequest is one booking of one or more trains
+type BookingRequest struct {
+	TrainIDs   []string
+	UserID     string
+	Insurance  bool // insure every ticket, where the provider offers insurance
+	Pet        bool // the passenger travels with a pet; refused on trains that do not take pets
+	Wheelchair bool // a wheelchair-accessible seat on every train; refused when a train has none left
+
+	// Labels of the saved travelers ("self" for the user) each given a ticket
+	// on every train; none books one ticket for the user
+	Travelers []string
+
+	// Sent again when the same booking is retried, so the provider books it
+	// at most once; adapters whose API has no such key may ignore it
+	IdempotencyKey string
+
+	// The answer to the challenge the booking was refused with last time
+	Challenge *ChallengeAnswer
+}
+
+// CancelRequest cancels one ticket on TrainID; naming its booking lets the
+// provider refund it by the booking's receipt and insurance
+type CancelRequest struct {
+	TrainID    string
+	UserID     string
+	BookingRef string
+}
+
+// SupportRequest is an issue the agent could not resolve
+type SupportRequest struct {
+	UserID      string
+	Subject     string
+	Description string // the recent conversation
+	BookingRef  string // the booking concerned, if any
+}
+
+// UpgradeRequest moves the ticket on TrainID of booking BookingRef to Class;
+// TrainID may be empty for a booking of one train
+type UpgradeRequest struct {
+	UserID     string
+	BookingRef string
+	TrainID    string
+	Class      string // e.g. first or business
+}
+
+// MealOrder is the meals wanted on TrainID of booking BookingRef; no meals
+// cancels the order. TrainID may be empty for a booking of one train.
+type MealOrder struct {
+	UserID     string
+	BookingRef string
+	TrainID    string
+	Meals      []MealChoice
+}
+
+// MealChoice is a quantity of one menu item
+type MealChoice struct {
+	Item     string `json:"item"` // menu item ID
+	Quantity int    `json:"quantity"`
+}
+
+// LuggageDeclaration is the luggage beyond the free allowance taken on
+// TrainID with booking BookingRef; no luggage withdraws the declaration.
+// TrainID may be empty for a booking of one train.
+type LuggageDeclaration struct {
+	UserID     string
+	BookingRef string
+	TrainID    string
+	Luggage    []LuggageChoice
+}
+
+// LuggageChoice is a quantity of one kind of luggage
+type LuggageChoice struct {
+	Item     string `json:"item"` // e.g. bicycle or oversized
+	Quantity int    `json:"quantity"`
+}
+
+// FeedbackRequest rates a trip (Rating 1-5) or, with Rating 0, complains
+// about it; it names the train, the booking or both
+type FeedbackRequest struct {
+	UserID     string
+	TrainID    string
+	BookingRef string
+	Rating     int
+	Message    string
+}
+
+// Notice is a change to the user's trip the provider wants them told about
+type Notice struct {
+	ID       string `json:"notification_id"`
+	Type     string `json:"type"` // train.delayed, train.platform_changed, train.departure_reminder or waitlist.promoted
+	TrainID  string `json:"train_id"`
+	Message  string `json:"message"`
+	Provider string `json:"-"`
+}
+
+// Reasons a provider turns a request down, tested with errors.Is
+var (
+	ErrTrainNotFound = errors.New("train not found")
+	ErrSoldOut       = errors.New("no tickets available")
+	ErrNoBooking     = errors.New("no ticket to cancel")
+	ErrInvalid       = errors.New("invalid request")
+	ErrNoReceipt     = errors.New("no receipt for this booking")
+	ErrDeparted      = errors.New("booking closed for a departing train")
+	ErrNotAllowed    = errors.New("not allowed by the provider's rules")
+	// The user already has the most tickets the provider allows on a train
+	ErrTrainLimit = fmt.Errorf("%w: ticket limit per train", ErrNotAllowed)
+	// The provider holds back a user, or a client, that booked too much in a
+	// short time: for a while, or until they are verified
+	ErrThrottled            = errors.New("too many requests")
+	ErrVerificationRequired = fmt.Errorf("%w: verification required", ErrNotAllowed)
+)
+
+// ProviderError is a request the provider answered but refused. Any other
+// error from an adapter means the provider could not be reached.
+type ProviderError struct {
+	Kind    error  // one of the Err values above, or nil for other failures
+	Message string // the provider's explanation, e.g. "K300: no tickets available"
+	TrainID string // the train refused, when the provider names it
+
+	// The fields an ErrInvalid request was turned down for, when the
+	// provider names them
+	Fields []FieldError
+
+	// What to answer before an ErrVerificationRequired request may go ahead,
+	// when the provider poses a challenge
+	Challenge *Challenge
+}
+
+// FieldError is one field of a request the provider turned down, such as
+// the document of a traveler
+type FieldError struct {
+	Field    string `json:"field"`              // e.g. "document"
+	Traveler string `json:"traveler,omitempty"` // label of the traveler it belongs to
+	Message  string `json:"message"`
+}
+
+// Challenge is a question the provider asks a user it holds back, such as a
+// sum or a one-time code sent to them
+type Challenge struct {
+	ID     string `json:"id"`
+	Prompt string `json:"prompt"` // e.g. "What is 7 + 12?"
+}
+
+// ChallengeAnswer answers a Challenge
+type ChallengeAnswer struct {
+	ID     string
+	Answer string
+}
+
+func (e *ProviderError) Error() string { return e.Message }
+
+func (e *ProviderError) Unwrap() error { return e.Kind }
+
+// ProviderFactory makes an adapter for endpoint (--server-url). Requests sent
+// through client are logged and recorded in transcripts, and it carries
+// --server-timeout.
+type ProviderFactory func(endpoint string, client *http.Client) (ProviderAdapter, error)
+
+var providers = map[string]ProviderFactory{
+	"local": newLocalServer,
+}
+
+// registerProvider makes an adapter available to --provider; call it from an
+// init function in the adapter's file
+func registerProvider(name string, factory ProviderFactory) {
+	if _, ok := providers[name]; ok {
+		panic(fmt.Sprintf("provider %q is already registered", name))
+	}
+	providers[name] = factory
+}
+
+func providerNames() []string {
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newProvider returns the adapter called name for endpoint
+func newProvider(name, endpoint string, client *http.Client) (ProviderAdapter, error) {
+	factory, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %q: want %s", name, strings.Join(providerNames(), " or "))
+	}
+	return factory(endpoint, client)
+}
+
+// providerError describes a failed provider call made while doing action,
+// e.g. "booking ticket". Refusals show the provider's explanation; anything
+// else means it could not be reached.
+func (a *BookingAgent) providerError(ctx context.Context, action string, err error) string {
+	var refused *ProviderError
+	if errors.As(err, &refused) {
+		return fmt.Sprintf("❌ Error: %s", refused.Message)
+	}
+	return a.serverError(ctx, action, err)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Punctuality: "how punctual is K300?" reports how late a train usually runs.
+// Search results with several trains to choose from cite each one's record,
+// so options can be compared on reliability as well as time.
+
+// Punctuality is how late a train has arrived on its recent runs
+type Punctuality struct {
+	TrainID              string  `json:"train_id"`
+	From                 string  `json:"from"`
+	To                   string  `json:"to"`
+	Runs                 int     `json:"runs"`
+	AverageDelayMinutes  float64 `json:"average_delay_minutes"`
+	OnTimePercent        float64 `json:"on_time_percent"`
+	MaxDelayMinutes      int     `json:"max_delay_minutes"`
+	ExpectedDelayMinutes int     `json:"expected_delay_minutes"`
+	Route                struct {
+		Runs                int     `json:"runs"`
+		AverageDelayMinutes float64 `json:"average_delay_minutes"`
+		OnTimePercent       float64 `json:"on_time_percent"`
+	} `json:"route"`
+}
+
+func (a *BookingAgent) registerPunctualityTool() {
+	err := a.tools.register(&funcTool{
+		name:        "train_punctuality",
+		description: "User asks how punctual a train is, how often it runs late or how long its delays are",
+		parameters: objectSchema(map[string]string{
+			"train_id": "Train, such as K300",
+		}, "train_id"),
+		run: func(ctx context.Context, p map[string]string) string {
+			return a.trainPunctuality(ctx, strings.ToUpper(strings.TrimSpace(p["train_id"])))
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// trainPunctuality reports trainID's delay record against its route's
+func (a *BookingAgent) trainPunctuality(ctx context.Context, trainID string) string {
+	if trainID == "" {
+		return "🤔 Which train? Please give the train ID, such as G100."
+	}
+	p, err := a.provider.Punctuality(ctx, trainID)
+	switch {
+	case errors.Is(err, ErrTrainNotFound):
+		return fmt.Sprintf("❌ Train %s not found", trainID)
+	case err != nil:
+		return a.providerError(ctx, "fetching punctuality", err)
+	}
+	result := fmt.Sprintf("%s arrived on time on %.0f%% of its last %d runs, %.0f minutes late on average and %d at worst. Expect about %d minutes' delay. Trains from %s to %s are on time %.0f%% of the time.",
+		p.TrainID, p.OnTimePercent, p.Runs, p.AverageDelayMinutes, p.MaxDelayMinutes, p.ExpectedDelayMinutes, p.From, p.To, p.Route.OnTimePercent)
+	if a.accessible {
+		return result
+	}
+	return "⏱️ " + result
+}
+
+// punctualityNotes gives each of trains its short delay record, e.g. "92% on
+// time, avg 3 min late", or in accessible mode a sentence; trains the
+// provider has no record of are left out
+func (a *BookingAgent) punctualityNotes(ctx context.Context, trains []Train) map[string]string {
+	notes := map[string]string{}
+	for _, train := range trains {
+		p, err := a.provider.Punctuality(ctx, train.ID)
+		if err != nil {
+			logger.Debug("punctuality unavailable", "train", train.ID, "err", err)
+			continue
+		}
+		if a.accessible {
+			notes[train.ID] = fmt.Sprintf("Train %s is on time %.0f%% of the time, %.0f minutes late on average.", train.ID, p.OnTimePercent, p.AverageDelayMinutes)
+		} else {
+			notes[train.ID] = fmt.Sprintf("%.0f%% on time, avg %.0f min late", p.OnTimePercent, p.AverageDelayMinutes)
+		}
+	}
+	return notes
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Receipts: the booking server prices every booking it makes and keeps the
+// breakdown under a booking reference. The agent shows it after each booking
+// and again when asked, e.g. "show the receipt" or "receipt for BK1A2B3C4D5E".
+
+// Receipt is the itemized fare breakdown of one booking
+type Receipt struct {
+	Ref        string        `json:"booking_ref"`
+	UserID     string        `json:"user_id"`
+	Currency   string        `json:"currency,omitempty"` // empty when the provider does not price tickets
+	Items      []ReceiptItem `json:"items"`
+	Subtotal   float64       `json:"subtotal"`
+	Fees       []ReceiptLine `json:"fees"`
+	Taxes      []ReceiptLine `json:"taxes"`
+	AddOns     []AddOn       `json:"add_ons,omitempty"`
+	PointsUsed int           `json:"points_used"`
+	Total      float64       `json:"total"`
+	Refunds    []Refund      `json:"refunds,omitempty"` // of cancelled tickets, not taken off the total
+	Provider   string        `json:"provider,omitempty"`
+}
+
+// ReceiptItem is one ticket of a booking
+type ReceiptItem struct {
+	TrainID        string        `json:"train_id"`
+	From           string        `json:"from"`
+	To             string        `json:"to"`
+	Date           string        `json:"date"`
+	DepartureTime  string        `json:"departure_time"`
+	Class          string        `json:"class"`
+	BaseFare       float64       `json:"base_fare"`
+	ClassSurcharge float64       `json:"class_surcharge"`
+	Discounts      []ReceiptLine `json:"discounts"`
+	Amount         float64       `json:"amount"`
+	Accessible     bool          `json:"accessible,omitempty"` // a wheelchair-accessible seat
+	Seat           string        `json:"seat,omitempty"`       // chosen by the passenger, e.g. 1-12F
+	Traveler       string        `json:"traveler,omitempty"`   // name of the saved traveler it is for
+	PassengerType  string        `json:"passenger_type,omitempty"`
+}
+
+// AddOn is an extra bought with a booking, such as a meal or insurance
+type AddOn struct {
+	Kind     string  `json:"kind"`
+	TrainID  string  `json:"train_id"`
+	Name     string  `json:"name"`
+	Quantity int     `json:"quantity"`
+	Amount   float64 `json:"amount"`
+}
+
+// Refund is what cancelling a ticket of a booking gave back
+type Refund struct {
+	TrainID  string        `json:"train_id"`
+	Insured  bool          `json:"insured"`
+	Lines    []ReceiptLine `json:"lines"`
+	Amount   float64       `json:"amount"`
+	Currency string        `json:"currency,omitempty"` // empty when the provider does not price tickets
+}
+
+// ReceiptLine is a named discount, fee or tax
+type ReceiptLine struct {
+	Name   string  `json:"name"`
+	Amount float64 `json:"amount"`
+}
+
+var bookingRefPattern = regexp.MustCompile(`(?i)\bBK[0-9A-F]{10}\b`)
+
+func (a *BookingAgent) registerReceiptTool() {
+	err := a.tools.register(&funcTool{
+		name:        "booking_receipt",
+		description: "User wants the receipt or fare breakdown of a booking; without a booking reference, the last booking made in this conversation",
+		parameters:  objectSchema(map[string]string{"booking_ref": "Booking reference such as BK1A2B3C4D5E", "user_id": userIDParam}),
+		run: func(ctx context.Context, p map[string]string) string {
+			return a.showReceipts(ctx, p["booking_ref"], p["user_id"])
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// showReceipts answers a receipt request for ref, or for the last booking
+func (a *BookingAgent) showReceipts(ctx context.Context, ref, userID string) string {
+	user := userID
+	if user == "" {
+		user = a.userID
+	}
+	refs := a.lastBookingRefs
+	if ref != "" {
+		refs = []string{strings.ToUpper(ref)}
+	}
+	if len(refs) == 0 {
+		return "🤔 Which booking? Please give its booking reference, such as BK1A2B3C4D5E."
+	}
+
+	var parts []string
+	for _, ref := range refs {
+		receipt, err := a.provider.Receipt(ctx, ref, user)
+		switch {
+		case errors.Is(err, ErrNoReceipt):
+			parts = append(parts, fmt.Sprintf("❌ No receipt found for booking %s of user %s", ref, user))
+		case err != nil:
+			parts = append(parts, a.providerError(ctx, "fetching receipt", err))
+		default:
+			parts = append(parts, a.formatReceipt(receipt))
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// bookingReceipts is appended to a booking's reply: the receipts of refs, or
+// just the references if they cannot be fetched. The booking succeeded either
+// way, so failures are not reported as errors.
+func (a *BookingAgent) bookingReceipts(ctx context.Context, refs []string, user string) string {
+	a.lastBookingRefs = refs
+	var parts []string
+	for _, ref := range refs {
+		receipt, err := a.provider.Receipt(ctx, ref, user)
+		if err != nil {
+			logger.Warn("cannot fetch receipt", "ref", ref, "err", err)
+			parts = append(parts, fmt.Sprintf("🧾 Booking reference: %s", ref))
+			continue
+		}
+		parts = append(parts, a.formatReceipt(receipt))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "\n" + strings.Join(parts, "\n")
+}
+
+// formatReceipt shows a receipt line by line, or as sentences in accessible mode
+func (a *BookingAgent) formatReceipt(r *Receipt) string {
+	if r.Currency == "" {
+		// Unpriced tickets: the breakdown would be all zeros
+		if a.accessible {
+			lines := []string{fmt.Sprintf("Booking reference %s.", r.Ref)}
+			for _, item := range r.Items {
+				if item.Accessible {
+					lines = append(lines, fmt.Sprintf("Wheelchair-accessible seat on train %s.", item.TrainID))
+				}
+				if item.Seat != "" {
+					lines = append(lines, fmt.Sprintf("Seat %s on train %s.", item.Seat, item.TrainID))
+				}
+				if item.Traveler != "" {
+					lines = append(lines, fmt.Sprintf("Ticket on train %s for %s, %s.", item.TrainID, item.Traveler, item.PassengerType))
+				}
+			}
+			for _, addOn := range r.AddOns {
+				lines = append(lines, fmt.Sprintf("%s on train %s: %d %s.", addOnKind(addOn), addOn.TrainID, addOn.Quantity, addOn.Name))
+			}
+			return strings.Join(lines, "\n")
+		}
+		lines := []string{fmt.Sprintf("🧾 Booking reference: %s%s", r.Ref, providerTag(r.Provider))}
+		for _, item := range r.Items {
+			if item.Accessible {
+				lines = append(lines, fmt.Sprintf("♿ Wheelchair-accessible seat on %s", item.TrainID))
+			}
+			if item.Seat != "" {
+				lines = append(lines, fmt.Sprintf("💺 Seat %s on %s", item.Seat, item.TrainID))
+			}
+			if item.Traveler != "" {
+				lines = append(lines, fmt.Sprintf("👤 %s (%s) on %s", item.Traveler, item.PassengerType, item.TrainID))
+			}
+		}
+		for _, addOn := range r.AddOns {
+			lines = append(lines, fmt.Sprintf("%s %d× %s on %s", addOnIcon(addOn), addOn.Quantity, addOn.Name, addOn.TrainID))
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	money := func(amount float64) string { return fmt.Sprintf("%.2f", amount) }
+	if a.accessible {
+		lines := []string{fmt.Sprintf("Receipt for booking %s.", r.Ref)}
+		for _, item := range r.Items {
+			seat := ""
+			if item.Accessible {
+				seat = ", wheelchair-accessible seat"
+			}
+			if item.Seat != "" {
+				seat += ", seat " + item.Seat
+			}
+			if item.Traveler != "" {
+				seat += ", for " + item.Traveler
+			}
+			lines = append(lines, fmt.Sprintf("Train %s from %s to %s, %s class%s: %s %s.", item.TrainID, item.From, item.To, item.Class, seat, money(item.Amount), r.Currency))
+		}
+		for _, line := range append(r.Fees, r.Taxes...) {
+			lines = append(lines, fmt.Sprintf("%s: %s %s.", line.Name, money(line.Amount), r.Currency))
+		}
+		for _, addOn := range r.AddOns {
+			lines = append(lines, fmt.Sprintf("%s on train %s: %d %s, %s %s.", addOnKind(addOn), addOn.TrainID, addOn.Quantity, addOn.Name, money(addOn.Amount), r.Currency))
+		}
+		if r.PointsUsed > 0 {
+			lines = append(lines, fmt.Sprintf("%d points used.", r.PointsUsed))
+		}
+		lines = append(lines, fmt.Sprintf("Total: %s %s.", money(r.Total), r.Currency))
+		for _, refund := range r.Refunds {
+			lines = append(lines, fmt.Sprintf("Refunded for train %s: %s %s.", refund.TrainID, money(refund.Amount), r.Currency))
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "🧾 Receipt %s%s\n", r.Ref, providerTag(r.Provider))
+	for _, item := range r.Items {
+		seat := ""
+		if item.Accessible {
+			seat = " ♿"
+		}
+		if item.Seat != "" {
+			seat += " seat " + item.Seat
+		}
+		if item.Traveler != "" {
+			seat += " for " + item.Traveler
+		}
+		fmt.Fprintf(&b, "• %s %s → %s, %s %s, %s class%s: %s\n", item.TrainID, item.From, item.To, item.Date, item.DepartureTime, item.Class, seat, money(item.BaseFare))
+		if item.ClassSurcharge != 0 {
+			fmt.Fprintf(&b, "    Class surcharge: %s\n", money(item.ClassSurcharge))
+		}
+		for _, discount := range item.Discounts {
+			fmt.Fprintf(&b, "    %s: %s\n", discount.Name, money(discount.Amount))
+		}
+	}
+	fmt.Fprintf(&b, "Subtotal: %s\n", money(r.Subtotal))
+	for _, line := range append(r.Fees, r.Taxes...) {
+		fmt.Fprintf(&b, "%s: %s\n", line.Name, money(line.Amount))
+	}
+	for _, addOn := range r.AddOns {
+		fmt.Fprintf(&b, "%s %d× %s (%s): %s\n", addOnIcon(addOn), addOn.Quantity, addOn.Name, addOn.TrainID, money(addOn.Amount))
+	}
+	if r.PointsUsed > 0 {
+		fmt.Fprintf(&b, "Points used: %d\n", r.PointsUsed)
+	}
+	fmt.Fprintf(&b, "Total: %s %s", r.Currency, money(r.Total))
+	for _, refund := range r.Refunds {
+		fmt.Fprintf(&b, "\n↩️ Refunded for %s: %s %s", refund.TrainID, r.Currency, money(refund.Amount))
+	}
+	return b.String()
+}
+
+// addOnKind names an add-on's kind in a sentence, e.g. "Meal"
+func addOnKind(addOn AddOn) string {
+	if addOn.Kind == "" {
+		return "Extra"
+	}
+	return strings.ToUpper(addOn.Kind[:1]) + addOn.Kind[1:]
+}
+
+func addOnIcon(addOn AddOn) string {
+	switch addOn.Kind {
+	case "meal":
+		return "🍱"
+	case "insurance":
+		return "🛡️"
+	case "luggage":
+		return "🧳"
+	}
+	return "➕"
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// References to trains shown earlier are resolved by the agent, not
+// DeepSeek: "the second one" is lastResults[1] and "that train" the train
+// the last action was about. DeepSeek still reads what the user wants done,
+// and sees the trains last shown in order, but its train_id is replaced by
+// the resolved one. References the agent cannot settle are left to it.
+
+var ordinals = map[string]int{
+	"first": 1, "second": 2, "third": 3, "fourth": 4, "fifth": 5,
+	"sixth": 6, "seventh": 7, "eighth": 8, "ninth": 9, "tenth": 10,
+	"1st": 1, "2nd": 2, "3rd": 3, "4th": 4, "5th": 5,
+	"6th": 6, "7th": 7, "8th": 8, "9th": 9, "10th": 10,
+	"last": -1,
+}
+
+// "the second one", "the last train", "option 3", "that train", "the same one";
+// a bare "the first" must end the clause, so "the first class" is no train
+var trainReference = regexp.MustCompile(`(?i)\bthe\s+(first|second|third|fourth|fifth|sixth|seventh|eighth|ninth|tenth|1st|2nd|3rd|[4-9]th|10th|last)(?:\s+(?:one|train|option|result)\b|\s*(?:[,.!?;]|\band\b|$))` +
+	`|\b(?:option|result)\s+#?(\d{1,2})\b` +
+	`|\b(?:that|this|the same)\s+(?:one|train)\b`)
+
+var explicitTrainID = regexp.MustCompile(`(?i)\b[A-Z]-?\d+\b`)
+
+// resolveReferences finds the trains message refers to. ids is nil when it
+// refers to none, or to one the agent cannot tell; question is set when it
+// names a position past the trains shown.
+func (a *BookingAgent) resolveReferences(message string) (ids []string, question string) {
+	for _, m := range trainReference.FindAllStringSubmatch(message, -1) {
+		var id string
+		switch {
+		case m[1] != "" || m[2] != "":
+			n := ordinals[strings.ToLower(m[1])]
+			if m[2] != "" {
+				fmt.Sscan(m[2], &n)
+			}
+			if n < 0 {
+				n = len(a.lastResults)
+			}
+			if len(a.lastResults) == 0 {
+				return nil, ""
+			}
+			if n < 1 || n > len(a.lastResults) {
+				return nil, fmt.Sprintf("I only listed %d, so there is no number %d. Which train do you mean?", len(a.lastResults), n)
+			}
+			id = a.lastResults[n-1].ID
+		case a.focusTrain != "":
+			id = a.focusTrain
+		case len(a.lastResults) == 1:
+			id = a.lastResults[0].ID
+		default:
+			return nil, ""
+		}
+		ids = append(ids, id)
+	}
+	return ids, ""
+}
+
+// applyReferences puts the trains message refers to into intent, for tools
+// taking a train_id
+func (a *BookingAgent) applyReferences(intent *IntentResponse, message string) {
+	tool, ok := a.tools.lookup(intent.Intent)
+	if !ok || intent.ClarifyQuestion != "" {
+		return
+	}
+	if _, ok := tool.Parameters().Properties["train_id"]; !ok {
+		return
+	}
+	if explicitTrainID.MatchString(message) {
+		// "the first one and G300": which is which is DeepSeek's to read
+		return
+	}
+	ids, question := a.resolveReferences(message)
+	if question != "" {
+		intent.ClarifyQuestion, intent.settled = question, true
+		return
+	}
+	if len(ids) == 0 {
+		return
+	}
+	resolved := strings.Join(ids, ",")
+	if got := intent.Parameters["train_id"]; got != resolved {
+		debugLog.Printf("Train reference in %q resolved to %s; DeepSeek read %q", message, resolved, got)
+	}
+	if intent.Parameters == nil {
+		// The model may leave parameters out, or send null
+		intent.Parameters = map[string]string{}
+	}
+	intent.Parameters["train_id"] = resolved
+	intent.MissingParameters = slices.DeleteFunc(intent.MissingParameters, func(p string) bool { return p == "train_id" })
+}
+
+// noteFocus remembers the one train an action was about, for "that train"
+func (a *BookingAgent) noteFocus(intent *IntentResponse) {
+	if id := intent.Parameters["train_id"]; id != "" && !strings.Contains(id, ",") {
+		a.focusTrain = id
+	}
+}
+
+// resultsContext lists the trains last shown, in order, for DeepSeek
+func (a *BookingAgent) resultsContext() string {
+	if len(a.lastResults) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("Trains last shown to the user, in order; \"the first one\" is number 1:")
+	for i, t := range a.lastResults {
+		fmt.Fprintf(&b, "\n%d. %s %s → %s %s %s", i+1, t.ID, t.From, t.To, t.Date, t.DepartureTime)
+	}
+	return b.String()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResolveReferences(t *testing.T) {
+	shown := []Train{{ID: "G100"}, {ID: "D200"}, {ID: "K300"}}
+	tests := []struct {
+		name     string
+		message  string
+		results  []Train
+		focus    string
+		ids      []string
+		question string
+	}{
+		{"ordinal", "book the second one", shown, "", []string{"D200"}, ""},
+		{"ordinal alone", "book the first, please", shown, "", []string{"G100"}, ""},
+		{"number", "book 3rd train", shown, "", nil, ""},
+		{"option", "option #3 looks good", shown, "", []string{"K300"}, ""},
+		{"last", "book the last train", shown, "", []string{"K300"}, ""},
+		{"several", "book the first one and the third one", shown, "", []string{"G100", "K300"}, ""},
+		{"that train", "cancel that train", shown, "D200", []string{"D200"}, ""},
+		{"that train without a focus", "cancel that train", shown, "", nil, ""},
+		{"that train of one shown", "book this one", shown[:1], "", []string{"G100"}, ""},
+		{"past the results", "book the fifth one", shown, "", nil, "I only listed 3, so there is no number 5. Which train do you mean?"},
+		{"nothing shown", "book the second one", nil, "", nil, ""},
+		{"first class", "book the first class seat on G100", shown, "", nil, ""},
+		{"no reference", "book a train to Shanghai", shown, "D200", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &BookingAgent{lastResults: tt.results, focusTrain: tt.focus}
+			ids, question := a.resolveReferences(tt.message)
+			if !reflect.DeepEqual(ids, tt.ids) || question != tt.question {
+				t.Errorf("resolved to %q asking %q, want %q asking %q", ids, question, tt.ids, tt.question)
+			}
+		})
+	}
+}
+
+func TestReferencesOverrideModel(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		// The model's train_id for message
+		read string
+		// The trains shown before, by position, the one meant
+		want int
+	}{
+		{"misread ordinal", "book the second one", "G100", 2},
+		{"left out", "book the first one", "", 1},
+		{"explicit ID left to the model", "book the first one and K300", "K300", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reading := `{"intent":"book_ticket","parameters":{"train_id":"` + tt.read + `"},"confidence":0.9}`
+			a := offlineAgent(t, `{"intent":"list_trains","parameters":{},"confidence":0.9}`, reading)
+			turn(t, a, "list trains")
+			if len(a.lastResults) < 2 {
+				t.Fatalf("%d trains listed", len(a.lastResults))
+			}
+			want := tt.read
+			if tt.want > 0 {
+				want = a.lastResults[tt.want-1].ID
+			}
+			entry := turn(t, a, tt.message)
+			if entry.Parameters["train_id"] != want {
+				t.Errorf("%q booked %q, want %q", tt.message, entry.Parameters["train_id"], want)
+			}
+			if a.focusTrain != want {
+				t.Errorf("focus on %q afterwards, want %q", a.focusTrain, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Replies that are not the intent JSON are first unwrapped, since models like
+// to put JSON in ```json fences or after a sentence, and then sent back to the
+// model with the parse error, up to llmRepairs times, before the user is told
+// their message was not understood.
+
+const defaultLLMRepairs = 2
+
+// unwrapJSON strips what surrounds the outermost object, such as ```json
+// fences or a sentence introducing it
+func unwrapJSON(reply string) string {
+	start, end := strings.Index(reply, "{"), strings.LastIndex(reply, "}")
+	if start < 0 || end < start {
+		return reply
+	}
+	return reply[start : end+1]
+}
+
+// parseIntent reads the intent JSON from a model's reply
+func parseIntent(reply string) (*IntentResponse, error) {
+	var intent IntentResponse
+	if err := json.Unmarshal([]byte(unwrapJSON(reply)), &intent); err != nil {
+		return nil, err
+	}
+	return &intent, nil
+}
+
+// repairPrompt asks the model to answer again in the format it was given
+func repairPrompt(err error) string {
+	return fmt.Sprintf("Your last reply could not be parsed as JSON (%v). Reply again with only the JSON object described above: no code fences, no text before or after it.", err)
+}
+
+// readIntent asks the model about messages and parses its reply, asking again
+// with the parse error while repairs are left. The intent is nil, with no
+// error, when no reply parsed.
+func (a *BookingAgent) readIntent(ctx context.Context, userInput string, messages []Message) (intent *IntentResponse, model string, err error) {
+	reply, model, err := a.ask(ctx, userInput, messages)
+	if err != nil {
+		return nil, "", err
+	}
+	debugLog.Printf("DeepSeek response: %q", reply)
+	intent, parseErr := parseIntent(reply)
+	for attempt := 1; parseErr != nil; attempt++ {
+		logger.WarnContext(ctx, "unparseable llm response", "err", parseErr, "model", model, "attempt", attempt)
+		if attempt > a.llmRepairs {
+			debugLog.Printf("Cannot parse DeepSeek response as JSON: %v", parseErr)
+			return nil, model, nil
+		}
+		debugLog.Printf("Cannot parse DeepSeek response as JSON, asking again (%d of %d): %v", attempt, a.llmRepairs, parseErr)
+		messages = append(messages,
+			Message{Role: "assistant", Content: reply},
+			Message{Role: "system", Content: repairPrompt(parseErr)},
+		)
+		if reply, model, err = a.ask(ctx, userInput, messages); err != nil {
+			return nil, "", err
+		}
+		debugLog.Printf("DeepSeek response: %q", reply)
+		intent, parseErr = parseIntent(reply)
+	}
+	return intent, model, nil
+}
+
+// ask sends messages to the chain of models, or takes the offline reply
+func (a *BookingAgent) ask(ctx context.Context, userInput string, messages []Message) (reply, model string, err error) {
+	if a.offline != nil {
+		logger.InfoContext(ctx, "llm call", "model", "offline")
+		return a.offline.reply(userInput), "offline", nil
+	}
+	return a.completeChain(ctx, messages)
+}
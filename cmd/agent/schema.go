@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Schema validation of DeepSeek's replies: the intent must name a tool, its
+// parameters must be ones the tool declares, and values of the formats below
+// must match them. A reply that breaks the schema is not acted on; the user is
+// asked to say again what did not read right.
+
+// paramFormat is the form of a parameter whatever tool takes it; pattern and
+// format are shown to DeepSeek in the tool's schema, hint to the user
+type paramFormat struct {
+	pattern string // JSON Schema pattern, matched ignoring case
+	format  string // JSON Schema format; only "date" is checked
+	hint    string
+}
+
+var paramFormats = map[string]paramFormat{
+	"train_id":    {pattern: `^[A-Z][0-9]+(\s*,\s*[A-Z][0-9]+)*$`, hint: "a train ID such as G100"},
+	"booking_ref": {pattern: `^BK[0-9A-F]{10}$`, hint: "a booking reference such as BK1A2B3C4D5E"},
+	"user_id":     {pattern: userIDPattern.String(), hint: "a user ID"},
+	"date":        {format: "date", hint: "a date such as 2026-05-01"},
+	"rating":      {pattern: `^[1-5]$`, hint: "a rating of 1 to 5 stars"},
+}
+
+var paramPatterns = func() map[string]*regexp.Regexp {
+	compiled := map[string]*regexp.Regexp{}
+	for name, f := range paramFormats {
+		if f.pattern != "" {
+			compiled[name] = regexp.MustCompile(`(?i)` + f.pattern)
+		}
+	}
+	return compiled
+}()
+
+// withFormat adds the pattern and format of a parameter named name to p
+func withFormat(name string, p ToolProperty) ToolProperty {
+	f := paramFormats[name]
+	p.Pattern, p.Format = f.pattern, f.format
+	return p
+}
+
+// schemaViolation is the first way intent breaks the schema, with the
+// question to ask the user instead
+type schemaViolation struct {
+	problem  string
+	question string
+}
+
+// checkIntent validates intent against the registered tools, dropping
+// parameters its tool does not take. It returns nil when intent may be acted on.
+func (a *BookingAgent) checkIntent(intent *IntentResponse) *schemaViolation {
+	if intent.Parameters == nil {
+		intent.Parameters = map[string]string{}
+	}
+	if intent.Confidence != nil && (*intent.Confidence < 0 || *intent.Confidence > 1) {
+		return &schemaViolation{
+			problem:  fmt.Sprintf("confidence %g is not between 0 and 1", *intent.Confidence),
+			question: "I'm not sure I understood. Could you say that again?",
+		}
+	}
+	if intent.Intent == "unknown" {
+		return nil
+	}
+	tool, ok := a.tools.lookup(intent.Intent)
+	if !ok {
+		return &schemaViolation{
+			problem:  fmt.Sprintf("intent %q is not a tool", intent.Intent),
+			question: "I'm not sure what you'd like to do. I can query, book or cancel tickets, search for trains or list them all; which is it?",
+		}
+	}
+
+	properties := tool.Parameters().Properties
+	for name, value := range intent.Parameters {
+		if _, ok := properties[name]; !ok {
+			debugLog.Printf("Dropping parameter %s=%q, which %s does not take", name, value, intent.Intent)
+			delete(intent.Parameters, name)
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		f, ok := paramFormats[name]
+		if !ok || validFormat(name, value) {
+			continue
+		}
+		return &schemaViolation{
+			problem:  fmt.Sprintf("%s %q is not %s", name, value, f.hint),
+			question: fmt.Sprintf("I couldn't read %q as %s. Could you give it again?", value, f.hint),
+		}
+	}
+	return nil
+}
+
+func validFormat(name, value string) bool {
+	if p, ok := paramPatterns[name]; ok && !p.MatchString(value) {
+		return false
+	}
+	if paramFormats[name].format == "date" {
+		if _, err := time.Parse(time.DateOnly, value); err != nil {
+			return false
+		}
+	}
+	return true
+}
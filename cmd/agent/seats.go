@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Seats: "show me the seats on G100" draws the train's standard class seat
+// map, coach by coach, marking the seats free, taken and the user's own.
+// "give me a window seat near the front" picks the first free window seat
+// from the front of the train and chooses it for the user's booking;
+// "seat 12F" or "1-12F" (coach 1, row 12, seat F) chooses that seat.
+
+// SeatMap is a train's standard class seats and those already chosen
+type SeatMap struct {
+	TrainID string      `json:"train_id"`
+	Letters []string    `json:"letters"` // the seats of a row, the aisle after C
+	Windows []string    `json:"windows"`
+	Coaches []SeatCoach `json:"coaches"`
+}
+
+// SeatCoach is one coach of a seat map
+type SeatCoach struct {
+	Number int      `json:"number"`
+	Rows   int      `json:"rows"`
+	Taken  []string `json:"taken"` // row and letter, e.g. 12F
+}
+
+// SeatChoice is the seat asked for on TrainID with booking BookingRef, e.g.
+// 1-12F; an empty seat gives the chosen one up. TrainID may be empty for a
+// booking of one train.
+type SeatChoice struct {
+	UserID     string
+	BookingRef string
+	TrainID    string
+	Seat       string
+}
+
+var (
+	seatWindow = regexp.MustCompile(`(?i)\bwindow\b`)
+	seatAisle  = regexp.MustCompile(`(?i)\baisle\b`)
+	seatMiddle = regexp.MustCompile(`(?i)\bmiddle\s+seat|\bmiddle\b.*\bseat`)
+	seatBack   = regexp.MustCompile(`(?i)\b(back|rear|end|last)\b`)
+)
+
+func (a *BookingAgent) registerSeatTool() {
+	err := a.tools.register(&funcTool{
+		name:        "choose_seat",
+		description: "User asks to see a train's seat map, or to choose a seat on a booked train, such as a window seat near the front or seat 12F",
+		parameters: objectSchema(map[string]string{
+			"train_id":    "Train, such as G100",
+			"seat":        "Seat asked for, such as 12F or 1-12F (coach 1, row 12, seat F)",
+			"preference":  "Kind of seat wanted, such as \"window near the front\", \"aisle\" or \"middle at the back\"; empty with no seat to see the map",
+			"booking_ref": "Booking reference, such as BK1A2B3C4D5E",
+			"user_id":     userIDParam,
+		}),
+		run: func(ctx context.Context, p map[string]string) string {
+			return a.chooseSeat(ctx, p)
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// chooseSeat shows the seat map, or chooses the seat asked for on a booking
+func (a *BookingAgent) chooseSeat(ctx context.Context, p map[string]string) string {
+	req := SeatChoice{
+		UserID:     p["user_id"],
+		BookingRef: strings.ToUpper(strings.TrimSpace(p["booking_ref"])),
+		TrainID:    strings.ToUpper(strings.TrimSpace(p["train_id"])),
+		Seat:       strings.ToUpper(strings.TrimSpace(p["seat"])),
+	}
+	if req.UserID == "" {
+		req.UserID = a.userID
+	}
+	preference := strings.TrimSpace(p["preference"])
+	if req.BookingRef == "" {
+		req.BookingRef = a.bookingWith(ctx, req.TrainID, req.UserID)
+	}
+	var receipt *Receipt
+	if req.BookingRef != "" {
+		receipt, _ = a.provider.Receipt(ctx, req.BookingRef, req.UserID)
+	}
+	if req.TrainID == "" {
+		if receipt == nil || len(receipt.Items) != 1 {
+			return "🤔 Which train? Please give the train ID, such as G100."
+		}
+		req.TrainID = receipt.Items[0].TrainID
+	}
+
+	seats, err := a.provider.SeatMap(ctx, req.TrainID)
+	switch {
+	case errors.Is(err, ErrTrainNotFound):
+		return fmt.Sprintf("❌ Train %s not found", req.TrainID)
+	case err != nil:
+		return a.providerError(ctx, "fetching the seat map", err)
+	}
+	yours := bookedSeat(receipt, req.TrainID)
+	if req.Seat == "" && preference == "" {
+		return a.formatSeatMap(seats, yours) + "\n" + a.seatHint(yours)
+	}
+
+	if req.Seat == "" {
+		seat, ok := pickSeat(seats, preference)
+		if !ok {
+			return fmt.Sprintf("❌ No %s seat is free on %s.\n%s", preference, req.TrainID, a.formatSeatMap(seats, yours))
+		}
+		req.Seat = seat
+	}
+	if req.BookingRef == "" {
+		return fmt.Sprintf("🤔 Which booking? Please give the booking reference of your %s ticket, such as BK1A2B3C4D5E.", req.TrainID)
+	}
+	receipt, err = a.provider.ChooseSeat(ctx, req)
+	switch {
+	case errors.Is(err, ErrNoReceipt):
+		return fmt.Sprintf("❌ No booking %s found for user %s", req.BookingRef, req.UserID)
+	case errors.Is(err, ErrSoldOut):
+		return fmt.Sprintf("❌ Seat %s on %s is already taken. Please choose another.\n%s", req.Seat, req.TrainID, a.formatSeatMap(seats, yours))
+	case errors.Is(err, ErrDeparted), errors.Is(err, ErrInvalid), errors.Is(err, ErrTrainNotFound):
+		return fmt.Sprintf("❌ %v", err)
+	case err != nil:
+		return a.providerError(ctx, "choosing a seat", err)
+	}
+
+	yours = bookedSeat(receipt, req.TrainID)
+	if refreshed, err := a.provider.SeatMap(ctx, req.TrainID); err == nil {
+		seats = refreshed
+	}
+	result := fmt.Sprintf("Seat %s on %s is yours, on booking %s.", describeSeat(seats, yours), req.TrainID, req.BookingRef)
+	if a.accessible {
+		return result
+	}
+	return "💺 " + result + "\n" + a.formatSeatMap(seats, yours)
+}
+
+// bookedSeat returns the seat chosen on trainID's ticket of receipt, if any
+func bookedSeat(receipt *Receipt, trainID string) string {
+	if receipt == nil {
+		return ""
+	}
+	for _, item := range receipt.Items {
+		if strings.EqualFold(item.TrainID, trainID) {
+			return item.Seat
+		}
+	}
+	return ""
+}
+
+// seatName writes the seat in a coach's row as the provider does, e.g. 1-12F
+func seatName(coach, row int, letter string) string {
+	return fmt.Sprintf("%d-%d%s", coach, row, letter)
+}
+
+// pickSeat picks the first free seat of the kind preferred: window, aisle or
+// middle, counted from the front of the train or, when asked, the back
+func pickSeat(seats *SeatMap, preference string) (string, bool) {
+	letters := seats.Letters
+	switch {
+	case seatWindow.MatchString(preference):
+		letters = seats.Windows
+	case seatAisle.MatchString(preference):
+		if i := slices.Index(seats.Letters, "C"); i >= 0 && i+1 < len(seats.Letters) {
+			letters = seats.Letters[i : i+2]
+		}
+	case seatMiddle.MatchString(preference):
+		letters = []string{"B"}
+	}
+
+	var free []string
+	for _, coach := range seats.Coaches {
+		for row := 1; row <= coach.Rows; row++ {
+			for _, letter := range letters {
+				if !slices.Contains(coach.Taken, fmt.Sprintf("%d%s", row, letter)) {
+					free = append(free, seatName(coach.Number, row, letter))
+				}
+			}
+		}
+	}
+	if len(free) == 0 {
+		return "", false
+	}
+	if seatBack.MatchString(preference) {
+		return free[len(free)-1], true
+	}
+	return free[0], true
+}
+
+// describeSeat names a seat and where it is, e.g. "1-3F (coach 1, row 3,
+// window)"
+func describeSeat(seats *SeatMap, seat string) string {
+	var coach, row int
+	var letter string
+	if _, err := fmt.Sscanf(seat, "%d-%d%s", &coach, &row, &letter); err != nil {
+		return seat
+	}
+	place := "aisle"
+	switch {
+	case slices.Contains(seats.Windows, letter):
+		place = "window"
+	case letter == "B":
+		place = "middle"
+	}
+	return fmt.Sprintf("%s (coach %d, row %d, %s)", seat, coach, row, place)
+}
+
+// seatHint tells how to choose a seat from the map
+func (a *BookingAgent) seatHint(yours string) string {
+	hint := "Say \"window seat near the front\" or a seat such as 1-12F to choose one."
+	if yours != "" {
+		hint = fmt.Sprintf("Your seat is %s. ", yours) + hint
+	}
+	if a.accessible {
+		return hint
+	}
+	return "💡 " + hint
+}
+
+// formatSeatMap draws the seats coach by coach, a row per line with the
+// aisle between C and D: o is free, x taken and * the user's seat. Screen
+// readers get a count of free seats per coach instead.
+func (a *BookingAgent) formatSeatMap(seats *SeatMap, yours string) string {
+	if a.accessible {
+		lines := []string{fmt.Sprintf("Standard class seats on train %s, seats %s by the window:", seats.TrainID, strings.Join(seats.Windows, " and "))}
+		for _, coach := range seats.Coaches {
+			total := coach.Rows * len(seats.Letters)
+			lines = append(lines, fmt.Sprintf("Coach %d has %d of %d seats free.", coach.Number, total-len(coach.Taken), total))
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	header := ""
+	for _, letter := range seats.Letters {
+		header += letter + " "
+		if letter == "C" {
+			header += "  "
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "💺 Seats on %s (o free, x taken, * yours; %s by the window):", seats.TrainID, strings.Join(seats.Windows, " and "))
+	for _, coach := range seats.Coaches {
+		fmt.Fprintf(&b, "\nCoach %d\n      %s", coach.Number, strings.TrimSpace(header))
+		for row := 1; row <= coach.Rows; row++ {
+			fmt.Fprintf(&b, "\n  %2d  ", row)
+			for _, letter := range seats.Letters {
+				mark := "o"
+				switch {
+				case seatName(coach.Number, row, letter) == yours:
+					mark = "*"
+				case slices.Contains(coach.Taken, fmt.Sprintf("%d%s", row, letter)):
+					mark = "x"
+				}
+				b.WriteString(mark + " ")
+				if letter == "C" {
+					b.WriteString("  ")
+				}
+			}
+		}
+	}
+	rows := strings.Split(b.String(), "\n")
+	for i := range rows {
+		rows[i] = strings.TrimRight(rows[i], " ")
+	}
+	return strings.Join(rows, "\n")
+}
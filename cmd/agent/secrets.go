@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Keychain entry looked up when no other key source is configured
+const (
+	keychainService = "train-booking-agent"
+	keychainAccount = "deepseek"
+)
+
+// loadAPIKey resolves the DeepSeek API key. The first configured source wins:
+//
+//  1. keyFile (--api-key-file) or DEEPSEEK_API_KEY_FILE
+//  2. DEEPSEEK_API_KEY_COMMAND, a secrets provider command whose stdout is the key
+//  3. DEEPSEEK_API_KEY
+//  4. the OS keychain (macOS Keychain or the Secret Service on Linux)
+//
+// A configured source that fails is an error rather than a reason to fall through,
+// so a typo never silently picks up a different key.
+func loadAPIKey(keyFile string) (key, source string, err error) {
+	if keyFile == "" {
+		keyFile = os.Getenv("DEEPSEEK_API_KEY_FILE")
+	}
+	if keyFile != "" {
+		key, err := readKeyFile(keyFile)
+		return key, "file " + keyFile, err
+	}
+
+	if command := os.Getenv("DEEPSEEK_API_KEY_COMMAND"); command != "" {
+		key, err := runSecretCommand(command)
+		return key, "secrets command", err
+	}
+
+	if key := strings.TrimSpace(os.Getenv("DEEPSEEK_API_KEY")); key != "" {
+		return key, "DEEPSEEK_API_KEY", nil
+	}
+
+	if key, err := readKeychain(); err == nil {
+		return key, "OS keychain", nil
+	}
+	return "", "", errors.New("no DeepSeek API key configured")
+}
+
+// readKeyFile reads a key file that only its owner may read
+func readKeyFile(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0o077 != 0 {
+		return "", fmt.Errorf("%s is accessible by other users (mode %v); run: chmod 600 %s", path, info.Mode().Perm(), path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	key := strings.TrimSpace(string(data))
+	if key == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return key, nil
+}
+
+// runSecretCommand runs a provider such as "pass show deepseek" or
+// "op read op://vault/deepseek/credential" and returns its trimmed output
+func runSecretCommand(command string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Stderr = os.Stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("secrets command failed: %w", err)
+	}
+	key := strings.TrimSpace(string(out))
+	if key == "" {
+		return "", errors.New("secrets command printed no key")
+	}
+	return key, nil
+}
+
+// readKeychain looks up the key with the platform's keychain tool
+func readKeychain() (string, error) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", keychainService, "-a", keychainAccount, "-w")
+	case "linux", "freebsd", "openbsd":
+		cmd = exec.Command("secret-tool", "lookup", "service", keychainService, "account", keychainAccount)
+	default:
+		return "", fmt.Errorf("no keychain support on %s", runtime.GOOS)
+	}
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	key := strings.TrimSpace(string(out))
+	if key == "" {
+		return "", errors.New("keychain entry is empty")
+	}
+	return key, nil
+}
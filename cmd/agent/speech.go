@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/zhangbiao2009/train-booking/internal/plain"
+)
+
+// Spoken replies: with --tts the agent reads each reply aloud after printing
+// it, and waits until it has finished, so --voice does not hear itself.
+// Replies are phrased as in --accessible, in sentences without tables or
+// symbols, which is what a speech engine reads well; --tts-ssml wraps them
+// in SSML with a pause between lines, for engines that take it.
+
+// Plays an audio file
+const defaultPlayCommand = "play -q {file}"
+
+// TextToSpeech reads text aloud; --tts picks the implementation
+type TextToSpeech interface {
+	Speak(ctx context.Context, text string) error
+}
+
+// newTextToSpeech returns the provider called name, or nil for "off":
+// "openai" for the OpenAI speech API, its audio played by play, or
+// "command" for a local engine such as espeak-ng, given the text on stdin
+func newTextToSpeech(name, command, play string) (TextToSpeech, error) {
+	switch name {
+	case "off", "":
+		return nil, nil
+	case "openai":
+		return &openAISpeech{
+			client: &http.Client{Timeout: 60 * time.Second},
+			url:    envOr("TRAIN_AGENT_TTS_URL", "https://api.openai.com/v1/audio/speech"),
+			model:  envOr("TRAIN_AGENT_TTS_MODEL", "tts-1"),
+			voice:  envOr("TRAIN_AGENT_TTS_VOICE", "alloy"),
+			key:    envOr("TRAIN_AGENT_TTS_API_KEY", os.Getenv("OPENAI_API_KEY")),
+			play:   play,
+		}, nil
+	case "command":
+		if command == "" {
+			return nil, fmt.Errorf("--tts=command needs --tts-command, e.g. \"espeak-ng\"")
+		}
+		return commandTTS{command: command}, nil
+	}
+	return nil, fmt.Errorf("unknown text-to-speech provider %q: want off, openai or command", name)
+}
+
+// openAISpeech fetches speech from an OpenAI-compatible endpoint as WAV and
+// plays it
+type openAISpeech struct {
+	client *http.Client
+	url    string
+	model  string
+	voice  string
+	key    string
+	play   string // plays {file}
+}
+
+func (o *openAISpeech) Speak(ctx context.Context, text string) error {
+	body, _ := json.Marshal(map[string]string{"model": o.model, "voice": o.voice, "input": text, "response_format": "wav"})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if o.key != "" {
+		req.Header.Set("Authorization", "Bearer "+o.key)
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("text-to-speech service: %s %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+
+	dir, err := os.MkdirTemp("", "train-agent-speech")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "reply.wav")
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, resp.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := runWithFile(ctx, o.play, file); err != nil {
+		return fmt.Errorf("playing speech: %v", err)
+	}
+	return nil
+}
+
+// commandTTS runs a local speech engine with the text on its stdin
+type commandTTS struct {
+	command string
+}
+
+func (c commandTTS) Speak(ctx context.Context, text string) error {
+	cmd := exec.CommandContext(ctx, "sh", "-c", c.command)
+	cmd.Stdin = strings.NewReader(text)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("text-to-speech command: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// Remains of the display that are noise when read aloud
+var (
+	unspokenURL     = regexp.MustCompile(`https?://\S+`)
+	unspokenSymbols = regexp.MustCompile(`[*_#|>` + "`" + `]+|\[[A-Za-z]+\]`)
+)
+
+// speechText turns a reply into what is read aloud: plain words, one
+// sentence per line, or with ssml an SSML document pausing between lines
+func speechText(reply string, ssml bool) string {
+	var lines []string
+	for _, line := range strings.Split(plain.String(reply), "\n") {
+		line = unspokenURL.ReplaceAllString(line, "the link shown")
+		line = strings.Join(strings.Fields(unspokenSymbols.ReplaceAllString(line, " ")), " ")
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if !ssml {
+		return strings.Join(lines, "\n")
+	}
+	var b strings.Builder
+	b.WriteString("<speak>")
+	for i, line := range lines {
+		if i > 0 {
+			b.WriteString(`<break time="400ms"/>`)
+		}
+		b.WriteString("<s>")
+		xmlEscaper.WriteString(&b, line)
+		b.WriteString("</s>")
+	}
+	b.WriteString("</speak>")
+	return b.String()
+}
+
+var xmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;", "'", "&apos;")
+
+// speak reads reply aloud when --tts is on; a failure is logged, the reply
+// having been printed already
+func (a *BookingAgent) speak(reply string) {
+	if a.tts == nil {
+		return
+	}
+	text := speechText(reply, a.ssml)
+	if text == "" {
+		return
+	}
+	if err := a.tts.Speak(context.Background(), text); err != nil {
+		logger.Warn("speaking reply failed", "err", err)
+		debugLog.Printf("Cannot speak reply: %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// City spell correction: when a search finds nothing and a city it names is
+// not in the station registry, the closest registry city is offered instead,
+// "Shangai" as Shanghai, and a yes runs the corrected search.
+
+// suggestCity returns the registry city name most likely meant, when name is
+// not a city already. A station name gives its city.
+func suggestCity(name string) (string, bool) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", false
+	}
+	if _, ok := cityStation(name); ok {
+		return "", false
+	}
+	if s, ok := findStation(name); ok {
+		return s.City, true
+	}
+
+	// Longer names may be further off; two cities as close as the best
+	// leave it unclear which was meant
+	limit := 1
+	if utf8.RuneCountInString(name) > 5 {
+		limit = 2
+	}
+	best, bestDistance, tied := "", limit+1, false
+	seen := map[string]bool{}
+	for _, s := range stations {
+		if seen[s.City] {
+			continue
+		}
+		seen[s.City] = true
+		d := editDistance(strings.ToLower(name), strings.ToLower(s.City))
+		switch {
+		case d < bestDistance:
+			best, bestDistance, tied = s.City, d, false
+		case d == bestDistance:
+			tied = true
+		}
+	}
+	if best == "" || tied {
+		return "", false
+	}
+	return best, true
+}
+
+// editDistance counts the insertions, deletions, substitutions and swaps of
+// neighbouring runes turning a into b
+func editDistance(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	d := make([][]int, len(s)+1)
+	for i := range d {
+		d[i] = make([]int, len(t)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(s)][len(t)]
+}
+
+// suggestSearch offers q with its misspelled cities corrected, held for the
+// user's yes; "" when no city needs correcting
+func (a *BookingAgent) suggestSearch(ctx context.Context, q SearchQuery) string {
+	corrected := q
+	var fixes []string
+	for _, city := range []*string{&corrected.From, &corrected.To} {
+		if suggestion, ok := suggestCity(*city); ok {
+			fixes = append(fixes, suggestion)
+			*city = suggestion
+		}
+	}
+	if len(fixes) == 0 {
+		return ""
+	}
+	logger.InfoContext(ctx, "city corrected", "from", q.From, "to", q.To, "suggested_from", corrected.From, "suggested_to", corrected.To)
+
+	params := map[string]string{"from": corrected.From, "to": corrected.To, "date": corrected.Date}
+	if corrected.Pets {
+		params["pets"] = "yes"
+	}
+	a.suggestedSearch = &IntentResponse{Intent: "search_trains", Parameters: params}
+
+	var route []string
+	if corrected.From != "" {
+		route = append(route, "from "+corrected.From)
+	}
+	if corrected.To != "" {
+		route = append(route, "to "+corrected.To)
+	}
+	if corrected.Date != "" {
+		route = append(route, "on "+corrected.Date)
+	}
+	return fmt.Sprintf("\n💡 Did you mean %s? Say yes to search for trains %s.", strings.Join(fixes, " and "), strings.Join(route, " "))
+}
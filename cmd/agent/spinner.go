@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/chzyer/readline"
+)
+
+// Spinner frames; setupOutput switches to ASCII in plain mode
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// startSpinner animates label on w until the returned stop function is called.
+// When stdout is not a terminal the label is printed once instead.
+func startSpinner(w io.Writer, label string) (stop func()) {
+	if !readline.IsTerminal(int(os.Stdout.Fd())) {
+		fmt.Fprint(w, label+"...")
+		return func() { fmt.Fprint(w, "\r") }
+	}
+
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for i := 0; ; i++ {
+			fmt.Fprintf(w, "\r%s %s (Ctrl-C to cancel)", label, spinnerFrames[i%len(spinnerFrames)])
+			select {
+			case <-done:
+				fmt.Fprint(w, "\r\033[K")
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-finished
+	}
+}
+
+// respondInterruptible runs one turn that Ctrl-C cancels without ending the program
+func (a *BookingAgent) respondInterruptible(userInput string) (string, error) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	return a.respond(ctx, userInput)
+}
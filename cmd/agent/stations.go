@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Station metadata: how long changing trains takes and how to get around, so
+// the agent can warn about tight connections in multi-leg journeys. Trains
+// only name cities, so a connection is assumed to be at the city's first
+// station listed in data/stations.json.
+
+//go:embed data/stations.json
+var stationsJSON []byte
+
+// Station is one station's transfer information
+type Station struct {
+	Name                string              `json:"name"`
+	City                string              `json:"city"`
+	MinTransferMinutes  int                 `json:"min_transfer_minutes"`  // shortest realistic change between trains
+	PlatformWalkMinutes int                 `json:"platform_walk_minutes"` // typical walk from one platform to another
+	Metro               []string            `json:"metro"`
+	Connections         []StationConnection `json:"connections"` // to other stations in the same city
+}
+
+type StationConnection struct {
+	Station string `json:"station"`
+	Minutes int    `json:"minutes"`
+	Via     string `json:"via"`
+}
+
+var stations = mustLoadStations()
+
+func mustLoadStations() []Station {
+	var list []Station
+	if err := json.Unmarshal(stationsJSON, &list); err != nil {
+		panic(fmt.Sprintf("data/stations.json: %v", err))
+	}
+	return list
+}
+
+// findStation matches a station name, or a city for its main station
+func findStation(name string) (Station, bool) {
+	for _, s := range stations {
+		if strings.EqualFold(s.Name, name) {
+			return s, true
+		}
+	}
+	return cityStation(name)
+}
+
+// cityStation is the station trains to and from city are assumed to use
+func cityStation(city string) (Station, bool) {
+	for _, s := range stations {
+		if strings.EqualFold(s.City, city) {
+			return s, true
+		}
+	}
+	return Station{}, false
+}
+
+// trainTimes returns when a train leaves and arrives; an arrival earlier in
+// the day than the departure is on the next day
+func trainTimes(t Train) (depart, arrive time.Time, err error) {
+	depart, err = time.Parse("2006-01-02 15:04", t.Date+" "+t.DepartureTime)
+	if err != nil {
+		return
+	}
+	arrive, err = time.Parse("2006-01-02 15:04", t.Date+" "+t.ArrivalTime)
+	if err == nil && arrive.Before(depart) {
+		arrive = arrive.Add(24 * time.Hour)
+	}
+	return
+}
+
+// connectionWarning describes a problem changing from one leg to the next;
+// it is empty when the change is comfortable
+func connectionWarning(from, to Train) string {
+	if !strings.EqualFold(from.To, to.From) {
+		return fmt.Sprintf("⚠️ %s arrives in %s but %s leaves from %s.", from.ID, from.To, to.ID, to.From)
+	}
+	_, arrive, err1 := trainTimes(from)
+	depart, _, err2 := trainTimes(to)
+	if err1 != nil || err2 != nil {
+		return ""
+	}
+
+	gap := int(depart.Sub(arrive).Minutes())
+	station, ok := cityStation(from.To)
+	where := from.To
+	minimum := 15
+	if ok {
+		where, minimum = station.Name, station.MinTransferMinutes
+	}
+	switch {
+	case gap < 0:
+		return fmt.Sprintf("⚠️ %s leaves %s before %s arrives there.", to.ID, where, from.ID)
+	case gap < minimum:
+		return fmt.Sprintf("⚠️ You'll have %d minutes to change trains at %s; at least %d are needed, so you are likely to miss %s.", gap, where, minimum, to.ID)
+	case gap < 2*minimum:
+		return fmt.Sprintf("⚠️ You'll have %d minutes to change trains at %s, which is tight.", gap, where)
+	}
+	return ""
+}
+
+// connectionWarnings checks every change in an itinerary, legs in travel order
+func (a *BookingAgent) connectionWarnings(ctx context.Context, trainIDs []string) []string {
+	if len(trainIDs) < 2 {
+		return nil
+	}
+	trains := a.getTrainDetails(ctx, trainIDs)
+	var warnings []string
+	for i := 1; i < len(trainIDs); i++ {
+		from, ok1 := trains[strings.TrimSpace(trainIDs[i-1])]
+		to, ok2 := trains[strings.TrimSpace(trainIDs[i])]
+		if !ok1 || !ok2 {
+			continue
+		}
+		// A return trip days later is not a connection
+		_, arrive, _ := trainTimes(from)
+		depart, _, _ := trainTimes(to)
+		if depart.Sub(arrive) > 12*time.Hour {
+			continue
+		}
+		if w := connectionWarning(from, to); w != "" {
+			warnings = append(warnings, w)
+		}
+	}
+	return warnings
+}
+
+// registerStationTools adds the station information and connection check tools
+func (a *BookingAgent) registerStationTools() {
+	tools := []*funcTool{
+		{
+			name:        "station_info",
+			description: "User asks about a station or a city's main station: time needed to change trains, walking times, metro lines, getting to other stations",
+			parameters:  objectSchema(map[string]string{"station": "Station name such as Nanjing South, or a city"}, "station"),
+			run: func(ctx context.Context, p map[string]string) string {
+				return describeStation(p["station"])
+			},
+		},
+		{
+			name:        "check_connection",
+			description: "User asks whether there is enough time to change between trains of a multi-leg journey",
+			parameters:  objectSchema(map[string]string{"train_id": "Train IDs in travel order, separated by commas"}, "train_id"),
+			run: func(ctx context.Context, p map[string]string) string {
+				ids := strings.Split(p["train_id"], ",")
+				if len(ids) < 2 {
+					return "🤔 Which trains would you change between? Please give at least two train IDs in travel order."
+				}
+				if warnings := a.connectionWarnings(ctx, ids); len(warnings) > 0 {
+					return strings.Join(warnings, "\n") + a.journeyDiagram(ctx, ids)
+				}
+				return "✅ Every change in this journey leaves enough time." + a.journeyDiagram(ctx, ids)
+			},
+		},
+	}
+	for _, t := range tools {
+		if err := a.tools.register(t); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func describeStation(name string) string {
+	s, ok := findStation(name)
+	if !ok {
+		return fmt.Sprintf("❌ I have no information about %s station", name)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "🚉 %s (%s)\n", s.Name, s.City)
+	fmt.Fprintf(&b, "• Allow at least %d minutes to change trains; walking between platforms takes about %d\n", s.MinTransferMinutes, s.PlatformWalkMinutes)
+	if len(s.Metro) > 0 {
+		fmt.Fprintf(&b, "• Metro: %s\n", strings.Join(s.Metro, ", "))
+	}
+	for _, c := range s.Connections {
+		fmt.Fprintf(&b, "• To %s station: about %d minutes by %s\n", c.Station, c.Minutes, c.Via)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
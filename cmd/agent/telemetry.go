@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/zhangbiao2009/train-booking/internal/otlp"
+)
+
+// OpenTelemetry export: with an OTLP endpoint in the standard OTEL_*
+// variables, each turn is traced with a span per DeepSeek call and per
+// booking server call, and the server calls carry a traceparent so the
+// server's spans join the turn's trace. Turns and DeepSeek latency are
+// exported as metrics, and structured log records as log records.
+
+var telemetry *otlp.Exporter
+
+// Metrics; nil when export is off
+var (
+	turnCounter *otlp.Counter
+	llmDuration *otlp.Histogram
+)
+
+func setupTelemetry() error {
+	exporter, err := otlp.FromEnv("train-booking-agent")
+	if err != nil || exporter == nil {
+		return err
+	}
+	telemetry = exporter
+	turnCounter = telemetry.Counter("train_booking.agent.turns", "{turn}", "Conversation turns, by intent")
+	llmDuration = telemetry.Histogram("gen_ai.client.operation.duration", "s", "Duration of DeepSeek calls",
+		[]float64{0.1, 0.25, 0.5, 1, 2, 4, 8, 16, 32, 64})
+	logger = slog.New(telemetryHandler{Handler: logger.Handler()})
+	debugLog.Printf("Exporting telemetry over OTLP")
+	return nil
+}
+
+// flushTelemetry exports what is left before the agent exits
+func flushTelemetry() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	telemetry.Shutdown(ctx)
+}
+
+// telemetryHandler exports structured log records at info level and above,
+// and any lower ones the log file keeps, tied to the turn's span; the log
+// file need not be on
+type telemetryHandler struct {
+	slog.Handler
+	attrs []otlp.Attr
+	group string
+}
+
+func (h telemetryHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= slog.LevelInfo || h.Handler.Enabled(ctx, level)
+}
+
+func (h telemetryHandler) Handle(ctx context.Context, r slog.Record) error {
+	severity := otlp.SeverityInfo
+	switch {
+	case r.Level >= slog.LevelError:
+		severity = otlp.SeverityError
+	case r.Level >= slog.LevelWarn:
+		severity = otlp.SeverityWarn
+	case r.Level < slog.LevelInfo:
+		severity = otlp.SeverityDebug
+	}
+	session := conversationFrom(ctx)
+	if session == "" {
+		session = processSession
+	}
+	attrs := append([]otlp.Attr{otlp.String("session.id", session)}, h.attrs...)
+	if id := requestIDFrom(ctx); id != "" {
+		attrs = append(attrs, otlp.String("request.id", id))
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, otlp.String(h.group+a.Key, fmt.Sprint(a.Value.Resolve().Any())))
+		return true
+	})
+	telemetry.Log(ctx, r.Time, severity, r.Message, attrs...)
+
+	if !h.Handler.Enabled(ctx, r.Level) {
+		return nil
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h telemetryHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	own := append([]otlp.Attr(nil), h.attrs...)
+	for _, a := range attrs {
+		own = append(own, otlp.String(h.group+a.Key, fmt.Sprint(a.Value.Resolve().Any())))
+	}
+	return telemetryHandler{Handler: h.Handler.WithAttrs(attrs), attrs: own, group: h.group}
+}
+
+func (h telemetryHandler) WithGroup(name string) slog.Handler {
+	return telemetryHandler{Handler: h.Handler.WithGroup(name), attrs: h.attrs, group: h.group + name + "."}
+}
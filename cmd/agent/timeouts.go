@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// llmTimeoutError reports that a model did not answer within --llm-timeout
+type llmTimeoutError struct {
+	limit    time.Duration
+	provider string // as shown to users, e.g. DeepSeek
+}
+
+func (e *llmTimeoutError) Error() string {
+	return fmt.Sprintf("%s did not answer within %s", e.provider, e.limit)
+}
+
+func (e *llmTimeoutError) Unwrap() error { return context.DeadlineExceeded }
+
+// turnError is the message shown when a turn produced no reply
+func (a *BookingAgent) turnError(err error) string {
+	var llmTimeout *llmTimeoutError
+	switch {
+	case errors.As(err, &llmTimeout):
+		return fmt.Sprintf("⚠️ %v. The service may be busy; try again, or raise --llm-timeout", llmTimeout)
+	case errors.Is(err, context.DeadlineExceeded):
+		return fmt.Sprintf("⚠️ No reply within %s, so the request was abandoned. Try again, or raise --turn-timeout", a.turnTimeout)
+	case errors.Is(err, context.Canceled):
+		return "⚠️ Cancelled"
+	}
+	return fmt.Sprintf("❌ Error reading your message: %v", err)
+}
+
+// serverError describes a failed booking server call made while doing action,
+// e.g. "booking ticket"
+func (a *BookingAgent) serverError(ctx context.Context, action string, err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return fmt.Sprintf("❌ Error %s: the message took longer than %s and was abandoned; check your tickets before retrying", action, a.turnTimeout)
+	case ctx.Err() != nil:
+		return fmt.Sprintf("❌ Error %s: cancelled before the booking server answered; check your tickets before retrying", action)
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return fmt.Sprintf("❌ Error %s: the booking server did not answer within %s. It may be overloaded; try again, or raise --server-timeout", action, a.client.Timeout)
+	}
+	return fmt.Sprintf("❌ Error %s: %v", action, err)
+}
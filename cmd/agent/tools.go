@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Tool is one capability the agent offers. Every registered tool is listed in
+// the system prompt with its parameter schema, and DeepSeek picks one by
+// naming it as the intent.
+type Tool interface {
+	Name() string
+	Description() string
+	Parameters() ToolParameters
+	Execute(ctx context.Context, params map[string]string) string
+}
+
+// ToolParameters is the JSON schema of a tool's parameters. Values are always
+// strings, as in IntentResponse.Parameters.
+type ToolParameters struct {
+	Type       string                  `json:"type"`
+	Properties map[string]ToolProperty `json:"properties"`
+	Required   []string                `json:"required,omitempty"`
+}
+
+type ToolProperty struct {
+	Type        string `json:"type"`
+	Description string `json:"description,omitempty"`
+	Pattern     string `json:"pattern,omitempty"`
+	Format      string `json:"format,omitempty"`
+}
+
+// objectSchema builds the schema of a tool with string parameters, those
+// with a known form constrained to it
+func objectSchema(properties map[string]string, required ...string) ToolParameters {
+	schema := ToolParameters{Type: "object", Properties: map[string]ToolProperty{}, Required: required}
+	for name, description := range properties {
+		schema.Properties[name] = withFormat(name, ToolProperty{Type: "string", Description: description})
+	}
+	return schema
+}
+
+// toolRegistry holds the agent's tools in registration order
+type toolRegistry struct {
+	tools  []Tool
+	byName map[string]Tool
+}
+
+func newToolRegistry() *toolRegistry {
+	return &toolRegistry{byName: map[string]Tool{}}
+}
+
+// register adds t; names must be unique and "unknown" is reserved
+func (r *toolRegistry) register(t Tool) error {
+	name := t.Name()
+	if name == "" || name == "unknown" {
+		return fmt.Errorf("invalid tool name %q", name)
+	}
+	if _, ok := r.byName[name]; ok {
+		return fmt.Errorf("tool %q is already registered", name)
+	}
+	r.tools = append(r.tools, t)
+	r.byName[name] = t
+	return nil
+}
+
+func (r *toolRegistry) lookup(name string) (Tool, bool) {
+	t, ok := r.byName[name]
+	return t, ok
+}
+
+func (r *toolRegistry) names() []string {
+	names := make([]string, len(r.tools))
+	for i, t := range r.tools {
+		names[i] = t.Name()
+	}
+	return names
+}
+
+// catalog lists the tools for the system prompt, one per line with its schema
+func (r *toolRegistry) catalog() string {
+	var b strings.Builder
+	for _, t := range r.tools {
+		schema, _ := json.Marshal(t.Parameters())
+		fmt.Fprintf(&b, "- %s: %s\n  parameters: %s\n", t.Name(), t.Description(), schema)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// funcTool is a Tool backed by a function
+type funcTool struct {
+	name        string
+	description string
+	parameters  ToolParameters
+	run         func(ctx context.Context, params map[string]string) string
+}
+
+func (t *funcTool) Name() string               { return t.name }
+func (t *funcTool) Description() string        { return t.description }
+func (t *funcTool) Parameters() ToolParameters { return t.parameters }
+func (t *funcTool) Execute(ctx context.Context, params map[string]string) string {
+	return t.run(ctx, params)
+}
+
+// Parameter descriptions shared by the built-in tools
+const (
+	trainIDParam = "Train ID such as G100"
+	userIDParam  = "User identifier; the active user when empty"
+)
+
+// registerBuiltinTools adds the booking server actions
+func (a *BookingAgent) registerBuiltinTools() {
+	builtins := []*funcTool{
+		{
+			name:        "query_ticket",
+			description: "User wants information about a specific train",
+			parameters:  objectSchema(map[string]string{"train_id": trainIDParam}, "train_id"),
+			run: func(ctx context.Context, p map[string]string) string {
+				return a.queryTrain(ctx, p["train_id"])
+			},
+		},
+		{
+			name:        "book_ticket",
+			description: "User wants to book a ticket (specific train or search criteria); several comma-separated train IDs book a round trip or multi-leg journey, all or nothing",
+			parameters:  objectSchema(map[string]string{"train_id": "One train ID, or several separated by commas", "user_id": userIDParam, "insurance": insuranceParam, "pet": petParam, "wheelchair": wheelchairParam, "luggage": luggageParam, "travelers": travelersParam}, "train_id"),
+			run: func(ctx context.Context, p map[string]string) string {
+				return a.placeBooking(ctx, p["train_id"], p["user_id"], bookingExtras{insured: saidYes(p["insurance"]), pet: saidYes(p["pet"]), wheelchair: saidYes(p["wheelchair"]), luggage: p["luggage"], travelers: parseTravelers(p["travelers"])})
+			},
+		},
+		{
+			name:        "cancel_ticket",
+			description: "User wants to cancel a booked ticket, or asks for a refund",
+			parameters:  objectSchema(map[string]string{"train_id": trainIDParam, "user_id": userIDParam, "booking_ref": "Booking reference, such as BK1A2B3C4D5E"}, "train_id"),
+			run: func(ctx context.Context, p map[string]string) string {
+				return a.cancelTicket(ctx, p["train_id"], p["user_id"], strings.ToUpper(p["booking_ref"]))
+			},
+		},
+		{
+			name:        "list_trains",
+			description: "User wants to see all available trains",
+			parameters:  objectSchema(nil),
+			run: func(ctx context.Context, p map[string]string) string {
+				return a.listTrains(ctx)
+			},
+		},
+		{
+			name:        "search_trains",
+			description: "User wants to search for trains by criteria",
+			parameters: objectSchema(map[string]string{
+				"from": "Departure city",
+				"to":   "Destination city",
+				"date": "Travel date, YYYY-MM-DD",
+				"pets": petsParam,
+			}),
+			run: func(ctx context.Context, p map[string]string) string {
+				return a.searchTrains(ctx, SearchQuery{From: p["from"], To: p["to"], Date: p["date"], Pets: saidYes(p["pets"])})
+			},
+		},
+		{
+			name:        "my_tickets",
+			description: "User wants to see their booked tickets",
+			parameters:  objectSchema(map[string]string{"user_id": userIDParam}),
+			run: func(ctx context.Context, p map[string]string) string {
+				return a.getUserTickets(ctx, p["user_id"])
+			},
+		},
+	}
+	for _, t := range builtins {
+		if err := a.tools.register(t); err != nil {
+			panic(err)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// TranscriptEntry is one conversation turn with the action the agent took
+type TranscriptEntry struct {
+	Time            time.Time         `json:"time"`
+	RequestID       string            `json:"request_id,omitempty"`
+	User            string            `json:"user"`
+	Intent          string            `json:"intent,omitempty"`
+	Parameters      map[string]string `json:"parameters,omitempty"`
+	ClarifyQuestion string            `json:"clarify_question,omitempty"`
+	Calls           []ServerCall      `json:"calls,omitempty"`
+	DryRun          bool              `json:"dry_run,omitempty"`
+	Planned         []ServerCall      `json:"planned,omitempty"` // calls a dry run would have made
+	Trains          []Train           `json:"trains,omitempty"`  // trains listed by the reply
+	Reply           string            `json:"reply,omitempty"`
+	Error           string            `json:"error,omitempty"`
+}
+
+// Transcript is the saved form of a conversation
+type Transcript struct {
+	UserID    string            `json:"user_id"`
+	ServerURL string            `json:"server_url"`
+	Prompt    string            `json:"prompt,omitempty"` // prompt version and hash
+	Started   time.Time         `json:"started"`
+	Saved     time.Time         `json:"saved"`
+	Turns     []TranscriptEntry `json:"turns"`
+}
+
+func (a *BookingAgent) record(entry TranscriptEntry) {
+	entry.Time = time.Now()
+	entry.RequestID = a.requestID
+	a.transcript = append(a.transcript, entry)
+	if a.transcriptFile != "" {
+		if err := a.saveTranscript(a.transcriptFile); err != nil {
+			logger.Error("saving transcript failed", "path", a.transcriptFile, "err", err)
+		}
+	}
+}
+
+// saveTranscript writes the conversation so far as JSON when path ends in .json
+// and as Markdown otherwise
+func (a *BookingAgent) saveTranscript(path string) error {
+	t := Transcript{
+		UserID:    a.userID,
+		ServerURL: a.serverURL,
+		Prompt:    a.prompts.String(),
+		Started:   a.started,
+		Saved:     time.Now(),
+		Turns:     a.transcript,
+	}
+
+	var data []byte
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		var err error
+		if data, err = json.MarshalIndent(t, "", "  "); err != nil {
+			return err
+		}
+	} else {
+		data = []byte(t.markdown())
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+func (t Transcript) markdown() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Train booking conversation\n\n")
+	fmt.Fprintf(&b, "- User: `%s`\n- Server: %s\n- Prompt: %s\n- Started: %s\n- Saved: %s\n",
+		t.UserID, t.ServerURL, t.Prompt, t.Started.Format(time.RFC3339), t.Saved.Format(time.RFC3339))
+
+	for i, turn := range t.Turns {
+		fmt.Fprintf(&b, "\n## Turn %d (%s)\n\n", i+1, turn.Time.Format("15:04:05"))
+		fmt.Fprintf(&b, "**You:** %s\n\n", turn.User)
+		if turn.Intent != "" {
+			fmt.Fprintf(&b, "**Action:** `%s`", turn.Intent)
+			if len(turn.Parameters) > 0 {
+				params, _ := json.Marshal(turn.Parameters)
+				fmt.Fprintf(&b, " `%s`", params)
+			}
+			b.WriteString("\n\n")
+		}
+		for _, call := range turn.Calls {
+			fmt.Fprintf(&b, "- `%s %s` -> %d\n", call.Method, call.URL, call.Status)
+		}
+		if len(turn.Calls) > 0 {
+			b.WriteString("\n")
+		}
+		if turn.Error != "" {
+			fmt.Fprintf(&b, "**Error:** %s\n", turn.Error)
+			continue
+		}
+		fmt.Fprintf(&b, "**Agent:**\n\n```\n%s\n```\n", strings.TrimRight(turn.Reply, "\n"))
+	}
+	return b.String()
+}
+
+// saveCommand handles "/save [path]"; without a path the transcript goes to a
+// timestamped Markdown file in the current directory
+func (a *BookingAgent) saveCommand(arg string) string {
+	path := strings.TrimSpace(arg)
+	if path == "" {
+		path = "transcript-" + time.Now().Format("20060102-150405") + ".md"
+	}
+	if err := a.saveTranscript(path); err != nil {
+		return fmt.Sprintf("❌ Cannot save transcript: %v", err)
+	}
+	return fmt.Sprintf("✅ Saved %s to %s", countNoun(len(a.transcript), "turn", "turns"), path)
+}
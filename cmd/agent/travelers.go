@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Travelers: a user saves the people they book for, e.g. "save my mom: Li
+// Hua, passport E1234567, senior", and books for them by who they are to the
+// user: "book G100 for me and my mom" books a ticket each, and the receipt
+// names the traveler of every ticket. "show my travelers" lists them and
+// "forget my mom" removes one.
+
+const travelersParam = "Who travels when the user books for others as well: \"me\" and saved travelers, comma separated, such as \"me, mom\"; empty for the user alone"
+
+// Traveler is someone a user books for, known by their label
+type Traveler struct {
+	Label        string `json:"label"` // who they are to the user, e.g. mom; "self" for the user
+	Name         string `json:"name"`
+	Document     string `json:"document,omitempty"`      // ID card or passport number
+	DocumentType string `json:"document_type,omitempty"` // id_card or passport; any when empty
+	Type         string `json:"type"`                    // adult, child, senior or student
+}
+
+// The label of the user themselves
+const selfTraveler = "self"
+
+var passengerTypes = []string{"adult", "child", "senior", "student"}
+
+// Splits "me and my mom" or "me, mom"
+var travelerSeparator = regexp.MustCompile(`(?i)\s*(?:,|&|\band\b|\bplus\b)\s*`)
+
+func (a *BookingAgent) registerTravelersTool() {
+	err := a.tools.register(&funcTool{
+		name:        "manage_travelers",
+		description: "User wants to save, list or remove the travelers they book for, such as family members",
+		parameters: objectSchema(map[string]string{
+			"action":        "\"save\", \"remove\" or \"list\"",
+			"label":         "Who the traveler is to the user, such as mom or son",
+			"name":          "Traveler's full name",
+			"document":      "Traveler's ID card or passport number",
+			"document_type": "\"id_card\" or \"passport\", whichever the document is",
+			"type":          "Passenger type: adult, child, senior or student",
+			"user_id":       userIDParam,
+		}, "action"),
+		run: func(ctx context.Context, p map[string]string) string {
+			return a.manageTravelers(ctx, p)
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// travelerLabel is the label the user means, e.g. "mom" for "my Mom"
+func travelerLabel(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	s = strings.TrimSpace(strings.TrimPrefix(s, "my "))
+	switch s {
+	case "me", "myself", "i", "self":
+		return selfTraveler
+	}
+	return s
+}
+
+// parseTravelers reads the travelers a booking is for as labels, each once
+func parseTravelers(raw string) []string {
+	var labels []string
+	for _, part := range travelerSeparator.Split(raw, -1) {
+		if label := travelerLabel(part); label != "" && !slices.Contains(labels, label) {
+			labels = append(labels, label)
+		}
+	}
+	// The user alone is an ordinary booking
+	if len(labels) == 1 && labels[0] == selfTraveler {
+		return nil
+	}
+	return labels
+}
+
+// documentKind reads the type of a document as the user named it, e.g.
+// id_card for "ID card"
+func documentKind(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch s {
+	case "id", "id card", "id_card", "national id", "identity card":
+		return "id_card"
+	}
+	return strings.ReplaceAll(s, " ", "_")
+}
+
+// documentName names a document type, e.g. "passport"
+func documentName(kind string) string {
+	if kind == "id_card" {
+		return "ID card"
+	}
+	return strings.ReplaceAll(kind, "_", " ")
+}
+
+// maskDocument shows only the end of a document number
+func maskDocument(document string) string {
+	if len(document) <= 4 {
+		return document
+	}
+	return "•••" + document[len(document)-4:]
+}
+
+func describeTraveler(t Traveler) string {
+	s := fmt.Sprintf("%s: %s (%s)", t.Label, t.Name, t.Type)
+	if t.Document != "" && t.DocumentType != "" {
+		s += ", " + documentName(t.DocumentType) + " " + maskDocument(t.Document)
+	} else if t.Document != "" {
+		s += ", document " + maskDocument(t.Document)
+	}
+	return s
+}
+
+// manageTravelers saves, removes or lists the user's travelers
+func (a *BookingAgent) manageTravelers(ctx context.Context, p map[string]string) string {
+	user := p["user_id"]
+	if user == "" {
+		user = a.userID
+	}
+	saved, err := a.provider.Travelers(ctx, user)
+	if err != nil {
+		return a.providerError(ctx, "fetching travelers", err)
+	}
+	a.pii.learnTravelers(saved)
+	label := travelerLabel(p["label"])
+	i := slices.IndexFunc(saved, func(t Traveler) bool { return t.Label == label })
+
+	switch strings.ToLower(strings.TrimSpace(p["action"])) {
+	case "save", "add", "update":
+		t := Traveler{
+			Label:        label,
+			Name:         strings.TrimSpace(p["name"]),
+			Document:     strings.ToUpper(strings.TrimSpace(p["document"])),
+			DocumentType: documentKind(p["document_type"]),
+			Type:         strings.ToLower(strings.TrimSpace(p["type"])),
+		}
+		if t.Label == "" || t.Name == "" {
+			return "🤔 Who is the traveler to you, and what is their full name? For example: \"save my mom: Li Hua, passport E1234567, senior\"."
+		}
+		a.pii.learnTravelers([]Traveler{t})
+		if t.Type == "" {
+			t.Type = "adult"
+		}
+		if !slices.Contains(passengerTypes, t.Type) {
+			return fmt.Sprintf("🤔 Is %s an adult, child, senior or student?", t.Name)
+		}
+		if i >= 0 {
+			saved[i] = t
+		} else {
+			saved = append(saved, t)
+		}
+		if _, err := a.provider.SaveTravelers(ctx, user, saved); err != nil {
+			if errors.Is(err, ErrInvalid) {
+				return fmt.Sprintf("❌ %v", err)
+			}
+			return a.providerError(ctx, "saving travelers", err)
+		}
+		if t.Label == selfTraveler {
+			return fmt.Sprintf("✅ Saved your own details: %s. Your tickets will carry them.", describeTraveler(t))
+		}
+		return fmt.Sprintf("✅ Saved %s. Say \"book G100 for me and my %s\" to book for them too.", describeTraveler(t), t.Label)
+
+	case "remove", "delete", "forget":
+		if i < 0 {
+			return fmt.Sprintf("❌ No saved traveler called %s", label)
+		}
+		if _, err := a.provider.SaveTravelers(ctx, user, slices.Delete(saved, i, i+1)); err != nil {
+			return a.providerError(ctx, "removing a traveler", err)
+		}
+		return fmt.Sprintf("✅ Removed %s from your travelers.", label)
+	}
+
+	if len(saved) == 0 {
+		return "You have no saved travelers. Say \"save my mom: Li Hua, passport E1234567, senior\" to add one."
+	}
+	lines := []string{"👥 Your travelers:"}
+	if a.accessible {
+		lines[0] = "Your travelers:"
+	}
+	for _, t := range saved {
+		lines = append(lines, "• "+describeTraveler(t))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// checkTravelers matches the travelers a booking names to the user's saved
+// ones, by label or by name, and returns their labels; question asks about
+// any it does not know
+func (a *BookingAgent) checkTravelers(ctx context.Context, user string, labels []string) (matched []string, question string, err error) {
+	saved, err := a.provider.Travelers(ctx, user)
+	if err != nil {
+		return nil, "", err
+	}
+	a.pii.learnTravelers(saved)
+	for _, label := range labels {
+		i := slices.IndexFunc(saved, func(t Traveler) bool {
+			return t.Label == label || strings.EqualFold(t.Name, label)
+		})
+		switch {
+		case i >= 0:
+			matched = append(matched, saved[i].Label)
+		case label == selfTraveler:
+			matched = append(matched, selfTraveler)
+		default:
+			return nil, fmt.Sprintf("🤔 I don't have a traveler called %s saved. Tell me their full name, document number and passenger type, e.g. \"save my %s: Li Hua, passport E1234567, senior\", then book again.", label, label), nil
+		}
+	}
+	return matched, "", nil
+}
+
+// travelersNote names whom a booking was for, e.g. " for you and mom"
+func travelersNote(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := make([]string, len(labels))
+	for i, label := range labels {
+		names[i] = label
+		if label == selfTraveler {
+			names[i] = "you"
+		}
+	}
+	if len(names) == 1 {
+		return " for " + names[0]
+	}
+	return " for " + strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
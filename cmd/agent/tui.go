@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/table"
+	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/zhangbiao2009/train-booking/internal/plain"
+)
+
+// Full-screen terminal UI: conversation pane, selectable results table and a status bar
+
+const (
+	healthCheckInterval = 10 * time.Second
+	maxTableRows        = 8
+)
+
+var (
+	statusStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("252")).Background(lipgloss.Color("236")).Padding(0, 1)
+	onlineStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("42")).Background(lipgloss.Color("236"))
+	offlineStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("203")).Background(lipgloss.Color("236"))
+	youStyle     = lipgloss.NewStyle().Foreground(lipgloss.Color("39")).Bold(true)
+	tableBorder  = lipgloss.NewStyle().BorderStyle(lipgloss.NormalBorder()).BorderForeground(lipgloss.Color("240"))
+)
+
+type turnDoneMsg struct {
+	reply string
+	err   error
+}
+
+type healthMsg struct{ online bool }
+
+type tuiModel struct {
+	agent *BookingAgent
+
+	transcript []string
+	viewport   viewport.Model
+	input      textinput.Model
+	results    table.Model
+	shown      []Train // trains behind the table rows
+
+	plain        bool
+	tableFocused bool
+	busy         bool
+	cancelTurn   context.CancelFunc // cancels the message in flight
+	online       bool
+	width        int
+	height       int
+}
+
+func runTUI(agent *BookingAgent, plainOutput bool) error {
+	input := textinput.New()
+	input.Prompt = agent.prompt()
+	input.Placeholder = "Ask to search, book or cancel trains…"
+	input.Focus()
+
+	results := table.New(table.WithColumns([]table.Column{
+		{Title: "#", Width: 3},
+		{Title: "Train", Width: 6},
+		{Title: "Route", Width: 24},
+		{Title: "Date", Width: 10},
+		{Title: "Time", Width: 11},
+		{Title: "Seats", Width: 9},
+	}))
+
+	m := &tuiModel{
+		agent:    agent,
+		viewport: viewport.New(80, 20),
+		input:    input,
+		results:  results,
+		online:   true,
+		plain:    plainOutput,
+	}
+	m.appendTranscript("🤖 I can help you query, book, and cancel train tickets! Tab switches to the results table, Enter there books the selected train.")
+	_, err := tea.NewProgram(m, tea.WithAltScreen()).Run()
+	return err
+}
+
+func (m *tuiModel) Init() tea.Cmd {
+	return tea.Batch(textinput.Blink, m.checkHealth())
+}
+
+// checkHealth probes the booking server in the background
+func (m *tuiModel) checkHealth() tea.Cmd {
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, m.agent.serverURL+"/v1/list", nil)
+		if err != nil {
+			return healthMsg{false}
+		}
+		resp, err := m.agent.client.Do(req)
+		if err != nil {
+			return healthMsg{false}
+		}
+		resp.Body.Close()
+		return healthMsg{resp.StatusCode < 500}
+	}
+}
+
+func (m *tuiModel) send(text string) tea.Cmd {
+	agent := m.agent
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancelTurn = cancel
+	return func() tea.Msg {
+		defer cancel()
+		reply, err := agent.respond(ctx, text)
+		return turnDoneMsg{reply, err}
+	}
+}
+
+// bookSelected books the highlighted train without a round trip to the LLM
+func (m *tuiModel) bookSelected(train Train) tea.Cmd {
+	agent := m.agent
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancelTurn = cancel
+	return func() tea.Msg {
+		defer cancel()
+		agent.conversationHistory = append(agent.conversationHistory, Message{Role: "user", Content: "Book train " + train.ID})
+		reply := agent.placeBooking(ctx, train.ID, "", bookingExtras{})
+		agent.remember(reply)
+		agent.record(TranscriptEntry{User: "[book " + train.ID + "]", Intent: "book_ticket", Parameters: map[string]string{"train_id": train.ID}, Reply: reply})
+		return turnDoneMsg{reply: reply}
+	}
+}
+
+func (m *tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+		m.layout()
+		return m, nil
+
+	case healthMsg:
+		m.online = msg.online
+		return m, tea.Tick(healthCheckInterval, func(time.Time) tea.Msg { return m.checkHealth()() })
+
+	case turnDoneMsg:
+		m.busy = false
+		m.cancelTurn = nil
+		if msg.err != nil {
+			m.appendTranscript(m.agent.turnError(msg.err))
+		} else {
+			m.appendTranscript("🤖 " + msg.reply)
+		}
+		m.showResults(m.agent.lastResults)
+		return m, nil
+
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "ctrl+c":
+			// Ctrl-C abandons the message in flight; when idle it quits
+			if m.busy && m.cancelTurn != nil {
+				m.cancelTurn()
+				return m, nil
+			}
+			return m, tea.Quit
+		case "esc":
+			return m, tea.Quit
+		case "tab":
+			if len(m.shown) > 0 {
+				m.setTableFocus(!m.tableFocused)
+			}
+			return m, nil
+		case "pgup", "pgdown":
+			var cmd tea.Cmd
+			m.viewport, cmd = m.viewport.Update(msg)
+			return m, cmd
+		case "enter":
+			if m.busy {
+				return m, nil
+			}
+			if m.tableFocused {
+				row := m.results.Cursor()
+				if row < 0 || row >= len(m.shown) {
+					return m, nil
+				}
+				train := m.shown[row]
+				m.appendTranscript(youStyle.Render(strings.TrimSpace(m.agent.prompt())) + " [book " + train.ID + "]")
+				m.busy = true
+				m.setTableFocus(false)
+				return m, m.bookSelected(train)
+			}
+
+			text := strings.TrimSpace(m.input.Value())
+			if text == "" {
+				return m, nil
+			}
+			if strings.ToLower(text) == "quit" {
+				return m, tea.Quit
+			}
+			m.input.SetValue("")
+			m.appendTranscript(youStyle.Render(strings.TrimSpace(m.agent.prompt())) + " " + text)
+			if reply, ok := m.agent.metaCommand(text); ok {
+				m.appendTranscript(reply)
+				m.input.Prompt = m.agent.prompt()
+				m.showResults(m.agent.lastResults)
+				return m, nil
+			}
+			m.busy = true
+			return m, m.send(text)
+		}
+	}
+
+	var cmd tea.Cmd
+	if m.tableFocused {
+		m.results, cmd = m.results.Update(msg)
+	} else {
+		m.input, cmd = m.input.Update(msg)
+	}
+	return m, cmd
+}
+
+func (m *tuiModel) setTableFocus(focused bool) {
+	m.tableFocused = focused
+	if focused {
+		m.results.Focus()
+		m.input.Blur()
+	} else {
+		m.results.Blur()
+		m.input.Focus()
+	}
+}
+
+func (m *tuiModel) appendTranscript(entry string) {
+	if m.plain {
+		entry = plain.String(entry)
+	}
+	m.transcript = append(m.transcript, entry)
+	m.viewport.SetContent(lipgloss.NewStyle().Width(m.viewport.Width).Render(strings.Join(m.transcript, "\n\n")))
+	m.viewport.GotoBottom()
+}
+
+func (m *tuiModel) showResults(trains []Train) {
+	m.shown = trains
+	rows := make([]table.Row, len(trains))
+	for i, t := range trains {
+		route := t.From + " → " + t.To
+		if m.plain {
+			route = plain.String(route)
+		}
+		rows[i] = table.Row{
+			fmt.Sprint(i + 1), t.ID, route, t.Date,
+			t.DepartureTime + "-" + t.ArrivalTime, fmt.Sprintf("%d/%d", t.Available, t.TotalTickets),
+		}
+	}
+	m.results.SetRows(rows)
+	m.results.SetCursor(0)
+	if len(trains) == 0 {
+		m.setTableFocus(false)
+	}
+	m.layout()
+}
+
+// layout splits the screen between the conversation, the results table and the input
+func (m *tuiModel) layout() {
+	if m.width == 0 {
+		return
+	}
+	tableHeight := 0
+	if len(m.shown) > 0 {
+		rows := min(len(m.shown), maxTableRows)
+		m.results.SetHeight(rows + 1)
+		tableHeight = rows + 1 + 2 + 1 // header, border, border
+	}
+	m.viewport.Width = m.width
+	m.viewport.Height = max(3, m.height-tableHeight-3) // input, status bar, spacing
+	m.input.Width = m.width - len(m.input.Prompt) - 1
+	m.viewport.SetContent(lipgloss.NewStyle().Width(m.width).Render(strings.Join(m.transcript, "\n\n")))
+	m.viewport.GotoBottom()
+}
+
+func (m *tuiModel) View() string {
+	var b strings.Builder
+	b.WriteString(m.viewport.View())
+	b.WriteString("\n")
+	if len(m.shown) > 0 {
+		b.WriteString(tableBorder.Render(m.results.View()))
+		b.WriteString("\n")
+	}
+	b.WriteString(m.input.View())
+	b.WriteString("\n")
+	b.WriteString(m.statusBar())
+	return b.String()
+}
+
+func (m *tuiModel) statusBar() string {
+	server := onlineStyle.Render("● server online")
+	if !m.online {
+		server = offlineStyle.Render("● server offline")
+	}
+	activity := "Tab: results · Enter: send · PgUp/PgDn: scroll · Esc: quit"
+	if m.tableFocused {
+		activity = "↑/↓: select · Enter: book · Tab: back to input"
+	}
+	if m.busy {
+		activity = "Thinking… (Ctrl-C to cancel)"
+	}
+	bar := fmt.Sprintf("👤 %s │ %s │ %s", m.agent.userID, server, activity)
+	if m.plain {
+		bar = plain.String(bar)
+	}
+	return statusStyle.Width(m.width).Render(bar)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Upgrades: "upgrade my G100 ticket to business class" moves a booked ticket
+// to a higher class, paying the fare difference. The booking is the one named,
+// or else the last booking made in this conversation with that train.
+
+// Upgrade is a ticket moved to a higher class, with the updated receipt
+type Upgrade struct {
+	Class    string   `json:"class"`
+	Charged  float64  `json:"charged"`
+	Currency string   `json:"currency,omitempty"`
+	Receipt  *Receipt `json:"receipt"`
+}
+
+func (a *BookingAgent) registerUpgradeTool() {
+	err := a.tools.register(&funcTool{
+		name:        "upgrade",
+		description: "User wants a booked ticket moved to a higher class or a better seat, e.g. business or first class",
+		parameters: objectSchema(map[string]string{
+			"train_id":    "Train of the ticket, such as G100",
+			"class":       "Class wanted, such as first or business",
+			"booking_ref": "Booking reference, such as BK1A2B3C4D5E",
+			"user_id":     userIDParam,
+		}),
+		run: func(ctx context.Context, p map[string]string) string {
+			return a.upgradeTicket(ctx, p)
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// upgradeTicket upgrades the ticket and shows what it cost
+func (a *BookingAgent) upgradeTicket(ctx context.Context, p map[string]string) string {
+	req := UpgradeRequest{
+		UserID:     p["user_id"],
+		BookingRef: strings.ToUpper(p["booking_ref"]),
+		TrainID:    strings.ToUpper(p["train_id"]),
+		Class:      strings.TrimSpace(p["class"]),
+	}
+	if req.UserID == "" {
+		req.UserID = a.userID
+	}
+	if req.Class == "" {
+		return "🤔 Which class would you like, such as first or business?"
+	}
+	if req.BookingRef == "" {
+		req.BookingRef = a.bookingWith(ctx, req.TrainID, req.UserID)
+	}
+	if req.BookingRef == "" {
+		ticket := "ticket"
+		if req.TrainID != "" {
+			ticket = req.TrainID + " ticket"
+		}
+		return fmt.Sprintf("🤔 Which booking? Please give the booking reference of your %s, such as BK1A2B3C4D5E.", ticket)
+	}
+
+	upgrade, err := a.provider.Upgrade(ctx, req)
+	switch {
+	case errors.Is(err, ErrNoReceipt):
+		return fmt.Sprintf("❌ No booking %s found for user %s", req.BookingRef, req.UserID)
+	case errors.Is(err, ErrDeparted):
+		return fmt.Sprintf("❌ %v", err)
+	case err != nil:
+		return a.providerError(ctx, "upgrading ticket", err)
+	}
+
+	reply := fmt.Sprintf("⬆️ Upgraded your ticket on booking %s to %s class.", req.BookingRef, upgrade.Class)
+	if a.accessible {
+		reply = fmt.Sprintf("Upgraded your ticket on booking %s to %s class.", req.BookingRef, upgrade.Class)
+	}
+	if upgrade.Currency != "" && upgrade.Charged > 0 {
+		reply += fmt.Sprintf(" You were charged %.2f %s.", upgrade.Charged, upgrade.Currency)
+	}
+	if upgrade.Receipt != nil && upgrade.Receipt.Currency != "" {
+		reply += "\n" + a.formatReceipt(upgrade.Receipt)
+	}
+	return reply
+}
+
+// bookingWith is the last booking made in this conversation with a ticket on
+// trainID, or with any train when it is empty; empty if there is none
+func (a *BookingAgent) bookingWith(ctx context.Context, trainID, userID string) string {
+	for _, ref := range a.lastBookingRefs {
+		if trainID == "" {
+			return ref
+		}
+		receipt, err := a.provider.Receipt(ctx, ref, userID)
+		if err != nil {
+			continue
+		}
+		for _, item := range receipt.Items {
+			if strings.EqualFold(item.TrainID, trainID) {
+				return ref
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Voice input: with --voice the agent listens instead of reading the
+// keyboard, for hands-free kiosks. Each message is recorded by an external
+// command, sox by default, which stops at a pause in speech; the recording
+// is transcribed by the --stt provider and the text goes through the normal
+// pipeline, so saying "quit" ends the conversation.
+
+// Records one utterance as 16 kHz mono WAV into {file}, stopping after 1.5
+// seconds of silence or 30 seconds in all
+const defaultRecordCommand = "sox -q -d -r 16000 -c 1 -b 16 {file} silence 1 0.1 1% 1 1.5 1% trim 0 30"
+
+// SpeechToText transcribes a recorded WAV file; --stt picks the implementation
+type SpeechToText interface {
+	Transcribe(ctx context.Context, wavFile string) (string, error)
+}
+
+// newSpeechToText returns the provider called name: "whisper" for the
+// OpenAI transcription API, or "command" for a local program such as
+// whisper.cpp run as command
+func newSpeechToText(name, command string) (SpeechToText, error) {
+	switch name {
+	case "whisper":
+		return &whisperAPI{
+			client: &http.Client{Timeout: 60 * time.Second},
+			url:    envOr("TRAIN_AGENT_STT_URL", "https://api.openai.com/v1/audio/transcriptions"),
+			model:  envOr("TRAIN_AGENT_STT_MODEL", "whisper-1"),
+			key:    envOr("TRAIN_AGENT_STT_API_KEY", os.Getenv("OPENAI_API_KEY")),
+		}, nil
+	case "command":
+		if command == "" {
+			return nil, fmt.Errorf("--stt=command needs --stt-command, e.g. \"whisper-cli -nt -m model.bin -f {file}\"")
+		}
+		return commandSTT{command: command}, nil
+	}
+	return nil, fmt.Errorf("unknown speech-to-text provider %q: want whisper or command", name)
+}
+
+// whisperAPI posts recordings to an OpenAI-compatible transcription endpoint
+type whisperAPI struct {
+	client *http.Client
+	url    string
+	model  string
+	key    string
+}
+
+func (w *whisperAPI) Transcribe(ctx context.Context, wavFile string) (string, error) {
+	audio, err := os.ReadFile(wavFile)
+	if err != nil {
+		return "", err
+	}
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("model", w.model)
+	form.WriteField("response_format", "json")
+	part, err := form.CreateFormFile("file", filepath.Base(wavFile))
+	if err != nil {
+		return "", err
+	}
+	part.Write(audio)
+	form.Close()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, &body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if w.key != "" {
+		req.Header.Set("Authorization", "Bearer "+w.key)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("speech-to-text service: %s %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+	var result struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("speech-to-text service: cannot decode response: %v", err)
+	}
+	return strings.TrimSpace(result.Text), nil
+}
+
+// commandSTT runs a local transcriber, its {file} replaced by the
+// recording, and takes what it prints as the text
+type commandSTT struct {
+	command string
+}
+
+func (c commandSTT) Transcribe(ctx context.Context, wavFile string) (string, error) {
+	output, err := runWithFile(ctx, c.command, wavFile)
+	if err != nil {
+		return "", fmt.Errorf("speech-to-text command: %v", err)
+	}
+	return strings.Join(strings.Fields(string(output)), " "), nil
+}
+
+// runWithFile runs command through the shell with {file} replaced by file
+// and returns its standard output
+func runWithFile(ctx context.Context, command, file string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "sh", "-c", strings.ReplaceAll(command, "{file}", shellQuote(file)))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil && stderr.Len() > 0 {
+		err = fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return output, err
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// voiceReader is a lineReader that records and transcribes each message
+type voiceReader struct {
+	record string // records one utterance into {file}
+	stt    SpeechToText
+	prompt string
+}
+
+func newVoiceReader(cfg Config) (*voiceReader, error) {
+	stt, err := newSpeechToText(cfg.STT, cfg.STTCommand)
+	if err != nil {
+		return nil, err
+	}
+	return &voiceReader{record: cfg.RecordCommand, stt: stt, prompt: "You: "}, nil
+}
+
+// ReadLine listens until something is understood; a recorder that fails
+// ends the conversation, a transcription that fails is retried
+func (v *voiceReader) ReadLine() (string, error) {
+	dir, err := os.MkdirTemp("", "train-agent-voice")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "message.wav")
+
+	for {
+		fmt.Fprintln(notices, "🎤 Listening...")
+		if _, err := runWithFile(context.Background(), v.record, file); err != nil {
+			return "", fmt.Errorf("recording: %v", err)
+		}
+		text, err := v.stt.Transcribe(context.Background(), file)
+		switch {
+		case err != nil:
+			logger.Warn("transcription failed", "err", err)
+			fmt.Fprintf(notices, "⚠️ Sorry, I couldn't transcribe that: %v\n", err)
+			continue
+		case text == "":
+			fmt.Fprintln(notices, "🤔 I didn't catch that, please say it again.")
+			continue
+		}
+		logger.Debug("transcribed voice input", "chars", len(text))
+		fmt.Fprintf(out, "%s%s\n", v.prompt, text)
+		return text, nil
+	}
+}
+
+func (v *voiceReader) SetPrompt(prompt string) { v.prompt = prompt }
+
+func (v *voiceReader) Close() error { return nil }
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Weather at the destination: a tool for "what's the weather in Shanghai on my
+// travel day?" and a warning added to bookings when severe weather is forecast.
+
+// Forecast is one city's weather for one day
+type Forecast struct {
+	City    string
+	Date    string // YYYY-MM-DD
+	Summary string // e.g. "light rain"
+	LowC    int
+	HighC   int
+	Severe  string // why travel may be disrupted; empty when nothing severe is expected
+}
+
+// WeatherProvider looks up forecasts; --weather picks the implementation
+type WeatherProvider interface {
+	Forecast(ctx context.Context, city, date string) (*Forecast, error)
+}
+
+// newWeatherProvider returns the provider called name, or nil for "off"
+func newWeatherProvider(name string) (WeatherProvider, error) {
+	switch name {
+	case "off", "":
+		return nil, nil
+	case "mock":
+		return mockWeather{}, nil
+	case "open-meteo":
+		return &openMeteo{client: &http.Client{Timeout: 10 * time.Second}}, nil
+	}
+	return nil, fmt.Errorf("unknown weather provider %q: want off, mock or open-meteo", name)
+}
+
+// mockWeather makes up a stable forecast from the city and date, for demos and offline use
+type mockWeather struct{}
+
+var mockConditions = []struct {
+	summary string
+	severe  string
+}{
+	{"sunny", ""},
+	{"clear sky", ""},
+	{"partly cloudy", ""},
+	{"partly cloudy", ""},
+	{"overcast", ""},
+	{"light rain", ""},
+	{"drizzle", ""},
+	{"heavy rain", "heavy rain may slow trains down"},
+	{"thunderstorms", "thunderstorms may delay or suspend trains"},
+}
+
+func (mockWeather) Forecast(ctx context.Context, city, date string) (*Forecast, error) {
+	h := fnv.New32a()
+	h.Write([]byte(strings.ToLower(city) + "|" + date))
+	n := h.Sum32()
+	c := mockConditions[n%uint32(len(mockConditions))]
+	low := 10 + int(n>>8%15)
+	return &Forecast{City: city, Date: date, Summary: c.summary, LowC: low, HighC: low + 4 + int(n>>16%8), Severe: c.severe}, nil
+}
+
+// openMeteo uses the free Open-Meteo geocoding and forecast APIs, which need no key
+type openMeteo struct {
+	client *http.Client
+}
+
+// WMO weather codes worth a warning
+var severeWeatherCodes = map[int]string{
+	65: "heavy rain may slow trains down",
+	66: "freezing rain may delay trains",
+	67: "freezing rain may delay trains",
+	75: "heavy snow may delay or suspend trains",
+	82: "violent rain showers may slow trains down",
+	86: "heavy snow showers may delay trains",
+	95: "thunderstorms may delay or suspend trains",
+	96: "thunderstorms with hail may delay or suspend trains",
+	99: "thunderstorms with hail may delay or suspend trains",
+}
+
+func weatherCodeSummary(code int) string {
+	switch {
+	case code == 0:
+		return "clear sky"
+	case code <= 2:
+		return "partly cloudy"
+	case code == 3:
+		return "overcast"
+	case code == 45 || code == 48:
+		return "fog"
+	case code >= 51 && code <= 57:
+		return "drizzle"
+	case code >= 61 && code <= 63:
+		return "rain"
+	case code == 65:
+		return "heavy rain"
+	case code == 66 || code == 67:
+		return "freezing rain"
+	case code >= 71 && code <= 77:
+		return "snow"
+	case code >= 80 && code <= 82:
+		return "rain showers"
+	case code == 85 || code == 86:
+		return "snow showers"
+	case code >= 95:
+		return "thunderstorms"
+	}
+	return fmt.Sprintf("weather code %d", code)
+}
+
+func (o *openMeteo) getJSON(ctx context.Context, u string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("weather service: %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (o *openMeteo) Forecast(ctx context.Context, city, date string) (*Forecast, error) {
+	var places struct {
+		Results []struct {
+			Latitude  float64 `json:"latitude"`
+			Longitude float64 `json:"longitude"`
+		} `json:"results"`
+	}
+	if err := o.getJSON(ctx, "https://geocoding-api.open-meteo.com/v1/search?count=1&name="+url.QueryEscape(city), &places); err != nil {
+		return nil, err
+	}
+	if len(places.Results) == 0 {
+		return nil, fmt.Errorf("no place called %s", city)
+	}
+
+	var forecast struct {
+		Daily struct {
+			Code []int     `json:"weathercode"`
+			Max  []float64 `json:"temperature_2m_max"`
+			Min  []float64 `json:"temperature_2m_min"`
+		} `json:"daily"`
+	}
+	u := fmt.Sprintf("https://api.open-meteo.com/v1/forecast?latitude=%.4f&longitude=%.4f&daily=weathercode,temperature_2m_max,temperature_2m_min&timezone=auto&start_date=%s&end_date=%s",
+		places.Results[0].Latitude, places.Results[0].Longitude, date, date)
+	if err := o.getJSON(ctx, u, &forecast); err != nil {
+		return nil, err
+	}
+	d := forecast.Daily
+	if len(d.Code) == 0 || len(d.Max) == 0 || len(d.Min) == 0 {
+		return nil, errors.New("no forecast for that day")
+	}
+	return &Forecast{
+		City:    city,
+		Date:    date,
+		Summary: weatherCodeSummary(d.Code[0]),
+		LowC:    int(d.Min[0] + 0.5),
+		HighC:   int(d.Max[0] + 0.5),
+		Severe:  severeWeatherCodes[d.Code[0]],
+	}, nil
+}
+
+// enableWeather registers the weather tool backed by p
+func (a *BookingAgent) enableWeather(p WeatherProvider) error {
+	a.weather = p
+	return a.tools.register(&funcTool{
+		name:        "weather",
+		description: "User asks about the weather at a city, usually their destination; leave date empty for the day of their booked trip",
+		parameters: objectSchema(map[string]string{
+			"city": "City name; empty for the destination of the user's next booked trip",
+			"date": "Day of the forecast, YYYY-MM-DD; empty for the day they travel to the city",
+		}),
+		run: a.weatherReport,
+	})
+}
+
+func (a *BookingAgent) weatherReport(ctx context.Context, p map[string]string) string {
+	city, date := p["city"], p["date"]
+	if city == "" || date == "" {
+		// "on my travel day": take it from the user's bookings
+		trip, ok := a.bookedTrip(ctx, a.userID, city)
+		switch {
+		case ok:
+			if city == "" {
+				city = trip.To
+			}
+			if date == "" {
+				date = trip.Date
+			}
+		case city == "":
+			return "🤔 Which city would you like the weather for?"
+		default:
+			date = time.Now().Format(time.DateOnly)
+		}
+	}
+
+	forecast, err := a.weather.Forecast(ctx, city, date)
+	if err != nil {
+		logger.Warn("weather lookup failed", "city", city, "date", date, "err", err)
+		return fmt.Sprintf("❌ Cannot get the weather for %s on %s: %v", city, date, err)
+	}
+	result := fmt.Sprintf("🌤️ Weather in %s on %s: %s, %d–%d°C", forecast.City, forecast.Date, forecast.Summary, forecast.LowC, forecast.HighC)
+	if forecast.Severe != "" {
+		result += fmt.Sprintf("\n⚠️ Severe weather: %s", forecast.Severe)
+	}
+	return result
+}
+
+// bookedTrip finds userID's booked train to city, or to anywhere if city is
+// empty, preferring the earliest
+func (a *BookingAgent) bookedTrip(ctx context.Context, userID, city string) (Train, bool) {
+	bookings, err := a.provider.Status(ctx, userID)
+	if err != nil || len(bookings) == 0 {
+		return Train{}, false
+	}
+
+	ids := make([]string, len(bookings))
+	for i, booking := range bookings {
+		ids[i] = booking.TrainID
+	}
+	var best Train
+	found := false
+	for _, train := range a.getTrainDetails(ctx, ids) {
+		if city != "" && !strings.EqualFold(train.To, city) {
+			continue
+		}
+		if !found || train.Date+train.DepartureTime < best.Date+best.DepartureTime {
+			best, found = train, true
+		}
+	}
+	return best, found
+}
+
+// weatherWarning mentions severe weather forecast at the destinations of
+// trainIDs on their travel days; empty when there is none or weather is off
+func (a *BookingAgent) weatherWarning(ctx context.Context, trainIDs []string) string {
+	if a.weather == nil {
+		return ""
+	}
+	var warnings []string
+	trains := a.getTrainDetails(ctx, trainIDs)
+	for _, id := range trainIDs {
+		train, ok := trains[strings.TrimSpace(id)]
+		if !ok {
+			continue
+		}
+		forecast, err := a.weather.Forecast(ctx, train.To, train.Date)
+		if err != nil {
+			logger.Warn("weather lookup failed", "city", train.To, "date", train.Date, "err", err)
+			continue
+		}
+		if forecast.Severe != "" {
+			warnings = append(warnings, fmt.Sprintf("⚠️ Severe weather expected in %s on %s (%s): %s. Check for delays before you travel.",
+				train.To, train.Date, forecast.Summary, forecast.Severe))
+		}
+	}
+	if len(warnings) == 0 {
+		return ""
+	}
+	return "\n" + strings.Join(warnings, "\n")
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// WeChat: --wechat :8092 serves a WeChat official account's message handler
+// instead of the terminal chat, in plaintext mode. Each text message is a
+// turn of its sender's conversation, keyed by their OpenID and booking as
+// the user ID --wechat-users maps it to, or wx-<OpenID>.
+//
+// WeChat waits 5 seconds for a reply and then delivers the message again,
+// up to three times. A reply ready in time goes back as the passive reply;
+// redeliveries wait on the same turn rather than starting another. With
+// WECHAT_APP_ID and WECHAT_APP_SECRET, a slower reply, and the rest of one
+// too long for a single message, is sent through the customer service
+// message API instead. WECHAT_TOKEN is the token configured for the server
+// URL, which signs every request.
+
+// Longest text WeChat accepts in one message, in bytes
+const wechatMaxText = 2048
+
+// How long a delivery waits for its reply, within WeChat's 5 seconds
+const wechatReplyWait = 4500 * time.Millisecond
+
+// How far a request's timestamp may be from now; its nonce is remembered as
+// long, so a captured request cannot be sent again
+const wechatMaxSkew = 5 * time.Minute
+
+type wechatBridge struct {
+	pool      *sessionPool
+	client    *http.Client
+	apiURL    string // e.g. https://api.weixin.qq.com
+	token     string
+	appID     string
+	appSecret string
+	users     map[string]string // OpenID to user ID; others get wx-<OpenID>
+
+	mu          sync.Mutex
+	turns       map[string]*wechatTurn // by message ID, as deliveries are retried
+	nonces      map[string]time.Time   // of signed requests, by when they are forgotten
+	accessToken string
+	tokenExpiry time.Time
+}
+
+// wechatTurn is a message being answered
+type wechatTurn struct {
+	done     chan struct{} // closed when reply is set
+	reply    string
+	started  time.Time
+	waiting  int  // deliveries waiting for the reply
+	answered bool // a delivery has taken the reply, or it was sent by the API
+}
+
+// wechatMessage is a message or event pushed to the handler
+type wechatMessage struct {
+	ToUserName   string `xml:"ToUserName"`
+	FromUserName string `xml:"FromUserName"`
+	CreateTime   int64  `xml:"CreateTime"`
+	MsgType      string `xml:"MsgType"`
+	Content      string `xml:"Content"`
+	MsgID        string `xml:"MsgId"`
+	Event        string `xml:"Event"`
+	Recognition  string `xml:"Recognition"` // voice messages, with speech recognition on
+}
+
+// wechatReply is a passive text reply
+type wechatReply struct {
+	XMLName      xml.Name `xml:"xml"`
+	ToUserName   cdata    `xml:"ToUserName"`
+	FromUserName cdata    `xml:"FromUserName"`
+	CreateTime   int64    `xml:"CreateTime"`
+	MsgType      cdata    `xml:"MsgType"`
+	Content      cdata    `xml:"Content"`
+}
+
+type cdata struct {
+	Text string `xml:",cdata"`
+}
+
+func newWechatBridge(cfg Config, agent *BookingAgent) (*wechatBridge, error) {
+	b := &wechatBridge{
+		pool:      newSessionPool(agent),
+		client:    &http.Client{Timeout: 30 * time.Second},
+		apiURL:    strings.TrimRight(envOr("WECHAT_API_URL", "https://api.weixin.qq.com"), "/"),
+		token:     os.Getenv("WECHAT_TOKEN"),
+		appID:     os.Getenv("WECHAT_APP_ID"),
+		appSecret: os.Getenv("WECHAT_APP_SECRET"),
+		turns:     map[string]*wechatTurn{},
+		nonces:    map[string]time.Time{},
+	}
+	if b.token == "" {
+		return nil, fmt.Errorf("WECHAT_TOKEN is not set")
+	}
+	if cfg.WechatUsers != "" {
+		users, err := loadChannelUsers(cfg.WechatUsers)
+		if err != nil {
+			return nil, err
+		}
+		b.users = users
+	}
+	if !b.canSend() {
+		logger.Warn("WECHAT_APP_ID or WECHAT_APP_SECRET is not set; replies slower than WeChat retries for are lost")
+	}
+	return b, nil
+}
+
+// canSend tells whether replies can be sent through the customer service API
+func (b *wechatBridge) canSend() bool {
+	return b.appID != "" && b.appSecret != ""
+}
+
+// userID is the booking user an OpenID maps to
+func (b *wechatBridge) userID(openID string) string {
+	if user, ok := b.users[openID]; ok {
+		return user
+	}
+	return channelUserID("wx", openID)
+}
+
+// signed checks a request's signature: the SHA-1 of the token, timestamp
+// and nonce, sorted and joined
+func (b *wechatBridge) signed(q url.Values) bool {
+	parts := []string{b.token, q.Get("timestamp"), q.Get("nonce")}
+	sort.Strings(parts)
+	sum := sha1.Sum([]byte(strings.Join(parts, "")))
+	return subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(q.Get("signature"))) == 1
+}
+
+// fresh checks that a signed request is not a replay: its timestamp is within
+// wechatMaxSkew of now and its nonce was not seen in that time
+func (b *wechatBridge) fresh(q url.Values, now time.Time) bool {
+	sec, err := strconv.ParseInt(q.Get("timestamp"), 10, 64)
+	if err != nil {
+		return false
+	}
+	if skew := now.Sub(time.Unix(sec, 0)); skew > wechatMaxSkew || skew < -wechatMaxSkew {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for nonce, expiry := range b.nonces {
+		if now.After(expiry) {
+			delete(b.nonces, nonce)
+		}
+	}
+	nonce := q.Get("timestamp") + ":" + q.Get("nonce")
+	if _, seen := b.nonces[nonce]; seen {
+		return false
+	}
+	b.nonces[nonce] = now.Add(2 * wechatMaxSkew)
+	return true
+}
+
+func (b *wechatBridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !b.signed(r.URL.Query()) {
+		http.Error(w, "bad signature", http.StatusUnauthorized)
+		return
+	}
+	if !b.fresh(r.URL.Query(), time.Now()) {
+		logger.Warn("wechat request refused", "reason", "stale timestamp or nonce seen before")
+		http.Error(w, "stale or replayed request", http.StatusUnauthorized)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		// Server URL verification; echostr is sent back as it came, as text
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		fmt.Fprint(w, r.URL.Query().Get("echostr"))
+	case http.MethodPost:
+		b.receive(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// receive answers a message with a passive reply, if its turn finishes in
+// time, or else with "success", which WeChat takes as no reply
+func (b *wechatBridge) receive(w http.ResponseWriter, r *http.Request) {
+	var m wechatMessage
+	if err := xml.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&m); err != nil {
+		http.Error(w, "invalid XML body", http.StatusBadRequest)
+		return
+	}
+
+	var reply string
+	switch {
+	case m.MsgType == "event" && m.Event == "subscribe":
+		reply = "👋 Welcome! I can search trains and book tickets for you. Try \"trains from Beijing to Shanghai tomorrow\"."
+	case m.MsgType == "event":
+	case m.MsgType == "text" || m.MsgType == "voice" && m.Recognition != "":
+		text := m.Content
+		if m.MsgType == "voice" {
+			text = m.Recognition
+		}
+		reply = b.await(&m, text)
+	default:
+		reply = "🤔 I can only read text messages. Please type your request, such as \"book G100\"."
+	}
+	if reply == "" {
+		fmt.Fprint(w, "success")
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(wechatReply{
+		ToUserName:   cdata{m.FromUserName},
+		FromUserName: cdata{m.ToUserName},
+		CreateTime:   time.Now().Unix(),
+		MsgType:      cdata{"text"},
+		Content:      cdata{reply},
+	})
+}
+
+// await starts the turn for message m, unless a delivery before this one
+// did, and waits for its reply. It returns "" when the reply is not ready,
+// or was already given.
+func (b *wechatBridge) await(m *wechatMessage, text string) string {
+	key := m.MsgID
+	if key == "" {
+		key = fmt.Sprintf("%s@%d", m.FromUserName, m.CreateTime)
+	}
+
+	b.mu.Lock()
+	now := time.Now()
+	for id, t := range b.turns {
+		if now.Sub(t.started) > time.Hour {
+			delete(b.turns, id)
+		}
+	}
+	t, ok := b.turns[key]
+	if !ok {
+		t = &wechatTurn{done: make(chan struct{}), started: now}
+		b.turns[key] = t
+		go b.answer(m.FromUserName, text, t)
+	}
+	t.waiting++
+	b.mu.Unlock()
+
+	timer := time.NewTimer(wechatReplyWait)
+	defer timer.Stop()
+	select {
+	case <-t.done:
+	case <-timer.C:
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t.waiting--
+	select {
+	case <-t.done:
+	default:
+		// Not ready: a redelivery or the API gives it
+		return ""
+	}
+	if t.answered {
+		return ""
+	}
+	t.answered = true
+	parts := splitMessage(t.reply, wechatMaxText)
+	switch {
+	case len(parts) == 0:
+		return ""
+	case len(parts) > 1 && b.canSend():
+		go b.sendText(m.FromUserName, parts[1:])
+	case len(parts) > 1:
+		return truncateBytes(parts[0], wechatMaxText-len("…")) + "…"
+	}
+	return parts[0]
+}
+
+// answer runs the turn and, when no delivery is waiting to give the reply,
+// sends it through the customer service API. Without the API it is left
+// for a redelivery to give.
+func (b *wechatBridge) answer(openID, text string, t *wechatTurn) {
+	turn := b.pool.reply("wx:"+openID, b.userID(openID), text)
+	b.mu.Lock()
+	t.reply = turn.Reply
+	close(t.done)
+	late := t.waiting == 0 && b.canSend()
+	if late {
+		t.answered = true
+	}
+	b.mu.Unlock()
+	if late {
+		b.sendText(openID, splitMessage(turn.Reply, wechatMaxText))
+	}
+}
+
+// sendText sends parts as customer service messages
+func (b *wechatBridge) sendText(openID string, parts []string) {
+	for _, part := range parts {
+		err := b.send(map[string]any{
+			"touser":  openID,
+			"msgtype": "text",
+			"text":    map[string]string{"content": part},
+		})
+		if err != nil {
+			logger.Error("wechat send failed", "err", err)
+			return
+		}
+	}
+}
+
+// send posts a customer service message
+func (b *wechatBridge) send(message map[string]any) error {
+	token, err := b.apiToken()
+	if err != nil {
+		return err
+	}
+	body, _ := json.Marshal(message)
+	var result struct {
+		ErrCode int    `json:"errcode"`
+		ErrMsg  string `json:"errmsg"`
+	}
+	if err := b.call(http.MethodPost, "/cgi-bin/message/custom/send?access_token="+url.QueryEscape(token), body, &result); err != nil {
+		return err
+	}
+	if result.ErrCode != 0 {
+		if result.ErrCode == 40001 || result.ErrCode == 42001 {
+			// The access token was revoked or expired early
+			b.mu.Lock()
+			b.accessToken = ""
+			b.mu.Unlock()
+		}
+		return fmt.Errorf("wechat: %d %s", result.ErrCode, result.ErrMsg)
+	}
+	return nil
+}
+
+// apiToken returns the access token for the API, fetching a new one
+// shortly before the current one expires
+func (b *wechatBridge) apiToken() (string, error) {
+	b.mu.Lock()
+	if b.accessToken != "" && time.Now().Before(b.tokenExpiry) {
+		defer b.mu.Unlock()
+		return b.accessToken, nil
+	}
+	b.mu.Unlock()
+
+	q := url.Values{"grant_type": {"client_credential"}, "appid": {b.appID}, "secret": {b.appSecret}}
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+		ErrCode     int    `json:"errcode"`
+		ErrMsg      string `json:"errmsg"`
+	}
+	if err := b.call(http.MethodGet, "/cgi-bin/token?"+q.Encode(), nil, &result); err != nil {
+		return "", err
+	}
+	if result.AccessToken == "" {
+		return "", fmt.Errorf("wechat: cannot get access token: %d %s", result.ErrCode, result.ErrMsg)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.accessToken = result.AccessToken
+	b.tokenExpiry = time.Now().Add(time.Duration(result.ExpiresIn)*time.Second - 5*time.Minute)
+	return b.accessToken, nil
+}
+
+// call sends a request to the WeChat API and decodes its JSON answer
+func (b *wechatBridge) call(method, path string, body []byte, result any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, b.apiURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("wechat: %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// truncateBytes cuts s to at most n bytes without splitting a character
+func truncateBytes(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// serveWechat runs the message handler at addr until the server fails
+func serveWechat(addr string, b *wechatBridge) error {
+	mux := http.NewServeMux()
+	mux.Handle("/wechat", b)
+	fmt.Fprintf(notices, "💬 WeChat message handler listening on %s/wechat\n", addr)
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	return server.ListenAndServe()
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// WhatsApp: --whatsapp :8090 serves a WhatsApp Business Cloud API webhook
+// instead of the terminal chat. Each text message is a turn of its sender's
+// conversation, booking as the user ID the number maps to, and the reply is
+// sent back through the Cloud API. With --whatsapp-template, a booking is
+// confirmed with that approved template, its body given the booking
+// reference, so the confirmation can be delivered outside WhatsApp's
+// 24-hour reply window.
+//
+// Credentials come from the environment: WHATSAPP_TOKEN to send messages,
+// WHATSAPP_VERIFY_TOKEN to answer Meta's webhook verification and
+// WHATSAPP_APP_SECRET to check the X-Hub-Signature-256 of deliveries.
+
+// Longest text message WhatsApp delivers
+const whatsappMaxText = 4096
+
+type whatsappBridge struct {
+	pool        *sessionPool
+	client      *http.Client
+	apiURL      string // Graph API base, e.g. https://graph.facebook.com/v20.0
+	token       string
+	verifyToken string
+	appSecret   string
+	template    string            // approved template confirming bookings; empty sends text
+	language    string            // the template's language code
+	users       map[string]string // phone number to user ID; others get wa-<number>
+
+	mu   sync.Mutex
+	seen map[string]time.Time // message IDs handled, as deliveries are retried
+}
+
+// whatsappUpdate is the part of a webhook delivery the bridge reads
+type whatsappUpdate struct {
+	Entry []struct {
+		Changes []struct {
+			Value struct {
+				Metadata struct {
+					PhoneNumberID string `json:"phone_number_id"`
+				} `json:"metadata"`
+				Messages []struct {
+					ID   string `json:"id"`
+					From string `json:"from"`
+					Type string `json:"type"`
+					Text struct {
+						Body string `json:"body"`
+					} `json:"text"`
+				} `json:"messages"`
+			} `json:"value"`
+		} `json:"changes"`
+	} `json:"entry"`
+}
+
+// newWhatsappBridge reads the credentials and the --whatsapp-users file,
+// a JSON object of phone numbers and user IDs
+func newWhatsappBridge(cfg Config, agent *BookingAgent) (*whatsappBridge, error) {
+	b := &whatsappBridge{
+		pool:        newSessionPool(agent),
+		client:      &http.Client{Timeout: 30 * time.Second},
+		apiURL:      strings.TrimRight(envOr("WHATSAPP_API_URL", "https://graph.facebook.com/v20.0"), "/"),
+		token:       os.Getenv("WHATSAPP_TOKEN"),
+		verifyToken: os.Getenv("WHATSAPP_VERIFY_TOKEN"),
+		appSecret:   os.Getenv("WHATSAPP_APP_SECRET"),
+		template:    cfg.WhatsappTemplate,
+		language:    envOr("WHATSAPP_TEMPLATE_LANGUAGE", "en"),
+		seen:        map[string]time.Time{},
+	}
+	if b.token == "" {
+		return nil, fmt.Errorf("WHATSAPP_TOKEN is not set")
+	}
+	// Unsigned deliveries could book and cancel as any phone number
+	if b.appSecret == "" {
+		return nil, fmt.Errorf("WHATSAPP_APP_SECRET is not set")
+	}
+	if cfg.WhatsappUsers != "" {
+		users, err := loadChannelUsers(cfg.WhatsappUsers)
+		if err != nil {
+			return nil, err
+		}
+		b.users = users
+	}
+	return b, nil
+}
+
+// userID is the booking user a phone number maps to
+func (b *whatsappBridge) userID(number string) string {
+	if user, ok := b.users[number]; ok {
+		return user
+	}
+	if user, ok := b.users["+"+number]; ok {
+		return user
+	}
+	return channelUserID("wa", number)
+}
+
+func (b *whatsappBridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		b.verify(w, r)
+	case http.MethodPost:
+		b.receive(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// verify answers Meta's subscription check with its challenge
+func (b *whatsappBridge) verify(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("hub.mode") != "subscribe" || b.verifyToken == "" ||
+		!hmac.Equal([]byte(q.Get("hub.verify_token")), []byte(b.verifyToken)) {
+		http.Error(w, "verification failed", http.StatusForbidden)
+		return
+	}
+	fmt.Fprint(w, q.Get("hub.challenge"))
+}
+
+// receive acknowledges a delivery at once and answers its text messages in
+// the background, as the Cloud API retries deliveries left waiting
+func (b *whatsappBridge) receive(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "cannot read body", http.StatusBadRequest)
+		return
+	}
+	mac := hmac.New(sha256.New, []byte(b.appSecret))
+	mac.Write(body)
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(r.Header.Get("X-Hub-Signature-256")), []byte(want)) {
+		http.Error(w, "bad signature", http.StatusUnauthorized)
+		return
+	}
+	var update whatsappUpdate
+	if err := json.Unmarshal(body, &update); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+
+	for _, entry := range update.Entry {
+		for _, change := range entry.Changes {
+			phoneID := change.Value.Metadata.PhoneNumberID
+			for _, m := range change.Value.Messages {
+				if !b.firstDelivery(m.ID) {
+					continue
+				}
+				if m.Type != "text" {
+					go b.sendText(phoneID, m.From, "🤔 I can only read text messages. Please type your request, such as \"book G100\".")
+					continue
+				}
+				go b.answer(phoneID, m.From, m.Text.Body)
+			}
+		}
+	}
+}
+
+// firstDelivery tells whether message id is new, forgetting IDs after a day
+func (b *whatsappBridge) firstDelivery(id string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	for seen, at := range b.seen {
+		if now.Sub(at) > 24*time.Hour {
+			delete(b.seen, seen)
+		}
+	}
+	if _, ok := b.seen[id]; ok {
+		return false
+	}
+	b.seen[id] = now
+	return true
+}
+
+// answer runs the message as a turn of the sender's conversation and sends
+// the reply, a booking first confirmed with the template when one is
+// configured
+func (b *whatsappBridge) answer(phoneID, from, text string) {
+	turn := b.pool.reply("wa:"+from, b.userID(from), text)
+	if b.template != "" && len(turn.BookingRefs) > 0 {
+		err := b.send(phoneID, map[string]any{
+			"to":   from,
+			"type": "template",
+			"template": map[string]any{
+				"name":     b.template,
+				"language": map[string]string{"code": b.language},
+				"components": []map[string]any{{
+					"type":       "body",
+					"parameters": []map[string]string{{"type": "text", "text": strings.Join(turn.BookingRefs, ", ")}},
+				}},
+			},
+		})
+		if err != nil {
+			logger.Warn("whatsapp template failed", "template", b.template, "err", err)
+		}
+	}
+	b.sendText(phoneID, from, turn.Reply)
+}
+
+// sendText sends text, split into messages WhatsApp accepts
+func (b *whatsappBridge) sendText(phoneID, to, text string) {
+	for _, part := range splitMessage(text, whatsappMaxText) {
+		err := b.send(phoneID, map[string]any{
+			"to":   to,
+			"type": "text",
+			"text": map[string]any{"body": part, "preview_url": false},
+		})
+		if err != nil {
+			logger.Error("whatsapp send failed", "err", err)
+			return
+		}
+	}
+}
+
+// send posts a message through the Cloud API
+func (b *whatsappBridge) send(phoneID string, message map[string]any) error {
+	message["messaging_product"] = "whatsapp"
+	body, _ := json.Marshal(message)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/%s/messages", b.apiURL, phoneID), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+b.token)
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("whatsapp: %s %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
+
+// splitMessage cuts text into parts of at most limit bytes, at line breaks
+// where it can
+func splitMessage(text string, limit int) []string {
+	var parts []string
+	for len(text) > limit {
+		cut := strings.LastIndex(text[:limit], "\n")
+		if cut <= 0 {
+			cut = limit
+			for cut > 0 && !utf8.RuneStart(text[cut]) {
+				cut--
+			}
+		}
+		parts = append(parts, strings.TrimRight(text[:cut], "\n"))
+		text = strings.TrimLeft(text[cut:], "\n")
+	}
+	if text != "" {
+		parts = append(parts, text)
+	}
+	return parts
+}
+
+// serveWhatsapp runs the webhook at addr until the server fails
+func serveWhatsapp(addr string, b *whatsappBridge) error {
+	mux := http.NewServeMux()
+	mux.Handle("/whatsapp", b)
+	fmt.Fprintf(notices, "💬 WhatsApp webhook listening on %s/whatsapp\n", addr)
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	return server.ListenAndServe()
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Wheelchair seats: "book K300 with a wheelchair-accessible seat" books one of
+// the seats a train sets aside, which the provider refuses when none are
+// left. A booking whose message mentions mobility needs without saying which
+// seat is wanted is held for a polite question first, when its trains have
+// such seats.
+
+const wheelchairParam = "\"yes\" when the passenger needs a wheelchair-accessible seat, \"no\" when they said they do not; empty otherwise"
+
+// Words suggesting the passenger may need an accessible seat
+var mobilityCue = regexp.MustCompile(`(?i)\b(wheel\s*chairs?|mobility|disab\w*|accessib\w*|step-free|crutches)\b`)
+
+// wheelchairQuestion asks whether a booking whose message mentions mobility
+// needs should take wheelchair-accessible seats; empty when the question does
+// not arise
+func (a *BookingAgent) wheelchairQuestion(ctx context.Context, intent *IntentResponse, message string) string {
+	if intent.Intent != "book_ticket" || intent.ClarifyQuestion != "" || intent.Parameters["wheelchair"] != "" || !mobilityCue.MatchString(message) {
+		return ""
+	}
+	ids := strings.Split(intent.Parameters["train_id"], ",")
+	trains := a.getTrainDetails(ctx, ids)
+	var with []string
+	for _, id := range ids {
+		if train, ok := trains[strings.ToUpper(strings.TrimSpace(id))]; ok && train.AccessibleSeats > 0 {
+			with = append(with, train.ID)
+		}
+	}
+	if len(with) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("♿ Would you like a wheelchair-accessible seat on %s? Just say yes or no, and I'll book it for you.", strings.Join(with, " and "))
+}
+
+// answerWheelchairQuestion books the booking held by wheelchairQuestion, with
+// accessible seats or without; "cancel" books nothing. Anything else is a new
+// request, so ok is false.
+func (a *BookingAgent) answerWheelchairQuestion(ctx context.Context, pending *IntentResponse, answer string) (reply string, ok bool) {
+	if strings.EqualFold(strings.Trim(strings.TrimSpace(answer), ".!"), "cancel") {
+		return a.answerConfirmation(ctx, pending, answer)
+	}
+	yes, ok := yesOrNo(answer)
+	if !ok {
+		return "", false
+	}
+	pending.Parameters["wheelchair"] = "no"
+	if yes {
+		pending.Parameters["wheelchair"] = "yes"
+	}
+	return a.runAnswered(ctx, pending, answer), true
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Discord interactions: Discord posts each slash command to the bot's
+// interactions endpoint, signed with the application's public key. The bot
+// defers its answer, which Discord allows 3 seconds for, runs the command
+// as a turn of the user's agent and then edits the deferred message with
+// the reply. Replies in servers are ephemeral, so each user's bookings stay
+// between them and the bot; in DMs they are ordinary messages.
+
+// Interaction and response types used by the bot
+const (
+	interactionPing    = 1
+	interactionCommand = 2
+
+	responsePong     = 1
+	responseDeferred = 5
+
+	flagEphemeral = 64
+)
+
+// Discord's limits on message content and embed fields
+const (
+	maxContent     = 2000
+	maxEmbedFields = 25
+)
+
+// Embed colors: green for trains found, red when a command failed
+const (
+	colorTrains = 0x2ecc71
+	colorFailed = 0xe74c3c
+)
+
+type interaction struct {
+	ID            string `json:"id"`
+	ApplicationID string `json:"application_id"`
+	Type          int    `json:"type"`
+	Token         string `json:"token"`
+	GuildID       string `json:"guild_id"`
+	Data          struct {
+		Name    string `json:"name"`
+		Options []struct {
+			Name  string `json:"name"`
+			Value any    `json:"value"`
+		} `json:"options"`
+	} `json:"data"`
+	Member *struct {
+		User discordUser `json:"user"`
+	} `json:"member"` // in servers
+	User *discordUser `json:"user"` // in DMs
+}
+
+type discordUser struct {
+	ID string `json:"id"`
+}
+
+// option returns the value of the command option name, or ""
+func (i *interaction) option(name string) string {
+	for _, o := range i.Data.Options {
+		if o.Name == name {
+			return strings.TrimSpace(fmt.Sprint(o.Value))
+		}
+	}
+	return ""
+}
+
+// userID is the Discord user who ran the command
+func (i *interaction) userID() string {
+	if i.Member != nil {
+		return i.Member.User.ID
+	}
+	if i.User != nil {
+		return i.User.ID
+	}
+	return ""
+}
+
+type embed struct {
+	Title       string       `json:"title,omitempty"`
+	Description string       `json:"description,omitempty"`
+	Color       int          `json:"color,omitempty"`
+	Fields      []embedField `json:"fields,omitempty"`
+	Footer      *embedFooter `json:"footer,omitempty"`
+}
+
+type embedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline,omitempty"`
+}
+
+type embedFooter struct {
+	Text string `json:"text"`
+}
+
+// message is the content of a reply
+type message struct {
+	Content string  `json:"content,omitempty"`
+	Embeds  []embed `json:"embeds,omitempty"`
+	Flags   int     `json:"flags,omitempty"`
+}
+
+// commands are the slash commands registered by --register
+var commands = []map[string]any{
+	{"name": "trains", "description": "List all trains"},
+	{"name": "search", "description": "Search trains between two cities", "options": []map[string]any{
+		{"type": 3, "name": "from", "description": "Departure city", "required": true},
+		{"type": 3, "name": "to", "description": "Destination city", "required": true},
+		{"type": 3, "name": "date", "description": "Travel date, YYYY-MM-DD"},
+	}},
+	{"name": "mytickets", "description": "Show your booked tickets"},
+	{"name": "ask", "description": "Ask the booking agent anything, e.g. book G100", "options": []map[string]any{
+		{"type": 3, "name": "message", "description": "What you would like to do", "required": true},
+	}},
+	{"name": "reset", "description": "Start a new conversation with the booking agent"},
+}
+
+type bot struct {
+	publicKey ed25519.PublicKey
+	apiURL    string // Discord API base
+	client    *http.Client
+	sessions  *sessions
+}
+
+func (b *bot) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "cannot read body", http.StatusBadRequest)
+		return
+	}
+	sig, err := hex.DecodeString(r.Header.Get("X-Signature-Ed25519"))
+	if err != nil || !ed25519.Verify(b.publicKey, append([]byte(r.Header.Get("X-Signature-Timestamp")), body...), sig) {
+		http.Error(w, "invalid request signature", http.StatusUnauthorized)
+		return
+	}
+	var i interaction
+	if err := json.Unmarshal(body, &i); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	switch i.Type {
+	case interactionPing:
+		json.NewEncoder(w).Encode(map[string]int{"type": responsePong})
+	case interactionCommand:
+		flags := 0
+		if i.GuildID != "" {
+			flags = flagEphemeral
+		}
+		json.NewEncoder(w).Encode(map[string]any{"type": responseDeferred, "data": map[string]int{"flags": flags}})
+		go b.answer(&i)
+	default:
+		http.Error(w, "unsupported interaction type", http.StatusBadRequest)
+	}
+}
+
+// answer runs a command and edits the deferred reply with the result
+func (b *bot) answer(i *interaction) {
+	user := "discord-" + i.userID()
+	var text string
+	switch i.Data.Name {
+	case "trains":
+		text = "list trains"
+	case "search":
+		text = fmt.Sprintf("search trains from %s to %s", i.option("from"), i.option("to"))
+		if date := i.option("date"); date != "" {
+			text += " on " + date
+		}
+	case "mytickets":
+		text = "show my tickets"
+	case "ask":
+		text = i.option("message")
+	case "reset":
+		b.sessions.reset(user)
+		b.edit(i, message{Content: "👋 Your conversation with the booking agent starts afresh."})
+		return
+	default:
+		b.edit(i, message{Content: fmt.Sprintf("Unknown command /%s", i.Data.Name)})
+		return
+	}
+
+	t, err := b.sessions.send(user, text)
+	if err != nil {
+		log.Printf("❌ Agent for %s: %v", user, err)
+		b.edit(i, message{Embeds: []embed{{Title: "The booking agent is unavailable", Description: "Please try again in a moment.", Color: colorFailed}}})
+		return
+	}
+	b.edit(i, reply(t))
+}
+
+// reply lays a turn out: trains as an embed with a field each, other
+// replies as text
+func reply(t *turn) message {
+	summary := strings.TrimSpace(t.Summary)
+	if t.Error != "" {
+		summary = t.Error
+	}
+	if len(t.Trains) == 0 {
+		if t.Outcome == "failed" || t.Outcome == "unavailable" {
+			return message{Embeds: []embed{{Description: truncate(summary, 4096), Color: colorFailed}}}
+		}
+		return message{Content: truncate(summary, maxContent)}
+	}
+
+	title, _, _ := strings.Cut(summary, "\n")
+	e := embed{Title: truncate(strings.TrimSuffix(title, ":"), 256), Color: colorTrains}
+	for _, tr := range t.Trains[:min(len(t.Trains), maxEmbedFields)] {
+		name := fmt.Sprintf("%s · %s → %s", tr.ID, tr.From, tr.To)
+		if tr.Provider != "" {
+			name += fmt.Sprintf(" [%s]", tr.Provider)
+		}
+		e.Fields = append(e.Fields, embedField{
+			Name:   name,
+			Value:  fmt.Sprintf("%s %s–%s\n%d/%d seats left", tr.Date, tr.DepartureTime, tr.ArrivalTime, tr.Available, tr.TotalTickets),
+			Inline: true,
+		})
+	}
+	footer := "Use /ask to book, e.g. \"book the first one\""
+	if n := len(t.Trains); n > maxEmbedFields {
+		footer = fmt.Sprintf("%d more not shown. %s", n-maxEmbedFields, footer)
+	}
+	e.Footer = &embedFooter{Text: footer}
+	return message{Embeds: []embed{e}}
+}
+
+func truncate(s string, limit int) string {
+	if r := []rune(s); len(r) > limit {
+		return string(r[:limit-1]) + "…"
+	}
+	return s
+}
+
+// edit replaces the deferred reply to i
+func (b *bot) edit(i *interaction, m message) {
+	url := fmt.Sprintf("%s/webhooks/%s/%s/messages/@original", b.apiURL, i.ApplicationID, i.Token)
+	if err := b.call(http.MethodPatch, url, "", m); err != nil {
+		log.Printf("❌ Cannot reply to /%s: %v", i.Data.Name, err)
+	}
+}
+
+// register installs the slash commands for applicationID, usable in servers
+// and DMs
+func (b *bot) register(applicationID, token string) error {
+	for _, c := range commands {
+		c["contexts"] = []int{0, 1, 2}
+		c["integration_types"] = []int{0, 1}
+	}
+	return b.call(http.MethodPut, fmt.Sprintf("%s/applications/%s/commands", b.apiURL, applicationID), "Bot "+token, commands)
+}
+
+// call sends a JSON request to the Discord API
+func (b *bot) call(method, url, auth string, body any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("discord: %s %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
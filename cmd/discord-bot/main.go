@@ -0,0 +1,66 @@
+// Command discord-bot exposes the train booking agent in Discord. It serves
+// Discord's interactions endpoint: /trains, /search and /mytickets are quick
+// actions, /ask passes anything else to the agent, and /reset starts over.
+// Each Discord user has an agent process of their own, booking as
+// discord-<user ID>.
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+func main() {
+	listen := flag.String("listen", envOr("DISCORD_BOT_LISTEN", ":8091"), "interactions endpoint address (env DISCORD_BOT_LISTEN)")
+	agent := flag.String("agent", envOr("DISCORD_BOT_AGENT", "./bin/agent"), "agent binary (env DISCORD_BOT_AGENT)")
+	agentArgs := flag.String("agent-args", envOr("DISCORD_BOT_AGENT_ARGS", ""), `extra agent arguments, e.g. "--offline --server-url http://localhost:8080" (env DISCORD_BOT_AGENT_ARGS)`)
+	timeout := flag.Duration("turn-timeout", 90*time.Second, "give up on an agent reply after this long")
+	register := flag.Bool("register", false, "register the slash commands with Discord (needs DISCORD_APPLICATION_ID and DISCORD_BOT_TOKEN) and exit")
+	flag.Parse()
+
+	b := &bot{
+		apiURL: strings.TrimRight(envOr("DISCORD_API_URL", "https://discord.com/api/v10"), "/"),
+		client: &http.Client{Timeout: 15 * time.Second},
+	}
+	if *register {
+		appID, token := os.Getenv("DISCORD_APPLICATION_ID"), os.Getenv("DISCORD_BOT_TOKEN")
+		if appID == "" || token == "" {
+			fmt.Fprintln(os.Stderr, "❌ --register needs DISCORD_APPLICATION_ID and DISCORD_BOT_TOKEN")
+			os.Exit(2)
+		}
+		if err := b.register(appID, token); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Cannot register commands: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("✅ Registered %d commands\n", len(commands))
+		return
+	}
+
+	key, err := hex.DecodeString(os.Getenv("DISCORD_PUBLIC_KEY"))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		fmt.Fprintln(os.Stderr, "❌ DISCORD_PUBLIC_KEY must be the application's public key, in hex")
+		os.Exit(2)
+	}
+	b.publicKey = ed25519.PublicKey(key)
+	b.sessions = newSessions(*agent, strings.Fields(*agentArgs), *timeout)
+
+	mux := http.NewServeMux()
+	mux.Handle("/interactions", b)
+	log.Printf("🤖 Discord bot listening on %s/interactions, running %s", *listen, *agent)
+	server := &http.Server{Addr: *listen, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	log.Fatal(server.ListenAndServe())
+}
+
+func envOr(key, fallback string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sessions: every Discord user talks to an agent process of their own, run
+// with --output=json so each message written to its stdin answers with one
+// JSON line. The process keeps the conversation, so "book the first one"
+// refers to that user's last search. An idle process is stopped.
+
+// turn is the part of the agent's JSON output the bot reads
+type turn struct {
+	Intent  string  `json:"intent"`
+	Trains  []train `json:"trains"`
+	Summary string  `json:"summary"`
+	Outcome string  `json:"outcome"`
+	Error   string  `json:"error"`
+}
+
+type train struct {
+	ID            string `json:"id"`
+	From          string `json:"from"`
+	To            string `json:"to"`
+	Date          string `json:"date"`
+	DepartureTime string `json:"departure_time"`
+	ArrivalTime   string `json:"arrival_time"`
+	TotalTickets  int    `json:"total_tickets"`
+	Available     int    `json:"available"`
+	Provider      string `json:"provider"`
+}
+
+// session is one user's agent process
+type session struct {
+	mu       sync.Mutex
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	lines    chan []byte // JSON lines from stdout; closed when the process exits
+	lastUsed time.Time
+
+	stop    sync.Once
+	stopped chan struct{} // closed when the bot stops the process
+}
+
+type sessions struct {
+	agent   string   // agent binary
+	args    []string // extra agent arguments, e.g. --offline
+	timeout time.Duration
+
+	mu    sync.Mutex
+	users map[string]*session
+}
+
+func newSessions(agent string, args []string, timeout time.Duration) *sessions {
+	s := &sessions{agent: agent, args: args, timeout: timeout, users: map[string]*session{}}
+	go s.reapIdle()
+	return s
+}
+
+// start runs an agent booking as userID
+func (s *sessions) start(userID string) (*session, error) {
+	args := append([]string{"--output=json", "--user", userID, "--history-file", "off", "--transcript", ""}, s.args...)
+	cmd := exec.Command(s.agent, args...)
+	stderr := &tail{}
+	cmd.Stderr = stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	sess := &session{cmd: cmd, stdin: stdin, lines: make(chan []byte), lastUsed: time.Now(), stopped: make(chan struct{})}
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		scanner.Buffer(make([]byte, 64<<10), 4<<20)
+		for scanner.Scan() {
+			select {
+			case sess.lines <- append([]byte(nil), scanner.Bytes()...):
+			case <-sess.stopped:
+			}
+		}
+		close(sess.lines)
+		err := cmd.Wait()
+		select {
+		case <-sess.stopped:
+		default:
+			// Banners and prompts go to stderr too, so it is shown only now
+			log.Printf("⚠️  Agent for %s stopped: %v: %s", userID, err, stderr)
+		}
+	}()
+	log.Printf("🧵 Started agent for %s (pid %d)", userID, cmd.Process.Pid)
+	return sess, nil
+}
+
+// send runs message as a turn of userID's conversation
+func (s *sessions) send(userID, message string) (*turn, error) {
+	// Meta commands answer on stderr, so they are sent as plain text
+	message = strings.TrimLeft(strings.Join(strings.Fields(message), " "), ":/")
+	if message == "" {
+		return nil, fmt.Errorf("empty message")
+	}
+
+	s.mu.Lock()
+	sess, ok := s.users[userID]
+	if !ok {
+		var err error
+		if sess, err = s.start(userID); err != nil {
+			s.mu.Unlock()
+			return nil, err
+		}
+		s.users[userID] = sess
+	}
+	s.mu.Unlock()
+
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	sess.lastUsed = time.Now()
+	if _, err := fmt.Fprintln(sess.stdin, message); err != nil {
+		s.drop(userID, sess)
+		return nil, fmt.Errorf("agent stopped: %v", err)
+	}
+	select {
+	case line, ok := <-sess.lines:
+		if !ok {
+			s.drop(userID, sess)
+			return nil, fmt.Errorf("agent stopped")
+		}
+		var t turn
+		if err := json.Unmarshal(line, &t); err != nil {
+			return nil, fmt.Errorf("cannot decode agent output: %v", err)
+		}
+		return &t, nil
+	case <-time.After(s.timeout):
+		s.drop(userID, sess)
+		return nil, fmt.Errorf("agent did not answer within %s", s.timeout)
+	}
+}
+
+// tail keeps the end of what an agent wrote to stderr
+type tail struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (t *tail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > 2048 {
+		t.buf = t.buf[len(t.buf)-2048:]
+	}
+	return len(p), nil
+}
+
+func (t *tail) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return strings.TrimSpace(string(t.buf))
+}
+
+// reset ends userID's conversation
+func (s *sessions) reset(userID string) {
+	s.mu.Lock()
+	sess := s.users[userID]
+	s.mu.Unlock()
+	if sess != nil {
+		s.drop(userID, sess)
+	}
+}
+
+// drop stops sess and forgets it, unless userID has a newer one
+func (s *sessions) drop(userID string, sess *session) {
+	s.mu.Lock()
+	if s.users[userID] == sess {
+		delete(s.users, userID)
+	}
+	s.mu.Unlock()
+	sess.stop.Do(func() {
+		close(sess.stopped)
+		sess.stdin.Close()
+		sess.cmd.Process.Kill()
+	})
+}
+
+// reapIdle stops agents not used for half an hour
+func (s *sessions) reapIdle() {
+	for range time.Tick(time.Minute) {
+		s.mu.Lock()
+		var idle []string
+		for user, sess := range s.users {
+			if sess.mu.TryLock() {
+				if time.Since(sess.lastUsed) > 30*time.Minute {
+					idle = append(idle, user)
+				}
+				sess.mu.Unlock()
+			}
+		}
+		s.mu.Unlock()
+		for _, user := range idle {
+			log.Printf("🧵 Stopping idle agent for %s", user)
+			s.reset(user)
+		}
+	}
+}
@@ -0,0 +1,8 @@
+// Command server runs the train booking server; see internal/server
+package main
+
+import "github.com/zhangbiao2009/train-booking/internal/server"
+
+func main() {
+	server.Main()
+}
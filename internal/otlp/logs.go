@@ -0,0 +1,65 @@
+package otlp
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/log"
+)
+
+// Severity of a log record, as OTLP numbers them
+type Severity = log.Severity
+
+const (
+	SeverityDebug = log.SeverityDebug
+	SeverityInfo  = log.SeverityInfo
+	SeverityWarn  = log.SeverityWarn
+	SeverityError = log.SeverityError
+)
+
+// severityText names severity the way log lines do
+func severityText(s Severity) string {
+	switch {
+	case s >= SeverityError:
+		return "ERROR"
+	case s >= SeverityWarn:
+		return "WARN"
+	case s >= SeverityInfo:
+		return "INFO"
+	}
+	return "DEBUG"
+}
+
+// Log queues a log record, tied to the span in ctx if there is one
+func (e *Exporter) Log(ctx context.Context, t time.Time, severity Severity, body string, attrs ...Attr) {
+	if e == nil || e.logger == nil {
+		return
+	}
+	var record log.Record
+	record.SetTimestamp(t)
+	record.SetObservedTimestamp(time.Now())
+	record.SetSeverity(severity)
+	record.SetSeverityText(severityText(severity))
+	record.SetBody(log.StringValue(body))
+	for _, a := range attrs {
+		record.AddAttributes(logAttr(a))
+	}
+	e.logger.Emit(ctx, record)
+}
+
+// logAttr is a as the logs API holds attributes
+func logAttr(a Attr) log.KeyValue {
+	key := string(a.Key)
+	switch a.Value.Type() {
+	case attribute.BOOL:
+		return log.Bool(key, a.Value.AsBool())
+	case attribute.INT64:
+		return log.Int64(key, a.Value.AsInt64())
+	case attribute.FLOAT64:
+		return log.Float64(key, a.Value.AsFloat64())
+	case attribute.STRING:
+		return log.String(key, a.Value.AsString())
+	}
+	return log.String(key, a.Value.Emit())
+}
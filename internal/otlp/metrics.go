@@ -0,0 +1,90 @@
+package otlp
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Metrics are cumulative: each export reports the totals since the start
+
+// Counter is a monotonic sum, per attribute set
+type Counter struct {
+	counter metric.Int64Counter
+}
+
+// Counter registers a counter; nil when metrics are off
+func (e *Exporter) Counter(name, unit, description string) *Counter {
+	if e == nil || e.meter == nil {
+		return nil
+	}
+	c, err := e.meter.Int64Counter(name, metric.WithUnit(unit), metric.WithDescription(description))
+	if err != nil {
+		e.invalid(name, err)
+		return nil
+	}
+	return &Counter{c}
+}
+
+func (c *Counter) Add(n int64, attrs ...Attr) {
+	if c == nil {
+		return
+	}
+	c.counter.Add(context.Background(), n, metric.WithAttributes(attrs...))
+}
+
+// Histogram counts recorded values in buckets with the given upper bounds,
+// per attribute set
+type Histogram struct {
+	histogram metric.Float64Histogram
+}
+
+// Histogram registers a histogram; nil when metrics are off
+func (e *Exporter) Histogram(name, unit, description string, bounds []float64) *Histogram {
+	if e == nil || e.meter == nil {
+		return nil
+	}
+	h, err := e.meter.Float64Histogram(name, metric.WithUnit(unit), metric.WithDescription(description),
+		metric.WithExplicitBucketBoundaries(bounds...))
+	if err != nil {
+		e.invalid(name, err)
+		return nil
+	}
+	return &Histogram{h}
+}
+
+func (h *Histogram) Record(value float64, attrs ...Attr) {
+	if h == nil {
+		return
+	}
+	h.histogram.Record(context.Background(), value, metric.WithAttributes(attrs...))
+}
+
+// Observation is one value of a gauge
+type Observation struct {
+	Value float64
+	Attrs []Attr
+}
+
+// Gauge registers a gauge whose values observe reads at each export
+func (e *Exporter) Gauge(name, unit, description string, observe func() []Observation) {
+	if e == nil || e.meter == nil {
+		return
+	}
+	_, err := e.meter.Float64ObservableGauge(name, metric.WithUnit(unit), metric.WithDescription(description),
+		metric.WithFloat64Callback(func(_ context.Context, o metric.Float64Observer) error {
+			for _, obs := range observe() {
+				o.Observe(obs.Value, metric.WithAttributes(obs.Attrs...))
+			}
+			return nil
+		}))
+	if err != nil {
+		e.invalid(name, err)
+	}
+}
+
+// invalid reports an instrument the SDK would not register; it is left out
+func (e *Exporter) invalid(name string, err error) {
+	fmt.Fprintf(e.errors, "otlp: metric %s left out: %v\n", name, err)
+}
@@ -0,0 +1,244 @@
+// Package otlp exports traces, metrics and logs to an OpenTelemetry collector
+// with the OpenTelemetry SDK and its OTLP exporters, over gRPC or HTTP/protobuf
+// as OTEL_EXPORTER_OTLP_PROTOCOL says, configured by the standard OTEL_*
+// environment variables. It wraps what the server and the agent report -
+// spans with W3C trace context, counters, histograms, gauges and log records
+// - so that every method of a nil *Exporter, and of the spans and
+// instruments it hands out, does nothing, and callers need not check whether
+// export is on.
+package otlp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploggrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploghttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/log"
+	"go.opentelemetry.io/otel/metric"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Telemetry is reported under this instrumentation scope
+const instrumentationScope = "github.com/zhangbiao2009/train-booking"
+
+// Exporter holds the SDK providers of the signals that are on; each batches
+// its telemetry and sends it to the collector in the background
+type Exporter struct {
+	traces  *sdktrace.TracerProvider // nil when the signal is off
+	metrics *sdkmetric.MeterProvider
+	logs    *sdklog.LoggerProvider
+
+	tracer trace.Tracer
+	meter  metric.Meter
+	logger log.Logger
+
+	errors  io.Writer
+	mu      sync.Mutex
+	failing map[string]bool // signal -> last export failed
+}
+
+// FromEnv makes the exporter the OTEL_* variables describe, reporting as
+// service unless OTEL_SERVICE_NAME names another. It is nil, with no error,
+// when no OTLP endpoint is set or OTEL_SDK_DISABLED is true.
+func FromEnv(service string) (*Exporter, error) {
+	if strings.EqualFold(os.Getenv("OTEL_SDK_DISABLED"), "true") {
+		return nil, nil
+	}
+	traces, err := signalProtocol("TRACES")
+	if err != nil {
+		return nil, err
+	}
+	metrics, err := signalProtocol("METRICS")
+	if err != nil {
+		return nil, err
+	}
+	logs, err := signalProtocol("LOGS")
+	if err != nil {
+		return nil, err
+	}
+	if traces == "" && metrics == "" && logs == "" {
+		return nil, nil
+	}
+
+	ctx := context.Background()
+	// The variables, read last, override the default service name
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", service)),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("OTEL_RESOURCE_ATTRIBUTES: %w", err)
+	}
+
+	e := &Exporter{errors: os.Stderr, failing: map[string]bool{}}
+	// Failed exports are reported by watch; the SDK's other complaints go
+	// to stderr rather than through the log package, which may export them
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		fmt.Fprintf(e.errors, "otlp: %v\n", err)
+	}))
+
+	if traces != "" {
+		var exp sdktrace.SpanExporter
+		if traces == "grpc" {
+			exp, err = otlptracegrpc.New(ctx)
+		} else {
+			exp, err = otlptracehttp.New(ctx)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("OTLP traces exporter: %w", err)
+		}
+		e.traces = sdktrace.NewTracerProvider(
+			sdktrace.WithResource(res),
+			sdktrace.WithBatcher(spanExporter{exp, e.watch("traces")}),
+		)
+		e.tracer = e.traces.Tracer(instrumentationScope)
+	}
+	if metrics != "" {
+		var exp sdkmetric.Exporter
+		if metrics == "grpc" {
+			exp, err = otlpmetricgrpc.New(ctx)
+		} else {
+			exp, err = otlpmetrichttp.New(ctx)
+		}
+		if err != nil {
+			e.Shutdown(ctx)
+			return nil, fmt.Errorf("OTLP metrics exporter: %w", err)
+		}
+		e.metrics = sdkmetric.NewMeterProvider(
+			sdkmetric.WithResource(res),
+			sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter{exp, e.watch("metrics")})),
+		)
+		e.meter = e.metrics.Meter(instrumentationScope)
+	}
+	if logs != "" {
+		var exp sdklog.Exporter
+		if logs == "grpc" {
+			exp, err = otlploggrpc.New(ctx)
+		} else {
+			exp, err = otlploghttp.New(ctx)
+		}
+		if err != nil {
+			e.Shutdown(ctx)
+			return nil, fmt.Errorf("OTLP logs exporter: %w", err)
+		}
+		e.logs = sdklog.NewLoggerProvider(
+			sdklog.WithResource(res),
+			sdklog.WithProcessor(sdklog.NewBatchProcessor(logExporter{exp, e.watch("logs")})),
+		)
+		e.logger = e.logs.Logger(instrumentationScope)
+	}
+	return e, nil
+}
+
+// signalProtocol is how one signal is sent, grpc or http/protobuf, or ""
+// when it is off: when OTEL_<SIGNAL>_EXPORTER is none, or neither
+// OTEL_EXPORTER_OTLP_<SIGNAL>_ENDPOINT nor OTEL_EXPORTER_OTLP_ENDPOINT is set.
+// The exporters read the endpoints, headers and timeouts themselves.
+func signalProtocol(signal string) (string, error) {
+	switch exporter := os.Getenv("OTEL_" + signal + "_EXPORTER"); exporter {
+	case "", "otlp":
+	case "none":
+		return "", nil
+	default:
+		return "", fmt.Errorf("OTEL_%s_EXPORTER %q is not supported: want otlp or none", signal, exporter)
+	}
+	if os.Getenv("OTEL_EXPORTER_OTLP_"+signal+"_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" {
+		return "", nil
+	}
+	protocol := os.Getenv("OTEL_EXPORTER_OTLP_" + signal + "_PROTOCOL")
+	if protocol == "" {
+		protocol = os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL")
+	}
+	switch protocol {
+	case "", "http/protobuf":
+		return "http/protobuf", nil
+	case "grpc":
+		return "grpc", nil
+	}
+	return "", fmt.Errorf("OTLP protocol %q is not supported: use grpc or http/protobuf", protocol)
+}
+
+// Shutdown exports what is left, within ctx, and stops exporting
+func (e *Exporter) Shutdown(ctx context.Context) {
+	if e == nil {
+		return
+	}
+	if e.traces != nil {
+		e.traces.Shutdown(ctx)
+	}
+	if e.metrics != nil {
+		e.metrics.Shutdown(ctx)
+	}
+	if e.logs != nil {
+		e.logs.Shutdown(ctx)
+	}
+}
+
+// watch reports the outcome of each export of signal on the error writer
+// when exports start failing and when they recover. What failed is dropped
+// rather than handed back to the SDK, which would report every failure.
+func (e *Exporter) watch(signal string) func(error) error {
+	return func(err error) error {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		switch {
+		case err != nil && !e.failing[signal]:
+			fmt.Fprintf(e.errors, "otlp: exporting %s failed, dropping telemetry until it works again: %v\n", signal, err)
+		case err == nil && e.failing[signal]:
+			fmt.Fprintf(e.errors, "otlp: exporting %s works again\n", signal)
+		}
+		e.failing[signal] = err != nil
+		return nil
+	}
+}
+
+type spanExporter struct {
+	sdktrace.SpanExporter
+	watch func(error) error
+}
+
+func (x spanExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	return x.watch(x.SpanExporter.ExportSpans(ctx, spans))
+}
+
+type metricExporter struct {
+	sdkmetric.Exporter
+	watch func(error) error
+}
+
+func (x metricExporter) Export(ctx context.Context, rm *metricdata.ResourceMetrics) error {
+	return x.watch(x.Exporter.Export(ctx, rm))
+}
+
+type logExporter struct {
+	sdklog.Exporter
+	watch func(error) error
+}
+
+func (x logExporter) Export(ctx context.Context, records []sdklog.Record) error {
+	return x.watch(x.Exporter.Export(ctx, records))
+}
+
+// Attr is an attribute of a resource, span, data point or log record
+type Attr = attribute.KeyValue
+
+func String(key, value string) Attr        { return attribute.String(key, value) }
+func Int(key string, value int) Attr       { return attribute.Int(key, value) }
+func Bool(key string, value bool) Attr     { return attribute.Bool(key, value) }
+func Float(key string, value float64) Attr { return attribute.Float64(key, value) }
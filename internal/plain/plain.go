@@ -0,0 +1,83 @@
+// Package plain renders the emoji-decorated console output of the server and the
+// agent as clean ASCII for terminals, logs and scripts that cannot show it.
+package plain
+
+import (
+	"io"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// Detect reports whether the environment asks for plain output: NO_COLOR is set,
+// TERM is dumb, or the locale is not UTF-8.
+func Detect() bool {
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return true
+	}
+	for _, key := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		if locale := os.Getenv(key); locale != "" {
+			locale = strings.ToLower(locale)
+			return !strings.Contains(locale, "utf-8") && !strings.Contains(locale, "utf8")
+		}
+	}
+	return false
+}
+
+// Symbols that carry meaning get a word; decorative ones are dropped
+var replacer = strings.NewReplacer(
+	"❌ ", "Error: ",
+	"✅ ", "OK: ",
+	"⚠️  ", "Warning: ",
+	"⚠️ ", "Warning: ",
+	"💡 ", "Hint: ",
+	"🤔 ", "Question: ",
+	":bullettrain_side: ", "",
+	"→", "->",
+	"↑", "Up",
+	"↓", "Down",
+	"•", "-",
+	"·", "-",
+	"—", "-",
+	"…", "...",
+	"│", "|",
+	"┆", ":",
+	"▓", "#",
+	"░", ".",
+	"●", "*",
+)
+
+// String converts s to ASCII-friendly text. Letters outside ASCII, such as city
+// names, are kept; only symbols are replaced or removed.
+func String(s string) string {
+	s = replacer.Replace(s)
+
+	var b strings.Builder
+	dropSpace := false
+	for _, r := range s {
+		switch {
+		case unicode.Is(unicode.So, r) || r == '\ufe0f' || r == '\u200d':
+			// Emoji and their variation selectors, along with the space after them
+			dropSpace = true
+			continue
+		case dropSpace && r == ' ':
+			dropSpace = false
+			continue
+		}
+		dropSpace = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Writer converts everything written through it with String
+type Writer struct {
+	W io.Writer
+}
+
+func (w Writer) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.W, String(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Accessibility: a train sets aside accessible_seats of its tickets as
+// wheelchair-accessible seats. A booking made with accessible=true takes one
+// on every train booked, and is refused with 409 when a train has none left.
+// Other bookings and holds leave the accessible seats not yet taken alone.
+// The ticket is marked accessible on the receipt and the check-in manifest;
+// cancelling it with its booking_ref gives the seat back.
+
+// Places on a train taken by accessible seats
+const accessibleSpace = "accessible"
+
+// ErrNoAccessibleSeats refuses an accessible seat on a train with none left
+var ErrNoAccessibleSeats = errors.New("no wheelchair-accessible seats left")
+
+// wantsAccessible reads the accessible parameter of a booking request
+func wantsAccessible(r *http.Request) (bool, error) {
+	value := r.URL.Query().Get("accessible")
+	if value == "" {
+		return false, nil
+	}
+	accessible, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid accessible %q, expected true or false", value)
+	}
+	return accessible, nil
+}
+
+// takeAccessibleSeats takes an accessible seat on each of trainIDs before they
+// are booked. When one is refused, those already taken are given back.
+func (t *Tenant) takeAccessibleSeats(ctx context.Context, trainIDs []string) error {
+	var taken []string
+	for _, id := range trainIDs {
+		train, err := t.store.GetTrain(ctx, id)
+		if err == nil {
+			_, err = t.store.TakeSpace(ctx, train.ID, accessibleSpace, 1, train.AccessibleSeats)
+		}
+		if errors.Is(err, ErrSoldOut) {
+			err = fmt.Errorf("%s: %w", train.ID, ErrNoAccessibleSeats)
+		}
+		if err != nil {
+			t.releaseAccessibleSeats(ctx, taken)
+			return err
+		}
+		taken = append(taken, train.ID)
+	}
+	return nil
+}
+
+// checkOpenSeats refuses a ticket outside the accessible seats on trainIDs
+// whose only tickets left are accessible seats. Concurrent bookings may
+// overshoot, as with max_tickets_per_user. Unknown trains are left for the
+// booking to report.
+func (t *Tenant) checkOpenSeats(ctx context.Context, trainIDs []string) error {
+	for _, id := range trainIDs {
+		train, err := t.store.GetTrain(ctx, id)
+		if errors.Is(err, ErrTrainNotFound) || (err == nil && train.AccessibleSeats == 0) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		left, err := t.store.TakeSpace(ctx, train.ID, accessibleSpace, 0, train.AccessibleSeats)
+		if err != nil {
+			return err
+		}
+		if train.Available <= left {
+			return fmt.Errorf("%s: %w; the seats left are wheelchair-accessible", train.ID, ErrSoldOut)
+		}
+	}
+	return nil
+}
+
+// releaseAccessibleSeats gives back an accessible seat on each of trainIDs,
+// after a booking failed or a ticket was cancelled
+func (t *Tenant) releaseAccessibleSeats(ctx context.Context, trainIDs []string) {
+	for _, id := range trainIDs {
+		if _, err := t.store.TakeSpace(context.WithoutCancel(ctx), id, accessibleSpace, -1, 0); err != nil {
+			logf(ctx, "⚠️  [ACCESSIBLE] Cannot give back an accessible seat on %s: %v", id, err)
+		}
+	}
+}
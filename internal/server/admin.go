@@ -0,0 +1,54 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+)
+
+// adminAuthorized accepts the configured admin token or the access token of
+// an admin session (as a bearer token or the dashboard cookie), a verified
+// client certificate, or — when no token is configured — requests from
+// loopback only
+func adminAuthorized(r *http.Request) bool {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return true
+	}
+
+	if config.AdminToken != "" {
+		token, ok := presentedToken(r)
+		if !ok {
+			return false
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(config.AdminToken)) == 1 {
+			return true
+		}
+		ok, _ = adminSessions.check(token, clock())
+		return ok
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Admin middleware for /admin/ routes
+func adminOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !adminAuthorized(r) {
+			if token, ok := presentedToken(r); ok {
+				if _, expired := adminSessions.check(token, clock()); expired {
+					w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token", error_description="the access token expired"`)
+					http.Error(w, "admin session expired; refresh it", http.StatusUnauthorized)
+					return
+				}
+			}
+			http.Error(w, "admin authorization required", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Route demand analytics, kept per server instance in time buckets
+
+// Origin-destination pair; "*" stands for an unspecified end of a search
+type routeKey struct {
+	From string
+	To   string
+}
+
+type routeCounts struct {
+	Searches int `json:"searches"`
+	Bookings int `json:"bookings"`
+}
+
+type routeAnalytics struct {
+	mu         sync.Mutex
+	bucketSize time.Duration
+	retention  time.Duration
+	buckets    map[time.Time]map[routeKey]*routeCounts
+}
+
+func newRouteAnalytics(bucketSize, retention time.Duration) *routeAnalytics {
+	return &routeAnalytics{
+		bucketSize: bucketSize,
+		retention:  retention,
+		buckets:    map[time.Time]map[routeKey]*routeCounts{},
+	}
+}
+
+func normalizeCity(city string) string {
+	if city = strings.TrimSpace(city); city == "" {
+		return "*"
+	}
+	return strings.ToLower(city)
+}
+
+func (a *routeAnalytics) record(from, to string, now time.Time, update func(*routeCounts)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	start := now.UTC().Truncate(a.bucketSize)
+	bucket := a.buckets[start]
+	if bucket == nil {
+		bucket = map[routeKey]*routeCounts{}
+		a.buckets[start] = bucket
+
+		// Drop buckets that fell out of the retention window
+		for t := range a.buckets {
+			if now.Sub(t) > a.retention {
+				delete(a.buckets, t)
+			}
+		}
+	}
+
+	key := routeKey{normalizeCity(from), normalizeCity(to)}
+	if bucket[key] == nil {
+		bucket[key] = &routeCounts{}
+	}
+	update(bucket[key])
+}
+
+func (a *routeAnalytics) recordSearch(from, to string) {
+	a.record(from, to, clock(), func(c *routeCounts) { c.Searches++ })
+}
+
+func (a *routeAnalytics) recordBooking(train *Train) {
+	a.record(train.From, train.To, clock(), func(c *routeCounts) { c.Bookings++ })
+}
+
+// Analytics report structures
+type RouteBucket struct {
+	Start time.Time `json:"start"`
+	routeCounts
+}
+
+type RouteReport struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	routeCounts
+	Buckets []RouteBucket `json:"buckets"`
+}
+
+type RouteAnalyticsResponse struct {
+	BucketSize string        `json:"bucket_size"`
+	Since      time.Time     `json:"since"`
+	Routes     []RouteReport `json:"routes"`
+}
+
+// report aggregates buckets that started after since, busiest routes first
+func (a *routeAnalytics) report(since time.Time) RouteAnalyticsResponse {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	routes := map[routeKey]*RouteReport{}
+	for start, bucket := range a.buckets {
+		if start.Before(since.Truncate(a.bucketSize)) {
+			continue
+		}
+		for key, counts := range bucket {
+			report := routes[key]
+			if report == nil {
+				report = &RouteReport{From: key.From, To: key.To}
+				routes[key] = report
+			}
+			report.Searches += counts.Searches
+			report.Bookings += counts.Bookings
+			report.Buckets = append(report.Buckets, RouteBucket{Start: start, routeCounts: *counts})
+		}
+	}
+
+	resp := RouteAnalyticsResponse{
+		BucketSize: a.bucketSize.String(),
+		Since:      since.UTC(),
+		Routes:     []RouteReport{},
+	}
+	for _, report := range routes {
+		sort.Slice(report.Buckets, func(i, j int) bool { return report.Buckets[i].Start.Before(report.Buckets[j].Start) })
+		resp.Routes = append(resp.Routes, *report)
+	}
+	sort.Slice(resp.Routes, func(i, j int) bool {
+		ri, rj := resp.Routes[i], resp.Routes[j]
+		if ri.Bookings != rj.Bookings {
+			return ri.Bookings > rj.Bookings
+		}
+		if ri.Searches != rj.Searches {
+			return ri.Searches > rj.Searches
+		}
+		return ri.From+ri.To < rj.From+rj.To
+	})
+	return resp
+}
+
+func handleAnalyticsRoutes(w http.ResponseWriter, r *http.Request) {
+	window := 24 * time.Hour
+	if v := r.URL.Query().Get("window"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			http.Error(w, "window must be a positive duration such as 6h", http.StatusBadRequest)
+			return
+		}
+		window = d
+	}
+
+	json.NewEncoder(w).Encode(tenantOf(r).analytics.report(clock().Add(-window)))
+}
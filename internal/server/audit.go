@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Bookings on behalf of users: an operator, such as a call-center agent,
+// books or cancels for any user through /admin/book and /admin/cancel, which
+// take the parameters of /book and /cancel. Every such call is written to the
+// audit log with the operator who made it, as their client certificate or
+// admin session names them; a header alone is not taken at its word.
+
+const operatorHeader = "X-Admin-Operator"
+
+// Operator names are logged as given, so they are kept short and plain
+var operatorPattern = regexp.MustCompile(`^[A-Za-z0-9._@-]{1,64}$`)
+
+// AuditEntry is one action an operator took for a user
+type AuditEntry struct {
+	Time       time.Time `json:"time"`
+	Tenant     string    `json:"tenant,omitempty"`
+	Operator   string    `json:"operator"`
+	Action     string    `json:"action"` // "book" or "cancel"
+	UserID     string    `json:"user_id"`
+	TrainID    string    `json:"train_id"`
+	BookingRef string    `json:"booking_ref,omitempty"`
+	Status     int       `json:"status"`
+	RequestID  string    `json:"request_id,omitempty"`
+}
+
+// auditLog appends entries to TRAIN_SERVER_AUDIT_LOG as JSON lines; they are
+// in the server log either way
+type auditLog struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+var audit auditLog
+
+func setupAudit(path string) error {
+	if path == "" {
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	audit.file = f
+	return nil
+}
+
+func (a *auditLog) record(ctx context.Context, entry AuditEntry) {
+	userID := entry.UserID
+	if !config.LogPII {
+		userID = redactValue(userID)
+	}
+	logf(ctx, "🛂 [AUDIT] %s %s for %s by %s: %d %s", entry.Action, entry.TrainID, userID, entry.Operator, entry.Status, entry.BookingRef)
+
+	if a.file == nil {
+		return
+	}
+	line, _ := json.Marshal(entry)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := fmt.Fprintf(a.file, "%s\n", line); err != nil {
+		log.Printf("⚠️  [AUDIT] Cannot write the audit log: %v", err)
+	}
+}
+
+// operatorOf names the admin making r: the subject of its client
+// certificate, or else the operator its admin session was opened for; "" if
+// neither
+func operatorOf(r *http.Request) string {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		if name := r.TLS.VerifiedChains[0][0].Subject.CommonName; name != "" {
+			return name
+		}
+	}
+	if token, ok := presentedToken(r); ok {
+		return adminSessions.operator(token, clock())
+	}
+	return ""
+}
+
+type auditEntryKey struct{}
+
+// onBehalf runs a user's booking handler for an operator, auditing the call
+// even if the handler panics
+func onBehalf(action string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		operator := operatorOf(r)
+		if operator == "" {
+			http.Error(w, "acting for users needs an operator: open an admin session with POST /admin/auth/token and an "+operatorHeader+" header, or use a client certificate", http.StatusForbidden)
+			return
+		}
+		entry := &AuditEntry{
+			Time:       clock(),
+			Tenant:     tenantOf(r).ID,
+			Operator:   operator,
+			Action:     action,
+			UserID:     r.URL.Query().Get("user_id"),
+			TrainID:    canonicalTrainID(r.URL.Query().Get("id")),
+			BookingRef: r.URL.Query().Get("booking_ref"),
+			RequestID:  requestID(r.Context()),
+		}
+		rw := &headerWriter{ResponseWriter: w}
+		// A panic is audited as the server error it becomes in the recovery
+		// middleware, which it goes on to
+		completed := false
+		defer func() {
+			entry.Status = rw.status()
+			if !completed {
+				entry.Status = http.StatusInternalServerError
+			}
+			audit.record(r.Context(), *entry)
+		}()
+		handler(rw, r.WithContext(context.WithValue(r.Context(), auditEntryKey{}, entry)))
+		completed = true
+	}
+}
+
+// bookedBy is the operator booking for the user in the request ctx belongs
+// to, or "" when users book for themselves
+func bookedBy(ctx context.Context) string {
+	if entry, _ := ctx.Value(auditEntryKey{}).(*AuditEntry); entry != nil {
+		return entry.Operator
+	}
+	return ""
+}
+
+// noteAudit adds the reference of a booking an operator made to its audit
+// entry
+func noteAudit(ctx context.Context, ref string) {
+	if entry, _ := ctx.Value(auditEntryKey{}).(*AuditEntry); entry != nil {
+		entry.BookingRef = ref
+	}
+}
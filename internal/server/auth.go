@@ -0,0 +1,266 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Admin sessions: rather than keep the admin token itself, the dashboard and
+// API clients trade it for a session, an access token that expires after
+// TRAIN_SERVER_ADMIN_SESSION_TTL and a refresh token good for
+// TRAIN_SERVER_ADMIN_REFRESH_TTL. Refreshing swaps both for new ones, so a
+// refresh token works once; logging out revokes the session. An expired
+// access token is answered with 401 and a WWW-Authenticate header saying so,
+// which tells a client to refresh rather than sign in again. A session opened
+// by an operator is bound to them for its life, refreshes included, and
+// /admin/book and /admin/cancel audit their calls under that name. Sessions
+// are kept in memory per server instance.
+
+// Cookies of a dashboard session; the refresh cookie is only sent to the
+// /admin/auth/ routes
+const (
+	adminCookieName        = "admin_session"
+	adminRefreshCookieName = "admin_refresh"
+)
+
+// AdminSession is a pair of session tokens, as handed to a client
+type AdminSession struct {
+	AccessToken      string `json:"access_token"`
+	RefreshToken     string `json:"refresh_token"`
+	ExpiresIn        int    `json:"expires_in"`         // seconds the access token is good for
+	RefreshExpiresIn int    `json:"refresh_expires_in"` // seconds the refresh token is good for
+	Operator         string `json:"operator,omitempty"` // whom the session acts as
+}
+
+type adminSession struct {
+	access, refresh               string // digests of the tokens
+	accessExpires, refreshExpires time.Time
+	operator                      string
+}
+
+// adminSessionStore keeps sessions by the digests of their tokens, so a
+// leaked copy of the store grants nothing
+type adminSessionStore struct {
+	mu        sync.Mutex
+	byAccess  map[string]*adminSession
+	byRefresh map[string]*adminSession
+}
+
+var adminSessions = &adminSessionStore{byAccess: map[string]*adminSession{}, byRefresh: map[string]*adminSession{}}
+
+func newSessionToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func tokenDigest(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// start opens a session for operator, who may be "" for a dashboard
+func (s *adminSessionStore) start(now time.Time, operator string) AdminSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.startLocked(now, operator)
+}
+
+func (s *adminSessionStore) startLocked(now time.Time, operator string) AdminSession {
+	for digest, session := range s.byRefresh {
+		if now.After(session.refreshExpires) {
+			delete(s.byRefresh, digest)
+			delete(s.byAccess, session.access)
+		}
+	}
+	tokens := AdminSession{
+		AccessToken:      newSessionToken(),
+		RefreshToken:     newSessionToken(),
+		ExpiresIn:        int(config.AdminSessionTTL.Seconds()),
+		RefreshExpiresIn: int(config.AdminRefreshTTL.Seconds()),
+		Operator:         operator,
+	}
+	session := &adminSession{
+		access:         tokenDigest(tokens.AccessToken),
+		refresh:        tokenDigest(tokens.RefreshToken),
+		accessExpires:  now.Add(config.AdminSessionTTL),
+		refreshExpires: now.Add(config.AdminRefreshTTL),
+		operator:       operator,
+	}
+	s.byAccess[session.access] = session
+	s.byRefresh[session.refresh] = session
+	return tokens
+}
+
+// check tells whether access is the token of a session, and whether it has
+// expired
+func (s *adminSessionStore) check(access string, now time.Time) (ok, expired bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session := s.byAccess[tokenDigest(access)]
+	if session == nil {
+		return false, false
+	}
+	if now.After(session.accessExpires) {
+		return false, true
+	}
+	return true, false
+}
+
+// operator is whom the live session of access acts as; "" for an unknown or
+// expired token, or a session opened without an operator
+func (s *adminSessionStore) operator(access string, now time.Time) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session := s.byAccess[tokenDigest(access)]
+	if session == nil || now.After(session.accessExpires) {
+		return ""
+	}
+	return session.operator
+}
+
+// refresh ends the session of refresh and opens a new one in its place; ok
+// is false for a refresh token that is unknown, used or expired
+func (s *adminSessionStore) refresh(refresh string, now time.Time) (tokens AdminSession, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session := s.byRefresh[tokenDigest(refresh)]
+	if session == nil {
+		return AdminSession{}, false
+	}
+	delete(s.byRefresh, session.refresh)
+	delete(s.byAccess, session.access)
+	if now.After(session.refreshExpires) {
+		return AdminSession{}, false
+	}
+	return s.startLocked(now, session.operator), true
+}
+
+// revoke ends the session of an access or refresh token
+func (s *adminSessionStore) revoke(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	digest := tokenDigest(token)
+	session := s.byAccess[digest]
+	if session == nil {
+		session = s.byRefresh[digest]
+	}
+	if session != nil {
+		delete(s.byAccess, session.access)
+		delete(s.byRefresh, session.refresh)
+	}
+}
+
+// setSessionCookies hands a dashboard its session, or clears it. The access
+// cookie outlives its token, so an expired one is answered as such and the
+// dashboard refreshes.
+func setSessionCookies(w http.ResponseWriter, r *http.Request, tokens AdminSession) {
+	maxAge := tokens.RefreshExpiresIn
+	if tokens.AccessToken == "" {
+		maxAge = -1
+	}
+	cookie := func(name, value, path string) {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Value:    value,
+			Path:     path,
+			MaxAge:   maxAge,
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+	// Under /v1/admin/auth or the legacy /admin/auth, as the request came
+	prefix, _, _ := strings.Cut(r.URL.Path, "/admin/")
+	cookie(adminCookieName, tokens.AccessToken, "/")
+	cookie(adminRefreshCookieName, tokens.RefreshToken, prefix+"/admin/auth")
+}
+
+// presentedToken is the session or admin token r carries, as a bearer token
+// or the dashboard cookie
+func presentedToken(r *http.Request) (string, bool) {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return token, true
+	}
+	if cookie, err := r.Cookie(adminCookieName); err == nil {
+		return cookie.Value, true
+	}
+	return "", false
+}
+
+// handleAdminToken opens a session for an API client holding admin
+// authorization, bound to the operator named by its client certificate or
+// session, or else by its X-Admin-Operator header
+func handleAdminToken(w http.ResponseWriter, r *http.Request) {
+	operator := operatorOf(r)
+	if name := r.Header.Get(operatorHeader); operator == "" && name != "" {
+		if !operatorPattern.MatchString(name) {
+			http.Error(w, operatorHeader+" must be 1 to 64 letters, digits, '.', '_', '@' or '-'", http.StatusBadRequest)
+			return
+		}
+		operator = name
+	}
+	json.NewEncoder(w).Encode(adminSessions.start(clock(), operator))
+}
+
+// handleAdminRefresh swaps the refresh token in the body for a new session,
+// or the dashboard's refresh cookie for new cookies
+func handleAdminRefresh(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	fromCookie := false
+	if cookie, err := r.Cookie(adminRefreshCookieName); err == nil {
+		req.RefreshToken, fromCookie = cookie.Value, true
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		http.Error(w, "refresh_token is required", http.StatusBadRequest)
+		return
+	}
+
+	tokens, ok := adminSessions.refresh(req.RefreshToken, clock())
+	if !ok {
+		if fromCookie {
+			setSessionCookies(w, r, AdminSession{})
+		}
+		http.Error(w, "refresh token is invalid or expired; sign in again", http.StatusUnauthorized)
+		return
+	}
+	if fromCookie {
+		// The tokens stay out of reach of the page's scripts
+		setSessionCookies(w, r, tokens)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// handleAdminLogout revokes the session of the token presented, and of the
+// refresh token in the body if there is one
+func handleAdminLogout(w http.ResponseWriter, r *http.Request) {
+	if token, ok := presentedToken(r); ok {
+		adminSessions.revoke(token)
+	}
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if cookie, err := r.Cookie(adminRefreshCookieName); err == nil {
+		req.RefreshToken = cookie.Value
+	} else {
+		json.NewDecoder(r.Body).Decode(&req)
+	}
+	if req.RefreshToken != "" {
+		adminSessions.revoke(req.RefreshToken)
+	}
+	setSessionCookies(w, r, AdminSession{})
+	w.WriteHeader(http.StatusNoContent)
+}
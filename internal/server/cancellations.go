@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Cancellation record errors
+var (
+	ErrCancellationNotFound = errors.New("cancelled booking not found")
+	ErrAlreadyRebooked      = errors.New("cancelled booking was already rebooked")
+)
+
+// Cancellation record statuses
+const (
+	StatusCancelled = "cancelled"
+	StatusRebooked  = "rebooked"
+)
+
+// CancelledBooking records one cancelled ticket, kept for refunds, history and
+// rebooking until TRAIN_SERVER_CANCELLED_RETENTION has passed
+type CancelledBooking struct {
+	ID          string     `json:"cancellation_id"`
+	TrainID     string     `json:"train_id"`
+	UserID      string     `json:"user_id"`
+	Status      string     `json:"status"`
+	CancelledAt time.Time  `json:"cancelled_at"`
+	RebookedAt  *time.Time `json:"rebooked_at,omitempty"`
+}
+
+func newCancellationID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// purgeCancelled deletes cancellation records older than
+// TRAIN_SERVER_CANCELLED_RETENTION; a scheduled job
+func purgeCancelled(ctx context.Context) (string, error) {
+	if config.CancelledRetention <= 0 {
+		return "retention is unlimited", nil
+	}
+	total := 0
+	var errs []error
+	for _, t := range tenantList {
+		purged, err := t.store.PurgeCancelled(ctx, clock().Add(-config.CancelledRetention))
+		if err != nil {
+			errs = append(errs, err)
+		}
+		total += purged
+	}
+	if total == 0 {
+		return "", errors.Join(errs...)
+	}
+	return fmt.Sprintf("deleted %d cancellation record(s) past retention", total), errors.Join(errs...)
+}
+
+func handleUserCancellations(w http.ResponseWriter, r *http.Request) {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "user_id parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	records, err := tenantOf(r).store.CancelledBookings(r.Context(), userID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if records == nil {
+		records = []CancelledBooking{}
+	}
+	json.NewEncoder(w).Encode(records)
+}
+
+func handleRebook(w http.ResponseWriter, r *http.Request) {
+	cancellationID := r.URL.Query().Get("cancellation_id")
+	userID := r.URL.Query().Get("user_id")
+
+	// Validate required parameters
+	if userID == "" {
+		http.Error(w, "user_id parameter is required", http.StatusBadRequest)
+		return
+	}
+	if cancellationID == "" {
+		http.Error(w, "cancellation_id parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	train, err := tenantOf(r).store.Rebook(r.Context(), cancellationID, userID, clock())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	events.emit(tenantOf(r).ID, EventBookingConfirmed, train.ID, userID, train.Available)
+	if train.Available == 0 {
+		events.emit(tenantOf(r).ID, EventTrainSoldOut, train.ID, "", 0)
+	}
+	tenantOf(r).analytics.recordBooking(train)
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"message":     "booked successfully",
+		"train_id":    train.ID,
+		"booking_ref": issueReceipt(r, userID, []*Train{train}, ticketOptions{}),
+	})
+}
@@ -0,0 +1,282 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Challenges: with TRAIN_SERVER_VELOCITY_ACTION=verify, a user or client
+// flagged for booking too fast is refused with a challenge, which it answers
+// out of band before its booking proceeds. The booking is sent again with the
+// challenge's ID and the answer in the X-Challenge-ID and X-Challenge-Answer
+// headers: a right answer lifts the flag and the booking goes ahead, a wrong
+// one is refused again, with a new challenge after challengeAttempts tries.
+// TRAIN_SERVER_CHALLENGE picks who poses them: "math", a sum to work out;
+// "otp", a mock one-time code written to the server log as if it were texted;
+// or the URL of a challenge service.
+
+const (
+	challengeIDHeader     = "X-Challenge-ID"
+	challengeAnswerHeader = "X-Challenge-Answer"
+)
+
+// A challenge can be answered for challengeTTL, with challengeAttempts tries
+const (
+	challengeTTL      = 5 * time.Minute
+	challengeAttempts = 3
+)
+
+// Challenger poses challenges and checks their answers
+type Challenger interface {
+	// Pose poses a challenge to userID, who may be unknown, and returns what
+	// to ask
+	Pose(ctx context.Context, userID string) (Challenge, error)
+	// Check tells whether answer is right for the challenge with id
+	Check(ctx context.Context, id, answer string) (bool, error)
+}
+
+// Challenge is what a flagged user or client is asked before booking again
+type Challenge struct {
+	ID     string `json:"id"`
+	Prompt string `json:"prompt"` // e.g. "What is 7 + 12?"
+}
+
+var challenger Challenger
+
+// setupChallenger reads TRAIN_SERVER_CHALLENGE: math, otp, or the http(s)
+// URL of a challenge service
+func setupChallenger(spec string) error {
+	switch spec {
+	case "math":
+		challenger = &localChallenger{pose: poseSum, answers: map[string]localAnswer{}}
+		return nil
+	case "otp":
+		challenger = &localChallenger{pose: poseOneTimeCode, answers: map[string]localAnswer{}}
+		return nil
+	}
+	u, err := url.Parse(spec)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q: want math, otp or an http(s) URL", spec)
+	}
+	challenger = &serviceChallenger{
+		baseURL: strings.TrimSuffix(spec, "/"),
+		client:  &http.Client{Timeout: 5 * time.Second},
+	}
+	return nil
+}
+
+func newChallengeID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// randomInt is a random number in [low, high]
+func randomInt(low, high int64) int64 {
+	n, _ := rand.Int(rand.Reader, big.NewInt(high-low+1))
+	return low + n.Int64()
+}
+
+// localChallenger poses challenges whose answers it keeps in memory
+type localChallenger struct {
+	pose func(ctx context.Context, userID string) (prompt, answer string)
+
+	mu      sync.Mutex
+	answers map[string]localAnswer // by challenge ID
+}
+
+type localAnswer struct {
+	answer  string
+	expires time.Time
+}
+
+func (c *localChallenger) Pose(ctx context.Context, userID string) (Challenge, error) {
+	prompt, answer := c.pose(ctx, userID)
+	challenge := Challenge{ID: newChallengeID(), Prompt: prompt}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := clock()
+	for id, a := range c.answers {
+		if now.After(a.expires) {
+			delete(c.answers, id)
+		}
+	}
+	c.answers[challenge.ID] = localAnswer{answer: answer, expires: now.Add(challengeTTL)}
+	return challenge, nil
+}
+
+func (c *localChallenger) Check(ctx context.Context, id, answer string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	want, ok := c.answers[id]
+	if !ok || clock().After(want.expires) {
+		return false, nil
+	}
+	answer = strings.TrimSpace(answer)
+	if subtle.ConstantTimeCompare([]byte(answer), []byte(want.answer)) != 1 {
+		return false, nil
+	}
+	delete(c.answers, id)
+	return true, nil
+}
+
+// poseSum asks for the sum of two small numbers
+func poseSum(ctx context.Context, userID string) (prompt, answer string) {
+	a, b := randomInt(2, 20), randomInt(2, 20)
+	return fmt.Sprintf("What is %d + %d?", a, b), fmt.Sprint(a + b)
+}
+
+// poseOneTimeCode asks for a six-digit code. Delivery is mocked: the code is
+// written to the server log instead of being texted to the user.
+func poseOneTimeCode(ctx context.Context, userID string) (prompt, answer string) {
+	code := fmt.Sprintf("%06d", randomInt(0, 999999))
+	if !config.LogPII {
+		userID = redactValue(userID)
+	}
+	logf(ctx, "📲 [CHALLENGE] One-time code for user %s: %s (mock delivery)", userID, code)
+	return "Enter the 6-digit code we sent you.", code
+}
+
+// serviceChallenger has a challenge service pose and check challenges:
+// POST {url}/challenges with {"user_id"} answers a Challenge, and POST
+// {url}/challenges/{id}/check with {"answer"} answers {"ok": true|false}
+type serviceChallenger struct {
+	baseURL string
+	client  *http.Client
+}
+
+func (c *serviceChallenger) post(ctx context.Context, path string, body, v any) error {
+	payload, _ := json.Marshal(body)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("challenge service answered %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (c *serviceChallenger) Pose(ctx context.Context, userID string) (Challenge, error) {
+	var challenge Challenge
+	if err := c.post(ctx, "/challenges", map[string]string{"user_id": userID}, &challenge); err != nil {
+		return Challenge{}, err
+	}
+	if challenge.ID == "" || challenge.Prompt == "" {
+		return Challenge{}, fmt.Errorf("challenge service answered no challenge")
+	}
+	return challenge, nil
+}
+
+func (c *serviceChallenger) Check(ctx context.Context, id, answer string) (bool, error) {
+	var checked struct {
+		OK bool `json:"ok"`
+	}
+	err := c.post(ctx, "/challenges/"+url.PathEscape(id)+"/check", map[string]string{"answer": answer}, &checked)
+	return checked.OK, err
+}
+
+// posedChallenge is a challenge the velocity gate posed, and to whom
+type posedChallenge struct {
+	Challenge
+	userID   string
+	client   string
+	expires  time.Time
+	attempts int
+}
+
+// challenge poses a challenge to the flagged userID and client; nil when
+// none can be posed
+func (v *velocityTracker) challenge(ctx context.Context, userID, client string) *Challenge {
+	c, err := challenger.Pose(ctx, userID)
+	if err != nil {
+		logf(ctx, "⚠️  [CHALLENGE] Cannot pose a challenge: %v", err)
+		return nil
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	now := clock()
+	for id, posed := range v.challenges {
+		if now.After(posed.expires) {
+			delete(v.challenges, id)
+		}
+	}
+	v.challenges[c.ID] = &posedChallenge{Challenge: c, userID: userID, client: client, expires: now.Add(challengeTTL)}
+	return &c
+}
+
+// answer checks the answer to challenge id, which must have been posed to
+// userID and client, and lifts their flags when it is right. A wrong answer
+// gets the challenge back while it has tries left.
+func (v *velocityTracker) answer(ctx context.Context, id, answer, userID, client string) (ok bool, again *Challenge) {
+	v.mu.Lock()
+	posed := v.challenges[id]
+	if posed == nil || posed.userID != userID || posed.client != client || clock().After(posed.expires) {
+		v.mu.Unlock()
+		return false, nil
+	}
+	posed.attempts++
+	if posed.attempts < challengeAttempts {
+		again = &posed.Challenge
+	} else {
+		delete(v.challenges, id)
+	}
+	v.mu.Unlock()
+
+	ok, err := challenger.Check(ctx, id, answer)
+	if err != nil {
+		logf(ctx, "⚠️  [CHALLENGE] Cannot check an answer: %v", err)
+		return false, again
+	}
+	event := SecurityEvent{Kind: "challenge_failed", Subject: "user", UserID: userID, Client: client, Action: v.action, Until: posed.expires}
+	if ok {
+		v.mu.Lock()
+		delete(v.challenges, id)
+		v.mu.Unlock()
+		v.clear(userID, client)
+		event.Kind, event.Until, again = "challenge_passed", clock(), nil
+	}
+	if userID == "" {
+		event.Subject = "client"
+	}
+	v.note(ctx, event)
+	return ok, again
+}
+
+// verified lets a request from a flagged userID through when it answers the
+// challenge posed to it rightly, and otherwise refuses it with a challenge
+func (v *velocityTracker) verified(w http.ResponseWriter, r *http.Request, userID string, refused *VelocityError) bool {
+	client := clientAddr(r)
+	if id := r.Header.Get(challengeIDHeader); id != "" {
+		ok, again := v.answer(r.Context(), id, r.Header.Get(challengeAnswerHeader), userID, client)
+		if ok {
+			return true
+		}
+		refused.WrongAnswer, refused.Challenge = true, again
+	}
+	if refused.Challenge == nil {
+		refused.Challenge = v.challenge(r.Context(), userID, client)
+	}
+	writeVelocityError(w, refused)
+	return false
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Check-in: staff boarding a train get its manifest, one entry per booked
+// ticket with what the passenger brings on board, from the booking receipts.
+// Tickets cancelled since, or whose user holds no ticket on the train any
+// more, are left out.
+
+// ManifestEntry is one passenger's ticket on the train
+type ManifestEntry struct {
+	BookingRef string    `json:"booking_ref"`
+	UserID     string    `json:"user_id"`
+	BookedAt   time.Time `json:"booked_at"`
+	Class      string    `json:"class"`
+	Seat       string    `json:"seat,omitempty"`     // chosen by the passenger; others are seated at check-in
+	Traveler   string    `json:"traveler,omitempty"` // the saved traveler the ticket is for; the user when empty
+	Type       string    `json:"passenger_type,omitempty"`
+	Accessible bool      `json:"accessible"`
+	Insured    bool      `json:"insured"`
+	Meals      []AddOn   `json:"meals,omitempty"`
+	Luggage    []AddOn   `json:"luggage,omitempty"`
+}
+
+// Manifest is the check-in data of one train
+type Manifest struct {
+	TrainID       string          `json:"train_id"`
+	Date          string          `json:"date"`
+	DepartureTime string          `json:"departure_time"`
+	Passengers    []ManifestEntry `json:"passengers"`
+	Luggage       map[string]int  `json:"luggage,omitempty"` // items on board by luggage option ID
+}
+
+// handleManifest serves a train's check-in manifest, as JSON or CSV
+func handleManifest(w http.ResponseWriter, r *http.Request) {
+	t := tenantOf(r)
+	ctx := r.Context()
+	train, err := t.store.GetTrain(ctx, canonicalTrainID(r.PathValue("id")))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	manifest := Manifest{TrainID: train.ID, Date: train.Date, DepartureTime: train.DepartureTime, Passengers: []ManifestEntry{}}
+	holds := map[string]int{} // user -> tickets held on the train, read once per user
+	err = t.store.EachReceipt(ctx, func(receipt Receipt) error {
+		// A booking for several travelers has a ticket on the train for each
+		items := unrefundedItems(&receipt, train.ID)
+		if len(items) == 0 {
+			return nil
+		}
+		if _, ok := holds[receipt.UserID]; !ok {
+			bookings, err := t.store.UserBookings(ctx, receipt.UserID)
+			if err != nil {
+				return err
+			}
+			holds[receipt.UserID] = 0
+			for _, b := range bookings {
+				if b.TrainID == train.ID {
+					holds[receipt.UserID] = b.Count
+				}
+			}
+		}
+		if holds[receipt.UserID] == 0 {
+			return nil
+		}
+
+		entry := ManifestEntry{
+			BookingRef: receipt.Ref,
+			UserID:     receipt.UserID,
+			BookedAt:   receipt.IssuedAt,
+		}
+		for _, a := range receipt.AddOns {
+			if a.TrainID != train.ID {
+				continue
+			}
+			switch a.Kind {
+			case AddOnInsurance:
+				entry.Insured = true
+			case AddOnMeal:
+				entry.Meals = append(entry.Meals, a)
+			case AddOnLuggage:
+				entry.Luggage = append(entry.Luggage, a)
+				if manifest.Luggage == nil {
+					manifest.Luggage = map[string]int{}
+				}
+				manifest.Luggage[a.Item] += a.Quantity
+			}
+		}
+		// Meals and luggage are the booking's, listed with its first ticket
+		for n, item := range items {
+			ticket := entry
+			if n > 0 {
+				ticket.Meals, ticket.Luggage = nil, nil
+			}
+			it := receipt.Items[item]
+			ticket.Class, ticket.Seat, ticket.Accessible = it.Class, it.Seat, it.Accessible
+			ticket.Traveler, ticket.Type = it.Traveler, it.PassengerType
+			manifest.Passengers = append(manifest.Passengers, ticket)
+		}
+		return nil
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	sort.Slice(manifest.Passengers, func(i, j int) bool {
+		return manifest.Passengers[i].BookedAt.Before(manifest.Passengers[j].BookedAt)
+	})
+
+	if !wantsCSV(r) {
+		json.NewEncoder(w).Encode(manifest)
+		return
+	}
+	cw := newCSVExport(w, "manifest-"+train.ID+".csv", []string{"booking_ref", "user_id", "booked_at", "traveler", "passenger_type", "class", "seat", "accessible", "insured", "meals", "luggage"})
+	for _, p := range manifest.Passengers {
+		cw.Write([]string{p.BookingRef, p.UserID, p.BookedAt.Format(time.RFC3339), p.Traveler, p.Type, p.Class, p.Seat,
+			strconv.FormatBool(p.Accessible), strconv.FormatBool(p.Insured), describeAddOns(p.Meals), describeAddOns(p.Luggage)})
+	}
+	cw.Flush()
+}
+
+// describeAddOns lists add-ons in a CSV cell, e.g. "2x Beef noodles; 1x Tea"
+func describeAddOns(addOns []AddOn) string {
+	var parts []string
+	for _, a := range addOns {
+		parts = append(parts, fmt.Sprintf("%dx %s", a.Quantity, a.Name))
+	}
+	return strings.Join(parts, "; ")
+}
@@ -0,0 +1,33 @@
+package server
+
+import "time"
+
+// The server clock: every time the server acts on - booking cutoffs, holds,
+// reminders, receipts, expiries, the demo timetable and live positions -
+// is read from clock, which TRAIN_SERVER_CLOCK can start at another instant,
+// e.g. to show a train under way or a booking past its cutoff. Elapsed times,
+// such as request latency, I/O deadlines and telemetry timestamps keep to the
+// wall clock.
+
+// clock is the server's current time
+var clock = time.Now
+
+// startClock sets the clock running from start; a zero start keeps the wall
+// clock
+func startClock(start time.Time) {
+	if start.IsZero() {
+		return
+	}
+	offset := time.Until(start)
+	clock = func() time.Time { return time.Now().Add(offset) }
+}
+
+// parseClock reads TRAIN_SERVER_CLOCK, an RFC 3339 time; empty or unreadable
+// is zero
+func parseClock(value string) time.Time {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// negotiateEncoding picks gzip or deflate from Accept-Encoding, preferring gzip
+func negotiateEncoding(header string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				continue
+			}
+		}
+		accepted[name] = true
+	}
+
+	switch {
+	case accepted["gzip"] || accepted["*"]:
+		return "gzip"
+	case accepted["deflate"]:
+		return "deflate"
+	default:
+		return ""
+	}
+}
+
+// compressWriter buffers the response until it reaches the size threshold and
+// only then switches to a compressed stream
+type compressWriter struct {
+	http.ResponseWriter
+	encoding   string
+	minSize    int
+	statusCode int
+	buf        bytes.Buffer
+	encoder    io.WriteCloser
+	committed  bool
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.statusCode == 0 {
+		cw.statusCode = code
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if cw.statusCode == 0 {
+		cw.statusCode = http.StatusOK
+	}
+	if cw.committed {
+		if cw.encoder != nil {
+			return cw.encoder.Write(b)
+		}
+		return cw.ResponseWriter.Write(b)
+	}
+
+	cw.buf.Write(b)
+	if cw.buf.Len() >= cw.minSize {
+		if err := cw.startCompression(); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+func (cw *compressWriter) startCompression() error {
+	cw.committed = true
+
+	h := cw.ResponseWriter.Header()
+	if h.Get("Content-Encoding") != "" {
+		// Handler already encoded the body
+		cw.ResponseWriter.WriteHeader(cw.statusCode)
+		_, err := cw.ResponseWriter.Write(cw.buf.Bytes())
+		return err
+	}
+
+	// Sniff the type from the plain body before it is replaced by compressed bytes
+	if h.Get("Content-Type") == "" {
+		h.Set("Content-Type", http.DetectContentType(cw.buf.Bytes()))
+	}
+	h.Set("Content-Encoding", cw.encoding)
+	h.Del("Content-Length")
+	cw.ResponseWriter.WriteHeader(cw.statusCode)
+
+	if cw.encoding == "gzip" {
+		cw.encoder = gzip.NewWriter(cw.ResponseWriter)
+	} else {
+		cw.encoder, _ = flate.NewWriter(cw.ResponseWriter, flate.DefaultCompression)
+	}
+	_, err := cw.encoder.Write(cw.buf.Bytes())
+	return err
+}
+
+// finish writes small responses as-is and terminates the compressed stream otherwise
+func (cw *compressWriter) finish() {
+	if cw.committed {
+		if cw.encoder != nil {
+			cw.encoder.Close()
+		}
+		return
+	}
+	if cw.statusCode != 0 {
+		cw.ResponseWriter.WriteHeader(cw.statusCode)
+	}
+	if cw.buf.Len() > 0 {
+		cw.ResponseWriter.Write(cw.buf.Bytes())
+	}
+}
+
+// Compression middleware; a negative minSize disables compression
+func compressionMiddleware(minSize int) middleware {
+	if minSize < 0 {
+		return nil
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+
+			encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+			if encoding == "" || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressWriter{ResponseWriter: w, encoding: encoding, minSize: minSize}
+			defer cw.finish()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
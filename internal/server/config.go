@@ -0,0 +1,306 @@
+package server
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zhangbiao2009/train-booking/internal/plain"
+)
+
+// Server configuration, read from TRAIN_SERVER_* environment variables
+type Config struct {
+	Addr string
+
+	// Dev mode validates requests and responses against the OpenAPI document
+	DevMode bool
+
+	// TLS; an empty cert and key serves plain HTTP
+	TLSCert       string
+	TLSKey        string
+	TLSClientCA   string
+	TLSClientAuth string // none, admin or all
+
+	// Deprecation and sunset dates announced on the unversioned routes; zero
+	// omits the header
+	LegacyDeprecated time.Time
+	LegacySunset     time.Time
+
+	// Instant the server clock starts at, for the booking cutoff, holds,
+	// reminders, live train positions and every other time the server acts
+	// on; zero uses the wall clock
+	Clock time.Time
+
+	// Request limits
+	MaxBodyBytes  int64
+	MaxQueryBytes int
+
+	// Requests a second each client address may make, in bursts of up to
+	// RateBurst; 0 disables rate limiting
+	RateLimit float64
+	RateBurst int
+
+	// Inventory store: memory (single replica), bolt:///path or redis://[:password@]host:port[/db]
+	Store string
+
+	// Write-ahead log making the memory store survive restarts; empty for
+	// none. It is compacted into a snapshot every WALCompactEvery records.
+	WAL             string
+	WALCompactEvery int
+
+	// JSON file of tenants, each with its own schedule, bookings, pricing and
+	// policies; empty serves a single tenant. TenantHeader names the tenant.
+	TenantsFile  string
+	TenantHeader string
+
+	// Bookings and cancellations close this long before a train departs;
+	// negative keeps them open
+	BookingCutoff time.Duration
+	// Meal pre-orders close this long before departure, unless a train's
+	// dining sets its own cutoff
+	MealCutoff time.Duration
+
+	// Seat holds; expired holds are released every HoldSweepInterval
+	HoldTTL           time.Duration
+	HoldSweepInterval time.Duration
+
+	// How long cancelled bookings are kept for refunds, history and rebooking;
+	// 0 keeps them forever
+	CancelledRetention     time.Duration
+	CancelledSweepInterval time.Duration
+
+	// How long a booking's Idempotency-Key is remembered; 0 ignores the header
+	IdempotencyTTL time.Duration
+	// How long a /tickets search token can be booked from; 0 issues none
+	SearchTokenTTL time.Duration
+
+	// Longest /query/wait long poll, and how often a waiting poll rechecks the
+	// store for changes made by other servers; 0 only sees this server's
+	MaxWait          time.Duration
+	WaitPollInterval time.Duration
+
+	// Notification delivery: workers, attempts per channel before a delivery is
+	// dead-lettered, and the first retry's backoff, doubled for each later one
+	NotifyWorkers      int
+	NotifyMaxAttempts  int
+	NotifyRetryBackoff time.Duration
+
+	// Email (host:port of an SMTP relay) and SMS (HTTP gateway taking
+	// {"to", "message"}) delivery; empty disables the channel
+	SMTPAddr     string
+	SMTPFrom     string
+	SMTPUsername string
+	SMTPPassword string
+	SMSGateway   string
+
+	// Schedules of the background jobs, "every <duration>", "daily HH:MM" or
+	// "off", and their settings
+	JobPurgeDeparted  string
+	DepartedRetention time.Duration // how long trains are kept after leaving
+	JobReminders      string
+	ReminderBefore    time.Duration // how long before departure passengers are reminded
+	JobDailyReport    string
+	ReportsDir        string // where daily reports are saved; empty only logs them
+
+	// How long a graceful shutdown waits for in-flight requests
+	ShutdownTimeout time.Duration
+
+	// Domain event publishing
+	EventsBroker      string // nats://host:4222, kafka-rest://host:8082, log, or empty to disable
+	EventsTopicBooked string
+	EventsTopicCancel string
+	EventsTopicSold   string
+
+	// Responses smaller than this are sent uncompressed; negative disables compression
+	CompressMinBytes int
+
+	// CORS; no origins disables CORS handling
+	CORSOrigins []string
+	CORSMethods string
+	CORSHeaders string
+	CORSMaxAge  int
+
+	// ASCII log output without emoji; also enabled by NO_COLOR or a non-UTF-8 locale
+	Plain bool
+
+	// Log user identifiers, documents and tokens unredacted; for local debugging only
+	LogPII bool
+
+	// Bearer token for /admin/ routes; empty allows loopback clients only
+	AdminToken string
+	// How long the access and refresh tokens of an admin session are good for
+	AdminSessionTTL time.Duration
+	AdminRefreshTTL time.Duration
+
+	// File the bookings operators make for users are appended to; the server
+	// log only when empty
+	AuditLog string
+
+	// Tickets one user may have on one train, unless a tenant sets its own
+	// limit; 0 for no limit
+	MaxTicketsPerTrain int
+
+	// Booking velocity: a user or client address booking more than
+	// VelocityMaxTickets tickets, or on more than VelocityMaxTrains trains,
+	// within VelocityWindow is throttled or, with the action "verify", must be
+	// verified, for VelocityPenalty; limits of 0 check nothing
+	VelocityWindow     time.Duration
+	VelocityMaxTickets int
+	VelocityMaxTrains  int
+	VelocityPenalty    time.Duration
+	VelocityAction     string
+
+	// Who poses the challenges of the action "verify": math, otp (a mock
+	// one-time code written to the log) or the URL of a challenge service
+	Challenge string
+
+	// Real-name ticketing for the single tenant; tenants set their own policy
+	RealName bool
+
+	// Format of each passenger document type, "type=pattern;...", checked
+	// on the travelers of a booking; "off" checks none
+	DocumentFormats string
+
+	// Route demand analytics
+	AnalyticsBucket    time.Duration
+	AnalyticsRetention time.Duration
+
+	// How long the actions of an agent conversation are kept after its last
+	SessionRetention time.Duration
+
+	// Address of the pprof and expvar diagnostics server; empty for none.
+	// One in MutexProfileFraction contended locks is sampled for the mutex
+	// profile; 0 samples none.
+	DiagnosticsAddr      string
+	MutexProfileFraction int
+}
+
+var config Config
+
+func loadConfig() Config {
+	return Config{
+		Addr:                   envOr("TRAIN_SERVER_ADDR", ":8080"),
+		DevMode:                envBool("TRAIN_SERVER_DEV_MODE", false),
+		TLSCert:                envOr("TRAIN_SERVER_TLS_CERT", ""),
+		TLSKey:                 envOr("TRAIN_SERVER_TLS_KEY", ""),
+		TLSClientCA:            envOr("TRAIN_SERVER_TLS_CLIENT_CA", ""),
+		TLSClientAuth:          envOr("TRAIN_SERVER_TLS_CLIENT_AUTH", clientAuthNone),
+		LegacyDeprecated:       parseDate(envOr("TRAIN_SERVER_LEGACY_DEPRECATED", "2026-10-16")),
+		LegacySunset:           parseDate(envOr("TRAIN_SERVER_LEGACY_SUNSET", "")),
+		Clock:                  parseClock(envOr("TRAIN_SERVER_CLOCK", "")),
+		MaxBodyBytes:           int64(envInt("TRAIN_SERVER_MAX_BODY_BYTES", 1<<20)),
+		MaxQueryBytes:          envInt("TRAIN_SERVER_MAX_QUERY_BYTES", 2048),
+		RateLimit:              envFloat("TRAIN_SERVER_RATE_LIMIT", 0),
+		RateBurst:              envInt("TRAIN_SERVER_RATE_BURST", 20),
+		Store:                  envOr("TRAIN_SERVER_STORE", "memory"),
+		WAL:                    envOr("TRAIN_SERVER_WAL", ""),
+		WALCompactEvery:        envInt("TRAIN_SERVER_WAL_COMPACT_EVERY", 10000),
+		TenantsFile:            envOr("TRAIN_SERVER_TENANTS", ""),
+		TenantHeader:           envOr("TRAIN_SERVER_TENANT_HEADER", "X-Tenant-ID"),
+		BookingCutoff:          envDuration("TRAIN_SERVER_BOOKING_CUTOFF", 5*time.Minute),
+		MealCutoff:             envDuration("TRAIN_SERVER_MEAL_CUTOFF", 2*time.Hour),
+		HoldTTL:                envDuration("TRAIN_SERVER_HOLD_TTL", 5*time.Minute),
+		HoldSweepInterval:      envDuration("TRAIN_SERVER_HOLD_SWEEP_INTERVAL", 10*time.Second),
+		CancelledRetention:     envDuration("TRAIN_SERVER_CANCELLED_RETENTION", 90*24*time.Hour),
+		CancelledSweepInterval: envDuration("TRAIN_SERVER_CANCELLED_SWEEP_INTERVAL", time.Hour),
+		IdempotencyTTL:         envDuration("TRAIN_SERVER_IDEMPOTENCY_TTL", 24*time.Hour),
+		SearchTokenTTL:         envDuration("TRAIN_SERVER_SEARCH_TOKEN_TTL", 30*time.Minute),
+		MaxWait:                envDuration("TRAIN_SERVER_MAX_WAIT", time.Minute),
+		WaitPollInterval:       envDuration("TRAIN_SERVER_WAIT_POLL_INTERVAL", 2*time.Second),
+		NotifyWorkers:          envInt("TRAIN_SERVER_NOTIFY_WORKERS", 4),
+		NotifyMaxAttempts:      envInt("TRAIN_SERVER_NOTIFY_MAX_ATTEMPTS", 5),
+		NotifyRetryBackoff:     envDuration("TRAIN_SERVER_NOTIFY_RETRY_BACKOFF", 10*time.Second),
+		SMTPAddr:               envOr("TRAIN_SERVER_SMTP_ADDR", ""),
+		SMTPFrom:               envOr("TRAIN_SERVER_SMTP_FROM", "trains@localhost"),
+		SMTPUsername:           envOr("TRAIN_SERVER_SMTP_USERNAME", ""),
+		SMTPPassword:           envOr("TRAIN_SERVER_SMTP_PASSWORD", ""),
+		SMSGateway:             envOr("TRAIN_SERVER_SMS_GATEWAY", ""),
+		JobPurgeDeparted:       envOr("TRAIN_SERVER_JOB_PURGE_DEPARTED", "off"),
+		DepartedRetention:      envDuration("TRAIN_SERVER_DEPARTED_RETENTION", 7*24*time.Hour),
+		JobReminders:           envOr("TRAIN_SERVER_JOB_DEPARTURE_REMINDERS", "every 5m"),
+		ReminderBefore:         envDuration("TRAIN_SERVER_REMINDER_BEFORE", 2*time.Hour),
+		JobDailyReport:         envOr("TRAIN_SERVER_JOB_DAILY_REPORT", "daily 00:05"),
+		ReportsDir:             envOr("TRAIN_SERVER_REPORTS_DIR", ""),
+		ShutdownTimeout:        envDuration("TRAIN_SERVER_SHUTDOWN_TIMEOUT", 15*time.Second),
+		EventsBroker:           envOr("TRAIN_SERVER_EVENTS_BROKER", ""),
+		EventsTopicBooked:      envOr("TRAIN_SERVER_EVENTS_TOPIC_BOOKED", "train.booking.confirmed"),
+		EventsTopicCancel:      envOr("TRAIN_SERVER_EVENTS_TOPIC_CANCELLED", "train.booking.cancelled"),
+		EventsTopicSold:        envOr("TRAIN_SERVER_EVENTS_TOPIC_SOLD_OUT", "train.sold_out"),
+		CompressMinBytes:       envInt("TRAIN_SERVER_COMPRESS_MIN_BYTES", 1024),
+		CORSOrigins:            envList("TRAIN_SERVER_CORS_ORIGINS"),
+		CORSMethods:            envOr("TRAIN_SERVER_CORS_METHODS", "GET, POST, OPTIONS"),
+		CORSHeaders:            envOr("TRAIN_SERVER_CORS_HEADERS", "Content-Type, Authorization, Idempotency-Key, X-Tenant-ID, X-Request-ID, X-Session-ID, X-Admin-Operator, X-Challenge-ID, X-Challenge-Answer"),
+		CORSMaxAge:             envInt("TRAIN_SERVER_CORS_MAX_AGE", 600),
+		Plain:                  envBool("TRAIN_SERVER_PLAIN", false) || plain.Detect(),
+		LogPII:                 envBool("TRAIN_SERVER_LOG_PII", false),
+		AdminToken:             envOr("TRAIN_SERVER_ADMIN_TOKEN", ""),
+		AdminSessionTTL:        envDuration("TRAIN_SERVER_ADMIN_SESSION_TTL", 15*time.Minute),
+		AdminRefreshTTL:        envDuration("TRAIN_SERVER_ADMIN_REFRESH_TTL", 24*time.Hour),
+		AuditLog:               envOr("TRAIN_SERVER_AUDIT_LOG", ""),
+		MaxTicketsPerTrain:     envInt("TRAIN_SERVER_MAX_TICKETS_PER_TRAIN", 0),
+		VelocityWindow:         envDuration("TRAIN_SERVER_VELOCITY_WINDOW", 10*time.Minute),
+		VelocityMaxTickets:     envInt("TRAIN_SERVER_VELOCITY_MAX_TICKETS", 0),
+		VelocityMaxTrains:      envInt("TRAIN_SERVER_VELOCITY_MAX_TRAINS", 0),
+		VelocityPenalty:        envDuration("TRAIN_SERVER_VELOCITY_PENALTY", 15*time.Minute),
+		VelocityAction:         envOr("TRAIN_SERVER_VELOCITY_ACTION", velocityThrottle),
+		Challenge:              envOr("TRAIN_SERVER_CHALLENGE", "math"),
+		RealName:               envBool("TRAIN_SERVER_REAL_NAME", false),
+		DocumentFormats:        envOr("TRAIN_SERVER_DOCUMENT_FORMATS", defaultDocumentFormats),
+		AnalyticsBucket:        envDuration("TRAIN_SERVER_ANALYTICS_BUCKET", time.Hour),
+		AnalyticsRetention:     envDuration("TRAIN_SERVER_ANALYTICS_RETENTION", 7*24*time.Hour),
+		SessionRetention:       envDuration("TRAIN_SERVER_SESSION_RETENTION", 7*24*time.Hour),
+		DiagnosticsAddr:        envOr("TRAIN_SERVER_DIAGNOSTICS_ADDR", ""),
+		MutexProfileFraction:   envInt("TRAIN_SERVER_MUTEX_PROFILE_FRACTION", 100),
+	}
+}
+
+func envOr(key, fallback string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	v, err := time.ParseDuration(envOr(key, ""))
+	if err != nil {
+		return fallback
+	}
+	return v
+}
+
+func envBool(key string, fallback bool) bool {
+	v, err := strconv.ParseBool(envOr(key, ""))
+	if err != nil {
+		return fallback
+	}
+	return v
+}
+
+func envInt(key string, fallback int) int {
+	v, err := strconv.Atoi(envOr(key, ""))
+	if err != nil {
+		return fallback
+	}
+	return v
+}
+
+func envFloat(key string, fallback float64) float64 {
+	v, err := strconv.ParseFloat(envOr(key, ""), 64)
+	if err != nil {
+		return fallback
+	}
+	return v
+}
+
+// envList splits a comma-separated variable, dropping empty entries
+func envList(key string) []string {
+	var values []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// CORS policy for browser-based frontends
+type corsPolicy struct {
+	origins []string // "*" allows any origin
+	methods string
+	headers string
+	maxAge  int
+}
+
+func (p corsPolicy) allowOrigin(origin string) bool {
+	return slices.Contains(p.origins, "*") || slices.ContainsFunc(p.origins, func(o string) bool {
+		return strings.EqualFold(o, origin)
+	})
+}
+
+// CORS middleware; passes requests through untouched when no origins are configured
+func corsMiddleware(policy corsPolicy) middleware {
+	if len(policy.origins) == 0 {
+		return nil
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Add("Vary", "Origin")
+			if !policy.allowOrigin(origin) {
+				// Let the browser block the response by omitting the CORS headers
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			// Let pages read the search token, to book from the results
+			w.Header().Set("Access-Control-Expose-Headers", searchTokenHeader)
+
+			// Preflight request
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Add("Vary", "Access-Control-Request-Method")
+				w.Header().Add("Vary", "Access-Control-Request-Headers")
+				w.Header().Set("Access-Control-Allow-Methods", policy.methods)
+				w.Header().Set("Access-Control-Allow-Headers", policy.headers)
+				if policy.maxAge > 0 {
+					w.Header().Set("Access-Control-Max-Age", strconv.Itoa(policy.maxAge))
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
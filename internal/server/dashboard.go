@@ -0,0 +1,165 @@
+package server
+
+import (
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Admin dashboard page; it polls the JSON endpoints below
+//
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+// Number of recent booking events and the request window shown on the dashboard
+const (
+	recentEventsLimit = 50
+	requestWindow     = 15 * time.Minute
+)
+
+// activityLog keeps the recent events and response status counts shown on the dashboard
+type activityLog struct {
+	mu       sync.Mutex
+	events   []Event
+	requests map[time.Time]*requestCounts // per-minute buckets
+}
+
+type requestCounts struct {
+	Total        int `json:"total"`
+	ClientErrors int `json:"client_errors"`
+	ServerErrors int `json:"server_errors"`
+}
+
+var activity = &activityLog{requests: map[time.Time]*requestCounts{}}
+
+func (a *activityLog) recordEvent(event Event) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.events = append(a.events, event)
+	if len(a.events) > recentEventsLimit {
+		a.events = a.events[len(a.events)-recentEventsLimit:]
+	}
+}
+
+func (a *activityLog) recordStatus(status int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := clock()
+	minute := now.Truncate(time.Minute)
+	counts := a.requests[minute]
+	if counts == nil {
+		counts = &requestCounts{}
+		a.requests[minute] = counts
+		for t := range a.requests {
+			if now.Sub(t) > requestWindow {
+				delete(a.requests, t)
+			}
+		}
+	}
+
+	counts.Total++
+	switch {
+	case status >= 500:
+		counts.ServerErrors++
+	case status >= 400:
+		counts.ClientErrors++
+	}
+}
+
+// Dashboard snapshot
+type DashboardData struct {
+	Trains       []*Train     `json:"trains"`
+	RecentEvents []Event      `json:"recent_events"`
+	Requests     RequestStats `json:"requests"`
+}
+
+type RequestStats struct {
+	Window string `json:"window"`
+	requestCounts
+	ErrorRate float64 `json:"error_rate"`
+}
+
+// snapshot returns the recent events of one tenant and the request stats
+func (a *activityLog) snapshot(tenant string) ([]Event, RequestStats) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// Newest first
+	recent := []Event{}
+	for i := len(a.events) - 1; i >= 0; i-- {
+		if a.events[i].Tenant == tenant {
+			recent = append(recent, a.events[i])
+		}
+	}
+
+	stats := RequestStats{Window: requestWindow.String()}
+	cutoff := clock().Add(-requestWindow)
+	for t, counts := range a.requests {
+		if t.Before(cutoff.Truncate(time.Minute)) {
+			continue
+		}
+		stats.Total += counts.Total
+		stats.ClientErrors += counts.ClientErrors
+		stats.ServerErrors += counts.ServerErrors
+	}
+	if stats.Total > 0 {
+		stats.ErrorRate = float64(stats.ClientErrors+stats.ServerErrors) / float64(stats.Total)
+	}
+	return recent, stats
+}
+
+// Shown instead of the dashboard to browsers without admin credentials
+const dashboardLoginHTML = `<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Train Booking Admin</title></head>
+<body style="font-family: sans-serif; margin: 3em">
+<h1>Train Booking Admin</h1>
+<form method="post" action="login">
+<label>Admin token <input type="password" name="token" autofocus></label>
+<button type="submit">Sign in</button>
+</form>
+</body></html>
+`
+
+// handleDashboard checks admin auth itself so unauthenticated browsers get a login form
+func handleDashboard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if !adminAuthorized(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(dashboardLoginHTML))
+		return
+	}
+	w.Write(dashboardHTML)
+}
+
+func handleDashboardData(w http.ResponseWriter, r *http.Request) {
+	trains, err := tenantOf(r).store.ListTrains(r.Context())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	recent, stats := activity.snapshot(tenantOf(r).ID)
+	json.NewEncoder(w).Encode(DashboardData{
+		Trains:       trains,
+		RecentEvents: recent,
+		Requests:     stats,
+	})
+}
+
+// handleDashboardLogin exchanges the admin token posted by the login form for
+// an admin session in cookies
+func handleDashboardLogin(w http.ResponseWriter, r *http.Request) {
+	token := r.PostFormValue("token")
+	if config.AdminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(config.AdminToken)) != 1 {
+		http.Error(w, "invalid admin token", http.StatusUnauthorized)
+		return
+	}
+
+	setSessionCookies(w, r, adminSessions.start(clock(), ""))
+	http.Redirect(w, r, "dashboard", http.StatusSeeOther)
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrDeparted refuses bookings and cancellations of a train that left or
+// leaves within TRAIN_SERVER_BOOKING_CUTOFF; answered with 410 Gone
+var ErrDeparted = errors.New("booking closed, the train departs soon or has left")
+
+// DepartedError is ErrDeparted for one train, naming it and when it leaves
+type DepartedError struct {
+	TrainID       string
+	Date          string
+	DepartureTime string
+}
+
+func (e *DepartedError) Error() string {
+	return fmt.Sprintf("%s: %v (%s on %s)", e.TrainID, ErrDeparted, e.DepartureTime, e.Date)
+}
+
+func (e *DepartedError) Unwrap() error { return ErrDeparted }
+
+// departedError refuses train, past its booking cutoff
+func departedError(train *Train) error {
+	return &DepartedError{TrainID: train.ID, Date: train.Date, DepartureTime: train.DepartureTime}
+}
+
+// departure is when train leaves, in server local time
+func departure(train *Train) (time.Time, error) {
+	return time.ParseInLocation("2006-01-02 15:04", train.Date+" "+train.DepartureTime, time.Local)
+}
+
+// bookingClosed tells whether train can no longer be booked or cancelled at
+// now. A negative cutoff never closes, nor does a train with an unreadable
+// date.
+func bookingClosed(train *Train, now time.Time) bool {
+	if config.BookingCutoff < 0 {
+		return false
+	}
+	leaves, err := departure(train)
+	return err == nil && !now.Before(leaves.Add(-config.BookingCutoff))
+}
+
+// purgeDeparted deletes trains, with their bookings, that left more than
+// TRAIN_SERVER_DEPARTED_RETENTION ago; a scheduled job
+func purgeDeparted(ctx context.Context) (string, error) {
+	cutoff := clock().Add(-config.DepartedRetention)
+	total := 0
+	var errs []error
+	for _, t := range tenantList {
+		trains, err := t.store.ListTrains(ctx)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, train := range trains {
+			leaves, err := departure(train)
+			if err != nil || !leaves.Before(cutoff) {
+				continue
+			}
+			if err := t.store.DeleteTrain(ctx, train.ID); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			total++
+		}
+	}
+	if total == 0 {
+		return "", errors.Join(errs...)
+	}
+	return fmt.Sprintf("deleted %d departed train(s)", total), errors.Join(errs...)
+}
+
+// reminded remembers the trains whose passengers were reminded, by tenant,
+// train and date, until they leave. It is per replica, so replicas sharing a
+// store each send the reminders.
+var reminded = struct {
+	sync.Mutex
+	trains map[string]time.Time // key -> departure
+}{trains: map[string]time.Time{}}
+
+// sendDepartureReminders notifies everyone booked on a train leaving within
+// TRAIN_SERVER_REMINDER_BEFORE, once per train; a scheduled job
+func sendDepartureReminders(ctx context.Context) (string, error) {
+	now := clock()
+	reminded.Lock()
+	for key, leaves := range reminded.trains {
+		if leaves.Before(now) {
+			delete(reminded.trains, key)
+		}
+	}
+	reminded.Unlock()
+
+	total := 0
+	var errs []error
+	for _, t := range tenantList {
+		trains, err := t.store.ListTrains(ctx)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		soon := map[string]*Train{}
+		for _, train := range trains {
+			leaves, err := departure(train)
+			if err != nil || leaves.Before(now) || leaves.After(now.Add(config.ReminderBefore)) {
+				continue
+			}
+			key := t.ID + "/" + train.ID + "/" + train.Date
+			reminded.Lock()
+			_, done := reminded.trains[key]
+			reminded.trains[key] = leaves
+			reminded.Unlock()
+			if !done {
+				soon[train.ID] = train
+			}
+		}
+		if len(soon) == 0 {
+			continue
+		}
+
+		err = t.store.EachBooking(ctx, func(userID string, booking UserBooking) error {
+			train, ok := soon[booking.TrainID]
+			if !ok {
+				return nil
+			}
+			total++
+			return notifications.notify(ctx, t, Notification{
+				ID:        newNotificationID(),
+				Tenant:    t.ID,
+				Type:      NotifyDepartureReminder,
+				UserID:    userID,
+				TrainID:   train.ID,
+				Message:   fmt.Sprintf("Reminder: train %s from %s to %s leaves at %s on %s.", train.ID, train.From, train.To, train.DepartureTime, train.Date),
+				CreatedAt: now,
+			})
+		})
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if total == 0 {
+		return "", errors.Join(errs...)
+	}
+	return fmt.Sprintf("reminded %d passenger(s)", total), errors.Join(errs...)
+}
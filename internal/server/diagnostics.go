@@ -0,0 +1,148 @@
+package server
+
+import (
+	"expvar"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Diagnostics: with TRAIN_SERVER_DIAGNOSTICS_ADDR, a second server serves
+// net/http/pprof under /debug/pprof/ and expvar counters under /debug/vars,
+// away from the API port, its middleware and its rate limits. Profiles can
+// hold anything in memory, so it is meant for a loopback or private address.
+
+var diagnostics *http.Server // nil when off
+
+var publishVars sync.Once
+
+func setupDiagnostics(cfg Config) error {
+	if cfg.DiagnosticsAddr == "" {
+		return nil
+	}
+	ln, err := net.Listen("tcp", cfg.DiagnosticsAddr)
+	if err != nil {
+		return err
+	}
+	runtime.SetMutexProfileFraction(cfg.MutexProfileFraction)
+	publishVars.Do(publishDiagnosticVars)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	diagnostics = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go diagnostics.Serve(ln)
+
+	if !loopbackAddr(ln.Addr()) {
+		log.Printf("⚠️  Diagnostics on %s are reachable from other hosts; bind them to localhost unless a firewall keeps them private", ln.Addr())
+	}
+	log.Printf("🩺 Diagnostics on http://%s/debug/pprof/ and /debug/vars", ln.Addr())
+	return nil
+}
+
+func loopbackAddr(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	return ok && tcp.IP.IsLoopback()
+}
+
+// closeDiagnostics stops the diagnostics server; profiles being taken are cut
+// short rather than holding up shutdown
+func closeDiagnostics() {
+	if diagnostics != nil {
+		diagnostics.Close()
+	}
+}
+
+var startedAt = time.Now()
+
+// publishDiagnosticVars adds the server's counters to expvar's cmdline and
+// memstats
+func publishDiagnosticVars() {
+	expvar.Publish("uptime_seconds", expvar.Func(func() any {
+		return int64(time.Since(startedAt).Seconds())
+	}))
+	expvar.Publish("goroutines", expvar.Func(func() any {
+		return runtime.NumGoroutine()
+	}))
+	expvar.Publish("holds", expvar.Func(func() any {
+		live := 0
+		for _, sizes := range storeSizesByTenant() {
+			live += sizes.Holds
+		}
+		return live
+	}))
+	expvar.Publish("store", expvar.Func(func() any {
+		return storeSizesByTenant()
+	}))
+	expvar.Publish("requests", expvar.Func(func() any {
+		_, stats := activity.snapshot("")
+		return stats
+	}))
+}
+
+// storeSizes counts what a store keeps in memory
+type storeSizes struct {
+	Trains        int `json:"trains"`
+	Tickets       int `json:"tickets"`       // booked, across users
+	Holds         int `json:"holds"`         // not yet confirmed, released or swept
+	Cancellations int `json:"cancellations"` // cancellation records kept
+	Receipts      int `json:"receipts"`
+	SupportCases  int `json:"support_cases"`
+	Feedback      int `json:"feedback"`
+	Notices       int `json:"notices"` // unacknowledged
+	DeadLetters   int `json:"dead_letters"`
+}
+
+// storeSizesByTenant reports the sizes of every tenant's store kept in this
+// process, by tenant ID ("default" for the single tenant); Redis stores are
+// left out
+func storeSizesByTenant() map[string]storeSizes {
+	all := map[string]storeSizes{}
+	for _, t := range tenantList {
+		inventory, ok := t.store.(*tenantStore)
+		if !ok {
+			continue
+		}
+		sized, ok := inventory.Store.(interface{ sizes() storeSizes })
+		if !ok {
+			continue
+		}
+		id := t.ID
+		if id == "" {
+			id = "default"
+		}
+		all[id] = sized.sizes()
+	}
+	return all
+}
+
+func (s *memoryStore) sizes() storeSizes {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sizes := storeSizes{
+		Trains:        len(s.trains),
+		Holds:         len(s.holds),
+		Cancellations: len(s.records),
+		Receipts:      len(s.receipts),
+		SupportCases:  len(s.cases),
+		Feedback:      len(s.feedback),
+		DeadLetters:   len(s.deadLetters),
+	}
+	for _, tickets := range s.userTickets {
+		for _, n := range tickets {
+			sizes.Tickets += n
+		}
+	}
+	for _, notices := range s.notices {
+		sizes.Notices += len(notices)
+	}
+	return sizes
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Passenger documents: the document number of every traveler a booking is
+// for must be in the format of its document type, checked when the booking
+// is made so formats configured later apply to travelers saved earlier. A
+// booking turned down for its documents is answered with 422 and the fields
+// at fault, so a client can ask again for just those.
+
+// Document types
+const (
+	DocumentIDCard   = "id_card"
+	DocumentPassport = "passport"
+)
+
+// National ID cards are 17 digits and a check character, a digit or X;
+// passports a letter and 6 to 8 letters or digits
+const defaultDocumentFormats = `id_card=^[0-9]{17}[0-9X]$;passport=^[A-Z][A-Z0-9]{6,8}$`
+
+// documentFormats is the pattern of each document type; none leaves
+// documents unchecked
+var documentFormats map[string]*regexp.Regexp
+
+// setupDocuments reads TRAIN_SERVER_DOCUMENT_FORMATS: type=pattern pairs
+// separated by semicolons, or "off"
+func setupDocuments(spec string) error {
+	documentFormats = map[string]*regexp.Regexp{}
+	if spec == "off" {
+		return nil
+	}
+	for _, pair := range strings.Split(spec, ";") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		kind, pattern, ok := strings.Cut(pair, "=")
+		kind = strings.ToLower(strings.TrimSpace(kind))
+		if !ok || kind == "" {
+			return fmt.Errorf("%q: want type=pattern", pair)
+		}
+		re, err := regexp.Compile(strings.TrimSpace(pattern))
+		if err != nil {
+			return fmt.Errorf("document type %s: %v", kind, err)
+		}
+		documentFormats[kind] = re
+	}
+	return nil
+}
+
+// documentTypes lists the configured document types, sorted
+func documentTypes() []string {
+	var kinds []string
+	for kind := range documentFormats {
+		kinds = append(kinds, kind)
+	}
+	slices.Sort(kinds)
+	return kinds
+}
+
+// FieldError is one field a request was turned down for
+type FieldError struct {
+	Field    string `json:"field"`              // e.g. "document"
+	Traveler string `json:"traveler,omitempty"` // label of the traveler the field belongs to
+	Message  string `json:"message"`
+}
+
+// ValidationError turns a request down for the fields it lists
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		messages[i] = f.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+func writeValidationError(w http.ResponseWriter, err *ValidationError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(map[string]any{"error": err.Error(), "fields": err.Fields})
+}
+
+// describeDocument names a document type for messages, e.g. "passport
+// number"; "document number" when the type is not known
+func describeDocument(kind string) string {
+	switch kind {
+	case DocumentIDCard:
+		return "ID card number"
+	case "":
+		return "document number"
+	}
+	return strings.ReplaceAll(kind, "_", " ") + " number"
+}
+
+// checkDocuments checks the document of each traveler a booking is for
+// against its type's format; a document of no stated type may be in any
+// configured format
+func checkDocuments(travelers []Traveler) error {
+	if len(documentFormats) == 0 {
+		return nil
+	}
+	var fields []FieldError
+	seen := map[string]bool{}
+	for _, t := range travelers {
+		if t.Document == "" || seen[t.Label] {
+			continue
+		}
+		seen[t.Label] = true
+		whose := t.Label + "'s"
+		if t.Label == selfTraveler {
+			whose = "your"
+		}
+
+		if t.DocumentType == "" {
+			matches := slices.ContainsFunc(documentTypes(), func(kind string) bool {
+				return documentFormats[kind].MatchString(t.Document)
+			})
+			if !matches {
+				fields = append(fields, FieldError{Field: "document", Traveler: t.Label, Message: fmt.Sprintf("%s %s is not valid", whose, describeDocument(""))})
+			}
+			continue
+		}
+		format, ok := documentFormats[t.DocumentType]
+		switch {
+		case !ok:
+			fields = append(fields, FieldError{Field: "document_type", Traveler: t.Label, Message: fmt.Sprintf("%s document type must be one of %s, not %q", whose, strings.Join(documentTypes(), ", "), t.DocumentType)})
+		case !format.MatchString(t.Document):
+			fields = append(fields, FieldError{Field: "document", Traveler: t.Label, Message: fmt.Sprintf("%s %s is not valid", whose, describeDocument(t.DocumentType))})
+		}
+	}
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
+	}
+	return nil
+}
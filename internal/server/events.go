@@ -0,0 +1,284 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zhangbiao2009/train-booking/internal/otlp"
+)
+
+// Domain event types
+const (
+	EventBookingConfirmed = "booking.confirmed"
+	EventBookingCancelled = "booking.cancelled"
+	EventTrainSoldOut     = "train.sold_out"
+)
+
+// Event published to the message broker
+type Event struct {
+	Type      string    `json:"type"`
+	Tenant    string    `json:"tenant,omitempty"`
+	TrainID   string    `json:"train_id"`
+	UserID    string    `json:"user_id,omitempty"`
+	Available int       `json:"available"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Publisher delivers a serialized event to a topic
+type Publisher interface {
+	Publish(topic string, payload []byte) error
+	Close() error
+}
+
+// eventBus publishes events asynchronously so the booking path never waits on the broker
+type eventBus struct {
+	publisher Publisher
+	topics    map[string]string // event type -> topic
+	queue     chan Event
+	stop      chan struct{} // closed by close to drain the queue and exit run
+	done      chan struct{} // closed when run has returned
+}
+
+var events *eventBus
+
+func newEventBus(cfg Config) (*eventBus, error) {
+	publisher, err := newPublisher(cfg.EventsBroker)
+	if err != nil {
+		return nil, err
+	}
+	if publisher == nil {
+		return nil, nil
+	}
+
+	bus := &eventBus{
+		publisher: publisher,
+		topics: map[string]string{
+			EventBookingConfirmed: cfg.EventsTopicBooked,
+			EventBookingCancelled: cfg.EventsTopicCancel,
+			EventTrainSoldOut:     cfg.EventsTopicSold,
+		},
+		queue: make(chan Event, 1024),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go bus.run()
+	return bus, nil
+}
+
+func (b *eventBus) run() {
+	defer close(b.done)
+	for {
+		select {
+		case event := <-b.queue:
+			b.publish(event)
+		case <-b.stop:
+			for {
+				select {
+				case event := <-b.queue:
+					b.publish(event)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (b *eventBus) publish(event Event) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("⚠️  [EVENTS] Cannot encode %s event: %v", event.Type, err)
+		return
+	}
+	if err := b.publisher.Publish(b.topics[event.Type], payload); err != nil {
+		log.Printf("⚠️  [EVENTS] Failed to publish %s event for %s: %v", event.Type, event.TrainID, err)
+	}
+}
+
+// close publishes the events still queued and closes the publisher. Events
+// emitted afterwards stay in the queue unpublished.
+func (b *eventBus) close() {
+	if b == nil {
+		return
+	}
+	close(b.stop)
+	<-b.done
+	if err := b.publisher.Close(); err != nil {
+		log.Printf("⚠️  [EVENTS] Cannot close publisher: %v", err)
+	}
+}
+
+// emit records an event for the admin dashboard and queues it for publishing
+// when a broker is configured
+func (b *eventBus) emit(tenant, eventType, trainID, userID string, available int) {
+	event := Event{
+		Type:      eventType,
+		Tenant:    tenant,
+		TrainID:   trainID,
+		UserID:    userID,
+		Available: available,
+		Timestamp: clock().UTC(),
+	}
+	activity.recordEvent(event)
+	bookingEvents.Add(1, otlp.String("event.type", eventType))
+	if b == nil {
+		return
+	}
+	select {
+	case b.queue <- event:
+	default:
+		log.Printf("⚠️  [EVENTS] Queue full, dropping %s event for %s", eventType, trainID)
+	}
+}
+
+func newPublisher(broker string) (Publisher, error) {
+	if broker == "" {
+		return nil, nil
+	}
+	if broker == "log" {
+		return logPublisher{}, nil
+	}
+
+	u, err := url.Parse(broker)
+	if err != nil {
+		return nil, fmt.Errorf("invalid events broker %q: %w", broker, err)
+	}
+	switch u.Scheme {
+	case "nats":
+		return &natsPublisher{addr: u.Host}, nil
+	case "kafka-rest":
+		return &kafkaRESTPublisher{
+			baseURL: "http://" + u.Host + strings.TrimSuffix(u.Path, "/"),
+			client:  &http.Client{Timeout: 5 * time.Second},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported events broker scheme %q", u.Scheme)
+	}
+}
+
+// logPublisher writes events to the server log, useful for local development
+type logPublisher struct{}
+
+func (logPublisher) Publish(topic string, payload []byte) error {
+	log.Printf("📣 [EVENT] %s %s", topic, redactBody("application/json", payload))
+	return nil
+}
+
+func (logPublisher) Close() error { return nil }
+
+// natsPublisher speaks the NATS text protocol directly and reconnects lazily
+type natsPublisher struct {
+	addr string
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func (p *natsPublisher) connect() error {
+	conn, err := net.DialTimeout("tcp", p.addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReader(conn)
+	// The server greets with an INFO line before accepting commands
+	if _, err := reader.ReadString('\n'); err != nil {
+		conn.Close()
+		return err
+	}
+	if _, err := conn.Write([]byte("CONNECT {\"verbose\":false,\"pedantic\":false,\"name\":\"train-booking-server\"}\r\n")); err != nil {
+		conn.Close()
+		return err
+	}
+	p.conn = conn
+
+	// Answer server keepalive pings so the connection is not dropped
+	go func() {
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if strings.HasPrefix(line, "PING") {
+				p.mu.Lock()
+				if p.conn == conn {
+					conn.Write([]byte("PONG\r\n"))
+				}
+				p.mu.Unlock()
+			} else if strings.HasPrefix(line, "-ERR") {
+				log.Printf("⚠️  [EVENTS] NATS error: %s", strings.TrimSpace(line))
+			}
+		}
+	}()
+	return nil
+}
+
+func (p *natsPublisher) Publish(topic string, payload []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conn == nil {
+		if err := p.connect(); err != nil {
+			return err
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "PUB %s %d\r\n", topic, len(payload))
+	buf.Write(payload)
+	buf.WriteString("\r\n")
+
+	p.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if _, err := p.conn.Write(buf.Bytes()); err != nil {
+		p.conn.Close()
+		p.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (p *natsPublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn == nil {
+		return nil
+	}
+	err := p.conn.Close()
+	p.conn = nil
+	return err
+}
+
+// kafkaRESTPublisher produces records through a Kafka REST Proxy (v2 API)
+type kafkaRESTPublisher struct {
+	baseURL string
+	client  *http.Client
+}
+
+func (p *kafkaRESTPublisher) Publish(topic string, payload []byte) error {
+	body, err := json.Marshal(map[string]any{
+		"records": []map[string]json.RawMessage{{"value": payload}},
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.client.Post(p.baseURL+"/topics/"+url.PathEscape(topic), "application/vnd.kafka.json.v2+json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("kafka rest proxy: %s", resp.Status)
+	}
+	return nil
+}
+
+func (p *kafkaRESTPublisher) Close() error { return nil }
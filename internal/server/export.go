@@ -0,0 +1,102 @@
+package server
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Export filters shared by the CSV endpoints: travel date range plus route
+type exportFilter struct {
+	start, end string
+	from, to   string
+}
+
+func parseExportFilter(r *http.Request) (exportFilter, error) {
+	start, end, err := parseDateRange(r)
+	if err != nil {
+		return exportFilter{}, err
+	}
+	return exportFilter{
+		start: start,
+		end:   end,
+		from:  r.URL.Query().Get("from"),
+		to:    r.URL.Query().Get("to"),
+	}, nil
+}
+
+func (f exportFilter) matches(train *Train) bool {
+	if f.from != "" && !strings.EqualFold(train.From, f.from) {
+		return false
+	}
+	if f.to != "" && !strings.EqualFold(train.To, f.to) {
+		return false
+	}
+	return inDateRange(train.Date, f.start, f.end)
+}
+
+// newCSVExport sets download headers and returns a writer that streams to the client
+func newCSVExport(w http.ResponseWriter, filename string, header []string) *csv.Writer {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	cw := csv.NewWriter(w)
+	cw.Write(header)
+	return cw
+}
+
+func handleExportTrains(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseExportFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	trains, err := tenantOf(r).store.ListTrains(r.Context())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	cw := newCSVExport(w, "trains.csv", []string{"train_id", "from", "to", "date", "departure_time", "arrival_time", "total_tickets", "available"})
+	for _, train := range trains {
+		if !filter.matches(train) {
+			continue
+		}
+		cw.Write([]string{train.ID, train.From, train.To, train.Date, train.DepartureTime, train.ArrivalTime,
+			strconv.Itoa(train.TotalTickets), strconv.Itoa(train.Available)})
+	}
+	cw.Flush()
+}
+
+func handleExportBookings(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseExportFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	trains, err := tenantOf(r).store.ListTrains(r.Context())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	byID := make(map[string]*Train, len(trains))
+	for _, train := range trains {
+		byID[train.ID] = train
+	}
+
+	// Headers are sent with the first row, so a store error part-way through
+	// can only truncate the download
+	cw := newCSVExport(w, "bookings.csv", []string{"user_id", "train_id", "from", "to", "date", "departure_time", "tickets"})
+	err = tenantOf(r).store.EachBooking(r.Context(), func(userID string, booking UserBooking) error {
+		train, ok := byID[booking.TrainID]
+		if !ok || !filter.matches(train) {
+			return nil
+		}
+		cw.Write([]string{userID, train.ID, train.From, train.To, train.Date, train.DepartureTime, strconv.Itoa(booking.Count)})
+		return cw.Error()
+	})
+	cw.Flush()
+	if err != nil {
+		logf(r.Context(), "❌ [EXPORT] bookings.csv aborted: %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Fare calendar: /fares/calendar?from=Beijing&to=Shanghai lists, for each
+// day from start to end, the lowest fare of the route's trains with tickets
+// left, for picking the cheapest day to travel. start defaults to today and
+// end to 30 days later. An unpriced tenant's days carry no fare, only the
+// earliest train.
+
+// Longest range of days one calendar covers
+const maxCalendarDays = 92
+
+// FareDay is a day of the calendar; a day without trains names none
+type FareDay struct {
+	Date       string   `json:"date"`
+	Trains     int      `json:"trains"`                // trains with tickets left
+	LowestFare *float64 `json:"lowest_fare,omitempty"` // when the tenant prices tickets
+	TrainID    string   `json:"train_id,omitempty"`    // the cheapest, or earliest when fares are equal or unpriced
+}
+
+// FareCalendar is the lowest fare of each day on a route
+type FareCalendar struct {
+	From     string    `json:"from"`
+	To       string    `json:"to"`
+	Currency string    `json:"currency,omitempty"`
+	Days     []FareDay `json:"days"`
+}
+
+func handleFareCalendar(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	from, to := strings.TrimSpace(q.Get("from")), strings.TrimSpace(q.Get("to"))
+	if from == "" || to == "" {
+		http.Error(w, "from and to are required", http.StatusBadRequest)
+		return
+	}
+	startDate := q.Get("start")
+	if startDate == "" {
+		startDate = clock().Format(time.DateOnly)
+	}
+	start, err := time.Parse(time.DateOnly, startDate)
+	if err != nil {
+		http.Error(w, "start must be a date such as 2025-06-01", http.StatusBadRequest)
+		return
+	}
+	end := start.AddDate(0, 0, 30)
+	if v := q.Get("end"); v != "" {
+		t, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			http.Error(w, "end must be a date such as 2025-06-30", http.StatusBadRequest)
+			return
+		}
+		end = t
+	}
+	days := int(end.Sub(start).Hours()/24) + 1
+	if days < 1 || days > maxCalendarDays {
+		http.Error(w, "end must be on or after start, at most 92 days later", http.StatusBadRequest)
+		return
+	}
+
+	t := tenantOf(r)
+	trains, err := t.store.ListTrains(r.Context())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	calendar := FareCalendar{From: from, To: to, Currency: t.Pricing.Currency, Days: make([]FareDay, days)}
+	index := map[string]int{}
+	for i := range calendar.Days {
+		calendar.Days[i].Date = start.AddDate(0, 0, i).Format(time.DateOnly)
+		index[calendar.Days[i].Date] = i
+	}
+	departs := map[string]string{} // departure time of each day's train chosen so far
+	for _, train := range trains {
+		i, ok := index[train.Date]
+		if !ok || train.Available == 0 || !strings.EqualFold(train.From, from) || !strings.EqualFold(train.To, to) {
+			continue
+		}
+		day := &calendar.Days[i]
+		day.Trains++
+		price := t.price(train.ID)
+		switch {
+		case day.TrainID == "":
+		case price != nil && *day.LowestFare != price.Amount:
+			if price.Amount > *day.LowestFare {
+				continue
+			}
+		case train.DepartureTime >= departs[day.Date]:
+			continue
+		}
+		day.TrainID, departs[day.Date] = train.ID, train.DepartureTime
+		if price != nil {
+			day.LowestFare = &price.Amount
+		}
+	}
+	json.NewEncoder(w).Encode(calendar)
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Feedback kinds
+const (
+	FeedbackRating    = "rating"
+	FeedbackComplaint = "complaint"
+)
+
+// Longest feedback message accepted
+const maxFeedbackMessage = 2000
+
+// Feedback is a user's rating of a trip or report of a problem, about a train
+// or one of their bookings
+type Feedback struct {
+	ID         string    `json:"feedback_id"`
+	UserID     string    `json:"user_id"`
+	Kind       string    `json:"kind"`
+	Rating     int       `json:"rating,omitempty"` // 1-5 stars, for ratings
+	TrainID    string    `json:"train_id,omitempty"`
+	BookingRef string    `json:"booking_ref,omitempty"`
+	Message    string    `json:"message,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// feedbackID formats the nth feedback received, e.g. FB-1001
+func feedbackID(n int) string {
+	return fmt.Sprintf("FB-%d", 1000+n)
+}
+
+// errFeedbackBooking refuses feedback naming someone else's or no booking
+var errFeedbackBooking = errors.New("booking not found for this user")
+
+func handleFeedback(w http.ResponseWriter, r *http.Request) {
+	var req Feedback
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	req.Message = strings.TrimSpace(req.Message)
+	req.BookingRef = strings.ToUpper(req.BookingRef)
+	req.TrainID = canonicalTrainID(req.TrainID)
+
+	// Validate required fields
+	switch {
+	case req.UserID == "":
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	case req.Kind != FeedbackRating && req.Kind != FeedbackComplaint:
+		http.Error(w, "kind must be rating or complaint", http.StatusBadRequest)
+		return
+	case req.Kind == FeedbackRating && (req.Rating < 1 || req.Rating > 5):
+		http.Error(w, "rating must be 1 to 5", http.StatusBadRequest)
+		return
+	case req.Kind == FeedbackComplaint && req.Message == "":
+		http.Error(w, "message is required for a complaint", http.StatusBadRequest)
+		return
+	case req.TrainID == "" && req.BookingRef == "":
+		http.Error(w, "train_id or booking_ref is required", http.StatusBadRequest)
+		return
+	case len(req.Message) > maxFeedbackMessage:
+		http.Error(w, "message too long", http.StatusBadRequest)
+		return
+	}
+	if req.Kind == FeedbackComplaint {
+		req.Rating = 0
+	}
+
+	t := tenantOf(r)
+	if req.BookingRef != "" {
+		receipt, err := t.store.Receipt(r.Context(), req.BookingRef)
+		if errors.Is(err, ErrReceiptNotFound) || (err == nil && receipt.UserID != req.UserID) {
+			http.Error(w, errFeedbackBooking.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		if req.TrainID == "" && len(receipt.Items) == 1 {
+			req.TrainID = receipt.Items[0].TrainID
+		}
+	} else if _, err := t.store.GetTrain(r.Context(), req.TrainID); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	req.CreatedAt = clock()
+	feedback, err := t.store.AddFeedback(r.Context(), req)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	logf(r.Context(), "📝 [FEEDBACK] %s %s received", feedback.Kind, feedback.ID)
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(feedback)
+}
+
+// handleAdminFeedback lists feedback, newest first, optionally of one ?kind= or ?train_id=
+func handleAdminFeedback(w http.ResponseWriter, r *http.Request) {
+	kind := r.URL.Query().Get("kind")
+	if kind != "" && kind != FeedbackRating && kind != FeedbackComplaint {
+		http.Error(w, "kind must be rating or complaint", http.StatusBadRequest)
+		return
+	}
+	trainID := canonicalTrainID(r.URL.Query().Get("train_id"))
+
+	all, err := tenantOf(r).store.Feedback(r.Context())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	list := []Feedback{}
+	for _, f := range all {
+		if (kind == "" || f.Kind == kind) && (trainID == "" || f.TrainID == trainID) {
+			list = append(list, f)
+		}
+	}
+	json.NewEncoder(w).Encode(list)
+}
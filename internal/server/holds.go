@@ -0,0 +1,156 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Hold errors
+var (
+	ErrHoldNotFound = errors.New("hold not found")
+	ErrHoldExpired  = errors.New("hold expired")
+)
+
+// Hold reserves one ticket for a user until it is confirmed, released or expires.
+// Holds live in the store so every replica sees the same leases.
+type Hold struct {
+	ID        string    `json:"hold_id"`
+	TrainID   string    `json:"train_id"`
+	UserID    string    `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func newHoldID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// expireHolds returns every tenant's expired holds to inventory; a scheduled job
+func expireHolds(ctx context.Context) (string, error) {
+	total := 0
+	var errs []error
+	for _, t := range tenantList {
+		released, err := t.store.ExpireHolds(ctx, clock())
+		if err != nil {
+			errs = append(errs, err)
+		}
+		total += released
+	}
+	if total == 0 {
+		return "", errors.Join(errs...)
+	}
+	return fmt.Sprintf("released %d expired hold(s)", total), errors.Join(errs...)
+}
+
+func handleHold(w http.ResponseWriter, r *http.Request) {
+	id := canonicalTrainID(r.URL.Query().Get("id"))
+	userID := r.URL.Query().Get("user_id")
+
+	// Validate required parameters
+	if userID == "" {
+		http.Error(w, "user_id parameter is required", http.StatusBadRequest)
+		return
+	}
+	if id == "" {
+		http.Error(w, "id parameter is required", http.StatusBadRequest)
+		return
+	}
+	pet, err := wantsPet(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if pet {
+		err = checkPets(r.Context(), tenantOf(r).store, []string{id})
+	}
+	if err == nil {
+		_, err = selfPassenger(r.Context(), tenantOf(r), userID, []string{id})
+	}
+	if err == nil {
+		err = tenantOf(r).checkOpenSeats(r.Context(), []string{id})
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	hold, err := tenantOf(r).store.Hold(r.Context(), id, userID, clock().Add(tenantOf(r).holdTTL))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(hold)
+}
+
+func handleHoldConfirm(w http.ResponseWriter, r *http.Request) {
+	holdID := r.URL.Query().Get("hold_id")
+	userID := r.URL.Query().Get("user_id")
+
+	// Validate required parameters
+	if userID == "" {
+		http.Error(w, "user_id parameter is required", http.StatusBadRequest)
+		return
+	}
+	if holdID == "" {
+		http.Error(w, "hold_id parameter is required", http.StatusBadRequest)
+		return
+	}
+	insured, err := wantsInsurance(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The hold checked the passenger's document on the train
+	passengers, err := selfPassenger(r.Context(), tenantOf(r), userID, nil)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	train, err := tenantOf(r).store.ConfirmHold(r.Context(), holdID, userID, clock())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	events.emit(tenantOf(r).ID, EventBookingConfirmed, train.ID, userID, train.Available)
+	tenantOf(r).analytics.recordBooking(train)
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"message":     "booked successfully",
+		"booking_ref": issueReceipt(r, userID, []*Train{train}, ticketOptions{insured: insured, travelers: passengers}),
+	})
+}
+
+func handleHoldRelease(w http.ResponseWriter, r *http.Request) {
+	holdID := r.URL.Query().Get("hold_id")
+	userID := r.URL.Query().Get("user_id")
+
+	// Validate required parameters
+	if userID == "" {
+		http.Error(w, "user_id parameter is required", http.StatusBadRequest)
+		return
+	}
+	if holdID == "" {
+		http.Error(w, "hold_id parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := tenantOf(r).store.ReleaseHold(r.Context(), holdID, userID); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "hold released",
+	})
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Idempotency-Key support for bookings: a client that retries a request with
+// the same key gets the first response replayed instead of a second ticket.
+// Keys are remembered by this process only, so with several replicas a retry
+// is only deduplicated when it reaches the same one.
+
+const idempotencyHeader = "Idempotency-Key"
+
+// Longest key accepted; clients normally send a UUID or random hex
+const maxIdempotencyKey = 255
+
+// idempotentResponse is the outcome of the first request with a key. done is
+// closed once it is filled in, so a concurrent retry waits for the original.
+type idempotentResponse struct {
+	fingerprint string
+	done        chan struct{}
+
+	status      int
+	contentType string
+	body        []byte
+	expires     time.Time
+}
+
+type idempotencyStore struct {
+	mu      sync.Mutex
+	entries map[string]*idempotentResponse
+}
+
+var idempotency = &idempotencyStore{entries: map[string]*idempotentResponse{}}
+
+// begin returns the entry for key and whether this request is the first with it
+func (s *idempotencyStore) begin(key, fingerprint string, now time.Time) (*idempotentResponse, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k, e := range s.entries {
+		if !e.expires.IsZero() && now.After(e.expires) {
+			delete(s.entries, k)
+		}
+	}
+	if e, ok := s.entries[key]; ok {
+		return e, false
+	}
+	e := &idempotentResponse{fingerprint: fingerprint, done: make(chan struct{})}
+	s.entries[key] = e
+	return e, true
+}
+
+// finish keeps the response for replay, or forgets the key after a server
+// error so that a retry is attempted again
+func (s *idempotencyStore) finish(key string, e *idempotentResponse, rw *responseWriter) {
+	s.mu.Lock()
+	if rw.statusCode >= 500 {
+		delete(s.entries, key)
+	} else {
+		e.status = rw.statusCode
+		e.contentType = rw.Header().Get("Content-Type")
+		e.body = rw.body.Bytes()
+		e.expires = clock().Add(config.IdempotencyTTL)
+	}
+	s.mu.Unlock()
+	close(e.done)
+}
+
+// requestFingerprint identifies what was asked, so a key cannot be reused for a different booking
+func requestFingerprint(r *http.Request, body []byte) string {
+	sum := sha256.Sum256(body)
+	path := strings.TrimPrefix(r.URL.Path, apiPrefix)
+	return r.Method + " " + path + "?" + r.URL.RawQuery + " " + hex.EncodeToString(sum[:8])
+}
+
+// idempotent replays the stored response when a request repeats an Idempotency-Key.
+// Requests without the header are handled as usual.
+func idempotent(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyHeader)
+		if key == "" || config.IdempotencyTTL <= 0 {
+			handler(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKey {
+			http.Error(w, "Idempotency-Key is too long", http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "cannot read request body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		fingerprint := requestFingerprint(r, body)
+		// Keys are the client's choice, so each tenant has its own
+		key = tenantOf(r).ID + "|" + key
+
+		for {
+			entry, first := idempotency.begin(key, fingerprint, clock())
+			if first {
+				rw := newResponseWriter(w)
+				// A handler that panics failed like a server error, so its
+				// key is forgotten and waiting retries run again; the panic
+				// goes on to the recovery middleware
+				completed := false
+				defer func() {
+					if !completed {
+						rw.statusCode = http.StatusInternalServerError
+					}
+					idempotency.finish(key, entry, rw)
+				}()
+				handler(rw, r)
+				completed = true
+				return
+			}
+
+			select {
+			case <-entry.done:
+			case <-r.Context().Done():
+				http.Error(w, "request cancelled", http.StatusServiceUnavailable)
+				return
+			}
+			if entry.fingerprint != fingerprint {
+				http.Error(w, "Idempotency-Key was already used for a different request", http.StatusUnprocessableEntity)
+				return
+			}
+			if entry.status == 0 {
+				// The original failed and was forgotten; run this one instead
+				continue
+			}
+
+			log.Printf("🔁 [IDEMPOTENCY] Replaying the response to an earlier request with the same key")
+			w.Header().Set("Idempotent-Replayed", "true")
+			if entry.contentType != "" {
+				w.Header().Set("Content-Type", entry.contentType)
+			}
+			w.WriteHeader(entry.status)
+			w.Write(entry.body)
+			return
+		}
+	}
+}
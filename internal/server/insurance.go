@@ -0,0 +1,217 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Insurance: a tenant may offer travel insurance, bought per ticket with
+// insurance=true when booking and kept as an insurance add-on on the receipt.
+// Cancelling a ticket with its booking_ref refunds it by the receipt: the fare
+// at the insurance's coverage, or at the tenant's refund_rate when uninsured,
+// the tax on it and, with the booking's last ticket on the train, the train's
+// meals and luggage. The premium has its own terms and is refunded only with a
+// ticket cancelled early enough.
+
+// InsurancePlan is the travel insurance a tenant offers
+type InsurancePlan struct {
+	Name       string  `json:"name"`
+	Price      float64 `json:"price,omitempty"`      // per ticket, in the tenant's currency
+	Coverage   float64 `json:"coverage,omitempty"`   // percent of the fare refunded on cancellation; 100 when 0
+	Refundable string  `json:"refundable,omitempty"` // the premium is refunded with a ticket cancelled at least this long before departure, e.g. "24h"; never when empty
+
+	refundable time.Duration // -1 when never
+}
+
+// demoInsurance is the single-tenant demo's plan, free as the demo is unpriced
+func demoInsurance() *InsurancePlan {
+	return &InsurancePlan{Name: "Trip protection", Refundable: "24h"}
+}
+
+// setupInsurance checks the tenant's plan and applies its defaults
+func (t *Tenant) setupInsurance() error {
+	plan := t.Insurance
+	if plan == nil {
+		return nil
+	}
+	if plan.Name == "" || plan.Price < 0 || plan.Coverage < 0 || plan.Coverage > 100 {
+		return fmt.Errorf("insurance needs a name, a price of at least 0 and a coverage of 0 to 100 percent")
+	}
+	if plan.Coverage == 0 {
+		plan.Coverage = 100
+	}
+	plan.refundable = -1
+	if plan.Refundable != "" {
+		d, err := time.ParseDuration(plan.Refundable)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid insurance refundable %q", plan.Refundable)
+		}
+		plan.refundable = d
+	}
+	if p := t.Pricing.RefundRate; p < 0 || p > 100 {
+		return fmt.Errorf("invalid refund_rate %g", p)
+	}
+	return nil
+}
+
+// premium is the price of insuring one ticket, or 0 when the tenant does not
+// price tickets
+func (t *Tenant) premium() float64 {
+	if t.Pricing.Currency == "" {
+		return 0
+	}
+	return t.Insurance.Price
+}
+
+// wantsInsurance reads the insurance parameter of a booking request, refusing
+// it where no insurance is offered
+func wantsInsurance(r *http.Request) (bool, error) {
+	value := r.URL.Query().Get("insurance")
+	if value == "" {
+		return false, nil
+	}
+	insured, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid insurance %q, expected true or false", value)
+	}
+	return insured, checkInsurance(r, insured)
+}
+
+// checkInsurance refuses insurance where none is offered
+func checkInsurance(r *http.Request, insured bool) error {
+	if insured && tenantOf(r).Insurance == nil {
+		return errors.New("insurance is not offered")
+	}
+	return nil
+}
+
+// Refund is what cancelling one ticket of a booking gave back
+type Refund struct {
+	TrainID        string        `json:"train_id"`
+	Item           int           `json:"item"` // index of the ticket in the receipt's items
+	CancellationID string        `json:"cancellation_id"`
+	RefundedAt     time.Time     `json:"refunded_at"`
+	Insured        bool          `json:"insured"`
+	Lines          []ReceiptLine `json:"lines"`
+	Amount         float64       `json:"amount"`
+}
+
+// refund works out the refund of the first ticket on train of a receipt not
+// refunded before, cancelled at now; nil if there is none. The booking's
+// meals and luggage on the train come back with its last ticket there.
+func (t *Tenant) refund(receipt *Receipt, train *Train, cancellationID string, now time.Time) *Refund {
+	items := unrefundedItems(receipt, train.ID)
+	if len(items) == 0 {
+		return nil
+	}
+	item, last := items[0], len(items) == 1
+
+	refund := &Refund{TrainID: train.ID, Item: item, CancellationID: cancellationID, RefundedAt: now, Lines: []ReceiptLine{}}
+	tickets := 0
+	for _, it := range receipt.Items {
+		if strings.EqualFold(it.TrainID, train.ID) {
+			tickets++
+		}
+	}
+	var insurance, meals, luggage float64
+	for _, a := range receipt.AddOns {
+		if a.TrainID != train.ID {
+			continue
+		}
+		switch a.Kind {
+		case AddOnInsurance:
+			refund.Insured = true
+			insurance += a.Amount
+		case AddOnMeal:
+			meals += a.Amount
+		case AddOnLuggage:
+			luggage += a.Amount
+		}
+	}
+
+	// Every ticket has its premium
+	insurance /= float64(tickets)
+	if !last {
+		meals, luggage = 0, 0
+	}
+
+	rate := t.Pricing.RefundRate
+	if refund.Insured && t.Insurance != nil {
+		rate = t.Insurance.Coverage
+	}
+	fare := cents(receipt.Items[item].Amount * rate / 100)
+	refund.Lines = append(refund.Lines, ReceiptLine{Name: fmt.Sprintf("Fare (%g%%)", rate), Amount: fare})
+	if tax := t.Pricing.TaxRate; tax > 0 && fare > 0 {
+		refund.Lines = append(refund.Lines, ReceiptLine{Name: fmt.Sprintf("Tax (%g%%)", tax), Amount: cents(fare * tax / 100)})
+	}
+	if meals > 0 {
+		refund.Lines = append(refund.Lines, ReceiptLine{Name: "Meals", Amount: cents(meals)})
+	}
+	if luggage > 0 {
+		refund.Lines = append(refund.Lines, ReceiptLine{Name: "Luggage", Amount: cents(luggage)})
+	}
+	if refund.Insured && t.Insurance != nil && t.Insurance.refundable >= 0 {
+		if leaves, err := departure(train); err == nil && !now.After(leaves.Add(-t.Insurance.refundable)) {
+			refund.Lines = append(refund.Lines, ReceiptLine{Name: t.Insurance.Name, Amount: cents(insurance)})
+		}
+	}
+	for _, line := range refund.Lines {
+		refund.Amount += line.Amount
+	}
+	refund.Amount = cents(refund.Amount)
+	return refund
+}
+
+// refundTicket records the refund of a cancelled ticket on its receipt. The
+// cancellation stands even if the receipt cannot be updated, so a failure is
+// logged and no refund returned.
+func refundTicket(r *http.Request, receipt *Receipt, train *Train, record *CancelledBooking) *Refund {
+	t := tenantOf(r)
+	refund := t.refund(receipt, train, record.ID, record.CancelledAt)
+	if refund == nil {
+		return nil
+	}
+	receipt.Refunds = append(receipt.Refunds, *refund)
+	if err := t.store.SaveReceipt(context.WithoutCancel(r.Context()), *receipt); err != nil {
+		logf(r.Context(), "⚠️  [REFUND] Cannot record the refund of %s on %s: %v", train.ID, receipt.Ref, err)
+		return nil
+	}
+	logf(r.Context(), "💸 [REFUND] %s: %s refunded %.2f (insured: %t)", receipt.Ref, train.ID, refund.Amount, refund.Insured)
+	return refund
+}
+
+// unrefundedItems are the indexes of a receipt's tickets on trainID not
+// refunded yet, in order; a booking for several travelers has one for each
+func unrefundedItems(receipt *Receipt, trainID string) []int {
+	refunded := map[int]bool{}
+	for _, refund := range receipt.Refunds {
+		refunded[refund.Item] = true
+	}
+	var items []int
+	for i, item := range receipt.Items {
+		if strings.EqualFold(item.TrainID, trainID) && !refunded[i] {
+			items = append(items, i)
+		}
+	}
+	return items
+}
+
+// latestReceipt returns userID's latest booking of trainID whose ticket was
+// not refunded, or nil, for a cancellation that names no booking
+func latestReceipt(ctx context.Context, store Store, userID, trainID string) (*Receipt, error) {
+	receipts, err := store.UserReceipts(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range receipts {
+		if len(unrefundedItems(&receipts[i], trainID)) > 0 {
+			return &receipts[i], nil
+		}
+	}
+	return nil, nil
+}
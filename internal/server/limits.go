@@ -0,0 +1,41 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Request limits middleware: rejects methods the route does not support and
+// oversized queries or bodies before the handler runs
+func limitsMiddleware(methods []string) middleware {
+	allowed := slices.Clone(methods)
+	if slices.Contains(allowed, http.MethodGet) && !slices.Contains(allowed, http.MethodHead) {
+		allowed = append(allowed, http.MethodHead)
+	}
+	allowHeader := strings.Join(allowed, ", ")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !slices.Contains(allowed, r.Method) {
+				w.Header().Set("Allow", allowHeader)
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+
+			if len(r.URL.RawQuery) > config.MaxQueryBytes {
+				http.Error(w, "query string too long", http.StatusRequestURITooLong)
+				return
+			}
+
+			if r.ContentLength > config.MaxBodyBytes {
+				http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			// Bodies without a declared length fail on read once they exceed the limit
+			r.Body = http.MaxBytesReader(w, r.Body, config.MaxBodyBytes)
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Luggage: a ticket includes the free allowance. Extra or oversized luggage,
+// such as a bicycle, is declared on a booking for a fee, up to a number of
+// places per train, and kept as luggage add-ons on its receipt; the train's
+// check-in manifest lists it.
+
+// Most of one kind of luggage declared for one ticket
+const maxLuggagePerTicket = 3
+
+// LuggageOption is a kind of luggage beyond the free allowance
+type LuggageOption struct {
+	ID       string  `json:"id"`
+	Name     string  `json:"name"`
+	Fee      float64 `json:"fee,omitempty"`       // per item, in the tenant's currency
+	PerTrain int     `json:"per_train,omitempty"` // places on every train; unlimited when 0
+}
+
+// demoLuggage is the single-tenant demo's luggage, free as the demo is unpriced
+func demoLuggage() []LuggageOption {
+	return []LuggageOption{
+		{ID: "extra", Name: "Extra bag"},
+		{ID: "oversized", Name: "Oversized bag", PerTrain: 10},
+		{ID: "bicycle", Name: "Bicycle", PerTrain: 4},
+	}
+}
+
+// setupLuggage checks the tenant's luggage options
+func (t *Tenant) setupLuggage() error {
+	seen := map[string]bool{}
+	for i, o := range t.Luggage {
+		id := strings.ToLower(strings.TrimSpace(o.ID))
+		if id == "" || o.Name == "" || o.Fee < 0 || o.PerTrain < 0 {
+			return fmt.Errorf("luggage options need an id, a name, a fee of at least 0 and per_train of at least 0")
+		}
+		if seen[id] {
+			return fmt.Errorf("luggage %q is listed twice", o.ID)
+		}
+		seen[id] = true
+		t.Luggage[i].ID = id
+	}
+	return nil
+}
+
+// luggageOption finds a kind of luggage by ID or name, ignoring case and a
+// plural "s"
+func (t *Tenant) luggageOption(name string) (LuggageOption, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	singular := strings.TrimSuffix(name, "s")
+	for _, o := range t.Luggage {
+		for _, n := range []string{o.ID, strings.ToLower(o.Name)} {
+			if name == n || singular == n {
+				return o, true
+			}
+		}
+	}
+	return LuggageOption{}, false
+}
+
+// luggageFee of one item, or 0 when the tenant does not price tickets
+func (t *Tenant) luggageFee(o LuggageOption) float64 {
+	if t.Pricing.Currency == "" {
+		return 0
+	}
+	return o.Fee
+}
+
+// luggageSpace names the places of a kind of luggage on a train
+func luggageSpace(id string) string { return "luggage:" + id }
+
+// handleDeclareLuggage replaces the luggage declared for one train of a
+// booking; an empty declaration withdraws it. train_id may be left out for a
+// booking of one train.
+func handleDeclareLuggage(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		UserID  string `json:"user_id"`
+		TrainID string `json:"train_id"`
+		Luggage []struct {
+			Item     string `json:"item"`
+			Quantity int    `json:"quantity"`
+		} `json:"luggage"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if req.UserID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+
+	t := tenantOf(r)
+	ctx := r.Context()
+	receipt, err := userReceipt(r, req.UserID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	item := receiptItem(receipt, req.TrainID)
+	if item < 0 {
+		writeNoTicket(w, req.TrainID)
+		return
+	}
+	trainID := receipt.Items[item].TrainID
+	if err := checkTicket(r, trainID, req.UserID); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	wanted := map[string]int{} // option ID -> quantity
+	var options []LuggageOption
+	for _, l := range req.Luggage {
+		o, ok := t.luggageOption(l.Item)
+		if !ok {
+			var ids []string
+			for _, o := range t.Luggage {
+				ids = append(ids, o.ID)
+			}
+			http.Error(w, fmt.Sprintf("%q is not a kind of luggage; luggage: %s", l.Item, strings.Join(ids, ", ")), http.StatusBadRequest)
+			return
+		}
+		if _, ok := wanted[o.ID]; !ok {
+			options = append(options, o)
+		}
+		wanted[o.ID] += l.Quantity
+		if l.Quantity < 1 || wanted[o.ID] > maxLuggagePerTicket {
+			http.Error(w, fmt.Sprintf("quantity must be 1 to %d of each kind of luggage", maxLuggagePerTicket), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Take the places the declaration adds before giving back those it drops
+	declared := map[string]int{}
+	for _, a := range receipt.AddOns {
+		if a.Kind == AddOnLuggage && a.TrainID == trainID {
+			declared[a.Item] += a.Quantity
+		}
+	}
+	if err := t.changeLuggage(ctx, trainID, declared, wanted); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	var kept []AddOn
+	for _, a := range receipt.AddOns {
+		if a.Kind == AddOnLuggage && a.TrainID == trainID {
+			receipt.Total -= a.Amount
+			continue
+		}
+		kept = append(kept, a)
+	}
+	for _, o := range options {
+		a := AddOn{
+			Kind:     AddOnLuggage,
+			TrainID:  trainID,
+			Item:     o.ID,
+			Name:     o.Name,
+			Quantity: wanted[o.ID],
+			Amount:   cents(t.luggageFee(o) * float64(wanted[o.ID])),
+		}
+		receipt.Total += a.Amount
+		kept = append(kept, a)
+	}
+	receipt.AddOns = kept
+	receipt.Total = cents(receipt.Total)
+	if err := t.store.SaveReceipt(context.WithoutCancel(ctx), *receipt); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	logf(r.Context(), "🧳 [LUGGAGE] %s: %d kind(s) declared on %s", receipt.Ref, len(options), trainID)
+	json.NewEncoder(w).Encode(receipt)
+}
+
+// changeLuggage moves a ticket's places on trainID from the luggage declared
+// to the luggage wanted, by option ID. Places are taken first, so a full train
+// refuses the change and leaves the places as they were.
+func (t *Tenant) changeLuggage(ctx context.Context, trainID string, declared, wanted map[string]int) error {
+	var taken []LuggageOption
+	for _, o := range t.Luggage {
+		n := wanted[o.ID] - declared[o.ID]
+		if n <= 0 || o.PerTrain == 0 {
+			continue
+		}
+		if _, err := t.store.TakeSpace(ctx, trainID, luggageSpace(o.ID), n, o.PerTrain); err != nil {
+			for _, back := range taken {
+				t.store.TakeSpace(context.WithoutCancel(ctx), trainID, luggageSpace(back.ID), declared[back.ID]-wanted[back.ID], back.PerTrain)
+			}
+			if errors.Is(err, ErrSoldOut) {
+				err = fmt.Errorf("%s: no room left for %d more %s: %w", trainID, n, strings.ToLower(o.Name), ErrSoldOut)
+			}
+			return err
+		}
+		taken = append(taken, o)
+	}
+	for _, o := range t.Luggage {
+		n := wanted[o.ID] - declared[o.ID]
+		if n >= 0 || o.PerTrain == 0 {
+			continue
+		}
+		if _, err := t.store.TakeSpace(context.WithoutCancel(ctx), trainID, luggageSpace(o.ID), n, o.PerTrain); err != nil {
+			logf(ctx, "⚠️  [LUGGAGE] Cannot give back %d %s place(s) on %s: %v", -n, o.ID, trainID, err)
+		}
+	}
+	return nil
+}
+
+// releaseLuggage gives back the places of the luggage declared for a
+// cancelled ticket on trainID
+func (t *Tenant) releaseLuggage(ctx context.Context, receipt *Receipt, trainID string) {
+	declared := map[string]int{}
+	for _, a := range receipt.AddOns {
+		if a.Kind == AddOnLuggage && a.TrainID == trainID {
+			declared[a.Item] += a.Quantity
+		}
+	}
+	if len(declared) > 0 {
+		t.changeLuggage(ctx, trainID, declared, map[string]int{})
+	}
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Meals: trains with dining service have a menu, and passengers pre-order
+// from it until a cutoff before departure. The order is kept with the
+// booking as meal add-ons on its receipt.
+
+// Meal order refusals
+var (
+	ErrNoDining    = errors.New("no dining service on this train")
+	ErrMealsClosed = errors.New("meal orders are closed")
+)
+
+// Most of one menu item on one order
+const maxMealQuantity = 10
+
+// Dining is a train's dining service
+type Dining struct {
+	Cutoff string     `json:"cutoff,omitempty"` // before departure, e.g. "3h"; TRAIN_SERVER_MEAL_CUTOFF when empty
+	Menu   []MenuItem `json:"menu"`
+
+	cutoff time.Duration
+}
+
+// MenuItem is a dish or drink; its price is in the tenant's currency
+type MenuItem struct {
+	ID    string  `json:"id"`
+	Name  string  `json:"name"`
+	Price float64 `json:"price,omitempty"`
+}
+
+// demoDining serves the long demo journeys; the demo is unpriced
+func demoDining() map[string]Dining {
+	menu := []MenuItem{
+		{ID: "noodles", Name: "Beef noodles"},
+		{ID: "rice", Name: "Braised pork with rice"},
+		{ID: "dumplings", Name: "Vegetable dumplings"},
+		{ID: "tea", Name: "Jasmine tea"},
+	}
+	return map[string]Dining{
+		"G100": {Menu: menu},
+		"G101": {Menu: menu},
+		"G102": {Menu: menu},
+		"K300": {Menu: menu},
+	}
+}
+
+// setupDining checks the tenant's menus and applies the default cutoff
+func (t *Tenant) setupDining(cfg Config) error {
+	for trainID, d := range t.Dining {
+		d.cutoff = cfg.MealCutoff
+		if d.Cutoff != "" {
+			cutoff, err := time.ParseDuration(d.Cutoff)
+			if err != nil || cutoff < 0 {
+				return fmt.Errorf("train %s: invalid dining cutoff %q", trainID, d.Cutoff)
+			}
+			d.cutoff = cutoff
+		}
+		for _, item := range d.Menu {
+			if item.ID == "" || item.Name == "" || item.Price < 0 {
+				return fmt.Errorf("train %s: menu items need an id, a name and a price of at least 0", trainID)
+			}
+		}
+		t.Dining[trainID] = d
+	}
+	return nil
+}
+
+// Menu is what a train serves and until when it can be ordered
+type Menu struct {
+	TrainID  string     `json:"train_id"`
+	OrderBy  time.Time  `json:"order_by"`
+	Currency string     `json:"currency,omitempty"`
+	Items    []MenuItem `json:"items"`
+}
+
+// menu is trainID's menu, with the time orders close
+func (t *Tenant) menu(ctx context.Context, trainID string) (*Menu, error) {
+	d, ok := t.Dining[trainID]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", trainID, ErrNoDining)
+	}
+	train, err := t.store.GetTrain(ctx, trainID)
+	if err != nil {
+		return nil, err
+	}
+	leaves, err := departure(train)
+	if err != nil {
+		return nil, err
+	}
+	menu := &Menu{TrainID: trainID, OrderBy: leaves.Add(-d.cutoff), Currency: t.Pricing.Currency, Items: d.Menu}
+	if menu.Currency == "" {
+		// Unpriced: the menu is free
+		menu.Items = make([]MenuItem, len(d.Menu))
+		for i, item := range d.Menu {
+			menu.Items[i] = MenuItem{ID: item.ID, Name: item.Name}
+		}
+	}
+	return menu, nil
+}
+
+// handleMenu shows a train's menu
+func handleMenu(w http.ResponseWriter, r *http.Request) {
+	menu, err := tenantOf(r).menu(r.Context(), canonicalTrainID(r.PathValue("id")))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(menu)
+}
+
+// handleOrderMeals replaces the meals ordered for one train of a booking; an
+// empty order cancels them. train_id may be left out for a booking of one
+// train.
+func handleOrderMeals(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		UserID  string `json:"user_id"`
+		TrainID string `json:"train_id"`
+		Meals   []struct {
+			Item     string `json:"item"`
+			Quantity int    `json:"quantity"`
+		} `json:"meals"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if req.UserID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+
+	t := tenantOf(r)
+	ctx := r.Context()
+	receipt, err := userReceipt(r, req.UserID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	item := receiptItem(receipt, req.TrainID)
+	if item < 0 {
+		writeNoTicket(w, req.TrainID)
+		return
+	}
+	trainID := receipt.Items[item].TrainID
+	menu, err := t.menu(ctx, trainID)
+	if err == nil && !clock().Before(menu.OrderBy) {
+		err = fmt.Errorf("%s: %w at %s", trainID, ErrMealsClosed, menu.OrderBy.Format("15:04 on 2006-01-02"))
+	}
+	if err == nil {
+		err = checkTicket(r, trainID, req.UserID)
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	var meals []AddOn
+	for _, m := range req.Meals {
+		dish, ok := menuItem(menu, m.Item)
+		if !ok {
+			var names []string
+			for _, i := range menu.Items {
+				names = append(names, i.ID)
+			}
+			http.Error(w, fmt.Sprintf("%s does not serve %q; menu: %s", trainID, m.Item, strings.Join(names, ", ")), http.StatusBadRequest)
+			return
+		}
+		if m.Quantity < 1 || m.Quantity > maxMealQuantity {
+			http.Error(w, fmt.Sprintf("quantity must be 1 to %d", maxMealQuantity), http.StatusBadRequest)
+			return
+		}
+		meals = append(meals, AddOn{
+			Kind:     AddOnMeal,
+			TrainID:  trainID,
+			Item:     dish.ID,
+			Name:     dish.Name,
+			Quantity: m.Quantity,
+			Amount:   cents(dish.Price * float64(m.Quantity)),
+		})
+	}
+
+	// The new order replaces the train's earlier one
+	var kept []AddOn
+	for _, a := range receipt.AddOns {
+		if a.Kind == AddOnMeal && a.TrainID == trainID {
+			receipt.Total -= a.Amount
+			continue
+		}
+		kept = append(kept, a)
+	}
+	for _, m := range meals {
+		receipt.Total += m.Amount
+	}
+	receipt.AddOns = append(kept, meals...)
+	receipt.Total = cents(receipt.Total)
+	if err := t.store.SaveReceipt(context.WithoutCancel(ctx), *receipt); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	logf(r.Context(), "🍱 [MEALS] %s: %d item(s) ordered on %s", receipt.Ref, len(meals), trainID)
+	json.NewEncoder(w).Encode(receipt)
+}
+
+// menuItem finds a dish by ID or name, ignoring case
+func menuItem(menu *Menu, name string) (MenuItem, bool) {
+	name = strings.TrimSpace(name)
+	for _, item := range menu.Items {
+		if strings.EqualFold(item.ID, name) || strings.EqualFold(item.Name, name) {
+			return item, true
+		}
+	}
+	return MenuItem{}, false
+}
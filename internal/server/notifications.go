@@ -0,0 +1,548 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Notifications: a delay or platform change announced by an admin, a
+// departure reminder, or a waitlist promotion queued by another service, is
+// delivered to each user's registered channels: email, SMS, a webhook, or the
+// agent, which shows it on the user's next turn. Users who registered no
+// channel get the agent. Failed deliveries are retried with doubling backoff
+// and dead-lettered after TRAIN_SERVER_NOTIFY_MAX_ATTEMPTS attempts, for
+// admins to redeliver.
+
+// Notification types
+const (
+	NotifyDelay             = "train.delayed"
+	NotifyPlatformChange    = "train.platform_changed"
+	NotifyWaitlistPromotion = "waitlist.promoted"
+	NotifyDepartureReminder = "train.departure_reminder"
+)
+
+// Delivery channel types
+const (
+	ChannelEmail   = "email"
+	ChannelSMS     = "sms"
+	ChannelWebhook = "webhook"
+	ChannelAgent   = "agent"
+)
+
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// Channels a user may register, and the longest notification message
+const (
+	maxChannels            = 5
+	maxNotificationMessage = 1000
+)
+
+// How long one delivery attempt may take
+const deliveryTimeout = 10 * time.Second
+
+var phonePattern = regexp.MustCompile(`^\+?[0-9]{6,15}$`)
+
+// Notification tells one user about a change to their trip
+type Notification struct {
+	ID        string    `json:"notification_id"`
+	Tenant    string    `json:"tenant,omitempty"`
+	Type      string    `json:"type"`
+	UserID    string    `json:"user_id"`
+	TrainID   string    `json:"train_id,omitempty"`
+	Message   string    `json:"message"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Channel is where a user wants notifications: an email address, a phone
+// number for SMS, a webhook URL, or the agent, which has no address
+type Channel struct {
+	Type    string `json:"type"`
+	Address string `json:"address,omitempty"`
+}
+
+// DeadLetter is a delivery that failed every attempt
+type DeadLetter struct {
+	ID           string       `json:"dead_letter_id"`
+	Notification Notification `json:"notification"`
+	Channel      Channel      `json:"channel"`
+	Attempts     int          `json:"attempts"`
+	LastError    string       `json:"last_error"`
+	FailedAt     time.Time    `json:"failed_at"`
+}
+
+func newNotificationID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// delivery is one notification on its way to one channel
+type delivery struct {
+	tenant       *Tenant
+	notification Notification
+	channel      Channel
+	attempts     int
+	due          time.Time // of the next attempt, for retries
+}
+
+// notifier delivers notifications in the background, so announcing a delay
+// to a full train never waits on mail servers and webhooks
+type notifier struct {
+	queue   chan delivery
+	client  *http.Client
+	mu      sync.Mutex
+	retries []delivery // failed deliveries waiting for their next attempt
+	stop    chan struct{}
+	workers sync.WaitGroup
+}
+
+var notifications *notifier
+
+func newNotifier(cfg Config) *notifier {
+	n := &notifier{
+		queue:  make(chan delivery, 1024),
+		client: &http.Client{Timeout: deliveryTimeout},
+		stop:   make(chan struct{}),
+	}
+	for i := 0; i < max(cfg.NotifyWorkers, 1); i++ {
+		n.workers.Add(1)
+		go n.work()
+	}
+	n.workers.Add(1)
+	go n.retry()
+	return n
+}
+
+// notify queues n for each of the user's channels
+func (n *notifier) notify(ctx context.Context, t *Tenant, note Notification) error {
+	channels, err := t.store.Channels(ctx, note.UserID)
+	if err != nil {
+		return err
+	}
+	if len(channels) == 0 {
+		channels = []Channel{{Type: ChannelAgent}}
+	}
+	for _, ch := range channels {
+		n.enqueue(delivery{tenant: t, notification: note, channel: ch})
+	}
+	return nil
+}
+
+// enqueue hands d to a worker, dead-lettering it when the queue is full
+func (n *notifier) enqueue(d delivery) {
+	select {
+	case n.queue <- d:
+	default:
+		n.deadLetter(d, errors.New("delivery queue full"))
+	}
+}
+
+func (n *notifier) work() {
+	defer n.workers.Done()
+	for {
+		select {
+		case d := <-n.queue:
+			n.deliver(d)
+		case <-n.stop:
+			return
+		}
+	}
+}
+
+// retry requeues failed deliveries once their backoff has passed
+func (n *notifier) retry() {
+	defer n.workers.Done()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			n.mu.Lock()
+			var due []delivery
+			waiting := n.retries[:0]
+			for _, d := range n.retries {
+				if now.Before(d.due) {
+					waiting = append(waiting, d)
+				} else {
+					due = append(due, d)
+				}
+			}
+			n.retries = waiting
+			n.mu.Unlock()
+			for _, d := range due {
+				n.enqueue(d)
+			}
+		case <-n.stop:
+			return
+		}
+	}
+}
+
+func (n *notifier) deliver(d delivery) {
+	d.attempts++
+	ctx, cancel := context.WithTimeout(context.Background(), deliveryTimeout)
+	err := n.send(ctx, d)
+	cancel()
+	if err == nil {
+		log.Printf("🔔 [NOTIFY] %s %s delivered by %s", d.notification.Type, d.notification.ID, d.channel.Type)
+		return
+	}
+	if d.attempts >= config.NotifyMaxAttempts {
+		n.deadLetter(d, err)
+		return
+	}
+	backoff := config.NotifyRetryBackoff << (d.attempts - 1)
+	log.Printf("⚠️  [NOTIFY] %s delivery of %s failed (attempt %d), retrying in %s: %v", d.channel.Type, d.notification.ID, d.attempts, backoff, err)
+	d.due = clock().Add(backoff)
+	n.mu.Lock()
+	n.retries = append(n.retries, d)
+	n.mu.Unlock()
+}
+
+func (n *notifier) deadLetter(d delivery, cause error) {
+	letter := DeadLetter{
+		ID:           newNotificationID(),
+		Notification: d.notification,
+		Channel:      d.channel,
+		Attempts:     d.attempts,
+		LastError:    cause.Error(),
+		FailedAt:     clock(),
+	}
+	log.Printf("💀 [NOTIFY] %s delivery of %s dead-lettered as %s: %v", d.channel.Type, d.notification.ID, letter.ID, cause)
+	ctx, cancel := context.WithTimeout(context.Background(), deliveryTimeout)
+	defer cancel()
+	if err := d.tenant.store.AddDeadLetter(ctx, letter); err != nil {
+		log.Printf("❌ [NOTIFY] Cannot keep dead letter %s: %v", letter.ID, err)
+	}
+}
+
+// close stops the workers after their current delivery and dead-letters the
+// deliveries still queued or waiting to be retried
+func (n *notifier) close() {
+	if n == nil {
+		return
+	}
+	close(n.stop)
+	n.workers.Wait()
+
+	shutDown := errors.New("server shut down before delivery")
+	for len(n.queue) > 0 {
+		n.deadLetter(<-n.queue, shutDown)
+	}
+	for _, d := range n.retries {
+		n.deadLetter(d, shutDown)
+	}
+}
+
+func (n *notifier) send(ctx context.Context, d delivery) error {
+	note := d.notification
+	switch d.channel.Type {
+	case ChannelAgent:
+		return d.tenant.store.AddNotice(ctx, note)
+	case ChannelEmail:
+		var auth smtp.Auth
+		if config.SMTPUsername != "" {
+			host, _, _ := strings.Cut(config.SMTPAddr, ":")
+			auth = smtp.PlainAuth("", config.SMTPUsername, config.SMTPPassword, host)
+		}
+		msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n",
+			config.SMTPFrom, d.channel.Address, notificationSubject(note), note.Message)
+		return smtp.SendMail(config.SMTPAddr, auth, config.SMTPFrom, []string{d.channel.Address}, []byte(msg))
+	case ChannelSMS:
+		return n.post(ctx, config.SMSGateway, map[string]string{
+			"to":      d.channel.Address,
+			"message": notificationSubject(note) + ": " + note.Message,
+		})
+	case ChannelWebhook:
+		return n.post(ctx, d.channel.Address, note)
+	default:
+		return fmt.Errorf("unknown channel %q", d.channel.Type)
+	}
+}
+
+// post sends body as JSON to an SMS gateway or webhook, which must answer 2xx
+func (n *notifier) post(ctx context.Context, target string, body any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s answered %s", req.URL.Host, resp.Status)
+	}
+	return nil
+}
+
+func notificationSubject(note Notification) string {
+	switch note.Type {
+	case NotifyDelay:
+		return "Train " + note.TrainID + " is delayed"
+	case NotifyPlatformChange:
+		return "Platform change for train " + note.TrainID
+	case NotifyWaitlistPromotion:
+		return "You got a ticket from the waitlist"
+	case NotifyDepartureReminder:
+		return "Train " + note.TrainID + " leaves soon"
+	}
+	return "Trip update"
+}
+
+// validChannel explains what is wrong with ch, or returns nil
+func validChannel(ch Channel) error {
+	switch ch.Type {
+	case ChannelAgent:
+		return nil
+	case ChannelEmail:
+		if config.SMTPAddr == "" {
+			return errors.New("email delivery is not configured on this server")
+		}
+		if _, err := mail.ParseAddress(ch.Address); err != nil {
+			return fmt.Errorf("invalid email address %q", ch.Address)
+		}
+	case ChannelSMS:
+		if config.SMSGateway == "" {
+			return errors.New("SMS delivery is not configured on this server")
+		}
+		if !phonePattern.MatchString(ch.Address) {
+			return fmt.Errorf("invalid phone number %q", ch.Address)
+		}
+	case ChannelWebhook:
+		u, err := url.Parse(ch.Address)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid webhook URL %q", ch.Address)
+		}
+	default:
+		return fmt.Errorf("channel type must be email, sms, webhook or agent, not %q", ch.Type)
+	}
+	return nil
+}
+
+// handleUserChannels replaces the user's channels (POST) or lists them (GET)
+func handleUserChannels(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		userID := r.URL.Query().Get("user_id")
+		if userID == "" {
+			http.Error(w, "user_id parameter is required", http.StatusBadRequest)
+			return
+		}
+		channels, err := tenantOf(r).store.Channels(r.Context(), userID)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		if channels == nil {
+			channels = []Channel{}
+		}
+		json.NewEncoder(w).Encode(channels)
+		return
+	}
+
+	var req struct {
+		UserID   string    `json:"user_id"`
+		Channels []Channel `json:"channels"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if req.UserID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Channels) > maxChannels {
+		http.Error(w, fmt.Sprintf("at most %d channels", maxChannels), http.StatusBadRequest)
+		return
+	}
+	for i := range req.Channels {
+		req.Channels[i].Type = strings.ToLower(req.Channels[i].Type)
+		if err := validChannel(req.Channels[i]); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := tenantOf(r).store.SetChannels(r.Context(), req.UserID, req.Channels); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if req.Channels == nil {
+		req.Channels = []Channel{}
+	}
+	json.NewEncoder(w).Encode(req.Channels)
+}
+
+// handleUserNotifications lists the notifications waiting for the user's next
+// agent turn, oldest first
+func handleUserNotifications(w http.ResponseWriter, r *http.Request) {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "user_id parameter is required", http.StatusBadRequest)
+		return
+	}
+	notices, err := tenantOf(r).store.Notices(r.Context(), userID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if notices == nil {
+		notices = []Notification{}
+	}
+	json.NewEncoder(w).Encode(notices)
+}
+
+// handleAckNotifications removes notifications the agent has shown
+func handleAckNotifications(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		UserID string   `json:"user_id"`
+		IDs    []string `json:"notification_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if req.UserID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+	if err := tenantOf(r).store.AckNotices(r.Context(), req.UserID, req.IDs); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"message": "acknowledged"})
+}
+
+// handleAdminNotify queues a delay or platform change for everyone booked on
+// train_id, or any notification for one user_id. A delay's delay_minutes is
+// recorded in the train's punctuality.
+func handleAdminNotify(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Type         string `json:"type"`
+		TrainID      string `json:"train_id"`
+		UserID       string `json:"user_id"`
+		Message      string `json:"message"`
+		DelayMinutes int    `json:"delay_minutes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	req.Message = strings.TrimSpace(req.Message)
+	req.TrainID = canonicalTrainID(req.TrainID)
+
+	// Validate required fields
+	switch {
+	case req.Type != NotifyDelay && req.Type != NotifyPlatformChange && req.Type != NotifyWaitlistPromotion:
+		http.Error(w, "type must be train.delayed, train.platform_changed or waitlist.promoted", http.StatusBadRequest)
+		return
+	case req.Message == "":
+		http.Error(w, "message is required", http.StatusBadRequest)
+		return
+	case len(req.Message) > maxNotificationMessage:
+		http.Error(w, "message too long", http.StatusBadRequest)
+		return
+	case req.UserID == "" && (req.TrainID == "" || req.Type == NotifyWaitlistPromotion):
+		http.Error(w, "user_id is required, or train_id to notify the train's passengers", http.StatusBadRequest)
+		return
+	case req.DelayMinutes < 0 || (req.DelayMinutes > 0 && (req.Type != NotifyDelay || req.TrainID == "")):
+		http.Error(w, "delay_minutes must be positive and given with a train.delayed train_id", http.StatusBadRequest)
+		return
+	}
+
+	t := tenantOf(r)
+	users := []string{req.UserID}
+	if req.TrainID != "" {
+		train, err := t.store.GetTrain(r.Context(), req.TrainID)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		if req.DelayMinutes > 0 {
+			t.delays.record(train, req.DelayMinutes)
+		}
+	}
+	if req.UserID == "" {
+		users = nil
+		err := t.store.EachBooking(r.Context(), func(userID string, booking UserBooking) error {
+			if booking.TrainID == req.TrainID {
+				users = append(users, userID)
+			}
+			return nil
+		})
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+	}
+
+	ids := []string{}
+	for _, userID := range users {
+		note := Notification{
+			ID:        newNotificationID(),
+			Tenant:    t.ID,
+			Type:      req.Type,
+			UserID:    userID,
+			TrainID:   req.TrainID,
+			Message:   req.Message,
+			CreatedAt: clock(),
+		}
+		if err := notifications.notify(r.Context(), t, note); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		ids = append(ids, note.ID)
+	}
+	log.Printf("🔔 [NOTIFY] %s queued for %d user(s)", req.Type, len(ids))
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string][]string{"notification_ids": ids})
+}
+
+func handleDeadLetters(w http.ResponseWriter, r *http.Request) {
+	letters, err := tenantOf(r).store.DeadLetters(r.Context())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if letters == nil {
+		letters = []DeadLetter{}
+	}
+	json.NewEncoder(w).Encode(letters)
+}
+
+// handleRedeliver queues a dead letter's delivery again with fresh attempts
+func handleRedeliver(w http.ResponseWriter, r *http.Request) {
+	t := tenantOf(r)
+	letter, err := t.store.TakeDeadLetter(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	notifications.enqueue(delivery{tenant: t, notification: letter.Notification, channel: letter.Channel})
+	log.Printf("🔁 [NOTIFY] Dead letter %s queued for redelivery", letter.ID)
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(letter)
+}
@@ -0,0 +1,365 @@
+package server
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// OpenAPI document describing the booking API; keep it in sync with the routes
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// Subset of OpenAPI 3.0 used for validation
+type openAPISchema struct {
+	Ref        string                    `json:"$ref"`
+	Type       string                    `json:"type"`
+	Nullable   bool                      `json:"nullable"`
+	Properties map[string]*openAPISchema `json:"properties"`
+	Required   []string                  `json:"required"`
+	Items      *openAPISchema            `json:"items"`
+	Enum       []any                     `json:"enum"`
+	Pattern    string                    `json:"pattern"`
+	MinLength  *int                      `json:"minLength"`
+	Minimum    *float64                  `json:"minimum"`
+}
+
+type openAPIParameter struct {
+	Ref      string         `json:"$ref"`
+	Name     string         `json:"name"`
+	In       string         `json:"in"`
+	Required bool           `json:"required"`
+	Schema   *openAPISchema `json:"schema"`
+}
+
+type openAPIResponse struct {
+	Ref     string `json:"$ref"`
+	Content map[string]struct {
+		Schema *openAPISchema `json:"schema"`
+	} `json:"content"`
+}
+
+type openAPIRequestBody struct {
+	Required bool `json:"required"`
+	Content  map[string]struct {
+		Schema *openAPISchema `json:"schema"`
+	} `json:"content"`
+}
+
+type openAPIOperation struct {
+	Parameters  []openAPIParameter          `json:"parameters"`
+	RequestBody *openAPIRequestBody         `json:"requestBody"`
+	Responses   map[string]*openAPIResponse `json:"responses"`
+}
+
+type openAPIDocument struct {
+	Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+	Components struct {
+		Parameters map[string]openAPIParameter `json:"parameters"`
+		Responses  map[string]*openAPIResponse `json:"responses"`
+		Schemas    map[string]*openAPISchema   `json:"schemas"`
+	} `json:"components"`
+}
+
+func refName(ref string) string {
+	return ref[strings.LastIndex(ref, "/")+1:]
+}
+
+func parseOpenAPI(data []byte) (*openAPIDocument, error) {
+	var doc openAPIDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parsing OpenAPI document: %w", err)
+	}
+	return &doc, nil
+}
+
+// operation finds the operation for a request path, ignoring the version prefix;
+// templated paths such as /booking/{ref}/receipt match any value in the braces
+func (doc *openAPIDocument) operation(method, path string) *openAPIOperation {
+	path = strings.TrimPrefix(path, apiPrefix)
+	if op, ok := doc.Paths[path][strings.ToLower(method)]; ok {
+		return op
+	}
+	segments := strings.Split(path, "/")
+	for template, ops := range doc.Paths {
+		if !strings.Contains(template, "{") {
+			continue
+		}
+		parts := strings.Split(template, "/")
+		if len(parts) != len(segments) {
+			continue
+		}
+		match := true
+		for i, part := range parts {
+			if part != segments[i] && !strings.HasPrefix(part, "{") {
+				match = false
+				break
+			}
+		}
+		if match {
+			return ops[strings.ToLower(method)]
+		}
+	}
+	return nil
+}
+
+func (doc *openAPIDocument) parameter(p openAPIParameter) openAPIParameter {
+	if p.Ref != "" {
+		return doc.Components.Parameters[refName(p.Ref)]
+	}
+	return p
+}
+
+func (doc *openAPIDocument) response(op *openAPIOperation, status int) *openAPIResponse {
+	resp, ok := op.Responses[strconv.Itoa(status)]
+	if !ok {
+		resp, ok = op.Responses["default"]
+	}
+	if !ok {
+		return nil
+	}
+	if resp.Ref != "" {
+		return doc.Components.Responses[refName(resp.Ref)]
+	}
+	return resp
+}
+
+// validateRequest checks query parameters and the JSON body against the operation
+func (doc *openAPIDocument) validateRequest(op *openAPIOperation, r *http.Request) []string {
+	var problems []string
+	query := r.URL.Query()
+	for _, p := range op.Parameters {
+		p = doc.parameter(p)
+		if p.In != "query" {
+			continue
+		}
+		values, present := query[p.Name]
+		if !present {
+			if p.Required {
+				problems = append(problems, fmt.Sprintf("query parameter %q is required", p.Name))
+			}
+			continue
+		}
+		if p.Schema != nil {
+			for _, problem := range doc.validateString(p.Schema, values[0]) {
+				problems = append(problems, fmt.Sprintf("query parameter %q %s", p.Name, problem))
+			}
+		}
+	}
+	return append(problems, doc.validateRequestBody(op, r)...)
+}
+
+// validateRequestBody checks a JSON body against the operation's requestBody,
+// leaving r.Body to be read again by the handler. Bodies in another
+// documented media type, and bodies over the size limit, which the limits
+// middleware refuses, are not inspected.
+func (doc *openAPIDocument) validateRequestBody(op *openAPIOperation, r *http.Request) []string {
+	if op.RequestBody == nil {
+		return nil
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		if _, documented := op.RequestBody.Content[mediaType]; documented {
+			return nil
+		}
+	}
+	media, ok := op.RequestBody.Content["application/json"]
+	if !ok || media.Schema == nil {
+		return nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, config.MaxBodyBytes+1))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+	if err != nil || int64(len(body)) > config.MaxBodyBytes {
+		return nil
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		if op.RequestBody.Required {
+			return []string{"request body is required"}
+		}
+		return nil
+	}
+
+	var value any
+	if err := json.Unmarshal(body, &value); err != nil {
+		return []string{fmt.Sprintf("request body is not valid JSON: %v", err)}
+	}
+	return doc.validateValue(media.Schema, value, "body")
+}
+
+// validateString checks a raw parameter value against a scalar schema
+func (doc *openAPIDocument) validateString(schema *openAPISchema, value string) []string {
+	switch schema.Type {
+	case "integer":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return []string{"must be an integer"}
+		}
+		return doc.validateValue(schema, float64(n), "")
+	case "number":
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return []string{"must be a number"}
+		}
+		return doc.validateValue(schema, n, "")
+	case "boolean":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return []string{"must be a boolean"}
+		}
+		return doc.validateValue(schema, b, "")
+	default:
+		return doc.validateValue(schema, value, "")
+	}
+}
+
+// validateValue checks a decoded JSON value against a schema
+func (doc *openAPIDocument) validateValue(schema *openAPISchema, value any, path string) []string {
+	if schema.Ref != "" {
+		schema = doc.Components.Schemas[refName(schema.Ref)]
+		if schema == nil {
+			return nil
+		}
+	}
+	at := func(msg string) string {
+		if path == "" {
+			return msg
+		}
+		return path + ": " + msg
+	}
+
+	if value == nil {
+		if schema.Nullable || schema.Type == "" {
+			return nil
+		}
+		return []string{at("must not be null")}
+	}
+
+	if len(schema.Enum) > 0 && !slices.Contains(schema.Enum, value) {
+		return []string{at(fmt.Sprintf("must be one of %v", schema.Enum))}
+	}
+
+	var problems []string
+	switch schema.Type {
+	case "object":
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return []string{at("must be an object")}
+		}
+		for _, name := range schema.Required {
+			if _, ok := obj[name]; !ok {
+				problems = append(problems, at(fmt.Sprintf("missing property %q", name)))
+			}
+		}
+		for name, prop := range schema.Properties {
+			if v, ok := obj[name]; ok {
+				problems = append(problems, doc.validateValue(prop, v, path+"."+name)...)
+			}
+		}
+	case "array":
+		items, ok := value.([]any)
+		if !ok {
+			return []string{at("must be an array")}
+		}
+		if schema.Items != nil {
+			for i, item := range items {
+				problems = append(problems, doc.validateValue(schema.Items, item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return []string{at("must be a string")}
+		}
+		if schema.MinLength != nil && len(s) < *schema.MinLength {
+			problems = append(problems, at(fmt.Sprintf("must be at least %d characters", *schema.MinLength)))
+		}
+		if schema.Pattern != "" {
+			if re, err := regexp.Compile(schema.Pattern); err == nil && !re.MatchString(s) {
+				problems = append(problems, at(fmt.Sprintf("must match %s", schema.Pattern)))
+			}
+		}
+	case "integer", "number":
+		n, ok := value.(float64)
+		if !ok {
+			return []string{at("must be a number")}
+		}
+		if schema.Type == "integer" && n != float64(int64(n)) {
+			problems = append(problems, at("must be an integer"))
+		}
+		if schema.Minimum != nil && n < *schema.Minimum {
+			problems = append(problems, at(fmt.Sprintf("must be at least %v", *schema.Minimum)))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return []string{at("must be a boolean")}
+		}
+	}
+	return problems
+}
+
+// validateResponse checks the status code and JSON body against the operation.
+// Bodies in another documented media type, such as CSV downloads, are not inspected.
+func (doc *openAPIDocument) validateResponse(op *openAPIOperation, status int, contentType string, body []byte) []string {
+	resp := doc.response(op, status)
+	if resp == nil {
+		return []string{fmt.Sprintf("status %d is not documented", status)}
+	}
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType != "application/json" {
+		if _, documented := resp.Content[mediaType]; documented {
+			return nil
+		}
+	}
+	media, ok := resp.Content["application/json"]
+	if !ok || media.Schema == nil || len(body) == 0 {
+		return nil
+	}
+
+	var value any
+	if err := json.Unmarshal(body, &value); err != nil {
+		return []string{fmt.Sprintf("response body is not valid JSON: %v", err)}
+	}
+	return doc.validateValue(media.Schema, value, "body")
+}
+
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
+
+// OpenAPI validation middleware for dev mode: rejects requests that do not match
+// the spec and logs responses that drift from it
+func openAPIValidationMiddleware(doc *openAPIDocument) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			op := doc.operation(r.Method, r.URL.Path)
+			if op == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if problems := doc.validateRequest(op, r); len(problems) > 0 {
+				http.Error(w, "request does not match API schema: "+strings.Join(problems, "; "), http.StatusBadRequest)
+				return
+			}
+
+			rw := newResponseWriter(w)
+			next.ServeHTTP(rw, r)
+
+			if problems := doc.validateResponse(op, rw.statusCode, rw.Header().Get("Content-Type"), rw.body.Bytes()); len(problems) > 0 {
+				logf(r.Context(), "⚠️  [OPENAPI] %s %s response does not match schema: %s", r.Method, r.URL.Path, strings.Join(problems, "; "))
+			}
+		})
+	}
+}
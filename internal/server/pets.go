@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Pets: a train that takes pets says so with pets_allowed, and
+// /tickets?pets_allowed=true lists only those. A booking or hold made with
+// pet=true, for a passenger traveling with a pet, is refused on any other
+// train, naming the pet-friendly trains on the same route.
+
+// ErrNoPets refuses a passenger with a pet on a train that does not take pets
+var ErrNoPets = errors.New("pets are not allowed on this train")
+
+// wantsPet reads the pet parameter of a booking request
+func wantsPet(r *http.Request) (bool, error) {
+	value := r.URL.Query().Get("pet")
+	if value == "" {
+		return false, nil
+	}
+	pet, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid pet %q, expected true or false", value)
+	}
+	return pet, nil
+}
+
+// petsFilter reads the pets_allowed filter of a search; nil when not given
+func petsFilter(r *http.Request) (*bool, error) {
+	value := r.URL.Query().Get("pets_allowed")
+	if value == "" {
+		return nil, nil
+	}
+	allowed, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pets_allowed %q, expected true or false", value)
+	}
+	return &allowed, nil
+}
+
+// checkPets refuses a passenger with a pet on any of trainIDs that does not
+// take pets. Unknown trains are left for the booking to report.
+func checkPets(ctx context.Context, store Store, trainIDs []string) error {
+	for _, id := range trainIDs {
+		train, err := store.GetTrain(ctx, id)
+		if errors.Is(err, ErrTrainNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if train.PetsAllowed {
+			continue
+		}
+		err = fmt.Errorf("%s: %w", train.ID, ErrNoPets)
+		if others := petFriendly(ctx, store, train); len(others) > 0 {
+			err = fmt.Errorf("%w; pet-friendly trains from %s to %s: %s", err, train.From, train.To, strings.Join(others, ", "))
+		}
+		return err
+	}
+	return nil
+}
+
+// petFriendly lists the trains on train's route that take pets and have
+// tickets left, e.g. "G101 on 2025-06-02"
+func petFriendly(ctx context.Context, store Store, train *Train) []string {
+	trains, err := store.ListTrains(ctx)
+	if err != nil {
+		return nil
+	}
+	sort.Slice(trains, func(i, j int) bool {
+		if trains[i].Date != trains[j].Date {
+			return trains[i].Date < trains[j].Date
+		}
+		return trains[i].DepartureTime < trains[j].DepartureTime
+	})
+	var found []string
+	for _, t := range trains {
+		if t.PetsAllowed && t.Available > 0 && strings.EqualFold(t.From, train.From) && strings.EqualFold(t.To, train.To) {
+			found = append(found, fmt.Sprintf("%s on %s", t.ID, t.Date))
+		}
+	}
+	return found
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Live position: /trains/{id}/position simulates where a train is from its
+// timetable, as if it ran on time along a straight line between its cities,
+// at the server clock's time.
+
+// Where a train is on its journey
+const (
+	PositionScheduled = "scheduled" // not left yet
+	PositionEnRoute   = "en_route"
+	PositionArrived   = "arrived"
+)
+
+// Position is a train's simulated place on its journey at AsOf
+type Position struct {
+	TrainID     string       `json:"train_id"`
+	From        string       `json:"from"`
+	To          string       `json:"to"`
+	Status      string       `json:"status"`
+	Progress    float64      `json:"progress"` // share of the journey done, 0 to 1
+	Location    *Coordinates `json:"location,omitempty"`
+	Description string       `json:"description"` // e.g. "G100 is about 40% of the way from Beijing to Shanghai"
+	DepartsAt   time.Time    `json:"departs_at"`
+	ArrivesAt   time.Time    `json:"arrives_at"`
+	AsOf        time.Time    `json:"as_of"`
+}
+
+// Coordinates is a point in decimal degrees
+type Coordinates struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// Cities trains run between; a train to or from any other has no location
+var cityCoordinates = map[string]Coordinates{
+	"beijing":   {39.9042, 116.4074},
+	"shanghai":  {31.2304, 121.4737},
+	"guangzhou": {23.1291, 113.2644},
+	"shenzhen":  {22.5431, 114.0579},
+	"chengdu":   {30.5728, 104.0668},
+	"xi'an":     {34.3416, 108.9398},
+	"nanjing":   {32.0603, 118.7969},
+	"hangzhou":  {30.2741, 120.1551},
+	"wuhan":     {30.5928, 114.3055},
+}
+
+// arrival is when train arrives, in server local time; an arrival time
+// earlier than the departure is on the next day
+func arrival(train *Train, leaves time.Time) (time.Time, error) {
+	arrives, err := time.ParseInLocation("2006-01-02 15:04", train.Date+" "+train.ArrivalTime, time.Local)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if arrives.Before(leaves) {
+		arrives = arrives.AddDate(0, 0, 1)
+	}
+	return arrives, nil
+}
+
+// trainPosition works out where train is at now
+func trainPosition(train *Train, now time.Time) (*Position, error) {
+	leaves, err := departure(train)
+	if err != nil {
+		return nil, fmt.Errorf("%s: unreadable departure: %w", train.ID, err)
+	}
+	arrives, err := arrival(train, leaves)
+	if err != nil {
+		return nil, fmt.Errorf("%s: unreadable arrival: %w", train.ID, err)
+	}
+	p := &Position{TrainID: train.ID, From: train.From, To: train.To, DepartsAt: leaves, ArrivesAt: arrives, AsOf: now}
+	switch {
+	case now.Before(leaves):
+		p.Status = PositionScheduled
+		p.Description = fmt.Sprintf("%s has not left %s yet; it departs at %s on %s", train.ID, train.From, train.DepartureTime, train.Date)
+	case !now.Before(arrives):
+		p.Status, p.Progress = PositionArrived, 1
+		p.Description = fmt.Sprintf("%s arrived in %s at %s", train.ID, train.To, train.ArrivalTime)
+	default:
+		p.Status = PositionEnRoute
+		p.Progress = math.Round(float64(now.Sub(leaves))/float64(arrives.Sub(leaves))*100) / 100
+		switch {
+		case p.Progress < 0.05:
+			p.Description = fmt.Sprintf("%s has just left %s", train.ID, train.From)
+		case p.Progress > 0.95:
+			p.Description = fmt.Sprintf("%s is approaching %s", train.ID, train.To)
+		default:
+			p.Description = fmt.Sprintf("%s is about %.0f%% of the way from %s to %s", train.ID, p.Progress*100, train.From, train.To)
+		}
+		p.Description += fmt.Sprintf(", arriving at %s", train.ArrivalTime)
+	}
+	from, okFrom := cityCoordinates[strings.ToLower(train.From)]
+	to, okTo := cityCoordinates[strings.ToLower(train.To)]
+	if okFrom && okTo {
+		p.Location = &Coordinates{
+			Latitude:  math.Round((from.Latitude+(to.Latitude-from.Latitude)*p.Progress)*1e4) / 1e4,
+			Longitude: math.Round((from.Longitude+(to.Longitude-from.Longitude)*p.Progress)*1e4) / 1e4,
+		}
+	}
+	return p, nil
+}
+
+// handlePosition returns where a train is right now
+func handlePosition(w http.ResponseWriter, r *http.Request) {
+	train, err := tenantOf(r).store.GetTrain(r.Context(), canonicalTrainID(r.PathValue("id")))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	position, err := trainPosition(train, clock())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(position)
+}
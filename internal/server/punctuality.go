@@ -0,0 +1,172 @@
+package server
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Punctuality: each train's arrival delays on its recent runs, kept per
+// server instance. A train.delayed notice sent to /admin/notifications with
+// delay_minutes records a real delay; until a train has history, a simulated
+// month of runs stands in, seeded from its ID so it never changes. High-speed
+// G and D trains are simulated as more punctual than others.
+// /trains/{id}/punctuality reports the train's and its route's average delay
+// and on-time percentage, and the delay to expect from its latest runs.
+
+// Runs kept per train, simulated runs for a train without history, and
+// runs the expected delay is worked out from
+const (
+	delayHistoryRuns   = 60
+	simulatedDelayRuns = 30
+	recentDelayRuns    = 7
+)
+
+// A run arriving less than this many minutes late counts as on time
+const onTimeMinutes = 5
+
+// delayRun is one run of a train and how late it arrived
+type delayRun struct {
+	Date      string
+	Minutes   int
+	Simulated bool
+}
+
+// delayHistory is the recent runs of each train, by train ID
+type delayHistory struct {
+	mu   sync.Mutex
+	runs map[string][]delayRun
+}
+
+func newDelayHistory() *delayHistory {
+	return &delayHistory{runs: map[string][]delayRun{}}
+}
+
+// record adds a run of train arriving minutes late
+func (h *delayHistory) record(train *Train, minutes int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	runs := append(h.history(train), delayRun{Date: train.Date, Minutes: minutes})
+	if len(runs) > delayHistoryRuns {
+		runs = runs[len(runs)-delayHistoryRuns:]
+	}
+	h.runs[train.ID] = runs
+}
+
+// of returns train's runs, oldest first
+func (h *delayHistory) of(train *Train) []delayRun {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.history(train)
+}
+
+// history returns train's runs, simulating them when it has none; h.mu is held
+func (h *delayHistory) history(train *Train) []delayRun {
+	if runs, ok := h.runs[train.ID]; ok {
+		return runs
+	}
+	runs := simulateDelays(train, clock())
+	h.runs[train.ID] = runs
+	return runs
+}
+
+// simulateDelays makes up the daily runs of train over the month before now
+func simulateDelays(train *Train, now time.Time) []delayRun {
+	seed := fnv.New64a()
+	seed.Write([]byte(train.ID))
+	rng := rand.New(rand.NewSource(int64(seed.Sum64())))
+	onTime := 0.7
+	if strings.HasPrefix(train.ID, "G") || strings.HasPrefix(train.ID, "D") {
+		onTime = 0.9
+	}
+	runs := make([]delayRun, simulatedDelayRuns)
+	for i := range runs {
+		minutes := rng.Intn(onTimeMinutes)
+		switch p := rng.Float64(); {
+		case p > onTime+(1-onTime)*0.8:
+			minutes = 20 + rng.Intn(40)
+		case p > onTime:
+			minutes = onTimeMinutes + rng.Intn(15)
+		}
+		runs[i] = delayRun{
+			Date:      now.AddDate(0, 0, i-simulatedDelayRuns).Format(time.DateOnly),
+			Minutes:   minutes,
+			Simulated: true,
+		}
+	}
+	return runs
+}
+
+// DelayStats summarizes the delays of a set of runs
+type DelayStats struct {
+	Runs                int     `json:"runs"`
+	AverageDelayMinutes float64 `json:"average_delay_minutes"`
+	OnTimePercent       float64 `json:"on_time_percent"`
+	MaxDelayMinutes     int     `json:"max_delay_minutes"`
+}
+
+func delayStats(runs []delayRun) DelayStats {
+	stats := DelayStats{Runs: len(runs)}
+	if len(runs) == 0 {
+		return stats
+	}
+	total, onTime := 0, 0
+	for _, run := range runs {
+		total += run.Minutes
+		if run.Minutes < onTimeMinutes {
+			onTime++
+		}
+		stats.MaxDelayMinutes = max(stats.MaxDelayMinutes, run.Minutes)
+	}
+	stats.AverageDelayMinutes = math.Round(float64(total)/float64(len(runs))*10) / 10
+	stats.OnTimePercent = math.Round(float64(onTime)/float64(len(runs))*1000) / 10
+	return stats
+}
+
+// Punctuality is a train's delay record and its route's
+type Punctuality struct {
+	TrainID string `json:"train_id"`
+	From    string `json:"from"`
+	To      string `json:"to"`
+	DelayStats
+	ExpectedDelayMinutes int        `json:"expected_delay_minutes"` // average of the latest runs
+	SimulatedRuns        int        `json:"simulated_runs"`         // runs made up for a train without history
+	Route                DelayStats `json:"route"`                  // every train from From to To
+}
+
+// handlePunctuality reports how late a train and its route usually run
+func handlePunctuality(w http.ResponseWriter, r *http.Request) {
+	t := tenantOf(r)
+	train, err := t.store.GetTrain(r.Context(), canonicalTrainID(r.PathValue("id")))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	trains, err := t.store.ListTrains(r.Context())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	runs := t.delays.of(train)
+	p := Punctuality{TrainID: train.ID, From: train.From, To: train.To, DelayStats: delayStats(runs)}
+	p.ExpectedDelayMinutes = int(math.Round(delayStats(runs[max(0, len(runs)-recentDelayRuns):]).AverageDelayMinutes))
+	for _, run := range runs {
+		if run.Simulated {
+			p.SimulatedRuns++
+		}
+	}
+	var route []delayRun
+	for _, other := range trains {
+		if strings.EqualFold(other.From, train.From) && strings.EqualFold(other.To, train.To) {
+			route = append(route, t.delays.of(other)...)
+		}
+	}
+	p.Route = delayStats(route)
+	json.NewEncoder(w).Encode(p)
+}
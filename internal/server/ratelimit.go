@@ -0,0 +1,92 @@
+package server
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Per-client rate limiting: each client address has a token bucket holding
+// up to burst requests, refilled at rate a second. A request finding the
+// bucket empty is refused with 429 and told when to retry.
+
+// Buckets kept before full ones, which clients idle long enough to have
+// refilled, are dropped
+const rateLimitClients = 10000
+
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu      sync.Mutex
+	clients map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{rate: rate, burst: float64(max(burst, 1)), clients: map[string]*tokenBucket{}}
+}
+
+// allow takes a token from client's bucket, or says how long until one is
+// there
+func (l *rateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.clients) >= rateLimitClients {
+		for c, b := range l.clients {
+			if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+				delete(l.clients, c)
+			}
+		}
+	}
+	b, ok := l.clients[client]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.clients[client] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// Rate limit middleware; a rate of 0 or less disables it
+func rateLimitMiddleware(rate float64, burst int) middleware {
+	if rate <= 0 {
+		return nil
+	}
+	limiter := newRateLimiter(rate, burst)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			client := clientAddr(r)
+			if ok, wait := limiter.allow(client, clock()); !ok {
+				logf(r.Context(), "🚦 [RATE LIMIT] %s %s from %s", r.Method, r.URL.Path, client)
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				http.Error(w, "too many requests", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// clientAddr is the address r came from, without the port
+func clientAddr(r *http.Request) string {
+	client, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return client
+}
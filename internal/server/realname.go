@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Real-name ticketing, a tenant policy mirroring the rules of Chinese rail:
+// every ticket names its passenger, who needs a name and a document in a
+// valid format, and a document holds at most one ticket on a train. Tickets
+// users book for themselves carry their own profile, the traveler "self".
+// Missing details are answered with 422 and the fields at fault, like
+// invalid documents.
+
+// ErrDocumentTaken refuses a second ticket on a train for one document
+var ErrDocumentTaken = errors.New("one ticket per document per train")
+
+// selfPassenger is the user as the passenger of a ticket on each of
+// trainIDs, checked like checkPassengers; nil when the tenant does not
+// ticket by real name
+func selfPassenger(ctx context.Context, t *Tenant, userID string, trainIDs []string) ([]Traveler, error) {
+	if !t.Policies.RealName {
+		return nil, nil
+	}
+	travelers, err := bookingTravelers(ctx, t.store, userID, []string{selfTraveler})
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPassengers(ctx, t, travelers, trainIDs); err != nil {
+		return nil, err
+	}
+	return travelers, nil
+}
+
+// checkPassengers checks the travelers who get a ticket on each of trainIDs:
+// their documents are in a valid format, and with real-name ticketing every
+// one has a name and a document not already holding a ticket on the trains
+func checkPassengers(ctx context.Context, t *Tenant, travelers []Traveler, trainIDs []string) error {
+	if t.Policies.RealName {
+		var fields []FieldError
+		for _, p := range travelers {
+			whose := p.Label + "'s"
+			if p.Label == selfTraveler {
+				whose = "your"
+			}
+			if p.Name == "" {
+				fields = append(fields, FieldError{Field: "name", Traveler: p.Label, Message: whose + " full name is needed for a real-name ticket"})
+			}
+			if p.Document == "" {
+				fields = append(fields, FieldError{Field: "document", Traveler: p.Label, Message: whose + " ID card or passport number is needed for a real-name ticket"})
+			}
+		}
+		if len(fields) > 0 {
+			return &ValidationError{Fields: fields}
+		}
+	}
+	if err := checkDocuments(travelers); err != nil {
+		return err
+	}
+	if !t.Policies.RealName || len(trainIDs) == 0 {
+		return nil
+	}
+	return checkDocumentsFree(ctx, t.store, travelers, trainIDs)
+}
+
+// checkDocumentsFree refuses a document that would get two tickets on a
+// train, or already holds a ticket on one of trainIDs that was not refunded.
+// Concurrent bookings with the same document may both get through.
+func checkDocumentsFree(ctx context.Context, store Store, travelers []Traveler, trainIDs []string) error {
+	names := map[string]string{} // document -> passenger
+	for _, p := range travelers {
+		if other, ok := names[p.Document]; ok {
+			return fmt.Errorf("%s and %s have the same document: %w", other, p.Name, ErrDocumentTaken)
+		}
+		names[p.Document] = p.Name
+	}
+	trains := map[string]bool{}
+	for _, id := range trainIDs {
+		id = strings.ToUpper(id)
+		if trains[id] {
+			return fmt.Errorf("%s is listed twice: %w", id, ErrDocumentTaken)
+		}
+		trains[id] = true
+	}
+
+	return store.EachReceipt(ctx, func(receipt Receipt) error {
+		refunded := map[int]bool{}
+		for _, refund := range receipt.Refunds {
+			refunded[refund.Item] = true
+		}
+		for i, item := range receipt.Items {
+			id := strings.ToUpper(item.TrainID)
+			if item.Document == "" || !trains[id] || refunded[i] {
+				continue
+			}
+			if name, ok := names[item.Document]; ok {
+				return fmt.Errorf("%s: %s already has a ticket: %w", id, name, ErrDocumentTaken)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,227 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrReceiptNotFound is returned for unknown booking references
+var ErrReceiptNotFound = errors.New("booking not found")
+
+// Tickets are booked in standard class, without a surcharge, and may be
+// upgraded later; there is no loyalty programme yet, so no points are used
+const standardClass = "standard"
+
+// Receipt is the itemized fare breakdown of one booking, issued when it is made
+type Receipt struct {
+	Ref        string        `json:"booking_ref"`
+	UserID     string        `json:"user_id"`
+	IssuedAt   time.Time     `json:"issued_at"`
+	Currency   string        `json:"currency,omitempty"` // empty when the tenant has no pricing
+	Items      []ReceiptItem `json:"items"`
+	Subtotal   float64       `json:"subtotal"`
+	Fees       []ReceiptLine `json:"fees"`
+	Taxes      []ReceiptLine `json:"taxes"`
+	AddOns     []AddOn       `json:"add_ons,omitempty"` // extras bought with the booking, in the total but untaxed
+	PointsUsed int           `json:"points_used"`
+	Total      float64       `json:"total"`
+	Refunds    []Refund      `json:"refunds,omitempty"`    // of cancelled tickets, not taken off the total paid
+	SessionID  string        `json:"session_id,omitempty"` // of the agent conversation that booked it
+	BookedBy   string        `json:"booked_by,omitempty"`  // operator who booked it for the user
+}
+
+// ReceiptItem is one ticket of a booking
+type ReceiptItem struct {
+	TrainID        string        `json:"train_id"`
+	From           string        `json:"from"`
+	To             string        `json:"to"`
+	Date           string        `json:"date"`
+	DepartureTime  string        `json:"departure_time"`
+	Class          string        `json:"class"`
+	BaseFare       float64       `json:"base_fare"`
+	ClassSurcharge float64       `json:"class_surcharge"`
+	Discounts      []ReceiptLine `json:"discounts"`
+	Amount         float64       `json:"amount"`
+	Accessible     bool          `json:"accessible,omitempty"` // a wheelchair-accessible seat
+	Seat           string        `json:"seat,omitempty"`       // chosen by the passenger, e.g. 1-12F
+	Traveler       string        `json:"traveler,omitempty"`   // name of the saved traveler it is for
+	PassengerType  string        `json:"passenger_type,omitempty"`
+	Document       string        `json:"document,omitempty"` // the traveler's, for real-name ticketing
+}
+
+// AddOn is an extra bought with a booking for one of its trains
+type AddOn struct {
+	Kind     string  `json:"kind"` // AddOnMeal, AddOnInsurance or AddOnLuggage
+	TrainID  string  `json:"train_id"`
+	Item     string  `json:"item"` // e.g. the menu item ID
+	Name     string  `json:"name"`
+	Quantity int     `json:"quantity"`
+	Amount   float64 `json:"amount"`
+}
+
+// Kinds of add-on
+const (
+	AddOnMeal      = "meal"
+	AddOnInsurance = "insurance"
+	AddOnLuggage   = "luggage"
+)
+
+// addOnKinds lists every kind of add-on, in report order
+var addOnKinds = []string{AddOnMeal, AddOnInsurance, AddOnLuggage}
+
+// ReceiptLine is a named discount, fee or tax
+type ReceiptLine struct {
+	Name   string  `json:"name"`
+	Amount float64 `json:"amount"`
+}
+
+func newBookingRef() string {
+	b := make([]byte, 5)
+	rand.Read(b)
+	return "BK" + strings.ToUpper(hex.EncodeToString(b))
+}
+
+// cents rounds an amount to two decimals
+func cents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// newReceipt prices the tickets of one booking with the tenant's fares, and
+// applies opts to each of them
+func (t *Tenant) newReceipt(userID string, trains []*Train, opts ticketOptions, now time.Time) Receipt {
+	p := t.Pricing
+	receipt := Receipt{
+		Ref:      newBookingRef(),
+		UserID:   userID,
+		IssuedAt: now,
+		Currency: p.Currency,
+		Items:    []ReceiptItem{},
+		Fees:     []ReceiptLine{},
+		Taxes:    []ReceiptLine{},
+	}
+
+	for i, train := range trains {
+		item := ReceiptItem{
+			TrainID:       train.ID,
+			From:          train.From,
+			To:            train.To,
+			Date:          train.Date,
+			DepartureTime: train.DepartureTime,
+			Class:         standardClass,
+			Discounts:     []ReceiptLine{},
+			Accessible:    opts.accessible,
+		}
+		if i < len(opts.travelers) {
+			item.Traveler, item.PassengerType, item.Document = opts.travelers[i].Name, opts.travelers[i].Type, opts.travelers[i].Document
+		}
+		if price := t.price(train.ID); price != nil {
+			item.BaseFare = price.Amount
+		}
+		item.Amount = item.BaseFare + item.ClassSurcharge
+		if p.MultiTicketDiscount > 0 && len(trains) > 1 && item.Amount > 0 {
+			discount := cents(item.Amount * p.MultiTicketDiscount / 100)
+			item.Discounts = append(item.Discounts, ReceiptLine{Name: fmt.Sprintf("Multi-ticket discount (%g%%)", p.MultiTicketDiscount), Amount: -discount})
+			item.Amount -= discount
+		}
+		item.Amount = cents(item.Amount)
+		receipt.Items = append(receipt.Items, item)
+		receipt.Subtotal += item.Amount
+		if opts.insured {
+			receipt.AddOns = append(receipt.AddOns, AddOn{
+				Kind:     AddOnInsurance,
+				TrainID:  train.ID,
+				Item:     AddOnInsurance,
+				Name:     t.Insurance.Name,
+				Quantity: 1,
+				Amount:   t.premium(),
+			})
+		}
+	}
+	receipt.Subtotal = cents(receipt.Subtotal)
+
+	total := receipt.Subtotal
+	if p.BookingFee > 0 {
+		receipt.Fees = append(receipt.Fees, ReceiptLine{Name: "Booking fee", Amount: p.BookingFee})
+		total += p.BookingFee
+	}
+	if p.TaxRate > 0 {
+		tax := cents(total * p.TaxRate / 100)
+		receipt.Taxes = append(receipt.Taxes, ReceiptLine{Name: fmt.Sprintf("Tax (%g%%)", p.TaxRate), Amount: tax})
+		total += tax
+	}
+	for _, a := range receipt.AddOns {
+		total += a.Amount
+	}
+	receipt.Total = cents(total)
+	return receipt
+}
+
+// ticketOptions are what a booking asks for with every ticket
+type ticketOptions struct {
+	insured    bool // insure it
+	accessible bool // in a wheelchair-accessible seat
+
+	travelers []Traveler // whom each ticket is for, in the order of the trains; none for the user
+}
+
+// issueReceipt stores the receipt of a booking just made and returns its
+// reference. The booking stands even if the receipt cannot be stored, so a
+// failure is logged and the reference left empty.
+func issueReceipt(r *http.Request, userID string, trains []*Train, opts ticketOptions) string {
+	t := tenantOf(r)
+	receipt := t.newReceipt(userID, trains, opts, clock())
+	receipt.SessionID = sessionOf(r)
+	receipt.BookedBy = bookedBy(r.Context())
+	// Store it even if the client has gone: the booking was made
+	if err := t.store.SaveReceipt(context.WithoutCancel(r.Context()), receipt); err != nil {
+		logf(r.Context(), "⚠️  [RECEIPT] Cannot store receipt for a booking of %d ticket(s): %v", len(trains), err)
+		return ""
+	}
+	noteBooking(r.Context(), receipt.Ref, trains)
+	noteAudit(r.Context(), receipt.Ref)
+	noteVelocity(r, userID, trains)
+	return receipt.Ref
+}
+
+// userReceipt is the receipt of the request's {ref}, which must be userID's
+func userReceipt(r *http.Request, userID string) (*Receipt, error) {
+	receipt, err := tenantOf(r).store.Receipt(r.Context(), strings.ToUpper(r.PathValue("ref")))
+	if err == nil && receipt.UserID != userID {
+		err = ErrReceiptNotFound
+	}
+	return receipt, err
+}
+
+// receiptItem is the ticket on trainID of a receipt, or its only ticket when
+// trainID is empty; -1 if there is none
+func receiptItem(receipt *Receipt, trainID string) int {
+	for i, item := range receipt.Items {
+		if strings.EqualFold(item.TrainID, trainID) || (trainID == "" && len(receipt.Items) == 1) {
+			return i
+		}
+	}
+	return -1
+}
+
+func handleReceipt(w http.ResponseWriter, r *http.Request) {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "user_id parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	receipt, err := userReceipt(r, userID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(receipt)
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/url"
+	"strings"
+)
+
+// Query parameters and JSON fields that identify a person or grant access.
+// Their values are replaced in logs unless TRAIN_SERVER_LOG_PII is set.
+var sensitiveFields = map[string]bool{
+	"user_id":         true,
+	"hold_id":         true,
+	"name":            true,
+	"passenger":       true,
+	"passenger_name":  true,
+	"document":        true,
+	"document_number": true,
+	"id_number":       true,
+	"passport":        true,
+	"traveler":        true,
+	"email":           true,
+	"phone":           true,
+	"token":           true,
+	"access_token":    true,
+	"authorization":   true,
+	"password":        true,
+	"api_key":         true,
+}
+
+func isSensitive(key string) bool {
+	return sensitiveFields[strings.ToLower(key)]
+}
+
+// redactValue replaces a value with a short digest so log lines about the same
+// user can still be correlated without revealing who it is
+func redactValue(value string) string {
+	if value == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(value))
+	return "[redacted:" + hex.EncodeToString(sum[:3]) + "]"
+}
+
+// redactURL masks sensitive query parameters of a request URL
+func redactURL(u *url.URL) string {
+	if config.LogPII || u.RawQuery == "" {
+		return u.String()
+	}
+	redacted := *u
+	redacted.RawQuery = redactQuery(u.RawQuery)
+	return redacted.String()
+}
+
+func redactQuery(rawQuery string) string {
+	if config.LogPII {
+		return rawQuery
+	}
+	// Rewrite pair by pair to keep the original order and encoding
+	pairs := strings.Split(rawQuery, "&")
+	for i, pair := range pairs {
+		key, value, _ := strings.Cut(pair, "=")
+		if name, err := url.QueryUnescape(key); err == nil && isSensitive(name) {
+			if v, err := url.QueryUnescape(value); err == nil {
+				value = v
+			}
+			pairs[i] = key + "=" + redactValue(value)
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// redactBody masks sensitive fields of JSON bodies. Plain-text error messages are
+// logged as is; other media types such as CSV exports and HTML pages are only
+// summarized because they are full of personal data or not worth logging.
+func redactBody(contentType string, body []byte) string {
+	if config.LogPII {
+		return string(body)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	var value any
+	if err := json.Unmarshal(body, &value); err == nil {
+		redacted, _ := json.Marshal(redactJSON(value))
+		return string(redacted)
+	}
+	if mediaType == "" || mediaType == "text/plain" {
+		return string(body)
+	}
+	return fmt.Sprintf("<%d bytes of %s>", len(body), mediaType)
+}
+
+func redactJSON(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, field := range v {
+			if s, ok := field.(string); ok && isSensitive(key) {
+				v[key] = redactValue(s)
+			} else {
+				v[key] = redactJSON(field)
+			}
+		}
+	case []any:
+		for i := range v {
+			v[i] = redactJSON(v[i])
+		}
+	}
+	return value
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Minimal RESP2 client covering the commands the Redis store needs
+
+var errRedisNil = errors.New("redis: nil")
+
+// redisError is an error reply returned by the server (e.g. a failed script)
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+type redisClient struct {
+	addr     string
+	password string
+	db       int
+	pool     chan *redisConn
+}
+
+func newRedisClient(addr, password string, db, poolSize int) *redisClient {
+	return &redisClient{
+		addr:     addr,
+		password: password,
+		db:       db,
+		pool:     make(chan *redisConn, poolSize),
+	}
+}
+
+func (c *redisClient) dial(ctx context.Context) (*redisConn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+	rc := &redisConn{conn: conn, reader: bufio.NewReader(conn)}
+
+	if c.password != "" {
+		if _, err := rc.do(ctx, "AUTH", c.password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if c.db != 0 {
+		if _, err := rc.do(ctx, "SELECT", strconv.Itoa(c.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return rc, nil
+}
+
+// Do runs one command on a pooled connection
+func (c *redisClient) Do(ctx context.Context, args ...string) (any, error) {
+	var rc *redisConn
+	select {
+	case rc = <-c.pool:
+	default:
+		var err error
+		if rc, err = c.dial(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	reply, err := rc.do(ctx, args...)
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) && !errors.Is(err, errRedisNil) {
+		// The reply may be partly unread after an I/O or parse error, or a
+		// cancellation, so the connection is not used again
+		rc.conn.Close()
+		return nil, withContextError(ctx, err)
+	}
+
+	select {
+	case c.pool <- rc:
+	default:
+		rc.conn.Close()
+	}
+	return reply, err
+}
+
+// withContextError names ctx's error in an I/O error it caused, so that a
+// store call cut short by the request's deadline reads as a timeout. The
+// connection deadline can pass a moment before ctx reports being done.
+func withContextError(ctx context.Context, err error) error {
+	ctxErr := ctx.Err()
+	if deadline, ok := ctx.Deadline(); ctxErr == nil && ok && errors.Is(err, os.ErrDeadlineExceeded) && !time.Now().Before(deadline) {
+		ctxErr = context.DeadlineExceeded
+	}
+	if ctxErr == nil {
+		return err
+	}
+	return fmt.Errorf("%w (%v)", ctxErr, err)
+}
+
+// do sends a command and reads its whole reply. A done ctx cuts the I/O
+// short, as its deadline does.
+func (rc *redisConn) do(ctx context.Context, args ...string) (any, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(5 * time.Second)
+	}
+	rc.conn.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { rc.conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	buf := fmt.Appendf(nil, "*%d\r\n", len(args))
+	for _, arg := range args {
+		buf = fmt.Appendf(buf, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := rc.conn.Write(buf); err != nil {
+		return nil, err
+	}
+	return rc.readReply()
+}
+
+func (rc *redisConn) readLine() (string, error) {
+	line, err := rc.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 3 {
+		return "", fmt.Errorf("redis: malformed reply %q", line)
+	}
+	return line[:len(line)-2], nil
+}
+
+// readReply reads one reply. An error reply is returned as the error; error
+// elements of an array, such as those of EXEC, are kept in it as redisError
+// values, so the array is always read to its end.
+func (rc *redisConn) readReply() (any, error) {
+	line, err := rc.readLine()
+	if err != nil {
+		return nil, err
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, errRedisNil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(rc.reader, data); err != nil {
+			return nil, err
+		}
+		return string(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, errRedisNil
+		}
+		items := make([]any, n)
+		for i := range items {
+			item, err := rc.readReply()
+			var itemErr redisError
+			switch {
+			case errors.As(err, &itemErr):
+				item = itemErr
+			case err != nil && !errors.Is(err, errRedisNil):
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply %q", line)
+	}
+}
+
+// Reply conversion helpers
+
+func redisInt(reply any, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+	n, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("redis: expected integer reply, got %T", reply)
+	}
+	return n, nil
+}
+
+func redisStrings(reply any, err error) ([]string, error) {
+	if err != nil {
+		return nil, err
+	}
+	items, ok := reply.([]any)
+	if !ok {
+		return nil, fmt.Errorf("redis: expected array reply, got %T", reply)
+	}
+	values := make([]string, len(items))
+	for i, item := range items {
+		values[i], _ = item.(string)
+	}
+	return values, nil
+}
+
+func redisStringMap(reply any, err error) (map[string]string, error) {
+	values, err := redisStrings(reply, err)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]string, len(values)/2)
+	for i := 0; i+1 < len(values); i += 2 {
+		m[values[i]] = values[i+1]
+	}
+	return m, nil
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeRedis answers each command with the next of replies, raw RESP, and
+// counts the connections made to it. A reply of "" is never sent.
+func fakeRedis(t *testing.T, replies ...string) (addr string, conns *atomic.Int32) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	next := make(chan string, len(replies))
+	for _, reply := range replies {
+		next <- reply
+	}
+	close(next)
+	conns = new(atomic.Int32)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns.Add(1)
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for skipCommand(r) == nil {
+					reply, ok := <-next
+					if !ok {
+						return
+					}
+					if reply == "" {
+						continue
+					}
+					conn.Write([]byte(reply))
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String(), conns
+}
+
+// skipCommand reads one command sent as a RESP array of bulk strings
+func skipCommand(r *bufio.Reader) error {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	for i := 0; i < 2*n; i++ {
+		if _, err := r.ReadString('\n'); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestRedisReplies(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply string
+		want  any
+		err   error
+	}{
+		{"status", "+OK\r\n", "OK", nil},
+		{"integer", ":42\r\n", int64(42), nil},
+		{"bulk", "$5\r\nhello\r\n", "hello", nil},
+		{"nil", "$-1\r\n", nil, errRedisNil},
+		{"error", "-ERR sold out\r\n", nil, redisError("ERR sold out")},
+		{"array", "*3\r\n:1\r\n$-1\r\n$2\r\nok\r\n", []any{int64(1), nil, "ok"}, nil},
+		{"error in an array", "*3\r\n:1\r\n-ERR no such train\r\n:2\r\n", []any{int64(1), redisError("ERR no such train"), int64(2)}, nil},
+		{"nested", "*2\r\n*1\r\n-ERR inner\r\n:3\r\n", []any{[]any{redisError("ERR inner")}, int64(3)}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Each reply is followed by another, which must be read whole
+			addr, conns := fakeRedis(t, tt.reply, "+PONG\r\n")
+			c := newRedisClient(addr, "", 0, 1)
+			ctx := context.Background()
+
+			got, err := c.Do(ctx, "GET", "k")
+			if !reflect.DeepEqual(got, tt.want) || !errors.Is(err, tt.err) {
+				t.Errorf("reply %#v, %v; want %#v, %v", got, err, tt.want, tt.err)
+			}
+			if got, err := c.Do(ctx, "PING"); got != "PONG" || err != nil {
+				t.Errorf("next reply %#v, %v; want PONG", got, err)
+			}
+			if n := conns.Load(); n != 1 {
+				t.Errorf("%d connections, want the one reused", n)
+			}
+		})
+	}
+}
+
+func TestRedisDropsConnectionCutShort(t *testing.T) {
+	withTimeout := func() (context.Context, context.CancelFunc) {
+		return context.WithTimeout(context.Background(), 50*time.Millisecond)
+	}
+	tests := []struct {
+		name  string
+		reply string // to the first command, never finished
+		ctx   func() (context.Context, context.CancelFunc)
+		err   error
+	}{
+		{"deadline", "", withTimeout, context.DeadlineExceeded},
+		{"cancelled", "", func() (context.Context, context.CancelFunc) {
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(50*time.Millisecond, cancel)
+			return ctx, cancel
+		}, context.Canceled},
+		{"array cut short", "*2\r\n:1\r\n", withTimeout, context.DeadlineExceeded},
+		{"bulk cut short", "$10\r\nhel", withTimeout, context.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, conns := fakeRedis(t, tt.reply, "+PONG\r\n")
+			c := newRedisClient(addr, "", 0, 1)
+
+			ctx, cancel := tt.ctx()
+			defer cancel()
+			start := time.Now()
+			if _, err := c.Do(ctx, "GET", "k"); !errors.Is(err, tt.err) {
+				t.Errorf("cut short with %v, want %v", err, tt.err)
+			}
+			if waited := time.Since(start); waited > 2*time.Second {
+				t.Errorf("waited %v for the reply", waited)
+			}
+			// The rest of the reply must not be read as the next one's
+			if got, err := c.Do(context.Background(), "PING"); got != "PONG" || err != nil {
+				t.Errorf("next reply %#v, %v; want PONG", got, err)
+			}
+			if n := conns.Load(); n != 2 {
+				t.Errorf("%d connections, want a new one after the cut", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,233 @@
+package server
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Occupancy and revenue report for one train, from its inventory and the
+// receipts of its tickets. Revenue is the fares charged; add-ons are reported
+// by kind and refunds apart, as the receipts keep them. No-shows are not
+// reported because tickets are never checked in.
+type TrainReport struct {
+	TrainID       string             `json:"train_id"`
+	From          string             `json:"from"`
+	To            string             `json:"to"`
+	Date          string             `json:"date"`
+	TotalTickets  int                `json:"total_tickets"`
+	Sold          int                `json:"sold"`
+	LoadFactor    float64            `json:"load_factor"`
+	Cancellations int                `json:"cancellations"`
+	Revenue       float64            `json:"revenue"`
+	AddOns        map[string]float64 `json:"add_ons,omitempty"` // by kind
+	Refunds       float64            `json:"refunds"`
+}
+
+type ReportTotals struct {
+	TotalTickets  int                `json:"total_tickets"`
+	Sold          int                `json:"sold"`
+	LoadFactor    float64            `json:"load_factor"`
+	Cancellations int                `json:"cancellations"`
+	Revenue       float64            `json:"revenue"`
+	AddOns        map[string]float64 `json:"add_ons,omitempty"`
+	Refunds       float64            `json:"refunds"`
+}
+
+type ReportResponse struct {
+	Start    string        `json:"start,omitempty"`
+	End      string        `json:"end,omitempty"`
+	Currency string        `json:"currency,omitempty"`
+	Trains   []TrainReport `json:"trains"`
+	Totals   ReportTotals  `json:"totals"`
+}
+
+func loadFactor(sold, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(sold) / float64(total)
+}
+
+// parseDateRange reads the inclusive start and end travel dates (YYYY-MM-DD)
+func parseDateRange(r *http.Request) (start, end string, err error) {
+	start = r.URL.Query().Get("start")
+	end = r.URL.Query().Get("end")
+	for _, d := range []string{start, end} {
+		if d == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", d); err != nil {
+			return "", "", fmt.Errorf("invalid date %q, expected YYYY-MM-DD", d)
+		}
+	}
+	return start, end, nil
+}
+
+func inDateRange(date, start, end string) bool {
+	return (start == "" || date >= start) && (end == "" || date <= end)
+}
+
+// wantsCSV reports whether the client asked for CSV via format=csv or Accept
+func wantsCSV(r *http.Request) bool {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format == "csv"
+	}
+	return strings.Contains(r.Header.Get("Accept"), "text/csv")
+}
+
+func handleReports(w http.ResponseWriter, r *http.Request) {
+	start, end, err := parseDateRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp, err := buildReport(r.Context(), tenantOf(r), start, end)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	if !wantsCSV(r) {
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="report.csv"`)
+	cw := csv.NewWriter(w)
+	header := []string{"train_id", "from", "to", "date", "total_tickets", "sold", "load_factor", "cancellations", "revenue"}
+	for _, kind := range addOnKinds {
+		header = append(header, kind)
+	}
+	cw.Write(append(header, "refunds"))
+	for _, t := range resp.Trains {
+		row := []string{t.TrainID, t.From, t.To, t.Date,
+			strconv.Itoa(t.TotalTickets), strconv.Itoa(t.Sold),
+			strconv.FormatFloat(t.LoadFactor, 'f', 4, 64), strconv.Itoa(t.Cancellations),
+			strconv.FormatFloat(t.Revenue, 'f', 2, 64)}
+		for _, kind := range addOnKinds {
+			row = append(row, strconv.FormatFloat(t.AddOns[kind], 'f', 2, 64))
+		}
+		cw.Write(append(row, strconv.FormatFloat(t.Refunds, 'f', 2, 64)))
+	}
+	cw.Flush()
+}
+
+// writeDailyReport reports yesterday's trains of every tenant, saving each
+// report as JSON in TRAIN_SERVER_REPORTS_DIR when set; a scheduled job
+func writeDailyReport(ctx context.Context) (string, error) {
+	day := clock().AddDate(0, 0, -1).Format("2006-01-02")
+	var summaries []string
+	var errs []error
+	for _, t := range tenantList {
+		report, err := buildReport(ctx, t, day, day)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		name := "report-" + day + ".json"
+		if t.ID != "" {
+			name = "report-" + t.ID + "-" + day + ".json"
+		}
+		if config.ReportsDir != "" {
+			data, _ := json.MarshalIndent(report, "", "  ")
+			if err := os.WriteFile(filepath.Join(config.ReportsDir, name), data, 0o644); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+		}
+		summaries = append(summaries, fmt.Sprintf("%s: %d train(s), %d/%d sold", name, len(report.Trains), report.Totals.Sold, report.Totals.TotalTickets))
+	}
+	return strings.Join(summaries, "; "), errors.Join(errs...)
+}
+
+// buildReport reports the occupancy and revenue of the tenant's trains
+// travelling between start and end
+func buildReport(ctx context.Context, t *Tenant, start, end string) (*ReportResponse, error) {
+	trains, err := t.store.ListTrains(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cancellations, err := t.store.Cancellations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &ReportResponse{Start: start, End: end, Currency: t.Pricing.Currency, Trains: []TrainReport{}}
+	index := map[string]int{} // train ID -> position in resp.Trains
+	for _, train := range trains {
+		if !inDateRange(train.Date, start, end) {
+			continue
+		}
+		sold := train.TotalTickets - train.Available
+		index[train.ID] = len(resp.Trains)
+		resp.Trains = append(resp.Trains, TrainReport{
+			TrainID:       train.ID,
+			From:          train.From,
+			To:            train.To,
+			Date:          train.Date,
+			TotalTickets:  train.TotalTickets,
+			Sold:          sold,
+			LoadFactor:    loadFactor(sold, train.TotalTickets),
+			Cancellations: cancellations[train.ID],
+		})
+		resp.Totals.TotalTickets += train.TotalTickets
+		resp.Totals.Sold += sold
+		resp.Totals.Cancellations += cancellations[train.ID]
+	}
+	resp.Totals.LoadFactor = loadFactor(resp.Totals.Sold, resp.Totals.TotalTickets)
+
+	err = t.store.EachReceipt(ctx, func(receipt Receipt) error {
+		report := func(trainID string) *TrainReport {
+			if i, ok := index[trainID]; ok {
+				return &resp.Trains[i]
+			}
+			return nil
+		}
+		for _, item := range receipt.Items {
+			if tr := report(item.TrainID); tr != nil {
+				tr.Revenue += item.Amount
+				resp.Totals.Revenue += item.Amount
+			}
+		}
+		for _, a := range receipt.AddOns {
+			if tr := report(a.TrainID); tr != nil {
+				if tr.AddOns == nil {
+					tr.AddOns = map[string]float64{}
+				}
+				if resp.Totals.AddOns == nil {
+					resp.Totals.AddOns = map[string]float64{}
+				}
+				tr.AddOns[a.Kind] = cents(tr.AddOns[a.Kind] + a.Amount)
+				resp.Totals.AddOns[a.Kind] = cents(resp.Totals.AddOns[a.Kind] + a.Amount)
+			}
+		}
+		for _, refund := range receipt.Refunds {
+			if tr := report(refund.TrainID); tr != nil {
+				tr.Refunds += refund.Amount
+				resp.Totals.Refunds += refund.Amount
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range resp.Trains {
+		resp.Trains[i].Revenue = cents(resp.Trains[i].Revenue)
+		resp.Trains[i].Refunds = cents(resp.Trains[i].Refunds)
+	}
+	resp.Totals.Revenue = cents(resp.Totals.Revenue)
+	resp.Totals.Refunds = cents(resp.Totals.Refunds)
+	return resp, nil
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"regexp"
+)
+
+// Request IDs: every request gets a correlation ID, the caller's
+// X-Request-ID when it sends a usable one (the agent sends one per turn),
+// echoed in the response and appended to the log lines it causes, so one
+// complaint can be followed from the agent's log into the server's.
+
+const requestIDHeader = "X-Request-ID"
+
+// Caller IDs are logged as sent, so they are kept short and plain
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+type requestIDContextKey struct{}
+
+// requestIDMiddleware takes or makes each request's ID
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			b := make([]byte, 8)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDContextKey{}, id)))
+	})
+}
+
+// requestID is the ID of the request ctx belongs to, or ""
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
+
+// logf logs a line caused by the request ctx belongs to, tagged with its ID
+func logf(ctx context.Context, format string, args ...any) {
+	if id := requestID(ctx); id != "" {
+		format += " req=%s"
+		args = append(args, id)
+	}
+	log.Printf(format, args...)
+}
+
+// logTag is " req=<ID>" for the request w answers, for code that has only
+// the response at hand
+func logTag(w http.ResponseWriter) string {
+	if id := w.Header().Get(requestIDHeader); id != "" {
+		return " req=" + id
+	}
+	return ""
+}
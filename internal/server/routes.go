@@ -0,0 +1,146 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"time"
+)
+
+// Current API version prefix; unversioned paths are deprecated aliases
+const apiPrefix = "/v1"
+
+// middleware wraps a handler in behaviour shared across routes; a nil
+// middleware is one that is switched off
+type middleware func(http.Handler) http.Handler
+
+// chain wraps handler in mws, the first outermost
+func chain(handler http.Handler, mws ...middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		if mws[i] != nil {
+			handler = mws[i](handler)
+		}
+	}
+	return handler
+}
+
+// router registers the API routes, each wrapped in the same middleware
+// chain, so no route can miss logging, session recording, panic recovery or
+// rate limiting
+type router struct {
+	mux        *http.ServeMux
+	middleware []middleware // around every route, outermost first
+}
+
+func newRouter(mws ...middleware) *router {
+	return &router{mux: http.NewServeMux(), middleware: mws}
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt.mux.ServeHTTP(w, r)
+}
+
+// handle registers handler at pattern inside the router's middleware and
+// then mws
+func (rt *router) handle(pattern string, handler http.Handler, mws ...middleware) {
+	rt.mux.Handle(pattern, chain(chain(handler, mws...), rt.middleware...))
+}
+
+// route registers a handler under /v1 and as a deprecated legacy alias,
+// with request limits for the given methods
+func (rt *router) route(pattern string, handler http.HandlerFunc, methods ...string) {
+	rt.routeWith(pattern, handler, methods)
+}
+
+// admin registers an /admin/ route, which needs admin authorization
+func (rt *router) admin(pattern string, handler http.HandlerFunc, methods ...string) {
+	rt.routeWith(pattern, handler, methods, adminOnly)
+}
+
+func (rt *router) routeWith(pattern string, handler http.Handler, methods []string, mws ...middleware) {
+	versioned := apiPrefix + pattern
+	handler = chain(handler, mws...)
+	rt.handle(versioned, handler, routeTag(versioned), limitsMiddleware(methods))
+	rt.handle(pattern, handler, routeTag(pattern), deprecatedMiddleware(versioned), limitsMiddleware(methods))
+}
+
+// Deprecation headers for legacy unversioned routes (RFC 9745 / RFC 8594);
+// Deprecation is a structured date, "@" and Unix seconds
+func deprecatedMiddleware(successor string) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !config.LegacyDeprecated.IsZero() {
+				w.Header().Set("Deprecation", "@"+strconv.FormatInt(config.LegacyDeprecated.Unix(), 10))
+			}
+			if !config.LegacySunset.IsZero() {
+				w.Header().Set("Sunset", config.LegacySunset.Format(http.TimeFormat))
+			}
+			w.Header().Set("Link", "<"+successor+`>; rel="successor-version"`)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Recovery middleware: a handler that panics answers 500 and the panic is
+// logged with its stack, instead of the connection being dropped
+func recoveryMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := &headerWriter{ResponseWriter: w}
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			log.Printf("💥 [PANIC] %s %s: %v%s\n%s", r.Method, redactURL(r.URL), err, logTag(w), debug.Stack())
+			if !rw.wroteHeader {
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+			}
+		}()
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// headerWriter notes whether the response has started, and its status
+type headerWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+	code        int
+}
+
+func (w *headerWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.code = code
+	}
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// status of the response, 200 when the handler wrote none
+func (w *headerWriter) status() int {
+	if w.code == 0 {
+		return http.StatusOK
+	}
+	return w.code
+}
+
+func (w *headerWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *headerWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// parseDate reads a YYYY-MM-DD date; zero when there is none
+func parseDate(value string) time.Time {
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
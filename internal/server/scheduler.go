@@ -0,0 +1,242 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Background jobs: maintenance tasks run on a schedule, "every 10s" or
+// "daily 03:00" (server local time), each in its own goroutine so a slow job
+// never delays another and a job never overlaps itself. Admins see each job's
+// last run and can start one at once through /admin/jobs.
+
+var errUnknownJob = errors.New("job not found")
+
+// schedule is when a job runs: every interval, or daily at a time of day; the
+// zero schedule never runs
+type schedule struct {
+	every   time.Duration
+	isDaily bool
+	at      time.Duration // since midnight, for daily schedules
+}
+
+// parseSchedule reads "every <duration>", "daily HH:MM" or "off", which
+// returns a zero schedule
+func parseSchedule(spec string) (schedule, error) {
+	kind, arg, _ := strings.Cut(strings.TrimSpace(spec), " ")
+	switch strings.ToLower(kind) {
+	case "off":
+		return schedule{}, nil
+	case "every":
+		d, err := time.ParseDuration(strings.TrimSpace(arg))
+		if err != nil || d <= 0 {
+			return schedule{}, fmt.Errorf("invalid schedule %q: expected e.g. \"every 10m\"", spec)
+		}
+		return schedule{every: d}, nil
+	case "daily":
+		at, err := time.Parse("15:04", strings.TrimSpace(arg))
+		if err != nil {
+			return schedule{}, fmt.Errorf("invalid schedule %q: expected e.g. \"daily 03:00\"", spec)
+		}
+		return schedule{isDaily: true, at: time.Duration(at.Hour())*time.Hour + time.Duration(at.Minute())*time.Minute}, nil
+	}
+	return schedule{}, fmt.Errorf("invalid schedule %q: expected \"every <duration>\", \"daily HH:MM\" or \"off\"", spec)
+}
+
+func (s schedule) off() bool { return s.every <= 0 && !s.isDaily }
+
+func (s schedule) String() string {
+	switch {
+	case s.off():
+		return "off"
+	case s.every > 0:
+		return "every " + s.every.String()
+	}
+	return fmt.Sprintf("daily %02d:%02d", int(s.at.Hours()), int(s.at.Minutes())%60)
+}
+
+// next is the first run after t
+func (s schedule) next(t time.Time) time.Time {
+	if s.every > 0 {
+		return t.Add(s.every)
+	}
+	y, m, d := t.Date()
+	hh, mm := int(s.at.Hours()), int(s.at.Minutes())%60
+	at := time.Date(y, m, d, hh, mm, 0, 0, t.Location())
+	if !at.After(t) {
+		at = time.Date(y, m, d+1, hh, mm, 0, 0, t.Location())
+	}
+	return at
+}
+
+// setupJobs registers the maintenance jobs with their configured schedules
+func setupJobs(cfg Config) error {
+	jobs.add("expire-holds", "Return expired seat holds to inventory", schedule{every: cfg.HoldSweepInterval}, expireHolds)
+	cancelled := schedule{every: cfg.CancelledSweepInterval}
+	if cfg.CancelledRetention <= 0 {
+		cancelled = schedule{}
+	}
+	jobs.add("purge-cancelled", "Delete cancellation records past their retention", cancelled, purgeCancelled)
+
+	for _, j := range []struct {
+		name, description, spec string
+		run                     func(ctx context.Context) (string, error)
+	}{
+		{"purge-departed", "Delete trains, and their bookings, that left more than TRAIN_SERVER_DEPARTED_RETENTION ago", cfg.JobPurgeDeparted, purgeDeparted},
+		{"departure-reminders", "Remind passengers of trains leaving within TRAIN_SERVER_REMINDER_BEFORE", cfg.JobReminders, sendDepartureReminders},
+		{"daily-report", "Report the occupancy of yesterday's trains", cfg.JobDailyReport, writeDailyReport},
+	} {
+		when, err := parseSchedule(j.spec)
+		if err != nil {
+			return fmt.Errorf("%s: %w", j.name, err)
+		}
+		jobs.add(j.name, j.description, when, j.run)
+	}
+	return nil
+}
+
+// JobStatus is what an admin sees of a job
+type JobStatus struct {
+	Name         string     `json:"name"`
+	Description  string     `json:"description"`
+	Schedule     string     `json:"schedule"`
+	Running      bool       `json:"running"`
+	NextRun      *time.Time `json:"next_run,omitempty"`
+	LastRun      *time.Time `json:"last_run,omitempty"`
+	LastDuration string     `json:"last_duration,omitempty"`
+	LastResult   string     `json:"last_result,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+	Runs         int        `json:"runs"`
+	Failures     int        `json:"failures"`
+}
+
+// job is one scheduled task; run returns a summary of what it did
+type job struct {
+	schedule schedule
+	run      func(ctx context.Context) (string, error)
+	trigger  chan struct{} // run now, from the admin endpoint
+
+	mu     sync.Mutex
+	status JobStatus
+}
+
+type scheduler struct {
+	jobs  []*job
+	names map[string]*job
+}
+
+var jobs = &scheduler{names: map[string]*job{}}
+
+// add registers a job; a job that is off still shows in the listing and can be
+// run by hand
+func (s *scheduler) add(name, description string, when schedule, run func(ctx context.Context) (string, error)) {
+	j := &job{
+		schedule: when,
+		run:      run,
+		trigger:  make(chan struct{}, 1),
+		status:   JobStatus{Name: name, Description: description, Schedule: when.String()},
+	}
+	s.jobs = append(s.jobs, j)
+	s.names[name] = j
+}
+
+// start runs every job on its schedule until ctx is done
+func (s *scheduler) start(ctx context.Context) {
+	for _, j := range s.jobs {
+		go j.loop(ctx)
+	}
+}
+
+func (j *job) loop(ctx context.Context) {
+	for {
+		var timer *time.Timer
+		var due <-chan time.Time // nil for a job that is off, so it only runs by hand
+		if !j.schedule.off() {
+			next := j.schedule.next(clock())
+			j.mu.Lock()
+			j.status.NextRun = &next
+			j.mu.Unlock()
+			timer = time.NewTimer(next.Sub(clock()))
+			due = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-due:
+		case <-j.trigger:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		j.runOnce(ctx)
+	}
+}
+
+func (j *job) runOnce(ctx context.Context) {
+	j.mu.Lock()
+	j.status.Running = true
+	j.mu.Unlock()
+
+	start := time.Now()
+	result, err := j.run(ctx)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status.Running = false
+	j.status.LastRun = &start
+	j.status.LastDuration = time.Since(start).Round(time.Millisecond).String()
+	j.status.LastResult = result
+	j.status.LastError = ""
+	j.status.Runs++
+	if err != nil {
+		j.status.LastError = err.Error()
+		j.status.Failures++
+		log.Printf("⚠️  [JOBS] %s failed: %v", j.status.Name, err)
+	} else if result != "" {
+		log.Printf("🗓️  [JOBS] %s: %s", j.status.Name, result)
+	}
+}
+
+func (s *scheduler) statuses() []JobStatus {
+	list := make([]JobStatus, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		j.mu.Lock()
+		list = append(list, j.status)
+		j.mu.Unlock()
+	}
+	return list
+}
+
+func handleJobs(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(jobs.statuses())
+}
+
+// handleRunJob starts a job now, or as soon as its current run ends
+func handleRunJob(w http.ResponseWriter, r *http.Request) {
+	j, ok := jobs.names[r.PathValue("name")]
+	if !ok {
+		http.Error(w, errUnknownJob.Error(), http.StatusNotFound)
+		return
+	}
+	select {
+	case j.trigger <- struct{}{}:
+	default:
+		// A run is already requested
+	}
+	log.Printf("▶️  [JOBS] %s started by an admin", j.status.Name)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(j.status)
+}
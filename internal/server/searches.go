@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Search tokens: /tickets numbers its results and names the list with a
+// token, returned in the X-Search-Token header, so that /book-by-result can
+// book "result #2" of that very list even if availability shifted since and a
+// new search would number the trains differently. Tokens are kept in the
+// tenant's store, so replicas sharing a Redis store accept each other's; the
+// bolt and WAL stores keep them in memory only, as they are short-lived.
+
+const searchTokenHeader = "X-Search-Token"
+
+// ErrSearchNotFound is a search token never issued, or expired
+var ErrSearchNotFound = errors.New("search token not found or expired; search again")
+
+// SearchResult is a train found by /tickets, with its position in the results
+type SearchResult struct {
+	Index int `json:"index"` // from 1
+	*Train
+}
+
+// searchToken names a list of train IDs. The same list gets the same token,
+// so repeating a search neither changes its ETag nor adds to the store.
+func searchToken(ids []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(ids, ",")))
+	return hex.EncodeToString(sum[:12])
+}
+
+// searchResultsOf numbers trains and, when tokens are on, sets the token
+// naming them. A search is still answered when its token cannot be kept.
+func searchResultsOf(w http.ResponseWriter, r *http.Request, trains []*Train) []SearchResult {
+	if len(trains) == 0 {
+		return nil
+	}
+	results := make([]SearchResult, len(trains))
+	ids := make([]string, len(trains))
+	for i, train := range trains {
+		results[i] = SearchResult{Index: i + 1, Train: train}
+		ids[i] = train.ID
+	}
+	if config.SearchTokenTTL <= 0 {
+		return results
+	}
+	token := searchToken(ids)
+	err := tenantOf(r).store.SaveSearch(context.WithoutCancel(r.Context()), token, ids, clock().Add(config.SearchTokenTTL))
+	if err != nil {
+		logf(r.Context(), "⚠️  [SEARCH] Cannot keep search token %s: %v", token, err)
+		return results
+	}
+	w.Header().Set(searchTokenHeader, token)
+	return results
+}
+
+// handleBookByResult books the train at index of the results token names,
+// as /book would with its ID. A POST sends its parameters as a JSON body, as
+// for /book/batch.
+func handleBookByResult(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if r.Method == http.MethodPost {
+		var req struct {
+			Token      string `json:"token"`
+			Index      int    `json:"index"`
+			UserID     string `json:"user_id"`
+			Insurance  bool   `json:"insurance"`
+			Pet        bool   `json:"pet"`
+			Accessible bool   `json:"accessible"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		query.Set("token", req.Token)
+		query.Set("index", strconv.Itoa(req.Index))
+		query.Set("user_id", req.UserID)
+		for name, on := range map[string]bool{"insurance": req.Insurance, "pet": req.Pet, "accessible": req.Accessible} {
+			if on {
+				query.Set(name, "true")
+			}
+		}
+	}
+
+	token := query.Get("token")
+	if token == "" {
+		http.Error(w, "token parameter is required", http.StatusBadRequest)
+		return
+	}
+	index, err := strconv.Atoi(query.Get("index"))
+	if err != nil || index < 1 {
+		http.Error(w, "index must be a result number from 1", http.StatusBadRequest)
+		return
+	}
+	ids, err := tenantOf(r).store.SearchResults(r.Context(), token, clock())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if index > len(ids) {
+		http.Error(w, fmt.Sprintf("the search has %d results, so there is no result %d", len(ids), index), http.StatusNotFound)
+		return
+	}
+	logf(r.Context(), "🔢 [SEARCH] Result %d of search %s is %s", index, token, ids[index-1])
+
+	booking := r.Clone(r.Context())
+	query.Del("token")
+	query.Del("index")
+	query.Set("id", ids[index-1])
+	booking.URL.RawQuery = query.Encode()
+	handleBook(w, booking)
+}
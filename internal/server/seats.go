@@ -0,0 +1,219 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Seats: a train's standard class is laid out in coaches of up to seatRows
+// rows of five seats, A B C on one side of the aisle and D F on the other,
+// A and F at the windows. Its total_tickets fill the coaches in order, so
+// the last coach may be shorter. A passenger may choose a seat, such as
+// "1-12F" (coach 1, row 12, seat F), for a standard class ticket of their
+// booking; the seat is kept on the receipt and the check-in manifest, and
+// /trains/{id}/seats maps the seats chosen. Tickets without a chosen seat
+// are seated at check-in. Cancelling with the booking_ref frees the seat.
+
+// Rows of a full coach
+const seatRows = 16
+
+// Seats of a row, the aisle after C; A and F are at the windows
+var (
+	seatLetters  = []string{"A", "B", "C", "D", "F"}
+	windowSeats  = []string{"A", "F"}
+	seatPattern  = regexp.MustCompile(`^(?:(\d{1,2})-)?(\d{1,2})([A-Za-z])$`)
+	ErrSeatTaken = errors.New("seat already taken")
+)
+
+// SeatMap is the seat layout of a train's standard class and the seats chosen
+type SeatMap struct {
+	TrainID string   `json:"train_id"`
+	Letters []string `json:"letters"` // the seats of a row, the aisle after C
+	Windows []string `json:"windows"`
+	Coaches []Coach  `json:"coaches"`
+}
+
+// Coach is one coach of a seat map
+type Coach struct {
+	Number int      `json:"number"`
+	Rows   int      `json:"rows"`
+	Taken  []string `json:"taken"` // seats chosen, e.g. "12F"
+}
+
+// seatLayout lays out train's coaches, none taken
+func seatLayout(train *Train) SeatMap {
+	layout := SeatMap{TrainID: train.ID, Letters: seatLetters, Windows: windowSeats, Coaches: []Coach{}}
+	rows := (train.TotalTickets + len(seatLetters) - 1) / len(seatLetters)
+	for number := 1; rows > 0; number++ {
+		n := min(rows, seatRows)
+		layout.Coaches = append(layout.Coaches, Coach{Number: number, Rows: n, Taken: []string{}})
+		rows -= n
+	}
+	return layout
+}
+
+// parseSeat reads a seat such as "1-12F", or "12F" in coach 1, into its
+// canonical form; ok is false when the train has no such seat
+func parseSeat(layout SeatMap, seat string) (string, bool) {
+	m := seatPattern.FindStringSubmatch(strings.TrimSpace(seat))
+	if m == nil {
+		return "", false
+	}
+	coach := 1
+	if m[1] != "" {
+		coach, _ = strconv.Atoi(m[1])
+	}
+	row, _ := strconv.Atoi(m[2])
+	letter := strings.ToUpper(m[3])
+	if coach < 1 || coach > len(layout.Coaches) || row < 1 || row > layout.Coaches[coach-1].Rows {
+		return "", false
+	}
+	for _, l := range layout.Letters {
+		if l == letter {
+			return fmt.Sprintf("%d-%d%s", coach, row, letter), true
+		}
+	}
+	return "", false
+}
+
+// seatSpace names the place of one seat on a train
+func seatSpace(seat string) string { return "seat:" + seat }
+
+// chosenSeats returns the seats chosen on train's tickets not refunded since
+func chosenSeats(ctx context.Context, store Store, trainID string) (map[string]bool, error) {
+	seats := map[string]bool{}
+	err := store.EachReceipt(ctx, func(receipt Receipt) error {
+		for _, item := range unrefundedItems(&receipt, trainID) {
+			if seat := receipt.Items[item].Seat; seat != "" {
+				seats[seat] = true
+			}
+		}
+		return nil
+	})
+	return seats, err
+}
+
+// handleSeatMap maps a train's seats and those chosen
+func handleSeatMap(w http.ResponseWriter, r *http.Request) {
+	t := tenantOf(r)
+	train, err := t.store.GetTrain(r.Context(), canonicalTrainID(r.PathValue("id")))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	chosen, err := chosenSeats(r.Context(), t.store, train.ID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	layout := seatLayout(train)
+	for i := range layout.Coaches {
+		coach := &layout.Coaches[i]
+		for row := 1; row <= coach.Rows; row++ {
+			for _, letter := range layout.Letters {
+				seat := fmt.Sprintf("%d%s", row, letter)
+				if chosen[fmt.Sprintf("%d-%s", coach.Number, seat)] {
+					coach.Taken = append(coach.Taken, seat)
+				}
+			}
+		}
+	}
+	json.NewEncoder(w).Encode(layout)
+}
+
+// handleChooseSeat gives one ticket of a booking the seat asked for, freeing
+// the seat chosen before; an empty seat only frees it. train_id may be left
+// out for a booking of one train.
+func handleChooseSeat(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		UserID  string `json:"user_id"`
+		TrainID string `json:"train_id"`
+		Seat    string `json:"seat"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if req.UserID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+
+	t := tenantOf(r)
+	ctx := r.Context()
+	receipt, err := userReceipt(r, req.UserID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	item := receiptItem(receipt, req.TrainID)
+	if item < 0 {
+		writeNoTicket(w, req.TrainID)
+		return
+	}
+	ticket := &receipt.Items[item]
+	if err := checkTicket(r, ticket.TrainID, req.UserID); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if ticket.Class != standardClass {
+		http.Error(w, fmt.Sprintf("%s: seats can be chosen in standard class only, not %s", ticket.TrainID, ticket.Class), http.StatusBadRequest)
+		return
+	}
+
+	seat := ""
+	if strings.TrimSpace(req.Seat) != "" {
+		train, err := t.store.GetTrain(ctx, ticket.TrainID)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		layout := seatLayout(train)
+		var ok bool
+		if seat, ok = parseSeat(layout, req.Seat); !ok {
+			http.Error(w, fmt.Sprintf("%s has no seat %q; seats are written coach-row-letter, e.g. 1-12F, in %d coach(es) of rows with seats %s",
+				train.ID, req.Seat, len(layout.Coaches), strings.Join(layout.Letters, " ")), http.StatusBadRequest)
+			return
+		}
+	}
+	if seat == ticket.Seat {
+		json.NewEncoder(w).Encode(receipt)
+		return
+	}
+	if seat != "" {
+		if _, err := t.store.TakeSpace(ctx, ticket.TrainID, seatSpace(seat), 1, 1); err != nil {
+			if errors.Is(err, ErrSoldOut) {
+				err = fmt.Errorf("%s: seat %s: %w", ticket.TrainID, seat, ErrSeatTaken)
+			}
+			writeStoreError(w, err)
+			return
+		}
+	}
+	previous := ticket.Seat
+	ticket.Seat = seat
+	if err := t.store.SaveReceipt(context.WithoutCancel(ctx), *receipt); err != nil {
+		if seat != "" {
+			t.releaseSeat(ctx, ticket.TrainID, seat)
+		}
+		writeStoreError(w, err)
+		return
+	}
+	if previous != "" {
+		t.releaseSeat(ctx, ticket.TrainID, previous)
+	}
+	logf(r.Context(), "💺 [SEAT] %s: seat %q chosen on %s", receipt.Ref, seat, ticket.TrainID)
+	json.NewEncoder(w).Encode(receipt)
+}
+
+// releaseSeat frees a seat chosen on trainID
+func (t *Tenant) releaseSeat(ctx context.Context, trainID, seat string) {
+	if _, err := t.store.TakeSpace(context.WithoutCancel(ctx), trainID, seatSpace(seat), -1, 1); err != nil {
+		logf(ctx, "⚠️  [SEAT] Cannot free seat %s on %s: %v", seat, trainID, err)
+	}
+}
@@ -0,0 +1,870 @@
+// Package server is the train booking HTTP server: cmd/server runs it on its
+// own, and the agent's --embedded-server runs it in-process with demo data.
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/zhangbiao2009/train-booking/internal/plain"
+)
+
+// ResponseWriter wrapper to capture response data
+type responseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	body       *bytes.Buffer
+}
+
+func newResponseWriter(w http.ResponseWriter) *responseWriter {
+	return &responseWriter{
+		ResponseWriter: w,
+		statusCode:     http.StatusOK,
+		body:           &bytes.Buffer{},
+	}
+}
+
+func (rw *responseWriter) WriteHeader(code int) {
+	rw.statusCode = code
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
+
+// Logging middleware; personal data is redacted unless TRAIN_SERVER_LOG_PII is set
+func loggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		// Log incoming request
+		logf(r.Context(), "📥 [REQUEST] %s %s from %s", r.Method, redactURL(r.URL), r.RemoteAddr)
+		if r.URL.RawQuery != "" {
+			logf(r.Context(), "📋 [PARAMS] %s", redactQuery(r.URL.RawQuery))
+		}
+
+		// Wrap response writer to capture response
+		rw := newResponseWriter(w)
+
+		// Call the handler
+		next.ServeHTTP(rw, r)
+
+		// Log response
+		duration := time.Since(start)
+		status := rw.statusCode
+		responseBody := redactBody(rw.Header().Get("Content-Type"), rw.body.Bytes())
+		activity.recordStatus(status)
+
+		if status >= 200 && status < 400 {
+			log.Printf("✅ [RESPONSE] %d - %v%s - Body: %s", status, duration, logTag(rw), responseBody)
+		} else {
+			log.Printf("❌ [RESPONSE] %d - %v%s - Error: %s", status, duration, logTag(rw), responseBody)
+		}
+	})
+}
+
+// Train structure
+type Train struct {
+	ID              string `json:"id"`
+	From            string `json:"from"`
+	To              string `json:"to"`
+	Date            string `json:"date"`
+	DepartureTime   string `json:"departure_time"`
+	ArrivalTime     string `json:"arrival_time"`
+	TotalTickets    int    `json:"total_tickets"`
+	Available       int    `json:"available"`
+	PetsAllowed     bool   `json:"pets_allowed"`
+	AccessibleSeats int    `json:"accessible_seats,omitempty"` // tickets set aside as wheelchair-accessible seats
+	Price           *Price `json:"price,omitempty"`            // set when the tenant prices tickets
+}
+
+// User booking information
+type UserBooking struct {
+	TrainID string `json:"train_id"`
+	Count   int    `json:"count"`
+}
+
+// Demo schedule seeded on startup, running tomorrow and the day after so it
+// stays bookable; existing inventory in a shared store is left untouched
+func demoTrains() []*Train {
+	day1 := clock().AddDate(0, 0, 1).Format("2006-01-02")
+	day2 := clock().AddDate(0, 0, 2).Format("2006-01-02")
+	return []*Train{
+		{ID: "G100", From: "Beijing", To: "Shanghai", Date: day1, DepartureTime: "08:00", ArrivalTime: "13:30", TotalTickets: 100, Available: 100, AccessibleSeats: 4},
+		{ID: "D200", From: "Guangzhou", To: "Shenzhen", Date: day1, DepartureTime: "09:15", ArrivalTime: "10:45", TotalTickets: 80, Available: 80, AccessibleSeats: 2},
+		{ID: "K300", From: "Chengdu", To: "Xi'an", Date: day1, DepartureTime: "18:20", ArrivalTime: "07:40", TotalTickets: 50, Available: 3, PetsAllowed: true, AccessibleSeats: 1},
+		// Add more dates for testing
+		{ID: "G101", From: "Beijing", To: "Shanghai", Date: day2, DepartureTime: "08:00", ArrivalTime: "13:30", TotalTickets: 100, Available: 95, PetsAllowed: true, AccessibleSeats: 4},
+		{ID: "D201", From: "Guangzhou", To: "Shenzhen", Date: day2, DepartureTime: "09:15", ArrivalTime: "10:45", TotalTickets: 80, Available: 75, PetsAllowed: true, AccessibleSeats: 2},
+		{ID: "G102", From: "Shanghai", To: "Beijing", Date: day1, DepartureTime: "14:00", ArrivalTime: "19:30", TotalTickets: 100, Available: 88, AccessibleSeats: 4},
+	}
+}
+
+// Startup messages; converted to ASCII in plain mode
+var console io.Writer = os.Stdout
+
+// Main runs the booking server configured from the environment until
+// SIGINT or SIGTERM
+func Main() {
+	config = loadConfig()
+	startClock(config.Clock)
+	if config.Plain {
+		console = plain.Writer{W: os.Stdout}
+		log.SetOutput(plain.Writer{W: os.Stderr})
+	}
+
+	tlsConfig, err := newTLSConfig(config)
+	if err != nil {
+		log.Fatalf("❌ Invalid TLS configuration: %v", err)
+	}
+
+	// Cancelled on SIGINT/SIGTERM to start a graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	handler, err := newHandler(ctx)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	server := &http.Server{
+		Addr:      config.Addr,
+		Handler:   handler,
+		TLSConfig: tlsConfig,
+	}
+	server.RegisterOnShutdown(inventory.close)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		if tlsConfig != nil {
+			fmt.Fprintf(console, ":bullettrain_side: Ticket server is running on %s (TLS, client auth: %s)\n", config.Addr, config.TLSClientAuth)
+			serveErr <- server.ListenAndServeTLS(config.TLSCert, config.TLSKey)
+			return
+		}
+		fmt.Fprintf(console, ":bullettrain_side: Ticket server is running on %s\n", config.Addr)
+		serveErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		log.Fatal(err)
+	case <-ctx.Done():
+	}
+
+	// Stop accepting connections and let in-flight requests finish
+	log.Printf("🛑 Shutting down, waiting up to %s for in-flight requests", config.ShutdownTimeout)
+	shutdown(server)
+	log.Printf("👋 Server stopped")
+}
+
+// Embedded starts the demo server in this process, for the agent's
+// --embedded-server: the in-memory store seeded with the demo schedule on a
+// random local port, without TLS or tenants, logging to logs. Other settings
+// still come from the environment. stop shuts it down.
+func Embedded(logs io.Writer) (url string, stop func(), err error) {
+	config = loadConfig()
+	config.Store = "memory"
+	config.TenantsFile = ""
+	config.TLSCert, config.TLSKey, config.TLSClientAuth = "", "", clientAuthNone
+	startClock(config.Clock)
+	log.SetOutput(logs)
+	console = io.Discard
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	handler, err := newHandler(ctx)
+	if err != nil {
+		cancel()
+		ln.Close()
+		return "", nil, err
+	}
+	server := &http.Server{Handler: handler}
+	server.RegisterOnShutdown(inventory.close)
+	go server.Serve(ln)
+
+	stop = func() {
+		cancel()
+		shutdown(server)
+	}
+	return "http://" + ln.Addr().String(), stop, nil
+}
+
+// shutdown lets in-flight requests finish, up to the shutdown timeout, then
+// flushes pending events and notifications
+func shutdown(server *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("⚠️  Shutdown incomplete: %v", err)
+	}
+	events.close()
+	notifications.close()
+	telemetry.Shutdown(ctx)
+	closeDiagnostics()
+}
+
+// newHandler sets up the configured store, tenants and background jobs,
+// which run until ctx is done, and returns every route behind the global
+// middleware
+func newHandler(ctx context.Context) (http.Handler, error) {
+	if err := setupTelemetry(); err != nil {
+		return nil, fmt.Errorf("invalid OpenTelemetry configuration: %w", err)
+	}
+	bus, err := newEventBus(config)
+	if err != nil {
+		return nil, fmt.Errorf("cannot configure event publishing: %w", err)
+	}
+	events = bus
+	notifications = newNotifier(config)
+	if err := setupAudit(config.AuditLog); err != nil {
+		return nil, fmt.Errorf("cannot open the audit log: %w", err)
+	}
+	if err := setupDocuments(config.DocumentFormats); err != nil {
+		return nil, fmt.Errorf("invalid TRAIN_SERVER_DOCUMENT_FORMATS: %w", err)
+	}
+	if err := setupChallenger(config.Challenge); err != nil {
+		return nil, fmt.Errorf("invalid TRAIN_SERVER_CHALLENGE: %w", err)
+	}
+
+	store, err := newStore(config.Store)
+	if err != nil {
+		return nil, fmt.Errorf("cannot configure store: %w", err)
+	}
+	if config.WAL != "" {
+		if store, err = logWrites(store, config.WAL, config.WALCompactEvery); err != nil {
+			return nil, fmt.Errorf("cannot open the write-ahead log: %w", err)
+		}
+	}
+	if err := setupTenants(ctx, config, store); err != nil {
+		return nil, fmt.Errorf("cannot set up tenants: %w", err)
+	}
+	if len(tenants) > 0 {
+		log.Printf("🏢 Serving %d tenants, selected by the %s header or host name", len(tenants), config.TenantHeader)
+	}
+	if err := setupJobs(config); err != nil {
+		return nil, fmt.Errorf("invalid job schedule: %w", err)
+	}
+	jobs.start(ctx)
+	if err := setupDiagnostics(config); err != nil {
+		return nil, fmt.Errorf("cannot start the diagnostics server: %w", err)
+	}
+
+	// Every route is logged, recorded for the agent conversation calling it,
+	// recovers from panics and is rate limited
+	rt := newRouter(
+		loggingMiddleware,
+		sessionMiddleware,
+		recoveryMiddleware,
+		rateLimitMiddleware(config.RateLimit, config.RateBurst),
+	)
+	rt.route("/query", handleQuery, http.MethodGet)
+	rt.route("/query/wait", handleQueryWait, http.MethodGet)
+	rt.route("/query/batch", handleQueryBatch, http.MethodGet, http.MethodPost)
+	rt.route("/book", velocityChecked(idempotent(handleBook)), http.MethodGet, http.MethodPost)
+	rt.route("/book/batch", velocityChecked(idempotent(handleBookBatch)), http.MethodGet, http.MethodPost)
+	rt.route("/book-by-result", velocityChecked(idempotent(handleBookByResult)), http.MethodGet, http.MethodPost)
+	rt.route("/cancel", handleCancel, http.MethodGet, http.MethodPost)
+	rt.route("/list", handleList, http.MethodGet)
+	rt.route("/tickets", handleTickets, http.MethodGet)
+	rt.route("/fares/calendar", handleFareCalendar, http.MethodGet)
+	rt.route("/user/tickets", handleUserTickets, http.MethodGet)
+	rt.route("/user/cancellations", handleUserCancellations, http.MethodGet)
+	rt.route("/rebook", velocityChecked(handleRebook), http.MethodGet, http.MethodPost)
+	rt.route("/booking/{ref}/receipt", handleReceipt, http.MethodGet)
+	rt.route("/booking/{ref}/upgrade", handleUpgrade, http.MethodPost)
+	rt.route("/booking/{ref}/meals", handleOrderMeals, http.MethodPost)
+	rt.route("/booking/{ref}/luggage", handleDeclareLuggage, http.MethodPost)
+	rt.route("/booking/{ref}/seat", handleChooseSeat, http.MethodPost)
+	rt.route("/trains/{id}/menu", handleMenu, http.MethodGet)
+	rt.route("/trains/{id}/position", handlePosition, http.MethodGet)
+	rt.route("/trains/{id}/seats", handleSeatMap, http.MethodGet)
+	rt.route("/trains/{id}/punctuality", handlePunctuality, http.MethodGet)
+	rt.route("/support/tickets", handleSupportTickets, http.MethodGet, http.MethodPost)
+	rt.route("/feedback", handleFeedback, http.MethodPost)
+	rt.route("/user/channels", handleUserChannels, http.MethodGet, http.MethodPost)
+	rt.route("/user/travelers", handleUserTravelers, http.MethodGet, http.MethodPost)
+	rt.route("/user/notifications", handleUserNotifications, http.MethodGet)
+	rt.route("/user/notifications/ack", handleAckNotifications, http.MethodPost)
+	rt.route("/hold", velocityChecked(handleHold), http.MethodGet, http.MethodPost)
+	rt.route("/hold/confirm", handleHoldConfirm, http.MethodGet, http.MethodPost)
+	rt.route("/hold/release", handleHoldRelease, http.MethodGet, http.MethodPost)
+	rt.admin("/admin/analytics/routes", handleAnalyticsRoutes, http.MethodGet)
+	rt.admin("/admin/sessions/{id}/actions", handleSessionActions, http.MethodGet)
+	rt.admin("/admin/security/events", handleSecurityEvents, http.MethodGet)
+	rt.admin("/admin/security/clear", handleClearSecurityFlag, http.MethodPost)
+	rt.admin("/admin/book", onBehalf("book", idempotent(handleBook)), http.MethodGet, http.MethodPost)
+	rt.admin("/admin/cancel", onBehalf("cancel", handleCancel), http.MethodGet, http.MethodPost)
+	rt.admin("/admin/reports", handleReports, http.MethodGet)
+	rt.admin("/admin/export/trains.csv", handleExportTrains, http.MethodGet)
+	rt.admin("/admin/export/bookings.csv", handleExportBookings, http.MethodGet)
+	rt.admin("/admin/trains/{id}/manifest", handleManifest, http.MethodGet)
+	rt.admin("/admin/support/tickets", handleAdminSupportTickets, http.MethodGet)
+	rt.admin("/admin/support/tickets/{case}/resolve", handleResolveSupportTicket, http.MethodPost)
+	rt.admin("/admin/feedback", handleAdminFeedback, http.MethodGet)
+	rt.admin("/admin/notifications", handleAdminNotify, http.MethodPost)
+	rt.admin("/admin/notifications/dead-letters", handleDeadLetters, http.MethodGet)
+	rt.admin("/admin/notifications/dead-letters/{id}/redeliver", handleRedeliver, http.MethodPost)
+	rt.admin("/admin/jobs", handleJobs, http.MethodGet)
+	rt.admin("/admin/jobs/{name}/run", handleRunJob, http.MethodPost)
+	rt.route("/admin/dashboard", handleDashboard, http.MethodGet)
+	rt.admin("/admin/dashboard/data", handleDashboardData, http.MethodGet)
+	rt.route("/admin/login", handleDashboardLogin, http.MethodPost)
+	rt.admin("/admin/auth/token", handleAdminToken, http.MethodPost)
+	rt.route("/admin/auth/refresh", handleAdminRefresh, http.MethodPost)
+	rt.route("/admin/auth/logout", handleAdminLogout, http.MethodPost)
+	rt.handle("/openapi.json", http.HandlerFunc(handleOpenAPI), limitsMiddleware([]string{http.MethodGet}))
+
+	cors := corsPolicy{
+		origins: config.CORSOrigins,
+		methods: config.CORSMethods,
+		headers: config.CORSHeaders,
+		maxAge:  config.CORSMaxAge,
+	}
+	// Around every request, routed or not, outermost first
+	global := []middleware{
+		requestIDMiddleware,
+		traceMiddleware,
+		corsMiddleware(cors),
+		adminClientCertMiddleware(config.TLSClientAuth),
+		compressionMiddleware(config.CompressMinBytes),
+		tenantMiddleware,
+	}
+	if config.DevMode {
+		doc, err := parseOpenAPI(openAPISpec)
+		if err != nil {
+			return nil, err
+		}
+		global = append(global, openAPIValidationMiddleware(doc))
+		log.Printf("🧪 Dev mode: validating requests and responses against the OpenAPI document")
+	}
+	return chain(rt, global...), nil
+}
+
+// writeStoreError maps store errors to HTTP responses
+func writeStoreError(w http.ResponseWriter, err error) {
+	var invalid *ValidationError
+	var limit *TrainLimitError
+	var departed *DepartedError
+	switch {
+	case errors.As(err, &invalid):
+		writeValidationError(w, invalid)
+	case errors.As(err, &limit):
+		writeTrainLimitError(w, limit)
+	case errors.As(err, &departed):
+		writeDepartedError(w, departed)
+	case errors.Is(err, ErrTrainNotFound), errors.Is(err, ErrHoldNotFound), errors.Is(err, ErrCancellationNotFound),
+		errors.Is(err, ErrReceiptNotFound), errors.Is(err, ErrCaseNotFound), errors.Is(err, ErrDeadLetterNotFound),
+		errors.Is(err, ErrNoDining), errors.Is(err, ErrTravelerNotFound), errors.Is(err, ErrSearchNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrHoldExpired), errors.Is(err, ErrDeparted):
+		http.Error(w, err.Error(), http.StatusGone)
+	case errors.Is(err, ErrSoldOut), errors.Is(err, ErrNoTicketsToCancel), errors.Is(err, ErrAlreadyRebooked),
+		errors.Is(err, ErrCaseResolved), errors.Is(err, ErrNotAnUpgrade), errors.Is(err, ErrTicketCanceled),
+		errors.Is(err, ErrMealsClosed), errors.Is(err, ErrNoAccessibleSeats), errors.Is(err, ErrSeatTaken):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrCancellationsClosed), errors.Is(err, ErrTicketLimit), errors.Is(err, ErrNoPets),
+		errors.Is(err, ErrDocumentTaken):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, context.Canceled):
+		// The client disconnected or the server is shutting down; nobody reads this
+		log.Printf("⚠️  [STORE] Request abandoned: %v%s", err, logTag(w))
+		http.Error(w, "request cancelled", http.StatusServiceUnavailable)
+	case errors.Is(err, context.DeadlineExceeded):
+		log.Printf("⚠️  [STORE] %v%s", err, logTag(w))
+		http.Error(w, "store timed out", http.StatusGatewayTimeout)
+	default:
+		log.Printf("❌ [STORE] %v%s", err, logTag(w))
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+	}
+}
+
+// writeDepartedError answers 410 with a JSON body whose code, booking_closed,
+// tells a train past its booking cutoff apart from an expired hold
+func writeDepartedError(w http.ResponseWriter, err *DepartedError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusGone)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error":          err.Error(),
+		"code":           "booking_closed",
+		"train_id":       err.TrainID,
+		"date":           err.Date,
+		"departure_time": err.DepartureTime,
+	})
+}
+
+// writeTrainLimitError answers 403 with a JSON body whose code, train_limit,
+// tells the limit apart from other refusals
+func writeTrainLimitError(w http.ResponseWriter, err *TrainLimitError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error":    err.Error(),
+		"code":     "train_limit",
+		"train_id": err.TrainID,
+		"limit":    err.Limit,
+		"held":     err.Held,
+	})
+}
+
+func handleQuery(w http.ResponseWriter, r *http.Request) {
+	id := canonicalTrainID(r.URL.Query().Get("id"))
+	train, err := tenantOf(r).store.GetTrain(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSONWithETag(w, r, train)
+}
+
+// Maximum number of trains in one batch request
+const maxBatchIDs = 100
+
+// canonicalTrainID is a train ID as trains are kept: " g100 " is G100
+func canonicalTrainID(id string) string {
+	return strings.ToUpper(strings.TrimSpace(id))
+}
+
+// canonicalTrainIDs applies canonicalTrainID to each of ids
+func canonicalTrainIDs(ids []string) []string {
+	canonical := make([]string, len(ids))
+	for i, id := range ids {
+		canonical[i] = canonicalTrainID(id)
+	}
+	return canonical
+}
+
+// splitIDs parses a comma-separated ID list, dropping blanks
+func splitIDs(value string) []string {
+	var ids []string
+	for _, id := range strings.Split(value, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Batch query result; IDs that do not exist are listed in not_found
+type BatchQueryResponse struct {
+	Trains   []*Train `json:"trains"`
+	NotFound []string `json:"not_found"`
+}
+
+func handleQueryBatch(w http.ResponseWriter, r *http.Request) {
+	var ids []string
+	if r.Method == http.MethodPost {
+		var req struct {
+			IDs []string `json:"ids"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		ids = canonicalTrainIDs(req.IDs)
+	} else {
+		ids = canonicalTrainIDs(splitIDs(r.URL.Query().Get("ids")))
+	}
+
+	// Validate required parameters
+	if len(ids) == 0 {
+		http.Error(w, "ids parameter is required", http.StatusBadRequest)
+		return
+	}
+	if len(ids) > maxBatchIDs {
+		http.Error(w, fmt.Sprintf("at most %d ids per request", maxBatchIDs), http.StatusBadRequest)
+		return
+	}
+
+	resp := BatchQueryResponse{Trains: []*Train{}, NotFound: []string{}}
+	seen := map[string]bool{}
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		train, err := tenantOf(r).store.GetTrain(r.Context(), id)
+		if errors.Is(err, ErrTrainNotFound) {
+			resp.NotFound = append(resp.NotFound, id)
+			continue
+		}
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		resp.Trains = append(resp.Trains, train)
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+func handleBook(w http.ResponseWriter, r *http.Request) {
+	id := canonicalTrainID(r.URL.Query().Get("id"))
+	userID := r.URL.Query().Get("user_id")
+
+	// Validate required parameters
+	if userID == "" {
+		http.Error(w, "user_id parameter is required", http.StatusBadRequest)
+		return
+	}
+	if id == "" {
+		http.Error(w, "id parameter is required", http.StatusBadRequest)
+		return
+	}
+	insured, err := wantsInsurance(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pet, err := wantsPet(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if pet {
+		if err := checkPets(r.Context(), tenantOf(r).store, []string{id}); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+	}
+	accessible, err := wantsAccessible(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	passengers, err := selfPassenger(r.Context(), tenantOf(r), userID, []string{id})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if accessible {
+		err = tenantOf(r).takeAccessibleSeats(r.Context(), []string{id})
+	} else {
+		err = tenantOf(r).checkOpenSeats(r.Context(), []string{id})
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	train, err := tenantOf(r).store.Book(r.Context(), id, userID)
+	if err != nil {
+		if accessible {
+			tenantOf(r).releaseAccessibleSeats(r.Context(), []string{id})
+		}
+		writeStoreError(w, err)
+		return
+	}
+
+	events.emit(tenantOf(r).ID, EventBookingConfirmed, id, userID, train.Available)
+	if train.Available == 0 {
+		events.emit(tenantOf(r).ID, EventTrainSoldOut, id, "", 0)
+	}
+	tenantOf(r).analytics.recordBooking(train)
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"message":     "booked successfully",
+		"booking_ref": issueReceipt(r, userID, []*Train{train}, ticketOptions{insured: insured, accessible: accessible, travelers: passengers}),
+	})
+}
+
+// Batch booking result, one train entry per ticket booked
+type BatchBookResponse struct {
+	Message    string   `json:"message"`
+	BookingRef string   `json:"booking_ref"`
+	Trains     []*Train `json:"trains"`
+}
+
+// handleBookBatch books several trains for one user with all-or-nothing semantics
+func handleBookBatch(w http.ResponseWriter, r *http.Request) {
+	var ids, labels []string
+	var insured, pet, accessible bool
+	var err error
+	userID := r.URL.Query().Get("user_id")
+	if r.Method == http.MethodPost {
+		var req struct {
+			UserID     string   `json:"user_id"`
+			IDs        []string `json:"ids"`
+			Travelers  []string `json:"travelers"`
+			Insurance  bool     `json:"insurance"`
+			Pet        bool     `json:"pet"`
+			Accessible bool     `json:"accessible"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		userID, ids, labels, insured, pet, accessible = req.UserID, canonicalTrainIDs(req.IDs), req.Travelers, req.Insurance, req.Pet, req.Accessible
+	} else {
+		ids = canonicalTrainIDs(splitIDs(r.URL.Query().Get("ids")))
+		labels = splitIDs(r.URL.Query().Get("travelers"))
+		insured, err = wantsInsurance(r)
+		if err == nil {
+			pet, err = wantsPet(r)
+		}
+		if err == nil {
+			accessible, err = wantsAccessible(r)
+		}
+	}
+	if err == nil {
+		err = checkInsurance(r, insured)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Validate required parameters
+	if userID == "" {
+		http.Error(w, "user_id parameter is required", http.StatusBadRequest)
+		return
+	}
+	if len(ids) == 0 {
+		http.Error(w, "ids parameter is required", http.StatusBadRequest)
+		return
+	}
+	// Named travelers get a ticket each on every train; real-name tickets
+	// name the user when nobody else is
+	var travelers []Traveler
+	if len(labels) == 0 && tenantOf(r).Policies.RealName {
+		labels = []string{selfTraveler}
+	}
+	if len(labels) > 0 {
+		named, err := bookingTravelers(r.Context(), tenantOf(r).store, userID, labels)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		if err := checkPassengers(r.Context(), tenantOf(r), named, ids); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		var each []string
+		for _, id := range ids {
+			for range named {
+				each = append(each, id)
+			}
+			travelers = append(travelers, named...)
+		}
+		ids = each
+	}
+	if len(ids) > maxBatchIDs {
+		http.Error(w, fmt.Sprintf("at most %d ids per request", maxBatchIDs), http.StatusBadRequest)
+		return
+	}
+	if pet {
+		if err := checkPets(r.Context(), tenantOf(r).store, ids); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+	}
+	if accessible {
+		err = tenantOf(r).takeAccessibleSeats(r.Context(), ids)
+	} else {
+		err = tenantOf(r).checkOpenSeats(r.Context(), ids)
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	trains, err := tenantOf(r).store.BookMany(r.Context(), ids, userID)
+	if err != nil {
+		if accessible {
+			tenantOf(r).releaseAccessibleSeats(r.Context(), ids)
+		}
+		writeStoreError(w, err)
+		return
+	}
+
+	for _, train := range trains {
+		events.emit(tenantOf(r).ID, EventBookingConfirmed, train.ID, userID, train.Available)
+		if train.Available == 0 {
+			events.emit(tenantOf(r).ID, EventTrainSoldOut, train.ID, "", 0)
+		}
+		tenantOf(r).analytics.recordBooking(train)
+	}
+
+	json.NewEncoder(w).Encode(BatchBookResponse{
+		Message:    "booked successfully",
+		BookingRef: issueReceipt(r, userID, trains, ticketOptions{insured: insured, accessible: accessible, travelers: travelers}),
+		Trains:     trains,
+	})
+}
+
+func handleCancel(w http.ResponseWriter, r *http.Request) {
+	id := canonicalTrainID(r.URL.Query().Get("id"))
+	userID := r.URL.Query().Get("user_id")
+
+	// Validate required parameters
+	if userID == "" {
+		http.Error(w, "user_id parameter is required", http.StatusBadRequest)
+		return
+	}
+	if id == "" {
+		http.Error(w, "id parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	// With the booking's reference the ticket is refunded by its receipt;
+	// without it, by the user's latest booking of the train not refunded
+	var receipt *Receipt
+	if ref := r.URL.Query().Get("booking_ref"); ref != "" {
+		var err error
+		receipt, err = tenantOf(r).store.Receipt(r.Context(), strings.ToUpper(ref))
+		if err == nil && (receipt.UserID != userID || receiptItem(receipt, id) < 0) {
+			err = ErrReceiptNotFound
+		}
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+	}
+
+	train, record, err := tenantOf(r).store.Cancel(r.Context(), id, userID, clock())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	events.emit(tenantOf(r).ID, EventBookingCancelled, id, userID, train.Available)
+
+	resp := CancelResponse{Message: "cancellation successful", CancellationID: record.ID}
+	if receipt == nil {
+		if receipt, err = latestReceipt(r.Context(), tenantOf(r).store, userID, train.ID); err != nil {
+			logf(r.Context(), "⚠️  [REFUND] Cannot find the booking of the ticket on %s: %v", train.ID, err)
+		}
+	}
+	var items []int
+	if receipt != nil {
+		items = unrefundedItems(receipt, train.ID)
+	}
+	if len(items) > 0 {
+		item := receipt.Items[items[0]]
+		resp.Refund = refundTicket(r, receipt, train, record)
+		// What the ticket took goes back with it, even if its refund could
+		// not be recorded; the booking's luggage with its last ticket
+		if len(items) == 1 {
+			tenantOf(r).releaseLuggage(r.Context(), receipt, train.ID)
+		}
+		if item.Accessible {
+			tenantOf(r).releaseAccessibleSeats(r.Context(), []string{train.ID})
+		}
+		if item.Seat != "" {
+			tenantOf(r).releaseSeat(r.Context(), train.ID, item.Seat)
+		}
+		if resp.Refund != nil {
+			resp.Currency = receipt.Currency
+		}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// CancelResponse confirms a cancellation, with the refund of its booking when
+// there is one
+type CancelResponse struct {
+	Message        string  `json:"message"`
+	CancellationID string  `json:"cancellation_id"`
+	Refund         *Refund `json:"refund,omitempty"`
+	Currency       string  `json:"currency,omitempty"`
+}
+
+func handleList(w http.ResponseWriter, r *http.Request) {
+	trains, err := tenantOf(r).store.ListTrains(r.Context())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	var trainList []*Train
+	for _, train := range trains {
+		if train.Available > 0 {
+			trainList = append(trainList, train)
+		}
+	}
+
+	writeJSONWithETag(w, r, trainList)
+}
+
+func handleTickets(w http.ResponseWriter, r *http.Request) {
+	from := r.URL.Query().Get("from")
+	to := r.URL.Query().Get("to")
+	date := r.URL.Query().Get("date")
+	pets, err := petsFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tenantOf(r).analytics.recordSearch(from, to)
+
+	trains, err := tenantOf(r).store.ListTrains(r.Context())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	var matchingTrains []*Train
+	for _, train := range trains {
+		matches := true
+
+		// Check from parameter (case insensitive)
+		if from != "" && !strings.EqualFold(train.From, from) {
+			matches = false
+		}
+
+		// Check to parameter (case insensitive)
+		if to != "" && !strings.EqualFold(train.To, to) {
+			matches = false
+		}
+
+		// Check date parameter
+		if date != "" && train.Date != date {
+			matches = false
+		}
+
+		// Check pets_allowed parameter
+		if pets != nil && train.PetsAllowed != *pets {
+			matches = false
+		}
+
+		// Only include trains with available tickets
+		if matches && train.Available > 0 {
+			matchingTrains = append(matchingTrains, train)
+		}
+	}
+
+	writeJSONWithETag(w, r, searchResultsOf(w, r, matchingTrains))
+}
+
+func handleUserTickets(w http.ResponseWriter, r *http.Request) {
+	userID := r.URL.Query().Get("user_id")
+
+	// Validate required parameter
+	if userID == "" {
+		http.Error(w, "user_id parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	userBookings, err := tenantOf(r).store.UserBookings(r.Context(), userID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(userBookings)
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Conversation actions: the agent sends X-Session-ID with every call a
+// conversation makes, and the server keeps, per instance, what each
+// conversation did and which bookings it made, so a report that the bot
+// booked the wrong train can be checked against what it actually asked for.
+
+const sessionIDHeader = "X-Session-ID"
+
+// Session IDs are looked up in the path, so they are kept short and plain
+var sessionIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Actions kept per session, the oldest dropped first
+const sessionMaxActions = 200
+
+// SessionAction is one request a conversation made
+type SessionAction struct {
+	Time        time.Time `json:"time"`
+	RequestID   string    `json:"request_id,omitempty"`
+	Method      string    `json:"method"`
+	Path        string    `json:"path"`
+	Query       string    `json:"query,omitempty"` // identifying parameters redacted as in the log
+	Status      int       `json:"status"`
+	BookingRefs []string  `json:"booking_refs,omitempty"` // of the bookings it made
+	Trains      []string  `json:"trains,omitempty"`       // booked by it
+}
+
+type SessionActionsResponse struct {
+	SessionID string          `json:"session_id"`
+	Actions   []SessionAction `json:"actions"`
+}
+
+type sessionLog struct {
+	actions []SessionAction
+	last    time.Time
+}
+
+type sessionActions struct {
+	mu        sync.Mutex
+	retention time.Duration
+	sessions  map[string]*sessionLog
+}
+
+func newSessionActions(retention time.Duration) *sessionActions {
+	return &sessionActions{retention: retention, sessions: map[string]*sessionLog{}}
+}
+
+func (s *sessionActions) record(id string, action SessionAction) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	log := s.sessions[id]
+	if log == nil {
+		// Drop sessions that fell out of the retention window
+		for sid, l := range s.sessions {
+			if action.Time.Sub(l.last) > s.retention {
+				delete(s.sessions, sid)
+			}
+		}
+
+		log = &sessionLog{}
+		s.sessions[id] = log
+	}
+	if len(log.actions) == sessionMaxActions {
+		log.actions = log.actions[1:]
+	}
+	log.actions = append(log.actions, action)
+	log.last = action.Time
+}
+
+// actions of session id, oldest first; nil if none are kept
+func (s *sessionActions) actions(id string) []SessionAction {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	log := s.sessions[id]
+	if log == nil || clock().Sub(log.last) > s.retention {
+		return nil
+	}
+	return append([]SessionAction(nil), log.actions...)
+}
+
+type sessionActionKey struct{}
+
+// sessionMiddleware records the requests that carry a session ID in their
+// tenant's session actions
+func sessionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(sessionIDHeader)
+		if !sessionIDPattern.MatchString(id) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		action := &SessionAction{
+			Time:      clock(),
+			RequestID: requestID(r.Context()),
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			Query:     redactQuery(r.URL.RawQuery),
+		}
+		rw := &headerWriter{ResponseWriter: w}
+		defer func() {
+			action.Status = rw.status()
+			tenantOf(r).sessions.record(id, *action)
+		}()
+		next.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), sessionActionKey{}, action)))
+	})
+}
+
+// sessionOf is the session ID r came with, or ""
+func sessionOf(r *http.Request) string {
+	if id := r.Header.Get(sessionIDHeader); sessionIDPattern.MatchString(id) {
+		return id
+	}
+	return ""
+}
+
+// noteBooking adds a booking to the session action of the request ctx
+// belongs to, if it has one
+func noteBooking(ctx context.Context, ref string, trains []*Train) {
+	action, _ := ctx.Value(sessionActionKey{}).(*SessionAction)
+	if action == nil {
+		return
+	}
+	action.BookingRefs = append(action.BookingRefs, ref)
+	for _, train := range trains {
+		action.Trains = append(action.Trains, train.ID)
+	}
+}
+
+func handleSessionActions(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	actions := tenantOf(r).sessions.actions(id)
+	if actions == nil {
+		http.Error(w, "no actions recorded for this session", http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(SessionActionsResponse{SessionID: id, Actions: actions})
+}
@@ -0,0 +1,904 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Store errors mapped to HTTP status codes by the handlers
+var (
+	ErrTrainNotFound     = errors.New("train not found")
+	ErrSoldOut           = errors.New("no tickets available")
+	ErrNoTicketsToCancel = errors.New("no tickets to cancel for this user")
+	ErrTrainLimit        = errors.New("ticket limit per user on a train reached")
+)
+
+// TrainLimitError refuses a booking that would give a user more tickets on
+// a train than the per-train limit
+type TrainLimitError struct {
+	TrainID string
+	Limit   int
+	Held    int // tickets the user already has on the train
+}
+
+func (e *TrainLimitError) Error() string {
+	return fmt.Sprintf("%s: the ticket limit per user on a train is %d, and %d already booked", e.TrainID, e.Limit, e.Held)
+}
+
+func (e *TrainLimitError) Unwrap() error { return ErrTrainLimit }
+
+// Store owns train inventory and user bookings. Every mutation is atomic in the
+// backing store so several server replicas can share one inventory.
+type Store interface {
+	// Seed inserts trains that do not exist yet, leaving existing inventory untouched
+	Seed(ctx context.Context, trains []*Train) error
+	GetTrain(ctx context.Context, id string) (*Train, error)
+	// ListTrains returns all trains sorted by ID
+	ListTrains(ctx context.Context) ([]*Train, error)
+	// DeleteTrain removes a train and its users' bookings of it
+	DeleteTrain(ctx context.Context, id string) error
+	// SetTrainLimit caps the tickets one user may have on a train, checked
+	// atomically by every booking, rebooking and confirmed hold; 0 for no cap
+	SetTrainLimit(limit int)
+	// Book atomically takes one ticket and returns the updated train
+	Book(ctx context.Context, trainID, userID string) (*Train, error)
+	// BookMany takes one ticket per listed train, all or nothing. The error names the
+	// train that could not be booked.
+	BookMany(ctx context.Context, trainIDs []string, userID string) ([]*Train, error)
+	// Cancel atomically returns one of the user's tickets, keeping a record of the
+	// cancelled booking, and returns the updated train
+	Cancel(ctx context.Context, trainID, userID string, now time.Time) (*Train, *CancelledBooking, error)
+	UserBookings(ctx context.Context, userID string) ([]UserBooking, error)
+	// EachBooking calls fn for every user's booking of every train, stopping at the first error
+	EachBooking(ctx context.Context, fn func(userID string, booking UserBooking) error) error
+	// Cancellations returns how many tickets were cancelled per train ID
+	Cancellations(ctx context.Context) (map[string]int, error)
+	// UpgradeSeat moves a passenger of trainID from class from to class to,
+	// which has seats seats on the train, returning how many are left; from is
+	// standardClass when no upgraded seat is given back
+	UpgradeSeat(ctx context.Context, trainID, from, to string, seats int) (int, error)
+	// TakeSpace takes n of the limit places of one kind on trainID, such as
+	// bicycle spaces, or gives -n back when n is negative, returning how many
+	// are left; ErrSoldOut when fewer than n are left
+	TakeSpace(ctx context.Context, trainID, space string, n, limit int) (int, error)
+
+	// CancelledBookings returns the user's cancellation records, newest first
+	CancelledBookings(ctx context.Context, userID string) ([]CancelledBooking, error)
+	// Rebook books the train of a cancelled booking again and marks the record rebooked
+	Rebook(ctx context.Context, cancellationID, userID string, now time.Time) (*Train, error)
+	// PurgeCancelled deletes cancellation records made before cutoff, returning how many
+	PurgeCancelled(ctx context.Context, cutoff time.Time) (int, error)
+
+	// SaveReceipt keeps the receipt of a booking under its reference
+	SaveReceipt(ctx context.Context, receipt Receipt) error
+	// Receipt returns the receipt with the booking reference ref
+	Receipt(ctx context.Context, ref string) (*Receipt, error)
+	// UserReceipts returns the receipts of userID's bookings, newest first
+	UserReceipts(ctx context.Context, userID string) ([]Receipt, error)
+	// EachReceipt calls fn for every receipt, stopping at the first error
+	EachReceipt(ctx context.Context, fn func(receipt Receipt) error) error
+
+	// OpenCase files a support case, numbering it
+	OpenCase(ctx context.Context, c SupportCase) (*SupportCase, error)
+	// SupportCases returns the cases of userID, or every user's when empty, with
+	// status, or any when empty, newest first
+	SupportCases(ctx context.Context, userID, status string) ([]SupportCase, error)
+	// ResolveCase closes an open case with the resolution
+	ResolveCase(ctx context.Context, number, resolution string, now time.Time) (*SupportCase, error)
+
+	// AddFeedback keeps a rating or complaint, numbering it
+	AddFeedback(ctx context.Context, f Feedback) (*Feedback, error)
+	// Feedback returns all feedback, newest first
+	Feedback(ctx context.Context) ([]Feedback, error)
+
+	// SetChannels replaces the user's notification channels
+	SetChannels(ctx context.Context, userID string, channels []Channel) error
+	Channels(ctx context.Context, userID string) ([]Channel, error)
+	// SetTravelers replaces the user's saved traveler profiles
+	SetTravelers(ctx context.Context, userID string, travelers []Traveler) error
+	Travelers(ctx context.Context, userID string) ([]Traveler, error)
+	// AddNotice keeps a notification for the user's next agent turn
+	AddNotice(ctx context.Context, n Notification) error
+	// Notices returns the user's notifications not yet acknowledged, oldest first
+	Notices(ctx context.Context, userID string) ([]Notification, error)
+	AckNotices(ctx context.Context, userID string, ids []string) error
+	// AddDeadLetter keeps a delivery that failed every attempt
+	AddDeadLetter(ctx context.Context, d DeadLetter) error
+	// DeadLetters returns every dead letter, oldest first
+	DeadLetters(ctx context.Context) ([]DeadLetter, error)
+	// TakeDeadLetter removes and returns a dead letter, for redelivery
+	TakeDeadLetter(ctx context.Context, id string) (*DeadLetter, error)
+
+	// Hold takes one ticket out of inventory until expiresAt
+	Hold(ctx context.Context, trainID, userID string, expiresAt time.Time) (*Hold, error)
+	// ConfirmHold turns an unexpired hold into a booking and returns the train
+	ConfirmHold(ctx context.Context, holdID, userID string, now time.Time) (*Train, error)
+	// ReleaseHold gives the held ticket back to inventory
+	ReleaseHold(ctx context.Context, holdID, userID string) error
+	// ExpireHolds releases every hold that expired before now, returning how many were released
+	ExpireHolds(ctx context.Context, now time.Time) (int, error)
+
+	// SaveSearch keeps the train IDs a search token names, in the order
+	// listed, until expiresAt
+	SaveSearch(ctx context.Context, token string, trainIDs []string, expiresAt time.Time) error
+	// SearchResults returns the train IDs token names, or ErrSearchNotFound
+	// once it expired
+	SearchResults(ctx context.Context, token string, now time.Time) ([]string, error)
+}
+
+func newStore(dsn string) (Store, error) {
+	if dsn == "" || dsn == "memory" {
+		return newMemoryStore(), nil
+	}
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid store %q: %w", dsn, err)
+	}
+	switch u.Scheme {
+	case "redis":
+		return newRedisStore(u)
+	case "bolt":
+		return newBoltStore(u)
+	default:
+		return nil, fmt.Errorf("unsupported store scheme %q", u.Scheme)
+	}
+}
+
+// memoryStore keeps everything in process; suitable for a single replica only
+type memoryStore struct {
+	mu          sync.Mutex
+	trains      map[string]*Train
+	userTickets map[string]map[string]int // userID -> trainID -> count
+	holds       map[string]*Hold
+	cancelled   map[string]int            // trainID -> cancellations
+	upgraded    map[string]map[string]int // trainID -> class -> seats taken
+	spaces      map[string]map[string]int // trainID -> space -> places taken
+	records     map[string]*CancelledBooking
+	receipts    map[string]Receipt
+	userRefs    map[string][]string // userID -> receipt refs, from receipts
+	cases       []*SupportCase      // in filing order
+	feedback    []Feedback          // in order received
+	channels    map[string][]Channel
+	travelers   map[string][]Traveler
+	notices     map[string][]Notification // user -> unacknowledged, oldest first
+	deadLetters []DeadLetter              // oldest first
+	searches    map[string]search         // by token
+	trainLimit  int                       // tickets per user per train; 0 for no cap
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		trains:      map[string]*Train{},
+		userTickets: map[string]map[string]int{},
+		holds:       map[string]*Hold{},
+		cancelled:   map[string]int{},
+		upgraded:    map[string]map[string]int{},
+		spaces:      map[string]map[string]int{},
+		records:     map[string]*CancelledBooking{},
+		receipts:    map[string]Receipt{},
+		userRefs:    map[string][]string{},
+		channels:    map[string][]Channel{},
+		travelers:   map[string][]Traveler{},
+		notices:     map[string][]Notification{},
+		searches:    map[string]search{},
+	}
+}
+
+func (s *memoryStore) Seed(ctx context.Context, trains []*Train) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, train := range trains {
+		if _, ok := s.trains[train.ID]; !ok {
+			t := *train
+			s.trains[train.ID] = &t
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) GetTrain(ctx context.Context, id string) (*Train, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	train, ok := s.trains[id]
+	if !ok {
+		return nil, ErrTrainNotFound
+	}
+	t := *train
+	return &t, nil
+}
+
+func (s *memoryStore) ListTrains(ctx context.Context) ([]*Train, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	trainList := make([]*Train, 0, len(s.trains))
+	for _, train := range s.trains {
+		t := *train
+		trainList = append(trainList, &t)
+	}
+	sort.Slice(trainList, func(i, j int) bool { return trainList[i].ID < trainList[j].ID })
+	return trainList, nil
+}
+
+func (s *memoryStore) SetTrainLimit(limit int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.trainLimit = limit
+}
+
+// checkTrainLimitLocked refuses adding tickets on trainID that would take
+// userID over the per-train limit
+func (s *memoryStore) checkTrainLimitLocked(trainID, userID string, adding int) error {
+	held := s.userTickets[userID][trainID]
+	if s.trainLimit > 0 && held+adding > s.trainLimit {
+		return &TrainLimitError{TrainID: trainID, Limit: s.trainLimit, Held: held}
+	}
+	return nil
+}
+
+func (s *memoryStore) Book(ctx context.Context, trainID, userID string) (*Train, error) {
+	// A client that has gone away must not end up with a booking it never saw
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	train, ok := s.trains[trainID]
+	if !ok {
+		return nil, ErrTrainNotFound
+	}
+	if train.Available <= 0 {
+		return nil, ErrSoldOut
+	}
+	if err := s.checkTrainLimitLocked(trainID, userID, 1); err != nil {
+		return nil, err
+	}
+	train.Available--
+
+	// Initialize user tickets map if not exists
+	if s.userTickets[userID] == nil {
+		s.userTickets[userID] = make(map[string]int)
+	}
+	s.userTickets[userID][trainID]++
+
+	t := *train
+	return &t, nil
+}
+
+func (s *memoryStore) BookMany(ctx context.Context, trainIDs []string, userID string) ([]*Train, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Check the whole itinerary before touching inventory
+	needed := map[string]int{}
+	for _, id := range trainIDs {
+		train, ok := s.trains[id]
+		if !ok {
+			return nil, fmt.Errorf("%s: %w", id, ErrTrainNotFound)
+		}
+		needed[id]++
+		if train.Available < needed[id] {
+			return nil, fmt.Errorf("%s: %w", id, ErrSoldOut)
+		}
+		if err := s.checkTrainLimitLocked(id, userID, needed[id]); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.userTickets[userID] == nil {
+		s.userTickets[userID] = make(map[string]int)
+	}
+	booked := make([]*Train, len(trainIDs))
+	for i, id := range trainIDs {
+		train := s.trains[id]
+		train.Available--
+		s.userTickets[userID][id]++
+		t := *train
+		booked[i] = &t
+	}
+	return booked, nil
+}
+
+func (s *memoryStore) Cancel(ctx context.Context, trainID, userID string, now time.Time) (*Train, *CancelledBooking, error) {
+	return s.cancel(ctx, trainID, userID, newCancellationID(), now)
+}
+
+// cancel is Cancel keeping the record under id
+func (s *memoryStore) cancel(ctx context.Context, trainID, userID, id string, now time.Time) (*Train, *CancelledBooking, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	train, ok := s.trains[trainID]
+	if !ok {
+		return nil, nil, ErrTrainNotFound
+	}
+	if s.userTickets[userID][trainID] <= 0 {
+		return nil, nil, ErrNoTicketsToCancel
+	}
+	train.Available++
+	s.userTickets[userID][trainID]--
+	s.cancelled[trainID]++
+	record := &CancelledBooking{ID: id, TrainID: trainID, UserID: userID, Status: StatusCancelled, CancelledAt: now}
+	s.records[record.ID] = record
+
+	// Remove train from user's bookings if count reaches 0
+	if s.userTickets[userID][trainID] == 0 {
+		delete(s.userTickets[userID], trainID)
+		// Clean up empty user map
+		if len(s.userTickets[userID]) == 0 {
+			delete(s.userTickets, userID)
+		}
+	}
+
+	t := *train
+	c := *record
+	return &t, &c, nil
+}
+
+func (s *memoryStore) UserBookings(ctx context.Context, userID string) ([]UserBooking, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var userBookings []UserBooking
+	for trainID, count := range s.userTickets[userID] {
+		userBookings = append(userBookings, UserBooking{
+			TrainID: trainID,
+			Count:   count,
+		})
+	}
+	sort.Slice(userBookings, func(i, j int) bool { return userBookings[i].TrainID < userBookings[j].TrainID })
+	return userBookings, nil
+}
+
+func (s *memoryStore) EachBooking(ctx context.Context, fn func(userID string, booking UserBooking) error) error {
+	// Copy under the lock so fn can block on a slow client
+	type row struct {
+		userID  string
+		booking UserBooking
+	}
+	s.mu.Lock()
+	var rows []row
+	for userID, tickets := range s.userTickets {
+		for trainID, count := range tickets {
+			rows = append(rows, row{userID, UserBooking{TrainID: trainID, Count: count}})
+		}
+	}
+	s.mu.Unlock()
+
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].userID != rows[j].userID {
+			return rows[i].userID < rows[j].userID
+		}
+		return rows[i].booking.TrainID < rows[j].booking.TrainID
+	})
+	for _, r := range rows {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(r.userID, r.booking); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) DeleteTrain(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.trains[id]; !ok {
+		return ErrTrainNotFound
+	}
+	delete(s.trains, id)
+	delete(s.cancelled, id)
+	delete(s.upgraded, id)
+	delete(s.spaces, id)
+	for userID, tickets := range s.userTickets {
+		delete(tickets, id)
+		if len(tickets) == 0 {
+			delete(s.userTickets, userID)
+		}
+	}
+	for holdID, hold := range s.holds {
+		if hold.TrainID == id {
+			delete(s.holds, holdID)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) UpgradeSeat(ctx context.Context, trainID, from, to string, seats int) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.trains[trainID]; !ok {
+		return 0, ErrTrainNotFound
+	}
+	taken := s.upgraded[trainID]
+	if taken == nil {
+		taken = map[string]int{}
+		s.upgraded[trainID] = taken
+	}
+	if taken[to] >= seats {
+		return 0, ErrSoldOut
+	}
+	if from != standardClass && taken[from] > 0 {
+		taken[from]--
+	}
+	taken[to]++
+	return seats - taken[to], nil
+}
+
+func (s *memoryStore) TakeSpace(ctx context.Context, trainID, space string, n, limit int) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.trains[trainID]; !ok {
+		return 0, ErrTrainNotFound
+	}
+	taken := s.spaces[trainID]
+	if taken == nil {
+		taken = map[string]int{}
+		s.spaces[trainID] = taken
+	}
+	if n > 0 && taken[space]+n > limit {
+		return 0, ErrSoldOut
+	}
+	taken[space] = max(taken[space]+n, 0)
+	return limit - taken[space], nil
+}
+
+func (s *memoryStore) Cancellations(ctx context.Context) (map[string]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counts := make(map[string]int, len(s.cancelled))
+	for id, n := range s.cancelled {
+		counts[id] = n
+	}
+	return counts, nil
+}
+
+func (s *memoryStore) CancelledBookings(ctx context.Context, userID string) ([]CancelledBooking, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var records []CancelledBooking
+	for _, record := range s.records {
+		if record.UserID == userID {
+			records = append(records, *record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].CancelledAt.After(records[j].CancelledAt) })
+	return records, nil
+}
+
+func (s *memoryStore) Rebook(ctx context.Context, cancellationID, userID string, now time.Time) (*Train, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.records[cancellationID]
+	if !ok || record.UserID != userID {
+		return nil, ErrCancellationNotFound
+	}
+	if record.Status != StatusCancelled {
+		return nil, ErrAlreadyRebooked
+	}
+	train, ok := s.trains[record.TrainID]
+	if !ok {
+		return nil, ErrTrainNotFound
+	}
+	if train.Available <= 0 {
+		return nil, ErrSoldOut
+	}
+	if err := s.checkTrainLimitLocked(record.TrainID, userID, 1); err != nil {
+		return nil, err
+	}
+	train.Available--
+	if s.userTickets[userID] == nil {
+		s.userTickets[userID] = make(map[string]int)
+	}
+	s.userTickets[userID][record.TrainID]++
+	record.Status = StatusRebooked
+	record.RebookedAt = &now
+
+	t := *train
+	return &t, nil
+}
+
+func (s *memoryStore) PurgeCancelled(ctx context.Context, cutoff time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	purged := 0
+	for id, record := range s.records {
+		if record.CancelledAt.Before(cutoff) {
+			delete(s.records, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func (s *memoryStore) SaveReceipt(ctx context.Context, receipt Receipt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.receipts[receipt.Ref]; !ok {
+		s.userRefs[receipt.UserID] = append(s.userRefs[receipt.UserID], receipt.Ref)
+	}
+	s.receipts[receipt.Ref] = receipt
+	return nil
+}
+
+func (s *memoryStore) Receipt(ctx context.Context, ref string) (*Receipt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	receipt, ok := s.receipts[ref]
+	if !ok {
+		return nil, ErrReceiptNotFound
+	}
+	copied := copyReceipt(receipt)
+	return &copied, nil
+}
+
+// copyReceipt is receipt with slices of its own; callers may change the
+// copy before saving it again
+func copyReceipt(receipt Receipt) Receipt {
+	receipt.Items = slices.Clone(receipt.Items)
+	receipt.Taxes = slices.Clone(receipt.Taxes)
+	receipt.AddOns = slices.Clone(receipt.AddOns)
+	receipt.Refunds = slices.Clone(receipt.Refunds)
+	return receipt
+}
+
+func (s *memoryStore) UserReceipts(ctx context.Context, userID string) ([]Receipt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	receipts := make([]Receipt, 0, len(s.userRefs[userID]))
+	for _, ref := range s.userRefs[userID] {
+		receipts = append(receipts, copyReceipt(s.receipts[ref]))
+	}
+	sort.Slice(receipts, func(i, j int) bool { return receipts[i].IssuedAt.After(receipts[j].IssuedAt) })
+	return receipts, nil
+}
+
+func (s *memoryStore) EachReceipt(ctx context.Context, fn func(receipt Receipt) error) error {
+	s.mu.Lock()
+	receipts := make([]Receipt, 0, len(s.receipts))
+	for _, receipt := range s.receipts {
+		receipts = append(receipts, receipt)
+	}
+	s.mu.Unlock()
+
+	for _, receipt := range receipts {
+		if err := fn(receipt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) OpenCase(ctx context.Context, c SupportCase) (*SupportCase, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c.Number = caseNumber(len(s.cases) + 1)
+	s.cases = append(s.cases, &c)
+	opened := c
+	return &opened, nil
+}
+
+func (s *memoryStore) SupportCases(ctx context.Context, userID, status string) ([]SupportCase, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var cases []SupportCase
+	for i := len(s.cases) - 1; i >= 0; i-- {
+		c := s.cases[i]
+		if (userID == "" || c.UserID == userID) && (status == "" || c.Status == status) {
+			cases = append(cases, *c)
+		}
+	}
+	return cases, nil
+}
+
+func (s *memoryStore) ResolveCase(ctx context.Context, number, resolution string, now time.Time) (*SupportCase, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range s.cases {
+		if c.Number != number {
+			continue
+		}
+		if c.Status != CaseOpen {
+			return nil, ErrCaseResolved
+		}
+		c.Status = CaseResolved
+		c.Resolution = resolution
+		c.ResolvedAt = &now
+		resolved := *c
+		return &resolved, nil
+	}
+	return nil, ErrCaseNotFound
+}
+
+func (s *memoryStore) AddFeedback(ctx context.Context, f Feedback) (*Feedback, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f.ID = feedbackID(len(s.feedback) + 1)
+	s.feedback = append(s.feedback, f)
+	return &f, nil
+}
+
+func (s *memoryStore) Feedback(ctx context.Context) ([]Feedback, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]Feedback, 0, len(s.feedback))
+	for i := len(s.feedback) - 1; i >= 0; i-- {
+		list = append(list, s.feedback[i])
+	}
+	return list, nil
+}
+
+func (s *memoryStore) SetChannels(ctx context.Context, userID string, channels []Channel) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(channels) == 0 {
+		delete(s.channels, userID)
+		return nil
+	}
+	s.channels[userID] = append([]Channel(nil), channels...)
+	return nil
+}
+
+func (s *memoryStore) Channels(ctx context.Context, userID string) ([]Channel, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Channel(nil), s.channels[userID]...), nil
+}
+
+func (s *memoryStore) SetTravelers(ctx context.Context, userID string, travelers []Traveler) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(travelers) == 0 {
+		delete(s.travelers, userID)
+		return nil
+	}
+	s.travelers[userID] = append([]Traveler(nil), travelers...)
+	return nil
+}
+
+func (s *memoryStore) Travelers(ctx context.Context, userID string) ([]Traveler, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Traveler(nil), s.travelers[userID]...), nil
+}
+
+// search is the train IDs a search token names, until expires
+type search struct {
+	trainIDs []string
+	expires  time.Time
+}
+
+func (s *memoryStore) SaveSearch(ctx context.Context, token string, trainIDs []string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := clock()
+	for t, kept := range s.searches {
+		if now.After(kept.expires) {
+			delete(s.searches, t)
+		}
+	}
+	s.searches[token] = search{trainIDs: append([]string(nil), trainIDs...), expires: expiresAt}
+	return nil
+}
+
+func (s *memoryStore) SearchResults(ctx context.Context, token string, now time.Time) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept, ok := s.searches[token]
+	if !ok || now.After(kept.expires) {
+		return nil, ErrSearchNotFound
+	}
+	return append([]string(nil), kept.trainIDs...), nil
+}
+
+func (s *memoryStore) AddNotice(ctx context.Context, n Notification) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.notices[n.UserID] = append(s.notices[n.UserID], n)
+	return nil
+}
+
+func (s *memoryStore) Notices(ctx context.Context, userID string) ([]Notification, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Notification(nil), s.notices[userID]...), nil
+}
+
+func (s *memoryStore) AckNotices(ctx context.Context, userID string, ids []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	acked := map[string]bool{}
+	for _, id := range ids {
+		acked[id] = true
+	}
+	var kept []Notification
+	for _, n := range s.notices[userID] {
+		if !acked[n.ID] {
+			kept = append(kept, n)
+		}
+	}
+	if len(kept) == 0 {
+		delete(s.notices, userID)
+	} else {
+		s.notices[userID] = kept
+	}
+	return nil
+}
+
+func (s *memoryStore) AddDeadLetter(ctx context.Context, d DeadLetter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deadLetters = append(s.deadLetters, d)
+	return nil
+}
+
+func (s *memoryStore) DeadLetters(ctx context.Context) ([]DeadLetter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]DeadLetter(nil), s.deadLetters...), nil
+}
+
+func (s *memoryStore) TakeDeadLetter(ctx context.Context, id string) (*DeadLetter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, d := range s.deadLetters {
+		if d.ID == id {
+			s.deadLetters = append(s.deadLetters[:i], s.deadLetters[i+1:]...)
+			return &d, nil
+		}
+	}
+	return nil, ErrDeadLetterNotFound
+}
+
+func (s *memoryStore) Hold(ctx context.Context, trainID, userID string, expiresAt time.Time) (*Hold, error) {
+	return s.hold(ctx, trainID, userID, newHoldID(), expiresAt)
+}
+
+// hold is Hold naming the hold id
+func (s *memoryStore) hold(ctx context.Context, trainID, userID, id string, expiresAt time.Time) (*Hold, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	train, ok := s.trains[trainID]
+	if !ok {
+		return nil, ErrTrainNotFound
+	}
+	if train.Available <= 0 {
+		return nil, ErrSoldOut
+	}
+	train.Available--
+
+	hold := &Hold{ID: id, TrainID: trainID, UserID: userID, ExpiresAt: expiresAt}
+	s.holds[hold.ID] = hold
+
+	h := *hold
+	return &h, nil
+}
+
+// releaseHoldLocked returns the held ticket to inventory; callers hold s.mu
+func (s *memoryStore) releaseHoldLocked(hold *Hold) {
+	if train, ok := s.trains[hold.TrainID]; ok {
+		train.Available++
+	}
+	delete(s.holds, hold.ID)
+}
+
+func (s *memoryStore) ConfirmHold(ctx context.Context, holdID, userID string, now time.Time) (*Train, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hold, ok := s.holds[holdID]
+	if !ok || hold.UserID != userID {
+		return nil, ErrHoldNotFound
+	}
+	if !now.Before(hold.ExpiresAt) {
+		s.releaseHoldLocked(hold)
+		return nil, ErrHoldExpired
+	}
+	train, ok := s.trains[hold.TrainID]
+	if !ok {
+		delete(s.holds, holdID)
+		return nil, ErrTrainNotFound
+	}
+
+	// Over the limit the hold is kept, to be released or to expire
+	if err := s.checkTrainLimitLocked(hold.TrainID, userID, 1); err != nil {
+		return nil, err
+	}
+
+	// The ticket already left inventory when the hold was taken
+	delete(s.holds, holdID)
+	if s.userTickets[userID] == nil {
+		s.userTickets[userID] = make(map[string]int)
+	}
+	s.userTickets[userID][hold.TrainID]++
+
+	t := *train
+	return &t, nil
+}
+
+func (s *memoryStore) ReleaseHold(ctx context.Context, holdID, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hold, ok := s.holds[holdID]
+	if !ok || hold.UserID != userID {
+		return ErrHoldNotFound
+	}
+	s.releaseHoldLocked(hold)
+	return nil
+}
+
+func (s *memoryStore) ExpireHolds(ctx context.Context, now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	released := 0
+	for _, hold := range s.holds {
+		if !now.Before(hold.ExpiresAt) {
+			s.releaseHoldLocked(hold)
+			released++
+		}
+	}
+	return released, nil
+}
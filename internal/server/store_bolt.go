@@ -0,0 +1,409 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// The bbolt store keeps the in-memory store's state durable without running
+// a database: trains, bookings, holds, cancellations, receipts, support
+// cases, feedback, notifications and dead letters live in one bbolt file,
+// each tenant in a bucket of its own. Every change is committed before it is
+// acknowledged, writing only the records it touched: each map of the state is
+// a nested bucket with a key per entry, such as a receipt by its reference,
+// and each list one value. A change whose commit fails is undone in memory
+// too, so what the server answers is always what the file holds. The file is
+// locked by one process at a time; replicas should share Redis.
+
+// Bucket of the inventory used without tenants, and of the tenants' buckets
+var (
+	boltInventory = []byte("inventory")
+	boltTenants   = []byte("tenants")
+)
+
+// boltDB is the database shared by the parts of a bbolt store
+type boltDB struct {
+	db    *bolt.DB
+	mu    sync.Mutex // held from a change until it is committed or undone
+	base  *boltPart
+	parts map[string]*boltPart
+}
+
+// boltPart is the state of one tenant, or of the inventory used without
+// tenants, with what the database holds for it
+type boltPart struct {
+	tenant    string
+	memory    *memoryStore
+	committed map[string][]byte // by record key, as boltRecords makes them
+}
+
+// boltStore is one part of a bbolt store
+type boltStore struct {
+	*memoryStore
+	db   *boltDB
+	part *boltPart
+}
+
+// newBoltStore opens the bbolt file named by u, bolt:///abs/path or
+// bolt:rel/path, creating it if need be
+func newBoltStore(u *url.URL) (*boltStore, error) {
+	path := u.Path
+	if u.Opaque != "" {
+		path = u.Opaque
+	}
+	if path == "" {
+		return nil, errors.New("bolt store needs a path, e.g. bolt:///var/lib/train-booking/store.db")
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	b := &boltDB{db: db, parts: map[string]*boltPart{}}
+	if b.base, err = b.load(""); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &boltStore{memoryStore: b.base.memory, db: b, part: b.base}, nil
+}
+
+// namespace returns the part of the database holding one tenant's inventory
+func (s *boltStore) namespace(tenant string) (*boltStore, error) {
+	b := s.db
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	part := b.parts[tenant]
+	if part == nil {
+		var err error
+		if part, err = b.load(tenant); err != nil {
+			return nil, fmt.Errorf("%s: tenant %q: %w", b.db.Path(), tenant, err)
+		}
+		b.parts[tenant] = part
+	}
+	return &boltStore{memoryStore: part.memory, db: b, part: part}, nil
+}
+
+// boltBucket is the bucket of tenant's part, or nil when it has none yet
+func boltBucket(tx *bolt.Tx, tenant string) *bolt.Bucket {
+	if tenant == "" {
+		return tx.Bucket(boltInventory)
+	}
+	if tenants := tx.Bucket(boltTenants); tenants != nil {
+		return tenants.Bucket([]byte(tenant))
+	}
+	return nil
+}
+
+// load reads tenant's part into a memory store
+func (b *boltDB) load(tenant string) (*boltPart, error) {
+	part := &boltPart{tenant: tenant, memory: newMemoryStore(), committed: map[string][]byte{}}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := boltBucket(tx, tenant)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			if v != nil {
+				part.committed[string(k)] = bytes.Clone(v)
+				return nil
+			}
+			return bucket.Bucket(k).ForEach(func(key, value []byte) error {
+				part.committed[string(k)+"\x00"+string(key)] = bytes.Clone(value)
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := part.memory.restore(snapshotOf(part.committed)); err != nil {
+		return nil, err
+	}
+	return part, nil
+}
+
+// boltRecords splits a snapshot into records: an entry of one of its maps is
+// keyed "<map>\x00<key>", a list by its name
+func boltRecords(snapshot []byte) (map[string][]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(snapshot, &fields); err != nil {
+		return nil, err
+	}
+	records := map[string][]byte{}
+	for name, value := range fields {
+		if len(value) == 0 || value[0] != '{' {
+			if !bytes.Equal(value, []byte("null")) {
+				records[name] = value
+			}
+			continue
+		}
+		var entries map[string]json.RawMessage
+		if err := json.Unmarshal(value, &entries); err != nil {
+			return nil, err
+		}
+		for key, entry := range entries {
+			records[name+"\x00"+key] = entry
+		}
+	}
+	return records, nil
+}
+
+// snapshotOf joins records back into the snapshot they were split from
+func snapshotOf(records map[string][]byte) []byte {
+	fields := map[string]any{}
+	for k, v := range records {
+		name, key, isEntry := strings.Cut(k, "\x00")
+		if !isEntry {
+			fields[name] = json.RawMessage(v)
+			continue
+		}
+		entries, _ := fields[name].(map[string]json.RawMessage)
+		if entries == nil {
+			entries = map[string]json.RawMessage{}
+			fields[name] = entries
+		}
+		entries[key] = v
+	}
+	data, _ := json.Marshal(fields)
+	return data
+}
+
+// change starts a change to the store; the function it returns ends it
+func (s *boltStore) change() func() {
+	s.db.mu.Lock()
+	return s.db.mu.Unlock
+}
+
+// commit writes what the change that returned err altered - even a failed
+// change may have, such as dropping an expired hold - and returns err. A
+// commit that fails undoes the change in memory and is the error.
+func (s *boltStore) commit(err error) error {
+	if cerr := s.db.commit(s.part); cerr != nil {
+		if rerr := s.part.memory.restore(snapshotOf(s.part.committed)); rerr != nil {
+			log.Printf("❌ [STORE] Cannot undo a change the store file did not take: %v", rerr)
+		}
+		return fmt.Errorf("writing store file: %w", cerr)
+	}
+	return err
+}
+
+// commit writes the records of part that differ from what the database holds
+func (b *boltDB) commit(part *boltPart) error {
+	data, err := part.memory.marshal()
+	if err != nil {
+		return err
+	}
+	records, err := boltRecords(data)
+	if err != nil {
+		return err
+	}
+	changed := false
+	for k, v := range records {
+		if !bytes.Equal(part.committed[k], v) {
+			changed = true
+		}
+	}
+	for k := range part.committed {
+		if _, ok := records[k]; !ok {
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		var bucket *bolt.Bucket
+		var err error
+		if part.tenant == "" {
+			bucket, err = tx.CreateBucketIfNotExists(boltInventory)
+		} else if bucket, err = tx.CreateBucketIfNotExists(boltTenants); err == nil {
+			bucket, err = bucket.CreateBucketIfNotExists([]byte(part.tenant))
+		}
+		if err != nil {
+			return err
+		}
+		for k := range part.committed {
+			if _, ok := records[k]; ok {
+				continue
+			}
+			name, key, isEntry := strings.Cut(k, "\x00")
+			if !isEntry {
+				err = bucket.Delete([]byte(name))
+			} else if entries := bucket.Bucket([]byte(name)); entries != nil {
+				err = entries.Delete([]byte(key))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		for k, v := range records {
+			if bytes.Equal(part.committed[k], v) {
+				continue
+			}
+			name, key, isEntry := strings.Cut(k, "\x00")
+			if !isEntry {
+				err = bucket.Put([]byte(name), v)
+			} else {
+				var entries *bolt.Bucket
+				if entries, err = bucket.CreateBucketIfNotExists([]byte(name)); err == nil {
+					err = entries.Put([]byte(key), v)
+				}
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	part.committed = records
+	return nil
+}
+
+func (s *boltStore) Seed(ctx context.Context, trains []*Train) error {
+	defer s.change()()
+	return s.commit(s.memoryStore.Seed(ctx, trains))
+}
+
+func (s *boltStore) DeleteTrain(ctx context.Context, id string) error {
+	defer s.change()()
+	return s.commit(s.memoryStore.DeleteTrain(ctx, id))
+}
+
+func (s *boltStore) Book(ctx context.Context, trainID, userID string) (*Train, error) {
+	defer s.change()()
+	train, err := s.memoryStore.Book(ctx, trainID, userID)
+	return train, s.commit(err)
+}
+
+func (s *boltStore) BookMany(ctx context.Context, trainIDs []string, userID string) ([]*Train, error) {
+	defer s.change()()
+	trains, err := s.memoryStore.BookMany(ctx, trainIDs, userID)
+	return trains, s.commit(err)
+}
+
+func (s *boltStore) Cancel(ctx context.Context, trainID, userID string, now time.Time) (*Train, *CancelledBooking, error) {
+	defer s.change()()
+	train, record, err := s.memoryStore.Cancel(ctx, trainID, userID, now)
+	return train, record, s.commit(err)
+}
+
+func (s *boltStore) UpgradeSeat(ctx context.Context, trainID, from, to string, seats int) (int, error) {
+	defer s.change()()
+	left, err := s.memoryStore.UpgradeSeat(ctx, trainID, from, to, seats)
+	return left, s.commit(err)
+}
+
+func (s *boltStore) TakeSpace(ctx context.Context, trainID, space string, n, limit int) (int, error) {
+	defer s.change()()
+	left, err := s.memoryStore.TakeSpace(ctx, trainID, space, n, limit)
+	return left, s.commit(err)
+}
+
+func (s *boltStore) Rebook(ctx context.Context, cancellationID, userID string, now time.Time) (*Train, error) {
+	defer s.change()()
+	train, err := s.memoryStore.Rebook(ctx, cancellationID, userID, now)
+	return train, s.commit(err)
+}
+
+func (s *boltStore) PurgeCancelled(ctx context.Context, cutoff time.Time) (int, error) {
+	defer s.change()()
+	purged, err := s.memoryStore.PurgeCancelled(ctx, cutoff)
+	if err = s.commit(err); err != nil {
+		return 0, err
+	}
+	return purged, nil
+}
+
+func (s *boltStore) SaveReceipt(ctx context.Context, receipt Receipt) error {
+	defer s.change()()
+	return s.commit(s.memoryStore.SaveReceipt(ctx, receipt))
+}
+
+func (s *boltStore) OpenCase(ctx context.Context, c SupportCase) (*SupportCase, error) {
+	defer s.change()()
+	opened, err := s.memoryStore.OpenCase(ctx, c)
+	return opened, s.commit(err)
+}
+
+func (s *boltStore) ResolveCase(ctx context.Context, number, resolution string, now time.Time) (*SupportCase, error) {
+	defer s.change()()
+	resolved, err := s.memoryStore.ResolveCase(ctx, number, resolution, now)
+	return resolved, s.commit(err)
+}
+
+func (s *boltStore) AddFeedback(ctx context.Context, f Feedback) (*Feedback, error) {
+	defer s.change()()
+	added, err := s.memoryStore.AddFeedback(ctx, f)
+	return added, s.commit(err)
+}
+
+func (s *boltStore) SetChannels(ctx context.Context, userID string, channels []Channel) error {
+	defer s.change()()
+	return s.commit(s.memoryStore.SetChannels(ctx, userID, channels))
+}
+
+func (s *boltStore) SetTravelers(ctx context.Context, userID string, travelers []Traveler) error {
+	defer s.change()()
+	return s.commit(s.memoryStore.SetTravelers(ctx, userID, travelers))
+}
+
+func (s *boltStore) AddNotice(ctx context.Context, n Notification) error {
+	defer s.change()()
+	return s.commit(s.memoryStore.AddNotice(ctx, n))
+}
+
+func (s *boltStore) AckNotices(ctx context.Context, userID string, ids []string) error {
+	defer s.change()()
+	return s.commit(s.memoryStore.AckNotices(ctx, userID, ids))
+}
+
+func (s *boltStore) AddDeadLetter(ctx context.Context, d DeadLetter) error {
+	defer s.change()()
+	return s.commit(s.memoryStore.AddDeadLetter(ctx, d))
+}
+
+func (s *boltStore) TakeDeadLetter(ctx context.Context, id string) (*DeadLetter, error) {
+	defer s.change()()
+	d, err := s.memoryStore.TakeDeadLetter(ctx, id)
+	return d, s.commit(err)
+}
+
+func (s *boltStore) Hold(ctx context.Context, trainID, userID string, expiresAt time.Time) (*Hold, error) {
+	defer s.change()()
+	hold, err := s.memoryStore.Hold(ctx, trainID, userID, expiresAt)
+	return hold, s.commit(err)
+}
+
+func (s *boltStore) ConfirmHold(ctx context.Context, holdID, userID string, now time.Time) (*Train, error) {
+	defer s.change()()
+	train, err := s.memoryStore.ConfirmHold(ctx, holdID, userID, now)
+	return train, s.commit(err)
+}
+
+func (s *boltStore) ReleaseHold(ctx context.Context, holdID, userID string) error {
+	defer s.change()()
+	return s.commit(s.memoryStore.ReleaseHold(ctx, holdID, userID))
+}
+
+func (s *boltStore) ExpireHolds(ctx context.Context, now time.Time) (int, error) {
+	defer s.change()()
+	released, err := s.memoryStore.ExpireHolds(ctx, now)
+	if err = s.commit(err); err != nil {
+		return 0, err
+	}
+	return released, nil
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testTrains() []*Train {
+	return []*Train{
+		{ID: "G100", From: "Beijing", To: "Shanghai", Date: "2030-01-01", DepartureTime: "08:00", ArrivalTime: "13:30", TotalTickets: 3, Available: 3},
+		{ID: "D200", From: "Guangzhou", To: "Shenzhen", Date: "2030-01-01", DepartureTime: "09:15", ArrivalTime: "10:45", TotalTickets: 2, Available: 2},
+	}
+}
+
+func openBoltStore(t *testing.T, path string) *boltStore {
+	t.Helper()
+	s, err := newBoltStore(&url.URL{Scheme: "bolt", Path: path})
+	if err != nil {
+		t.Fatalf("opening %s: %v", path, err)
+	}
+	return s
+}
+
+func snapshotBytes(t *testing.T, s *memoryStore) []byte {
+	t.Helper()
+	data, err := s.marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestBoltStoreReopens(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2029, time.December, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		change func(t *testing.T, s *boltStore)
+	}{
+		{"seed", func(t *testing.T, s *boltStore) {}},
+		{"book", func(t *testing.T, s *boltStore) {
+			if _, err := s.Book(ctx, "G100", "alice"); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"book many", func(t *testing.T, s *boltStore) {
+			if _, err := s.BookMany(ctx, []string{"G100", "D200"}, "alice"); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"cancel", func(t *testing.T, s *boltStore) {
+			if _, err := s.Book(ctx, "G100", "alice"); err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := s.Cancel(ctx, "G100", "alice", now); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"hold", func(t *testing.T, s *boltStore) {
+			if _, err := s.Hold(ctx, "D200", "bob", now.Add(time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"expired hold", func(t *testing.T, s *boltStore) {
+			hold, err := s.Hold(ctx, "D200", "bob", now.Add(time.Minute))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.ConfirmHold(ctx, hold.ID, "bob", now.Add(time.Hour)); !errors.Is(err, ErrHoldExpired) {
+				t.Fatalf("confirming an expired hold: %v, want ErrHoldExpired", err)
+			}
+		}},
+		{"receipt", func(t *testing.T, s *boltStore) {
+			if err := s.SaveReceipt(ctx, Receipt{Ref: "BK1", UserID: "alice", IssuedAt: now, Items: []ReceiptItem{{TrainID: "G100"}}}); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"delete train", func(t *testing.T, s *boltStore) {
+			if err := s.DeleteTrain(ctx, "D200"); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "store.db")
+			s := openBoltStore(t, path)
+			if err := s.Seed(ctx, testTrains()); err != nil {
+				t.Fatal(err)
+			}
+			tt.change(t, s)
+			want := snapshotBytes(t, s.memoryStore)
+			s.db.db.Close()
+
+			reopened := openBoltStore(t, path)
+			defer reopened.db.db.Close()
+			if got := snapshotBytes(t, reopened.memoryStore); !bytes.Equal(got, want) {
+				t.Errorf("reopened store holds\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestBoltStoreKeepsTenantsApart(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "store.db")
+	s := openBoltStore(t, path)
+	acme, err := s.namespace("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, part := range []*boltStore{s, acme} {
+		if err := part.Seed(ctx, testTrains()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := acme.Book(ctx, "G100", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	s.db.db.Close()
+
+	s = openBoltStore(t, path)
+	defer s.db.db.Close()
+	acme, err = s.namespace("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name string
+		part *boltStore
+		want int
+	}{
+		{"inventory", s, 3},
+		{"acme", acme, 2},
+	} {
+		train, err := tt.part.GetTrain(ctx, "G100")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if train.Available != tt.want {
+			t.Errorf("%s: G100 has %d tickets left, want %d", tt.name, train.Available, tt.want)
+		}
+	}
+}
+
+func TestBoltStoreUndoesFailedCommit(t *testing.T) {
+	ctx := context.Background()
+	s := openBoltStore(t, filepath.Join(t.TempDir(), "store.db"))
+	if err := s.Seed(ctx, testTrains()); err != nil {
+		t.Fatal(err)
+	}
+	want := snapshotBytes(t, s.memoryStore)
+	s.db.db.Close()
+
+	if _, err := s.Book(ctx, "G100", "alice"); err == nil {
+		t.Fatal("booking with the database closed succeeded")
+	}
+	if got := snapshotBytes(t, s.memoryStore); !bytes.Equal(got, want) {
+		t.Errorf("the booking the file did not take stayed in memory:\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestBoltRecords(t *testing.T) {
+	tests := []struct {
+		name     string
+		snapshot string
+		keys     []string
+	}{
+		{"maps by entry", `{"trains":{"D200":{"id":"D200"},"G100":{"id":"G100"}}}`, []string{"trains\x00D200", "trains\x00G100"}},
+		{"lists whole", `{"feedback":[{"id":"1"}],"trains":{}}`, []string{"feedback"}},
+		{"null left out", `{"trains":null}`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := boltRecords([]byte(tt.snapshot))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != len(tt.keys) {
+				t.Fatalf("records %q, want keys %q", records, tt.keys)
+			}
+			for _, k := range tt.keys {
+				if _, ok := records[k]; !ok {
+					t.Errorf("no record %q in %q", k, records)
+				}
+			}
+			again, err := boltRecords(snapshotOf(records))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range records {
+				if !bytes.Equal(again[k], v) {
+					t.Errorf("record %q is %s after a round trip, want %s", k, again[k], v)
+				}
+			}
+		})
+	}
+}
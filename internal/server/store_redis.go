@@ -0,0 +1,1118 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Redis key layout:
+//
+//	train-booking:trains          set of train IDs
+//	train-booking:train:{id}      hash with the train fields, a cancelled counter,
+//	                              class:{name} counters of upgraded seats taken and
+//	                              space:{name} counters of places taken, e.g. for luggage
+//	train-booking:user:{id}       hash trainID -> ticket count
+//	train-booking:cancellation:{id}          hash of a cancelled booking's record
+//	train-booking:cancellations              sorted set of record IDs by cancellation time
+//	train-booking:cancellations:user:{id}    the same for one user
+//	train-booking:receipt:{ref}   JSON receipt of a booking
+//	train-booking:support:next    number of support cases filed
+//	train-booking:support:case:{number}     JSON support case
+//	train-booking:support:cases   sorted set of case numbers by filing time
+//	train-booking:feedback:next   number of feedback records received
+//	train-booking:feedback        list of JSON feedback records, newest first
+//	train-booking:channels:{user} JSON list of the user's notification channels
+//	train-booking:travelers:{user}           JSON list of the user's saved travelers
+//	train-booking:notices:{user}  hash notification ID -> JSON notification awaiting the agent
+//	train-booking:dead-letters    hash dead letter ID -> JSON dead letter
+//
+// A tenant's keys have the same layout under train-booking:tenant:{tenant}:
+const redisKeyPrefix = "train-booking:"
+
+// Scripts return -1 when the train (or hold) is missing, -2 when the operation is
+// refused, -3 when a hold has expired or a cancellation was already rebooked and
+// -5 when the user would pass the per-train limit (the last ARGV, 0 for none),
+// otherwise the new number of available tickets.
+const (
+	redisSeedScript = `
+if redis.call('EXISTS', KEYS[1]) == 0 then
+  redis.call('HSET', KEYS[1], unpack(ARGV, 2))
+end
+redis.call('SADD', KEYS[2], ARGV[1])
+return 1`
+
+	redisBookScript = `
+if redis.call('EXISTS', KEYS[1]) == 0 then return -1 end
+local available = tonumber(redis.call('HGET', KEYS[1], 'available'))
+if available <= 0 then return -2 end
+local limit = tonumber(ARGV[2])
+if limit > 0 and tonumber(redis.call('HGET', KEYS[2], ARGV[1]) or '0') >= limit then return -5 end
+redis.call('HINCRBY', KEYS[2], ARGV[1], 1)
+return redis.call('HINCRBY', KEYS[1], 'available', -1)`
+
+	// KEYS: train, user, record, cancellations, user's cancellations;
+	// ARGV: train ID, record ID, cancellation time, user ID
+	redisCancelScript = `
+if redis.call('EXISTS', KEYS[1]) == 0 then return -1 end
+local count = tonumber(redis.call('HGET', KEYS[2], ARGV[1]) or '0')
+if count <= 0 then return -2 end
+if count == 1 then
+  redis.call('HDEL', KEYS[2], ARGV[1])
+else
+  redis.call('HINCRBY', KEYS[2], ARGV[1], -1)
+end
+redis.call('HSET', KEYS[3], 'train_id', ARGV[1], 'user_id', ARGV[4], 'status', 'cancelled', 'cancelled_at', ARGV[3])
+redis.call('ZADD', KEYS[4], ARGV[3], ARGV[2])
+redis.call('ZADD', KEYS[5], ARGV[3], ARGV[2])
+redis.call('HINCRBY', KEYS[1], 'cancelled', 1)
+return redis.call('HINCRBY', KEYS[1], 'available', 1)`
+
+	// KEYS: train keys in booking order, then the user key; ARGV: matching train
+	// IDs, then the limit. Returns {code, index} on failure or {0, available...}
+	// on success.
+	redisBookManyScript = `
+local userKey = KEYS[#KEYS]
+local limit = tonumber(ARGV[#ARGV])
+local needed = {}
+for i = 1, #ARGV - 1 do
+  local key = KEYS[i]
+  if redis.call('EXISTS', key) == 0 then return {-1, i - 1} end
+  needed[key] = (needed[key] or 0) + 1
+  if tonumber(redis.call('HGET', key, 'available')) < needed[key] then return {-2, i - 1} end
+  if limit > 0 and tonumber(redis.call('HGET', userKey, ARGV[i]) or '0') + needed[key] > limit then return {-5, i - 1} end
+end
+local result = {0}
+for i = 1, #ARGV - 1 do
+  redis.call('HINCRBY', userKey, ARGV[i], 1)
+  result[i + 1] = redis.call('HINCRBY', KEYS[i], 'available', -1)
+end
+return result`
+
+	// KEYS: record, train, user; ARGV: record ID, user ID, rebooking time, train ID,
+	// limit. -4 when the train no longer exists.
+	redisRebookScript = `
+if redis.call('HGET', KEYS[1], 'user_id') ~= ARGV[2] then return -1 end
+if redis.call('HGET', KEYS[1], 'status') ~= 'cancelled' then return -3 end
+if redis.call('EXISTS', KEYS[2]) == 0 then return -4 end
+if tonumber(redis.call('HGET', KEYS[2], 'available')) <= 0 then return -2 end
+local limit = tonumber(ARGV[5])
+if limit > 0 and tonumber(redis.call('HGET', KEYS[3], ARGV[4]) or '0') >= limit then return -5 end
+redis.call('HSET', KEYS[1], 'status', 'rebooked', 'rebooked_at', ARGV[3])
+redis.call('HINCRBY', KEYS[3], ARGV[4], 1)
+return redis.call('HINCRBY', KEYS[2], 'available', -1)`
+
+	redisPurgeCancelledScript = `
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', '(' .. ARGV[1])
+for _, id in ipairs(ids) do
+  local recordKey = ARGV[2] .. 'cancellation:' .. id
+  local userID = redis.call('HGET', recordKey, 'user_id')
+  if userID then
+    redis.call('ZREM', ARGV[2] .. 'cancellations:user:' .. userID, id)
+  end
+  redis.call('DEL', recordKey)
+  redis.call('ZREM', KEYS[1], id)
+end
+return #ids`
+
+	// KEYS: case; ARGV: resolution, resolution time (RFC 3339).
+	// -1 when the case does not exist, -2 when it is not open.
+	redisResolveCaseScript = `
+local data = redis.call('GET', KEYS[1])
+if not data then return -1 end
+local c = cjson.decode(data)
+if c.status ~= 'open' then return -2 end
+c.status = 'resolved'
+c.resolution = ARGV[1]
+c.resolved_at = ARGV[2]
+redis.call('SET', KEYS[1], cjson.encode(c))
+return 0`
+
+	// KEYS: train; ARGV: counter of the class given back, or empty, counter of
+	// the class taken, seats of that class. Returns the seats taken.
+	redisUpgradeScript = `
+if redis.call('EXISTS', KEYS[1]) == 0 then return -1 end
+local taken = tonumber(redis.call('HGET', KEYS[1], ARGV[2]) or '0')
+if taken >= tonumber(ARGV[3]) then return -2 end
+if ARGV[1] ~= '' and tonumber(redis.call('HGET', KEYS[1], ARGV[1]) or '0') > 0 then
+  redis.call('HINCRBY', KEYS[1], ARGV[1], -1)
+end
+return redis.call('HINCRBY', KEYS[1], ARGV[2], 1)`
+
+	redisSpaceScript = `
+if redis.call('EXISTS', KEYS[1]) == 0 then return -1 end
+local taken = tonumber(redis.call('HGET', KEYS[1], ARGV[1]) or '0')
+local n = tonumber(ARGV[2])
+if n > 0 and taken + n > tonumber(ARGV[3]) then return -2 end
+taken = math.max(taken + n, 0)
+redis.call('HSET', KEYS[1], ARGV[1], taken)
+return taken`
+
+	redisHoldScript = `
+if redis.call('EXISTS', KEYS[1]) == 0 then return -1 end
+local available = tonumber(redis.call('HGET', KEYS[1], 'available'))
+if available <= 0 then return -2 end
+redis.call('HSET', KEYS[2], 'train_id', ARGV[1], 'user_id', ARGV[2], 'expires_at', ARGV[4])
+redis.call('ZADD', KEYS[3], ARGV[4], ARGV[3])
+return redis.call('HINCRBY', KEYS[1], 'available', -1)`
+
+	// Over the limit the hold is kept, to be released or to expire
+	redisConfirmHoldScript = `
+if redis.call('HGET', KEYS[1], 'user_id') ~= ARGV[2] then return -1 end
+local expired = tonumber(redis.call('HGET', KEYS[1], 'expires_at')) <= tonumber(ARGV[3])
+local limit = tonumber(ARGV[5])
+if not expired and limit > 0 and tonumber(redis.call('HGET', KEYS[4], ARGV[4]) or '0') >= limit then return -5 end
+redis.call('DEL', KEYS[1])
+redis.call('ZREM', KEYS[2], ARGV[1])
+if redis.call('EXISTS', KEYS[3]) == 0 then return -1 end
+if expired then
+  redis.call('HINCRBY', KEYS[3], 'available', 1)
+  return -3
+end
+redis.call('HINCRBY', KEYS[4], ARGV[4], 1)
+return tonumber(redis.call('HGET', KEYS[3], 'available'))`
+
+	redisReleaseHoldScript = `
+if redis.call('HGET', KEYS[1], 'user_id') ~= ARGV[2] then return -1 end
+redis.call('DEL', KEYS[1])
+redis.call('ZREM', KEYS[2], ARGV[1])
+if redis.call('EXISTS', KEYS[3]) == 0 then return 0 end
+return redis.call('HINCRBY', KEYS[3], 'available', 1)`
+
+	// Releases one hold, declaring every key it touches; 0 when it was
+	// confirmed or released since it was found expired
+	// Keeps the receipt and indexes it under its user by when it was issued
+	redisSaveReceiptScript = `
+redis.call('SET', KEYS[1], ARGV[1])
+redis.call('ZADD', KEYS[2], ARGV[2], ARGV[3])
+return 1`
+
+	redisExpireHoldScript = `
+local expiresAt = redis.call('HGET', KEYS[1], 'expires_at')
+if not expiresAt or tonumber(expiresAt) > tonumber(ARGV[2]) then return 0 end
+redis.call('DEL', KEYS[1])
+redis.call('ZREM', KEYS[2], ARGV[1])
+if redis.call('EXISTS', KEYS[3]) == 1 then
+  redis.call('HINCRBY', KEYS[3], 'available', 1)
+end
+return 1`
+)
+
+// redisStore shares inventory between server replicas
+type redisStore struct {
+	client     *redisClient
+	prefix     string // redisKeyPrefix, or a tenant's namespace under it
+	trainLimit int    // tickets per user per train; 0 for no cap
+}
+
+// newRedisStore accepts redis://[:password@]host:port[/db]
+func newRedisStore(u *url.URL) (*redisStore, error) {
+	db := 0
+	if path := strings.Trim(u.Path, "/"); path != "" {
+		n, err := strconv.Atoi(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis database %q", path)
+		}
+		db = n
+	}
+	password, _ := u.User.Password()
+	return &redisStore{client: newRedisClient(u.Host, password, db, 16), prefix: redisKeyPrefix}, nil
+}
+
+// namespace returns a store sharing the connection pool whose keys all start
+// with train-booking:tenant:{tenant}:
+func (s *redisStore) namespace(tenant string) *redisStore {
+	return &redisStore{client: s.client, prefix: redisKeyPrefix + "tenant:" + tenant + ":"}
+}
+
+func (s *redisStore) trainSetKey() string           { return s.prefix + "trains" }
+func (s *redisStore) holdSetKey() string            { return s.prefix + "holds" }
+func (s *redisStore) trainKey(id string) string     { return s.prefix + "train:" + id }
+func (s *redisStore) userKey(userID string) string  { return s.prefix + "user:" + userID }
+func (s *redisStore) holdKey(id string) string      { return s.prefix + "hold:" + id }
+func (s *redisStore) cancelKey(id string) string    { return s.prefix + "cancellation:" + id }
+func (s *redisStore) cancelSetKey() string          { return s.prefix + "cancellations" }
+func (s *redisStore) receiptKey(ref string) string  { return s.prefix + "receipt:" + ref }
+func (s *redisStore) caseKey(number string) string  { return s.prefix + "support:case:" + number }
+func (s *redisStore) caseSetKey() string            { return s.prefix + "support:cases" }
+func (s *redisStore) channelKey(id string) string   { return s.prefix + "channels:" + id }
+func (s *redisStore) travelerKey(id string) string  { return s.prefix + "travelers:" + id }
+func (s *redisStore) noticeKey(id string) string    { return s.prefix + "notices:" + id }
+func (s *redisStore) searchKey(token string) string { return s.prefix + "searches:" + token }
+func (s *redisStore) deadLetterKey() string         { return s.prefix + "dead-letters" }
+func (s *redisStore) userCancelKey(userID string) string {
+	return s.prefix + "cancellations:user:" + userID
+}
+func (s *redisStore) userReceiptKey(userID string) string {
+	return s.prefix + "receipts:user:" + userID
+}
+
+func (s *redisStore) eval(ctx context.Context, script string, keys []string, args ...string) (any, error) {
+	cmd := []string{"EVAL", script, strconv.Itoa(len(keys))}
+	cmd = append(cmd, keys...)
+	cmd = append(cmd, args...)
+	return s.client.Do(ctx, cmd...)
+}
+
+func (s *redisStore) Seed(ctx context.Context, trains []*Train) error {
+	for _, train := range trains {
+		args := []string{train.ID,
+			"id", train.ID,
+			"from", train.From,
+			"to", train.To,
+			"date", train.Date,
+			"departure_time", train.DepartureTime,
+			"arrival_time", train.ArrivalTime,
+			"total_tickets", strconv.Itoa(train.TotalTickets),
+			"available", strconv.Itoa(train.Available),
+			"pets_allowed", strconv.FormatBool(train.PetsAllowed),
+			"accessible_seats", strconv.Itoa(train.AccessibleSeats),
+		}
+		if _, err := s.eval(ctx, redisSeedScript, []string{s.trainKey(train.ID), s.trainSetKey()}, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func trainFromHash(fields map[string]string) *Train {
+	total, _ := strconv.Atoi(fields["total_tickets"])
+	available, _ := strconv.Atoi(fields["available"])
+	pets, _ := strconv.ParseBool(fields["pets_allowed"])
+	accessible, _ := strconv.Atoi(fields["accessible_seats"])
+	return &Train{
+		ID:              fields["id"],
+		From:            fields["from"],
+		To:              fields["to"],
+		Date:            fields["date"],
+		DepartureTime:   fields["departure_time"],
+		ArrivalTime:     fields["arrival_time"],
+		TotalTickets:    total,
+		Available:       available,
+		PetsAllowed:     pets,
+		AccessibleSeats: accessible,
+	}
+}
+
+func (s *redisStore) GetTrain(ctx context.Context, id string) (*Train, error) {
+	fields, err := redisStringMap(s.client.Do(ctx, "HGETALL", s.trainKey(id)))
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, ErrTrainNotFound
+	}
+	return trainFromHash(fields), nil
+}
+
+func (s *redisStore) ListTrains(ctx context.Context) ([]*Train, error) {
+	ids, err := redisStrings(s.client.Do(ctx, "SMEMBERS", s.trainSetKey()))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(ids)
+
+	trainList := make([]*Train, 0, len(ids))
+	for _, id := range ids {
+		train, err := s.GetTrain(ctx, id)
+		if errors.Is(err, ErrTrainNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		trainList = append(trainList, train)
+	}
+	return trainList, nil
+}
+
+func (s *redisStore) SetTrainLimit(limit int) {
+	s.trainLimit = limit
+}
+
+// trainLimitError describes the limit userID reached on trainID
+func (s *redisStore) trainLimitError(ctx context.Context, trainID, userID string) error {
+	held, err := s.client.Do(ctx, "HGET", s.userKey(userID), trainID)
+	if err != nil && !errors.Is(err, errRedisNil) {
+		return err
+	}
+	n, _ := strconv.Atoi(fmt.Sprint(held))
+	return &TrainLimitError{TrainID: trainID, Limit: s.trainLimit, Held: n}
+}
+
+func (s *redisStore) Book(ctx context.Context, trainID, userID string) (*Train, error) {
+	result, err := redisInt(s.eval(ctx, redisBookScript, []string{s.trainKey(trainID), s.userKey(userID)}, trainID, strconv.Itoa(s.trainLimit)))
+	if err != nil {
+		return nil, err
+	}
+	switch result {
+	case -1:
+		return nil, ErrTrainNotFound
+	case -2:
+		return nil, ErrSoldOut
+	case -5:
+		return nil, s.trainLimitError(ctx, trainID, userID)
+	}
+
+	train, err := s.GetTrain(ctx, trainID)
+	if err != nil {
+		return nil, err
+	}
+	// Report the availability produced by this mutation rather than a later read
+	train.Available = int(result)
+	return train, nil
+}
+
+func (s *redisStore) BookMany(ctx context.Context, trainIDs []string, userID string) ([]*Train, error) {
+	keys := make([]string, 0, len(trainIDs)+1)
+	for _, id := range trainIDs {
+		keys = append(keys, s.trainKey(id))
+	}
+	keys = append(keys, s.userKey(userID))
+
+	reply, err := s.eval(ctx, redisBookManyScript, keys, append(slices.Clone(trainIDs), strconv.Itoa(s.trainLimit))...)
+	if err != nil {
+		return nil, err
+	}
+	// {0, available per train} when booked, or {code, index of the train}
+	result, ok := reply.([]any)
+	if !ok || len(result) == 0 {
+		return nil, fmt.Errorf("redis: unexpected reply %v", reply)
+	}
+	code, ok := result[0].(int64)
+	if !ok {
+		return nil, fmt.Errorf("redis: unexpected reply %v", reply)
+	}
+	if code != 0 {
+		var at int64 = -1
+		if len(result) == 2 {
+			if i, ok := result[1].(int64); ok && i >= 0 && i < int64(len(trainIDs)) {
+				at = i
+			}
+		}
+		switch {
+		case at < 0:
+			return nil, fmt.Errorf("redis: unexpected reply %v", reply)
+		case code == -1:
+			return nil, fmt.Errorf("%s: %w", trainIDs[at], ErrTrainNotFound)
+		case code == -2:
+			return nil, fmt.Errorf("%s: %w", trainIDs[at], ErrSoldOut)
+		case code == -5:
+			return nil, s.trainLimitError(ctx, trainIDs[at], userID)
+		}
+		return nil, fmt.Errorf("redis: unexpected reply %v", reply)
+	}
+	if len(result) != len(trainIDs)+1 {
+		return nil, fmt.Errorf("redis: unexpected reply %v", reply)
+	}
+	available := make([]int, len(trainIDs))
+	for i, item := range result[1:] {
+		n, ok := item.(int64)
+		if !ok {
+			return nil, fmt.Errorf("redis: unexpected reply %v", reply)
+		}
+		available[i] = int(n)
+	}
+
+	booked := make([]*Train, len(trainIDs))
+	for i, id := range trainIDs {
+		train, err := s.GetTrain(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		train.Available = available[i]
+		booked[i] = train
+	}
+	return booked, nil
+}
+
+func (s *redisStore) Cancel(ctx context.Context, trainID, userID string, now time.Time) (*Train, *CancelledBooking, error) {
+	record := &CancelledBooking{ID: newCancellationID(), TrainID: trainID, UserID: userID, Status: StatusCancelled, CancelledAt: now}
+	keys := []string{s.trainKey(trainID), s.userKey(userID), s.cancelKey(record.ID), s.cancelSetKey(), s.userCancelKey(userID)}
+	result, err := redisInt(s.eval(ctx, redisCancelScript, keys, trainID, record.ID, strconv.FormatInt(now.UnixMilli(), 10), userID))
+	if err != nil {
+		return nil, nil, err
+	}
+	switch result {
+	case -1:
+		return nil, nil, ErrTrainNotFound
+	case -2:
+		return nil, nil, ErrNoTicketsToCancel
+	}
+
+	train, err := s.GetTrain(ctx, trainID)
+	if err != nil {
+		return nil, nil, err
+	}
+	train.Available = int(result)
+	return train, record, nil
+}
+
+func (s *redisStore) UserBookings(ctx context.Context, userID string) ([]UserBooking, error) {
+	counts, err := redisStringMap(s.client.Do(ctx, "HGETALL", s.userKey(userID)))
+	if err != nil {
+		return nil, err
+	}
+
+	var userBookings []UserBooking
+	for trainID, count := range counts {
+		n, _ := strconv.Atoi(count)
+		userBookings = append(userBookings, UserBooking{TrainID: trainID, Count: n})
+	}
+	sort.Slice(userBookings, func(i, j int) bool { return userBookings[i].TrainID < userBookings[j].TrainID })
+	return userBookings, nil
+}
+
+// EachBooking walks user keys with SCAN so large datasets are streamed in batches
+func (s *redisStore) EachBooking(ctx context.Context, fn func(userID string, booking UserBooking) error) error {
+	prefix := s.userKey("")
+	cursor := "0"
+	for {
+		reply, err := s.client.Do(ctx, "SCAN", cursor, "MATCH", prefix+"*", "COUNT", "100")
+		if err != nil {
+			return err
+		}
+		page, ok := reply.([]any)
+		if !ok || len(page) != 2 {
+			return fmt.Errorf("redis: unexpected SCAN reply %v", reply)
+		}
+		cursor, _ = page[0].(string)
+		keys, err := redisStrings(page[1], nil)
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			userID := strings.TrimPrefix(key, prefix)
+			bookings, err := s.UserBookings(ctx, userID)
+			if err != nil {
+				return err
+			}
+			for _, booking := range bookings {
+				if err := fn(userID, booking); err != nil {
+					return err
+				}
+			}
+		}
+
+		if cursor == "0" {
+			return nil
+		}
+	}
+}
+
+func (s *redisStore) DeleteTrain(ctx context.Context, id string) error {
+	removed, err := redisInt(s.client.Do(ctx, "SREM", s.trainSetKey(), id))
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		return ErrTrainNotFound
+	}
+	if _, err := s.client.Do(ctx, "DEL", s.trainKey(id)); err != nil {
+		return err
+	}
+
+	var passengers []string
+	err = s.EachBooking(ctx, func(userID string, booking UserBooking) error {
+		if booking.TrainID == id {
+			passengers = append(passengers, userID)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, userID := range passengers {
+		if _, err := s.client.Do(ctx, "HDEL", s.userKey(userID), id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *redisStore) Cancellations(ctx context.Context) (map[string]int, error) {
+	ids, err := redisStrings(s.client.Do(ctx, "SMEMBERS", s.trainSetKey()))
+	if err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int{}
+	for _, id := range ids {
+		reply, err := s.client.Do(ctx, "HGET", s.trainKey(id), "cancelled")
+		if errors.Is(err, errRedisNil) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		value, _ := reply.(string)
+		counts[id], _ = strconv.Atoi(value)
+	}
+	return counts, nil
+}
+
+func (s *redisStore) UpgradeSeat(ctx context.Context, trainID, from, to string, seats int) (int, error) {
+	release := ""
+	if from != standardClass {
+		release = "class:" + from
+	}
+	taken, err := redisInt(s.eval(ctx, redisUpgradeScript, []string{s.trainKey(trainID)}, release, "class:"+to, strconv.Itoa(seats)))
+	if err != nil {
+		return 0, err
+	}
+	switch taken {
+	case -1:
+		return 0, ErrTrainNotFound
+	case -2:
+		return 0, ErrSoldOut
+	}
+	return seats - int(taken), nil
+}
+
+func (s *redisStore) TakeSpace(ctx context.Context, trainID, space string, n, limit int) (int, error) {
+	taken, err := redisInt(s.eval(ctx, redisSpaceScript, []string{s.trainKey(trainID)}, "space:"+space, strconv.Itoa(n), strconv.Itoa(limit)))
+	if err != nil {
+		return 0, err
+	}
+	switch taken {
+	case -1:
+		return 0, ErrTrainNotFound
+	case -2:
+		return 0, ErrSoldOut
+	}
+	return limit - int(taken), nil
+}
+
+func (s *redisStore) CancelledBookings(ctx context.Context, userID string) ([]CancelledBooking, error) {
+	ids, err := redisStrings(s.client.Do(ctx, "ZREVRANGE", s.userCancelKey(userID), "0", "-1"))
+	if err != nil {
+		return nil, err
+	}
+
+	var records []CancelledBooking
+	for _, id := range ids {
+		fields, err := redisStringMap(s.client.Do(ctx, "HGETALL", s.cancelKey(id)))
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) == 0 {
+			// Purged since the ZREVRANGE
+			continue
+		}
+		cancelledAt, _ := strconv.ParseInt(fields["cancelled_at"], 10, 64)
+		record := CancelledBooking{
+			ID:          id,
+			TrainID:     fields["train_id"],
+			UserID:      fields["user_id"],
+			Status:      fields["status"],
+			CancelledAt: time.UnixMilli(cancelledAt).UTC(),
+		}
+		if ms, err := strconv.ParseInt(fields["rebooked_at"], 10, 64); err == nil {
+			rebookedAt := time.UnixMilli(ms).UTC()
+			record.RebookedAt = &rebookedAt
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func (s *redisStore) Rebook(ctx context.Context, cancellationID, userID string, now time.Time) (*Train, error) {
+	trainID, err := s.client.Do(ctx, "HGET", s.cancelKey(cancellationID), "train_id")
+	if errors.Is(err, errRedisNil) {
+		return nil, ErrCancellationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{s.cancelKey(cancellationID), s.trainKey(trainID.(string)), s.userKey(userID)}
+	result, err := redisInt(s.eval(ctx, redisRebookScript, keys, cancellationID, userID, strconv.FormatInt(now.UnixMilli(), 10), trainID.(string), strconv.Itoa(s.trainLimit)))
+	if err != nil {
+		return nil, err
+	}
+	switch result {
+	case -1:
+		return nil, ErrCancellationNotFound
+	case -2:
+		return nil, ErrSoldOut
+	case -3:
+		return nil, ErrAlreadyRebooked
+	case -4:
+		return nil, ErrTrainNotFound
+	case -5:
+		return nil, s.trainLimitError(ctx, trainID.(string), userID)
+	}
+
+	train, err := s.GetTrain(ctx, trainID.(string))
+	if err != nil {
+		return nil, err
+	}
+	train.Available = int(result)
+	return train, nil
+}
+
+func (s *redisStore) PurgeCancelled(ctx context.Context, cutoff time.Time) (int, error) {
+	purged, err := redisInt(s.eval(ctx, redisPurgeCancelledScript, []string{s.cancelSetKey()}, strconv.FormatInt(cutoff.UnixMilli(), 10), s.prefix))
+	return int(purged), err
+}
+
+func (s *redisStore) SaveReceipt(ctx context.Context, receipt Receipt) error {
+	data, err := json.Marshal(receipt)
+	if err != nil {
+		return err
+	}
+	keys := []string{s.receiptKey(receipt.Ref), s.userReceiptKey(receipt.UserID)}
+	_, err = s.eval(ctx, redisSaveReceiptScript, keys, string(data), strconv.FormatInt(receipt.IssuedAt.UnixMilli(), 10), receipt.Ref)
+	return err
+}
+
+func (s *redisStore) Receipt(ctx context.Context, ref string) (*Receipt, error) {
+	reply, err := s.client.Do(ctx, "GET", s.receiptKey(ref))
+	if errors.Is(err, errRedisNil) {
+		return nil, ErrReceiptNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, _ := reply.(string)
+	var receipt Receipt
+	if err := json.Unmarshal([]byte(data), &receipt); err != nil {
+		return nil, fmt.Errorf("redis: invalid receipt %s: %w", ref, err)
+	}
+	return &receipt, nil
+}
+
+func (s *redisStore) UserReceipts(ctx context.Context, userID string) ([]Receipt, error) {
+	refs, err := redisStrings(s.client.Do(ctx, "ZREVRANGE", s.userReceiptKey(userID), "0", "-1"))
+	if err != nil {
+		return nil, err
+	}
+
+	receipts := make([]Receipt, 0, len(refs))
+	for _, ref := range refs {
+		receipt, err := s.Receipt(ctx, ref)
+		if errors.Is(err, ErrReceiptNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		receipts = append(receipts, *receipt)
+	}
+	return receipts, nil
+}
+
+func (s *redisStore) EachReceipt(ctx context.Context, fn func(receipt Receipt) error) error {
+	prefix := s.receiptKey("")
+	cursor := "0"
+	for {
+		reply, err := s.client.Do(ctx, "SCAN", cursor, "MATCH", prefix+"*", "COUNT", "100")
+		if err != nil {
+			return err
+		}
+		page, ok := reply.([]any)
+		if !ok || len(page) != 2 {
+			return fmt.Errorf("redis: unexpected SCAN reply %v", reply)
+		}
+		cursor, _ = page[0].(string)
+		keys, err := redisStrings(page[1], nil)
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			receipt, err := s.Receipt(ctx, strings.TrimPrefix(key, prefix))
+			if errors.Is(err, ErrReceiptNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err := fn(*receipt); err != nil {
+				return err
+			}
+		}
+
+		if cursor == "0" {
+			return nil
+		}
+	}
+}
+
+func (s *redisStore) OpenCase(ctx context.Context, c SupportCase) (*SupportCase, error) {
+	n, err := redisInt(s.client.Do(ctx, "INCR", s.prefix+"support:next"))
+	if err != nil {
+		return nil, err
+	}
+	c.Number = caseNumber(int(n))
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.client.Do(ctx, "SET", s.caseKey(c.Number), string(data)); err != nil {
+		return nil, err
+	}
+	if _, err := s.client.Do(ctx, "ZADD", s.caseSetKey(), strconv.FormatInt(c.CreatedAt.UnixMilli(), 10), c.Number); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (s *redisStore) supportCase(ctx context.Context, number string) (*SupportCase, error) {
+	reply, err := s.client.Do(ctx, "GET", s.caseKey(number))
+	if errors.Is(err, errRedisNil) {
+		return nil, ErrCaseNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, _ := reply.(string)
+	var c SupportCase
+	if err := json.Unmarshal([]byte(data), &c); err != nil {
+		return nil, fmt.Errorf("redis: invalid support case %s: %w", number, err)
+	}
+	return &c, nil
+}
+
+func (s *redisStore) SupportCases(ctx context.Context, userID, status string) ([]SupportCase, error) {
+	numbers, err := redisStrings(s.client.Do(ctx, "ZREVRANGE", s.caseSetKey(), "0", "-1"))
+	if err != nil {
+		return nil, err
+	}
+
+	var cases []SupportCase
+	for _, number := range numbers {
+		c, err := s.supportCase(ctx, number)
+		if errors.Is(err, ErrCaseNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if (userID == "" || c.UserID == userID) && (status == "" || c.Status == status) {
+			cases = append(cases, *c)
+		}
+	}
+	return cases, nil
+}
+
+func (s *redisStore) ResolveCase(ctx context.Context, number, resolution string, now time.Time) (*SupportCase, error) {
+	result, err := redisInt(s.eval(ctx, redisResolveCaseScript, []string{s.caseKey(number)}, resolution, now.UTC().Format(time.RFC3339Nano)))
+	if err != nil {
+		return nil, err
+	}
+	switch result {
+	case -1:
+		return nil, ErrCaseNotFound
+	case -2:
+		return nil, ErrCaseResolved
+	}
+	return s.supportCase(ctx, number)
+}
+
+func (s *redisStore) AddFeedback(ctx context.Context, f Feedback) (*Feedback, error) {
+	n, err := redisInt(s.client.Do(ctx, "INCR", s.prefix+"feedback:next"))
+	if err != nil {
+		return nil, err
+	}
+	f.ID = feedbackID(int(n))
+	data, err := json.Marshal(f)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.client.Do(ctx, "LPUSH", s.prefix+"feedback", string(data)); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func (s *redisStore) Feedback(ctx context.Context) ([]Feedback, error) {
+	items, err := redisStrings(s.client.Do(ctx, "LRANGE", s.prefix+"feedback", "0", "-1"))
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Feedback, 0, len(items))
+	for _, item := range items {
+		var f Feedback
+		if err := json.Unmarshal([]byte(item), &f); err != nil {
+			return nil, fmt.Errorf("redis: invalid feedback record: %w", err)
+		}
+		list = append(list, f)
+	}
+	return list, nil
+}
+
+func (s *redisStore) SetChannels(ctx context.Context, userID string, channels []Channel) error {
+	if len(channels) == 0 {
+		_, err := s.client.Do(ctx, "DEL", s.channelKey(userID))
+		return err
+	}
+	data, err := json.Marshal(channels)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.Do(ctx, "SET", s.channelKey(userID), string(data))
+	return err
+}
+
+func (s *redisStore) Channels(ctx context.Context, userID string) ([]Channel, error) {
+	reply, err := s.client.Do(ctx, "GET", s.channelKey(userID))
+	if errors.Is(err, errRedisNil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, _ := reply.(string)
+	var channels []Channel
+	if err := json.Unmarshal([]byte(data), &channels); err != nil {
+		return nil, fmt.Errorf("redis: invalid channels of %s: %w", userID, err)
+	}
+	return channels, nil
+}
+
+func (s *redisStore) SetTravelers(ctx context.Context, userID string, travelers []Traveler) error {
+	if len(travelers) == 0 {
+		_, err := s.client.Do(ctx, "DEL", s.travelerKey(userID))
+		return err
+	}
+	data, err := json.Marshal(travelers)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.Do(ctx, "SET", s.travelerKey(userID), string(data))
+	return err
+}
+
+func (s *redisStore) Travelers(ctx context.Context, userID string) ([]Traveler, error) {
+	reply, err := s.client.Do(ctx, "GET", s.travelerKey(userID))
+	if errors.Is(err, errRedisNil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, _ := reply.(string)
+	var travelers []Traveler
+	if err := json.Unmarshal([]byte(data), &travelers); err != nil {
+		return nil, fmt.Errorf("redis: invalid travelers of %s: %w", userID, err)
+	}
+	return travelers, nil
+}
+
+func (s *redisStore) SaveSearch(ctx context.Context, token string, trainIDs []string, expiresAt time.Time) error {
+	ttl := expiresAt.Sub(clock()).Milliseconds()
+	if ttl <= 0 {
+		return nil
+	}
+	_, err := s.client.Do(ctx, "SET", s.searchKey(token), strings.Join(trainIDs, ","), "PX", strconv.FormatInt(ttl, 10))
+	return err
+}
+
+// SearchResults leaves expiry to Redis, which drops the key at expiresAt
+func (s *redisStore) SearchResults(ctx context.Context, token string, now time.Time) ([]string, error) {
+	reply, err := s.client.Do(ctx, "GET", s.searchKey(token))
+	if errors.Is(err, errRedisNil) {
+		return nil, ErrSearchNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, _ := reply.(string)
+	return strings.Split(data, ","), nil
+}
+
+func (s *redisStore) AddNotice(ctx context.Context, n Notification) error {
+	data, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.Do(ctx, "HSET", s.noticeKey(n.UserID), n.ID, string(data))
+	return err
+}
+
+func (s *redisStore) Notices(ctx context.Context, userID string) ([]Notification, error) {
+	items, err := redisStrings(s.client.Do(ctx, "HVALS", s.noticeKey(userID)))
+	if err != nil {
+		return nil, err
+	}
+	var notices []Notification
+	for _, item := range items {
+		var n Notification
+		if err := json.Unmarshal([]byte(item), &n); err != nil {
+			return nil, fmt.Errorf("redis: invalid notification: %w", err)
+		}
+		notices = append(notices, n)
+	}
+	sort.Slice(notices, func(i, j int) bool { return notices[i].CreatedAt.Before(notices[j].CreatedAt) })
+	return notices, nil
+}
+
+func (s *redisStore) AckNotices(ctx context.Context, userID string, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := s.client.Do(ctx, append([]string{"HDEL", s.noticeKey(userID)}, ids...)...)
+	return err
+}
+
+func (s *redisStore) AddDeadLetter(ctx context.Context, d DeadLetter) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.Do(ctx, "HSET", s.deadLetterKey(), d.ID, string(data))
+	return err
+}
+
+func (s *redisStore) DeadLetters(ctx context.Context) ([]DeadLetter, error) {
+	items, err := redisStrings(s.client.Do(ctx, "HVALS", s.deadLetterKey()))
+	if err != nil {
+		return nil, err
+	}
+	var letters []DeadLetter
+	for _, item := range items {
+		var d DeadLetter
+		if err := json.Unmarshal([]byte(item), &d); err != nil {
+			return nil, fmt.Errorf("redis: invalid dead letter: %w", err)
+		}
+		letters = append(letters, d)
+	}
+	sort.Slice(letters, func(i, j int) bool { return letters[i].FailedAt.Before(letters[j].FailedAt) })
+	return letters, nil
+}
+
+func (s *redisStore) TakeDeadLetter(ctx context.Context, id string) (*DeadLetter, error) {
+	reply, err := s.client.Do(ctx, "HGET", s.deadLetterKey(), id)
+	if errors.Is(err, errRedisNil) {
+		return nil, ErrDeadLetterNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Only the replica whose HDEL removes it redelivers the letter
+	removed, err := redisInt(s.client.Do(ctx, "HDEL", s.deadLetterKey(), id))
+	if err != nil {
+		return nil, err
+	}
+	if removed == 0 {
+		return nil, ErrDeadLetterNotFound
+	}
+	data, _ := reply.(string)
+	var d DeadLetter
+	if err := json.Unmarshal([]byte(data), &d); err != nil {
+		return nil, fmt.Errorf("redis: invalid dead letter %s: %w", id, err)
+	}
+	return &d, nil
+}
+
+func (s *redisStore) Hold(ctx context.Context, trainID, userID string, expiresAt time.Time) (*Hold, error) {
+	hold := &Hold{ID: newHoldID(), TrainID: trainID, UserID: userID, ExpiresAt: expiresAt}
+	keys := []string{s.trainKey(trainID), s.holdKey(hold.ID), s.holdSetKey()}
+	result, err := redisInt(s.eval(ctx, redisHoldScript, keys, trainID, userID, hold.ID, strconv.FormatInt(expiresAt.UnixMilli(), 10)))
+	if err != nil {
+		return nil, err
+	}
+	switch result {
+	case -1:
+		return nil, ErrTrainNotFound
+	case -2:
+		return nil, ErrSoldOut
+	}
+	return hold, nil
+}
+
+func (s *redisStore) ConfirmHold(ctx context.Context, holdID, userID string, now time.Time) (*Train, error) {
+	trainID, err := s.client.Do(ctx, "HGET", s.holdKey(holdID), "train_id")
+	if errors.Is(err, errRedisNil) {
+		return nil, ErrHoldNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{s.holdKey(holdID), s.holdSetKey(), s.trainKey(trainID.(string)), s.userKey(userID)}
+	result, err := redisInt(s.eval(ctx, redisConfirmHoldScript, keys, holdID, userID, strconv.FormatInt(now.UnixMilli(), 10), trainID.(string), strconv.Itoa(s.trainLimit)))
+	if err != nil {
+		return nil, err
+	}
+	switch result {
+	case -1:
+		return nil, ErrHoldNotFound
+	case -3:
+		return nil, ErrHoldExpired
+	case -5:
+		return nil, s.trainLimitError(ctx, trainID.(string), userID)
+	}
+
+	train, err := s.GetTrain(ctx, trainID.(string))
+	if err != nil {
+		return nil, err
+	}
+	train.Available = int(result)
+	return train, nil
+}
+
+func (s *redisStore) ReleaseHold(ctx context.Context, holdID, userID string) error {
+	trainID, err := s.client.Do(ctx, "HGET", s.holdKey(holdID), "train_id")
+	if errors.Is(err, errRedisNil) {
+		return ErrHoldNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	keys := []string{s.holdKey(holdID), s.holdSetKey(), s.trainKey(trainID.(string))}
+	result, err := redisInt(s.eval(ctx, redisReleaseHoldScript, keys, holdID, userID))
+	if err != nil {
+		return err
+	}
+	if result == -1 {
+		return ErrHoldNotFound
+	}
+	return nil
+}
+
+// ExpireHolds finds the expired holds, then releases each in a script of its
+// own, since a script may only touch the keys it is given and which train
+// a hold returns its ticket to is only known once the hold is read
+func (s *redisStore) ExpireHolds(ctx context.Context, now time.Time) (int, error) {
+	nowMillis := strconv.FormatInt(now.UnixMilli(), 10)
+	ids, err := redisStrings(s.client.Do(ctx, "ZRANGEBYSCORE", s.holdSetKey(), "-inf", nowMillis))
+	if err != nil {
+		return 0, err
+	}
+	released := 0
+	for _, id := range ids {
+		trainID, err := s.client.Do(ctx, "HGET", s.holdKey(id), "train_id")
+		if errors.Is(err, errRedisNil) {
+			// Gone already; hold IDs are never reused
+			if _, err := s.client.Do(ctx, "ZREM", s.holdSetKey(), id); err != nil {
+				return released, err
+			}
+			continue
+		}
+		if err != nil {
+			return released, err
+		}
+		train, ok := trainID.(string)
+		if !ok {
+			return released, fmt.Errorf("redis: unexpected train_id of hold %s: %v", id, trainID)
+		}
+		keys := []string{s.holdKey(id), s.holdSetKey(), s.trainKey(train)}
+		n, err := redisInt(s.eval(ctx, redisExpireHoldScript, keys, id, nowMillis))
+		if err != nil {
+			return released, err
+		}
+		released += int(n)
+	}
+	return released, nil
+}
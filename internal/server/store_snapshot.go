@@ -0,0 +1,84 @@
+package server
+
+import "encoding/json"
+
+// Snapshots: the whole state of an in-memory store, as the write-ahead log's
+// snapshot and the bbolt store keep it.
+
+// memorySnapshot is everything a memoryStore keeps
+type memorySnapshot struct {
+	Trains      map[string]*Train            `json:"trains"`
+	UserTickets map[string]map[string]int    `json:"user_tickets,omitempty"`
+	Holds       map[string]*Hold             `json:"holds,omitempty"`
+	Cancelled   map[string]int               `json:"cancelled,omitempty"`
+	Upgraded    map[string]map[string]int    `json:"upgraded,omitempty"`
+	Spaces      map[string]map[string]int    `json:"spaces,omitempty"`
+	Records     map[string]*CancelledBooking `json:"cancellations,omitempty"`
+	Receipts    map[string]Receipt           `json:"receipts,omitempty"`
+	Cases       []*SupportCase               `json:"support_cases,omitempty"`
+	Feedback    []Feedback                   `json:"feedback,omitempty"`
+	Channels    map[string][]Channel         `json:"channels,omitempty"`
+	Travelers   map[string][]Traveler        `json:"travelers,omitempty"`
+	Notices     map[string][]Notification    `json:"notifications,omitempty"`
+	DeadLetters []DeadLetter                 `json:"dead_letters,omitempty"`
+}
+
+// marshal encodes the store's state
+func (s *memoryStore) marshal() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return json.Marshal(memorySnapshot{
+		Trains:      s.trains,
+		UserTickets: s.userTickets,
+		Holds:       s.holds,
+		Cancelled:   s.cancelled,
+		Upgraded:    s.upgraded,
+		Spaces:      s.spaces,
+		Records:     s.records,
+		Receipts:    s.receipts,
+		Cases:       s.cases,
+		Feedback:    s.feedback,
+		Channels:    s.channels,
+		Travelers:   s.travelers,
+		Notices:     s.notices,
+		DeadLetters: s.deadLetters,
+	})
+}
+
+// restore replaces the store's state with what marshal encoded
+func (s *memoryStore) restore(data []byte) error {
+	var snap memorySnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.trains = orEmpty(snap.Trains)
+	s.userTickets = orEmpty(snap.UserTickets)
+	s.holds = orEmpty(snap.Holds)
+	s.cancelled = orEmpty(snap.Cancelled)
+	s.upgraded = orEmpty(snap.Upgraded)
+	s.spaces = orEmpty(snap.Spaces)
+	s.records = orEmpty(snap.Records)
+	s.receipts = orEmpty(snap.Receipts)
+	s.userRefs = map[string][]string{}
+	for ref, receipt := range s.receipts {
+		s.userRefs[receipt.UserID] = append(s.userRefs[receipt.UserID], ref)
+	}
+	s.cases = snap.Cases
+	s.feedback = snap.Feedback
+	s.channels = orEmpty(snap.Channels)
+	s.travelers = orEmpty(snap.Travelers)
+	s.notices = orEmpty(snap.Notices)
+	s.deadLetters = snap.DeadLetters
+	return nil
+}
+
+func orEmpty[K comparable, V any](m map[K]V) map[K]V {
+	if m == nil {
+		return map[K]V{}
+	}
+	return m
+}
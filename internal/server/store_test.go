@@ -0,0 +1,185 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testStores opens each kind of store, empty. Redis runs its Lua scripts
+// against TRAIN_SERVER_TEST_REDIS, e.g. redis://localhost:6379/15, in a
+// tenant of its own, and is skipped without it.
+var testStores = []struct {
+	name string
+	open func(t *testing.T) Store
+}{
+	{"memory", func(t *testing.T) Store { return newMemoryStore() }},
+	{"wal", func(t *testing.T) Store {
+		s := openWAL(t, filepath.Join(t.TempDir(), "store.wal"), 0)
+		t.Cleanup(func() { crash(s) })
+		return s
+	}},
+	{"bolt", func(t *testing.T) Store {
+		s := openBoltStore(t, filepath.Join(t.TempDir(), "store.db"))
+		t.Cleanup(func() { s.db.db.Close() })
+		return s
+	}},
+	{"redis", func(t *testing.T) Store {
+		dsn := os.Getenv("TRAIN_SERVER_TEST_REDIS")
+		if dsn == "" {
+			t.Skip("TRAIN_SERVER_TEST_REDIS is not set")
+		}
+		u, err := url.Parse(dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := newRedisStore(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s.namespace(fmt.Sprintf("test-%d", time.Now().UnixNano()))
+	}},
+}
+
+func TestStores(t *testing.T) {
+	now := time.Date(2029, time.December, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		run  func(ctx context.Context, s Store) error
+		// Tickets left on G100 and D200 afterwards, of 3 and 2
+		g100, d200 int
+	}{
+		{"book", func(ctx context.Context, s Store) error {
+			_, err := s.Book(ctx, "G100", "alice")
+			return err
+		}, 2, 2},
+		{"book sold out", func(ctx context.Context, s Store) error {
+			for i := 0; i < 2; i++ {
+				if _, err := s.Book(ctx, "D200", "alice"); err != nil {
+					return err
+				}
+			}
+			if _, err := s.Book(ctx, "D200", "bob"); !errors.Is(err, ErrSoldOut) {
+				return fmt.Errorf("booking a sold-out train: %v, want ErrSoldOut", err)
+			}
+			return nil
+		}, 3, 0},
+		{"book many", func(ctx context.Context, s Store) error {
+			_, err := s.BookMany(ctx, []string{"G100", "D200"}, "alice")
+			return err
+		}, 2, 1},
+		{"book many is all or nothing", func(ctx context.Context, s Store) error {
+			for i := 0; i < 2; i++ {
+				if _, err := s.Book(ctx, "D200", "alice"); err != nil {
+					return err
+				}
+			}
+			if _, err := s.BookMany(ctx, []string{"G100", "D200"}, "bob"); !errors.Is(err, ErrSoldOut) {
+				return fmt.Errorf("booking a sold-out train with another: %v, want ErrSoldOut", err)
+			}
+			if _, err := s.BookMany(ctx, []string{"G100", "X1"}, "bob"); !errors.Is(err, ErrTrainNotFound) {
+				return fmt.Errorf("booking an unknown train with another: %v, want ErrTrainNotFound", err)
+			}
+			return nil
+		}, 3, 0},
+		{"cancel", func(ctx context.Context, s Store) error {
+			if _, err := s.Book(ctx, "G100", "alice"); err != nil {
+				return err
+			}
+			_, _, err := s.Cancel(ctx, "G100", "alice", now)
+			return err
+		}, 3, 2},
+		{"cancel without a ticket", func(ctx context.Context, s Store) error {
+			if _, _, err := s.Cancel(ctx, "G100", "alice", now); !errors.Is(err, ErrNoTicketsToCancel) {
+				return fmt.Errorf("cancelling without a ticket: %v, want ErrNoTicketsToCancel", err)
+			}
+			return nil
+		}, 3, 2},
+		{"hold and confirm", func(ctx context.Context, s Store) error {
+			hold, err := s.Hold(ctx, "G100", "alice", now.Add(time.Hour))
+			if err != nil {
+				return err
+			}
+			_, err = s.ConfirmHold(ctx, hold.ID, "alice", now)
+			return err
+		}, 2, 2},
+		{"expired hold", func(ctx context.Context, s Store) error {
+			hold, err := s.Hold(ctx, "G100", "alice", now.Add(time.Minute))
+			if err != nil {
+				return err
+			}
+			if _, err := s.ConfirmHold(ctx, hold.ID, "alice", now.Add(time.Hour)); !errors.Is(err, ErrHoldExpired) {
+				return fmt.Errorf("confirming an expired hold: %v, want ErrHoldExpired", err)
+			}
+			return nil
+		}, 3, 2},
+		{"expire holds", func(ctx context.Context, s Store) error {
+			if _, err := s.Hold(ctx, "G100", "alice", now.Add(time.Minute)); err != nil {
+				return err
+			}
+			if _, err := s.Hold(ctx, "D200", "alice", now.Add(time.Hour)); err != nil {
+				return err
+			}
+			released, err := s.ExpireHolds(ctx, now.Add(30*time.Minute))
+			if err == nil && released != 1 {
+				err = fmt.Errorf("released %d holds, want 1", released)
+			}
+			return err
+		}, 3, 1},
+		{"user receipts", func(ctx context.Context, s Store) error {
+			for i, r := range []Receipt{
+				{Ref: "BK-1", UserID: "alice", IssuedAt: now},
+				{Ref: "BK-2", UserID: "bob", IssuedAt: now.Add(time.Minute)},
+				{Ref: "BK-3", UserID: "alice", IssuedAt: now.Add(2 * time.Minute)},
+				{Ref: "BK-1", UserID: "alice", IssuedAt: now, Refunds: []Refund{{Item: 0}}},
+			} {
+				if err := s.SaveReceipt(ctx, r); err != nil {
+					return fmt.Errorf("receipt %d: %w", i+1, err)
+				}
+			}
+			receipts, err := s.UserReceipts(ctx, "alice")
+			if err != nil {
+				return err
+			}
+			var refs []string
+			for _, r := range receipts {
+				refs = append(refs, r.Ref)
+			}
+			if !slices.Equal(refs, []string{"BK-3", "BK-1"}) || len(receipts[1].Refunds) != 1 {
+				return fmt.Errorf("alice's receipts %v, want BK-3 and then BK-1 as saved again", refs)
+			}
+			return nil
+		}, 3, 2},
+	}
+	for _, store := range testStores {
+		t.Run(store.name, func(t *testing.T) {
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					ctx := context.Background()
+					s := store.open(t)
+					if err := s.Seed(ctx, testTrains()); err != nil {
+						t.Fatal(err)
+					}
+					if err := tt.run(ctx, s); err != nil {
+						t.Fatal(err)
+					}
+					for id, want := range map[string]int{"G100": tt.g100, "D200": tt.d200} {
+						train, err := s.GetTrain(ctx, id)
+						if err != nil {
+							t.Fatal(err)
+						}
+						if train.Available != want {
+							t.Errorf("%s has %d tickets left, want %d", id, train.Available, want)
+						}
+					}
+				})
+			}
+		})
+	}
+}
@@ -0,0 +1,582 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// The write-ahead log makes the in-memory store survive a crash: every
+// change is appended to the log and synced before it is acknowledged, and
+// on startup the log is replayed on top of the last snapshot. Once the log
+// holds TRAIN_SERVER_WAL_COMPACT_EVERY records the whole state is written to
+// <log>.snapshot and the log starts over.
+// Records carry a sequence number, so those already in the snapshot are
+// skipped if the server stopped before the log was emptied.
+
+// walRecord is one change to the store, with what it needs to be applied
+// again exactly: the IDs it generated and the times it was given
+type walRecord struct {
+	Seq    int64  `json:"seq"`
+	Tenant string `json:"tenant,omitempty"`
+	Op     string `json:"op"`
+
+	TrainID    string        `json:"train_id,omitempty"`
+	TrainIDs   []string      `json:"train_ids,omitempty"`
+	UserID     string        `json:"user_id,omitempty"`
+	ID         string        `json:"id,omitempty"` // of the cancellation, hold, support case or dead letter
+	IDs        []string      `json:"ids,omitempty"`
+	Time       time.Time     `json:"time"`
+	From       string        `json:"from,omitempty"`
+	To         string        `json:"to,omitempty"`
+	Space      string        `json:"space,omitempty"`
+	N          int           `json:"n,omitempty"`
+	Limit      int           `json:"limit,omitempty"` // seats in the class, or places of the space
+	Resolution string        `json:"resolution,omitempty"`
+	Trains     []*Train      `json:"trains,omitempty"`
+	Receipt    *Receipt      `json:"receipt,omitempty"`
+	Case       *SupportCase  `json:"case,omitempty"`
+	Feedback   *Feedback     `json:"feedback,omitempty"`
+	Channels   []Channel     `json:"channels,omitempty"`
+	Travelers  []Traveler    `json:"travelers,omitempty"`
+	Notice     *Notification `json:"notice,omitempty"`
+	DeadLetter *DeadLetter   `json:"dead_letter,omitempty"`
+}
+
+// fileFormat is the layout of a snapshot file
+type fileFormat struct {
+	Version   int                        `json:"version"`
+	Inventory json.RawMessage            `json:"inventory,omitempty"` // the store used without tenants
+	Tenants   map[string]json.RawMessage `json:"tenants,omitempty"`
+}
+
+const fileFormatVersion = 1
+
+// walSnapshot is the state the log starts from, up to record Seq
+type walSnapshot struct {
+	Seq int64 `json:"seq"`
+	fileFormat
+}
+
+// writeAheadLog is the log shared by the parts of a logged store
+type writeAheadLog struct {
+	path         string
+	compactEvery int
+	mu           sync.Mutex // held from a change until its record is synced, so the log keeps the store's order
+	f            *os.File
+	seq          int64 // of the last record
+	records      int   // in the log since the snapshot
+	size         int64 // of the log's whole records
+	base         *memoryStore
+	parts        map[string]*memoryStore
+}
+
+// walStore is one part of a store whose changes go through a write-ahead log
+type walStore struct {
+	*memoryStore
+	wal    *writeAheadLog
+	tenant string
+}
+
+// logWrites puts base, which must be the in-memory store, behind the
+// write-ahead log at path, first restoring what the log and its snapshot hold
+func logWrites(base Store, path string, compactEvery int) (*walStore, error) {
+	memory, ok := base.(*memoryStore)
+	if !ok {
+		return nil, errors.New("the write-ahead log is for the memory store")
+	}
+	w := &writeAheadLog{path: path, compactEvery: compactEvery, base: memory, parts: map[string]*memoryStore{}}
+	if err := w.recover(); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	w.f = f
+	return &walStore{memoryStore: memory, wal: w}, nil
+}
+
+// part is the store a record of tenant applies to
+func (w *writeAheadLog) part(tenant string) *memoryStore {
+	if tenant == "" {
+		return w.base
+	}
+	if w.parts[tenant] == nil {
+		w.parts[tenant] = newMemoryStore()
+	}
+	return w.parts[tenant]
+}
+
+// recover loads the snapshot and replays the log after it. A record cut
+// short by a crash can only be the last one; it was never acknowledged, so
+// it is dropped.
+func (w *writeAheadLog) recover() error {
+	data, err := os.ReadFile(w.path + ".snapshot")
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err == nil {
+		var snap walSnapshot
+		if err := json.Unmarshal(data, &snap); err != nil {
+			return fmt.Errorf("%s.snapshot: %w", w.path, err)
+		}
+		if snap.Version != fileFormatVersion {
+			return fmt.Errorf("%s.snapshot: unsupported version %d", w.path, snap.Version)
+		}
+		if snap.Inventory != nil {
+			if err := w.base.restore(snap.Inventory); err != nil {
+				return fmt.Errorf("%s.snapshot: %w", w.path, err)
+			}
+		}
+		for tenant, data := range snap.Tenants {
+			if err := w.part(tenant).restore(data); err != nil {
+				return fmt.Errorf("%s.snapshot: tenant %q: %w", w.path, tenant, err)
+			}
+		}
+		w.seq = snap.Seq
+	}
+
+	f, err := os.OpenFile(w.path, os.O_RDWR, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var offset int64 // end of the last whole record
+	replayed := 0
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		var rec walRecord
+		if jsonErr := json.Unmarshal(line, &rec); jsonErr != nil || err == io.EOF {
+			if _, more := r.Peek(1); more == nil {
+				return fmt.Errorf("%s: record after offset %d: %v", w.path, offset, jsonErr)
+			}
+			log.Printf("⚠️  [WAL] Dropping a record cut short at offset %d of %s", offset, w.path)
+			if err := f.Truncate(offset); err != nil {
+				return err
+			}
+			break
+		}
+		offset += int64(len(line))
+		w.size = offset
+		w.records++
+		if rec.Seq <= w.seq {
+			continue // already in the snapshot
+		}
+		if err := w.part(rec.Tenant).apply(rec); err != nil {
+			return fmt.Errorf("%s: record %d: %w", w.path, rec.Seq, err)
+		}
+		w.seq = rec.Seq
+		replayed++
+	}
+	if replayed > 0 {
+		log.Printf("📜 Replayed %d changes from the write-ahead log %s", replayed, w.path)
+	}
+	return nil
+}
+
+// apply makes the change rec records. Only a change that went ahead was
+// logged, and it goes ahead again on the same state, so the store's result
+// is not needed; the exception, a hold confirmed too late, is dropped again.
+func (s *memoryStore) apply(rec walRecord) error {
+	ctx := context.Background()
+	switch rec.Op {
+	case "seed":
+		_ = s.Seed(ctx, rec.Trains)
+	case "delete_train":
+		_ = s.DeleteTrain(ctx, rec.TrainID)
+	case "book":
+		_, _ = s.Book(ctx, rec.TrainID, rec.UserID)
+	case "book_many":
+		_, _ = s.BookMany(ctx, rec.TrainIDs, rec.UserID)
+	case "cancel":
+		_, _, _ = s.cancel(ctx, rec.TrainID, rec.UserID, rec.ID, rec.Time)
+	case "upgrade_seat":
+		_, _ = s.UpgradeSeat(ctx, rec.TrainID, rec.From, rec.To, rec.Limit)
+	case "take_space":
+		_, _ = s.TakeSpace(ctx, rec.TrainID, rec.Space, rec.N, rec.Limit)
+	case "rebook":
+		_, _ = s.Rebook(ctx, rec.ID, rec.UserID, rec.Time)
+	case "purge_cancelled":
+		_, _ = s.PurgeCancelled(ctx, rec.Time)
+	case "save_receipt":
+		_ = s.SaveReceipt(ctx, *rec.Receipt)
+	case "open_case":
+		_, _ = s.OpenCase(ctx, *rec.Case)
+	case "resolve_case":
+		_, _ = s.ResolveCase(ctx, rec.ID, rec.Resolution, rec.Time)
+	case "add_feedback":
+		_, _ = s.AddFeedback(ctx, *rec.Feedback)
+	case "set_channels":
+		_ = s.SetChannels(ctx, rec.UserID, rec.Channels)
+	case "set_travelers":
+		_ = s.SetTravelers(ctx, rec.UserID, rec.Travelers)
+	case "add_notice":
+		_ = s.AddNotice(ctx, *rec.Notice)
+	case "ack_notices":
+		_ = s.AckNotices(ctx, rec.UserID, rec.IDs)
+	case "add_dead_letter":
+		_ = s.AddDeadLetter(ctx, *rec.DeadLetter)
+	case "take_dead_letter":
+		_, _ = s.TakeDeadLetter(ctx, rec.ID)
+	case "hold":
+		_, _ = s.hold(ctx, rec.TrainID, rec.UserID, rec.ID, rec.Time)
+	case "confirm_hold":
+		_, _ = s.ConfirmHold(ctx, rec.ID, rec.UserID, rec.Time)
+	case "release_hold":
+		_ = s.ReleaseHold(ctx, rec.ID, rec.UserID)
+	case "expire_holds":
+		_, _ = s.ExpireHolds(ctx, rec.Time)
+	default:
+		return fmt.Errorf("unknown operation %q", rec.Op)
+	}
+	return nil
+}
+
+// namespace returns the part of the store holding one tenant's inventory
+func (s *walStore) namespace(tenant string) *walStore {
+	s.wal.mu.Lock()
+	defer s.wal.mu.Unlock()
+	return &walStore{memoryStore: s.wal.part(tenant), wal: s.wal, tenant: tenant}
+}
+
+// logged makes a change and appends the record change returns for it,
+// unless the change failed; a record that cannot be written is the error,
+// and the change is undone
+func (s *walStore) logged(change func() (walRecord, error)) error {
+	w := s.wal
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	rec, err := change()
+	if err != nil {
+		return err
+	}
+	rec.Seq = w.seq + 1
+	rec.Tenant = s.tenant
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if _, err = w.f.Write(line); err == nil {
+		err = w.f.Sync()
+	}
+	if err != nil {
+		if uerr := w.undoLocked(); uerr != nil {
+			log.Printf("❌ [WAL] Cannot undo a change the log did not take: %v", uerr)
+		}
+		return fmt.Errorf("writing the write-ahead log: %w", err)
+	}
+	w.seq = rec.Seq
+	w.size += int64(len(line))
+	w.records++
+	if w.compactEvery > 0 && w.records >= w.compactEvery {
+		if err := w.compactLocked(); err != nil {
+			log.Printf("⚠️  [WAL] Compaction failed, the log keeps growing: %v", err)
+		}
+	}
+	return nil
+}
+
+// compactLocked writes a snapshot of every part and empties the log
+func (w *writeAheadLog) compactLocked() error {
+	snap := walSnapshot{Seq: w.seq, fileFormat: fileFormat{Version: fileFormatVersion, Tenants: map[string]json.RawMessage{}}}
+	var err error
+	if snap.Inventory, err = w.base.marshal(); err != nil {
+		return err
+	}
+	for tenant, part := range w.parts {
+		if snap.Tenants[tenant], err = part.marshal(); err != nil {
+			return err
+		}
+	}
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	if err := writeFileSynced(w.path+".snapshot", data); err != nil {
+		return err
+	}
+	// Records up to Seq are skipped from now on, so a crash here loses nothing
+	if err := w.f.Truncate(0); err != nil {
+		return err
+	}
+	if err := w.f.Sync(); err != nil {
+		return err
+	}
+	log.Printf("📜 Compacted %d write-ahead log records into %s.snapshot", w.records, w.path)
+	w.records, w.size = 0, 0
+	return nil
+}
+
+// undoLocked drops what a record that could not be written left behind: the
+// log is cut back to its last whole record, and the state read again from
+// the snapshot and the log, as after a crash
+func (w *writeAheadLog) undoLocked() error {
+	if err := os.Truncate(w.path, w.size); err != nil {
+		return err
+	}
+	empty, err := json.Marshal(memorySnapshot{})
+	if err != nil {
+		return err
+	}
+	parts := []*memoryStore{w.base}
+	for _, part := range w.parts {
+		parts = append(parts, part)
+	}
+	for _, part := range parts {
+		if err := part.restore(empty); err != nil {
+			return err
+		}
+	}
+	w.seq, w.records, w.size = 0, 0, 0
+	return w.recover()
+}
+
+func (s *walStore) Seed(ctx context.Context, trains []*Train) error {
+	return s.logged(func() (walRecord, error) {
+		return walRecord{Op: "seed", Trains: trains}, s.memoryStore.Seed(ctx, trains)
+	})
+}
+
+func (s *walStore) DeleteTrain(ctx context.Context, id string) error {
+	return s.logged(func() (walRecord, error) {
+		return walRecord{Op: "delete_train", TrainID: id}, s.memoryStore.DeleteTrain(ctx, id)
+	})
+}
+
+func (s *walStore) Book(ctx context.Context, trainID, userID string) (train *Train, err error) {
+	err = s.logged(func() (walRecord, error) {
+		train, err = s.memoryStore.Book(ctx, trainID, userID)
+		return walRecord{Op: "book", TrainID: trainID, UserID: userID}, err
+	})
+	return train, err
+}
+
+func (s *walStore) BookMany(ctx context.Context, trainIDs []string, userID string) (trains []*Train, err error) {
+	err = s.logged(func() (walRecord, error) {
+		trains, err = s.memoryStore.BookMany(ctx, trainIDs, userID)
+		return walRecord{Op: "book_many", TrainIDs: trainIDs, UserID: userID}, err
+	})
+	return trains, err
+}
+
+func (s *walStore) Cancel(ctx context.Context, trainID, userID string, now time.Time) (train *Train, record *CancelledBooking, err error) {
+	id := newCancellationID()
+	err = s.logged(func() (walRecord, error) {
+		train, record, err = s.memoryStore.cancel(ctx, trainID, userID, id, now)
+		return walRecord{Op: "cancel", TrainID: trainID, UserID: userID, ID: id, Time: now}, err
+	})
+	return train, record, err
+}
+
+func (s *walStore) UpgradeSeat(ctx context.Context, trainID, from, to string, seats int) (left int, err error) {
+	err = s.logged(func() (walRecord, error) {
+		left, err = s.memoryStore.UpgradeSeat(ctx, trainID, from, to, seats)
+		return walRecord{Op: "upgrade_seat", TrainID: trainID, From: from, To: to, Limit: seats}, err
+	})
+	return left, err
+}
+
+func (s *walStore) TakeSpace(ctx context.Context, trainID, space string, n, limit int) (left int, err error) {
+	err = s.logged(func() (walRecord, error) {
+		left, err = s.memoryStore.TakeSpace(ctx, trainID, space, n, limit)
+		return walRecord{Op: "take_space", TrainID: trainID, Space: space, N: n, Limit: limit}, err
+	})
+	return left, err
+}
+
+func (s *walStore) Rebook(ctx context.Context, cancellationID, userID string, now time.Time) (train *Train, err error) {
+	err = s.logged(func() (walRecord, error) {
+		train, err = s.memoryStore.Rebook(ctx, cancellationID, userID, now)
+		return walRecord{Op: "rebook", ID: cancellationID, UserID: userID, Time: now}, err
+	})
+	return train, err
+}
+
+func (s *walStore) PurgeCancelled(ctx context.Context, cutoff time.Time) (purged int, err error) {
+	err = s.logged(func() (walRecord, error) {
+		purged, err = s.memoryStore.PurgeCancelled(ctx, cutoff)
+		if err == nil && purged == 0 {
+			err = errUnchanged
+		}
+		return walRecord{Op: "purge_cancelled", Time: cutoff}, err
+	})
+	return purged, unchanged(err)
+}
+
+func (s *walStore) SaveReceipt(ctx context.Context, receipt Receipt) error {
+	return s.logged(func() (walRecord, error) {
+		return walRecord{Op: "save_receipt", Receipt: &receipt}, s.memoryStore.SaveReceipt(ctx, receipt)
+	})
+}
+
+func (s *walStore) OpenCase(ctx context.Context, c SupportCase) (opened *SupportCase, err error) {
+	err = s.logged(func() (walRecord, error) {
+		opened, err = s.memoryStore.OpenCase(ctx, c)
+		return walRecord{Op: "open_case", Case: &c}, err
+	})
+	return opened, err
+}
+
+func (s *walStore) ResolveCase(ctx context.Context, number, resolution string, now time.Time) (resolved *SupportCase, err error) {
+	err = s.logged(func() (walRecord, error) {
+		resolved, err = s.memoryStore.ResolveCase(ctx, number, resolution, now)
+		return walRecord{Op: "resolve_case", ID: number, Resolution: resolution, Time: now}, err
+	})
+	return resolved, err
+}
+
+func (s *walStore) AddFeedback(ctx context.Context, f Feedback) (added *Feedback, err error) {
+	err = s.logged(func() (walRecord, error) {
+		added, err = s.memoryStore.AddFeedback(ctx, f)
+		return walRecord{Op: "add_feedback", Feedback: &f}, err
+	})
+	return added, err
+}
+
+func (s *walStore) SetChannels(ctx context.Context, userID string, channels []Channel) error {
+	return s.logged(func() (walRecord, error) {
+		return walRecord{Op: "set_channels", UserID: userID, Channels: channels}, s.memoryStore.SetChannels(ctx, userID, channels)
+	})
+}
+
+func (s *walStore) SetTravelers(ctx context.Context, userID string, travelers []Traveler) error {
+	return s.logged(func() (walRecord, error) {
+		return walRecord{Op: "set_travelers", UserID: userID, Travelers: travelers}, s.memoryStore.SetTravelers(ctx, userID, travelers)
+	})
+}
+
+func (s *walStore) AddNotice(ctx context.Context, n Notification) error {
+	return s.logged(func() (walRecord, error) {
+		return walRecord{Op: "add_notice", Notice: &n}, s.memoryStore.AddNotice(ctx, n)
+	})
+}
+
+func (s *walStore) AckNotices(ctx context.Context, userID string, ids []string) error {
+	return s.logged(func() (walRecord, error) {
+		return walRecord{Op: "ack_notices", UserID: userID, IDs: ids}, s.memoryStore.AckNotices(ctx, userID, ids)
+	})
+}
+
+func (s *walStore) AddDeadLetter(ctx context.Context, d DeadLetter) error {
+	return s.logged(func() (walRecord, error) {
+		return walRecord{Op: "add_dead_letter", DeadLetter: &d}, s.memoryStore.AddDeadLetter(ctx, d)
+	})
+}
+
+func (s *walStore) TakeDeadLetter(ctx context.Context, id string) (d *DeadLetter, err error) {
+	err = s.logged(func() (walRecord, error) {
+		d, err = s.memoryStore.TakeDeadLetter(ctx, id)
+		return walRecord{Op: "take_dead_letter", ID: id}, err
+	})
+	return d, err
+}
+
+func (s *walStore) Hold(ctx context.Context, trainID, userID string, expiresAt time.Time) (hold *Hold, err error) {
+	id := newHoldID()
+	err = s.logged(func() (walRecord, error) {
+		hold, err = s.memoryStore.hold(ctx, trainID, userID, id, expiresAt)
+		return walRecord{Op: "hold", TrainID: trainID, UserID: userID, ID: id, Time: expiresAt}, err
+	})
+	return hold, err
+}
+
+func (s *walStore) ConfirmHold(ctx context.Context, holdID, userID string, now time.Time) (train *Train, err error) {
+	var refused error
+	err = s.logged(func() (walRecord, error) {
+		train, refused = s.memoryStore.ConfirmHold(ctx, holdID, userID, now)
+		rec := walRecord{Op: "confirm_hold", ID: holdID, UserID: userID, Time: now}
+		if errors.Is(refused, ErrHoldExpired) || errors.Is(refused, ErrTrainNotFound) {
+			// The hold was dropped all the same
+			return rec, nil
+		}
+		return rec, refused
+	})
+	if err != nil {
+		return nil, err
+	}
+	return train, refused
+}
+
+func (s *walStore) ReleaseHold(ctx context.Context, holdID, userID string) error {
+	return s.logged(func() (walRecord, error) {
+		return walRecord{Op: "release_hold", ID: holdID, UserID: userID}, s.memoryStore.ReleaseHold(ctx, holdID, userID)
+	})
+}
+
+func (s *walStore) ExpireHolds(ctx context.Context, now time.Time) (released int, err error) {
+	err = s.logged(func() (walRecord, error) {
+		released, err = s.memoryStore.ExpireHolds(ctx, now)
+		if err == nil && released == 0 {
+			err = errUnchanged
+		}
+		return walRecord{Op: "expire_holds", Time: now}, err
+	})
+	return released, unchanged(err)
+}
+
+// errUnchanged tells logged that a sweep changed nothing, so there is
+// nothing to log
+var errUnchanged = errors.New("unchanged")
+
+func unchanged(err error) error {
+	if err == errUnchanged {
+		return nil
+	}
+	return err
+}
+
+// writeFileSynced replaces path with data through a synced temporary file,
+// so a crash leaves either the old contents or the new
+func writeFileSynced(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	// Make the rename itself durable
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
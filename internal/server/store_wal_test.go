@@ -0,0 +1,215 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openWAL(t *testing.T, path string, compactEvery int) *walStore {
+	t.Helper()
+	s, err := logWrites(newMemoryStore(), path, compactEvery)
+	if err != nil {
+		t.Fatalf("opening %s: %v", path, err)
+	}
+	return s
+}
+
+// crash leaves the log as it is, as a server killed mid-sale would
+func crash(s *walStore) {
+	s.wal.f.Close()
+}
+
+func walChanges(t *testing.T, s *walStore) {
+	t.Helper()
+	ctx := context.Background()
+	now := time.Date(2029, time.December, 1, 12, 0, 0, 0, time.UTC)
+	if err := s.Seed(ctx, testTrains()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Book(ctx, "G100", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.BookMany(ctx, []string{"G100", "D200"}, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Cancel(ctx, "G100", "alice", now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Hold(ctx, "D200", "carol", now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveReceipt(ctx, Receipt{Ref: "BK1", UserID: "bob", IssuedAt: now, Items: []ReceiptItem{{TrainID: "G100"}, {TrainID: "D200"}}}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWALRecovers(t *testing.T) {
+	tests := []struct {
+		name         string
+		compactEvery int
+		// damage does to the files what a crash at that point would
+		damage func(t *testing.T, s *walStore, path string)
+	}{
+		{"replay", 0, nil},
+		{"record cut short", 0, func(t *testing.T, s *walStore, path string) {
+			f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			f.WriteString(`{"seq":99,"op":"book","train_id":"G100","user_id":"mallory"`)
+		}},
+		{"record without its newline", 0, func(t *testing.T, s *walStore, path string) {
+			f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			f.WriteString(`{"seq":99,"op":"book","train_id":"G100","user_id":"mallory"}`)
+		}},
+		{"compacted", 4, nil},
+		{"crash between snapshot and truncate", 0, func(t *testing.T, s *walStore, path string) {
+			log, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.wal.mu.Lock()
+			err = s.wal.compactLocked()
+			s.wal.mu.Unlock()
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The log was never emptied: its records are all in the snapshot
+			if err := os.WriteFile(path, log, 0o600); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "store.wal")
+			s := openWAL(t, path, tt.compactEvery)
+			walChanges(t, s)
+			want := snapshotBytes(t, s.memoryStore)
+			if tt.damage != nil {
+				tt.damage(t, s, path)
+			}
+			crash(s)
+
+			s = openWAL(t, path, tt.compactEvery)
+			if got := snapshotBytes(t, s.memoryStore); !bytes.Equal(got, want) {
+				t.Fatalf("recovered\n%s\nwant\n%s", got, want)
+			}
+			// Along with the receipts, the index of them by user
+			if receipts, err := s.UserReceipts(context.Background(), "bob"); err != nil || len(receipts) != 1 {
+				t.Errorf("bob's receipts after recovery: %v, %v; want BK1", receipts, err)
+			}
+			// The log takes new records after what was recovered
+			if _, err := s.Book(context.Background(), "G100", "dave"); err != nil {
+				t.Fatal(err)
+			}
+			want = snapshotBytes(t, s.memoryStore)
+			crash(s)
+			s = openWAL(t, path, tt.compactEvery)
+			defer crash(s)
+			if got := snapshotBytes(t, s.memoryStore); !bytes.Equal(got, want) {
+				t.Errorf("recovered after a new record\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestWALCompacts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.wal")
+	s := openWAL(t, path, 4)
+	defer crash(s)
+	walChanges(t, s) // six records
+
+	if _, err := os.Stat(path + ".snapshot"); err != nil {
+		t.Fatalf("no snapshot after compaction: %v", err)
+	}
+	if s.wal.records != 2 || s.wal.seq != 6 {
+		t.Errorf("log holds %d records up to %d, want 2 up to 6", s.wal.records, s.wal.seq)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(data, []byte("\n")); lines != 2 {
+		t.Errorf("log file has %d records, want 2", lines)
+	}
+}
+
+func TestWALRefusesDamageBeforeTheEnd(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.wal")
+	s := openWAL(t, path, 0)
+	walChanges(t, s)
+	crash(s)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := bytes.IndexByte(data, '\n')
+	damaged := append([]byte(`{"seq":1,"op":`+"\n"), data[first+1:]...)
+	if err := os.WriteFile(path, damaged, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := logWrites(newMemoryStore(), path, 0); err == nil {
+		t.Error("a log damaged before its last record was replayed")
+	}
+}
+
+func TestWALKeepsTenantsApart(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "store.wal")
+	s := openWAL(t, path, 0)
+	acme := s.namespace("acme")
+	for _, part := range []*walStore{s, acme} {
+		if err := part.Seed(ctx, testTrains()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := acme.Book(ctx, "G100", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	crash(s)
+
+	s = openWAL(t, path, 0)
+	defer crash(s)
+	for _, tt := range []struct {
+		name string
+		part *walStore
+		want int
+	}{
+		{"inventory", s, 3},
+		{"acme", s.namespace("acme"), 2},
+	} {
+		train, err := tt.part.GetTrain(ctx, "G100")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if train.Available != tt.want {
+			t.Errorf("%s: G100 has %d tickets left, want %d", tt.name, train.Available, tt.want)
+		}
+	}
+}
+
+func TestWALUndoesUnwrittenRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.wal")
+	s := openWAL(t, path, 0)
+	walChanges(t, s)
+	want := snapshotBytes(t, s.memoryStore)
+
+	s.wal.f.Close() // every write fails from now on
+	if _, err := s.Book(context.Background(), "G100", "mallory"); err == nil {
+		t.Fatal("a booking the log did not take succeeded")
+	}
+	if got := snapshotBytes(t, s.memoryStore); !bytes.Equal(got, want) {
+		t.Errorf("the booking the log did not take stayed in memory:\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Support case errors
+var (
+	ErrCaseNotFound = errors.New("support case not found")
+	ErrCaseResolved = errors.New("support case is already resolved")
+)
+
+// Support case statuses
+const (
+	CaseOpen     = "open"
+	CaseResolved = "resolved"
+)
+
+// Longest subject and description accepted for a support case
+const (
+	maxCaseSubject     = 200
+	maxCaseDescription = 4000
+)
+
+// caseNumber formats the nth case filed, e.g. CS-1001
+func caseNumber(n int) string {
+	return fmt.Sprintf("CS-%d", 1000+n)
+}
+
+// SupportCase is an issue handed over to a person, filed by the agent when it
+// cannot resolve it and listed and resolved by admins
+type SupportCase struct {
+	Number      string     `json:"case_number"`
+	UserID      string     `json:"user_id"`
+	Subject     string     `json:"subject"`
+	Description string     `json:"description,omitempty"`
+	BookingRef  string     `json:"booking_ref,omitempty"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ResolvedAt  *time.Time `json:"resolved_at,omitempty"`
+	Resolution  string     `json:"resolution,omitempty"`
+}
+
+// handleSupportTickets files a case (POST) or lists the user's cases (GET)
+func handleSupportTickets(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		userID := r.URL.Query().Get("user_id")
+		if userID == "" {
+			http.Error(w, "user_id parameter is required", http.StatusBadRequest)
+			return
+		}
+		writeCases(w, r, userID, "")
+		return
+	}
+
+	var req struct {
+		UserID      string `json:"user_id"`
+		Subject     string `json:"subject"`
+		Description string `json:"description"`
+		BookingRef  string `json:"booking_ref"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	req.Subject = strings.TrimSpace(req.Subject)
+	if req.UserID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+	if req.Subject == "" {
+		http.Error(w, "subject is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Subject) > maxCaseSubject || len(req.Description) > maxCaseDescription {
+		http.Error(w, "subject or description too long", http.StatusBadRequest)
+		return
+	}
+
+	c, err := tenantOf(r).store.OpenCase(r.Context(), SupportCase{
+		UserID:      req.UserID,
+		Subject:     req.Subject,
+		Description: req.Description,
+		BookingRef:  strings.ToUpper(req.BookingRef),
+		Status:      CaseOpen,
+		CreatedAt:   clock(),
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	logf(r.Context(), "🆘 [SUPPORT] Case %s opened", c.Number)
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(c)
+}
+
+// handleAdminSupportTickets lists every case, optionally only those with ?status=
+func handleAdminSupportTickets(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status != "" && status != CaseOpen && status != CaseResolved {
+		http.Error(w, "status must be open or resolved", http.StatusBadRequest)
+		return
+	}
+	writeCases(w, r, "", status)
+}
+
+func writeCases(w http.ResponseWriter, r *http.Request, userID, status string) {
+	cases, err := tenantOf(r).store.SupportCases(r.Context(), userID, status)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if cases == nil {
+		cases = []SupportCase{}
+	}
+	json.NewEncoder(w).Encode(cases)
+}
+
+// handleResolveSupportTicket closes a case with the admin's resolution note
+func handleResolveSupportTicket(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Resolution string `json:"resolution"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Resolution) == "" {
+		http.Error(w, "resolution is required", http.StatusBadRequest)
+		return
+	}
+
+	c, err := tenantOf(r).store.ResolveCase(r.Context(), strings.ToUpper(r.PathValue("case")), req.Resolution, clock())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	logf(r.Context(), "✅ [SUPPORT] Case %s resolved", c.Number)
+	json.NewEncoder(w).Encode(c)
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/zhangbiao2009/train-booking/internal/otlp"
+)
+
+// OpenTelemetry export: with an OTLP endpoint in the standard OTEL_*
+// variables, every request is traced as a server span, joining the agent's
+// trace when it sends a traceparent; request durations, booking events and
+// runtime figures are exported as metrics; and every log line is exported as
+// a log record.
+
+var telemetry *otlp.Exporter
+
+// Metrics; nil when export is off
+var (
+	requestDuration *otlp.Histogram
+	bookingEvents   *otlp.Counter
+)
+
+// Request duration buckets in seconds, as OpenTelemetry recommends for HTTP
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.075, 0.1, 0.25, 0.5, 0.75, 1, 2.5, 5, 7.5, 10}
+
+func setupTelemetry() error {
+	exporter, err := otlp.FromEnv("train-booking-server")
+	if err != nil || exporter == nil {
+		return err
+	}
+	telemetry = exporter
+	requestDuration = telemetry.Histogram("http.server.request.duration", "s", "Duration of HTTP server requests", durationBuckets)
+	bookingEvents = telemetry.Counter("train_booking.events", "{event}", "Booking events: confirmed, cancelled and sold out")
+	telemetry.Gauge("go.goroutine.count", "{goroutine}", "Live goroutines", func() []otlp.Observation {
+		return []otlp.Observation{{Value: float64(runtime.NumGoroutine())}}
+	})
+	telemetry.Gauge("go.memory.used", "By", "Memory used by the Go runtime", func() []otlp.Observation {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		return []otlp.Observation{{Value: float64(m.Sys - m.HeapReleased)}}
+	})
+	log.SetOutput(io.MultiWriter(log.Writer(), logExporter{}))
+	log.Printf("📡 Exporting telemetry over OTLP")
+	return nil
+}
+
+type routeContextKey struct{}
+
+// traceMiddleware traces each request as a server span; the router names
+// it after the route that serves the request
+func traceMiddleware(next http.Handler) http.Handler {
+	if telemetry == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		route := new(string)
+		ctx, span := telemetry.Start(otlp.Extract(r.Context(), r.Header), r.Method, otlp.KindServer,
+			otlp.String("http.request.method", r.Method),
+			otlp.String("url.path", r.URL.Path),
+			otlp.String("request.id", requestID(r.Context())),
+		)
+		ctx = context.WithValue(ctx, routeContextKey{}, route)
+
+		hw := &headerWriter{ResponseWriter: w}
+		next.ServeHTTP(hw, r.WithContext(ctx))
+
+		status := hw.status()
+		span.SetAttributes(otlp.Int("http.response.status_code", status))
+		if status >= 500 {
+			span.SetError(http.StatusText(status))
+		}
+		span.End()
+		attrs := []otlp.Attr{otlp.String("http.request.method", r.Method), otlp.Int("http.response.status_code", status)}
+		if *route != "" {
+			attrs = append(attrs, otlp.String("http.route", *route))
+		}
+		requestDuration.Record(time.Since(start).Seconds(), attrs...)
+	})
+}
+
+// routeTag names the request's span after pattern
+func routeTag(pattern string) middleware {
+	return func(next http.Handler) http.Handler {
+		if telemetry == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if route, ok := r.Context().Value(routeContextKey{}).(*string); ok {
+				*route = pattern
+			}
+			span := otlp.SpanFromContext(r.Context())
+			span.SetName(r.Method + " " + pattern)
+			span.SetAttributes(otlp.String("http.route", pattern))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Log lines as the log package writes them, with the request ID logf adds
+var (
+	logTimestamp = regexp.MustCompile(`^\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2}(\.\d+)? `)
+	logRequestID = regexp.MustCompile(` req=([A-Za-z0-9._-]+)`)
+)
+
+// logExporter exports each log line as a log record, its severity told by
+// the line's marker
+type logExporter struct{}
+
+func (logExporter) Write(p []byte) (int, error) {
+	line := logTimestamp.ReplaceAllString(strings.TrimRight(string(p), "\n"), "")
+	severity := otlp.SeverityInfo
+	switch {
+	case strings.HasPrefix(line, "❌"):
+		severity = otlp.SeverityError
+	case strings.HasPrefix(line, "⚠️"), strings.HasPrefix(line, "🚨"):
+		severity = otlp.SeverityWarn
+	}
+	var attrs []otlp.Attr
+	if m := logRequestID.FindStringSubmatch(line); m != nil {
+		attrs = append(attrs, otlp.String("request.id", m[1]))
+	}
+	telemetry.Log(context.Background(), time.Now(), severity, line, attrs...)
+	return len(p), nil
+}
@@ -0,0 +1,496 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Multi-tenancy: with TRAIN_SERVER_TENANTS naming a JSON file, one deployment
+// serves several organizations, each with its own schedule, users, bookings,
+// pricing and policies. A request picks its tenant with the tenant header
+// (X-Tenant-ID by default) or by host name, e.g. acme.trains.example.com.
+// Without the file there is a single tenant with the demo schedule.
+
+// Tenant is one organization and its isolated inventory
+type Tenant struct {
+	ID      string   `json:"id"`
+	Hosts   []string `json:"hosts,omitempty"`   // host names that select the tenant, besides {id}.<domain>
+	Default bool     `json:"default,omitempty"` // serves requests that name no tenant
+	Trains  []*Train `json:"trains,omitempty"`  // schedule seeded on startup; the demo schedule when empty
+
+	Pricing   TenantPricing     `json:"pricing"`
+	Policies  TenantPolicies    `json:"policies"`
+	Classes   []TravelClass     `json:"classes,omitempty"`   // offered for upgrades, lowest first
+	Dining    map[string]Dining `json:"dining,omitempty"`    // by train ID
+	Insurance *InsurancePlan    `json:"insurance,omitempty"` // offered with bookings; none when nil
+	Luggage   []LuggageOption   `json:"luggage,omitempty"`   // beyond the free allowance
+
+	store      Store
+	analytics  *routeAnalytics
+	sessions   *sessionActions
+	delays     *delayHistory
+	holdTTL    time.Duration
+	trainLimit int // tickets per user per train; 0 for no limit
+	velocity   *velocityTracker
+}
+
+// TenantPricing puts a price on every train; without a currency trains are unpriced
+type TenantPricing struct {
+	Currency    string             `json:"currency,omitempty"`
+	DefaultFare float64            `json:"default_fare,omitempty"`
+	Fares       map[string]float64 `json:"fares,omitempty"` // by train ID, overriding default_fare
+
+	// Receipt lines: percent off each ticket of a booking with several, a fee
+	// per booking and a tax percentage on the fares and fee
+	MultiTicketDiscount float64 `json:"multi_ticket_discount,omitempty"`
+	BookingFee          float64 `json:"booking_fee,omitempty"`
+	TaxRate             float64 `json:"tax_rate,omitempty"`
+
+	// Percent of the fare refunded for a cancelled ticket without insurance
+	RefundRate float64 `json:"refund_rate,omitempty"`
+}
+
+// TenantPolicies are the booking rules of one tenant
+type TenantPolicies struct {
+	HoldTTL           string `json:"hold_ttl,omitempty"` // e.g. "10m"; TRAIN_SERVER_HOLD_TTL when empty
+	NoCancellations   bool   `json:"no_cancellations,omitempty"`
+	MaxTicketsPerUser int    `json:"max_tickets_per_user,omitempty"` // 0 for no limit
+	// Tickets one user may have on one train; TRAIN_SERVER_MAX_TICKETS_PER_TRAIN
+	// when 0
+	MaxTicketsPerTrain int `json:"max_tickets_per_train,omitempty"`
+
+	// Real-name ticketing: every ticket names a passenger with a valid
+	// document, which holds one ticket per train
+	RealName bool `json:"real_name,omitempty"`
+}
+
+// Price of one ticket
+type Price struct {
+	Amount   float64 `json:"amount"`
+	Currency string  `json:"currency"`
+}
+
+// Policy refusals, answered with 403 Forbidden
+var (
+	ErrCancellationsClosed = errors.New("cancellations are not allowed")
+	ErrTicketLimit         = errors.New("ticket limit per user reached")
+)
+
+var (
+	tenants       = map[string]*Tenant{} // by ID; empty in single-tenant mode
+	tenantList    []*Tenant              // every tenant in file order, for background jobs
+	defaultTenant *Tenant                // serves requests naming no tenant; nil requires one
+)
+
+var tenantIDPattern = regexp.MustCompile(`^[a-z][a-z0-9-]{0,31}$`)
+
+// setupTenants loads the tenants from cfg.TenantsFile, or makes the single
+// default tenant, and seeds each one's schedule into its part of base
+func setupTenants(ctx context.Context, cfg Config, base Store) error {
+	if cfg.TenantsFile == "" {
+		defaultTenant = &Tenant{Classes: demoClasses(), Dining: demoDining(), Insurance: demoInsurance(), Luggage: demoLuggage(), Policies: TenantPolicies{RealName: cfg.RealName}}
+		tenantList = []*Tenant{defaultTenant}
+		return defaultTenant.open(ctx, cfg, base)
+	}
+
+	data, err := os.ReadFile(cfg.TenantsFile)
+	if err != nil {
+		return err
+	}
+	var list []*Tenant
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("%s: %w", cfg.TenantsFile, err)
+	}
+	if len(list) == 0 {
+		return fmt.Errorf("%s: no tenants", cfg.TenantsFile)
+	}
+	for _, t := range list {
+		if !tenantIDPattern.MatchString(t.ID) {
+			return fmt.Errorf("tenant ID %q must be 1-32 lowercase letters, digits or -, starting with a letter", t.ID)
+		}
+		if tenants[t.ID] != nil {
+			return fmt.Errorf("tenant %q is listed twice", t.ID)
+		}
+		if t.Default {
+			if defaultTenant != nil {
+				return fmt.Errorf("tenants %q and %q are both the default", defaultTenant.ID, t.ID)
+			}
+			defaultTenant = t
+		}
+		inventory, err := namespaced(base, t.ID)
+		if err == nil {
+			err = t.open(ctx, cfg, inventory)
+		}
+		if err != nil {
+			return fmt.Errorf("tenant %q: %w", t.ID, err)
+		}
+		tenants[t.ID] = t
+		tenantList = append(tenantList, t)
+	}
+	return nil
+}
+
+// namespaced is the part of base holding one tenant's inventory
+func namespaced(base Store, tenant string) (Store, error) {
+	switch s := base.(type) {
+	case *redisStore:
+		return s.namespace(tenant), nil
+	case *boltStore:
+		return s.namespace(tenant)
+	case *walStore:
+		return s.namespace(tenant), nil
+	}
+	return newMemoryStore(), nil
+}
+
+// open applies the tenant's configuration and seeds its schedule
+func (t *Tenant) open(ctx context.Context, cfg Config, inventory Store) error {
+	t.holdTTL = cfg.HoldTTL
+	if t.Policies.HoldTTL != "" {
+		d, err := time.ParseDuration(t.Policies.HoldTTL)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid hold_ttl %q", t.Policies.HoldTTL)
+		}
+		t.holdTTL = d
+	}
+	seen := map[string]bool{}
+	for i, c := range t.Classes {
+		name := className(c.Name)
+		if name == "" || name == standardClass || c.Seats < 0 {
+			return fmt.Errorf("invalid class %q", c.Name)
+		}
+		if seen[name] {
+			return fmt.Errorf("class %q is listed twice", c.Name)
+		}
+		seen[name] = true
+		t.Classes[i].Name = name
+	}
+	if err := t.setupDining(cfg); err != nil {
+		return err
+	}
+	if err := t.setupInsurance(); err != nil {
+		return err
+	}
+	if err := t.setupLuggage(); err != nil {
+		return err
+	}
+	t.analytics = newRouteAnalytics(cfg.AnalyticsBucket, cfg.AnalyticsRetention)
+	t.sessions = newSessionActions(cfg.SessionRetention)
+	t.delays = newDelayHistory()
+	velocity, err := newVelocityTracker(cfg)
+	if err != nil {
+		return err
+	}
+	t.velocity = velocity
+	if t.Policies.MaxTicketsPerTrain < 0 {
+		return fmt.Errorf("invalid max_tickets_per_train %d", t.Policies.MaxTicketsPerTrain)
+	}
+	t.trainLimit = cfg.MaxTicketsPerTrain
+	if t.Policies.MaxTicketsPerTrain > 0 {
+		t.trainLimit = t.Policies.MaxTicketsPerTrain
+	}
+	inventory.SetTrainLimit(t.trainLimit)
+	t.store = &tenantStore{Store: inventory, tenant: t}
+
+	schedule := t.Trains
+	if len(schedule) == 0 {
+		schedule = demoTrains()
+	}
+	for _, train := range schedule {
+		if train.Available == 0 {
+			train.Available = train.TotalTickets
+		}
+	}
+	t.Trains = nil
+	return t.store.Seed(ctx, schedule)
+}
+
+// price is the tenant's price of a ticket on trainID, or nil when unpriced
+func (t *Tenant) price(trainID string) *Price {
+	p := t.Pricing
+	if p.Currency == "" {
+		return nil
+	}
+	amount, ok := p.Fares[trainID]
+	if !ok {
+		amount = p.DefaultFare
+	}
+	return &Price{Amount: amount, Currency: p.Currency}
+}
+
+// tenantStore applies a tenant's pricing and policies to its inventory
+type tenantStore struct {
+	Store
+	tenant *Tenant
+}
+
+func (s *tenantStore) priced(train *Train) *Train {
+	if train != nil {
+		train.Price = s.tenant.price(train.ID)
+	}
+	return train
+}
+
+// checkLimit refuses more than max_tickets_per_user tickets for userID,
+// counting the adding ones about to be booked. Concurrent bookings by the
+// same user may overshoot the limit.
+func (s *tenantStore) checkLimit(ctx context.Context, userID string, adding int) error {
+	limit := s.tenant.Policies.MaxTicketsPerUser
+	if limit <= 0 {
+		return nil
+	}
+	bookings, err := s.Store.UserBookings(ctx, userID)
+	if err != nil {
+		return err
+	}
+	held := adding
+	for _, booking := range bookings {
+		held += booking.Count
+	}
+	if held > limit {
+		return fmt.Errorf("%w: at most %d", ErrTicketLimit, limit)
+	}
+	return nil
+}
+
+// checkTrainLimit refuses a hold on a train where userID already has the
+// most tickets a user may; confirming the hold checks again, atomically
+func (s *tenantStore) checkTrainLimit(ctx context.Context, trainID, userID string) error {
+	limit := s.tenant.trainLimit
+	if limit <= 0 {
+		return nil
+	}
+	bookings, err := s.Store.UserBookings(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, booking := range bookings {
+		if booking.TrainID == trainID && booking.Count >= limit {
+			return &TrainLimitError{TrainID: trainID, Limit: limit, Held: booking.Count}
+		}
+	}
+	return nil
+}
+
+// checkDeparture refuses trains past the booking cutoff. Unknown trains pass,
+// for the store to report.
+func (s *tenantStore) checkDeparture(ctx context.Context, trainIDs ...string) error {
+	now := clock()
+	for _, id := range trainIDs {
+		train, err := s.Store.GetTrain(ctx, id)
+		if errors.Is(err, ErrTrainNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if bookingClosed(train, now) {
+			return departedError(train)
+		}
+	}
+	return nil
+}
+
+// changed wakes the tenant's long polls after a successful inventory change
+func (s *tenantStore) changed(err error) {
+	if err == nil {
+		inventory.changed(s.tenant.ID)
+	}
+}
+
+func (s *tenantStore) GetTrain(ctx context.Context, id string) (*Train, error) {
+	train, err := s.Store.GetTrain(ctx, id)
+	return s.priced(train), err
+}
+
+func (s *tenantStore) ListTrains(ctx context.Context) ([]*Train, error) {
+	trains, err := s.Store.ListTrains(ctx)
+	for _, train := range trains {
+		s.priced(train)
+	}
+	return trains, err
+}
+
+func (s *tenantStore) Book(ctx context.Context, trainID, userID string) (*Train, error) {
+	if err := s.checkDeparture(ctx, trainID); err != nil {
+		return nil, err
+	}
+	if err := s.checkLimit(ctx, userID, 1); err != nil {
+		return nil, err
+	}
+	train, err := s.Store.Book(ctx, trainID, userID)
+	s.changed(err)
+	return s.priced(train), err
+}
+
+func (s *tenantStore) BookMany(ctx context.Context, trainIDs []string, userID string) ([]*Train, error) {
+	if err := s.checkDeparture(ctx, trainIDs...); err != nil {
+		return nil, err
+	}
+	if err := s.checkLimit(ctx, userID, len(trainIDs)); err != nil {
+		return nil, err
+	}
+	trains, err := s.Store.BookMany(ctx, trainIDs, userID)
+	s.changed(err)
+	for _, train := range trains {
+		s.priced(train)
+	}
+	return trains, err
+}
+
+func (s *tenantStore) Cancel(ctx context.Context, trainID, userID string, now time.Time) (*Train, *CancelledBooking, error) {
+	if s.tenant.Policies.NoCancellations {
+		return nil, nil, ErrCancellationsClosed
+	}
+	if err := s.checkDeparture(ctx, trainID); err != nil {
+		return nil, nil, err
+	}
+	train, record, err := s.Store.Cancel(ctx, trainID, userID, now)
+	s.changed(err)
+	return s.priced(train), record, err
+}
+
+func (s *tenantStore) Rebook(ctx context.Context, cancellationID, userID string, now time.Time) (*Train, error) {
+	records, err := s.Store.CancelledBookings(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		if record.ID != cancellationID {
+			continue
+		}
+		if err := s.checkDeparture(ctx, record.TrainID); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.checkLimit(ctx, userID, 1); err != nil {
+		return nil, err
+	}
+	train, err := s.Store.Rebook(ctx, cancellationID, userID, now)
+	s.changed(err)
+	return s.priced(train), err
+}
+
+func (s *tenantStore) Hold(ctx context.Context, trainID, userID string, expiresAt time.Time) (*Hold, error) {
+	// A hold becomes a booking without another check
+	if err := s.checkDeparture(ctx, trainID); err != nil {
+		return nil, err
+	}
+	if err := s.checkLimit(ctx, userID, 1); err != nil {
+		return nil, err
+	}
+	if err := s.checkTrainLimit(ctx, trainID, userID); err != nil {
+		return nil, err
+	}
+	hold, err := s.Store.Hold(ctx, trainID, userID, expiresAt)
+	s.changed(err)
+	return hold, err
+}
+
+func (s *tenantStore) ConfirmHold(ctx context.Context, holdID, userID string, now time.Time) (*Train, error) {
+	train, err := s.Store.ConfirmHold(ctx, holdID, userID, now)
+	if errors.Is(err, ErrHoldExpired) {
+		// The expired hold's ticket went back to inventory
+		s.changed(nil)
+	}
+	return s.priced(train), err
+}
+
+func (s *tenantStore) ReleaseHold(ctx context.Context, holdID, userID string) error {
+	err := s.Store.ReleaseHold(ctx, holdID, userID)
+	s.changed(err)
+	return err
+}
+
+func (s *tenantStore) ExpireHolds(ctx context.Context, now time.Time) (int, error) {
+	released, err := s.Store.ExpireHolds(ctx, now)
+	if released > 0 {
+		s.changed(nil)
+	}
+	return released, err
+}
+
+var (
+	errUnknownTenant = errors.New("unknown tenant")
+	errNoTenant      = errors.New("tenant required")
+)
+
+// resolveTenant picks the request's tenant: the tenant header, then a host
+// listed by a tenant, then the {id}. subdomain, then the default tenant
+func resolveTenant(r *http.Request) (*Tenant, error) {
+	if len(tenants) == 0 {
+		return defaultTenant, nil
+	}
+	if id := r.Header.Get(config.TenantHeader); id != "" {
+		if t, ok := tenants[strings.ToLower(id)]; ok {
+			return t, nil
+		}
+		return nil, errUnknownTenant
+	}
+
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	for _, t := range tenantList {
+		for _, h := range t.Hosts {
+			if strings.EqualFold(h, host) {
+				return t, nil
+			}
+		}
+	}
+	if label, _, ok := strings.Cut(host, "."); ok {
+		if t, ok := tenants[strings.ToLower(label)]; ok {
+			return t, nil
+		}
+	}
+	if defaultTenant != nil {
+		return defaultTenant, nil
+	}
+	return nil, errNoTenant
+}
+
+// Paths that work without a tenant: they serve no tenant's data
+var tenantFreePaths = map[string]bool{
+	"/openapi.json":    true,
+	"/admin/dashboard": true,
+	"/admin/login":     true,
+}
+
+type tenantContextKey struct{}
+
+// tenantMiddleware resolves every request's tenant before the handlers run
+func tenantMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t, err := resolveTenant(r)
+		if err != nil && !tenantFreePaths[strings.TrimPrefix(r.URL.Path, apiPrefix)] {
+			logf(r.Context(), "🏢 [TENANT] %s %s: %v", r.Method, r.URL.Path, err)
+			if errors.Is(err, errUnknownTenant) {
+				http.Error(w, fmt.Sprintf("unknown tenant %q", r.Header.Get(config.TenantHeader)), http.StatusNotFound)
+			} else {
+				http.Error(w, fmt.Sprintf("tenant required: send %s or use the tenant's host name", config.TenantHeader), http.StatusBadRequest)
+			}
+			return
+		}
+		if t != nil {
+			r = r.WithContext(context.WithValue(r.Context(), tenantContextKey{}, t))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// tenantOf is the tenant tenantMiddleware resolved for r
+func tenantOf(r *http.Request) *Tenant {
+	if t, ok := r.Context().Value(tenantContextKey{}).(*Tenant); ok {
+		return t
+	}
+	return defaultTenant
+}
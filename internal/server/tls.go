@@ -0,0 +1,85 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Client certificate requirements
+const (
+	clientAuthNone  = "none"  // no client certificates
+	clientAuthAdmin = "admin" // required for /admin/ routes only
+	clientAuthAll   = "all"   // required for every request
+)
+
+// adminPathPrefix marks routes that belong to the admin API
+const adminPathPrefix = "/admin/"
+
+// newTLSConfig builds the TLS configuration, or returns nil when TLS is disabled
+func newTLSConfig(cfg Config) (*tls.Config, error) {
+	if cfg.TLSCert == "" && cfg.TLSKey == "" {
+		if cfg.TLSClientAuth != clientAuthNone {
+			return nil, fmt.Errorf("client certificate auth %q requires TLS", cfg.TLSClientAuth)
+		}
+		return nil, nil
+	}
+	if cfg.TLSCert == "" || cfg.TLSKey == "" {
+		return nil, fmt.Errorf("both TRAIN_SERVER_TLS_CERT and TRAIN_SERVER_TLS_KEY must be set")
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	switch cfg.TLSClientAuth {
+	case clientAuthNone:
+		return tlsConfig, nil
+	case clientAuthAdmin, clientAuthAll:
+	default:
+		return nil, fmt.Errorf("unknown client auth mode %q (want none, admin or all)", cfg.TLSClientAuth)
+	}
+
+	if cfg.TLSClientCA == "" {
+		return nil, fmt.Errorf("TRAIN_SERVER_TLS_CLIENT_CA is required for client certificate auth")
+	}
+	pem, err := os.ReadFile(cfg.TLSClientCA)
+	if err != nil {
+		return nil, fmt.Errorf("reading client CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", cfg.TLSClientCA)
+	}
+	tlsConfig.ClientCAs = pool
+
+	if cfg.TLSClientAuth == clientAuthAll {
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	} else {
+		// Verified when presented; the admin routes enforce presence
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tlsConfig, nil
+}
+
+// Client certificate middleware for the admin API
+func adminClientCertMiddleware(mode string) middleware {
+	if mode != clientAuthAdmin {
+		return nil
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isAdminPath(r.URL.Path) && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+				http.Error(w, "client certificate required", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func isAdminPath(path string) bool {
+	return strings.HasPrefix(path, adminPathPrefix) || strings.HasPrefix(path, apiPrefix+adminPathPrefix)
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Traveler profiles: a user keeps the people they book for, such as "mom",
+// with the name, document and passenger type their tickets need. A batch
+// booking naming travelers books every train once per traveler, and each
+// ticket's receipt item carries its traveler's name and type.
+
+// Traveler is someone a user books for, known by their label
+type Traveler struct {
+	Label    string `json:"label"` // e.g. "mom"; "self" is the user themselves
+	Name     string `json:"name"`
+	Document string `json:"document,omitempty"` // ID card or passport number
+	// A document type, such as id_card or passport; the document may be
+	// of any type when empty
+	DocumentType string `json:"document_type,omitempty"`
+	Type         string `json:"type"` // a passenger type
+}
+
+// Passenger types
+const (
+	PassengerAdult   = "adult"
+	PassengerChild   = "child"
+	PassengerSenior  = "senior"
+	PassengerStudent = "student"
+)
+
+// The label of the user themselves, whose ticket names nobody unless they
+// saved a profile under it
+const selfTraveler = "self"
+
+// Travelers a user may save
+const maxTravelers = 10
+
+var ErrTravelerNotFound = errors.New("no saved traveler")
+
+func validTraveler(t Traveler) error {
+	if t.Label == "" || len(t.Label) > 32 {
+		return fmt.Errorf("traveler label must be 1 to 32 characters, not %q", t.Label)
+	}
+	if strings.TrimSpace(t.Name) == "" || len(t.Name) > 100 {
+		return fmt.Errorf("traveler %q needs a name of up to 100 characters", t.Label)
+	}
+	if len(t.Document) > 32 || len(t.DocumentType) > 32 {
+		return fmt.Errorf("document of traveler %q is too long", t.Label)
+	}
+	switch t.Type {
+	case PassengerAdult, PassengerChild, PassengerSenior, PassengerStudent:
+		return nil
+	default:
+		return fmt.Errorf("passenger type must be adult, child, senior or student, not %q", t.Type)
+	}
+}
+
+// maskDocument shows only the last four characters of a document number
+func maskDocument(document string) string {
+	if len(document) <= 4 {
+		return "•••"
+	}
+	return "•••" + document[len(document)-4:]
+}
+
+// maskedTravelers copies travelers with their documents masked, for
+// answers to a user_id nobody authenticated; the store keeps them whole
+func maskedTravelers(travelers []Traveler) []Traveler {
+	masked := make([]Traveler, len(travelers))
+	for i, t := range travelers {
+		if t.Document != "" {
+			t.Document = maskDocument(t.Document)
+		}
+		masked[i] = t
+	}
+	return masked
+}
+
+// handleUserTravelers lists the user's saved travelers, or replaces them.
+// Documents are answered masked, and a traveler saved again with its masked
+// document keeps the one on file.
+func handleUserTravelers(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		userID := r.URL.Query().Get("user_id")
+		if userID == "" {
+			http.Error(w, "user_id parameter is required", http.StatusBadRequest)
+			return
+		}
+		travelers, err := tenantOf(r).store.Travelers(r.Context(), userID)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		json.NewEncoder(w).Encode(maskedTravelers(travelers))
+		return
+	}
+
+	var req struct {
+		UserID    string     `json:"user_id"`
+		Travelers []Traveler `json:"travelers"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if req.UserID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Travelers) > maxTravelers {
+		http.Error(w, fmt.Sprintf("at most %d travelers", maxTravelers), http.StatusBadRequest)
+		return
+	}
+	saved, err := tenantOf(r).store.Travelers(r.Context(), req.UserID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	onFile := map[string]string{}
+	for _, t := range saved {
+		onFile[t.Label] = t.Document
+	}
+	seen := map[string]bool{}
+	for i := range req.Travelers {
+		t := &req.Travelers[i]
+		t.Label = strings.ToLower(strings.TrimSpace(t.Label))
+		t.Name = strings.TrimSpace(t.Name)
+		t.Document = strings.ToUpper(strings.TrimSpace(t.Document))
+		if document := onFile[t.Label]; document != "" && t.Document == maskDocument(document) {
+			t.Document = document
+		}
+		t.DocumentType = strings.ToLower(strings.TrimSpace(t.DocumentType))
+		t.Type = strings.ToLower(strings.TrimSpace(t.Type))
+		if t.Type == "" {
+			t.Type = PassengerAdult
+		}
+		if err := validTraveler(*t); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if seen[t.Label] {
+			http.Error(w, fmt.Sprintf("traveler %q is listed twice", t.Label), http.StatusBadRequest)
+			return
+		}
+		seen[t.Label] = true
+	}
+
+	if err := tenantOf(r).store.SetTravelers(r.Context(), req.UserID, req.Travelers); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(maskedTravelers(req.Travelers))
+}
+
+// bookingTravelers looks up the travelers a booking names by label, each
+// once; "self" without a saved profile is the user, with an empty name
+func bookingTravelers(ctx context.Context, store Store, userID string, labels []string) ([]Traveler, error) {
+	saved, err := store.Travelers(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	var travelers []Traveler
+	seen := map[string]bool{}
+	for _, label := range labels {
+		label = strings.ToLower(strings.TrimSpace(label))
+		if seen[label] {
+			continue
+		}
+		seen[label] = true
+		found := false
+		for _, t := range saved {
+			if t.Label == label {
+				travelers, found = append(travelers, t), true
+				break
+			}
+		}
+		if !found && label == selfTraveler {
+			travelers, found = append(travelers, Traveler{Label: selfTraveler}), true
+		}
+		if !found {
+			return nil, fmt.Errorf("%w %q", ErrTravelerNotFound, label)
+		}
+	}
+	return travelers, nil
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Upgrades: every ticket is booked in standard class. A tenant may offer
+// classes above it, each with a number of seats per train and a surcharge,
+// and a passenger moves a booked ticket up through
+// /booking/{ref}/upgrade, paying the surcharge difference.
+
+// Upgrade refusals
+var (
+	ErrUnknownClass   = errors.New("class not offered")
+	ErrNotAnUpgrade   = errors.New("the ticket is already in that class or a higher one")
+	ErrTicketCanceled = errors.New("the ticket was cancelled")
+)
+
+// TravelClass is a class above standard
+type TravelClass struct {
+	Name      string  `json:"name"`
+	Seats     int     `json:"seats"`               // on every train
+	Surcharge float64 `json:"surcharge,omitempty"` // per ticket, over the standard fare
+}
+
+// demoClasses are the classes of the single-tenant demo, which is unpriced
+func demoClasses() []TravelClass {
+	return []TravelClass{
+		{Name: "first", Seats: 10},
+		{Name: "business", Seats: 2},
+	}
+}
+
+// className normalizes "Business Class" to "business"
+func className(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), " class")
+}
+
+// class looks a class up by name, with its rank: 0 for standard and 1 for
+// the lowest class above it
+func (t *Tenant) class(name string) (TravelClass, int, bool) {
+	name = className(name)
+	if name == standardClass {
+		return TravelClass{Name: standardClass}, 0, true
+	}
+	for i, c := range t.Classes {
+		if c.Name == name {
+			return c, i + 1, true
+		}
+	}
+	return TravelClass{}, 0, false
+}
+
+// surcharge of class c, or 0 when the tenant does not price tickets
+func (t *Tenant) surcharge(c TravelClass) float64 {
+	if t.Pricing.Currency == "" {
+		return 0
+	}
+	return c.Surcharge
+}
+
+// UpgradeResponse is a ticket moved to a higher class, with the updated receipt
+type UpgradeResponse struct {
+	Message    string   `json:"message"`
+	BookingRef string   `json:"booking_ref"`
+	TrainID    string   `json:"train_id"`
+	Class      string   `json:"class"`
+	Charged    float64  `json:"charged"`
+	Currency   string   `json:"currency,omitempty"`
+	SeatsLeft  int      `json:"seats_left"`
+	Receipt    *Receipt `json:"receipt"`
+}
+
+// handleUpgrade moves one ticket of a booking to a higher class. train_id
+// picks the ticket and may be left out for a booking of one train.
+func handleUpgrade(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		UserID  string `json:"user_id"`
+		TrainID string `json:"train_id"`
+		Class   string `json:"class"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if req.UserID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+	t := tenantOf(r)
+	to, toRank, ok := t.class(req.Class)
+	if !ok {
+		names := []string{standardClass}
+		for _, c := range t.Classes {
+			names = append(names, c.Name)
+		}
+		http.Error(w, fmt.Sprintf("%s: %q; classes: %s", ErrUnknownClass, req.Class, strings.Join(names, ", ")), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	receipt, err := userReceipt(r, req.UserID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	item := receiptItem(receipt, req.TrainID)
+	if item < 0 {
+		writeNoTicket(w, req.TrainID)
+		return
+	}
+	ticket := &receipt.Items[item]
+	from, fromRank, ok := t.class(ticket.Class)
+	if !ok || toRank <= fromRank {
+		writeStoreError(w, fmt.Errorf("%s: %w", ticket.TrainID, ErrNotAnUpgrade))
+		return
+	}
+
+	if err := checkTicket(r, ticket.TrainID, req.UserID); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	left, err := t.store.UpgradeSeat(ctx, ticket.TrainID, from.Name, to.Name, to.Seats)
+	if errors.Is(err, ErrSoldOut) {
+		err = fmt.Errorf("%s: no %s class seats left: %w", ticket.TrainID, to.Name, ErrSoldOut)
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	// Charge the surcharge difference, and tax on it
+	difference := cents(t.surcharge(to) - t.surcharge(from))
+	ticket.Class = to.Name
+	ticket.ClassSurcharge = cents(ticket.ClassSurcharge + difference)
+	ticket.Amount = cents(ticket.Amount + difference)
+	receipt.Subtotal = cents(receipt.Subtotal + difference)
+	charged := difference
+	if rate := t.Pricing.TaxRate; rate > 0 && len(receipt.Taxes) > 0 {
+		tax := cents(difference * rate / 100)
+		receipt.Taxes[0].Amount = cents(receipt.Taxes[0].Amount + tax)
+		charged += tax
+	}
+	charged = cents(charged)
+	receipt.Total = cents(receipt.Total + charged)
+	if err := t.store.SaveReceipt(context.WithoutCancel(ctx), *receipt); err != nil {
+		logf(r.Context(), "⚠️  [UPGRADE] Cannot update receipt %s: %v", receipt.Ref, err)
+	}
+	logf(r.Context(), "⬆️  [UPGRADE] %s: %s moved to %s class (%d left)", receipt.Ref, ticket.TrainID, to.Name, left)
+
+	json.NewEncoder(w).Encode(UpgradeResponse{
+		Message:    fmt.Sprintf("upgraded to %s class", to.Name),
+		BookingRef: receipt.Ref,
+		TrainID:    ticket.TrainID,
+		Class:      to.Name,
+		Charged:    charged,
+		Currency:   receipt.Currency,
+		SeatsLeft:  left,
+		Receipt:    receipt,
+	})
+}
+
+// writeNoTicket answers a request naming no ticket of the booking
+func writeNoTicket(w http.ResponseWriter, trainID string) {
+	if trainID == "" {
+		http.Error(w, "train_id is required for a booking of several trains", http.StatusBadRequest)
+		return
+	}
+	http.Error(w, fmt.Sprintf("%s: %s", trainID, ErrReceiptNotFound), http.StatusNotFound)
+}
+
+// checkTicket refuses changes to a ticket the user no longer holds or on a
+// train past its booking cutoff
+func checkTicket(r *http.Request, trainID, userID string) error {
+	store := tenantOf(r).store
+	bookings, err := store.UserBookings(r.Context(), userID)
+	if err != nil {
+		return err
+	}
+	held := false
+	for _, b := range bookings {
+		held = held || (b.TrainID == trainID && b.Count > 0)
+	}
+	if !held {
+		return fmt.Errorf("%s: %w", trainID, ErrTicketCanceled)
+	}
+	train, err := store.GetTrain(r.Context(), trainID)
+	if err != nil {
+		return err
+	}
+	if bookingClosed(train, clock()) {
+		return departedError(train)
+	}
+	return nil
+}
@@ -0,0 +1,366 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Booking velocity, against scalping: a user or a client address that books
+// more than TRAIN_SERVER_VELOCITY_MAX_TICKETS tickets, or tickets on more
+// than TRAIN_SERVER_VELOCITY_MAX_TRAINS trains, within
+// TRAIN_SERVER_VELOCITY_WINDOW is flagged for TRAIN_SERVER_VELOCITY_PENALTY.
+// While flagged its bookings and holds are throttled with 429, or with the
+// action "verify" refused with 403 and a challenge to answer (see
+// challenge.go). Every flag, and every answer to a challenge, is a security
+// event, logged and kept for /admin/security/events. Bookings operators make
+// for users do not count.
+
+// Velocity actions
+const (
+	velocityThrottle = "throttle"
+	velocityVerify   = "verify"
+)
+
+// Security events kept per tenant, newest first
+const securityEventsKept = 1000
+
+// SecurityEvent is suspicious activity kept for admin review
+type SecurityEvent struct {
+	ID        string    `json:"id"`
+	Time      time.Time `json:"time"`
+	Kind      string    `json:"kind"`    // "velocity", "challenge_passed" or "challenge_failed"
+	Subject   string    `json:"subject"` // "user" or "client", whichever booked too fast or answered
+	UserID    string    `json:"user_id,omitempty"`
+	Client    string    `json:"client,omitempty"`
+	Tickets   int       `json:"tickets"` // booked within the window
+	Trains    int       `json:"trains"`  // distinct trains among them
+	Action    string    `json:"action"`  // "throttle" or "verify"
+	Until     time.Time `json:"until"`   // when the flag lifts
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+type SecurityEventsResponse struct {
+	Events []SecurityEvent `json:"events"`
+}
+
+// VelocityError refuses a booking from a user or client flagged for booking
+// too fast
+type VelocityError struct {
+	Action string
+	Until  time.Time
+
+	// With the action "verify": the challenge to answer, if one could be
+	// posed, and whether the last answer was wrong
+	Challenge   *Challenge
+	WrongAnswer bool
+}
+
+func (e *VelocityError) Error() string {
+	if e.WrongAnswer {
+		return "wrong answer to the verification challenge"
+	}
+	if e.Action == velocityVerify {
+		return "too many bookings in a short time: verification is required before booking again"
+	}
+	return fmt.Sprintf("too many bookings in a short time: try again after %s", e.Until.Format(time.Kitchen))
+}
+
+func newSecurityEventID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+type velocityBooking struct {
+	time   time.Time
+	trains []string
+}
+
+// velocityTracker counts the recent bookings of each user and client
+// address. Subjects are keyed "user:<id>" and "client:<addr>".
+type velocityTracker struct {
+	window     time.Duration
+	penalty    time.Duration
+	maxTickets int // 0 for no limit
+	maxTrains  int // 0 for no limit
+	action     string
+
+	mu         sync.Mutex
+	bookings   map[string][]velocityBooking // oldest first
+	flagged    map[string]time.Time         // until when
+	challenges map[string]*posedChallenge   // by ID
+	events     []SecurityEvent              // oldest first
+}
+
+func newVelocityTracker(cfg Config) (*velocityTracker, error) {
+	if cfg.VelocityAction != velocityThrottle && cfg.VelocityAction != velocityVerify {
+		return nil, fmt.Errorf("invalid velocity action %q: want %s or %s", cfg.VelocityAction, velocityThrottle, velocityVerify)
+	}
+	if cfg.VelocityMaxTickets < 0 || cfg.VelocityMaxTrains < 0 {
+		return nil, fmt.Errorf("velocity limits must not be negative")
+	}
+	return &velocityTracker{
+		window:     cfg.VelocityWindow,
+		penalty:    cfg.VelocityPenalty,
+		maxTickets: cfg.VelocityMaxTickets,
+		maxTrains:  cfg.VelocityMaxTrains,
+		action:     cfg.VelocityAction,
+		bookings:   map[string][]velocityBooking{},
+		flagged:    map[string]time.Time{},
+		challenges: map[string]*posedChallenge{},
+	}, nil
+}
+
+func (v *velocityTracker) enabled() bool {
+	return v.maxTickets > 0 || v.maxTrains > 0
+}
+
+func velocityKeys(userID, client string) []string {
+	var keys []string
+	if userID != "" {
+		keys = append(keys, "user:"+userID)
+	}
+	if client != "" {
+		keys = append(keys, "client:"+client)
+	}
+	return keys
+}
+
+// check refuses userID and client while either is flagged
+func (v *velocityTracker) check(userID, client string, now time.Time) *VelocityError {
+	if !v.enabled() {
+		return nil
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for _, key := range velocityKeys(userID, client) {
+		until, ok := v.flagged[key]
+		if !ok {
+			continue
+		}
+		if now.After(until) {
+			delete(v.flagged, key)
+			continue
+		}
+		return &VelocityError{Action: v.action, Until: until}
+	}
+	return nil
+}
+
+// record counts a booking of trains by userID from client, flagging either
+// that has now booked too fast
+func (v *velocityTracker) record(ctx context.Context, userID, client string, trains []*Train, now time.Time) {
+	if !v.enabled() || len(trains) == 0 {
+		return
+	}
+	booking := velocityBooking{time: now}
+	for _, train := range trains {
+		booking.trains = append(booking.trains, train.ID)
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if len(v.bookings) >= rateLimitClients {
+		for key, bookings := range v.bookings {
+			if now.Sub(bookings[len(bookings)-1].time) > v.window {
+				delete(v.bookings, key)
+			}
+		}
+	}
+	for _, key := range velocityKeys(userID, client) {
+		bookings := v.bookings[key]
+		i := slices.IndexFunc(bookings, func(b velocityBooking) bool { return now.Sub(b.time) <= v.window })
+		if i < 0 {
+			bookings = nil
+		} else {
+			bookings = bookings[i:]
+		}
+		bookings = append(bookings, booking)
+		v.bookings[key] = bookings
+
+		tickets, trains := 0, map[string]bool{}
+		for _, b := range bookings {
+			tickets += len(b.trains)
+			for _, id := range b.trains {
+				trains[id] = true
+			}
+		}
+		if (v.maxTickets == 0 || tickets <= v.maxTickets) && (v.maxTrains == 0 || len(trains) <= v.maxTrains) {
+			continue
+		}
+		if until, ok := v.flagged[key]; ok && now.Before(until) {
+			continue
+		}
+		event := SecurityEvent{
+			Time:    now,
+			Kind:    "velocity",
+			Subject: "user",
+			UserID:  userID,
+			Client:  client,
+			Tickets: tickets,
+			Trains:  len(trains),
+			Action:  v.action,
+			Until:   now.Add(v.penalty),
+		}
+		if key != "user:"+userID {
+			event.Subject = "client"
+		}
+		v.flagged[key] = event.Until
+		v.noteLocked(ctx, event)
+	}
+}
+
+// note keeps a security event and logs it
+func (v *velocityTracker) note(ctx context.Context, event SecurityEvent) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.noteLocked(ctx, event)
+}
+
+func (v *velocityTracker) noteLocked(ctx context.Context, event SecurityEvent) {
+	event.ID, event.RequestID = newSecurityEventID(), requestID(ctx)
+	if event.Time.IsZero() {
+		event.Time = clock()
+	}
+	v.events = append(v.events, event)
+	if len(v.events) > securityEventsKept {
+		v.events = v.events[len(v.events)-securityEventsKept:]
+	}
+
+	userID := event.UserID
+	if !config.LogPII {
+		userID = redactValue(userID)
+	}
+	switch event.Kind {
+	case "velocity":
+		logf(ctx, "🚨 [SECURITY] %s booked %d ticket(s) on %d train(s) within %v: %s until %s (user %s, client %s)",
+			event.Subject, event.Tickets, event.Trains, v.window, event.Action, event.Until.Format(time.RFC3339), userID, event.Client)
+	default:
+		logf(ctx, "🚨 [SECURITY] %s (user %s, client %s)", strings.ReplaceAll(event.Kind, "_", " "), userID, event.Client)
+	}
+}
+
+// clear lifts the flags on userID and client, returning how many there were
+func (v *velocityTracker) clear(userID, client string) int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	cleared := 0
+	for _, key := range velocityKeys(userID, client) {
+		if _, ok := v.flagged[key]; ok {
+			cleared++
+		}
+		delete(v.flagged, key)
+		delete(v.bookings, key)
+	}
+	return cleared
+}
+
+// recent security events, newest first
+func (v *velocityTracker) recent() []SecurityEvent {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	events := slices.Clone(v.events)
+	slices.Reverse(events)
+	return events
+}
+
+// noteVelocity counts a booking users made for themselves
+func noteVelocity(r *http.Request, userID string, trains []*Train) {
+	if bookedBy(r.Context()) != "" {
+		return
+	}
+	tenantOf(r).velocity.record(r.Context(), userID, clientAddr(r), trains, clock())
+}
+
+// velocityChecked refuses the booking handler to users and clients flagged
+// for booking too fast
+func velocityChecked(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		v := tenantOf(r).velocity
+		if !v.enabled() {
+			handler(w, r)
+			return
+		}
+		userID := r.URL.Query().Get("user_id")
+		if userID == "" && r.Method == http.MethodPost && r.Body != nil {
+			// The batch takes its user in the body; leave it for the handler
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, "cannot read request body", http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			var req struct {
+				UserID string `json:"user_id"`
+			}
+			if json.Unmarshal(body, &req) == nil {
+				userID = req.UserID
+			}
+		}
+		if refused := v.check(userID, clientAddr(r), clock()); refused != nil {
+			if refused.Action != velocityVerify {
+				writeVelocityError(w, refused)
+				return
+			}
+			if !v.verified(w, r, userID, refused) {
+				return
+			}
+		}
+		handler(w, r)
+	}
+}
+
+// writeVelocityError answers 429 with Retry-After for a throttled subject,
+// or 403 and the challenge to answer for one that must be verified, with a
+// JSON body whose code tells the two apart
+func writeVelocityError(w http.ResponseWriter, err *VelocityError) {
+	status, code := http.StatusTooManyRequests, "velocity_throttled"
+	if err.Action == velocityVerify {
+		status, code = http.StatusForbidden, "verification_required"
+	} else {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(err.Until.Sub(clock()).Seconds()))))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	body := map[string]any{"error": err.Error(), "code": code, "until": err.Until}
+	if err.Challenge != nil {
+		body["challenge"] = err.Challenge
+	}
+	json.NewEncoder(w).Encode(body)
+}
+
+func handleSecurityEvents(w http.ResponseWriter, r *http.Request) {
+	events := tenantOf(r).velocity.recent()
+	if events == nil {
+		events = []SecurityEvent{}
+	}
+	json.NewEncoder(w).Encode(SecurityEventsResponse{Events: events})
+}
+
+// handleClearSecurityFlag lets a user or client flagged for booking too fast
+// book again, once an admin has reviewed it
+func handleClearSecurityFlag(w http.ResponseWriter, r *http.Request) {
+	userID, client := r.URL.Query().Get("user_id"), r.URL.Query().Get("client")
+	if userID == "" && client == "" {
+		http.Error(w, "user_id or client parameter is required", http.StatusBadRequest)
+		return
+	}
+	cleared := tenantOf(r).velocity.clear(userID, client)
+	json.NewEncoder(w).Encode(map[string]int{"cleared": cleared})
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Long polling: /query/wait blocks until a train has at least min_available
+// tickets or the timeout passes, for clients that cannot hold a stream open.
+// Waiters wake whenever their tenant's inventory changes on this server, and
+// recheck every TRAIN_SERVER_WAIT_POLL_INTERVAL for changes made by other
+// servers sharing the store.
+
+// Timeout of a long poll that does not give one
+const defaultWaitTimeout = 30 * time.Second
+
+// inventoryWatch wakes long polls when a tenant's inventory changes
+type inventoryWatch struct {
+	mu      sync.Mutex
+	changes map[string]chan struct{} // tenant -> closed on its next change
+	stop    chan struct{}            // closed at shutdown
+}
+
+var inventory = &inventoryWatch{changes: map[string]chan struct{}{}, stop: make(chan struct{})}
+
+// next returns a channel closed when tenant's inventory next changes
+func (w *inventoryWatch) next(tenant string) <-chan struct{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	ch, ok := w.changes[tenant]
+	if !ok {
+		ch = make(chan struct{})
+		w.changes[tenant] = ch
+	}
+	return ch
+}
+
+// changed wakes the long polls waiting on tenant
+func (w *inventoryWatch) changed(tenant string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if ch, ok := w.changes[tenant]; ok {
+		close(ch)
+		delete(w.changes, tenant)
+	}
+}
+
+// close answers every long poll at shutdown, so none holds it up
+func (w *inventoryWatch) close() {
+	close(w.stop)
+}
+
+// WaitResponse is the train when a long poll ends; Met is false on timeout
+type WaitResponse struct {
+	Met   bool   `json:"met"`
+	Train *Train `json:"train"`
+}
+
+func handleQueryWait(w http.ResponseWriter, r *http.Request) {
+	id := canonicalTrainID(r.URL.Query().Get("id"))
+	if id == "" {
+		http.Error(w, "id parameter is required", http.StatusBadRequest)
+		return
+	}
+	minAvailable := 1
+	if v := r.URL.Query().Get("min_available"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "min_available must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		minAvailable = n
+	}
+	timeout := defaultWaitTimeout
+	if v := r.URL.Query().Get("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			// Plain seconds, e.g. timeout=30
+			n, nerr := strconv.Atoi(v)
+			d, err = time.Duration(n)*time.Second, nerr
+		}
+		if err != nil || d < 0 {
+			http.Error(w, "timeout must be a duration such as 30s", http.StatusBadRequest)
+			return
+		}
+		timeout = d
+	}
+	if timeout > config.MaxWait {
+		timeout = config.MaxWait
+	}
+
+	t := tenantOf(r)
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	var recheck <-chan time.Time
+	if config.WaitPollInterval > 0 {
+		poll := time.NewTicker(config.WaitPollInterval)
+		defer poll.Stop()
+		recheck = poll.C
+	}
+
+	for {
+		// Subscribe before reading, so a change in between is not missed
+		changed := inventory.next(t.ID)
+		train, err := t.store.GetTrain(r.Context(), id)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		if train.Available >= minAvailable {
+			json.NewEncoder(w).Encode(WaitResponse{Met: true, Train: train})
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-recheck:
+		case <-deadline.C:
+			json.NewEncoder(w).Encode(WaitResponse{Met: false, Train: train})
+			return
+		case <-inventory.stop:
+			json.NewEncoder(w).Encode(WaitResponse{Met: false, Train: train})
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}